@@ -0,0 +1,297 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Dong557799/zhibo-class/apperr"
+	"github.com/Dong557799/zhibo-class/query"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	accessCodeLength   = 10
+	accessCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // 去掉易混淆的 0/O、1/I
+)
+
+var (
+	errAccessCodeNotFound  = domainError(apperr.ErrNotFound, CodeAccessCodeNotFound)
+	errAccessCodeExpired   = domainError(apperr.ErrExpired, CodeAccessCodeExpired)
+	errAccessCodeExhausted = domainError(apperr.ErrConflict, CodeAccessCodeExhausted)
+	errAccessCodeRedeemed  = domainError(apperr.ErrConflict, CodeAccessCodeRedeemed)
+)
+
+// 兑换码，绑定会话时只授予该会话的试听权限，否则报名整门课程
+type AccessCode struct {
+	ID        int        `json:"id"`
+	Code      string     `json:"code"`
+	CourseID  int        `json:"course_id"`
+	SessionID *int       `json:"session_id,omitempty"`
+	Batch     string     `json:"batch,omitempty"`
+	MaxUses   int        `json:"max_uses"`
+	UsedCount int        `json:"used_count"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedBy int        `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+var accessCodeSortColumns = map[string]string{
+	"id":         "id",
+	"created_at": "created_at",
+	"expires_at": "expires_at",
+	"used_count": "used_count",
+}
+
+func newAccessCode() (string, error) {
+	b := make([]byte, accessCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = accessCodeAlphabet[int(b[i])%len(accessCodeAlphabet)]
+	}
+	return string(b), nil
+}
+
+// 学生是否持有会话的试听授权；sessionID 为 0 时检查课程中正在直播的会话
+func sessionGranted(courseID, sessionID, studentID int) (bool, error) {
+	var one int
+	var err error
+	if sessionID > 0 {
+		err = db.QueryRow("SELECT 1 FROM session_grants WHERE session_id = ? AND student_id = ?", sessionID, studentID).Scan(&one)
+	} else {
+		err = db.QueryRow(`
+			SELECT 1 FROM session_grants g
+			JOIN live_sessions s ON s.id = g.session_id
+			WHERE s.course_id = ? AND s.status = 'live' AND g.student_id = ?
+			LIMIT 1
+		`, courseID, studentID).Scan(&one)
+	}
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// 批量生成兑换码
+func createAccessCodes(c *gin.Context) {
+	var req struct {
+		CourseID  int        `json:"course_id" binding:"required"`
+		SessionID *int       `json:"session_id"`
+		Count     int        `json:"count" binding:"required,min=1,max=1000"`
+		MaxUses   int        `json:"max_uses" binding:"omitempty,min=1"` // 为 0 时每个兑换码只能使用一次
+		ExpiresAt *time.Time `json:"expires_at"`
+		Batch     string     `json:"batch" binding:"max=64"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "expires_at")
+		return
+	}
+	if req.SessionID != nil {
+		var courseID int
+		err := db.QueryRow("SELECT course_id FROM live_sessions WHERE id = ?", *req.SessionID).Scan(&courseID)
+		if err == sql.ErrNoRows || (err == nil && courseID != req.CourseID) {
+			respondError(c, http.StatusBadRequest, CodeInvalidParam, "session_id")
+			return
+		}
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+			return
+		}
+	}
+
+	user := currentUser(c)
+	now := time.Now().UTC().Truncate(time.Second)
+	var expiresAt *time.Time
+	if req.ExpiresAt != nil {
+		t := req.ExpiresAt.UTC()
+		expiresAt = &t
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeAccessCodeCreateFailed)
+		return
+	}
+	defer tx.Rollback()
+
+	codes := make([]AccessCode, 0, req.Count)
+	for len(codes) < req.Count {
+		code, err := newAccessCode()
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeAccessCodeCreateFailed)
+			return
+		}
+		// 随机码重复的概率极低，冲突时跳过重新生成
+		res, err := tx.Exec(dialect.insertIgnore(`
+			INSERT INTO access_codes (code, course_id, session_id, batch, max_uses, expires_at, created_by, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`), code, req.CourseID, req.SessionID, req.Batch, req.MaxUses, expiresAt, user.ID, now)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeAccessCodeCreateFailed)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		var id int
+		if err := tx.QueryRow("SELECT id FROM access_codes WHERE code = ?", code).Scan(&id); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeAccessCodeCreateFailed)
+			return
+		}
+		codes = append(codes, AccessCode{
+			ID:        id,
+			Code:      code,
+			CourseID:  req.CourseID,
+			SessionID: req.SessionID,
+			Batch:     req.Batch,
+			MaxUses:   req.MaxUses,
+			ExpiresAt: expiresAt,
+			CreatedBy: user.ID,
+			CreatedAt: now,
+		})
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeAccessCodeCreateFailed)
+		return
+	}
+
+	loc := requestLocation(c)
+	for i := range codes {
+		codes[i].CreatedAt = codes[i].CreatedAt.In(loc)
+		codes[i].ExpiresAt = inLocation(codes[i].ExpiresAt, loc)
+	}
+	recordAudit(c, "create_access_codes", "course", req.CourseID, gin.H{
+		"count":      req.Count,
+		"session_id": req.SessionID,
+		"max_uses":   req.MaxUses,
+		"batch":      req.Batch,
+	})
+	respondOK(c, http.StatusCreated, codes)
+}
+
+// 兑换码列表，可按课程、会话和批次筛选
+func listAccessCodes(c *gin.Context) {
+	page, pageSize, offset := pageParams(c)
+
+	q := query.New().
+		Eq("course_id", c.Query("course_id")).
+		Eq("session_id", c.Query("session_id")).
+		Eq("batch", c.Query("batch")).
+		Sort(c.Query("sort"), accessCodeSortColumns, "id DESC")
+
+	var total int64
+	if err := db.QueryRow("SELECT COUNT(*) FROM access_codes WHERE "+q.WhereSQL(), q.Args()...).Scan(&total); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeAccessCodeGetFailed)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, code, course_id, session_id, batch, max_uses, used_count, expires_at, created_by, created_at
+		FROM access_codes
+		WHERE `+q.WhereSQL()+`
+		ORDER BY `+q.OrderSQL()+`
+		LIMIT ? OFFSET ?
+	`, q.Args(pageSize, offset)...)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeAccessCodeGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	codes := []AccessCode{}
+	for rows.Next() {
+		var ac AccessCode
+		if err := rows.Scan(&ac.ID, &ac.Code, &ac.CourseID, &ac.SessionID, &ac.Batch, &ac.MaxUses, &ac.UsedCount,
+			&ac.ExpiresAt, &ac.CreatedBy, &ac.CreatedAt); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeAccessCodeGetFailed)
+			return
+		}
+		ac.CreatedAt = ac.CreatedAt.In(loc)
+		ac.ExpiresAt = inLocation(ac.ExpiresAt, loc)
+		codes = append(codes, ac)
+	}
+
+	respondPage(c, codes, Pagination{Page: page, PageSize: pageSize, Total: total, HasMore: int64(offset+len(codes)) < total})
+}
+
+// 兑换码兑换，授予课程报名或单次会话的试听权限
+func redeemAccessCode(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required,max=32"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	ac, err := redeem(strings.ToUpper(strings.TrimSpace(req.Code)), currentUser(c).ID)
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+
+	respondOK(c, http.StatusOK, gin.H{"course_id": ac.CourseID, "session_id": ac.SessionID})
+}
+
+func redeem(code string, studentID int) (AccessCode, error) {
+	var ac AccessCode
+	tx, err := db.Begin()
+	if err != nil {
+		return ac, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		SELECT id, course_id, session_id, max_uses, used_count, expires_at
+		FROM access_codes WHERE code = ? FOR UPDATE
+	`, code).Scan(&ac.ID, &ac.CourseID, &ac.SessionID, &ac.MaxUses, &ac.UsedCount, &ac.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return ac, errAccessCodeNotFound
+	}
+	if err != nil {
+		return ac, err
+	}
+	now := time.Now().UTC()
+	if ac.ExpiresAt != nil && now.After(*ac.ExpiresAt) {
+		return ac, errAccessCodeExpired
+	}
+
+	res, err := tx.Exec(dialect.insertIgnore("INSERT INTO access_code_redemptions (code_id, student_id, redeemed_at) VALUES (?, ?, ?)"),
+		ac.ID, studentID, now)
+	if err != nil {
+		return ac, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ac, errAccessCodeRedeemed
+	}
+	if ac.UsedCount >= ac.MaxUses {
+		return ac, errAccessCodeExhausted
+	}
+	if _, err := tx.Exec("UPDATE access_codes SET used_count = used_count + 1 WHERE id = ?", ac.ID); err != nil {
+		return ac, err
+	}
+
+	if ac.SessionID != nil {
+		_, err = tx.Exec(dialect.insertIgnore("INSERT INTO session_grants (session_id, student_id, code_id, created_at) VALUES (?, ?, ?, ?)"),
+			*ac.SessionID, studentID, ac.ID, now)
+	} else {
+		_, err = tx.Exec(dialect.insertIgnore("INSERT INTO course_enrollments (course_id, student_id, order_id, created_at) VALUES (?, ?, NULL, ?)"),
+			ac.CourseID, studentID, now)
+	}
+	if err != nil {
+		return ac, err
+	}
+	return ac, tx.Commit()
+}
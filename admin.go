@@ -0,0 +1,352 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/Dong557799/zhibo-class/query"
+	"github.com/gin-gonic/gin"
+)
+
+// 列表接口允许的排序字段
+var (
+	sessionSortColumns = map[string]string{"id": "id", "created_at": "created_at", "start_time": "start_time"}
+	userSortColumns    = map[string]string{"id": "id", "username": "username", "created_at": "created_at"}
+)
+
+// 管理员查看所有课程的直播会话
+func adminListSessions(c *gin.Context) {
+	page, pageSize, offset := pageParams(c)
+
+	q := query.New().
+		Eq("status", c.Query("status")).
+		Eq("course_id", c.Query("course_id")).
+		Sort(c.Query("sort"), sessionSortColumns, "id DESC")
+
+	var total int64
+	if err := db.QueryRow("SELECT COUNT(*) FROM live_sessions WHERE "+q.WhereSQL(), q.Args()...).Scan(&total); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, course_id, stream_key, status, start_time, end_time, created_at, thumbnail
+		FROM live_sessions
+		WHERE `+q.WhereSQL()+`
+		ORDER BY `+q.OrderSQL()+`
+		LIMIT ? OFFSET ?
+	`, q.Args(pageSize, offset)...)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	sessions := []LiveSession{}
+	for rows.Next() {
+		var s LiveSession
+		if err := rows.Scan(&s.ID, &s.CourseID, &s.StreamKey, &s.Status, &s.StartTime, &s.EndTime, &s.CreatedAt, &s.ThumbnailURL); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+			return
+		}
+		s.inLocation(loc)
+		sessions = append(sessions, s)
+	}
+
+	respondPage(c, sessions, Pagination{Page: page, PageSize: pageSize, Total: total, HasMore: int64(offset+len(sessions)) < total})
+}
+
+// 管理员强制结束直播会话
+func adminForceEndSession(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	if err := transitionSession(sessionID, transitionForceEnd); err != nil {
+		respondServiceError(c, err)
+		return
+	}
+
+	if _, err := closeSessionBreakouts(sessionID); err != nil {
+		recordAudit(c, "close_breakouts_failed", "live_session", sessionID, gin.H{"error": err.Error()})
+	}
+	hub.broadcast(sessionRoom(sessionID), Message{Type: "session_ended", Data: gin.H{"forced": true}})
+	notifySessionStatus(sessionID, "ended")
+	recordAudit(c, "force_end_session", "live_session", sessionID, nil)
+
+	respondOK(c, http.StatusOK, gin.H{"message": "Live session ended by admin"})
+}
+
+// 系统整体统计
+func adminGetStats(c *gin.Context) {
+	sessions := map[string]int{}
+	rows, err := readQuery("SELECT status, COUNT(*) FROM live_sessions GROUP BY status")
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeStatsGetFailed)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeStatsGetFailed)
+			return
+		}
+		sessions[status] = count
+	}
+
+	users := map[string]int{}
+	userRows, err := readQuery("SELECT role, COUNT(*) FROM users GROUP BY role")
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeStatsGetFailed)
+		return
+	}
+	defer userRows.Close()
+	for userRows.Next() {
+		var role string
+		var count int
+		if err := userRows.Scan(&role, &count); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeStatsGetFailed)
+			return
+		}
+		users[role] = count
+	}
+
+	var questionCount, answerCount int
+	if err := readDB().QueryRow("SELECT COUNT(*) FROM questions").Scan(&questionCount); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeStatsGetFailed)
+		return
+	}
+	if err := readDB().QueryRow("SELECT COUNT(*) FROM answers").Scan(&answerCount); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeStatsGetFailed)
+		return
+	}
+
+	respondOK(c, http.StatusOK, gin.H{
+		"sessions":           sessions,
+		"users":              users,
+		"question_count":     questionCount,
+		"answer_count":       answerCount,
+		"online_connections": hub.connectionCount(),
+	})
+}
+
+// 用户列表
+func adminListUsers(c *gin.Context) {
+	page, pageSize, offset := pageParams(c)
+
+	q := query.New().
+		Eq("role", c.Query("role")).
+		Eq("status", c.Query("status")).
+		Eq("org", c.Query("org")).
+		Contains(c.Query("q"), "username", "name").
+		Sort(c.Query("sort"), userSortColumns, "id ASC")
+
+	var total int64
+	if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE "+q.WhereSQL(), q.Args()...).Scan(&total); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeUserGetFailed)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, username, name, role, status, time_zone, org, created_at
+		FROM users
+		WHERE `+q.WhereSQL()+`
+		ORDER BY `+q.OrderSQL()+`
+		LIMIT ? OFFSET ?
+	`, q.Args(pageSize, offset)...)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeUserGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Name, &u.Role, &u.Status, &u.TimeZone, &u.Org, &u.CreatedAt); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeUserGetFailed)
+			return
+		}
+		users = append(users, u)
+	}
+
+	respondPage(c, users, Pagination{Page: page, PageSize: pageSize, Total: total, HasMore: int64(offset+len(users)) < total})
+}
+
+// 创建用户
+func adminCreateUser(c *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required"`
+		Name     string `json:"name" binding:"required"`
+		Role     string `json:"role" binding:"required,oneof=admin teacher student"`
+		Password string `json:"password" binding:"required,min=8"`
+		TimeZone string `json:"time_zone" binding:"omitempty,timezone"`
+		Org      string `json:"org" binding:"max=64"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeUserCreateFailed)
+		return
+	}
+
+	id, err := dialect.insertID(db, `
+		INSERT INTO users (username, name, role, status, time_zone, org, password_hash, created_at)
+		VALUES (?, ?, ?, 'active', ?, ?, ?, NOW())
+	`, req.Username, req.Name, req.Role, req.TimeZone, req.Org, hash)
+	if err != nil {
+		if dialect.isDuplicate(err) {
+			respondError(c, http.StatusConflict, CodeUserExists)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeUserCreateFailed)
+		}
+		return
+	}
+
+	recordAudit(c, "create_user", "user", int(id), gin.H{"username": req.Username, "role": req.Role})
+	respondOK(c, http.StatusCreated, User{
+		ID:       int(id),
+		Username: req.Username,
+		Name:     req.Name,
+		Role:     req.Role,
+		Status:   "active",
+		TimeZone: req.TimeZone,
+		Org:      req.Org,
+	})
+}
+
+// 修改用户信息、角色、状态或重置密码
+func adminUpdateUser(c *gin.Context) {
+	userID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name     *string `json:"name"`
+		Role     *string `json:"role" binding:"omitempty,oneof=admin teacher student"`
+		Status   *string `json:"status" binding:"omitempty,oneof=active disabled"`
+		Password *string `json:"password" binding:"omitempty,min=8"`
+		TimeZone *string `json:"time_zone" binding:"omitempty,timezone"`
+		Org      *string `json:"org" binding:"omitempty,max=64"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	var sets []string
+	var args []interface{}
+	if req.Name != nil {
+		sets = append(sets, "name = ?")
+		args = append(args, *req.Name)
+	}
+	if req.Role != nil {
+		sets = append(sets, "role = ?")
+		args = append(args, *req.Role)
+	}
+	if req.Status != nil {
+		sets = append(sets, "status = ?")
+		args = append(args, *req.Status)
+	}
+	if req.TimeZone != nil {
+		sets = append(sets, "time_zone = ?")
+		args = append(args, *req.TimeZone)
+	}
+	if req.Org != nil {
+		sets = append(sets, "org = ?")
+		args = append(args, *req.Org)
+	}
+	if req.Password != nil {
+		hash, err := hashPassword(*req.Password)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeUserUpdateFailed)
+			return
+		}
+		sets = append(sets, "password_hash = ?")
+		args = append(args, hash)
+	}
+	if len(sets) == 0 {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest)
+		return
+	}
+
+	if _, err := db.Exec("UPDATE users SET "+strings.Join(sets, ", ")+" WHERE id = ?", append(args, userID)...); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeUserUpdateFailed)
+		return
+	}
+	invalidateUserStatus(userID)
+
+	user, err := getUser(userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, CodeUserNotFound)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeUserGetFailed)
+		}
+		return
+	}
+
+	recordAudit(c, "update_user", "user", userID, gin.H{
+		"name_changed":     req.Name != nil,
+		"role":             req.Role,
+		"status":           req.Status,
+		"org":              req.Org,
+		"password_changed": req.Password != nil,
+	})
+	respondOK(c, http.StatusOK, user)
+}
+
+// 管理员以老师身份登录，用于排查问题
+func adminImpersonate(c *gin.Context) {
+	var req struct {
+		UserID int    `json:"user_id" binding:"required"`
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	target, err := getUser(req.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, CodeUserNotFound)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeUserGetFailed)
+		}
+		return
+	}
+	if target.Role != RoleTeacher || target.Status != "active" {
+		respondError(c, http.StatusBadRequest, CodeImpersonateNotTeacher)
+		return
+	}
+
+	admin := currentUser(c)
+	tokens, err := issueToken(c, target, admin.ID, impersonateTokenTTL)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+
+	recordAudit(c, "impersonate", "user", target.ID, gin.H{"reason": req.Reason})
+	respondOK(c, http.StatusOK, tokens.response(gin.H{"user": target}))
+}
+
+func getUser(userID int) (User, error) {
+	var u User
+	err := db.QueryRow(`
+		SELECT id, username, name, role, status, time_zone, org, created_at
+		FROM users
+		WHERE id = ?
+	`, userID).Scan(&u.ID, &u.Username, &u.Name, &u.Role, &u.Status, &u.TimeZone, &u.Org, &u.CreatedAt)
+	return u, err
+}
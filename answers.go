@@ -0,0 +1,487 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Dong557799/zhibo-class/apperr"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	jobQuestionClose       = "question.close"
+	maxAnswerWindowSeconds = 3600
+)
+
+var (
+	errQuestionClosed = domainError(apperr.ErrInvalidState, CodeQuestionClosed)
+	errAnswerLocked   = domainError(apperr.ErrConflict, CodeAnswerLocked)
+
+	errAnswerSuperseded = domainError(apperr.ErrConflict, CodeAnswerSuperseded)
+)
+
+// 学生在本次推送中保存的答案，刷新页面或重连后用于恢复作答状态
+type SavedAnswer struct {
+	QuestionID  int        `json:"question_id"`
+	PushID      int        `json:"push_id"`
+	Answer      string     `json:"answer,omitempty"`
+	SubmittedAt *time.Time `json:"submitted_at,omitempty"`
+	Revisions   int        `json:"revisions"` // 修改次数
+	Closed      bool       `json:"closed"`
+	ClosesAt    *time.Time `json:"closes_at,omitempty"`
+}
+
+func init() {
+	// 到截止时间通知学生端结束作答
+	jobHandlers[jobQuestionClose] = func(ctx context.Context, payload json.RawMessage) error {
+		var p struct {
+			PushID   int       `json:"push_id"`
+			ClosesAt time.Time `json:"closes_at"`
+		}
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		push, err := loadQuestionPush(p.PushID)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// 老师提前结束时已经通知过，延长截止时间后由新的任务通知
+		if push.ClosesAt == nil || push.ClosesAt.Before(p.ClosesAt.Add(-time.Second)) || push.ClosesAt.After(p.ClosesAt.Add(time.Second)) {
+			return nil
+		}
+		notifyQuestionClosed(push)
+		return nil
+	}
+}
+
+func answerLockName(pushID, studentID int) string {
+	return fmt.Sprintf("zhibo:answer:%d:%d", pushID, studentID)
+}
+
+func loadQuestionPush(id int) (QuestionPush, error) {
+	var p QuestionPush
+	err := db.QueryRow(`
+		SELECT id, question_id, course_id, fastest_n, winners, pushed_at, closes_at
+		FROM question_pushes
+		WHERE id = ?
+	`, id).Scan(&p.ID, &p.QuestionID, &p.CourseID, &p.FastestN, &p.Winners, &p.PushedAt, &p.ClosesAt)
+	return p, err
+}
+
+// 按截止时间安排结束作答的通知
+func scheduleQuestionClose(push QuestionPush) {
+	if push.ClosesAt == nil {
+		return
+	}
+	if _, err := enqueueJob(jobQuestionClose, gin.H{"push_id": push.ID, "closes_at": push.ClosesAt}, *push.ClosesAt); err != nil {
+		log.Printf("Failed to schedule close for question push %d: %v", push.ID, err)
+	}
+}
+
+func notifyQuestionClosed(push QuestionPush) {
+	data := gin.H{"question_id": push.QuestionID, "push_id": push.ID, "closed_at": push.ClosesAt}
+	hub.broadcast(courseRoom(push.CourseID), Message{Type: "question_closed", Data: data})
+	publishMQTT(push.CourseID, "question", data)
+}
+
+// 一次作答的处理结果
+type answerResult struct {
+	PushID    int
+	LatencyMs *int64
+	Rank      int  // 抢答模式下的名次
+	Changed   bool // 修改了截止前已保存的答案
+	Makeup    bool // 截止后按补答授权提交
+}
+
+// 校验、评分并保存学生的作答，失败时返回 HTTP 状态码和错误码。answeredAt 为作答时间，用于判断是否截止和计算耗时：
+// 在线提交时与服务端收到的时间 now 相同，离线同步时为校正后的客户端时间；抢答名次始终按服务端时间计算。
+// 离线同步的答案耗时由客户端时间推算，标记后不参与答题速度排行
+func recordAnswer(ctx context.Context, user *AuthUser, clientIP string, questionID int, answer string, answeredAt, now time.Time, offline bool) (answerResult, int, ErrorCode) {
+	studentID := user.ID
+	var result answerResult
+	var courseID int
+	var questionType, scoring, correctAnswer string
+	err := db.QueryRowContext(ctx, "SELECT course_id, type, scoring, answer FROM questions WHERE id = ?", questionID).
+		Scan(&courseID, &questionType, &scoring, &correctAnswer)
+	if err == sql.ErrNoRows {
+		return result, http.StatusNotFound, CodeQuestionNotFound
+	}
+	if err != nil {
+		return result, http.StatusInternalServerError, CodeQuestionGetFailed
+	}
+	accessible, err := courseAccessible(courseID, 0, studentID)
+	if err != nil {
+		return result, http.StatusInternalServerError, CodePriceGetFailed
+	}
+	if !accessible {
+		return result, http.StatusPaymentRequired, CodeCoursePaymentRequired
+	}
+	allowed, err := networkAllowed(courseID, user.Org, clientIP, NetworkAnswers)
+	if err != nil {
+		return result, http.StatusInternalServerError, CodeAnswerSubmitFailed
+	}
+	if !allowed {
+		return result, http.StatusForbidden, CodeNetworkRestricted
+	}
+
+	var latency sql.NullInt64
+	push, err := latestQuestionPush(questionID)
+	if err == nil {
+		result.PushID = push.ID
+		// 客户端时钟落后时作答时间可能早于推送，按推送时间计
+		if answeredAt.Before(push.PushedAt) {
+			answeredAt = push.PushedAt
+		}
+		latencyFrom := answeredAt
+		if push.FastestN > 0 {
+			latencyFrom = now
+		}
+		latency = sql.NullInt64{Int64: latencyFrom.Sub(push.PushedAt).Milliseconds(), Valid: true}
+		result.LatencyMs = &latency.Int64
+	} else if err != sql.ErrNoRows {
+		return result, http.StatusInternalServerError, CodeAnswerSubmitFailed
+	}
+
+	// 截止后只接受有补答授权的学生，补答不计耗时，也不参与抢答
+	if result.PushID != 0 && push.closed(answeredAt) {
+		deadline, err := activeMakeup(MakeupQuestion, questionID, studentID)
+		if err != nil {
+			return result, http.StatusInternalServerError, CodeAnswerSubmitFailed
+		}
+		if deadline == nil {
+			return result, http.StatusConflict, CodeQuestionClosed
+		}
+		result.Makeup = true
+		latency, result.LatencyMs = sql.NullInt64{}, nil
+	}
+
+	// 在数据库中存储答案，按题目的计分方式记录得分；推送过的题目截止前可以修改答案
+	answer = normalizeAnswer(questionType, answer)
+	credit := answerCredit(questionType, scoring, correctAnswer, answer)
+	correct := credit == 1
+	if result.PushID != 0 {
+		result.Changed, err = saveLiveAnswer(ctx, push, studentID, answer, credit, answeredAt, latency, offline, result.Makeup)
+	} else {
+		_, err = db.ExecContext(ctx, `
+			INSERT INTO answers (question_id, student_id, answer, push_id, submitted_at, latency_ms, credit, offline)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, questionID, studentID, answer, 0, answeredAt, latency, credit, offline)
+	}
+	if err != nil {
+		if status, code, _, ok := serviceErrorCode(err); ok {
+			return result, status, code
+		}
+		return result, http.StatusInternalServerError, CodeAnswerSubmitFailed
+	}
+
+	// “前 N 名答对”模式下公布获奖学生
+	if result.PushID != 0 && push.FastestN > 0 && correct && !result.Makeup {
+		result.Rank, err = claimFastestRank(push.ID)
+		if err != nil {
+			log.Printf("Failed to rank answer for push %d: %v", push.ID, err)
+		}
+		if result.Rank > 0 {
+			hub.broadcast(courseRoom(courseID), Message{Type: "quiz_winner", Data: gin.H{
+				"question_id": questionID,
+				"push_id":     push.ID,
+				"student_id":  studentID,
+				"rank":        result.Rank,
+				"latency_ms":  latency.Int64,
+			}})
+		}
+	}
+
+	go scoreAnswer(courseID, studentID, questionID, correct, result.Rank)
+	if result.PushID != 0 {
+		go func(pushID int) {
+			if err := evaluateQuizRules(context.Background(), pushID); err != nil {
+				log.Printf("Failed to evaluate quiz rules for push %d: %v", pushID, err)
+			}
+		}(result.PushID)
+	}
+
+	emitEvent(EventAnswerSubmitted, courseID, gin.H{
+		"question_id": questionID,
+		"student_id":  studentID,
+		"course_id":   courseID,
+		"push_id":     result.PushID,
+		"latency_ms":  latency.Int64,
+		"changed":     result.Changed,
+		"makeup":      result.Makeup,
+	})
+	return result, http.StatusOK, ""
+}
+
+// 保存推送题目的作答。截止前学生可以修改答案，每个学生只保留一条当前答案，
+// 被替换的答案记入 answer_revisions；抢答模式下首次提交即锁定，避免逐个尝试选项争抢名次。
+// makeup 为截止后按补答授权提交，由调用方校验授权。返回是否修改了已保存的答案
+func saveLiveAnswer(ctx context.Context, push QuestionPush, studentID int, answer string, credit float64,
+	submittedAt time.Time, latency sql.NullInt64, offline, makeup bool) (bool, error) {
+	if !makeup && push.closed(submittedAt) {
+		return false, errQuestionClosed
+	}
+	unlock, err := acquireLock(answerLockName(push.ID, studentID), lockWait)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var previous struct {
+		id          int64
+		answer      string
+		credit      sql.NullFloat64
+		submittedAt time.Time
+	}
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, answer, credit, submitted_at FROM answers
+		WHERE push_id = ? AND student_id = ?
+		ORDER BY id DESC
+		LIMIT 1
+	`, push.ID, studentID).Scan(&previous.id, &previous.answer, &previous.credit, &previous.submittedAt)
+	if err == sql.ErrNoRows {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO answers (question_id, student_id, answer, push_id, submitted_at, latency_ms, credit, offline, makeup)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, push.QuestionID, studentID, answer, push.ID, submittedAt, latency, credit, offline, makeup)
+		if err != nil {
+			return false, err
+		}
+		return false, tx.Commit()
+	}
+	if err != nil {
+		return false, err
+	}
+	if push.FastestN > 0 {
+		return false, errAnswerLocked
+	}
+	// 离线同步的答案可能晚于较新的答案到达，不能覆盖
+	if submittedAt.Before(previous.submittedAt) {
+		return false, errAnswerSuperseded
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO answer_revisions (answer_id, question_id, push_id, student_id, answer, credit, submitted_at, replaced_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, previous.id, push.QuestionID, push.ID, studentID, previous.answer, previous.credit, previous.submittedAt, submittedAt)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE answers SET answer = ?, credit = ?, submitted_at = ?, latency_ms = ?, offline = ?, makeup = ? WHERE id = ?
+	`, answer, credit, submittedAt, latency, offline, makeup, previous.id)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// 老师提前结束最近一次推送的作答，之后学生不能再提交或修改答案
+func closeQuestion(c *gin.Context) {
+	questionID, ok := intParam(c, "question_id")
+	if !ok {
+		return
+	}
+	push, err := latestQuestionPush(questionID)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeQuestionNotPushed)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQuestionGetFailed)
+		return
+	}
+	now := time.Now().UTC()
+	if push.closed(now) {
+		respondError(c, http.StatusConflict, CodeQuestionClosed)
+		return
+	}
+	if _, err := db.Exec("UPDATE question_pushes SET closes_at = ? WHERE id = ?", now, push.ID); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQuestionCloseFailed)
+		return
+	}
+	push.ClosesAt = &now
+	notifyQuestionClosed(push)
+	respondOK(c, http.StatusOK, push)
+}
+
+// 当前用户在题目最近一次推送中保存的答案；截止前不返回对错，避免学生据此修改
+func getSavedAnswer(c *gin.Context) {
+	questionID, ok := intParam(c, "question_id")
+	if !ok {
+		return
+	}
+	push, err := latestQuestionPush(questionID)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeQuestionNotPushed)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQuestionGetFailed)
+		return
+	}
+
+	saved := SavedAnswer{
+		QuestionID: questionID,
+		PushID:     push.ID,
+		Closed:     push.closed(time.Now()),
+		ClosesAt:   push.ClosesAt,
+	}
+	var answerID int64
+	var submittedAt time.Time
+	err = db.QueryRow(`
+		SELECT id, answer, submitted_at FROM answers
+		WHERE push_id = ? AND student_id = ?
+		ORDER BY id DESC
+		LIMIT 1
+	`, push.ID, currentUser(c).ID).Scan(&answerID, &saved.Answer, &submittedAt)
+	if err != nil && err != sql.ErrNoRows {
+		respondError(c, http.StatusInternalServerError, CodeAnswerGetFailed)
+		return
+	}
+	if err == nil {
+		saved.SubmittedAt = &submittedAt
+		if err := db.QueryRow("SELECT COUNT(*) FROM answer_revisions WHERE answer_id = ?", answerID).Scan(&saved.Revisions); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeAnswerGetFailed)
+			return
+		}
+	}
+
+	loc := requestLocation(c)
+	saved.SubmittedAt = inLocation(saved.SubmittedAt, loc)
+	saved.ClosesAt = inLocation(saved.ClosesAt, loc)
+	respondOK(c, http.StatusOK, saved)
+}
+
+// 离线同步时客户端作答时间最多可以早于服务端的时长，超过时拒绝，避免事后补交
+const maxOfflineAnswerDelay = 10 * time.Minute
+
+// 离线同步中一条答案的处理结果
+type SyncedAnswer struct {
+	ClientID   string    `json:"client_id"`
+	QuestionID int       `json:"question_id"`
+	Status     string    `json:"status"` // accepted 或 rejected
+	Duplicate  bool      `json:"duplicate,omitempty"`
+	Code       ErrorCode `json:"code,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	Changed    bool      `json:"changed,omitempty"`
+	LatencyMs  *int64    `json:"latency_ms,omitempty"`
+	Rank       int       `json:"rank,omitempty"`
+}
+
+// 批量提交移动端在网络中断期间缓存的答案。每条答案带客户端生成的 client_id 作为幂等键，
+// 重复提交直接返回首次的处理结果；按客户端作答时间判断是否在截止前作答，同一题按时间顺序应用
+func submitAnswerBatch(c *gin.Context) {
+	var req struct {
+		Answers []struct {
+			ClientID   string    `json:"client_id" binding:"required,max=64"`
+			QuestionID int       `json:"question_id" binding:"required"`
+			Answer     string    `json:"answer" binding:"required,max=255"`
+			AnsweredAt time.Time `json:"answered_at" binding:"required"`
+		} `json:"answers" binding:"required,min=1,max=50,dive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	studentID := currentUser(c).ID
+	lang := requestLang(c)
+
+	items := req.Answers
+	sort.SliceStable(items, func(i, j int) bool { return items[i].AnsweredAt.Before(items[j].AnsweredAt) })
+
+	results := make([]SyncedAnswer, 0, len(items))
+	for _, item := range items {
+		result := SyncedAnswer{ClientID: item.ClientID, QuestionID: item.QuestionID}
+		reject := func(code ErrorCode) {
+			result.Status = "rejected"
+			result.Code = code
+			result.Message = localize(code, lang)
+		}
+
+		claimed, status, storedCode, err := claimSyncKey(studentID, item.ClientID, item.QuestionID)
+		if err != nil {
+			reject(CodeAnswerSubmitFailed)
+			results = append(results, result)
+			continue
+		}
+		if !claimed {
+			result.Duplicate = true
+			result.Status = status
+			if storedCode != "" {
+				reject(ErrorCode(storedCode))
+			}
+			results = append(results, result)
+			continue
+		}
+
+		now := time.Now().UTC()
+		answeredAt := item.AnsweredAt.UTC()
+		if answeredAt.After(now) {
+			answeredAt = now // 客户端时钟超前
+		}
+		httpStatus, code := http.StatusOK, ErrorCode("")
+		if now.Sub(answeredAt) > maxOfflineAnswerDelay {
+			httpStatus, code = http.StatusConflict, CodeAnswerSyncExpired
+		} else {
+			var answered answerResult
+			answered, httpStatus, code = recordAnswer(c.Request.Context(), currentUser(c), c.ClientIP(), item.QuestionID, item.Answer, answeredAt, now, true)
+			result.Changed, result.LatencyMs, result.Rank = answered.Changed, answered.LatencyMs, answered.Rank
+		}
+
+		if code == "" {
+			result.Status = "accepted"
+		} else {
+			reject(code)
+		}
+		// 服务端错误时释放幂等键，客户端可以重试
+		if httpStatus >= http.StatusInternalServerError {
+			releaseSyncKey(studentID, item.ClientID)
+		} else if _, err := db.Exec(`
+			UPDATE answer_sync_keys SET status = ?, code = ? WHERE student_id = ? AND client_id = ?
+		`, result.Status, string(code), studentID, item.ClientID); err != nil {
+			log.Printf("Failed to record sync result for student %d: %v", studentID, err)
+		}
+		results = append(results, result)
+	}
+
+	respondOK(c, http.StatusOK, gin.H{"results": results})
+}
+
+// 占用幂等键；已被占用时返回首次处理的状态和错误码
+func claimSyncKey(studentID int, clientID string, questionID int) (bool, string, string, error) {
+	res, err := db.Exec(dialect.insertIgnore(`
+		INSERT INTO answer_sync_keys (student_id, client_id, question_id, status, code, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+	), studentID, clientID, questionID, "pending", "", time.Now().UTC())
+	if err != nil {
+		return false, "", "", err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return n == 1, "", "", err
+	}
+	var status, code string
+	err = db.QueryRow("SELECT status, code FROM answer_sync_keys WHERE student_id = ? AND client_id = ?", studentID, clientID).
+		Scan(&status, &code)
+	return false, status, code, err
+}
+
+func releaseSyncKey(studentID int, clientID string) {
+	if _, err := db.Exec("DELETE FROM answer_sync_keys WHERE student_id = ? AND client_id = ?", studentID, clientID); err != nil {
+		log.Printf("Failed to release sync key for student %d: %v", studentID, err)
+	}
+}
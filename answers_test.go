@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSaveLiveAnswerAfterClose(t *testing.T) {
+	pushedAt := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	closesAt := pushedAt.Add(time.Minute)
+	push := QuestionPush{ID: 7, QuestionID: 3, CourseID: 1, PushedAt: pushedAt, ClosesAt: &closesAt}
+
+	tests := []struct {
+		name        string
+		submittedAt time.Time
+		offline     bool
+		makeup      bool
+		wantErr     error
+	}{
+		{"before close", closesAt.Add(-time.Second), false, false, nil},
+		{"offline before close", closesAt.Add(-time.Second), true, false, nil},
+		{"after close", closesAt, false, false, errQuestionClosed},
+		{"makeup after close", closesAt.Add(time.Hour), false, true, nil},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			studentID := 100 + i
+			latency := sql.NullInt64{Int64: tt.submittedAt.Sub(pushedAt).Milliseconds(), Valid: !tt.makeup}
+			_, err := saveLiveAnswer(context.Background(), push, studentID, "A", 1, tt.submittedAt, latency, tt.offline, tt.makeup)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("saveLiveAnswer error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			var offline, makeup bool
+			var stored sql.NullInt64
+			err = db.QueryRow("SELECT offline, makeup, latency_ms FROM answers WHERE push_id = ? AND student_id = ?", push.ID, studentID).
+				Scan(&offline, &makeup, &stored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if offline != tt.offline || makeup != tt.makeup || stored.Valid == tt.makeup {
+				t.Errorf("stored offline=%v makeup=%v latency=%v, want offline=%v makeup=%v", offline, makeup, stored, tt.offline, tt.makeup)
+			}
+		})
+	}
+}
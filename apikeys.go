@@ -0,0 +1,437 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 通过 API Key 调用接口的学校系统，不是登录用户，权限由 API Key 的 scopes 决定
+const RoleIntegration = "integration"
+
+const (
+	apiKeyHeader      = "X-API-Key"
+	apiKeyPrefix      = "zk_"
+	apiKeyLength      = 40
+	apiKeyShownLength = 8 // 列表中显示的前缀长度，便于辨认
+
+	// 每分钟请求数，API Key 未设置时使用
+	defaultAPIKeyRateLimit = 600
+	maxAPIKeyRateLimit     = 60000
+
+	// 轮换后旧密钥默认继续可用的时间，供对接方更新配置
+	defaultAPIKeyGrace = 24 * time.Hour
+	maxAPIKeyGrace     = 7 * 24 * time.Hour
+
+	// 多副本部署时其他实例吊销的密钥最迟在该时间后失效，最后使用时间也按该间隔更新
+	apiKeyCacheTTL = 30 * time.Second
+)
+
+// 学校系统对接使用的 API Key，只在创建和轮换时返回明文
+type APIKey struct {
+	ID                int        `json:"id"`
+	Org               string     `json:"org"`
+	Name              string     `json:"name"`
+	Prefix            string     `json:"prefix"`
+	Scopes            []string   `json:"scopes"`
+	RateLimit         int        `json:"rate_limit"` // 每分钟请求数
+	CreatedBy         int        `json:"created_by"`
+	CreatedAt         time.Time  `json:"created_at"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt        *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP        string     `json:"last_used_ip,omitempty"`
+	RotatedAt         *time.Time `json:"rotated_at,omitempty"`
+	PreviousExpiresAt *time.Time `json:"previous_expires_at,omitempty"` // 轮换前的旧密钥失效时间
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+}
+
+func (k *APIKey) inLocation(loc *time.Location) {
+	k.CreatedAt = k.CreatedAt.In(loc)
+	k.ExpiresAt = inLocation(k.ExpiresAt, loc)
+	k.LastUsedAt = inLocation(k.LastUsedAt, loc)
+	k.RotatedAt = inLocation(k.RotatedAt, loc)
+	k.PreviousExpiresAt = inLocation(k.PreviousExpiresAt, loc)
+	k.RevokedAt = inLocation(k.RevokedAt, loc)
+}
+
+func (k APIKey) rateLimit() int {
+	if k.RateLimit > 0 {
+		return k.RateLimit
+	}
+	return defaultAPIKeyRateLimit
+}
+
+type cachedAPIKey struct {
+	key      *APIKey // 无效的密钥为 nil
+	loadedAt time.Time
+}
+
+type apiKeyWindow struct {
+	minute int64
+	count  int
+}
+
+var (
+	apiKeyMu    sync.Mutex
+	apiKeyCache = make(map[string]cachedAPIKey)
+
+	// 未启用 Redis 时在本实例内按分钟计数
+	apiKeyWindows = make(map[int]apiKeyWindow)
+)
+
+const apiKeyColumns = `id, org, name, prefix, scopes, rate_limit, created_by, created_at, expires_at,
+	last_used_at, last_used_ip, rotated_at, previous_expires_at, revoked_at`
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (APIKey, error) {
+	var k APIKey
+	var scopes string
+	err := row.Scan(&k.ID, &k.Org, &k.Name, &k.Prefix, &scopes, &k.RateLimit, &k.CreatedBy, &k.CreatedAt, &k.ExpiresAt,
+		&k.LastUsedAt, &k.LastUsedIP, &k.RotatedAt, &k.PreviousExpiresAt, &k.RevokedAt)
+	k.Scopes = splitList(scopes)
+	return k, err
+}
+
+// 生成新的密钥明文，返回明文和列表中显示的前缀
+func newAPIKeySecret() (string, string) {
+	secret := apiKeyPrefix + generateRandomString(apiKeyLength)
+	return secret, secret[:len(apiKeyPrefix)+apiKeyShownLength]
+}
+
+// 校验 scopes 均为已定义的权限，返回去重后的列表
+func normalizeScopes(scopes []string) ([]string, bool) {
+	out := []string{}
+	for _, s := range scopes {
+		if !contains(allPermissions, s) {
+			return nil, false
+		}
+		if !contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out, true
+}
+
+// 按明文查找有效的密钥，轮换后的旧密钥在宽限期内仍然有效。缓存过期重新加载时顺带更新最后使用时间和 IP
+func lookupAPIKey(c *gin.Context, secret string) (*APIKey, error) {
+	hash := hashSecret(secret)
+	apiKeyMu.Lock()
+	cached, ok := apiKeyCache[hash]
+	apiKeyMu.Unlock()
+	if ok && time.Since(cached.loadedAt) < apiKeyCacheTTL {
+		return cached.key, nil
+	}
+
+	now := time.Now().UTC()
+	k, err := scanAPIKey(db.QueryRow(`
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE (key_hash = ? OR previous_hash = ? AND previous_expires_at > ?)
+			AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)
+	`, hash, hash, now, now))
+	var key *APIKey
+	switch {
+	case err == nil:
+		key = &k
+		if _, err := db.Exec("UPDATE api_keys SET last_used_at = ?, last_used_ip = ? WHERE id = ?", now, c.ClientIP(), k.ID); err != nil {
+			log.Printf("Failed to update last use of API key %d: %v", k.ID, err)
+		}
+	case err != sql.ErrNoRows:
+		return nil, err
+	}
+	apiKeyMu.Lock()
+	apiKeyCache[hash] = cachedAPIKey{key: key, loadedAt: time.Now()}
+	apiKeyMu.Unlock()
+	return key, nil
+}
+
+// 密钥变更后立即在所有实例生效
+func invalidateAPIKeys() {
+	apiKeyMu.Lock()
+	apiKeyCache = make(map[string]cachedAPIKey)
+	apiKeyMu.Unlock()
+	publishControl(controlAuth)
+}
+
+// 占用本分钟的一次请求额度，超出时返回到下一分钟的等待时间；配置了 Redis 时在所有副本间共享
+func takeAPIKeyQuota(key *APIKey) time.Duration {
+	now := time.Now()
+	minute := now.Unix() / 60
+	wait := time.Duration(60-now.Unix()%60) * time.Second
+	if redisClient != nil {
+		redisKey := fmt.Sprintf("zhibo:apikey:%d:%d", key.ID, minute)
+		n, err := redisClient.Incr(redisKey).Result()
+		if err == nil {
+			if n == 1 {
+				redisClient.Expire(redisKey, 2*time.Minute)
+			}
+			if n > int64(key.rateLimit()) {
+				return wait
+			}
+			return 0
+		}
+		log.Printf("Failed to check rate limit of API key %d: %v", key.ID, err)
+	}
+
+	apiKeyMu.Lock()
+	defer apiKeyMu.Unlock()
+	w := apiKeyWindows[key.ID]
+	if w.minute != minute {
+		w = apiKeyWindow{minute: minute}
+	}
+	w.count++
+	apiKeyWindows[key.ID] = w
+	if w.count > key.rateLimit() {
+		return wait
+	}
+	return 0
+}
+
+// 校验 X-API-Key 头，失败时写入错误响应
+func authenticateAPIKey(c *gin.Context, secret string) (*AuthUser, bool) {
+	key, err := lookupAPIKey(c, secret)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return nil, false
+	}
+	if key == nil {
+		respondError(c, http.StatusUnauthorized, CodeAPIKeyInvalid)
+		return nil, false
+	}
+	if wait := takeAPIKeyQuota(key); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(wait/time.Second)))
+		respondError(c, http.StatusTooManyRequests, CodeAPIKeyRateLimited)
+		return nil, false
+	}
+	return &AuthUser{Role: RoleIntegration, Org: key.Org, APIKeyID: key.ID, Scopes: key.Scopes}, true
+}
+
+// 查询 API Key，失败时写入错误响应
+func apiKeyParam(c *gin.Context) (APIKey, bool) {
+	id, ok := intParam(c, "id")
+	if !ok {
+		return APIKey{}, false
+	}
+	k, err := scanAPIKey(db.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeAPIKeyNotFound)
+		return k, false
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeAPIKeyGetFailed)
+		return k, false
+	}
+	return k, true
+}
+
+// API Key 列表，可按机构筛选，默认不含已吊销的
+func adminListAPIKeys(c *gin.Context) {
+	q := "SELECT " + apiKeyColumns + " FROM api_keys WHERE 1 = 1"
+	args := []interface{}{}
+	if org, ok := c.GetQuery("org"); ok {
+		q += " AND org = ?"
+		args = append(args, org)
+	}
+	if c.Query("revoked") != "true" {
+		q += " AND revoked_at IS NULL"
+	}
+	rows, err := db.Query(q+" ORDER BY id", args...)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeAPIKeyGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeAPIKeyGetFailed)
+			return
+		}
+		k.inLocation(loc)
+		keys = append(keys, k)
+	}
+	respondOK(c, http.StatusOK, keys)
+}
+
+// 为机构创建 API Key，明文只在响应中出现一次
+func adminCreateAPIKey(c *gin.Context) {
+	var req struct {
+		Org       string     `json:"org" binding:"max=64"`
+		Name      string     `json:"name" binding:"required,max=100"`
+		Scopes    []string   `json:"scopes" binding:"required,min=1"`
+		RateLimit int        `json:"rate_limit" binding:"min=0"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	scopes, ok := normalizeScopes(req.Scopes)
+	if !ok {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "scopes")
+		return
+	}
+	if req.RateLimit > maxAPIKeyRateLimit {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "rate_limit")
+		return
+	}
+	now := time.Now().UTC()
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) {
+			respondError(c, http.StatusBadRequest, CodeInvalidParam, "expires_at")
+			return
+		}
+		t := req.ExpiresAt.UTC()
+		req.ExpiresAt = &t
+	}
+
+	secret, prefix := newAPIKeySecret()
+	key := APIKey{
+		Org:       req.Org,
+		Name:      strings.TrimSpace(req.Name),
+		Prefix:    prefix,
+		Scopes:    scopes,
+		RateLimit: req.RateLimit,
+		CreatedBy: currentUser(c).ID,
+		CreatedAt: now,
+		ExpiresAt: req.ExpiresAt,
+	}
+	id, err := dialect.insertID(db, `
+		INSERT INTO api_keys (org, name, prefix, key_hash, scopes, rate_limit, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, key.Org, key.Name, key.Prefix, hashSecret(secret), strings.Join(key.Scopes, ","), key.RateLimit, key.CreatedBy, key.CreatedAt, key.ExpiresAt)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeAPIKeyUpdateFailed)
+		return
+	}
+	key.ID = int(id)
+
+	recordAudit(c, "create_api_key", "api_key", key.ID, gin.H{"org": key.Org, "name": key.Name, "scopes": key.Scopes})
+	key.inLocation(requestLocation(c))
+	respondOK(c, http.StatusCreated, gin.H{"api_key": key, "secret": secret})
+}
+
+// 修改 API Key 的名称、权限范围或请求频率，未提供的字段保持不变
+func adminUpdateAPIKey(c *gin.Context) {
+	key, ok := apiKeyParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name      *string  `json:"name" binding:"omitempty,min=1,max=100"`
+		Scopes    []string `json:"scopes" binding:"omitempty,min=1"`
+		RateLimit *int     `json:"rate_limit" binding:"omitempty,min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if key.RevokedAt != nil {
+		respondError(c, http.StatusConflict, CodeAPIKeyRevoked)
+		return
+	}
+	if req.Name != nil {
+		key.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Scopes != nil {
+		scopes, ok := normalizeScopes(req.Scopes)
+		if !ok {
+			respondError(c, http.StatusBadRequest, CodeInvalidParam, "scopes")
+			return
+		}
+		key.Scopes = scopes
+	}
+	if req.RateLimit != nil {
+		if *req.RateLimit > maxAPIKeyRateLimit {
+			respondError(c, http.StatusBadRequest, CodeInvalidParam, "rate_limit")
+			return
+		}
+		key.RateLimit = *req.RateLimit
+	}
+	if _, err := db.Exec("UPDATE api_keys SET name = ?, scopes = ?, rate_limit = ? WHERE id = ?",
+		key.Name, strings.Join(key.Scopes, ","), key.RateLimit, key.ID); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeAPIKeyUpdateFailed)
+		return
+	}
+	invalidateAPIKeys()
+
+	recordAudit(c, "update_api_key", "api_key", key.ID, gin.H{"name": key.Name, "scopes": key.Scopes, "rate_limit": key.RateLimit})
+	key.inLocation(requestLocation(c))
+	respondOK(c, http.StatusOK, key)
+}
+
+// 轮换 API Key，旧密钥在宽限期内仍可使用，grace_minutes 为 0 时立即失效
+func adminRotateAPIKey(c *gin.Context) {
+	key, ok := apiKeyParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		GraceMinutes *int `json:"grace_minutes" binding:"omitempty,min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		respondBindError(c, err)
+		return
+	}
+	grace := defaultAPIKeyGrace
+	if req.GraceMinutes != nil {
+		grace = time.Duration(*req.GraceMinutes) * time.Minute
+	}
+	if grace > maxAPIKeyGrace {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "grace_minutes")
+		return
+	}
+	if key.RevokedAt != nil {
+		respondError(c, http.StatusConflict, CodeAPIKeyRevoked)
+		return
+	}
+
+	now := time.Now().UTC()
+	previousExpiresAt := now.Add(grace)
+	secret, prefix := newAPIKeySecret()
+	_, err := db.Exec(`
+		UPDATE api_keys SET previous_hash = key_hash, previous_expires_at = ?, key_hash = ?, prefix = ?, rotated_at = ?
+		WHERE id = ? AND revoked_at IS NULL
+	`, previousExpiresAt, hashSecret(secret), prefix, now, key.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeAPIKeyUpdateFailed)
+		return
+	}
+	invalidateAPIKeys()
+	key.Prefix, key.RotatedAt, key.PreviousExpiresAt = prefix, &now, &previousExpiresAt
+
+	recordAudit(c, "rotate_api_key", "api_key", key.ID, gin.H{"grace_minutes": int(grace / time.Minute)})
+	key.inLocation(requestLocation(c))
+	respondOK(c, http.StatusOK, gin.H{"api_key": key, "secret": secret})
+}
+
+// 吊销 API Key，包括宽限期内的旧密钥
+func adminRevokeAPIKey(c *gin.Context) {
+	key, ok := apiKeyParam(c)
+	if !ok {
+		return
+	}
+	if key.RevokedAt != nil {
+		respondError(c, http.StatusConflict, CodeAPIKeyRevoked)
+		return
+	}
+	now := time.Now().UTC()
+	if _, err := db.Exec("UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", now, key.ID); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeAPIKeyUpdateFailed)
+		return
+	}
+	invalidateAPIKeys()
+
+	recordAudit(c, "revoke_api_key", "api_key", key.ID, gin.H{"org": key.Org, "name": key.Name})
+	respondOK(c, http.StatusOK, gin.H{"id": key.ID, "revoked_at": now.In(requestLocation(c))})
+}
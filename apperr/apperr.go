@@ -0,0 +1,79 @@
+// Package apperr 定义服务层返回的领域错误。
+//
+// 服务函数用 ErrNotFound 等哨兵错误表示错误的种类，需要告知客户端具体原因时
+// 返回带错误码的 *Error；HTTP 层统一按种类映射状态码，其他错误一律视为内部错误。
+package apperr
+
+import (
+	"errors"
+	"strings"
+)
+
+// 领域错误的种类
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrForbidden    = errors.New("forbidden")
+	ErrInvalidState = errors.New("invalid state")
+	ErrExpired      = errors.New("expired") // 资源曾经有效但已过期，如兑换码
+)
+
+var kinds = []error{ErrNotFound, ErrConflict, ErrForbidden, ErrInvalidState, ErrExpired}
+
+// 带错误码的领域错误，errors.Is 既能匹配种类也能匹配底层错误
+type Error struct {
+	Kind error         // 上面的哨兵错误之一
+	Code string        // 返回给客户端的错误码，供客户端分支处理
+	Args []interface{} // 错误信息的格式化参数
+	Err  error         // 底层错误，可为空
+}
+
+// 新建领域错误
+func New(kind error, code string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Code: code, Args: args}
+}
+
+// 把底层错误包装为领域错误
+func Wrap(kind error, code string, err error) *Error {
+	return &Error{Kind: kind, Code: code, Err: err}
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	if e.Code != "" {
+		b.WriteString(e.Code)
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Kind.Error())
+	if e.Err != nil {
+		b.WriteString(": ")
+		b.WriteString(e.Err.Error())
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// 错误的种类，不是领域错误时返回 nil
+func KindOf(err error) error {
+	for _, kind := range kinds {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	return nil
+}
+
+// 错误码及其格式化参数，没有错误码时返回空
+func CodeOf(err error) (string, []interface{}) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code, e.Args
+	}
+	return "", nil
+}
@@ -0,0 +1,45 @@
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestKindAndCode(t *testing.T) {
+	cause := errors.New("row locked")
+	tests := []struct {
+		name     string
+		err      error
+		kind     error
+		code     string
+		args     []interface{}
+		message  string
+		hasCause bool
+	}{
+		{"sentinel", ErrNotFound, ErrNotFound, "", nil, "not found", false},
+		{"with code", New(ErrConflict, "ANSWER_LOCKED"), ErrConflict, "ANSWER_LOCKED", nil, "ANSWER_LOCKED: conflict", false},
+		{"with args", New(ErrInvalidState, "SESSION_STATUS", "ended"), ErrInvalidState, "SESSION_STATUS", []interface{}{"ended"}, "SESSION_STATUS: invalid state", false},
+		{"wrapped cause", Wrap(ErrConflict, "LOCKED", cause), ErrConflict, "LOCKED", nil, "LOCKED: conflict: row locked", true},
+		{"wrapped by fmt", fmt.Errorf("redeem: %w", New(ErrExpired, "ACCESS_CODE_EXPIRED")), ErrExpired, "ACCESS_CODE_EXPIRED", nil, "redeem: ACCESS_CODE_EXPIRED: expired", false},
+		{"plain error", cause, nil, "", nil, "row locked", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if kind := KindOf(tt.err); kind != tt.kind {
+				t.Errorf("KindOf = %v, want %v", kind, tt.kind)
+			}
+			code, args := CodeOf(tt.err)
+			if code != tt.code || !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("CodeOf = %q, %v; want %q, %v", code, args, tt.code, tt.args)
+			}
+			if tt.err.Error() != tt.message {
+				t.Errorf("Error() = %q, want %q", tt.err.Error(), tt.message)
+			}
+			if errors.Is(tt.err, cause) != tt.hasCause {
+				t.Errorf("errors.Is(cause) = %v, want %v", !tt.hasCause, tt.hasCause)
+			}
+		})
+	}
+}
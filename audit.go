@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Dong557799/zhibo-class/query"
+	"github.com/gin-gonic/gin"
+)
+
+// 审计日志
+type AuditLog struct {
+	ID             int             `json:"id"`
+	ActorID        int             `json:"actor_id"`
+	ImpersonatorID int             `json:"impersonator_id,omitempty"`
+	Action         string          `json:"action"`
+	TargetType     string          `json:"target_type"`
+	TargetID       int             `json:"target_id"`
+	Detail         json.RawMessage `json:"detail,omitempty"`
+	IP             string          `json:"ip"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// 记录审计日志，失败只打日志不影响请求
+func recordAudit(c *gin.Context, action, targetType string, targetID int, detail interface{}) {
+	var actorID, impersonatorID int
+	if user := currentUser(c); user != nil {
+		actorID = user.ID
+		impersonatorID = user.ImpersonatorID
+	}
+
+	raw, err := json.Marshal(detail)
+	if err != nil {
+		raw = []byte("null")
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO audit_logs (actor_id, impersonator_id, action, target_type, target_id, detail, ip, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, NOW())
+	`, actorID, impersonatorID, action, targetType, targetID, string(raw), c.ClientIP())
+	if err != nil {
+		log.Printf("Failed to record audit log %s by user %d: %v", action, actorID, err)
+	}
+}
+
+// 查询审计日志
+func listAuditLogs(c *gin.Context) {
+	page, pageSize, offset := pageParams(c)
+
+	q := query.New().
+		Eq("action", c.Query("action")).
+		Eq("actor_id", c.Query("actor_id"))
+
+	var total int64
+	if err := db.QueryRow("SELECT COUNT(*) FROM audit_logs WHERE "+q.WhereSQL(), q.Args()...).Scan(&total); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeAuditGetFailed)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, actor_id, impersonator_id, action, target_type, target_id, detail, ip, created_at
+		FROM audit_logs
+		WHERE `+q.WhereSQL()+`
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`, q.Args(pageSize, offset)...)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeAuditGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	logs := []AuditLog{}
+	for rows.Next() {
+		var l AuditLog
+		var detail string
+		if err := rows.Scan(&l.ID, &l.ActorID, &l.ImpersonatorID, &l.Action, &l.TargetType, &l.TargetID, &detail, &l.IP, &l.CreatedAt); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeAuditGetFailed)
+			return
+		}
+		l.Detail = json.RawMessage(detail)
+		logs = append(logs, l)
+	}
+
+	respondPage(c, logs, Pagination{Page: page, PageSize: pageSize, Total: total, HasMore: int64(offset+len(logs)) < total})
+}
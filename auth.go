@@ -0,0 +1,401 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 用户角色
+const (
+	RoleAdmin   = "admin"
+	RoleTeacher = "teacher"
+	RoleStudent = "student"
+)
+
+var userRoles = []string{RoleAdmin, RoleTeacher, RoleStudent}
+
+const (
+	defaultTokenTTL     = 24 * time.Hour
+	impersonateTokenTTL = time.Hour
+
+	// 没有密码的账号（如验证码注册）的 password_hash，bcrypt 校验总是失败
+	noPasswordHash = "!"
+
+	// 多副本部署时其他实例停用账号后，已签发的令牌最迟在该时间后失效
+	userStatusCacheTTL = 30 * time.Second
+)
+
+// 用户
+type User struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	Status    string    `json:"status"`              // active / disabled
+	TimeZone  string    `json:"time_zone,omitempty"` // IANA 时区，为空时使用机构默认时区
+	Org       string    `json:"org,omitempty"`       // 所属机构，用于按机构开关功能
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// 当前请求的登录用户
+type AuthUser struct {
+	ID             int
+	Role           string
+	ImpersonatorID int // 管理员代登录时为管理员ID
+	TimeZone       string
+	Org            string
+	LoginSessionID string // 登录设备，旧令牌为空
+
+	// 通过 API Key 调用时的密钥ID和权限范围，此时 ID 为 0
+	APIKeyID int
+	Scopes   []string
+}
+
+// JWT 载荷
+type tokenClaims struct {
+	UserID         int    `json:"uid"`
+	Role           string `json:"role"`
+	ImpersonatorID int    `json:"imp,omitempty"`
+	TimeZone       string `json:"tz,omitempty"`
+	Org            string `json:"org,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// 登录成功后返回给客户端的令牌，未启用刷新令牌时只有访问令牌
+type tokenPair struct {
+	Token            string     `json:"token"`
+	ExpiresAt        time.Time  `json:"expires_at"`
+	RefreshToken     string     `json:"refresh_token,omitempty"`
+	RefreshExpiresAt *time.Time `json:"refresh_expires_at,omitempty"`
+}
+
+// 登录接口的响应，附带 extra 中的字段
+func (p tokenPair) response(extra gin.H) gin.H {
+	data := gin.H{"token": p.Token, "expires_at": p.ExpiresAt}
+	if p.RefreshToken != "" {
+		data["refresh_token"] = p.RefreshToken
+		data["refresh_expires_at"] = p.RefreshExpiresAt
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	return data
+}
+
+// 登录后重定向到前端时放在地址片段中的令牌
+func (p tokenPair) fragment() url.Values {
+	values := url.Values{
+		"token":      {p.Token},
+		"expires_at": {p.ExpiresAt.UTC().Format(time.RFC3339)},
+	}
+	if p.RefreshToken != "" {
+		values.Set("refresh_token", p.RefreshToken)
+		values.Set("refresh_expires_at", p.RefreshExpiresAt.UTC().Format(time.RFC3339))
+	}
+	return values
+}
+
+// 访问令牌有效期
+func accessTokenTTL() time.Duration {
+	if minutes := currentConfig().TokenMinutes; minutes > 0 {
+		return time.Duration(minutes) * time.Minute
+	}
+	return defaultTokenTTL
+}
+
+// 签发访问令牌，同时记录登录设备，令牌 ID 即设备ID。启用刷新令牌时一并签发，代登录不签发刷新令牌
+func issueToken(c *gin.Context, user User, impersonatorID int, ttl time.Duration) (tokenPair, error) {
+	var pair tokenPair
+	sessionExpiresAt := time.Now().Add(ttl)
+	refresh := impersonatorID == 0 && refreshTokenTTL() > 0
+	if refresh {
+		sessionExpiresAt = time.Now().Add(refreshTokenTTL())
+	}
+	loginSessionID, err := createLoginSession(c, user.ID, impersonatorID, sessionExpiresAt)
+	if err != nil {
+		return pair, err
+	}
+	if pair.Token, pair.ExpiresAt, err = signToken(user, impersonatorID, loginSessionID, ttl); err != nil {
+		return pair, err
+	}
+	if refresh {
+		if pair.RefreshToken, err = issueRefreshToken(db, user.ID, loginSessionID, sessionExpiresAt); err != nil {
+			return pair, err
+		}
+		pair.RefreshExpiresAt = &sessionExpiresAt
+	}
+	return pair, nil
+}
+
+// 为登录设备签名访问令牌
+func signToken(user User, impersonatorID int, loginSessionID string, ttl time.Duration) (string, time.Time, error) {
+	expiresAt := time.Now().Add(ttl)
+	claims := tokenClaims{
+		UserID:         user.ID,
+		Role:           user.Role,
+		ImpersonatorID: impersonatorID,
+		TimeZone:       user.TimeZone,
+		Org:            user.Org,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        loginSessionID,
+			Subject:   fmt.Sprint(user.ID),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(currentConfig().JWTSecret))
+	return token, expiresAt, err
+}
+
+// 解析并校验访问令牌
+func parseToken(tokenString string) (*tokenClaims, error) {
+	claims := &tokenClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return []byte(currentConfig().JWTSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+type cachedUserStatus struct {
+	status   string
+	loadedAt time.Time
+}
+
+var (
+	userStatusMu    sync.Mutex
+	userStatusCache = make(map[int]cachedUserStatus)
+)
+
+// 账号当前状态，用于让停用或删除的账号已签发的令牌失效
+func userStatus(userID int) (string, error) {
+	userStatusMu.Lock()
+	cached, ok := userStatusCache[userID]
+	userStatusMu.Unlock()
+	if ok && time.Since(cached.loadedAt) < userStatusCacheTTL {
+		return cached.status, nil
+	}
+
+	var status string
+	err := db.QueryRow("SELECT status FROM users WHERE id = ?", userID).Scan(&status)
+	if err == sql.ErrNoRows {
+		status, err = "deleted", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	userStatusMu.Lock()
+	userStatusCache[userID] = cachedUserStatus{status: status, loadedAt: time.Now()}
+	userStatusMu.Unlock()
+	return status, nil
+}
+
+// 账号状态变更后立即在所有实例生效
+func invalidateUserStatus(userIDs ...int) {
+	userStatusMu.Lock()
+	for _, id := range userIDs {
+		delete(userStatusCache, id)
+	}
+	userStatusMu.Unlock()
+	publishControl(controlAuth)
+}
+
+// 校验 Authorization 头中的 Bearer 令牌
+func authRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user *AuthUser
+		var ok bool
+		header := c.GetHeader("Authorization")
+		tokenString := strings.TrimPrefix(header, "Bearer ")
+		switch key := c.GetHeader(apiKeyHeader); {
+		case key != "":
+			user, ok = authenticateAPIKey(c, key)
+		case header == "" || tokenString == header:
+			respondError(c, http.StatusUnauthorized, CodeUnauthorized)
+		default:
+			user, ok = authenticate(c, tokenString)
+		}
+		if !ok {
+			c.Abort()
+			return
+		}
+		c.Set("user", user)
+
+		// 代登录期间的写操作记录审计日志
+		if user.ImpersonatorID != 0 && c.Request.Method != http.MethodGet {
+			recordAudit(c, "impersonated_request", "route", 0, gin.H{
+				"method": c.Request.Method,
+				"path":   c.Request.URL.Path,
+			})
+		}
+
+		c.Next()
+	}
+}
+
+// 校验令牌及账号状态，失败时写入错误响应
+func authenticate(c *gin.Context, tokenString string) (*AuthUser, bool) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, CodeUnauthorized)
+		return nil, false
+	}
+	status, err := userStatus(claims.UserID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return nil, false
+	}
+	if status != "active" {
+		respondError(c, http.StatusUnauthorized, CodeUserDisabled)
+		return nil, false
+	}
+	if claims.ID != "" {
+		active, err := loginSessionActive(c, claims.ID, claims.UserID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeInternal)
+			return nil, false
+		}
+		if !active {
+			respondError(c, http.StatusUnauthorized, CodeLoginRevoked)
+			return nil, false
+		}
+	}
+	return &AuthUser{ID: claims.UserID, Role: claims.Role, ImpersonatorID: claims.ImpersonatorID, TimeZone: claims.TimeZone, Org: claims.Org, LoginSessionID: claims.ID}, true
+}
+
+// WebSocket 握手的登录用户。浏览器无法为 WebSocket 设置请求头，应先签发会话的连接令牌并通过 ws_token 查询参数传入；
+// 未要求连接令牌时，登录令牌也可以通过 access_token 查询参数传入
+func authenticateUpgrade(c *gin.Context, sessionID int) (*AuthUser, bool) {
+	if token := c.Query("ws_token"); token != "" {
+		user, ok := authenticateWSToken(c, token, sessionID)
+		if ok {
+			c.Set("user", user)
+		}
+		return user, ok
+	}
+	tokenString := c.Query("access_token")
+	if tokenString != "" && currentConfig().WS.RequireConnectToken {
+		respondError(c, http.StatusUnauthorized, CodeWSTokenRequired)
+		return nil, false
+	}
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		tokenString = strings.TrimPrefix(header, "Bearer ")
+	}
+	if tokenString == "" {
+		respondError(c, http.StatusUnauthorized, CodeUnauthorized)
+		return nil, false
+	}
+	user, ok := authenticate(c, tokenString)
+	if ok {
+		c.Set("user", user)
+	}
+	return user, ok
+}
+
+// 要求登录用户具有指定角色之一
+func requireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := currentUser(c)
+		if user == nil || !contains(roles, user.Role) {
+			respondError(c, http.StatusForbidden, CodeForbidden)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// 当前登录用户，未登录时返回 nil
+func currentUser(c *gin.Context) *AuthUser {
+	if v, ok := c.Get("user"); ok {
+		return v.(*AuthUser)
+	}
+	return nil
+}
+
+// 用户名密码登录
+func login(c *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	var user User
+	var passwordHash string
+	err := db.QueryRow(`
+		SELECT id, username, name, role, status, time_zone, org, password_hash, created_at
+		FROM users
+		WHERE username = ?
+	`, req.Username).Scan(&user.ID, &user.Username, &user.Name, &user.Role, &user.Status, &user.TimeZone, &user.Org, &passwordHash, &user.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusUnauthorized, CodeInvalidCredentials)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeUserGetFailed)
+		}
+		return
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)) != nil {
+		respondError(c, http.StatusUnauthorized, CodeInvalidCredentials)
+		return
+	}
+	if user.Status != "active" {
+		respondError(c, http.StatusForbidden, CodeUserDisabled)
+		return
+	}
+
+	tokens, err := issueToken(c, user, 0, accessTokenTTL())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+
+	respondOK(c, http.StatusOK, tokens.response(gin.H{"user": user}))
+}
+
+// 没有管理员时按配置创建初始管理员账号
+func ensureAdminUser() error {
+	conf := currentConfig()
+	if conf.AdminUsername == "" || conf.AdminPassword == "" {
+		return nil
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE role = ?", RoleAdmin).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	hash, err := hashPassword(conf.AdminPassword)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		INSERT INTO users (username, name, role, status, password_hash, created_at)
+		VALUES (?, ?, ?, 'active', ?, NOW())
+	`, conf.AdminUsername, conf.AdminUsername, RoleAdmin, hash)
+	return err
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
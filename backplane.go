@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const (
+	backplaneChannel = "zhibo:hub"
+	// 配置类变更的通知频道，收到后各副本重新加载本地缓存
+	controlChannel = "zhibo:control"
+)
+
+// Redis 连接配置，addr 为空时以单实例模式运行
+type RedisConfig struct {
+	Addr     string `json:"addr"`
+	Password string `json:"password"`
+	DB       int    `json:"db"`
+}
+
+// 跨实例转发的广播消息
+type backplaneMessage struct {
+	Origin  string          `json:"origin"`
+	Room    string          `json:"room"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// 跨实例的缓存失效通知
+type controlMessage struct {
+	Origin string `json:"origin"`
+	Kind   string `json:"kind"`
+}
+
+var (
+	redisClient *redis.Client
+	instanceID  = newInstanceID()
+
+	// 按通知类型注册的处理函数，在 init 中注册
+	controlHandlers = map[string]func(){}
+
+	// 收到其他副本的广播后、投递到本地连接前调用，用于同步本地状态
+	backplaneObservers []func(room string, payload []byte)
+)
+
+// 实例标识，用于过滤自己发出的广播
+func newInstanceID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+}
+
+// 连接 Redis 并订阅广播频道，使多个副本之间的房间广播互通
+func startBackplane() error {
+	conf := currentConfig().Redis
+	if conf.Addr == "" {
+		return nil
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     conf.Addr,
+		Password: conf.Password,
+		DB:       conf.DB,
+	})
+	if err := client.Ping().Err(); err != nil {
+		return err
+	}
+
+	pubsub := client.Subscribe(backplaneChannel, controlChannel)
+	if _, err := pubsub.Receive(); err != nil {
+		return err
+	}
+	redisClient = client
+
+	go func() {
+		for msg := range pubsub.Channel() {
+			if msg.Channel == controlChannel {
+				handleControl(msg.Payload)
+				continue
+			}
+			var m backplaneMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+				log.Printf("Invalid backplane message: %v", err)
+				continue
+			}
+			// 本实例发出的消息已在本地投递
+			if m.Origin == instanceID {
+				continue
+			}
+			for _, observe := range backplaneObservers {
+				observe(m.Room, m.Payload)
+			}
+			hub.deliver(m.Room, m.Payload)
+		}
+	}()
+	return nil
+}
+
+// 将广播转发给其他副本，未启用时直接返回
+func publishBackplane(room string, payload []byte) {
+	if redisClient == nil {
+		return
+	}
+
+	data, err := json.Marshal(backplaneMessage{Origin: instanceID, Room: room, Payload: payload})
+	if err != nil {
+		log.Printf("Failed to encode backplane message: %v", err)
+		return
+	}
+	if err := redisClient.Publish(backplaneChannel, data).Err(); err != nil {
+		log.Printf("Failed to publish to backplane: %v", err)
+	}
+}
+
+// 通知其他副本重新加载本地缓存，未启用时直接返回
+func publishControl(kind string) {
+	if redisClient == nil {
+		return
+	}
+
+	data, err := json.Marshal(controlMessage{Origin: instanceID, Kind: kind})
+	if err != nil {
+		log.Printf("Failed to encode control message: %v", err)
+		return
+	}
+	if err := redisClient.Publish(controlChannel, data).Err(); err != nil {
+		log.Printf("Failed to publish %s control message: %v", kind, err)
+	}
+}
+
+func handleControl(payload string) {
+	var m controlMessage
+	if err := json.Unmarshal([]byte(payload), &m); err != nil {
+		log.Printf("Invalid control message: %v", err)
+		return
+	}
+	if m.Origin == instanceID {
+		return
+	}
+	if handler, ok := controlHandlers[m.Kind]; ok {
+		handler()
+	}
+}
@@ -0,0 +1,658 @@
+package main
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dong557799/zhibo-class/query"
+	"github.com/gin-gonic/gin"
+)
+
+// 支付渠道
+const (
+	ChannelWechat = "wechat"
+	ChannelAlipay = "alipay"
+)
+
+// 订单状态
+const (
+	OrderPending = "pending"
+	OrderPaid    = "paid"
+)
+
+const (
+	defaultCurrency     = "CNY"
+	wechatNotifyMaxSkew = 5 * time.Minute
+)
+
+var (
+	errOrderNotFound = errors.New("order not found")
+	errOrderMismatch = errors.New("order amount or channel mismatch")
+)
+
+// 支付渠道配置
+type BillingConfig struct {
+	Wechat WechatPayConfig `json:"wechat"`
+	Alipay AlipayConfig    `json:"alipay"`
+}
+
+// 微信支付 APIv3 回调验签和解密所需的配置
+type WechatPayConfig struct {
+	AppID             string `json:"app_id"`
+	MchID             string `json:"mch_id"`
+	APIv3Key          string `json:"api_v3_key"`          // 32 字节，用于解密回调报文
+	PlatformPublicKey string `json:"platform_public_key"` // 平台证书或微信支付公钥，PEM 格式
+}
+
+// 支付宝异步通知验签所需的配置
+type AlipayConfig struct {
+	AppID     string `json:"app_id"`
+	PublicKey string `json:"public_key"` // 支付宝公钥，PEM 或 Base64
+}
+
+func (c WechatPayConfig) enabled() bool {
+	return c.MchID != "" && c.APIv3Key != "" && c.PlatformPublicKey != ""
+}
+
+func (c AlipayConfig) enabled() bool {
+	return c.AppID != "" && c.PublicKey != ""
+}
+
+func paymentChannelEnabled(channel string) bool {
+	conf := currentConfig().Billing
+	switch channel {
+	case ChannelWechat:
+		return conf.Wechat.enabled()
+	case ChannelAlipay:
+		return conf.Alipay.enabled()
+	}
+	return false
+}
+
+// 课程价格，未设置或为 0 时课程免费
+type CoursePrice struct {
+	CourseID   int    `json:"course_id"`
+	PriceCents int    `json:"price_cents"`
+	Currency   string `json:"currency"`
+	Enrolled   bool   `json:"enrolled"`
+}
+
+// 购课订单，order_no 即支付渠道的商户订单号（out_trade_no）
+type Order struct {
+	ID            int        `json:"id"`
+	OrderNo       string     `json:"order_no"`
+	CourseID      int        `json:"course_id"`
+	StudentID     int        `json:"student_id"`
+	AmountCents   int        `json:"amount_cents"`
+	Currency      string     `json:"currency"`
+	Channel       string     `json:"channel"`
+	Status        string     `json:"status"`
+	TransactionID string     `json:"transaction_id,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	PaidAt        *time.Time `json:"paid_at,omitempty"`
+}
+
+const orderColumns = "id, order_no, course_id, student_id, amount_cents, currency, channel, status, COALESCE(transaction_id, ''), created_at, paid_at"
+
+var orderSortColumns = map[string]string{
+	"id":         "id",
+	"created_at": "created_at",
+	"paid_at":    "paid_at",
+}
+
+func scanOrder(row interface{ Scan(...interface{}) error }, loc *time.Location) (Order, error) {
+	var o Order
+	err := row.Scan(&o.ID, &o.OrderNo, &o.CourseID, &o.StudentID, &o.AmountCents, &o.Currency,
+		&o.Channel, &o.Status, &o.TransactionID, &o.CreatedAt, &o.PaidAt)
+	o.CreatedAt = o.CreatedAt.In(loc)
+	o.PaidAt = inLocation(o.PaidAt, loc)
+	return o, err
+}
+
+func loadCoursePrice(courseID int) (CoursePrice, error) {
+	price := CoursePrice{CourseID: courseID, Currency: defaultCurrency}
+	err := db.QueryRow("SELECT price_cents, currency FROM course_prices WHERE course_id = ?", courseID).Scan(&price.PriceCents, &price.Currency)
+	if err == sql.ErrNoRows {
+		return price, nil
+	}
+	return price, err
+}
+
+// 免费课程、学生已报名或持有该会话的试听授权时可参加；sessionID 为 0 时按课程正在直播的会话判断
+func courseAccessible(courseID, sessionID, studentID int) (bool, error) {
+	price, err := loadCoursePrice(courseID)
+	if err != nil {
+		return false, err
+	}
+	if price.PriceCents == 0 {
+		return true, nil
+	}
+	enrolled, err := courseEnrolled(courseID, studentID)
+	if err != nil || enrolled {
+		return enrolled, err
+	}
+	return sessionGranted(courseID, sessionID, studentID)
+}
+
+func courseEnrolled(courseID, studentID int) (bool, error) {
+	var one int
+	err := db.QueryRow("SELECT 1 FROM course_enrollments WHERE course_id = ? AND student_id = ?", courseID, studentID).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// 校验学生能否参加课程，不能时写入错误响应并返回 false
+func requireCourseAccess(c *gin.Context, courseID, sessionID, studentID int) bool {
+	ok, err := courseAccessible(courseID, sessionID, studentID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodePriceGetFailed)
+		return false
+	}
+	if !ok {
+		respondError(c, http.StatusPaymentRequired, CodeCoursePaymentRequired)
+		return false
+	}
+	return true
+}
+
+// 生成商户订单号：UTC 时间加随机后缀
+func newOrderNo() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return time.Now().UTC().Format("20060102150405") + hex.EncodeToString(b), nil
+}
+
+// 设置课程价格，price_cents 为 0 表示免费
+func setCoursePrice(c *gin.Context) {
+	courseID, ok := intParam(c, "course_id")
+	if !ok {
+		return
+	}
+	var req struct {
+		PriceCents *int   `json:"price_cents" binding:"required,min=0"`
+		Currency   string `json:"currency" binding:"omitempty,len=3"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if req.Currency == "" {
+		req.Currency = defaultCurrency
+	}
+	req.Currency = strings.ToUpper(req.Currency)
+
+	_, err := db.Exec(dialect.upsert(
+		"INSERT INTO course_prices (course_id, price_cents, currency, updated_by, updated_at) VALUES (?, ?, ?, ?, ?)",
+		[]string{"course_id"},
+		"price_cents = EXCLUDED.price_cents", "currency = EXCLUDED.currency",
+		"updated_by = EXCLUDED.updated_by", "updated_at = EXCLUDED.updated_at",
+	), courseID, *req.PriceCents, req.Currency, currentUser(c).ID, time.Now().UTC())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodePriceUpdateFailed)
+		return
+	}
+
+	recordAudit(c, "set_course_price", "course", courseID, gin.H{"price_cents": *req.PriceCents, "currency": req.Currency})
+	respondOK(c, http.StatusOK, CoursePrice{CourseID: courseID, PriceCents: *req.PriceCents, Currency: req.Currency})
+}
+
+// 获取课程价格及当前用户是否已报名
+func getCoursePrice(c *gin.Context) {
+	courseID, ok := intParam(c, "course_id")
+	if !ok {
+		return
+	}
+	price, err := loadCoursePrice(courseID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodePriceGetFailed)
+		return
+	}
+	if price.Enrolled, err = courseEnrolled(courseID, currentUser(c).ID); err != nil {
+		respondError(c, http.StatusInternalServerError, CodePriceGetFailed)
+		return
+	}
+	respondOK(c, http.StatusOK, price)
+}
+
+// 学生下单购买课程；同一渠道已有待支付订单时直接返回该订单
+func createOrder(c *gin.Context) {
+	var req struct {
+		CourseID int    `json:"course_id" binding:"required"`
+		Channel  string `json:"channel" binding:"required,oneof=wechat alipay"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if !paymentChannelEnabled(req.Channel) {
+		respondError(c, http.StatusBadRequest, CodePaymentChannelUnavailable, req.Channel)
+		return
+	}
+	user := currentUser(c)
+	loc := requestLocation(c)
+
+	price, err := loadCoursePrice(req.CourseID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeOrderCreateFailed)
+		return
+	}
+	if price.PriceCents == 0 {
+		respondError(c, http.StatusBadRequest, CodeCourseFree)
+		return
+	}
+	enrolled, err := courseEnrolled(req.CourseID, user.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeOrderCreateFailed)
+		return
+	}
+	if enrolled {
+		respondError(c, http.StatusConflict, CodeAlreadyEnrolled)
+		return
+	}
+
+	order, err := scanOrder(db.QueryRow(`
+		SELECT `+orderColumns+` FROM orders
+		WHERE student_id = ? AND course_id = ? AND channel = ? AND status = ? AND amount_cents = ? AND currency = ?
+		ORDER BY id DESC LIMIT 1
+	`, user.ID, req.CourseID, req.Channel, OrderPending, price.PriceCents, price.Currency), loc)
+	if err == nil {
+		respondOK(c, http.StatusOK, order)
+		return
+	}
+	if err != sql.ErrNoRows {
+		respondError(c, http.StatusInternalServerError, CodeOrderCreateFailed)
+		return
+	}
+
+	orderNo, err := newOrderNo()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeOrderCreateFailed)
+		return
+	}
+	order = Order{
+		OrderNo:     orderNo,
+		CourseID:    req.CourseID,
+		StudentID:   user.ID,
+		AmountCents: price.PriceCents,
+		Currency:    price.Currency,
+		Channel:     req.Channel,
+		Status:      OrderPending,
+		CreatedAt:   time.Now().UTC().Truncate(time.Second),
+	}
+	id, err := dialect.insertID(db, `
+		INSERT INTO orders (order_no, course_id, student_id, amount_cents, currency, channel, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, order.OrderNo, order.CourseID, order.StudentID, order.AmountCents, order.Currency, order.Channel, order.Status, order.CreatedAt)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeOrderCreateFailed)
+		return
+	}
+	order.ID = int(id)
+	order.CreatedAt = order.CreatedAt.In(loc)
+	respondOK(c, http.StatusCreated, order)
+}
+
+// 查询订单，学生只能查看自己的订单
+func getOrder(c *gin.Context) {
+	user := currentUser(c)
+	order, err := scanOrder(db.QueryRow("SELECT "+orderColumns+" FROM orders WHERE order_no = ?", c.Param("order_no")), requestLocation(c))
+	if err == sql.ErrNoRows || (err == nil && order.StudentID != user.ID && user.Role != RoleAdmin) {
+		respondError(c, http.StatusNotFound, CodeOrderNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeOrderGetFailed)
+		return
+	}
+	respondOK(c, http.StatusOK, order)
+}
+
+// 当前用户的订单
+func listMyOrders(c *gin.Context) {
+	rows, err := db.Query("SELECT "+orderColumns+" FROM orders WHERE student_id = ? ORDER BY id DESC", currentUser(c).ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeOrderGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	orders := []Order{}
+	for rows.Next() {
+		order, err := scanOrder(rows, loc)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeOrderGetFailed)
+			return
+		}
+		orders = append(orders, order)
+	}
+	respondOK(c, http.StatusOK, orders)
+}
+
+// 管理后台订单列表
+func adminListOrders(c *gin.Context) {
+	page, pageSize, offset := pageParams(c)
+
+	q := query.New().
+		Eq("status", c.Query("status")).
+		Eq("channel", c.Query("channel")).
+		Eq("course_id", c.Query("course_id")).
+		Eq("student_id", c.Query("student_id")).
+		Sort(c.Query("sort"), orderSortColumns, "id DESC")
+
+	var total int64
+	if err := db.QueryRow("SELECT COUNT(*) FROM orders WHERE "+q.WhereSQL(), q.Args()...).Scan(&total); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeOrderGetFailed)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT `+orderColumns+`
+		FROM orders
+		WHERE `+q.WhereSQL()+`
+		ORDER BY `+q.OrderSQL()+`
+		LIMIT ? OFFSET ?
+	`, q.Args(pageSize, offset)...)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeOrderGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	orders := []Order{}
+	for rows.Next() {
+		order, err := scanOrder(rows, loc)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeOrderGetFailed)
+			return
+		}
+		orders = append(orders, order)
+	}
+
+	respondPage(c, orders, Pagination{Page: page, PageSize: pageSize, Total: total, HasMore: int64(offset+len(orders)) < total})
+}
+
+// 支付成功后更新订单并为学生报名。重复通知直接返回成功
+func markOrderPaid(orderNo, channel, transactionID string, amountCents int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var order Order
+	err = tx.QueryRow(`
+		SELECT id, course_id, student_id, amount_cents, channel, status
+		FROM orders WHERE order_no = ? FOR UPDATE
+	`, orderNo).Scan(&order.ID, &order.CourseID, &order.StudentID, &order.AmountCents, &order.Channel, &order.Status)
+	if err == sql.ErrNoRows {
+		return errOrderNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if order.Status == OrderPaid {
+		return nil
+	}
+	if order.AmountCents != amountCents || order.Channel != channel {
+		return errOrderMismatch
+	}
+
+	now := time.Now().UTC()
+	if _, err := tx.Exec("UPDATE orders SET status = ?, transaction_id = ?, paid_at = ? WHERE id = ?",
+		OrderPaid, transactionID, now, order.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(dialect.insertIgnore("INSERT INTO course_enrollments (course_id, student_id, order_id, created_at) VALUES (?, ?, ?, ?)"),
+		order.CourseID, order.StudentID, order.ID, now); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("Order %s paid via %s, student %d enrolled in course %d", orderNo, channel, order.StudentID, order.CourseID)
+	emitEvent(EventOrderPaid, order.CourseID, gin.H{
+		"order_no":     orderNo,
+		"student_id":   order.StudentID,
+		"amount_cents": amountCents,
+		"channel":      channel,
+	})
+	return nil
+}
+
+// 解析 PEM（公钥或证书）或 Base64 编码的 RSA 公钥
+func parseRSAPublicKey(s string) (*rsa.PublicKey, error) {
+	var pub interface{}
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		if block.Type == "CERTIFICATE" {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			pub = cert.PublicKey
+		} else {
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			pub = key
+		}
+	} else {
+		der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		if pub, err = x509.ParsePKIXPublicKey(der); err != nil {
+			return nil, err
+		}
+	}
+	key, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an RSA public key")
+	}
+	return key, nil
+}
+
+// SHA256WithRSA 验签，签名为 Base64 编码
+func verifyRSASHA256(publicKey, message, signature string) error {
+	key, err := parseRSAPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(message))
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig)
+}
+
+// 微信支付回调通知
+func wechatPayNotify(c *gin.Context) {
+	conf := currentConfig().Billing.Wechat
+	fail := func(status int, message string) {
+		c.JSON(status, gin.H{"code": "FAIL", "message": message})
+	}
+	if !conf.enabled() {
+		fail(http.StatusNotFound, "channel disabled")
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		fail(http.StatusBadRequest, "read body failed")
+		return
+	}
+	timestamp := c.GetHeader("Wechatpay-Timestamp")
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(ts, 0)).Abs() > wechatNotifyMaxSkew {
+		fail(http.StatusUnauthorized, "invalid timestamp")
+		return
+	}
+	message := timestamp + "\n" + c.GetHeader("Wechatpay-Nonce") + "\n" + string(body) + "\n"
+	if err := verifyRSASHA256(conf.PlatformPublicKey, message, c.GetHeader("Wechatpay-Signature")); err != nil {
+		log.Printf("Wechat Pay notify signature check failed: %v", err)
+		fail(http.StatusUnauthorized, "invalid signature")
+		return
+	}
+
+	var notify struct {
+		EventType string `json:"event_type"`
+		Resource  struct {
+			Algorithm      string `json:"algorithm"`
+			Ciphertext     string `json:"ciphertext"`
+			AssociatedData string `json:"associated_data"`
+			Nonce          string `json:"nonce"`
+		} `json:"resource"`
+	}
+	if err := json.Unmarshal(body, &notify); err != nil || notify.Resource.Algorithm != "AEAD_AES_256_GCM" {
+		fail(http.StatusBadRequest, "invalid notification")
+		return
+	}
+	plaintext, err := decryptWechatResource(conf.APIv3Key, notify.Resource.Ciphertext, notify.Resource.Nonce, notify.Resource.AssociatedData)
+	if err != nil {
+		log.Printf("Wechat Pay notify decrypt failed: %v", err)
+		fail(http.StatusBadRequest, "decrypt failed")
+		return
+	}
+
+	var txn struct {
+		AppID         string `json:"appid"`
+		MchID         string `json:"mchid"`
+		OutTradeNo    string `json:"out_trade_no"`
+		TransactionID string `json:"transaction_id"`
+		TradeState    string `json:"trade_state"`
+		Amount        struct {
+			Total int `json:"total"`
+		} `json:"amount"`
+	}
+	if err := json.Unmarshal(plaintext, &txn); err != nil {
+		fail(http.StatusBadRequest, "invalid transaction")
+		return
+	}
+	if txn.MchID != conf.MchID || (conf.AppID != "" && txn.AppID != conf.AppID) {
+		fail(http.StatusBadRequest, "merchant mismatch")
+		return
+	}
+	// 非支付成功的通知只需确认收到
+	if notify.EventType == "TRANSACTION.SUCCESS" && txn.TradeState == "SUCCESS" {
+		if err := markOrderPaid(txn.OutTradeNo, ChannelWechat, txn.TransactionID, txn.Amount.Total); err != nil {
+			log.Printf("Failed to confirm Wechat Pay order %s: %v", txn.OutTradeNo, err)
+			fail(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"code": "SUCCESS", "message": "成功"})
+}
+
+// AEAD_AES_256_GCM 解密回调报文中的 resource
+func decryptWechatResource(key, ciphertext, nonce, associatedData string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(nonce))
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, []byte(nonce), data, []byte(associatedData))
+}
+
+// 支付宝异步通知，处理成功返回 success，否则支付宝会重试
+func alipayNotify(c *gin.Context) {
+	conf := currentConfig().Billing.Alipay
+	if !conf.enabled() {
+		c.String(http.StatusNotFound, "failure")
+		return
+	}
+	if err := c.Request.ParseForm(); err != nil {
+		c.String(http.StatusBadRequest, "failure")
+		return
+	}
+	form := c.Request.PostForm
+	if form.Get("sign_type") != "RSA2" || form.Get("app_id") != conf.AppID {
+		c.String(http.StatusBadRequest, "failure")
+		return
+	}
+	if err := verifyRSASHA256(conf.PublicKey, alipaySignContent(form), form.Get("sign")); err != nil {
+		log.Printf("Alipay notify signature check failed: %v", err)
+		c.String(http.StatusUnauthorized, "failure")
+		return
+	}
+
+	status := form.Get("trade_status")
+	if status == "TRADE_SUCCESS" || status == "TRADE_FINISHED" {
+		amount, err := parseYuan(form.Get("total_amount"))
+		if err != nil {
+			c.String(http.StatusBadRequest, "failure")
+			return
+		}
+		if err := markOrderPaid(form.Get("out_trade_no"), ChannelAlipay, form.Get("trade_no"), amount); err != nil {
+			log.Printf("Failed to confirm Alipay order %s: %v", form.Get("out_trade_no"), err)
+			c.String(http.StatusInternalServerError, "failure")
+			return
+		}
+	}
+	c.String(http.StatusOK, "success")
+}
+
+// 待签名字符串：除 sign、sign_type 和空值外的参数按键名排序后以 & 拼接
+func alipaySignContent(form url.Values) string {
+	keys := make([]string, 0, len(form))
+	for k := range form {
+		if k != "sign" && k != "sign_type" && form.Get(k) != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + form.Get(k)
+	}
+	return strings.Join(parts, "&")
+}
+
+// 以元为单位的金额，最多两位小数，不接受正负号和指数形式
+var yuanPattern = regexp.MustCompile(`^[0-9]{1,10}(\.[0-9]{1,2})?$`)
+
+// 将以元为单位的金额（如 "12.50"）转换为分；超过两位小数时不做舍入，直接视为无效
+func parseYuan(s string) (int, error) {
+	if !yuanPattern.MatchString(s) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	yuan, fen, _ := strings.Cut(s, ".")
+	y, _ := strconv.Atoi(yuan)
+	f, _ := strconv.Atoi((fen + "00")[:2])
+	return y*100 + f, nil
+}
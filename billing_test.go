@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/url"
+	"testing"
+)
+
+func TestParseYuan(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{in: "12.50", want: 1250},
+		{in: "12.5", want: 1250},
+		{in: "12.05", want: 1205},
+		{in: "0.01", want: 1},
+		{in: "0.10", want: 10},
+		{in: "12", want: 1200},
+		{in: "0", want: 0},
+		{in: "0099.90", want: 9990},
+		{in: "9999999999.99", want: 999999999999},
+		// 不做舍入
+		{in: "12.345", wantErr: true},
+		{in: "0.005", wantErr: true},
+		{in: "", wantErr: true},
+		{in: ".50", wantErr: true},
+		{in: "12.", wantErr: true},
+		{in: "-1.00", wantErr: true},
+		{in: "-0.50", wantErr: true},
+		{in: "+1.00", wantErr: true},
+		{in: "1.+5", wantErr: true},
+		{in: "1.-5", wantErr: true},
+		{in: "1e3", wantErr: true},
+		{in: " 1.00", wantErr: true},
+		{in: "1,000.00", wantErr: true},
+		{in: "1.00.00", wantErr: true},
+		{in: "12345678901.00", wantErr: true},
+		{in: "１２.00", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseYuan(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseYuan(%q) = %d, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseYuan(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+// 支付宝异步通知的待签名字符串：去掉 sign、sign_type 和空值，按键名排序，取值不做 URL 编码
+func TestAlipaySignContent(t *testing.T) {
+	form := url.Values{
+		"trade_status":   {"TRADE_SUCCESS"},
+		"total_amount":   {"12.50"},
+		"app_id":         {"2021000000000000"},
+		"out_trade_no":   {"ZB20260301000001"},
+		"subject":        {"物理 直播课&答疑"},
+		"gmt_payment":    {"2026-03-01 10:00:00"},
+		"trade_no":       {"2026030122001400000000000001"},
+		"buyer_logon_id": {""},
+		"sign":           {"c2lnbmF0dXJl"},
+		"sign_type":      {"RSA2"},
+		"notify_id":      {"ac05099524730693a8b330c5ecf72da9786"},
+	}
+	want := "app_id=2021000000000000&gmt_payment=2026-03-01 10:00:00&notify_id=ac05099524730693a8b330c5ecf72da9786" +
+		"&out_trade_no=ZB20260301000001&subject=物理 直播课&答疑&total_amount=12.50&trade_no=2026030122001400000000000001" +
+		"&trade_status=TRADE_SUCCESS"
+	if got := alipaySignContent(form); got != want {
+		t.Fatalf("alipaySignContent =\n%s\nwant\n%s", got, want)
+	}
+	if got := alipaySignContent(url.Values{"sign": {"x"}, "sign_type": {"RSA2"}}); got != "" {
+		t.Fatalf("alipaySignContent without parameters = %q, want empty", got)
+	}
+}
+
+func TestVerifyRSASHA256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	base64Key := base64.StdEncoding.EncodeToString(der)
+
+	content := alipaySignContent(url.Values{"out_trade_no": {"ZB1"}, "total_amount": {"12.50"}, "sign_type": {"RSA2"}})
+	digest := sha256.Sum256([]byte(content))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := base64.StdEncoding.EncodeToString(sig)
+
+	tests := []struct {
+		name, key, message, signature string
+		wantErr                       bool
+	}{
+		{"pem key", pemKey, content, signature, false},
+		{"base64 key", base64Key, content, signature, false},
+		{"tampered amount", pemKey, "out_trade_no=ZB1&total_amount=0.01", signature, true},
+		{"signature not base64", pemKey, content, "!!", true},
+		{"invalid key", "not a key", content, signature, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyRSASHA256(tt.key, tt.message, tt.signature)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifyRSASHA256 error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxBreakoutGroups = 50
+
+// 分组讨论
+type BreakoutGroup struct {
+	ID        int               `json:"id"`
+	SessionID int               `json:"session_id"`
+	Name      string            `json:"name"`
+	StreamKey string            `json:"stream_key,omitempty"`
+	Status    string            `json:"status"`
+	Members   []int             `json:"members"`
+	PlayURLs  map[string]string `json:"play_urls,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// 创建分组
+func createBreakoutGroups(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Count     int      `json:"count"`
+		Names     []string `json:"names"`
+		SubStream bool     `json:"sub_stream"` // 是否为每个分组创建子流
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	names := req.Names
+	if len(names) == 0 {
+		for i := 1; i <= req.Count; i++ {
+			names = append(names, fmt.Sprintf("第%d组", i))
+		}
+	}
+	if len(names) < 1 || len(names) > maxBreakoutGroups {
+		respondError(c, http.StatusBadRequest, CodeBreakoutCountInvalid, maxBreakoutGroups)
+		return
+	}
+
+	if !requireLiveSession(c, sessionID) {
+		return
+	}
+
+	var openCount int
+	if err := db.QueryRow(`
+		SELECT COUNT(*) FROM breakout_groups WHERE session_id = ? AND status = 'open'
+	`, sessionID).Scan(&openCount); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeBreakoutGetFailed)
+		return
+	}
+	if openCount > 0 {
+		respondError(c, http.StatusConflict, CodeBreakoutAlreadyOpen)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeBreakoutCreateFailed)
+		return
+	}
+	defer tx.Rollback()
+
+	groups := make([]BreakoutGroup, 0, len(names))
+	var streamKeys []string
+	for _, name := range names {
+		group := BreakoutGroup{
+			SessionID: sessionID,
+			Name:      name,
+			Status:    "open",
+			Members:   []int{},
+			CreatedAt: time.Now(),
+		}
+		if req.SubStream {
+			group.StreamKey = generateStreamKey()
+		}
+
+		id, err := dialect.insertID(tx, `
+			INSERT INTO breakout_groups (session_id, name, stream_key, status, created_at)
+			VALUES (?, ?, NULLIF(?, ''), 'open', NOW())
+		`, sessionID, name, group.StreamKey)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeBreakoutCreateFailed)
+			return
+		}
+		group.ID = int(id)
+
+		if group.StreamKey != "" {
+			if err := media().CreateStream(c.Request.Context(), group.StreamKey); err != nil {
+				for _, key := range streamKeys {
+					media().DeleteStream(context.Background(), key)
+				}
+				respondError(c, http.StatusInternalServerError, CodeStreamCreateFailed)
+				return
+			}
+			streamKeys = append(streamKeys, group.StreamKey)
+			group.PlayURLs = getPlayURLs(group.StreamKey, playRegion(c))
+		}
+		groups = append(groups, group)
+	}
+
+	if err := tx.Commit(); err != nil {
+		for _, key := range streamKeys {
+			media().DeleteStream(context.Background(), key)
+		}
+		respondError(c, http.StatusInternalServerError, CodeBreakoutCreateFailed)
+		return
+	}
+
+	hub.broadcast(sessionRoom(sessionID), Message{Type: "breakout_created", Data: groups})
+	respondOK(c, http.StatusCreated, groups)
+}
+
+// 获取当前开放的分组
+func listBreakoutGroups(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+
+	groups, err := loadOpenBreakoutGroups(sessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeBreakoutGetFailed)
+		return
+	}
+
+	respondOK(c, http.StatusOK, groups)
+}
+
+// 分配学生到分组，支持手动和随机两种方式
+func assignBreakoutStudents(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Mode        string `json:"mode" binding:"required,oneof=manual random"`
+		Assignments []struct {
+			GroupID    int   `json:"group_id"`
+			StudentIDs []int `json:"student_ids"`
+		} `json:"assignments"`
+		StudentIDs []int `json:"student_ids"` // 随机分配的学生，为空时使用当前在线学生
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	groups, err := loadOpenBreakoutGroups(sessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeBreakoutGetFailed)
+		return
+	}
+	if len(groups) == 0 {
+		respondError(c, http.StatusBadRequest, CodeBreakoutNoneOpen)
+		return
+	}
+
+	// 学生ID -> 分组ID
+	assignment := make(map[int]int)
+	if req.Mode == "manual" {
+		valid := make(map[int]bool, len(groups))
+		for _, g := range groups {
+			valid[g.ID] = true
+		}
+		for _, a := range req.Assignments {
+			if !valid[a.GroupID] {
+				respondError(c, http.StatusBadRequest, CodeBreakoutGroupNotFound, a.GroupID)
+				return
+			}
+			for _, studentID := range a.StudentIDs {
+				assignment[studentID] = a.GroupID
+			}
+		}
+	} else {
+		students := req.StudentIDs
+		if len(students) == 0 {
+			online, err := onlineUserIDs(sessionID, RoleStudent)
+			if err != nil {
+				respondError(c, http.StatusInternalServerError, CodePresenceGetFailed)
+				return
+			}
+			students = online
+		}
+		rand.Shuffle(len(students), func(i, j int) {
+			students[i], students[j] = students[j], students[i]
+		})
+		for i, studentID := range students {
+			assignment[studentID] = groups[i%len(groups)].ID
+		}
+	}
+	if len(assignment) == 0 {
+		respondError(c, http.StatusBadRequest, CodeBreakoutNoStudents)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeBreakoutAssignFailed)
+		return
+	}
+	defer tx.Rollback()
+
+	for studentID, groupID := range assignment {
+		// 一个学生同一时间只属于一个分组
+		if _, err := tx.Exec(`
+			DELETE FROM breakout_members
+			WHERE group_id IN (SELECT id FROM breakout_groups WHERE session_id = ? AND status = 'open')
+				AND student_id = ?
+		`, sessionID, studentID); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeBreakoutAssignFailed)
+			return
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO breakout_members (group_id, student_id) VALUES (?, ?)
+		`, groupID, studentID); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeBreakoutAssignFailed)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeBreakoutAssignFailed)
+		return
+	}
+
+	// 将在线学生切换到分组聊天室
+	for studentID, groupID := range assignment {
+		hub.moveChat(sessionID, studentID, breakoutRoom(groupID))
+	}
+
+	groups, err = loadOpenBreakoutGroups(sessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeBreakoutGetFailed)
+		return
+	}
+	for _, g := range groups {
+		hub.broadcast(breakoutRoom(g.ID), Message{Type: "breakout_assigned", Data: g})
+	}
+
+	respondOK(c, http.StatusOK, groups)
+}
+
+// 向所有分组广播消息
+func broadcastToBreakouts(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Content string `json:"content" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	groups, err := loadOpenBreakoutGroups(sessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeBreakoutGetFailed)
+		return
+	}
+	if len(groups) == 0 {
+		respondError(c, http.StatusBadRequest, CodeBreakoutNoneOpen)
+		return
+	}
+
+	for _, g := range groups {
+		hub.broadcast(breakoutRoom(g.ID), Message{
+			Type: "breakout_broadcast",
+			Data: gin.H{"content": req.Content},
+		})
+	}
+
+	respondOK(c, http.StatusOK, gin.H{"message": "Broadcast sent", "group_count": len(groups)})
+}
+
+// 关闭所有分组，学生回到主房间
+func closeBreakoutGroups(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+
+	closed, err := closeSessionBreakouts(sessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeBreakoutCloseFailed)
+		return
+	}
+
+	respondOK(c, http.StatusOK, gin.H{"message": "Breakout groups closed", "group_count": closed})
+}
+
+// 关闭会话的所有分组并返回关闭的数量
+func closeSessionBreakouts(sessionID int) (int, error) {
+	groups, err := loadOpenBreakoutGroups(sessionID)
+	if err != nil {
+		return 0, err
+	}
+	if len(groups) == 0 {
+		return 0, nil
+	}
+
+	_, err = db.Exec(`
+		UPDATE breakout_groups
+		SET status = 'closed', closed_at = NOW()
+		WHERE session_id = ? AND status = 'open'
+	`, sessionID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, g := range groups {
+		for _, studentID := range g.Members {
+			hub.moveChat(sessionID, studentID, sessionRoom(sessionID))
+		}
+		if g.StreamKey != "" {
+			if err := media().DeleteStream(context.Background(), g.StreamKey); err != nil {
+				log.Printf("Failed to delete breakout stream %s: %v", g.StreamKey, err)
+			}
+		}
+	}
+
+	hub.broadcast(sessionRoom(sessionID), Message{Type: "breakout_closed"})
+	return len(groups), nil
+}
+
+// 加载会话中开放的分组及成员
+func loadOpenBreakoutGroups(sessionID int) ([]BreakoutGroup, error) {
+	rows, err := db.Query(`
+		SELECT id, session_id, name, stream_key, status, created_at
+		FROM breakout_groups
+		WHERE session_id = ? AND status = 'open'
+		ORDER BY id
+	`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []BreakoutGroup{}
+	index := make(map[int]int)
+	for rows.Next() {
+		var g BreakoutGroup
+		var streamKey sql.NullString
+		if err := rows.Scan(&g.ID, &g.SessionID, &g.Name, &streamKey, &g.Status, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		g.StreamKey = streamKey.String
+		g.Members = []int{}
+		if g.StreamKey != "" {
+			g.PlayURLs = getPlayURLs(g.StreamKey, "")
+		}
+		index[g.ID] = len(groups)
+		groups = append(groups, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	memberRows, err := db.Query(`
+		SELECT m.group_id, m.student_id
+		FROM breakout_members m
+		JOIN breakout_groups g ON g.id = m.group_id
+		WHERE g.session_id = ? AND g.status = 'open'
+		ORDER BY m.student_id
+	`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer memberRows.Close()
+
+	for memberRows.Next() {
+		var groupID, studentID int
+		if err := memberRows.Scan(&groupID, &studentID); err != nil {
+			return nil, err
+		}
+		if i, ok := index[groupID]; ok {
+			groups[i].Members = append(groups[i].Members, studentID)
+		}
+	}
+	return groups, memberRows.Err()
+}
+
+// 学生当前所在的分组
+func currentBreakoutGroup(sessionID, studentID int) (int, bool) {
+	var groupID int
+	err := db.QueryRow(`
+		SELECT g.id
+		FROM breakout_members m
+		JOIN breakout_groups g ON g.id = m.group_id
+		WHERE g.session_id = ? AND g.status = 'open' AND m.student_id = ?
+	`, sessionID, studentID).Scan(&groupID)
+	if err != nil {
+		return 0, false
+	}
+	return groupID, true
+}
+
+// 检查会话是否正在直播，否则写入错误响应
+func requireLiveSession(c *gin.Context, sessionID int) bool {
+	var status string
+	err := db.QueryRow("SELECT status FROM live_sessions WHERE id = ?", sessionID).Scan(&status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, CodeSessionNotFound)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		}
+		return false
+	}
+	if status != "live" {
+		respondError(c, http.StatusBadRequest, CodeSessionNotLive)
+		return false
+	}
+	return true
+}
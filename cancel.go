@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 退款处理需要的已支付订单
+type cancelledOrder struct {
+	OrderNo     string `json:"order_no"`
+	StudentID   int    `json:"student_id"`
+	AmountCents int    `json:"amount_cents"`
+	Currency    string `json:"currency"`
+}
+
+func paidCourseOrders(courseID int) ([]cancelledOrder, error) {
+	rows, err := db.Query(`
+		SELECT order_no, student_id, amount_cents, currency
+		FROM orders
+		WHERE course_id = ? AND status = 'paid'
+		ORDER BY id
+	`, courseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	orders := []cancelledOrder{}
+	for rows.Next() {
+		var o cancelledOrder
+		if err := rows.Scan(&o.OrderNo, &o.StudentID, &o.AmountCents, &o.Currency); err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+	return orders, rows.Err()
+}
+
+// 会话取消后删除推流、通知在线客户端和报名学生，并发出事件供计费模块按订单处理退款。返回通知的学生数
+func onSessionCancelled(s LiveSession, reason string, makeupAt *time.Time) int {
+	if err := media().DeleteStream(context.Background(), s.StreamKey); err != nil {
+		log.Printf("Failed to delete stream of session %d: %v", s.ID, err)
+	}
+	notifySessionStatus(s.ID, "cancelled")
+
+	data := gin.H{
+		"session_id":   s.ID,
+		"course_id":    s.CourseID,
+		"scheduled_at": s.ScheduledAt,
+		"reason":       reason,
+	}
+	if makeupAt != nil {
+		data["makeup_proposed_at"] = makeupAt
+	}
+	students, err := enrolledStudents(s.CourseID)
+	if err != nil {
+		log.Printf("Failed to load students of course %d: %v", s.CourseID, err)
+	}
+	if err := notifyUsers(students, NotifySessionCancelled, data); err != nil {
+		log.Printf("Failed to notify students of cancelled session %d: %v", s.ID, err)
+	}
+
+	orders, err := paidCourseOrders(s.CourseID)
+	if err != nil {
+		log.Printf("Failed to load orders of course %d: %v", s.CourseID, err)
+	}
+	event := gin.H{"orders": orders}
+	for k, v := range data {
+		event[k] = v
+	}
+	emitEvent(EventSessionCancelled, s.CourseID, event)
+	return len(students)
+}
+
+// 取消尚未开始的会话，与结束直播不同，取消的会话不会再开始。可同时提议补课时间，提议的时间与老师其他课次冲突时在响应中列出
+func cancelLiveSession(c *gin.Context) {
+	id, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Reason        string     `json:"reason" binding:"max=255"`
+		MakeupAt      *time.Time `json:"makeup_at"`
+		MakeupMinutes int        `json:"makeup_minutes" binding:"omitempty,min=5,max=720"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	now := time.Now().UTC()
+	if req.MakeupAt != nil {
+		if !req.MakeupAt.After(now) {
+			respondError(c, http.StatusBadRequest, CodeInvalidParam, "makeup_at")
+			return
+		}
+		t := req.MakeupAt.UTC()
+		req.MakeupAt = &t
+	}
+	unlock, ok := lockSession(c, id)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	var s LiveSession
+	err := db.QueryRow(`
+		SELECT id, course_id, teacher_id, stream_key, status, scheduled_at, scheduled_minutes
+		FROM live_sessions
+		WHERE id = ?
+	`, id).Scan(&s.ID, &s.CourseID, &s.TeacherID, &s.StreamKey, &s.Status, &s.ScheduledAt, &s.ScheduledMinutes)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSessionNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+	if s.Status != "pending" {
+		respondError(c, http.StatusConflict, CodeSessionNotCancellable)
+		return
+	}
+
+	conflicts := []ScheduleConflict{}
+	if req.MakeupAt != nil {
+		minutes := req.MakeupMinutes
+		if minutes == 0 {
+			minutes = s.ScheduledMinutes
+		}
+		if minutes <= 0 {
+			minutes = defaultSessionMinutes
+		}
+		slot := ScheduleSlot{CourseID: s.CourseID, StartsAt: *req.MakeupAt, EndsAt: req.MakeupAt.Add(time.Duration(minutes) * time.Minute)}
+		if conflicts, err = findScheduleConflicts(s.TeacherID, []ScheduleSlot{slot}); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+			return
+		}
+	}
+
+	reason := strings.TrimSpace(req.Reason)
+	res, err := db.Exec(`
+		UPDATE live_sessions SET status = 'cancelled', cancelled_at = ?, cancel_reason = ?, makeup_proposed_at = ?
+		WHERE id = ? AND status = 'pending'
+	`, now, reason, req.MakeupAt, id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionCancelFailed)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusConflict, CodeSessionNotCancellable)
+		return
+	}
+
+	notified := onSessionCancelled(s, reason, req.MakeupAt)
+	recordAudit(c, "cancel_session", "session", id, gin.H{"reason": reason, "makeup_at": req.MakeupAt})
+
+	loc := requestLocation(c)
+	for i := range conflicts {
+		conflicts[i].inLocation(loc)
+	}
+	respondOK(c, http.StatusOK, gin.H{
+		"session_id":         id,
+		"status":             "cancelled",
+		"reason":             reason,
+		"makeup_proposed_at": inLocation(req.MakeupAt, loc),
+		"notified":           notified,
+		"conflicts":          conflicts,
+	})
+}
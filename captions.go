@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxCaptionSegments = 200
+	defaultCaptionLang = "zh"
+)
+
+// 字幕片段，时间为相对开播的毫秒数
+type CaptionSegment struct {
+	ID      int64  `json:"id,omitempty"`
+	Lang    string `json:"lang"`
+	StartMs int    `json:"start_ms" binding:"min=0"`
+	EndMs   int    `json:"end_ms" binding:"min=0"`
+	Text    string `json:"text" binding:"required,max=2000"`
+}
+
+// 字幕客户端或语音识别服务提交字幕片段，实时推送到课堂
+func postSessionCaptions(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Segments []CaptionSegment `json:"segments" binding:"required,min=1,dive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if len(req.Segments) > maxCaptionSegments {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "segments")
+		return
+	}
+	for i, seg := range req.Segments {
+		if seg.EndMs <= seg.StartMs {
+			respondError(c, http.StatusBadRequest, CodeCaptionInvalid, i)
+			return
+		}
+	}
+
+	if !sessionExists(c, sessionID) {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeCaptionSaveFailed)
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for i := range req.Segments {
+		seg := &req.Segments[i]
+		if seg.Lang == "" {
+			seg.Lang = defaultCaptionLang
+		}
+		seg.ID, err = dialect.insertID(tx, `
+			INSERT INTO session_captions (session_id, lang, start_ms, end_ms, text, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, sessionID, seg.Lang, seg.StartMs, seg.EndMs, seg.Text, now)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeCaptionSaveFailed)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeCaptionSaveFailed)
+		return
+	}
+
+	for _, seg := range req.Segments {
+		hub.broadcast(sessionRoom(sessionID), Message{Type: "caption", Data: seg})
+	}
+
+	respondOK(c, http.StatusCreated, req.Segments)
+}
+
+// 会话字幕，format=vtt 时返回 WebVTT 文件用于回放
+func getSessionCaptions(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	lang := c.DefaultQuery("lang", defaultCaptionLang)
+
+	segments, err := loadCaptions(sessionID, lang)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeCaptionGetFailed)
+		return
+	}
+
+	if c.Query("format") == "vtt" {
+		c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="session-%d.%s.vtt"`, sessionID, lang))
+		c.Header("Content-Type", "text/vtt; charset=utf-8")
+		c.Status(http.StatusOK)
+		writeWebVTT(c.Writer, segments)
+		return
+	}
+
+	respondOK(c, http.StatusOK, segments)
+}
+
+func loadCaptions(sessionID int, lang string) ([]CaptionSegment, error) {
+	rows, err := db.Query(`
+		SELECT id, lang, start_ms, end_ms, text
+		FROM session_captions
+		WHERE session_id = ? AND lang = ?
+		ORDER BY start_ms, id
+	`, sessionID, lang)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	segments := []CaptionSegment{}
+	for rows.Next() {
+		var seg CaptionSegment
+		if err := rows.Scan(&seg.ID, &seg.Lang, &seg.StartMs, &seg.EndMs, &seg.Text); err != nil {
+			return nil, err
+		}
+		segments = append(segments, seg)
+	}
+	return segments, rows.Err()
+}
+
+// 按 WebVTT 格式输出字幕
+func writeWebVTT(w io.Writer, segments []CaptionSegment) error {
+	if _, err := io.WriteString(w, "WEBVTT\n\n"); err != nil {
+		return err
+	}
+	for i, seg := range segments {
+		// 字幕文本中不能出现空行或 "-->"
+		text := strings.ReplaceAll(seg.Text, "-->", "->")
+		text = strings.Join(strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == '\r' }), "\n")
+		if _, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1, vttTimestamp(seg.StartMs), vttTimestamp(seg.EndMs), text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func vttTimestamp(ms int) string {
+	d := time.Duration(ms) * time.Millisecond
+	h := int(d / time.Hour)
+	m := int(d % time.Hour / time.Minute)
+	s := int(d % time.Minute / time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", h, m, s, ms%1000)
+}
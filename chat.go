@@ -0,0 +1,219 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 聊天记录
+type ChatMessage struct {
+	ID        int64           `json:"id"`
+	SessionID int             `json:"session_id"`
+	Room      string          `json:"room"`
+	UserID    int             `json:"user_id"`
+	Content   json.RawMessage `json:"content"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// 导出记录的类型
+const (
+	transcriptChat     = "chat"
+	transcriptQuestion = "question"
+	transcriptAnswer   = "answer"
+)
+
+// 聊天和问答导出中的一条记录
+type transcriptEntry struct {
+	Time   time.Time
+	Kind   string
+	Room   string
+	UserID int
+	Name   string
+	Text   string
+}
+
+// 保存聊天消息，供课后导出、举报和按保留期限清理；返回消息ID，保存失败时为 0
+func saveChatMessage(sessionID int, room string, msg Message) int64 {
+	content, err := json.Marshal(msg.Data)
+	if err != nil {
+		return 0
+	}
+	id, err := dialect.insertID(db, `
+		INSERT INTO chat_messages (session_id, room, user_id, content, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, sessionID, room, msg.From, string(content), msg.Time.UTC())
+	if err != nil {
+		log.Printf("Failed to save chat message for session %d: %v", sessionID, err)
+		return 0
+	}
+	return id
+}
+
+// 聊天消息的文本：字符串直接使用，对象取 text 或 content 字段，其余保留原始 JSON
+func chatText(content string) string {
+	var v interface{}
+	if err := json.Unmarshal([]byte(content), &v); err != nil {
+		return content
+	}
+	switch v := v.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		for _, key := range []string{"text", "content"} {
+			if s, ok := v[key].(string); ok {
+				return s
+			}
+		}
+	}
+	return content
+}
+
+// 相对开播时间的偏移，格式为 hh:mm:ss，开播前的消息为负数
+func streamOffset(t time.Time, start *time.Time) string {
+	if start == nil {
+		return ""
+	}
+	d := t.Sub(*start).Round(time.Second)
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	return fmt.Sprintf("%s%02d:%02d:%02d", sign, h, m, s)
+}
+
+// 导出会话的聊天记录和课堂问答，按时间排序并附上相对开播时间的偏移；
+// format=txt 时返回纯文本，默认 CSV
+func exportSessionChat(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	var startTime *time.Time
+	err := db.QueryRow("SELECT start_time FROM live_sessions WHERE id = ?", sessionID).Scan(&startTime)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSessionNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+
+	entries, err := loadSessionTranscript(sessionID)
+	if err != nil {
+		log.Printf("Failed to export chat of session %d: %v", sessionID, err)
+		respondError(c, http.StatusInternalServerError, CodeChatExportFailed)
+		return
+	}
+	recordAudit(c, "export_session_chat", "session", sessionID, gin.H{"entries": len(entries)})
+
+	loc := requestLocation(c)
+	if c.Query("format") == "txt" {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="session-%d-chat.txt"`, sessionID))
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+		c.Status(http.StatusOK)
+		for _, e := range entries {
+			label := ""
+			switch e.Kind {
+			case transcriptQuestion:
+				label = "[提问] "
+			case transcriptAnswer:
+				label = "[回答] "
+			}
+			fmt.Fprintf(c.Writer, "%s [%s] %s: %s%s\n",
+				e.Time.In(loc).Format("2006-01-02 15:04:05"), streamOffset(e.Time, startTime), e.Name, label, e.Text)
+		}
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="session-%d-chat.csv"`, sessionID))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+	cw := csv.NewWriter(c.Writer)
+	cw.Write([]string{"time", "offset", "kind", "room", "user_id", "name", "content"})
+	for _, e := range entries {
+		cw.Write([]string{
+			e.Time.In(loc).Format(time.RFC3339), streamOffset(e.Time, startTime), e.Kind, e.Room,
+			strconv.Itoa(e.UserID), e.Name, e.Text,
+		})
+	}
+	cw.Flush()
+}
+
+// 会话的聊天、提问和老师回答，按时间排序
+func loadSessionTranscript(sessionID int) ([]transcriptEntry, error) {
+	entries := []transcriptEntry{}
+	rows, err := readQuery(`
+		SELECT m.created_at, m.room, m.user_id, COALESCE(u.name, ''), m.content
+		FROM chat_messages m
+		LEFT JOIN users u ON u.id = m.user_id
+		WHERE m.session_id = ?
+		ORDER BY m.created_at, m.id
+	`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		e := transcriptEntry{Kind: transcriptChat}
+		var content string
+		if err := rows.Scan(&e.Time, &e.Room, &e.UserID, &e.Name, &content); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		e.Text = chatText(content)
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = readQuery(`
+		SELECT q.created_at, q.user_id, COALESCE(u.name, ''), q.content,
+			q.answered_at, COALESCE(q.answered_by, 0), COALESCE(a.name, ''), COALESCE(q.answer, '')
+		FROM session_qa q
+		LEFT JOIN users u ON u.id = q.user_id
+		LEFT JOIN users a ON a.id = q.answered_by
+		WHERE q.session_id = ?
+	`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	room := sessionRoom(sessionID)
+	for rows.Next() {
+		q := transcriptEntry{Kind: transcriptQuestion, Room: room}
+		var answeredAt *time.Time
+		var answeredBy int
+		var answerer, answer string
+		if err := rows.Scan(&q.Time, &q.UserID, &q.Name, &q.Text, &answeredAt, &answeredBy, &answerer, &answer); err != nil {
+			return nil, err
+		}
+		entries = append(entries, q)
+		if answeredAt != nil && answer != "" {
+			entries = append(entries, transcriptEntry{
+				Time: *answeredAt, Kind: transcriptAnswer, Room: room, UserID: answeredBy, Name: answerer, Text: answer,
+			})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	for i := range entries {
+		entries[i].Text = strings.ReplaceAll(entries[i].Text, "\r\n", "\n")
+	}
+	return entries, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// 本地记录慢速模式下学生可以再次发言的时间，超过该数量时清理已过期的记录
+const maxSlowModeEntries = 10000
+
+type slowModeKey struct {
+	sessionID int
+	userID    int
+}
+
+var (
+	slowModeMu   sync.Mutex
+	slowModeNext = make(map[slowModeKey]time.Time)
+)
+
+func slowModeRedisKey(sessionID, userID int) string {
+	return fmt.Sprintf("zhibo:chat:slow:%d:%d", sessionID, userID)
+}
+
+// 按会话的聊天控制检查学生的消息，不允许发送时返回错误码和参数
+func checkChatModes(c *Client, settings SessionSettings, msg Message) (ErrorCode, []interface{}) {
+	if settings.ChatMembersOnly {
+		enrolled, err := courseEnrolled(c.courseID, c.userID)
+		if err != nil {
+			return CodeInternal, nil
+		}
+		if !enrolled {
+			return CodeChatMembersOnly, nil
+		}
+	}
+	if settings.ChatEmojiOnly {
+		content, err := json.Marshal(msg.Data)
+		if err != nil || !emojiOnly(chatText(string(content))) {
+			return CodeChatEmojiOnly, nil
+		}
+	}
+	if settings.ChatSlowMode > 0 {
+		wait := takeSlowModeSlot(c.sessionID, c.userID, time.Duration(settings.ChatSlowMode)*time.Second)
+		if wait > 0 {
+			return CodeChatSlowMode, []interface{}{int((wait + time.Second - 1) / time.Second)}
+		}
+	}
+	return "", nil
+}
+
+// 占用学生在慢速模式下的发言机会，返回还需等待的时间，0 表示可以发送；
+// 配置了 Redis 时在所有副本间共享，同一学生的多个连接也受同一限制
+func takeSlowModeSlot(sessionID, userID int, interval time.Duration) time.Duration {
+	if redisClient != nil {
+		key := slowModeRedisKey(sessionID, userID)
+		ok, err := redisClient.SetNX(key, 1, interval).Result()
+		if err == nil {
+			if ok {
+				return 0
+			}
+			ttl, err := redisClient.PTTL(key).Result()
+			if err == nil && ttl > 0 {
+				return ttl
+			}
+			return 0
+		}
+		log.Printf("Failed to check slow mode for user %d in session %d: %v", userID, sessionID, err)
+	}
+
+	now := time.Now()
+	key := slowModeKey{sessionID, userID}
+	slowModeMu.Lock()
+	defer slowModeMu.Unlock()
+	if next, ok := slowModeNext[key]; ok && now.Before(next) {
+		return next.Sub(now)
+	}
+	if len(slowModeNext) >= maxSlowModeEntries {
+		for k, next := range slowModeNext {
+			if !now.Before(next) {
+				delete(slowModeNext, k)
+			}
+		}
+	}
+	slowModeNext[key] = now.Add(interval)
+	return 0
+}
+
+// 文本是否只由表情组成，忽略空白；肤色、组合连接符和变体选择符视为表情的一部分
+func emojiOnly(text string) bool {
+	found := false
+	for _, r := range text {
+		switch {
+		case unicode.IsSpace(r):
+		case r == 0x200D, r == 0x20E3, r >= 0xFE00 && r <= 0xFE0F, r >= 0xE0020 && r <= 0xE007F:
+		case r >= 0x1F3FB && r <= 0x1F3FF:
+		case unicode.Is(unicode.So, r):
+			found = true
+		default:
+			return false
+		}
+	}
+	return found
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 视频打点挂载的对象
+const (
+	CheckpointRecording = "recording"
+	CheckpointLesson    = "lesson"
+)
+
+// 视频打点题：播放到 offset_ms 时暂停，学生作答后才能继续
+type Checkpoint struct {
+	ID         int    `json:"id"`
+	TargetType string `json:"target_type"`
+	TargetID   int    `json:"target_id"`
+	OffsetMs   int    `json:"offset_ms"`
+	Question   gin.H  `json:"question"`
+	Attempts   int    `json:"attempts"` // 当前用户的作答次数
+	Answered   bool   `json:"answered"`
+	Correct    bool   `json:"correct"` // 任一次作答正确即为 true
+}
+
+// 按时间顺序加载视频的打点题及当前用户的作答情况
+func loadCheckpoints(targetType string, targetID, userID int) ([]Checkpoint, error) {
+	rows, err := db.Query(`
+		SELECT cp.id, cp.offset_ms, q.id, q.course_id, q.type, q.content, q.options, q.estimated_seconds, COALESCE(q.rich_content, ''),
+			(SELECT COUNT(*) FROM answers a WHERE a.checkpoint_id = cp.id AND a.student_id = ?),
+			(SELECT COUNT(*) FROM answers a WHERE a.checkpoint_id = cp.id AND a.student_id = ? AND a.answer = q.answer)
+		FROM video_checkpoints cp
+		JOIN questions q ON q.id = cp.question_id
+		WHERE cp.target_type = ? AND cp.target_id = ?
+		ORDER BY cp.offset_ms, cp.id
+	`, userID, userID, targetType, targetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checkpoints := []Checkpoint{}
+	for rows.Next() {
+		cp := Checkpoint{TargetType: targetType, TargetID: targetID}
+		var q Question
+		var options, richContent string
+		var correct int
+		if err := rows.Scan(&cp.ID, &cp.OffsetMs, &q.ID, &q.CourseID, &q.Type, &q.Content, &options, &q.EstimatedSeconds, &richContent,
+			&cp.Attempts, &correct); err != nil {
+			return nil, err
+		}
+		q.Options = splitList(options)
+		q.Blocks = decodeQuestionBlocks(context.Background(), richContent)
+		cp.Question = studentQuestion(q)
+		cp.Answered = cp.Attempts > 0
+		cp.Correct = correct > 0
+		checkpoints = append(checkpoints, cp)
+	}
+	return checkpoints, rows.Err()
+}
+
+// 在录像上添加打点题
+func createRecordingCheckpoint(c *gin.Context) {
+	rec, ok := recordingParam(c)
+	if !ok {
+		return
+	}
+	var courseID int
+	if err := db.QueryRow("SELECT course_id FROM live_sessions WHERE id = ?", rec.SessionID).Scan(&courseID); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+	createCheckpoint(c, CheckpointRecording, rec.ID, courseID, 0)
+}
+
+// 在录播课上添加打点题
+func createLessonCheckpoint(c *gin.Context) {
+	lesson, ok := lessonParam(c)
+	if !ok {
+		return
+	}
+	if lesson.Kind != LessonVOD {
+		respondError(c, http.StatusBadRequest, CodeLessonNotVOD)
+		return
+	}
+	createCheckpoint(c, CheckpointLesson, lesson.ID, lesson.CourseID, lesson.DurationSeconds)
+}
+
+// 题目须属于同一课程；durationSeconds 大于 0 时打点时间不能超过视频时长
+func createCheckpoint(c *gin.Context, targetType string, targetID, courseID, durationSeconds int) {
+	var req struct {
+		QuestionID int `json:"question_id" binding:"required"`
+		OffsetMs   int `json:"offset_ms" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if durationSeconds > 0 && req.OffsetMs > durationSeconds*1000 {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "offset_ms")
+		return
+	}
+	var questionCourse int
+	var questionStatus string
+	err := db.QueryRow("SELECT course_id, status FROM questions WHERE id = ?", req.QuestionID).Scan(&questionCourse, &questionStatus)
+	if err == sql.ErrNoRows || (err == nil && questionCourse != courseID) {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "question_id")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQuestionGetFailed)
+		return
+	}
+	if questionStatus == QuestionDraft {
+		respondError(c, http.StatusConflict, CodeQuestionDraft)
+		return
+	}
+
+	user := currentUser(c)
+	id, err := dialect.insertID(db, `
+		INSERT INTO video_checkpoints (target_type, target_id, question_id, offset_ms, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, targetType, targetID, req.QuestionID, req.OffsetMs, user.ID, time.Now().UTC())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeCheckpointCreateFailed)
+		return
+	}
+	respondOK(c, http.StatusCreated, gin.H{
+		"id":          id,
+		"target_type": targetType,
+		"target_id":   targetID,
+		"question_id": req.QuestionID,
+		"offset_ms":   req.OffsetMs,
+	})
+}
+
+// 删除打点题，已有的作答记录保留
+func deleteCheckpoint(c *gin.Context) {
+	id, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	res, err := db.Exec("DELETE FROM video_checkpoints WHERE id = ?", id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeCheckpointDeleteFailed)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, CodeCheckpointNotFound)
+		return
+	}
+	respondOK(c, http.StatusOK, gin.H{"id": id})
+}
+
+// 回答打点题，判分与课堂答题一致，只有第一次作答计入积分
+func answerCheckpoint(c *gin.Context) {
+	id, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Answer string `json:"answer" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	var questionID, courseID int
+	var questionType, scoring, correctAnswer string
+	err := db.QueryRow(`
+		SELECT q.id, q.course_id, q.type, q.scoring, q.answer
+		FROM video_checkpoints cp
+		JOIN questions q ON q.id = cp.question_id
+		WHERE cp.id = ?
+	`, id).Scan(&questionID, &courseID, &questionType, &scoring, &correctAnswer)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeCheckpointNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeCheckpointGetFailed)
+		return
+	}
+	user := currentUser(c)
+	if user.Role == RoleStudent && !requireCourseAccess(c, courseID, 0, user.ID) {
+		return
+	}
+
+	var attempts int
+	if err := db.QueryRow("SELECT COUNT(*) FROM answers WHERE checkpoint_id = ? AND student_id = ?", id, user.ID).Scan(&attempts); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeAnswerSubmitFailed)
+		return
+	}
+	answer := normalizeAnswer(questionType, req.Answer)
+	credit := answerCredit(questionType, scoring, correctAnswer, answer)
+	_, err = db.Exec(`
+		INSERT INTO answers (question_id, student_id, answer, checkpoint_id, submitted_at, credit)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, questionID, user.ID, answer, id, time.Now().UTC(), credit)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeAnswerSubmitFailed)
+		return
+	}
+	attempts++
+
+	correct := credit == 1
+	if attempts == 1 {
+		go scoreAnswer(courseID, user.ID, questionID, correct, 0)
+	}
+	emitEvent(EventAnswerSubmitted, courseID, gin.H{
+		"question_id":   questionID,
+		"student_id":    user.ID,
+		"course_id":     courseID,
+		"checkpoint_id": id,
+	})
+
+	respondOK(c, http.StatusOK, gin.H{
+		"checkpoint_id":  id,
+		"correct":        correct,
+		"credit":         credit,
+		"correct_answer": correctAnswer,
+		"attempts":       attempts,
+	})
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/Dong557799/zhibo-class/query"
+	"github.com/gin-gonic/gin"
+)
+
+const maxClassGroupMembers = 500
+
+// 班级，整班学生可一次报名课程，考勤和答题统计可按班级汇总
+type ClassGroup struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Org         string    `json:"org,omitempty"`
+	MemberCount int       `json:"member_count"`
+	MemberIDs   []int     `json:"member_ids,omitempty"`
+	CreatedBy   int       `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// 按班级汇总的考勤或答题数据
+type ClassGroupStat struct {
+	ClassGroupID int     `json:"class_group_id"`
+	Name         string  `json:"name"`
+	Members      int     `json:"members"`
+	Attended     int     `json:"attended,omitempty"`
+	AnswerCount  int     `json:"answer_count,omitempty"`
+	CorrectCount int     `json:"correct_count,omitempty"`
+	Rate         float64 `json:"rate"` // 出勤率或正确率
+}
+
+func loadClassGroup(id int) (ClassGroup, error) {
+	var g ClassGroup
+	err := db.QueryRow(`
+		SELECT id, name, org, created_by, created_at FROM class_groups WHERE id = ?
+	`, id).Scan(&g.ID, &g.Name, &g.Org, &g.CreatedBy, &g.CreatedAt)
+	if err != nil {
+		return g, err
+	}
+	rows, err := db.Query("SELECT student_id FROM class_group_members WHERE group_id = ? ORDER BY student_id", id)
+	if err != nil {
+		return g, err
+	}
+	defer rows.Close()
+	g.MemberIDs = []int{}
+	for rows.Next() {
+		var studentID int
+		if err := rows.Scan(&studentID); err != nil {
+			return g, err
+		}
+		g.MemberIDs = append(g.MemberIDs, studentID)
+	}
+	g.MemberCount = len(g.MemberIDs)
+	return g, rows.Err()
+}
+
+// 路由中的班级，不存在时写入错误响应
+func classGroupParam(c *gin.Context) (ClassGroup, bool) {
+	id, ok := intParam(c, "id")
+	if !ok {
+		return ClassGroup{}, false
+	}
+	g, err := loadClassGroup(id)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeClassGroupNotFound)
+		return g, false
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeClassGroupGetFailed)
+		return g, false
+	}
+	return g, true
+}
+
+// 创建班级
+func createClassGroup(c *gin.Context) {
+	var req struct {
+		Name string `json:"name" binding:"required,max=64"`
+		Org  string `json:"org" binding:"max=64"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	g := ClassGroup{
+		Name:      req.Name,
+		Org:       req.Org,
+		MemberIDs: []int{},
+		CreatedBy: currentUser(c).ID,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	id, err := dialect.insertID(db, `
+		INSERT INTO class_groups (name, org, created_by, created_at) VALUES (?, ?, ?, ?)
+	`, g.Name, g.Org, g.CreatedBy, g.CreatedAt)
+	if err != nil {
+		if dialect.isDuplicate(err) {
+			respondError(c, http.StatusConflict, CodeClassGroupExists)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeClassGroupSaveFailed)
+		}
+		return
+	}
+	g.ID = int(id)
+	recordAudit(c, "create_class_group", "class_group", g.ID, gin.H{"name": g.Name, "org": g.Org})
+	respondOK(c, http.StatusCreated, g)
+}
+
+// 班级列表，可按机构筛选、按名称搜索
+func listClassGroups(c *gin.Context) {
+	page, pageSize, offset := pageParams(c)
+	q := query.New().
+		Eq("g.org", c.Query("org")).
+		Contains(c.Query("q"), "g.name")
+
+	var total int64
+	if err := db.QueryRow("SELECT COUNT(*) FROM class_groups g WHERE "+q.WhereSQL(), q.Args()...).Scan(&total); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeClassGroupGetFailed)
+		return
+	}
+	rows, err := db.Query(`
+		SELECT g.id, g.name, g.org, g.created_by, g.created_at,
+			(SELECT COUNT(*) FROM class_group_members m WHERE m.group_id = g.id)
+		FROM class_groups g
+		WHERE `+q.WhereSQL()+`
+		ORDER BY g.org, g.name
+		LIMIT ? OFFSET ?
+	`, q.Args(pageSize, offset)...)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeClassGroupGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	groups := []ClassGroup{}
+	for rows.Next() {
+		var g ClassGroup
+		if err := rows.Scan(&g.ID, &g.Name, &g.Org, &g.CreatedBy, &g.CreatedAt, &g.MemberCount); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeClassGroupGetFailed)
+			return
+		}
+		g.CreatedAt = g.CreatedAt.In(loc)
+		groups = append(groups, g)
+	}
+	respondPage(c, groups, Pagination{Page: page, PageSize: pageSize, Total: total, HasMore: int64(offset+len(groups)) < total})
+}
+
+// 班级详情及成员
+func getClassGroup(c *gin.Context) {
+	g, ok := classGroupParam(c)
+	if !ok {
+		return
+	}
+	g.CreatedAt = g.CreatedAt.In(requestLocation(c))
+	respondOK(c, http.StatusOK, g)
+}
+
+// 删除班级，已通过班级报名的课程不受影响
+func deleteClassGroup(c *gin.Context) {
+	g, ok := classGroupParam(c)
+	if !ok {
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeClassGroupSaveFailed)
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM class_group_members WHERE group_id = ?", g.ID); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeClassGroupSaveFailed)
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM class_groups WHERE id = ?", g.ID); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeClassGroupSaveFailed)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeClassGroupSaveFailed)
+		return
+	}
+	recordAudit(c, "delete_class_group", "class_group", g.ID, gin.H{"name": g.Name, "members": g.MemberCount})
+	respondOK(c, http.StatusOK, gin.H{"message": "Class group deleted"})
+}
+
+// 设置班级成员：replace 为 true 时以 student_ids 替换现有成员，否则追加；只接受学生账号
+func setClassGroupMembers(c *gin.Context) {
+	g, ok := classGroupParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		StudentIDs []int `json:"student_ids" binding:"required,max=500,dive,gt=0"`
+		Replace    bool  `json:"replace"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	ids := uniqueInts(req.StudentIDs)
+	if len(ids) > 0 {
+		args := make([]interface{}, 0, len(ids)+1)
+		args = append(args, RoleStudent)
+		for _, id := range ids {
+			args = append(args, id)
+		}
+		var n int
+		err := db.QueryRow("SELECT COUNT(*) FROM users WHERE role = ? AND status = 'active' AND id IN ("+query.Placeholders(len(ids))+")", args...).Scan(&n)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeUserGetFailed)
+			return
+		}
+		if n != len(ids) {
+			respondError(c, http.StatusBadRequest, CodeInvalidParam, "student_ids")
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeClassGroupSaveFailed)
+		return
+	}
+	defer tx.Rollback()
+	if req.Replace {
+		if _, err := tx.Exec("DELETE FROM class_group_members WHERE group_id = ?", g.ID); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeClassGroupSaveFailed)
+			return
+		}
+	}
+	now := time.Now().UTC()
+	added := 0
+	for _, id := range ids {
+		res, err := tx.Exec(dialect.insertIgnore(`
+			INSERT INTO class_group_members (group_id, student_id, created_at) VALUES (?, ?, ?)
+		`), g.ID, id, now)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeClassGroupSaveFailed)
+			return
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			added++
+		}
+	}
+	var total int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM class_group_members WHERE group_id = ?", g.ID).Scan(&total); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeClassGroupSaveFailed)
+		return
+	}
+	if total > maxClassGroupMembers {
+		respondError(c, http.StatusBadRequest, CodeClassGroupTooLarge, maxClassGroupMembers)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeClassGroupSaveFailed)
+		return
+	}
+	recordAudit(c, "set_class_group_members", "class_group", g.ID, gin.H{"student_ids": ids, "replace": req.Replace})
+	respondOK(c, http.StatusOK, gin.H{"added": added, "member_count": total})
+}
+
+// 移出班级成员
+func removeClassGroupMember(c *gin.Context) {
+	g, ok := classGroupParam(c)
+	if !ok {
+		return
+	}
+	studentID, ok := intParam(c, "student_id")
+	if !ok {
+		return
+	}
+	res, err := db.Exec("DELETE FROM class_group_members WHERE group_id = ? AND student_id = ?", g.ID, studentID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeClassGroupSaveFailed)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, CodeUserNotFound)
+		return
+	}
+	recordAudit(c, "remove_class_group_member", "class_group", g.ID, gin.H{"student_id": studentID})
+	respondOK(c, http.StatusOK, gin.H{"message": "Member removed"})
+}
+
+// 整班报名课程，已报名的学生跳过
+func enrollClassGroup(c *gin.Context) {
+	g, ok := classGroupParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		CourseID int `json:"course_id" binding:"required,gt=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeClassGroupEnrollFailed)
+		return
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	enrolled := 0
+	for _, studentID := range g.MemberIDs {
+		res, err := tx.Exec(dialect.insertIgnore(`
+			INSERT INTO course_enrollments (course_id, student_id, order_id, created_at) VALUES (?, ?, NULL, ?)
+		`), req.CourseID, studentID, now)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeClassGroupEnrollFailed)
+			return
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			enrolled++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeClassGroupEnrollFailed)
+		return
+	}
+	recordAudit(c, "enroll_class_group", "class_group", g.ID, gin.H{"course_id": req.CourseID, "enrolled": enrolled})
+	respondOK(c, http.StatusOK, gin.H{
+		"course_id": req.CourseID,
+		"members":   g.MemberCount,
+		"enrolled":  enrolled,
+		"skipped":   g.MemberCount - enrolled,
+	})
+}
+
+// 会话考勤按班级汇总：班级中报名了该课程的学生及其中进入过会话的人数
+func getSessionAttendanceByClassGroup(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	var courseID int
+	err := db.QueryRow("SELECT course_id FROM live_sessions WHERE id = ?", sessionID).Scan(&courseID)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSessionNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+
+	// 原会话和补课会话的出勤合并计算
+	unit, err := attendanceUnit(sessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+	rows, err := readQuery(`
+		SELECT g.id, g.name, COUNT(m.student_id), COUNT(a.user_id)
+		FROM class_groups g
+		JOIN class_group_members m ON m.group_id = g.id
+		JOIN course_enrollments e ON e.course_id = ? AND e.student_id = m.student_id
+		LEFT JOIN (
+			SELECT DISTINCT user_id FROM session_attendance
+			WHERE session_id = ? OR session_id IN (SELECT id FROM live_sessions WHERE makeup_for = ?)
+		) a ON a.user_id = m.student_id
+		GROUP BY g.id, g.name
+		ORDER BY g.name
+	`, courseID, unit, unit)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeClassGroupGetFailed)
+		return
+	}
+	defer rows.Close()
+	stats := []ClassGroupStat{}
+	for rows.Next() {
+		var s ClassGroupStat
+		if err := rows.Scan(&s.ClassGroupID, &s.Name, &s.Members, &s.Attended); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeClassGroupGetFailed)
+			return
+		}
+		if s.Members > 0 {
+			s.Rate = float64(s.Attended) / float64(s.Members)
+		}
+		stats = append(stats, s)
+	}
+	respondOK(c, http.StatusOK, stats)
+}
+
+// 题目的作答情况按班级汇总，不含测验补答
+func questionStatsByClassGroup(questionID, correctAnswer string) ([]ClassGroupStat, error) {
+	rows, err := readQuery(`
+		SELECT g.id, g.name, (SELECT COUNT(*) FROM class_group_members x WHERE x.group_id = g.id), COUNT(a.id),
+			COALESCE(SUM(CASE WHEN a.answer = ? THEN 1 ELSE 0 END), 0)
+		FROM class_groups g
+		JOIN class_group_members m ON m.group_id = g.id
+		JOIN answers a ON a.student_id = m.student_id AND a.question_id = ? AND NOT a.makeup
+		GROUP BY g.id, g.name
+		ORDER BY g.name
+	`, correctAnswer, questionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := []ClassGroupStat{}
+	for rows.Next() {
+		var s ClassGroupStat
+		if err := rows.Scan(&s.ClassGroupID, &s.Name, &s.Members, &s.AnswerCount, &s.CorrectCount); err != nil {
+			return nil, err
+		}
+		if s.AnswerCount > 0 {
+			s.Rate = float64(s.CorrectCount) / float64(s.AnswerCount)
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+func uniqueInts(ids []int) []int {
+	seen := make(map[int]bool, len(ids))
+	out := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dong557799/zhibo-class/query"
+	"github.com/gin-gonic/gin"
+)
+
+// 片段状态
+const (
+	ClipPending    = "pending"
+	ClipProcessing = "processing"
+	ClipReady      = "ready"
+	ClipFailed     = "failed"
+)
+
+const (
+	jobRecordingClip = "recording.clip"
+
+	maxClipDuration         = 10 * time.Minute
+	defaultClipShareSeconds = 7 * 24 * 3600
+	maxClipShareSeconds     = 30 * 24 * 3600
+)
+
+// 从录像中截取的片段，如知识点回顾
+type Clip struct {
+	ID          int        `json:"id"`
+	RecordingID int        `json:"recording_id"`
+	CourseID    int        `json:"course_id"`
+	Title       string     `json:"title"`
+	StartMs     int        `json:"start_ms"` // 相对处理后 MP4 的起止时间
+	EndMs       int        `json:"end_ms"`
+	Status      string     `json:"status"`
+	VideoURL    string     `json:"video_url,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedBy   int        `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+
+	videoKey string
+}
+
+const clipColumns = "id, recording_id, course_id, title, start_ms, end_ms, status, video_key, COALESCE(error, ''), created_by, created_at, finished_at"
+
+func init() {
+	jobHandlers[jobRecordingClip] = func(ctx context.Context, payload json.RawMessage) error {
+		var p struct {
+			ClipID int `json:"clip_id"`
+		}
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return runClipJob(p.ClipID)
+	}
+}
+
+func scanClip(row interface{ Scan(...interface{}) error }) (Clip, error) {
+	var clip Clip
+	err := row.Scan(&clip.ID, &clip.RecordingID, &clip.CourseID, &clip.Title, &clip.StartMs, &clip.EndMs, &clip.Status,
+		&clip.videoKey, &clip.Error, &clip.CreatedBy, &clip.CreatedAt, &clip.FinishedAt)
+	return clip, err
+}
+
+// 按 URL 中的 id 加载片段，不存在时写入错误响应
+func clipParam(c *gin.Context) (Clip, bool) {
+	id, ok := intParam(c, "id")
+	if !ok {
+		return Clip{}, false
+	}
+	clip, err := scanClip(db.QueryRow("SELECT "+clipColumns+" FROM recording_clips WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeClipNotFound)
+		return clip, false
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeClipGetFailed)
+		return clip, false
+	}
+	return clip, true
+}
+
+func (clip *Clip) localize(c *gin.Context) {
+	loc := requestLocation(c)
+	clip.CreatedAt = clip.CreatedAt.In(loc)
+	clip.FinishedAt = inLocation(clip.FinishedAt, loc)
+	if clip.Status == ClipReady {
+		clip.VideoURL = storageURL(c.Request.Context(), clip.videoKey)
+	}
+}
+
+// 截取录像片段，由后台任务转码后上传
+func createClip(c *gin.Context) {
+	rec, ok := recordingParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Title   string `json:"title" binding:"required,max=128"`
+		StartMs int    `json:"start_ms" binding:"min=0"`
+		EndMs   int    `json:"end_ms" binding:"required,gtfield=StartMs"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if time.Duration(req.EndMs-req.StartMs)*time.Millisecond > maxClipDuration {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "end_ms")
+		return
+	}
+	// 起止时间以处理后的 MP4 为准，需等待录像处理完成
+	if rec.Status != RecordingReady {
+		respondError(c, http.StatusConflict, CodeRecordingNotReady)
+		return
+	}
+
+	clip := Clip{
+		RecordingID: rec.ID,
+		Title:       req.Title,
+		StartMs:     req.StartMs,
+		EndMs:       req.EndMs,
+		Status:      ClipPending,
+		CreatedBy:   currentUser(c).ID,
+		CreatedAt:   time.Now().UTC(),
+	}
+	if err := db.QueryRow("SELECT course_id FROM live_sessions WHERE id = ?", rec.SessionID).Scan(&clip.CourseID); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+	id, err := dialect.insertID(db, `
+		INSERT INTO recording_clips (recording_id, course_id, title, start_ms, end_ms, status, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, clip.RecordingID, clip.CourseID, clip.Title, clip.StartMs, clip.EndMs, clip.Status, clip.CreatedBy, clip.CreatedAt)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeClipCreateFailed)
+		return
+	}
+	clip.ID = int(id)
+
+	if _, err := enqueueJob(jobRecordingClip, gin.H{"clip_id": clip.ID}, time.Time{}); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeClipCreateFailed)
+		return
+	}
+	clip.localize(c)
+	respondOK(c, http.StatusAccepted, clip)
+}
+
+// 执行截取任务，失败时记录错误并交由任务队列重试
+func runClipJob(clipID int) error {
+	clip, err := scanClip(db.QueryRow("SELECT "+clipColumns+" FROM recording_clips WHERE id = ?", clipID))
+	if err == sql.ErrNoRows {
+		return nil // 片段已删除
+	}
+	if err != nil {
+		return err
+	}
+	db.Exec("UPDATE recording_clips SET status = ?, error = NULL WHERE id = ?", ClipProcessing, clip.ID)
+
+	key, err := cutClip(clip)
+	if err != nil {
+		db.Exec("UPDATE recording_clips SET status = ?, error = ?, finished_at = ? WHERE id = ?", ClipFailed, err.Error(), time.Now().UTC(), clip.ID)
+		return err
+	}
+	_, err = db.Exec("UPDATE recording_clips SET status = ?, video_key = ?, finished_at = ? WHERE id = ?", ClipReady, key, time.Now().UTC(), clip.ID)
+	return err
+}
+
+// 从原始分段截取并重新编码，保证起点精确而不受关键帧位置影响
+func cutClip(clip Clip) (string, error) {
+	rec, err := getRecording("id", clip.RecordingID)
+	if err != nil {
+		return "", err
+	}
+	if len(rec.Segments) == 0 {
+		return "", errNoRecordingSegments
+	}
+
+	workDir, err := os.MkdirTemp("", fmt.Sprintf("recording-clip-%d-", clip.ID))
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(workDir)
+
+	list, err := writeConcatList(rec.Segments, workDir)
+	if err != nil {
+		return "", err
+	}
+	// 处理录像时裁掉的开头静音需要加回，才能与 MP4 的时间轴对齐
+	start := time.Duration(rec.TrimMs+clip.StartMs) * time.Millisecond
+	length := time.Duration(clip.EndMs-clip.StartMs) * time.Millisecond
+	out := filepath.Join(workDir, "clip.mp4")
+	args := []string{"-hide_banner", "-loglevel", "error", "-f", "concat", "-safe", "0", "-i", list,
+		"-ss", strconv.FormatFloat(start.Seconds(), 'f', 3, 64),
+		"-t", strconv.FormatFloat(length.Seconds(), 'f', 3, 64)}
+	if settings, err := loadSessionSettings(rec.SessionID); err == nil && settings.ForensicWatermark {
+		args = append(args, "-vf", forensicWatermarkFilter(forensicMark(rec.ID)))
+	}
+	args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-c:a", "aac",
+		"-movflags", "+faststart", "-y", out)
+	if output, err := exec.Command(ffmpegPath(), args...).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("ffmpeg failed: %v: %s", err, output)
+	}
+
+	key := fmt.Sprintf("clips/%d/%d.mp4", rec.ID, clip.ID)
+	if err := putFile(context.Background(), key, out, "video/mp4"); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// 课程的片段列表，学生只能看到已生成的片段
+func listCourseClips(c *gin.Context) {
+	courseID, ok := intParam(c, "course_id")
+	if !ok {
+		return
+	}
+	user := currentUser(c)
+	q := query.New().
+		Where("course_id = ?", courseID).
+		Eq("recording_id", c.Query("recording_id"))
+	if user.Role == RoleStudent {
+		if !requireCourseAccess(c, courseID, 0, user.ID) {
+			return
+		}
+		q.Where("status = ?", ClipReady)
+	}
+
+	rows, err := db.Query("SELECT "+clipColumns+" FROM recording_clips WHERE "+q.WhereSQL()+" ORDER BY recording_id, start_ms, id", q.Args()...)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeClipGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	clips := []Clip{}
+	for rows.Next() {
+		clip, err := scanClip(rows)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeClipGetFailed)
+			return
+		}
+		clip.localize(c)
+		clips = append(clips, clip)
+	}
+	respondOK(c, http.StatusOK, clips)
+}
+
+// 片段详情
+func getClip(c *gin.Context) {
+	clip, ok := clipParam(c)
+	if !ok {
+		return
+	}
+	user := currentUser(c)
+	if user.Role == RoleStudent {
+		if clip.Status != ClipReady {
+			respondError(c, http.StatusNotFound, CodeClipNotFound)
+			return
+		}
+		if !requireCourseAccess(c, clip.CourseID, 0, user.ID) {
+			return
+		}
+	}
+	clip.localize(c)
+	respondOK(c, http.StatusOK, clip)
+}
+
+// 删除片段及其视频文件
+func deleteClip(c *gin.Context) {
+	clip, ok := clipParam(c)
+	if !ok {
+		return
+	}
+	if _, err := db.Exec("DELETE FROM recording_clips WHERE id = ?", clip.ID); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeClipDeleteFailed)
+		return
+	}
+	if clip.videoKey != "" {
+		deleteVideos(c, []string{clip.videoKey})
+	}
+	respondOK(c, http.StatusOK, gin.H{"id": clip.ID})
+}
+
+func clipShareSignature(payload string) string {
+	mac := hmac.New(sha256.New, []byte(currentConfig().JWTSecret))
+	mac.Write([]byte("clip\n" + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 生成免登录的分享链接，格式为 /api/clips/shared/<id>.<expires>.<sig>
+func shareClip(c *gin.Context) {
+	clip, ok := clipParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		ExpiresSeconds int `json:"expires_seconds" binding:"omitempty,min=60"` // 为 0 时 7 天有效
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		respondBindError(c, err)
+		return
+	}
+	if clip.Status != ClipReady {
+		respondError(c, http.StatusConflict, CodeClipNotReady)
+		return
+	}
+	secs := req.ExpiresSeconds
+	if secs == 0 {
+		secs = defaultClipShareSeconds
+	}
+	secs = min(secs, maxClipShareSeconds)
+
+	expires := time.Now().Add(time.Duration(secs) * time.Second)
+	payload := fmt.Sprintf("%d.%d", clip.ID, expires.Unix())
+	recordAudit(c, "share_clip", "clip", clip.ID, gin.H{"expires_seconds": secs})
+	respondOK(c, http.StatusOK, gin.H{
+		"id":         clip.ID,
+		"share_url":  "/api/clips/shared/" + payload + "." + clipShareSignature(payload),
+		"expires_at": expires.In(requestLocation(c)),
+	})
+}
+
+// 校验分享链接并跳转到片段的下载地址
+func openSharedClip(c *gin.Context) {
+	token := c.Param("token")
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 || !hmac.Equal([]byte(token[i+1:]), []byte(clipShareSignature(token[:i]))) {
+		respondError(c, http.StatusForbidden, CodeClipShareInvalid)
+		return
+	}
+	parts := strings.Split(token[:i], ".")
+	if len(parts) != 2 {
+		respondError(c, http.StatusForbidden, CodeClipShareInvalid)
+		return
+	}
+	id, err1 := strconv.Atoi(parts[0])
+	expires, err2 := strconv.ParseInt(parts[1], 10, 64)
+	if err1 != nil || err2 != nil || time.Now().Unix() > expires {
+		respondError(c, http.StatusForbidden, CodeClipShareInvalid)
+		return
+	}
+
+	var key, status string
+	err := db.QueryRow("SELECT video_key, status FROM recording_clips WHERE id = ?", id).Scan(&key, &status)
+	if err == sql.ErrNoRows || (err == nil && status != ClipReady) {
+		respondError(c, http.StatusNotFound, CodeClipNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeClipGetFailed)
+		return
+	}
+	u := storageURL(c.Request.Context(), key)
+	if u == "" {
+		respondError(c, http.StatusInternalServerError, CodeClipGetFailed)
+		return
+	}
+	c.Redirect(http.StatusFound, u)
+}
@@ -0,0 +1,440 @@
+// simulate 对目标环境做一次课堂答题压测：创建模拟课程、学生和题目，
+// 建立大量 WebSocket 连接，按场景推送题目并模拟学生作答，最后输出延迟分位数。
+//
+//	go run ./cmd/simulate -base http://localhost:8080 -admin admin -password secret -students 2000
+//
+// 每个连接占用一个文件描述符，数千连接时需先调高 ulimit -n。
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	baseURL     = flag.String("base", "http://localhost:8080", "目标环境地址")
+	adminUser   = flag.String("admin", "admin", "管理员用户名")
+	adminPass   = flag.String("password", "", "管理员密码")
+	numStudents = flag.Int("students", 500, "模拟学生数")
+	numQuestion = flag.Int("questions", 5, "推送题目数")
+	courseID    = flag.Int("course", 0, "模拟课程 ID，为 0 时按当前时间生成")
+	connectRate = flag.Int("connect-rate", 200, "每秒新建连接数")
+	setupJobs   = flag.Int("setup-concurrency", 20, "创建学生时的并发数")
+	thinkTime   = flag.Duration("think", 5*time.Second, "学生收到题目后的最长作答时间")
+	interval    = flag.Duration("interval", 10*time.Second, "两次推题的间隔")
+	correctRate = flag.Float64("correct", 0.7, "答对比例")
+)
+
+func main() {
+	flag.Parse()
+	if *adminPass == "" {
+		fmt.Fprintln(os.Stderr, "-password is required")
+		os.Exit(2)
+	}
+	if *connectRate <= 0 || *setupJobs <= 0 {
+		fmt.Fprintln(os.Stderr, "-connect-rate and -setup-concurrency must be positive")
+		os.Exit(2)
+	}
+	if *courseID == 0 {
+		*courseID = int(time.Now().Unix() % 1000000000)
+	}
+
+	api := &apiClient{base: strings.TrimRight(*baseURL, "/"), http: &http.Client{Timeout: 30 * time.Second}}
+	if err := api.login(*adminUser, *adminPass); err != nil {
+		log.Fatalf("Failed to login: %v", err)
+	}
+
+	log.Printf("Preparing course %d with %d students and %d questions", *courseID, *numStudents, *numQuestion)
+	students, err := createStudents(api, *courseID, *numStudents)
+	if err != nil {
+		log.Fatalf("Failed to create students: %v", err)
+	}
+	questions, err := createQuestions(api, *courseID, *numQuestion)
+	if err != nil {
+		log.Fatalf("Failed to create questions: %v", err)
+	}
+	sessionID, err := createSession(api, *courseID)
+	if err != nil {
+		log.Fatalf("Failed to create session: %v", err)
+	}
+
+	sim := &simulation{api: api, sessionID: sessionID, pushedAt: make(map[int]time.Time)}
+	sim.connect(students)
+	log.Printf("Connected %d/%d students", sim.connected.Load(), len(students))
+
+	for i, qid := range questions {
+		if i > 0 {
+			time.Sleep(*interval)
+		}
+		sim.push(qid)
+	}
+	// 等待最后一题的作答
+	time.Sleep(*thinkTime + 5*time.Second)
+	sim.close()
+
+	if err := api.do(http.MethodPost, fmt.Sprintf("/api/live/sessions/%d/end", sessionID), nil, nil); err != nil {
+		log.Printf("Failed to end session %d: %v", sessionID, err)
+	}
+	sim.report(len(questions))
+}
+
+// 调用后台接口，统一使用 code/message/data 外层格式
+type apiClient struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+}
+
+func (a *apiClient) do(method, path string, body, out interface{}) error {
+	var raw []byte
+	if body != nil {
+		var err error
+		if raw, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, a.base+path, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Response-Format", "envelope")
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Code    string          `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	if resp.StatusCode >= 400 {
+		return &apiError{Status: resp.StatusCode, Code: envelope.Code, Message: envelope.Message}
+	}
+	if out != nil && len(envelope.Data) > 0 {
+		return json.Unmarshal(envelope.Data, out)
+	}
+	return nil
+}
+
+func (a *apiClient) login(username, password string) error {
+	var out struct {
+		Token string `json:"token"`
+	}
+	if err := a.do(http.MethodPost, "/api/auth/login", map[string]string{"username": username, "password": password}, &out); err != nil {
+		return err
+	}
+	a.token = out.Token
+	return nil
+}
+
+// 模拟学生，以自己的登录令牌连接和作答
+type student struct {
+	api *apiClient
+}
+
+func createStudents(api *apiClient, courseID, n int) ([]student, error) {
+	students := make([]student, n)
+	errs := make(chan error, n)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < *setupJobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				username := fmt.Sprintf("sim-%d-%d", courseID, i)
+				password := fmt.Sprintf("sim-%d-password", courseID)
+				err := api.do(http.MethodPost, "/api/admin/users", map[string]string{
+					"username": username,
+					"name":     fmt.Sprintf("Sim Student %d", i),
+					"role":     "student",
+					"password": password,
+				}, nil)
+				if err != nil {
+					errs <- err
+					continue
+				}
+				sc := &apiClient{base: api.base, http: api.http}
+				if err := sc.login(username, password); err != nil {
+					errs <- err
+					continue
+				}
+				students[i] = student{api: sc}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+	if err, ok := <-errs; ok {
+		return nil, err
+	}
+	return students, nil
+}
+
+func createQuestions(api *apiClient, courseID, n int) ([]int, error) {
+	ids := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		var out struct {
+			ID int `json:"id"`
+		}
+		err := api.do(http.MethodPost, "/api/question/create", map[string]interface{}{
+			"course_id": courseID,
+			"type":      "single_choice",
+			"content":   fmt.Sprintf("Simulated question %d", i+1),
+			"options":   []string{"A", "B", "C", "D"},
+			"answer":    "A",
+		}, &out)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, out.ID)
+	}
+	return ids, nil
+}
+
+func createSession(api *apiClient, courseID int) (int, error) {
+	var out struct {
+		ID int `json:"id"`
+	}
+	if err := api.do(http.MethodPost, "/api/live/sessions", map[string]int{"course_id": courseID}, &out); err != nil {
+		return 0, err
+	}
+	if err := api.do(http.MethodPost, fmt.Sprintf("/api/live/sessions/%d/start", out.ID), nil, nil); err != nil {
+		return 0, err
+	}
+	return out.ID, nil
+}
+
+// 一轮压测的连接和统计
+type simulation struct {
+	api       *apiClient
+	sessionID int
+
+	mu       sync.RWMutex
+	pushedAt map[int]time.Time // 题目 ID -> 发起推送的时间
+
+	conns     sync.WaitGroup
+	connsMu   sync.Mutex
+	open      []*websocket.Conn
+	connected atomic.Int64
+
+	connectErrors recorder
+	pushLatency   recorder // 推题接口耗时
+	delivery      recorder // 发起推送到学生收到题目
+	submitLatency recorder // 提交答案接口耗时
+	submits       sync.WaitGroup
+}
+
+func (s *simulation) connect(students []student) {
+	u, err := url.Parse(s.api.base)
+	if err != nil {
+		log.Fatalf("Invalid base url: %v", err)
+	}
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+	u.Path = fmt.Sprintf("/api/live/sessions/%d/ws", s.sessionID)
+
+	ticker := time.NewTicker(time.Second / time.Duration(*connectRate))
+	defer ticker.Stop()
+	var dialing sync.WaitGroup
+	for _, st := range students {
+		<-ticker.C
+		dialing.Add(1)
+		go func(st student) {
+			defer dialing.Done()
+			q := url.Values{"access_token": {st.api.token}}
+			wsURL := *u
+			wsURL.RawQuery = q.Encode()
+			conn, _, err := websocket.DefaultDialer.Dial(wsURL.String(), nil)
+			if err != nil {
+				s.connectErrors.fail()
+				return
+			}
+			s.connected.Add(1)
+			s.connsMu.Lock()
+			s.open = append(s.open, conn)
+			s.connsMu.Unlock()
+			s.conns.Add(1)
+			go s.read(conn, st)
+		}(st)
+	}
+	dialing.Wait()
+}
+
+func (s *simulation) read(conn *websocket.Conn, st student) {
+	defer s.conns.Done()
+	for {
+		_, payload, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg struct {
+			Type string `json:"type"`
+			Data struct {
+				ID int `json:"id"`
+			} `json:"data"`
+		}
+		if json.Unmarshal(payload, &msg) != nil || msg.Type != "question" {
+			continue
+		}
+		s.mu.RLock()
+		pushedAt, ok := s.pushedAt[msg.Data.ID]
+		s.mu.RUnlock()
+		if ok {
+			s.delivery.add(time.Since(pushedAt))
+		}
+		s.submits.Add(1)
+		go s.answer(st, msg.Data.ID)
+	}
+}
+
+func (s *simulation) answer(st student, questionID int) {
+	defer s.submits.Done()
+	time.Sleep(time.Duration(rand.Int63n(int64(*thinkTime) + 1)))
+	answer := "A"
+	if rand.Float64() >= *correctRate {
+		answer = "B"
+	}
+	start := time.Now()
+	err := st.api.do(http.MethodPost, "/api/question/submit", map[string]interface{}{
+		"question_id": questionID,
+		"answer":      answer,
+	}, nil)
+	if err != nil {
+		s.submitLatency.fail()
+		return
+	}
+	s.submitLatency.add(time.Since(start))
+}
+
+func (s *simulation) push(questionID int) {
+	start := time.Now()
+	s.mu.Lock()
+	s.pushedAt[questionID] = start
+	s.mu.Unlock()
+
+	err := s.api.do(http.MethodGet, fmt.Sprintf("/api/question/push/%d/%d", *courseID, questionID), nil, nil)
+	if err != nil {
+		log.Printf("Failed to push question %d: %v", questionID, err)
+		s.pushLatency.fail()
+		return
+	}
+	s.pushLatency.add(time.Since(start))
+	log.Printf("Pushed question %d", questionID)
+}
+
+// 关闭连接后再等待未完成的作答，避免与读协程中的 submits.Add 并发
+func (s *simulation) close() {
+	s.connsMu.Lock()
+	for _, conn := range s.open {
+		conn.Close()
+	}
+	s.connsMu.Unlock()
+	s.conns.Wait()
+	s.submits.Wait()
+}
+
+func (s *simulation) report(questions int) {
+	expected := int(s.connected.Load()) * questions
+	fmt.Printf("\nconnections: %d ok, %d failed\n", s.connected.Load(), s.connectErrors.failures())
+	fmt.Printf("deliveries:  %d/%d\n\n", s.delivery.count(), expected)
+	fmt.Printf("%-10s %8s %8s %10s %10s %10s %10s\n", "metric", "count", "errors", "p50", "p90", "p99", "max")
+	for _, m := range []struct {
+		name string
+		r    *recorder
+	}{
+		{"push", &s.pushLatency},
+		{"delivery", &s.delivery},
+		{"submit", &s.submitLatency},
+	} {
+		m.r.print(m.name)
+	}
+}
+
+// 延迟样本
+type recorder struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	errors  int
+}
+
+func (r *recorder) add(d time.Duration) {
+	r.mu.Lock()
+	r.samples = append(r.samples, d)
+	r.mu.Unlock()
+}
+
+func (r *recorder) fail() {
+	r.mu.Lock()
+	r.errors++
+	r.mu.Unlock()
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.samples)
+}
+
+func (r *recorder) failures() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.errors
+}
+
+func (r *recorder) print(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sorted := append([]time.Duration(nil), r.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	pct := func(p float64) time.Duration {
+		if len(sorted) == 0 {
+			return 0
+		}
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+	fmt.Printf("%-10s %8d %8d %10s %10s %10s %10s\n", name, len(sorted), r.errors,
+		pct(0.5).Round(time.Millisecond), pct(0.9).Round(time.Millisecond),
+		pct(0.99).Round(time.Millisecond), pct(1).Round(time.Millisecond))
+}
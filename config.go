@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const configPath = "config.json"
+
+// 当前配置。重新加载时整体替换，读取方拿到的始终是一份完整的配置，
+// 同一处理流程中需要多个字段时应先保存 currentConfig() 的返回值
+var activeConfig atomic.Pointer[Config]
+
+func currentConfig() *Config {
+	return activeConfig.Load()
+}
+
+// 读取并校验配置文件
+func readConfig(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	conf := &Config{}
+	if err := json.NewDecoder(file).Decode(conf); err != nil {
+		return nil, err
+	}
+	if conf.JWTSecret == "" {
+		return nil, fmt.Errorf("jwt_secret is required")
+	}
+	switch conf.DBDriver {
+	case "", "mysql", "postgres", "sqlite":
+	default:
+		return nil, fmt.Errorf("unknown db_driver %q", conf.DBDriver)
+	}
+	switch conf.Storage.Driver {
+	case "", "local", "s3", "oss", "cos":
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", conf.Storage.Driver)
+	}
+	switch conf.SMS.Driver {
+	case "", "log":
+	case "http":
+		if conf.SMS.URL == "" {
+			return nil, fmt.Errorf("sms url is required for the http driver")
+		}
+	default:
+		return nil, fmt.Errorf("unknown sms driver %q", conf.SMS.Driver)
+	}
+	switch conf.WS.DropPolicy {
+	case "", DropNewest, DropOldest:
+	default:
+		return nil, fmt.Errorf("unknown ws drop_policy %q", conf.WS.DropPolicy)
+	}
+	for name := range conf.Features {
+		if _, ok := defaultFeatures[name]; !ok {
+			return nil, fmt.Errorf("unknown feature %q", name)
+		}
+	}
+	if err := parseLTIPrivateKey(&conf.LTI); err != nil {
+		return nil, err
+	}
+	if err := parsePlayDomains(conf.Playback.Domains); err != nil {
+		return nil, err
+	}
+	if err := validateOutbound(conf.Outbound); err != nil {
+		return nil, err
+	}
+	if err := validateMediaServer(conf.MediaServer); err != nil {
+		return nil, err
+	}
+	if err := validateErrorReporting(conf.ErrorReporting); err != nil {
+		return nil, err
+	}
+	conf.location = time.UTC
+	if conf.TimeZone != "" {
+		loc, err := time.LoadLocation(conf.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time_zone: %w", err)
+		}
+		conf.location = loc
+	}
+	return conf, nil
+}
+
+func loadConfig() error {
+	conf, err := readConfig(configPath)
+	if err != nil {
+		return err
+	}
+	activeConfig.Store(conf)
+	return nil
+}
+
+// 连接、端口等在启动时生效的配置，重新加载时保留原值
+func keepStartupSettings(next, prev *Config) {
+	warn := func(name string, changed bool) {
+		if changed {
+			log.Printf("Config %s changed; restart required for it to take effect", name)
+		}
+	}
+	warn("db", next.DBUser != prev.DBUser || next.DBPassword != prev.DBPassword ||
+		next.DBHost != prev.DBHost || next.DBPort != prev.DBPort || next.DBName != prev.DBName ||
+		next.DBDriver != prev.DBDriver)
+	warn("db_replica", next.DBReplicaHost != prev.DBReplicaHost || next.DBReplicaPort != prev.DBReplicaPort ||
+		next.DBReplicaUser != prev.DBReplicaUser || next.DBReplicaPassword != prev.DBReplicaPassword)
+	warn("api_port", next.APIPort != prev.APIPort)
+	warn("jwt_secret", next.JWTSecret != prev.JWTSecret)
+	warn("mqtt", !reflect.DeepEqual(next.MQTT, prev.MQTT))
+	warn("event_bus", !reflect.DeepEqual(next.EventBus, prev.EventBus))
+	warn("redis", !reflect.DeepEqual(next.Redis, prev.Redis))
+	warn("tls", !reflect.DeepEqual(next.TLS, prev.TLS))
+	warn("storage", !reflect.DeepEqual(next.Storage, prev.Storage))
+	warn("job_workers", next.JobWorkers != prev.JobWorkers)
+	warn("tracing", next.Tracing != prev.Tracing)
+	warn("thumbnail_interval", next.ThumbnailInterval != prev.ThumbnailInterval)
+	warn("outbound", !reflect.DeepEqual(next.Outbound, prev.Outbound))
+	warn("media_server", (next.MediaServer.Driver == MediaEmbedded || prev.MediaServer.Driver == MediaEmbedded) &&
+		(next.MediaServer.Driver != prev.MediaServer.Driver || next.MediaServer.RTMPListen != prev.MediaServer.RTMPListen))
+
+	next.DBUser, next.DBPassword, next.DBHost, next.DBPort, next.DBName, next.DBDriver =
+		prev.DBUser, prev.DBPassword, prev.DBHost, prev.DBPort, prev.DBName, prev.DBDriver
+	next.DBReplicaHost, next.DBReplicaPort, next.DBReplicaUser, next.DBReplicaPassword =
+		prev.DBReplicaHost, prev.DBReplicaPort, prev.DBReplicaUser, prev.DBReplicaPassword
+	next.APIPort = prev.APIPort
+	next.JWTSecret = prev.JWTSecret
+	next.MQTT = prev.MQTT
+	next.EventBus = prev.EventBus
+	next.Redis = prev.Redis
+	next.TLS = prev.TLS
+	next.Storage = prev.Storage
+	next.JobWorkers = prev.JobWorkers
+	next.ThumbnailInterval = prev.ThumbnailInterval
+	next.Tracing = prev.Tracing
+	next.Outbound = prev.Outbound
+	// 内置 RTMP 服务启动后不能切换，其他服务器之间可以直接切换
+	if next.MediaServer.Driver == MediaEmbedded || prev.MediaServer.Driver == MediaEmbedded {
+		next.MediaServer.Driver, next.MediaServer.RTMPListen = prev.MediaServer.Driver, prev.MediaServer.RTMPListen
+	}
+}
+
+// 重新读取配置文件，校验失败时继续使用原配置
+func reloadConfig() error {
+	next, err := readConfig(configPath)
+	if err != nil {
+		return err
+	}
+	keepStartupSettings(next, currentConfig())
+	activeConfig.Store(next)
+	return nil
+}
+
+// 收到 SIGHUP 时重新加载配置
+func watchConfigReload() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		if err := reloadConfig(); err != nil {
+			log.Printf("Failed to reload config: %v", err)
+			continue
+		}
+		log.Printf("Config reloaded")
+	}
+}
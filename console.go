@@ -0,0 +1,27 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const consoleURLPrefix = "/console"
+
+// 内嵌的教师控制台静态页面，调用的都是公开的 /api 接口
+//
+//go:embed web/console
+var consoleFiles embed.FS
+
+func registerConsole(r *gin.Engine) {
+	files, err := fs.Sub(consoleFiles, "web/console")
+	if err != nil {
+		panic(err)
+	}
+	r.StaticFS(consoleURLPrefix, http.FS(files))
+	r.GET("/", func(c *gin.Context) {
+		c.Redirect(http.StatusFound, consoleURLPrefix+"/")
+	})
+}
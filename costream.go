@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 连麦推流端的状态，由推流回调逐个更新
+const (
+	PublisherIdle  = "idle"
+	PublisherLive  = "live"
+	PublisherEnded = "ended" // 会话结束后推流码失效
+)
+
+// 画面布局，播放端按 priority 从小到大叠放
+const (
+	LayoutMain       = "main"
+	LayoutPIP        = "pip"
+	LayoutSideBySide = "side_by_side"
+	LayoutGrid       = "grid"
+)
+
+const mainPublisherName = "主讲"
+
+// 会话的额外推流端，如客座讲师；会话自身的推流码为主画面
+type Publisher struct {
+	ID        int               `json:"id"`
+	SessionID int               `json:"session_id"`
+	Name      string            `json:"name"`
+	UserID    *int              `json:"user_id,omitempty"`
+	StreamKey string            `json:"stream_key"`
+	Layout    string            `json:"layout"`
+	Priority  int               `json:"priority"`
+	Status    string            `json:"status"`
+	PlayURLs  map[string]string `json:"play_urls,omitempty"`
+	StartedAt *time.Time        `json:"started_at,omitempty"`
+	EndedAt   *time.Time        `json:"ended_at,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// 播放端展示的一路画面，publisher_id 为 0 表示主讲
+type LiveStream struct {
+	PublisherID int               `json:"publisher_id"`
+	Name        string            `json:"name"`
+	Layout      string            `json:"layout"`
+	Priority    int               `json:"priority"`
+	PlayURLs    map[string]string `json:"play_urls"`
+}
+
+const publisherColumns = "id, session_id, name, user_id, stream_key, layout, priority, status, started_at, ended_at, created_at"
+
+func scanPublisher(row interface{ Scan(...interface{}) error }) (Publisher, error) {
+	var p Publisher
+	err := row.Scan(&p.ID, &p.SessionID, &p.Name, &p.UserID, &p.StreamKey, &p.Layout, &p.Priority, &p.Status,
+		&p.StartedAt, &p.EndedAt, &p.CreatedAt)
+	return p, err
+}
+
+func (p *Publisher) inLocation(loc *time.Location) {
+	p.CreatedAt = p.CreatedAt.In(loc)
+	p.StartedAt = inLocation(p.StartedAt, loc)
+	p.EndedAt = inLocation(p.EndedAt, loc)
+}
+
+// 会话正在播出的所有画面：主讲在直播时排在最前，其余按 priority 排序
+func liveStreams(sessionID int, mainKey, sessionStatus, region string, settings SessionSettings) ([]LiveStream, error) {
+	streams := []LiveStream{}
+	if sessionStatus == "live" {
+		streams = append(streams, LiveStream{
+			Name:     mainPublisherName,
+			Layout:   LayoutMain,
+			PlayURLs: filterPlayURLs(getPlayURLs(mainKey, region), settings),
+		})
+	}
+
+	rows, err := db.Query(`
+		SELECT id, name, stream_key, layout, priority FROM session_publishers
+		WHERE session_id = ? AND status = ?
+		ORDER BY priority, id
+	`, sessionID, PublisherLive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s LiveStream
+		var key string
+		if err := rows.Scan(&s.PublisherID, &s.Name, &key, &s.Layout, &s.Priority); err != nil {
+			return nil, err
+		}
+		s.PlayURLs = filterPlayURLs(getPlayURLs(key, region), settings)
+		streams = append(streams, s)
+	}
+	sort.SliceStable(streams, func(i, j int) bool { return streams[i].Priority < streams[j].Priority })
+	return streams, rows.Err()
+}
+
+// 为会话添加推流端，返回推流码，推流地址通过 publish-info?publisher_id= 获取
+func createPublisher(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Name     string `json:"name" binding:"required,max=64"`
+		UserID   *int   `json:"user_id"`
+		Layout   string `json:"layout" binding:"omitempty,oneof=main pip side_by_side grid"`
+		Priority *int   `json:"priority" binding:"omitempty,min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	var status string
+	err := db.QueryRow("SELECT status FROM live_sessions WHERE id = ?", sessionID).Scan(&status)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSessionNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+	if status == "ended" {
+		respondError(c, http.StatusConflict, CodeSessionAlreadyEnded)
+		return
+	}
+
+	p := Publisher{
+		SessionID: sessionID,
+		Name:      req.Name,
+		UserID:    req.UserID,
+		StreamKey: generateStreamKey(),
+		Layout:    req.Layout,
+		Status:    PublisherIdle,
+		CreatedAt: time.Now().UTC(),
+	}
+	if p.Layout == "" {
+		p.Layout = LayoutPIP
+	}
+	if req.Priority != nil {
+		p.Priority = *req.Priority
+	} else if err := db.QueryRow("SELECT COALESCE(MAX(priority), 0) + 1 FROM session_publishers WHERE session_id = ?", sessionID).Scan(&p.Priority); err != nil {
+		respondError(c, http.StatusInternalServerError, CodePublisherCreateFailed)
+		return
+	}
+
+	id, err := dialect.insertID(db, `
+		INSERT INTO session_publishers (session_id, name, user_id, stream_key, layout, priority, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, p.SessionID, p.Name, p.UserID, p.StreamKey, p.Layout, p.Priority, p.Status, p.CreatedAt)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodePublisherCreateFailed)
+		return
+	}
+	p.ID = int(id)
+	if err := media().CreateStream(c.Request.Context(), p.StreamKey); err != nil {
+		db.Exec("DELETE FROM session_publishers WHERE id = ?", p.ID)
+		respondError(c, http.StatusInternalServerError, CodeStreamCreateFailed)
+		return
+	}
+
+	recordAudit(c, "create_publisher", "live_session", sessionID, gin.H{"publisher_id": p.ID, "name": p.Name})
+	p.inLocation(requestLocation(c))
+	respondOK(c, http.StatusCreated, p)
+}
+
+// 会话的推流端列表
+func listPublishers(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	if !sessionExists(c, sessionID) {
+		return
+	}
+	rows, err := db.Query("SELECT "+publisherColumns+" FROM session_publishers WHERE session_id = ? ORDER BY priority, id", sessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodePublisherGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	publishers := []Publisher{}
+	for rows.Next() {
+		p, err := scanPublisher(rows)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodePublisherGetFailed)
+			return
+		}
+		if p.Status == PublisherLive {
+			p.PlayURLs = getPlayURLs(p.StreamKey, playRegion(c))
+		}
+		p.inLocation(loc)
+		publishers = append(publishers, p)
+	}
+	respondOK(c, http.StatusOK, publishers)
+}
+
+// 按 URL 中的 publisher_id 加载会话的推流端，不存在时写入错误响应
+func publisherParam(c *gin.Context) (Publisher, bool) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return Publisher{}, false
+	}
+	publisherID, ok := intParam(c, "publisher_id")
+	if !ok {
+		return Publisher{}, false
+	}
+	p, err := scanPublisher(db.QueryRow("SELECT "+publisherColumns+" FROM session_publishers WHERE id = ? AND session_id = ?",
+		publisherID, sessionID))
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodePublisherNotFound)
+		return p, false
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodePublisherGetFailed)
+		return p, false
+	}
+	return p, true
+}
+
+// 调整推流端的名称、布局和叠放顺序，直播中立即通知播放端
+func updatePublisher(c *gin.Context) {
+	p, ok := publisherParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name     *string `json:"name" binding:"omitempty,min=1,max=64"`
+		Layout   *string `json:"layout" binding:"omitempty,oneof=main pip side_by_side grid"`
+		Priority *int    `json:"priority" binding:"omitempty,min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if req.Name != nil {
+		p.Name = *req.Name
+	}
+	if req.Layout != nil {
+		p.Layout = *req.Layout
+	}
+	if req.Priority != nil {
+		p.Priority = *req.Priority
+	}
+	if _, err := db.Exec("UPDATE session_publishers SET name = ?, layout = ?, priority = ? WHERE id = ?",
+		p.Name, p.Layout, p.Priority, p.ID); err != nil {
+		respondError(c, http.StatusInternalServerError, CodePublisherUpdateFailed)
+		return
+	}
+	notifyPublisher(p)
+	p.inLocation(requestLocation(c))
+	respondOK(c, http.StatusOK, p)
+}
+
+// 移除推流端并删除其推流码
+func deletePublisher(c *gin.Context) {
+	p, ok := publisherParam(c)
+	if !ok {
+		return
+	}
+	if _, err := db.Exec("DELETE FROM session_publishers WHERE id = ?", p.ID); err != nil {
+		respondError(c, http.StatusInternalServerError, CodePublisherUpdateFailed)
+		return
+	}
+	if err := media().DeleteStream(c.Request.Context(), p.StreamKey); err != nil {
+		log.Printf("Failed to delete publisher stream %s: %v", p.StreamKey, err)
+	}
+	if p.Status == PublisherLive {
+		p.Status = PublisherEnded
+		notifyPublisher(p)
+	}
+	recordAudit(c, "delete_publisher", "live_session", p.SessionID, gin.H{"publisher_id": p.ID, "name": p.Name})
+	respondOK(c, http.StatusOK, gin.H{"id": p.ID})
+}
+
+// 通知播放端某一路画面的状态或布局变化
+func notifyPublisher(p Publisher) {
+	hub.broadcast(sessionRoom(p.SessionID), Message{Type: "publisher_status", Data: gin.H{
+		"publisher_id": p.ID,
+		"name":         p.Name,
+		"layout":       p.Layout,
+		"priority":     p.Priority,
+		"status":       p.Status,
+	}})
+}
+
+// 处理额外推流端的推流回调，只更新该推流端自身的状态，返回响应的状态码和错误码；
+// 推流码不属于任何推流端时返回 false，由调用方按会话主推流处理
+func publisherCallback(streamKey, event string) (bool, int, ErrorCode) {
+	p, err := scanPublisher(db.QueryRow("SELECT "+publisherColumns+" FROM session_publishers WHERE stream_key = ?", streamKey))
+	if err == sql.ErrNoRows {
+		return false, 0, ""
+	}
+	if err != nil {
+		return true, http.StatusInternalServerError, CodeInternal
+	}
+	if event == "start" && p.Status == PublisherEnded {
+		return true, http.StatusConflict, CodeSessionAlreadyEnded
+	}
+
+	var res sql.Result
+	switch event {
+	case "start":
+		p.Status = PublisherLive
+		res, err = db.Exec("UPDATE session_publishers SET status = ?, started_at = ?, ended_at = NULL WHERE id = ? AND status = ?",
+			PublisherLive, time.Now().UTC(), p.ID, PublisherIdle)
+	case "stop":
+		p.Status = PublisherIdle
+		res, err = db.Exec("UPDATE session_publishers SET status = ?, ended_at = ? WHERE id = ? AND status = ?",
+			PublisherIdle, time.Now().UTC(), p.ID, PublisherLive)
+	}
+	if err != nil {
+		log.Printf("Failed to update publisher %d from callback: %v", p.ID, err)
+		return true, http.StatusInternalServerError, CodeInternal
+	}
+	if res != nil {
+		if n, _ := res.RowsAffected(); n > 0 {
+			notifyPublisher(p)
+		}
+	}
+	return true, http.StatusOK, ""
+}
+
+// 会话结束时停用所有推流端的推流码
+func endSessionPublishers(sessionID int) error {
+	rows, err := db.Query("SELECT stream_key FROM session_publishers WHERE session_id = ? AND status <> ?", sessionID, PublisherEnded)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if len(keys) == 0 {
+		return nil
+	}
+
+	if _, err := db.Exec("UPDATE session_publishers SET status = ?, ended_at = ? WHERE session_id = ? AND status <> ?",
+		PublisherEnded, time.Now().UTC(), sessionID, PublisherEnded); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := media().DeleteStream(context.Background(), key); err != nil {
+			log.Printf("Failed to delete publisher stream %s: %v", key, err)
+		}
+	}
+	return nil
+}
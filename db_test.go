@@ -0,0 +1,44 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// 在临时目录创建迁移好的 SQLite 库并替换全局 db，测试结束后恢复
+func openTestDB(t *testing.T) {
+	t.Helper()
+	prevDialect, prevDB := dialect, db
+	if err := setDialect("sqlite"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := sql.Open(dialect.driverName(), dialect.dsn("", "", "", 0, filepath.Join(t.TempDir(), "test.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(conn); err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	db = conn
+	t.Cleanup(func() {
+		conn.Close()
+		dialect, db = prevDialect, prevDB
+	})
+}
+
+func mustExec(t *testing.T, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
+
+// 替换当前配置，测试结束后恢复
+func useTestConfig(t *testing.T, conf *Config) {
+	t.Helper()
+	prev := activeConfig.Load()
+	activeConfig.Store(conf)
+	t.Cleanup(func() { activeConfig.Store(prev) })
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v7"
+)
+
+const (
+	defaultSlowConsumerDrops = 64
+	deliveryFlushInterval    = 2 * time.Second
+	deliveryTTL              = 24 * time.Hour
+	maxDeliveryRecords       = 1000
+)
+
+// 发送缓冲区已满时的丢弃策略：drop_newest 丢弃新消息（默认），drop_oldest 丢弃最早排队的消息
+const (
+	DropNewest = "drop_newest"
+	DropOldest = "drop_oldest"
+)
+
+// WebSocket 连接的发送队列与握手令牌
+type WSConfig struct {
+	SendBuffer int    `json:"send_buffer"` // 每个连接最多排队的消息数，为 0 时使用 256
+	DropPolicy string `json:"drop_policy"` // 普通消息的丢弃策略，关键消息总是挤掉最早排队的消息
+	// 连续丢弃该数量的消息后断开连接，客户端重连后重新获取状态；为 0 时使用 64，为负数时不断开
+	SlowConsumerDrops int `json:"slow_consumer_drops"`
+
+	// 连接令牌有效期（秒），为 0 时使用 60；require_connect_token 为 true 时不再接受 access_token 查询参数
+	TokenSeconds        int  `json:"token_seconds"`
+	RequireConnectToken bool `json:"require_connect_token"`
+}
+
+// 题目、作答截止、会话状态等消息丢失后学生端会停留在错误的状态，缓冲区满时优先保留
+var criticalMessages = map[string]bool{
+	"question":            true,
+	"question_closed":     true,
+	"question_extended":   true,
+	"answer_revealed":     true,
+	"exam_started":        true,
+	"exam_closed":         true,
+	"session_status":      true,
+	"session_transferred": true,
+	"settings_updated":    true,
+	"timer_expired":       true,
+}
+
+// 排队等待发送的消息，delivery 不为空时统计学生端的送达情况
+type outbound struct {
+	payload  []byte
+	critical bool
+	delivery string
+}
+
+// 本实例因发送缓冲区已满丢弃的消息总数和因读取过慢被断开的连接数
+var (
+	droppedMessages     int64
+	slowConsumersClosed int64
+)
+
+func sendBufferSize() int {
+	if n := currentConfig().WS.SendBuffer; n > 0 {
+		return n
+	}
+	return 256
+}
+
+func slowConsumerLimit() int {
+	if n := currentConfig().WS.SlowConsumerDrops; n != 0 {
+		return n
+	}
+	return defaultSlowConsumerDrops
+}
+
+func pushDelivery(pushID int) string { return fmt.Sprintf("push:%d", pushID) }
+
+// 从已编码的消息中读出投递需要的字段，用于其他副本转发来的广播
+func decodeOutbound(payload []byte) outbound {
+	var head struct {
+		Type     string `json:"type"`
+		Delivery string `json:"delivery"`
+	}
+	json.Unmarshal(payload, &head)
+	return outbound{payload: payload, critical: criticalMessages[head.Type], delivery: head.Delivery}
+}
+
+// 将消息放入连接的发送队列，队列已满时按丢弃策略处理，返回消息是否入队
+func (c *Client) enqueue(out outbound) bool {
+	if out.delivery != "" && c.role == RoleStudent {
+		deliveries.target(out.delivery, c.userID)
+	}
+	select {
+	case c.send <- out:
+		return true
+	default:
+	}
+
+	if out.critical || currentConfig().WS.DropPolicy == DropOldest {
+		select {
+		case old := <-c.send:
+			c.dropped(old)
+		default:
+		}
+		select {
+		case c.send <- out:
+			return true
+		default:
+		}
+	}
+	c.dropped(out)
+	return false
+}
+
+// 记录丢弃的消息，连续丢弃过多时断开连接，避免一个读取过慢的客户端一直占用缓冲区
+func (c *Client) dropped(out outbound) {
+	atomic.AddInt64(&droppedMessages, 1)
+	if out.delivery != "" && c.role == RoleStudent {
+		deliveries.drop(out.delivery)
+	}
+	n := atomic.AddInt32(&c.drops, 1)
+	log.Printf("Dropped message for user %d: send buffer full", c.userID)
+	if limit := slowConsumerLimit(); limit > 0 && int(n) == limit {
+		atomic.AddInt64(&slowConsumersClosed, 1)
+		log.Printf("Closing slow websocket consumer for user %d after %d dropped messages", c.userID, n)
+		c.conn.Close()
+	}
+}
+
+// 消息已写入连接
+func (c *Client) delivered(out outbound) {
+	atomic.StoreInt32(&c.drops, 0)
+	if out.delivery != "" && c.role == RoleStudent {
+		deliveries.receive(out.delivery, c.userID)
+	}
+}
+
+// 一次推送的送达记录。配置了 Redis 时定期合并到 Redis 并清空，由各副本共同统计
+type deliveryRecord struct {
+	targeted  map[int]bool
+	received  map[int]bool
+	dropped   int
+	updatedAt time.Time
+}
+
+type deliveryTracker struct {
+	mu      sync.Mutex
+	records map[string]*deliveryRecord
+}
+
+var deliveries = &deliveryTracker{records: make(map[string]*deliveryRecord)}
+
+func deliveryKey(key string) string { return "zhibo:delivery:" + key }
+
+func (t *deliveryTracker) record(key string) *deliveryRecord {
+	r := t.records[key]
+	if r == nil {
+		// 未配置 Redis 时只保留最近的记录
+		if len(t.records) >= maxDeliveryRecords {
+			t.evict()
+		}
+		r = &deliveryRecord{targeted: make(map[int]bool), received: make(map[int]bool)}
+		t.records[key] = r
+	}
+	r.updatedAt = time.Now()
+	return r
+}
+
+func (t *deliveryTracker) evict() {
+	var oldest string
+	for key, r := range t.records {
+		if oldest == "" || r.updatedAt.Before(t.records[oldest].updatedAt) {
+			oldest = key
+		}
+	}
+	delete(t.records, oldest)
+}
+
+func (t *deliveryTracker) target(key string, userID int) {
+	t.mu.Lock()
+	t.record(key).targeted[userID] = true
+	t.mu.Unlock()
+}
+
+func (t *deliveryTracker) receive(key string, userID int) {
+	t.mu.Lock()
+	t.record(key).received[userID] = true
+	t.mu.Unlock()
+}
+
+func (t *deliveryTracker) drop(key string) {
+	t.mu.Lock()
+	t.record(key).dropped++
+	t.mu.Unlock()
+}
+
+// 将本实例的记录合并到 Redis；未配置 Redis 时清理过期的记录
+func (t *deliveryTracker) flush() {
+	t.mu.Lock()
+	records := t.records
+	if redisClient != nil {
+		t.records = make(map[string]*deliveryRecord)
+	} else {
+		for key, r := range records {
+			if time.Since(r.updatedAt) > deliveryTTL {
+				delete(records, key)
+			}
+		}
+	}
+	t.mu.Unlock()
+	if redisClient == nil {
+		return
+	}
+
+	pipe := redisClient.Pipeline()
+	for key, r := range records {
+		base := deliveryKey(key)
+		if ids := userIDs(r.targeted); len(ids) > 0 {
+			pipe.SAdd(base+":targeted", ids...)
+			pipe.Expire(base+":targeted", deliveryTTL)
+		}
+		if ids := userIDs(r.received); len(ids) > 0 {
+			pipe.SAdd(base+":received", ids...)
+			pipe.Expire(base+":received", deliveryTTL)
+		}
+		if r.dropped > 0 {
+			pipe.IncrBy(base+":dropped", int64(r.dropped))
+			pipe.Expire(base+":dropped", deliveryTTL)
+		}
+	}
+	if _, err := pipe.Exec(); err != nil {
+		log.Printf("Failed to flush delivery stats: %v", err)
+	}
+}
+
+func userIDs(set map[int]bool) []interface{} {
+	ids := make([]interface{}, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func runDeliveryFlusher() {
+	ticker := time.NewTicker(deliveryFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		deliveries.flush()
+	}
+}
+
+// 学生的送达状态：pending 未收到，delivered 已写入连接，acknowledged 学生端已回执，answered 已作答
+const (
+	DeliveryPending      = "pending"
+	DeliveryDelivered    = "delivered"
+	DeliveryAcknowledged = "acknowledged"
+	DeliveryAnswered     = "answered"
+)
+
+type StudentDelivery struct {
+	StudentID int    `json:"student_id"`
+	Status    string `json:"status"`
+}
+
+// 题目推送的送达报告。已送达指消息已写入学生的连接，同一学生有多个连接时只计一次；
+// 其他副本的记录最多延迟 deliveryFlushInterval 合并
+type DeliveryReport struct {
+	QuestionID   int               `json:"question_id"`
+	PushID       int               `json:"push_id"`
+	PushedAt     time.Time         `json:"pushed_at"`
+	Targeted     int               `json:"targeted"`     // 推送时或作答期间重连的在线学生数，其他副本上的学生同样计入
+	Received     int               `json:"received"`     // 已收到题目的学生数
+	Acknowledged int               `json:"acknowledged"` // 学生端已回执的学生数
+	Answered     int               `json:"answered"`     // 已作答的学生数
+	Dropped      int               `json:"dropped"`      // 因发送缓冲区已满丢弃的次数
+	Students     []StudentDelivery `json:"students"`
+}
+
+func (t *deliveryTracker) report(key string) (targeted, received map[int]bool, dropped int, err error) {
+	if redisClient != nil {
+		t.flush()
+		base := deliveryKey(key)
+		targeted, received = make(map[int]bool), make(map[int]bool)
+		for set, ids := range map[string]map[int]bool{base + ":targeted": targeted, base + ":received": received} {
+			members, err := redisClient.SMembers(set).Result()
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			for _, m := range members {
+				if id, err := strconv.Atoi(m); err == nil {
+					ids[id] = true
+				}
+			}
+		}
+		n, err := redisClient.Get(base + ":dropped").Int()
+		if err != nil && err != redis.Nil {
+			return nil, nil, 0, err
+		}
+		return targeted, received, n, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	targeted, received = make(map[int]bool), make(map[int]bool)
+	if r := t.records[key]; r != nil {
+		for id := range r.targeted {
+			targeted[id] = true
+		}
+		for id := range r.received {
+			received[id] = true
+		}
+		dropped = r.dropped
+	}
+	return targeted, received, dropped, nil
+}
+
+// 查看题目推送有多少学生实际收到，默认取最近一次推送
+func getDeliveryReport(c *gin.Context) {
+	questionID, ok := intParam(c, "question_id")
+	if !ok {
+		return
+	}
+	push, err := latestQuestionPush(questionID)
+	if rawID := c.Query("push_id"); rawID != "" {
+		pushID, convErr := strconv.Atoi(rawID)
+		if convErr != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidParam, "push_id")
+			return
+		}
+		push, err = loadQuestionPush(pushID)
+		if err == nil && push.QuestionID != questionID {
+			err = sql.ErrNoRows
+		}
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, CodeQuestionNotPushed)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeResultGetFailed)
+		}
+		return
+	}
+
+	targeted, received, dropped, err := deliveries.report(pushDelivery(push.ID))
+	if err != nil {
+		log.Printf("Failed to load delivery stats for push %d: %v", push.ID, err)
+		respondError(c, http.StatusInternalServerError, CodeResultGetFailed)
+		return
+	}
+	acked, err := pushStudents("SELECT student_id FROM question_acks WHERE push_id = ?", push.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeResultGetFailed)
+		return
+	}
+	answered, err := pushStudents("SELECT DISTINCT student_id FROM answers WHERE push_id = ?", push.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeResultGetFailed)
+		return
+	}
+
+	report := DeliveryReport{
+		QuestionID:   questionID,
+		PushID:       push.ID,
+		PushedAt:     push.PushedAt.In(requestLocation(c)),
+		Acknowledged: len(acked),
+		Answered:     len(answered),
+		Dropped:      dropped,
+		Students:     []StudentDelivery{},
+	}
+	// 回执和作答说明学生已收到题目，其他副本的送达记录尚未合并时也计入
+	for _, set := range []map[int]bool{received, acked, answered} {
+		for id := range set {
+			targeted[id] = true
+		}
+	}
+	for id := range targeted {
+		status := DeliveryPending
+		switch {
+		case answered[id]:
+			status = DeliveryAnswered
+		case acked[id]:
+			status = DeliveryAcknowledged
+		case received[id]:
+			status = DeliveryDelivered
+		}
+		if status != DeliveryPending {
+			report.Received++
+		}
+		report.Students = append(report.Students, StudentDelivery{StudentID: id, Status: status})
+	}
+	report.Targeted = len(targeted)
+	sort.Slice(report.Students, func(i, j int) bool { return report.Students[i].StudentID < report.Students[j].StudentID })
+	respondOK(c, http.StatusOK, report)
+}
+
+func pushStudents(query string, pushID int) (map[int]bool, error) {
+	rows, err := db.Query(query, pushID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// 作答期间内的最近一次推送。未设置截止时间的推送在推送后 maxAnswerWindowSeconds 内视为仍在作答
+func openQuestionPush(courseID int, now time.Time) (QuestionPush, bool, error) {
+	var p QuestionPush
+	err := db.QueryRow(`
+		SELECT id, question_id, course_id, fastest_n, winners, pushed_at, closes_at
+		FROM question_pushes
+		WHERE course_id = ? AND pushed_at > ?
+		ORDER BY id DESC
+		LIMIT 1
+	`, courseID, now.Add(-maxAnswerWindowSeconds*time.Second)).Scan(&p.ID, &p.QuestionID, &p.CourseID, &p.FastestN, &p.Winners, &p.PushedAt, &p.ClosesAt)
+	if err == sql.ErrNoRows {
+		return p, false, nil
+	}
+	if err != nil {
+		return p, false, err
+	}
+	return p, !p.closed(now), nil
+}
+
+// 学生在作答期间重连时重新推送尚未回执也未作答的题目
+func (c *Client) repushOpenQuestion() {
+	if c.role != RoleStudent {
+		return
+	}
+	push, ok, err := openQuestionPush(c.courseID, time.Now().UTC())
+	if err != nil {
+		log.Printf("Failed to load open question for user %d: %v", c.userID, err)
+		return
+	}
+	if !ok {
+		return
+	}
+	var n int
+	err = db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM question_acks WHERE push_id = ? AND student_id = ?)
+			+ (SELECT COUNT(*) FROM answers WHERE push_id = ? AND student_id = ?)
+	`, push.ID, c.userID, push.ID, c.userID).Scan(&n)
+	if err != nil {
+		log.Printf("Failed to check question ack for user %d: %v", c.userID, err)
+		return
+	}
+	if n > 0 {
+		return
+	}
+	q, err := loadQuestion(push.QuestionID)
+	if err != nil {
+		log.Printf("Failed to load question %d for re-push: %v", push.QuestionID, err)
+		return
+	}
+	data := pushedQuestion(q, push)
+	data["repush"] = true
+	c.sendMessage(Message{Type: "question", Room: courseRoom(c.courseID), Data: data, Delivery: pushDelivery(push.ID)})
+}
+
+// 记录学生对题目推送的回执，首次回执时通知会话的老师
+func ackQuestion(c *Client, pushID int) ErrorCode {
+	push, err := loadQuestionPush(pushID)
+	if err == sql.ErrNoRows || (err == nil && push.CourseID != c.courseID) {
+		return CodeQuestionNotPushed
+	}
+	if err != nil {
+		return CodeInternal
+	}
+	res, err := db.Exec(dialect.insertIgnore(`
+		INSERT INTO question_acks (push_id, student_id, acked_at) VALUES (?, ?, ?)
+	`), push.ID, c.userID, time.Now().UTC())
+	if err != nil {
+		log.Printf("Failed to save question ack for user %d: %v", c.userID, err)
+		return CodeInternal
+	}
+	deliveries.receive(pushDelivery(push.ID), c.userID)
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ""
+	}
+
+	var acked int
+	if err := db.QueryRow("SELECT COUNT(*) FROM question_acks WHERE push_id = ?", push.ID).Scan(&acked); err != nil {
+		log.Printf("Failed to count question acks for push %d: %v", push.ID, err)
+		return ""
+	}
+	hub.broadcast(teacherRoom(c.sessionID), Message{Type: "question_delivery", Data: gin.H{
+		"question_id":  push.QuestionID,
+		"push_id":      push.ID,
+		"student_id":   c.userID,
+		"status":       DeliveryAcknowledged,
+		"acknowledged": acked,
+	}})
+	return ""
+}
+
+func init() {
+	// 学生端收到题目后回执，data 为 {"push_id": 1}
+	wsHandlers["question_ack"] = func(c *Client, msg Message) {
+		if c.role != RoleStudent {
+			return
+		}
+		data, _ := msg.Data.(map[string]interface{})
+		pushID, ok := data["push_id"].(float64)
+		if !ok || pushID <= 0 {
+			c.sendError(CodeInvalidParam, "push_id")
+			return
+		}
+		if code := ackQuestion(c, int(pushID)); code != "" {
+			c.sendError(code)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// 演示模式：启动时写入示例课程数据，并向直播会话循环推送测试画面
+var demoMode = flag.Bool("demo", false, "seed a sample course with users, questions and a live session")
+
+const (
+	demoCourseID       = 1001
+	demoPassword       = "demo1234"
+	demoTeacher        = "demo_teacher"
+	demoStudentCount   = 5
+	demoStreamRestart  = 5 * time.Second
+	demoTestPatternSrc = "testsrc2=size=1280x720:rate=25"
+)
+
+var demoQuestions = []struct {
+	typ     string
+	content string
+	options []string
+	answer  string
+	tags    []string
+}{
+	{QuestionSingleChoice, "中国的首都是哪座城市？", []string{"北京", "上海", "广州", "深圳"}, "北京", []string{"地理"}},
+	{QuestionSingleChoice, "1 + 2 × 3 等于多少？", []string{"9", "7", "6", "5"}, "7", []string{"数学"}},
+	{QuestionTrueFalse, "水在标准大气压下 100°C 沸腾。", nil, "true", []string{"物理"}},
+	{QuestionFillBlank, "HTTP 默认端口是 ____。", nil, "80", []string{"计算机"}},
+}
+
+// 写入演示数据，重复启动时复用已有数据；返回直播中的演示会话的推流码
+func seedDemo() (string, error) {
+	var teacherID int
+	err := db.QueryRow("SELECT id FROM users WHERE username = ?", demoTeacher).Scan(&teacherID)
+	if err == sql.ErrNoRows {
+		if err := seedDemoData(); err != nil {
+			return "", err
+		}
+		err = db.QueryRow("SELECT id FROM users WHERE username = ?", demoTeacher).Scan(&teacherID)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var streamKey string
+	err = db.QueryRow("SELECT stream_key FROM live_sessions WHERE course_id = ? AND status = 'live' ORDER BY id DESC LIMIT 1",
+		demoCourseID).Scan(&streamKey)
+	if err == sql.ErrNoRows {
+		streamKey = generateStreamKey()
+		if err := media().CreateStream(context.Background(), streamKey); err != nil {
+			return "", err
+		}
+		_, err = db.Exec(`
+			INSERT INTO live_sessions (course_id, teacher_id, stream_key, status, start_time, created_at)
+			VALUES (?, ?, ?, 'live', NOW(), NOW())
+		`, demoCourseID, teacherID, streamKey)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("Demo course %d is ready: teacher %s, students demo_student1-%d, password %s",
+		demoCourseID, demoTeacher, demoStudentCount, demoPassword)
+	return streamKey, nil
+}
+
+func seedDemoData() error {
+	hash, err := hashPassword(demoPassword)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	addUser := func(username, name, role string) error {
+		_, err := tx.Exec(`
+			INSERT INTO users (username, name, role, status, password_hash, created_at)
+			VALUES (?, ?, ?, 'active', ?, NOW())
+		`, username, name, role, hash)
+		return err
+	}
+	if err := addUser(demoTeacher, "演示教师", RoleTeacher); err != nil {
+		return err
+	}
+	for i := 1; i <= demoStudentCount; i++ {
+		if err := addUser(fmt.Sprintf("demo_student%d", i), fmt.Sprintf("演示学生 %d", i), RoleStudent); err != nil {
+			return err
+		}
+	}
+
+	for _, q := range demoQuestions {
+		id, err := dialect.insertID(tx, `
+			INSERT INTO questions (course_id, type, content, options, answer, difficulty, estimated_seconds)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, demoCourseID, q.typ, q.content, strings.Join(q.options, ","), q.answer, "easy", 30)
+		if err != nil {
+			return err
+		}
+		if err := saveQuestionTags(tx, int(id), q.tags); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// 用 ffmpeg 生成测试画面和音调推送到演示会话，退出后自动重启
+func runDemoStream(streamKey string) {
+	if livegoStubbed() {
+		log.Printf("Livego stub in use, demo session %s has no video", streamKey)
+		return
+	}
+	for {
+		target := internalRTMPURL(streamKey) + "?" + publishToken(streamKey, time.Now().Add(publishTokenTTL()))
+		cmd := exec.Command(ffmpegPath(), "-hide_banner", "-loglevel", "error", "-re",
+			"-f", "lavfi", "-i", demoTestPatternSrc,
+			"-f", "lavfi", "-i", "sine=frequency=440",
+			"-c:v", "libx264", "-preset", "veryfast", "-tune", "zerolatency", "-g", "50",
+			"-c:a", "aac", "-f", "flv", target)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			log.Printf("Demo stream stopped: %v: %s", err, output)
+		}
+		time.Sleep(demoStreamRestart)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var startedAt = time.Now()
+
+// net/http/pprof 的处理函数，挂在管理员路由下，
+// 例如 /api/admin/debug/pprof/goroutine?debug=2
+func servePprof(c *gin.Context) {
+	switch name := strings.TrimPrefix(c.Param("name"), "/"); name {
+	case "":
+		pprof.Index(c.Writer, c.Request)
+	case "cmdline":
+		pprof.Cmdline(c.Writer, c.Request)
+	case "profile":
+		pprof.Profile(c.Writer, c.Request)
+	case "symbol":
+		pprof.Symbol(c.Writer, c.Request)
+	case "trace":
+		pprof.Trace(c.Writer, c.Request)
+	default:
+		pprof.Handler(name).ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// 运行时状态：goroutine、连接、内存和数据库连接池
+func adminGetDiagnostics(c *gin.Context) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	dbStats := db.Stats()
+
+	respondOK(c, http.StatusOK, gin.H{
+		"go_version":     runtime.Version(),
+		"num_cpu":        runtime.NumCPU(),
+		"uptime_seconds": int64(time.Since(startedAt).Seconds()),
+		"goroutines":     runtime.NumGoroutine(),
+		"hub":            hub.stats(),
+		"livego_stub":    livegoStubbed(),
+		"media_server":   currentConfig().MediaServer.Driver,
+		"memory": gin.H{
+			"alloc_bytes":      mem.Alloc,
+			"heap_inuse_bytes": mem.HeapInuse,
+			"heap_objects":     mem.HeapObjects,
+			"sys_bytes":        mem.Sys,
+			"num_gc":           mem.NumGC,
+			"gc_pause_total":   time.Duration(mem.PauseTotalNs).String(),
+		},
+		"db": gin.H{
+			"open_connections": dbStats.OpenConnections,
+			"in_use":           dbStats.InUse,
+			"idle":             dbStats.Idle,
+			"wait_count":       dbStats.WaitCount,
+			"wait_duration":    dbStats.WaitDuration.String(),
+			"replica":          replica != nil,
+			"replica_healthy":  replicaHealthy.Load(),
+		},
+	})
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// 数据库方言。业务代码中的 SQL 按 MySQL 语法书写并使用 ? 占位符，
+// 方言之间不兼容的语句（自增主键、插入或更新、命名锁等）通过这里生成
+type sqlDialect interface {
+	name() string
+	driverName() string
+	dsn(user, password, host string, port int, dbName string) string
+
+	// 将建表语句转换为当前数据库的 DDL，可能拆分为多条
+	createTable(stmt string) []string
+	columnType(def string) string
+	columnExistsSQL() string
+	indexExistsSQL() string
+
+	// insert 为不带冲突处理的 INSERT 语句；sets 中用 EXCLUDED.col 引用待插入的值
+	upsert(insert string, keys []string, sets ...string) string
+	// 主键或唯一键冲突时忽略
+	insertIgnore(insert string) string
+	groupConcat(expr, orderBy string) string
+	// 执行 INSERT 并返回自增 id
+	insertID(e execer, query string, args ...interface{}) (int64, error)
+	isDuplicate(err error) bool
+
+	// 跨实例的命名锁，与连接绑定
+	acquireLock(ctx context.Context, conn *sql.Conn, name string, wait time.Duration) (bool, error)
+	releaseLock(ctx context.Context, conn *sql.Conn, name string) error
+}
+
+// *sql.DB 和 *sql.Tx 共有的方法
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+var dialect sqlDialect = mysqlDialect{}
+
+func setDialect(driver string) error {
+	switch driver {
+	case "", "mysql":
+		dialect = mysqlDialect{}
+	case "postgres":
+		dialect = postgresDialect{}
+	case "sqlite":
+		dialect = &sqliteDialect{locks: map[string]chan struct{}{}}
+	default:
+		return fmt.Errorf("unknown db_driver %q", driver)
+	}
+	return nil
+}
+
+var excludedPattern = regexp.MustCompile(`EXCLUDED\.(\w+)`)
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) name() string       { return "mysql" }
+func (mysqlDialect) driverName() string { return "mysql" }
+
+func (mysqlDialect) dsn(user, password, host string, port int, dbName string) string {
+	// 连接时区固定为 UTC，NOW() 等函数不再依赖 MySQL 服务器的时区设置
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true&loc=UTC&time_zone=%%27%%2B00%%3A00%%27",
+		user, password, host, port, dbName)
+}
+
+func (mysqlDialect) createTable(stmt string) []string { return []string{stmt} }
+func (mysqlDialect) columnType(def string) string     { return def }
+
+func (mysqlDialect) columnExistsSQL() string {
+	return `SELECT COUNT(*) FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`
+}
+
+func (mysqlDialect) indexExistsSQL() string {
+	return `SELECT COUNT(*) FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?`
+}
+
+func (mysqlDialect) upsert(insert string, keys []string, sets ...string) string {
+	return insert + " ON DUPLICATE KEY UPDATE " + excludedPattern.ReplaceAllString(strings.Join(sets, ", "), "VALUES($1)")
+}
+
+func (mysqlDialect) insertIgnore(insert string) string {
+	return strings.Replace(insert, "INSERT INTO", "INSERT IGNORE INTO", 1)
+}
+
+func (mysqlDialect) groupConcat(expr, orderBy string) string {
+	return fmt.Sprintf("GROUP_CONCAT(%s ORDER BY %s)", expr, orderBy)
+}
+
+func (mysqlDialect) insertID(e execer, query string, args ...interface{}) (int64, error) {
+	result, err := e.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (mysqlDialect) isDuplicate(err error) bool {
+	var me *mysql.MySQLError
+	return errors.As(err, &me) && me.Number == 1062
+}
+
+func (mysqlDialect) acquireLock(ctx context.Context, conn *sql.Conn, name string, wait time.Duration) (bool, error) {
+	var got sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", name, int(wait.Seconds())).Scan(&got); err != nil {
+		return false, err
+	}
+	return got.Valid && got.Int64 == 1, nil
+}
+
+func (mysqlDialect) releaseLock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", name)
+	return err
+}
+
+type postgresDialect struct{}
+
+const pgLockPoll = 100 * time.Millisecond
+
+var (
+	autoIncrementPattern = regexp.MustCompile(`\b(BIG)?INT AUTO_INCREMENT PRIMARY KEY`)
+	datetimePattern      = regexp.MustCompile(`\bDATETIME(\(\d\))?`)
+	tableNamePattern     = regexp.MustCompile(`CREATE TABLE IF NOT EXISTS (\w+)`)
+	indexPattern         = regexp.MustCompile(`^\s*INDEX (\w+) (\(.*\)),?$`)
+	uniqueKeyPattern     = regexp.MustCompile(`^(\s*)UNIQUE KEY \w+ (\(.*\))(,?)$`)
+)
+
+func (postgresDialect) name() string       { return "postgresql" }
+func (postgresDialect) driverName() string { return pgRebindDriver }
+
+func (postgresDialect) dsn(user, password, host string, port int, dbName string) string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(user, password),
+		Host:     fmt.Sprintf("%s:%d", host, port),
+		Path:     "/" + dbName,
+		RawQuery: "timezone=UTC",
+	}
+	return u.String()
+}
+
+func (d postgresDialect) createTable(stmt string) []string {
+	table, indexes := splitIndexes(stmt)
+	return append([]string{d.columnType(table)}, indexes...)
+}
+
+// 将建表语句中的内联索引拆成单独的 CREATE INDEX。
+// PostgreSQL 和 SQLite 的索引名在库内唯一，因此加上表名前缀
+func splitIndexes(stmt string) (string, []string) {
+	m := tableNamePattern.FindStringSubmatch(stmt)
+	if m == nil {
+		return stmt, nil
+	}
+	table := m[1]
+
+	var lines, indexes []string
+	for _, line := range strings.Split(stmt, "\n") {
+		if im := indexPattern.FindStringSubmatch(line); im != nil {
+			indexes = append(indexes, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_%s ON %s %s", table, im[1], table, im[2]))
+			continue
+		}
+		line = uniqueKeyPattern.ReplaceAllString(line, "${1}UNIQUE ${2}${3}")
+		lines = append(lines, line)
+	}
+	// 去掉索引行后，最后一列可能留下多余的逗号
+	for i := len(lines) - 1; i > 0; i-- {
+		if strings.TrimSpace(lines[i]) == ")" {
+			lines[i-1] = strings.TrimSuffix(lines[i-1], ",")
+			break
+		}
+	}
+	return strings.Join(lines, "\n"), indexes
+}
+
+// 自增主键改为 SERIAL，DATETIME 改为 TIMESTAMP
+func (postgresDialect) columnType(def string) string {
+	def = autoIncrementPattern.ReplaceAllStringFunc(def, func(s string) string {
+		if strings.HasPrefix(s, "BIG") {
+			return "BIGSERIAL PRIMARY KEY"
+		}
+		return "SERIAL PRIMARY KEY"
+	})
+	return datetimePattern.ReplaceAllStringFunc(def, func(s string) string {
+		if s == "DATETIME" {
+			return "TIMESTAMP(0)"
+		}
+		return "TIMESTAMP" + strings.TrimPrefix(s, "DATETIME")
+	})
+}
+
+func (postgresDialect) columnExistsSQL() string {
+	return `SELECT COUNT(*) FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?`
+}
+
+func (postgresDialect) indexExistsSQL() string {
+	return "SELECT COUNT(*) FROM pg_indexes WHERE schemaname = current_schema() AND tablename = ? AND indexname = ?"
+}
+
+func (postgresDialect) upsert(insert string, keys []string, sets ...string) string {
+	return fmt.Sprintf("%s ON CONFLICT (%s) DO UPDATE SET %s", insert, strings.Join(keys, ", "), strings.Join(sets, ", "))
+}
+
+func (postgresDialect) insertIgnore(insert string) string {
+	return insert + " ON CONFLICT DO NOTHING"
+}
+
+func (postgresDialect) groupConcat(expr, orderBy string) string {
+	return fmt.Sprintf("STRING_AGG(%s, ',' ORDER BY %s)", expr, orderBy)
+}
+
+func (postgresDialect) insertID(e execer, query string, args ...interface{}) (int64, error) {
+	var id int64
+	err := e.QueryRow(query+" RETURNING id", args...).Scan(&id)
+	return id, err
+}
+
+func (postgresDialect) isDuplicate(err error) bool {
+	var pe *pgconn.PgError
+	return errors.As(err, &pe) && pe.Code == "23505"
+}
+
+// 咨询锁不支持等待超时，按间隔重试
+func (postgresDialect) acquireLock(ctx context.Context, conn *sql.Conn, name string, wait time.Duration) (bool, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		var got bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext(?))", name).Scan(&got); err != nil {
+			return false, err
+		}
+		if got || time.Now().After(deadline) {
+			return got, nil
+		}
+		time.Sleep(pgLockPoll)
+	}
+}
+
+func (postgresDialect) releaseLock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock(hashtext(?))", name)
+	return err
+}
+
+// 单机嵌入式数据库，用于本地开发和演示。db_name 为数据库文件路径
+type sqliteDialect struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+var sqliteAutoIncrementPattern = regexp.MustCompile(`\b(BIG)?INT AUTO_INCREMENT PRIMARY KEY`)
+
+func (*sqliteDialect) name() string       { return "sqlite" }
+func (*sqliteDialect) driverName() string { return sqliteCompatDriver }
+
+// 事务以 IMMEDIATE 方式开启，读后写的事务之间不会因升级写锁而失败
+func (*sqliteDialect) dsn(user, password, host string, port int, dbName string) string {
+	return "file:" + dbName + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)" +
+		"&_time_format=sqlite&_txlock=immediate"
+}
+
+func (d *sqliteDialect) createTable(stmt string) []string {
+	table, indexes := splitIndexes(stmt)
+	return append([]string{d.columnType(table)}, indexes...)
+}
+
+// 自增主键改为 INTEGER PRIMARY KEY；DATETIME 去掉精度，驱动按声明类型解析时间
+func (*sqliteDialect) columnType(def string) string {
+	def = sqliteAutoIncrementPattern.ReplaceAllString(def, "INTEGER PRIMARY KEY AUTOINCREMENT")
+	return datetimePattern.ReplaceAllString(def, "DATETIME")
+}
+
+func (*sqliteDialect) columnExistsSQL() string {
+	return "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?"
+}
+
+func (*sqliteDialect) indexExistsSQL() string {
+	return "SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND name = ?"
+}
+
+func (*sqliteDialect) upsert(insert string, keys []string, sets ...string) string {
+	return postgresDialect{}.upsert(insert, keys, sets...)
+}
+
+func (*sqliteDialect) insertIgnore(insert string) string {
+	return insert + " ON CONFLICT DO NOTHING"
+}
+
+func (*sqliteDialect) groupConcat(expr, orderBy string) string {
+	return mysqlDialect{}.groupConcat(expr, orderBy)
+}
+
+func (*sqliteDialect) insertID(e execer, query string, args ...interface{}) (int64, error) {
+	return mysqlDialect{}.insertID(e, query, args...)
+}
+
+func (*sqliteDialect) isDuplicate(err error) bool {
+	var se *sqlite.Error
+	return errors.As(err, &se) &&
+		(se.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || se.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY)
+}
+
+// 只有一个进程访问数据库文件，命名锁在进程内实现
+func (d *sqliteDialect) acquireLock(ctx context.Context, conn *sql.Conn, name string, wait time.Duration) (bool, error) {
+	d.mu.Lock()
+	ch, ok := d.locks[name]
+	if !ok {
+		ch = make(chan struct{}, 1)
+		d.locks[name] = ch
+	}
+	d.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case ch <- struct{}{}:
+		return true, nil
+	case <-timer.C:
+		return false, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+func (d *sqliteDialect) releaseLock(ctx context.Context, conn *sql.Conn, name string) error {
+	d.mu.Lock()
+	ch := d.locks[name]
+	d.mu.Unlock()
+	select {
+	case <-ch:
+	default:
+	}
+	return nil
+}
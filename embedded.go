@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/Dong557799/zhibo-class/ingest"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultRTMPListen = ":1935"
+
+// 内置 RTMP 服务，media_server.driver 为 embedded 时启动
+var embeddedIngest *ingest.Server
+
+// 启动内置 RTMP 服务，推流鉴权和状态变更直接在进程内完成，无需回调
+func startMediaServer() error {
+	conf := currentConfig().MediaServer
+	if conf.Driver != MediaEmbedded {
+		return nil
+	}
+	addr := conf.RTMPListen
+	if addr == "" {
+		addr = defaultRTMPListen
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	srv := ingest.NewServer()
+	srv.OnPublish = func(app, name, query string) error {
+		if app != mediaApp {
+			return errInvalidStreamPath
+		}
+		if _, code := applyStreamEvent(StreamEvent{StreamKey: name, Status: "start", Query: query}); code != "" {
+			return errors.New(string(code))
+		}
+		return nil
+	}
+	srv.OnUnpublish = func(app, name string) {
+		if app == mediaApp {
+			applyStreamEvent(StreamEvent{StreamKey: name, Status: "stop"})
+		}
+	}
+	embeddedIngest = srv
+	go func() {
+		if err := srv.Serve(ln); err != nil {
+			log.Printf("Embedded RTMP server stopped: %v", err)
+		}
+	}()
+	log.Printf("Embedded RTMP server listening on %s", addr)
+	return nil
+}
+
+// 内置服务的 HTTP-FLV 播放，地址为 /live/<stream_key>.flv?token=<播放令牌>，
+// 与 CDN 回源鉴权一样校验播放令牌和网络限制
+func serveEmbeddedFLV(c *gin.Context) {
+	name, ok := strings.CutSuffix(c.Param("file"), ".flv")
+	if embeddedIngest == nil || !ok {
+		respondError(c, http.StatusNotFound, CodeStreamNotFound)
+		return
+	}
+	if _, ok := authorizeStreamToken(c, c.Query("token"), name); !ok {
+		return
+	}
+	if err := embeddedIngest.ServeFLV(c.Writer, c.Request, mediaApp, name); err == ingest.ErrStreamNotFound {
+		respondError(c, http.StatusNotFound, CodeStreamNotFound)
+	}
+}
+
+// 内置 RTMP 服务：按需创建流，不需要单独部署 Livego，只提供 RTMP 和 HTTP-FLV 播放
+type embeddedServer struct {
+	conf MediaServerConfig
+}
+
+// 推流码在推流时校验，无需登记
+func (s embeddedServer) CreateStream(ctx context.Context, streamKey string) error {
+	return nil
+}
+
+func (s embeddedServer) DeleteStream(ctx context.Context, streamKey string) error {
+	if embeddedIngest != nil {
+		embeddedIngest.Drop(mediaApp, streamKey)
+	}
+	return nil
+}
+
+func (s embeddedServer) GetStats(ctx context.Context) ([]StreamStats, error) {
+	stats := []StreamStats{}
+	if embeddedIngest == nil {
+		return stats, nil
+	}
+	for _, st := range embeddedIngest.Streams() {
+		if st.App != mediaApp {
+			continue
+		}
+		stats = append(stats, StreamStats{
+			StreamKey:   st.Name,
+			Clients:     st.Clients,
+			BitrateKbps: st.BitrateKbps,
+			VideoCodec:  st.VideoCodec,
+			AudioCodec:  st.AudioCodec,
+		})
+	}
+	return stats, nil
+}
+
+// 内置服务不经过 HTTP 回调
+func (s embeddedServer) ParseCallback(c *gin.Context) (StreamEvent, error) {
+	return StreamEvent{}, errInvalidStreamPath
+}
+
+func (s embeddedServer) RespondCallback(c *gin.Context, status int, code ErrorCode) {
+	respondMediaCallback(c, status, code)
+}
+
+func (s embeddedServer) RTMPURL() string {
+	if s.conf.RTMPURL != "" {
+		return strings.TrimSuffix(s.conf.RTMPURL, "/")
+	}
+	port := defaultRTMPPort
+	if _, p, err := net.SplitHostPort(s.conf.RTMPListen); err == nil && p != "" {
+		port = p
+	}
+	return fmt.Sprintf("rtmp://localhost:%s/%s", port, mediaApp)
+}
+
+// 未配置 play_url 时返回本服务的相对地址
+func (s embeddedServer) PlayURLs(streamKey string) map[string]string {
+	play := strings.TrimSuffix(s.conf.PlayURL, "/")
+	if play == "" {
+		play = "/" + mediaApp
+	}
+	return map[string]string{
+		"rtmp": s.RTMPURL() + "/" + streamKey,
+		"flv":  play + "/" + streamKey + ".flv",
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	errorReportQueue   = 64
+	errorReportTimeout = 5 * time.Second
+	maxStackFrames     = 64
+	requestIDHeader    = "X-Request-ID"
+)
+
+// 错误上报，dsn 为 Sentry 格式（https://public_key@host/project_id），
+// 兼容 Sentry 的服务（如 GlitchTip）均可使用；为空时只写日志
+type ErrorReportingConfig struct {
+	DSN         string `json:"dsn"`
+	Environment string `json:"environment"` // 如 production、staging
+	Release     string `json:"release"`     // 部署的版本号
+}
+
+// 从 DSN 解析出的上报地址和密钥
+type sentryDSN struct {
+	storeURL  string
+	publicKey string
+	secretKey string
+}
+
+func parseSentryDSN(dsn string) (sentryDSN, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User == nil || u.User.Username() == "" {
+		return sentryDSN{}, fmt.Errorf("invalid error_reporting dsn")
+	}
+	p := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(p, "/")
+	if i < 0 || p[i+1:] == "" {
+		return sentryDSN{}, fmt.Errorf("error_reporting dsn has no project id")
+	}
+	secret, _ := u.User.Password()
+	return sentryDSN{
+		storeURL:  fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, p[:i], p[i+1:]),
+		publicKey: u.User.Username(),
+		secretKey: secret,
+	}, nil
+}
+
+func validateErrorReporting(conf ErrorReportingConfig) error {
+	if conf.DSN == "" {
+		return nil
+	}
+	_, err := parseSentryDSN(conf.DSN)
+	return err
+}
+
+// Sentry 事件，只包含用到的字段
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Transaction string            `json:"transaction,omitempty"`
+	Exception   sentryExceptions  `json:"exception"`
+	Request     *sentryRequest    `json:"request,omitempty"`
+	User        *sentryUser       `json:"user,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+type sentryExceptions struct {
+	Values []sentryException `json:"values"`
+}
+
+type sentryException struct {
+	Type       string           `json:"type"`
+	Value      string           `json:"value"`
+	Stacktrace sentryStacktrace `json:"stacktrace"`
+}
+
+type sentryStacktrace struct {
+	Frames []sentryFrame `json:"frames"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+type sentryRequest struct {
+	URL         string            `json:"url"`
+	Method      string            `json:"method"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+type sentryUser struct {
+	ID string `json:"id"`
+}
+
+// 随事件上报的请求头，不包含 Authorization、Cookie 等凭据
+var reportedHeaders = []string{"User-Agent", "Referer", "Content-Type", "Accept-Language", "X-Response-Format"}
+
+const filteredValue = "[Filtered]"
+
+// 上报前隐去取值的查询参数：WebSocket、播放和登录回调的令牌，OAuth、LTI 的授权码和启动参数，
+// 以及推流回调、签名链接中的密钥；名称含 token、secret、password 的参数也一并隐去
+var sensitiveParams = map[string]bool{
+	"access_token":     true,
+	"code":             true,
+	"id_token":         true,
+	"key":              true,
+	"login_hint":       true,
+	"lti_message_hint": true,
+	"nonce":            true,
+	"password":         true,
+	"secret":           true,
+	"sig":              true,
+	"sign":             true,
+	"signature":        true,
+	"state":            true,
+	"token":            true,
+	"ws_token":         true,
+}
+
+func sensitiveParam(name string) bool {
+	name = strings.ToLower(name)
+	return sensitiveParams[name] || strings.Contains(name, "token") || strings.Contains(name, "secret") || strings.Contains(name, "password")
+}
+
+// 隐去查询字符串中敏感参数的取值，其余参数保持原样和顺序
+func redactQuery(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	parts := strings.Split(raw, "&")
+	for i, part := range parts {
+		name, _, _ := strings.Cut(part, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if sensitiveParam(name) {
+			parts[i] = url.QueryEscape(name) + "=" + filteredValue
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// 请求头中可能带有凭据的部分：Referer 的查询参数、片段（OAuth 隐式授权把令牌放在片段中）和用户信息
+func redactHeader(name, value string) string {
+	if name != "Referer" {
+		return value
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return filteredValue
+	}
+	u.RawQuery = redactQuery(u.RawQuery)
+	u.Fragment, u.RawFragment = "", ""
+	u.User = nil
+	return u.String()
+}
+
+var errorReports = make(chan sentryEvent, errorReportQueue)
+
+// 依次发送上报队列中的事件，上报失败只写日志
+func runErrorReporter() {
+	for event := range errorReports {
+		conf := currentConfig().ErrorReporting
+		if conf.DSN == "" {
+			continue
+		}
+		dsn, err := parseSentryDSN(conf.DSN)
+		if err != nil {
+			continue
+		}
+		if err := sendErrorReport(dsn, event); err != nil {
+			log.Printf("Failed to report error %s: %v", event.EventID, err)
+		}
+	}
+}
+
+func sendErrorReport(dsn sentryDSN, event sentryEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), errorReportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dsn.storeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=%s/1.0, sentry_key=%s", defaultServiceName, dsn.publicKey)
+	if dsn.secretKey != "" {
+		auth += ", sentry_secret=" + dsn.secretKey
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", auth)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", dsn.storeURL, resp.Status)
+	}
+	return nil
+}
+
+// 把 panic 加入上报队列，未配置 dsn 或队列已满时丢弃；c 为空表示后台任务中的 panic
+func reportPanic(c *gin.Context, r interface{}, frames []sentryFrame, tags map[string]string) {
+	conf := currentConfig().ErrorReporting
+	if conf.DSN == "" {
+		return
+	}
+	host, _ := os.Hostname()
+	event := sentryEvent{
+		EventID:     newEventID(),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Level:       "fatal",
+		Platform:    "go",
+		ServerName:  host,
+		Environment: conf.Environment,
+		Release:     conf.Release,
+		Exception: sentryExceptions{Values: []sentryException{{
+			Type:       fmt.Sprintf("%T", r),
+			Value:      fmt.Sprint(r),
+			Stacktrace: sentryStacktrace{Frames: frames},
+		}}},
+		Tags: tags,
+	}
+	if c != nil {
+		event.Transaction = c.Request.Method + " " + c.FullPath()
+		event.Request = &sentryRequest{
+			URL:         c.Request.URL.Path,
+			Method:      c.Request.Method,
+			QueryString: redactQuery(c.Request.URL.RawQuery),
+			Headers:     map[string]string{},
+		}
+		for _, name := range reportedHeaders {
+			if v := c.GetHeader(name); v != "" {
+				event.Request.Headers[name] = redactHeader(name, v)
+			}
+		}
+		if user := currentUser(c); user != nil {
+			event.User = &sentryUser{ID: fmt.Sprint(user.ID)}
+		}
+	}
+	select {
+	case errorReports <- event:
+	default:
+		log.Printf("Error report queue full, dropping event %s", event.EventID)
+	}
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 在 recover 所在的 defer 中调用，返回 panic 位置的调用栈，按 Sentry 的要求从外层到内层排列
+func panicFrames() []sentryFrame {
+	pcs := make([]uintptr, maxStackFrames)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var out []sentryFrame
+	for {
+		f, more := frames.Next()
+		// 跳过 runtime 中处理 panic 的帧
+		if !strings.HasPrefix(f.Function, "runtime.") {
+			module, function := splitFunction(f.Function)
+			out = append(out, sentryFrame{
+				Function: function,
+				Module:   module,
+				AbsPath:  f.File,
+				Lineno:   f.Line,
+				InApp:    module == "main" || strings.HasPrefix(module, "github.com/Dong557799/zhibo-class"),
+			})
+		}
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// 拆分 runtime.Frame.Function 为包路径和函数名，如 github.com/a/b.(*T).M
+func splitFunction(name string) (module, function string) {
+	slash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[slash+1:], ".")
+	if dot < 0 {
+		return "", name
+	}
+	return name[:slash+1+dot], name[slash+1+dot+1:]
+}
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// 为请求分配请求 ID：优先使用网关传入的 X-Request-ID，其次为 trace id，都没有时随机生成；
+// 请求 ID 写入响应头，错误响应中也会带上，便于按请求排查
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = traceID(c)
+		}
+		if id == "" {
+			id = newEventID()
+		}
+		c.Set("request_id", id)
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// 客户端断开导致的写入失败，无需上报
+func brokenPipe(r interface{}) bool {
+	err, ok := r.(error)
+	if !ok {
+		return false
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && (errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET))
+}
+
+// 捕获处理请求时的 panic：记录调用栈并上报，返回带请求 ID 的 500，替代 gin 默认的恢复中间件
+func recoverPanics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			if brokenPipe(r) {
+				log.Printf("Connection closed while serving %s %s: %v", c.Request.Method, c.Request.URL.Path, r)
+				c.Abort()
+				return
+			}
+			frames := panicFrames()
+			requestID := c.GetString("request_id")
+			log.Printf("Panic serving %s %s (request %s): %v\n%s", c.Request.Method, c.Request.URL.Path, requestID, r, debug.Stack())
+
+			span := trace.SpanFromContext(c.Request.Context())
+			span.RecordError(fmt.Errorf("panic: %v", r))
+			span.SetStatus(codes.Error, "panic")
+			reportPanic(c, r, frames, map[string]string{"request_id": requestID, "trace_id": traceID(c)})
+
+			// 已经开始写响应时无法再返回错误
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			respondError(c, http.StatusInternalServerError, CodeInternal)
+			c.Abort()
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+// 领域事件类型
+const (
+	EventSessionCreated     = "session.created"
+	EventSessionStarted     = "session.started"
+	EventSessionInterrupted = "session.interrupted" // 连续多次查不到推流
+	EventSessionResumed     = "session.resumed"
+	EventSessionEnded       = "session.ended"
+	EventSessionTransferred = "session.transferred"
+	EventSessionCancelled   = "session.cancelled" // 附带课程的已支付订单，供计费模块处理退款
+	EventQuestionPushed     = "question.pushed"
+	EventAnswerSubmitted    = "answer.submitted"
+	EventOrderPaid          = "order.paid"
+)
+
+const (
+	eventBufferSize   = 1024
+	eventWriteTimeout = 5 * time.Second
+)
+
+// 事件总线配置，driver 为空时不启用
+type EventBusConfig struct {
+	Driver  string   `json:"driver"`  // kafka 或 nats
+	Brokers []string `json:"brokers"` // kafka 为 host:port 列表，nats 为 nats://host:port 列表
+	Topic   string   `json:"topic"`   // kafka topic 或 nats subject
+}
+
+// 领域事件，供下游分析和数仓消费
+type DomainEvent struct {
+	ID   string      `json:"id"`
+	Type string      `json:"type"`
+	Key  string      `json:"key"` // 分区键，同一课程的事件保持顺序
+	Data interface{} `json:"data"`
+	Time time.Time   `json:"time"`
+}
+
+// 事件发布后端
+type eventPublisher interface {
+	publish(key string, payload []byte) error
+	close() error
+}
+
+var eventQueue chan DomainEvent
+
+// 连接事件总线并启动后台发布
+func startEventBus() error {
+	cfg := currentConfig().EventBus
+	if cfg.Driver == "" {
+		return nil
+	}
+	if len(cfg.Brokers) == 0 || cfg.Topic == "" {
+		return fmt.Errorf("event bus requires brokers and topic")
+	}
+
+	var publisher eventPublisher
+	switch cfg.Driver {
+	case "kafka":
+		publisher = &kafkaPublisher{writer: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Topic:        cfg.Topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			WriteTimeout: eventWriteTimeout,
+		}}
+	case "nats":
+		conn, err := nats.Connect(strings.Join(cfg.Brokers, ","),
+			nats.Name("zhibo-class"),
+			nats.MaxReconnects(-1),
+		)
+		if err != nil {
+			return err
+		}
+		publisher = &natsPublisher{conn: conn, subject: cfg.Topic}
+	default:
+		return fmt.Errorf("unsupported event bus driver %q", cfg.Driver)
+	}
+
+	eventQueue = make(chan DomainEvent, eventBufferSize)
+	go runEventPublisher(publisher)
+	return nil
+}
+
+// 提交领域事件，不阻塞请求处理；队列已满时丢弃
+func emitEvent(eventType string, courseID int, data interface{}) {
+	if eventQueue == nil {
+		return
+	}
+
+	event := DomainEvent{
+		ID:   generateRandomString(16),
+		Type: eventType,
+		Key:  fmt.Sprintf("course:%d", courseID),
+		Data: data,
+		Time: time.Now(),
+	}
+	select {
+	case eventQueue <- event:
+	default:
+		log.Printf("Dropped %s event: queue full", eventType)
+	}
+}
+
+func runEventPublisher(publisher eventPublisher) {
+	defer publisher.close()
+
+	for event := range eventQueue {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("Failed to encode %s event: %v", event.Type, err)
+			continue
+		}
+		if err := publisher.publish(event.Key, payload); err != nil {
+			log.Printf("Failed to publish %s event: %v", event.Type, err)
+		}
+	}
+}
+
+type kafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func (p *kafkaPublisher) publish(key string, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), eventWriteTimeout)
+	defer cancel()
+	return p.writer.WriteMessages(ctx, kafka.Message{Key: []byte(key), Value: payload})
+}
+
+func (p *kafkaPublisher) close() error {
+	return p.writer.Close()
+}
+
+type natsPublisher struct {
+	conn    *nats.Conn
+	subject string
+}
+
+func (p *natsPublisher) publish(key string, payload []byte) error {
+	return p.conn.Publish(p.subject, payload)
+}
+
+func (p *natsPublisher) close() error {
+	return p.conn.Drain()
+}
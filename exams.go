@@ -0,0 +1,792 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Dong557799/zhibo-class/apperr"
+	"github.com/Dong557799/zhibo-class/query"
+	"github.com/gin-gonic/gin"
+)
+
+// 测验状态
+const (
+	ExamDraft   = "draft"
+	ExamRunning = "running"
+	ExamClosed  = "closed"
+)
+
+const jobExamClose = "exam.close"
+
+var errExamSubmitted = domainError(apperr.ErrConflict, CodeExamAlreadySubmitted)
+
+// 课堂测验：多道题作为一个整体推送，统一限时
+type Exam struct {
+	ID               int        `json:"id"`
+	CourseID         int        `json:"course_id"`
+	SessionID        *int       `json:"session_id,omitempty"`
+	Title            string     `json:"title"`
+	TimeLimitSeconds int        `json:"time_limit_seconds"`
+	SingleDevice     bool       `json:"single_device"` // 学生开始作答后只能在同一台登录设备上继续
+	Status           string     `json:"status"`
+	QuestionIDs      []int      `json:"question_ids"`
+	CreatedBy        int        `json:"created_by"`
+	CreatedAt        time.Time  `json:"created_at"`
+	StartedAt        *time.Time `json:"started_at,omitempty"`
+	EndsAt           *time.Time `json:"ends_at,omitempty"`
+	ClosedAt         *time.Time `json:"closed_at,omitempty"`
+}
+
+func (e *Exam) inLocation(loc *time.Location) {
+	e.CreatedAt = e.CreatedAt.In(loc)
+	e.StartedAt = inLocation(e.StartedAt, loc)
+	e.EndsAt = inLocation(e.EndsAt, loc)
+	e.ClosedAt = inLocation(e.ClosedAt, loc)
+}
+
+// 学生的作答进度与成绩
+type ExamAttempt struct {
+	StudentID     int        `json:"student_id"`
+	Answered      int        `json:"answered"`
+	Score         int        `json:"score"`  // 答对题数，交卷后有效
+	Points        float64    `json:"points"` // 按计分方式累计的得分，包含多选题的部分得分
+	AutoSubmitted bool       `json:"auto_submitted"`
+	Makeup        bool       `json:"makeup"` // 结束后补答
+	StartedAt     time.Time  `json:"started_at"`
+	SubmittedAt   *time.Time `json:"submitted_at,omitempty"`
+}
+
+// 单题在本次测验中的答对情况，补答单独统计
+type ExamQuestionStat struct {
+	QuestionID         int     `json:"question_id"`
+	AnswerCount        int     `json:"answer_count"`
+	CorrectCount       int     `json:"correct_count"`
+	AverageCredit      float64 `json:"average_credit"` // 平均得分，包含部分得分，不含补答
+	MakeupAnswerCount  int     `json:"makeup_answer_count"`
+	MakeupCorrectCount int     `json:"makeup_correct_count"`
+}
+
+func init() {
+	// 到时自动收卷
+	jobHandlers[jobExamClose] = func(ctx context.Context, payload json.RawMessage) error {
+		var p struct {
+			ExamID int `json:"exam_id"`
+		}
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return closeExam(p.ExamID)
+	}
+}
+
+func loadExam(id int) (Exam, error) {
+	var exam Exam
+	var sessionID sql.NullInt64
+	err := db.QueryRow(`
+		SELECT id, course_id, session_id, title, time_limit_seconds, single_device, status, created_by, created_at, started_at, ends_at, closed_at
+		FROM exams WHERE id = ?
+	`, id).Scan(&exam.ID, &exam.CourseID, &sessionID, &exam.Title, &exam.TimeLimitSeconds, &exam.SingleDevice, &exam.Status,
+		&exam.CreatedBy, &exam.CreatedAt, &exam.StartedAt, &exam.EndsAt, &exam.ClosedAt)
+	if err != nil {
+		return exam, err
+	}
+	if sessionID.Valid {
+		v := int(sessionID.Int64)
+		exam.SessionID = &v
+	}
+
+	rows, err := db.Query("SELECT question_id FROM exam_questions WHERE exam_id = ? ORDER BY position", id)
+	if err != nil {
+		return exam, err
+	}
+	defer rows.Close()
+	exam.QuestionIDs = []int{}
+	for rows.Next() {
+		var qid int
+		if err := rows.Scan(&qid); err != nil {
+			return exam, err
+		}
+		exam.QuestionIDs = append(exam.QuestionIDs, qid)
+	}
+	return exam, rows.Err()
+}
+
+// 查询测验，失败时写入错误响应
+func examParam(c *gin.Context) (Exam, bool) {
+	id, ok := intParam(c, "id")
+	if !ok {
+		return Exam{}, false
+	}
+	exam, err := loadExam(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, CodeExamNotFound)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeExamGetFailed)
+		}
+		return exam, false
+	}
+	return exam, true
+}
+
+// 按测验中的顺序加载题目
+func loadExamQuestions(examID int) ([]Question, error) {
+	rows, err := db.Query(`
+		SELECT q.id, q.course_id, q.type, q.content, q.options, q.answer, q.difficulty, q.estimated_seconds, q.status,
+			COALESCE(q.rich_content, '')
+		FROM exam_questions eq
+		JOIN questions q ON q.id = eq.question_id
+		WHERE eq.exam_id = ?
+		ORDER BY eq.position
+	`, examID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	questions := []Question{}
+	for rows.Next() {
+		var q Question
+		var options, richContent string
+		if err := rows.Scan(&q.ID, &q.CourseID, &q.Type, &q.Content, &options, &q.Answer, &q.Difficulty, &q.EstimatedSeconds, &q.Status,
+			&richContent); err != nil {
+			return nil, err
+		}
+		q.Options = splitList(options)
+		q.Blocks = decodeQuestionBlocks(context.Background(), richContent)
+		questions = append(questions, q)
+	}
+	return questions, rows.Err()
+}
+
+func studentQuestions(questions []Question) []gin.H {
+	list := make([]gin.H, 0, len(questions))
+	for _, q := range questions {
+		list = append(list, studentQuestion(q))
+	}
+	return list
+}
+
+// 创建测验，题目须属于同一课程
+func createExam(c *gin.Context) {
+	var req struct {
+		CourseID         int    `json:"course_id" binding:"required"`
+		SessionID        *int   `json:"session_id"`
+		Title            string `json:"title" binding:"required,max=255"`
+		TimeLimitSeconds int    `json:"time_limit_seconds" binding:"required,min=10,max=86400"`
+		QuestionIDs      []int  `json:"question_ids" binding:"required,min=1,max=100"`
+		SingleDevice     bool   `json:"single_device"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	// 去重并保持顺序
+	seen := make(map[int]bool)
+	ids := []int{}
+	args := []interface{}{req.CourseID}
+	for _, id := range req.QuestionIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+			args = append(args, id)
+		}
+	}
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM questions WHERE course_id = ? AND id IN ("+query.Placeholders(len(ids))+")", args...).Scan(&count)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeExamCreateFailed)
+		return
+	}
+	if count != len(ids) {
+		respondError(c, http.StatusBadRequest, CodeExamQuestionInvalid)
+		return
+	}
+
+	exam := Exam{
+		CourseID:         req.CourseID,
+		SessionID:        req.SessionID,
+		Title:            req.Title,
+		TimeLimitSeconds: req.TimeLimitSeconds,
+		SingleDevice:     req.SingleDevice,
+		Status:           ExamDraft,
+		QuestionIDs:      ids,
+		CreatedBy:        currentUser(c).ID,
+		CreatedAt:        time.Now().UTC(),
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeExamCreateFailed)
+		return
+	}
+	defer tx.Rollback()
+
+	id, err := dialect.insertID(tx, `
+		INSERT INTO exams (course_id, session_id, title, time_limit_seconds, single_device, status, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, exam.CourseID, exam.SessionID, exam.Title, exam.TimeLimitSeconds, exam.SingleDevice, exam.Status, exam.CreatedBy, exam.CreatedAt)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeExamCreateFailed)
+		return
+	}
+	exam.ID = int(id)
+
+	for i, qid := range ids {
+		if _, err := tx.Exec("INSERT INTO exam_questions (exam_id, question_id, position) VALUES (?, ?, ?)", exam.ID, qid, i); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeExamCreateFailed)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeExamCreateFailed)
+		return
+	}
+
+	recordAudit(c, "create_exam", "exam", exam.ID, gin.H{"question_ids": ids})
+	exam.inLocation(requestLocation(c))
+	respondOK(c, http.StatusCreated, exam)
+}
+
+// 测验详情：老师看到完整题目，学生在开始后看到不含答案的题目和自己的进度
+func getExam(c *gin.Context) {
+	exam, ok := examParam(c)
+	if !ok {
+		return
+	}
+	questions, err := loadExamQuestions(exam.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeExamGetFailed)
+		return
+	}
+
+	user := currentUser(c)
+	exam.inLocation(requestLocation(c))
+	if user.can(PermQuestionCreate) {
+		respondOK(c, http.StatusOK, gin.H{"exam": exam, "questions": questions})
+		return
+	}
+	if exam.Status == ExamDraft {
+		respondError(c, http.StatusConflict, CodeExamNotRunning)
+		return
+	}
+
+	answers, err := loadExamAnswers(exam.ID, user.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeExamGetFailed)
+		return
+	}
+	var submittedAt *time.Time
+	err = db.QueryRow("SELECT submitted_at FROM exam_attempts WHERE exam_id = ? AND student_id = ?", exam.ID, user.ID).Scan(&submittedAt)
+	if err != nil && err != sql.ErrNoRows {
+		respondError(c, http.StatusInternalServerError, CodeExamGetFailed)
+		return
+	}
+	makeupDeadline, err := activeMakeup(MakeupExam, exam.ID, user.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeExamGetFailed)
+		return
+	}
+
+	respondOK(c, http.StatusOK, gin.H{
+		"exam":            exam,
+		"questions":       studentQuestions(questions),
+		"answers":         answers,
+		"submitted":       submittedAt != nil,
+		"makeup_deadline": inLocation(makeupDeadline, requestLocation(c)),
+	})
+}
+
+// 学生已保存的答案，question_id -> answer
+func loadExamAnswers(examID, studentID int) (map[int]string, error) {
+	rows, err := db.Query("SELECT question_id, answer FROM exam_answers WHERE exam_id = ? AND student_id = ?", examID, studentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	answers := make(map[int]string)
+	for rows.Next() {
+		var qid int
+		var answer string
+		if err := rows.Scan(&qid, &answer); err != nil {
+			return nil, err
+		}
+		answers[qid] = answer
+	}
+	return answers, rows.Err()
+}
+
+// 开始测验，推送全部题目并在到时后自动收卷
+func startExam(c *gin.Context) {
+	exam, ok := examParam(c)
+	if !ok {
+		return
+	}
+	questions, err := loadExamQuestions(exam.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeExamStartFailed)
+		return
+	}
+	// 测验可以包含草稿题目，开始前需要全部发布
+	for _, q := range questions {
+		if q.Status == QuestionDraft {
+			respondError(c, http.StatusConflict, CodeQuestionDraft)
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	endsAt := now.Add(time.Duration(exam.TimeLimitSeconds) * time.Second)
+	result, err := db.Exec(`
+		UPDATE exams SET status = ?, started_at = ?, ends_at = ?
+		WHERE id = ? AND status = ?
+	`, ExamRunning, now, endsAt, exam.ID, ExamDraft)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeExamStartFailed)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		respondError(c, http.StatusConflict, CodeExamAlreadyStarted)
+		return
+	}
+	exam.Status, exam.StartedAt, exam.EndsAt = ExamRunning, &now, &endsAt
+
+	if _, err := enqueueJob(jobExamClose, gin.H{"exam_id": exam.ID}, endsAt); err != nil {
+		log.Printf("Failed to schedule close for exam %d: %v", exam.ID, err)
+	}
+
+	data := gin.H{
+		"exam_id":            exam.ID,
+		"title":              exam.Title,
+		"time_limit_seconds": exam.TimeLimitSeconds,
+		"ends_at":            endsAt,
+		"questions":          studentQuestions(questions),
+	}
+	hub.broadcast(courseRoom(exam.CourseID), Message{Type: "exam_started", Data: data})
+	publishMQTT(exam.CourseID, "exam", data)
+
+	recordAudit(c, "start_exam", "exam", exam.ID, nil)
+	exam.inLocation(requestLocation(c))
+	respondOK(c, http.StatusOK, exam)
+}
+
+// 学生保存单题答案，交卷前可修改
+func saveExamAnswer(c *gin.Context) {
+	exam, ok := examParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		QuestionID int    `json:"question_id" binding:"required"`
+		Answer     string `json:"answer" binding:"required,max=255"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	user := currentUser(c)
+	studentID := user.ID
+	if _, ok := examAnswerMode(c, exam, studentID); !ok {
+		return
+	}
+	if !containsInt(exam.QuestionIDs, req.QuestionID) {
+		respondError(c, http.StatusBadRequest, CodeExamQuestionInvalid)
+		return
+	}
+
+	now := time.Now().UTC()
+	tx, err := db.Begin()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeExamAnswerFailed)
+		return
+	}
+	defer tx.Rollback()
+
+	device := ""
+	if exam.SingleDevice {
+		device = user.LoginSessionID
+	}
+	submittedAt, bound, err := lockExamAttempt(tx, exam.ID, studentID, device, now)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeExamAnswerFailed)
+		return
+	}
+	if submittedAt != nil {
+		respondError(c, http.StatusConflict, CodeExamAlreadySubmitted)
+		return
+	}
+	if device != "" && bound != device {
+		respondError(c, http.StatusConflict, CodeExamOtherDevice)
+		return
+	}
+
+	_, err = tx.Exec(dialect.upsert(`
+		INSERT INTO exam_answers (exam_id, student_id, question_id, answer, answered_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		[]string{"exam_id", "student_id", "question_id"},
+		"answer = EXCLUDED.answer", "answered_at = EXCLUDED.answered_at",
+	), exam.ID, studentID, req.QuestionID, req.Answer, now)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeExamAnswerFailed)
+		return
+	}
+	var answered int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM exam_answers WHERE exam_id = ? AND student_id = ?", exam.ID, studentID).Scan(&answered); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeExamAnswerFailed)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeExamAnswerFailed)
+		return
+	}
+
+	progress := gin.H{"exam_id": exam.ID, "student_id": studentID, "answered": answered, "total": len(exam.QuestionIDs)}
+	if exam.SessionID != nil {
+		hub.broadcast(teacherRoom(*exam.SessionID), Message{Type: "exam_progress", From: studentID, Data: progress})
+	}
+	respondOK(c, http.StatusOK, progress)
+}
+
+// 测验进行中且未到截止时间，或学生有有效的补答授权；makeup 表示按补答处理
+func examAnswerMode(c *gin.Context, exam Exam, studentID int) (makeup bool, ok bool) {
+	sessionID := 0
+	if exam.SessionID != nil {
+		sessionID = *exam.SessionID
+	}
+	if !requireCourseAccess(c, exam.CourseID, sessionID, studentID) {
+		return false, false
+	}
+	if exam.SingleDevice {
+		var bound string
+		err := db.QueryRow("SELECT login_session_id FROM exam_attempts WHERE exam_id = ? AND student_id = ?", exam.ID, studentID).Scan(&bound)
+		if err != nil && err != sql.ErrNoRows {
+			respondError(c, http.StatusInternalServerError, CodeExamGetFailed)
+			return false, false
+		}
+		// 旧令牌没有设备ID，无法限制
+		if device := currentUser(c).LoginSessionID; bound != "" && device != "" && bound != device {
+			respondError(c, http.StatusConflict, CodeExamOtherDevice)
+			return false, false
+		}
+	}
+	if exam.Status == ExamRunning && exam.EndsAt != nil && time.Now().Before(*exam.EndsAt) {
+		return false, true
+	}
+	if exam.Status != ExamDraft {
+		deadline, err := activeMakeup(MakeupExam, exam.ID, studentID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeExamGetFailed)
+			return false, false
+		}
+		if deadline != nil {
+			return true, true
+		}
+	}
+	respondError(c, http.StatusConflict, CodeExamNotRunning)
+	return false, false
+}
+
+// 创建并锁定学生的作答记录，返回交卷时间（未交卷时为 nil）和绑定的登录设备。
+// device 不为空时将尚未绑定的作答记录绑定到该设备
+func lockExamAttempt(tx *sql.Tx, examID, studentID int, device string, now time.Time) (submittedAt *time.Time, bound string, err error) {
+	if _, err := tx.Exec(dialect.insertIgnore("INSERT INTO exam_attempts (exam_id, student_id, started_at, login_session_id) VALUES (?, ?, ?, ?)"),
+		examID, studentID, now, device); err != nil {
+		return nil, "", err
+	}
+	if device != "" {
+		if _, err := tx.Exec("UPDATE exam_attempts SET login_session_id = ? WHERE exam_id = ? AND student_id = ? AND login_session_id = ''",
+			device, examID, studentID); err != nil {
+			return nil, "", err
+		}
+	}
+	err = tx.QueryRow("SELECT submitted_at, login_session_id FROM exam_attempts WHERE exam_id = ? AND student_id = ? FOR UPDATE", examID, studentID).
+		Scan(&submittedAt, &bound)
+	return submittedAt, bound, err
+}
+
+func containsInt(list []int, value int) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// 学生交卷
+func submitExam(c *gin.Context) {
+	exam, ok := examParam(c)
+	if !ok {
+		return
+	}
+	studentID := currentUser(c).ID
+	makeup, ok := examAnswerMode(c, exam, studentID)
+	if !ok {
+		return
+	}
+
+	attempt, err := finalizeExamAttempt(exam, studentID, false, makeup)
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+
+	loc := requestLocation(c)
+	attempt.StartedAt = attempt.StartedAt.In(loc)
+	attempt.SubmittedAt = inLocation(attempt.SubmittedAt, loc)
+	respondOK(c, http.StatusOK, attempt)
+}
+
+// 判分并将答案写入答题记录，auto 表示到时自动收卷，makeup 表示补答
+func finalizeExamAttempt(exam Exam, studentID int, auto, makeup bool) (ExamAttempt, error) {
+	attempt := ExamAttempt{StudentID: studentID, AutoSubmitted: auto, Makeup: makeup}
+	now := time.Now().UTC()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return attempt, err
+	}
+	defer tx.Rollback()
+
+	submittedAt, _, err := lockExamAttempt(tx, exam.ID, studentID, "", now)
+	if err != nil {
+		return attempt, err
+	}
+	if submittedAt != nil {
+		return attempt, errExamSubmitted
+	}
+
+	rows, err := tx.Query(`
+		SELECT ea.question_id, ea.answer, q.type, q.scoring, q.answer
+		FROM exam_answers ea
+		JOIN questions q ON q.id = ea.question_id
+		WHERE ea.exam_id = ? AND ea.student_id = ?
+	`, exam.ID, studentID)
+	if err != nil {
+		return attempt, err
+	}
+	type gradedAnswer struct {
+		questionID int
+		answer     string
+		credit     float64
+		correct    bool
+	}
+	var graded []gradedAnswer
+	for rows.Next() {
+		var a gradedAnswer
+		var questionType, scoring, correctAnswer string
+		if err := rows.Scan(&a.questionID, &a.answer, &questionType, &scoring, &correctAnswer); err != nil {
+			rows.Close()
+			return attempt, err
+		}
+		a.answer = normalizeAnswer(questionType, a.answer)
+		a.credit = answerCredit(questionType, scoring, correctAnswer, a.answer)
+		a.correct = a.credit == 1
+		graded = append(graded, a)
+	}
+	rows.Close()
+
+	for _, a := range graded {
+		_, err := tx.Exec(`
+			INSERT INTO answers (question_id, student_id, answer, exam_id, makeup, submitted_at, credit)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, a.questionID, studentID, a.answer, exam.ID, makeup, now, a.credit)
+		if err != nil {
+			return attempt, err
+		}
+		if a.correct {
+			attempt.Score++
+		}
+		attempt.Points += a.credit
+	}
+	attempt.Answered = len(graded)
+	attempt.Points = roundCredit(attempt.Points)
+
+	if _, err := tx.Exec(`
+		UPDATE exam_attempts SET submitted_at = ?, auto_submitted = ?, makeup = ?, score = ?, points = ?
+		WHERE exam_id = ? AND student_id = ?
+	`, now, auto, makeup, attempt.Score, attempt.Points, exam.ID, studentID); err != nil {
+		return attempt, err
+	}
+	if err := tx.QueryRow("SELECT started_at FROM exam_attempts WHERE exam_id = ? AND student_id = ?", exam.ID, studentID).Scan(&attempt.StartedAt); err != nil {
+		return attempt, err
+	}
+	if err := tx.Commit(); err != nil {
+		return attempt, err
+	}
+	attempt.SubmittedAt = &now
+
+	for _, a := range graded {
+		go scoreAnswer(exam.CourseID, studentID, a.questionID, a.correct, 0)
+	}
+	queueLTIScore(exam.CourseID, exam.ID, studentID)
+	if exam.SessionID != nil {
+		hub.broadcast(teacherRoom(*exam.SessionID), Message{Type: "exam_submitted", From: studentID, Data: gin.H{
+			"exam_id": exam.ID, "student_id": studentID, "score": attempt.Score, "points": attempt.Points,
+			"auto_submitted": auto, "makeup": makeup,
+		}})
+	}
+	return attempt, nil
+}
+
+// 结束测验并为未交卷的学生自动收卷，可重复调用
+func closeExam(examID int) error {
+	now := time.Now().UTC()
+	result, err := db.Exec("UPDATE exams SET status = ?, closed_at = ? WHERE id = ? AND status = ?", ExamClosed, now, examID, ExamRunning)
+	if err != nil {
+		return err
+	}
+	exam, err := loadExam(examID)
+	if err != nil {
+		return err
+	}
+
+	// 补答中的学生在补答截止时单独收卷
+	rows, err := db.Query(`
+		SELECT a.student_id FROM exam_attempts a
+		WHERE a.exam_id = ? AND a.submitted_at IS NULL AND NOT EXISTS (
+			SELECT 1 FROM makeup_grants g
+			WHERE g.target_type = ? AND g.target_id = a.exam_id AND g.student_id = a.student_id AND g.deadline > ?
+		)
+	`, examID, MakeupExam, now)
+	if err != nil {
+		return err
+	}
+	var pending []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, id)
+	}
+	rows.Close()
+
+	for _, studentID := range pending {
+		if _, err := finalizeExamAttempt(exam, studentID, true, false); err != nil && !errors.Is(err, errExamSubmitted) {
+			return err
+		}
+	}
+
+	if n, _ := result.RowsAffected(); n > 0 {
+		hub.broadcast(courseRoom(exam.CourseID), Message{Type: "exam_closed", Data: gin.H{"exam_id": exam.ID}})
+	}
+	return nil
+}
+
+// 老师提前结束测验
+func closeExamHandler(c *gin.Context) {
+	exam, ok := examParam(c)
+	if !ok {
+		return
+	}
+	if exam.Status != ExamRunning {
+		respondError(c, http.StatusConflict, CodeExamNotRunning)
+		return
+	}
+	if err := closeExam(exam.ID); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeExamCloseFailed)
+		return
+	}
+	recordAudit(c, "close_exam", "exam", exam.ID, nil)
+	respondOK(c, http.StatusOK, gin.H{"id": exam.ID, "status": ExamClosed})
+}
+
+// 解除学生作答记录绑定的设备，学生更换设备（如设备故障）后可继续作答
+func releaseExamDevice(c *gin.Context) {
+	exam, ok := examParam(c)
+	if !ok {
+		return
+	}
+	studentID, ok := intParam(c, "student_id")
+	if !ok {
+		return
+	}
+	if _, err := db.Exec("UPDATE exam_attempts SET login_session_id = '' WHERE exam_id = ? AND student_id = ? AND submitted_at IS NULL",
+		exam.ID, studentID); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeExamAnswerFailed)
+		return
+	}
+	recordAudit(c, "release_exam_device", "exam", exam.ID, gin.H{"student_id": studentID})
+	respondOK(c, http.StatusOK, gin.H{"exam_id": exam.ID, "student_id": studentID})
+}
+
+// 测验汇总：每个学生的进度与成绩，以及每题的答对人数
+func getExamResults(c *gin.Context) {
+	exam, ok := examParam(c)
+	if !ok {
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT a.student_id, COUNT(ea.question_id), a.score, COALESCE(a.points, a.score), a.auto_submitted, a.makeup,
+			a.started_at, a.submitted_at
+		FROM exam_attempts a
+		LEFT JOIN exam_answers ea ON ea.exam_id = a.exam_id AND ea.student_id = a.student_id
+		WHERE a.exam_id = ?
+		GROUP BY a.student_id, a.score, a.points, a.auto_submitted, a.makeup, a.started_at, a.submitted_at
+		ORDER BY COALESCE(a.points, a.score) DESC, a.submitted_at
+	`, exam.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeExamGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	attempts := []ExamAttempt{}
+	for rows.Next() {
+		var a ExamAttempt
+		if err := rows.Scan(&a.StudentID, &a.Answered, &a.Score, &a.Points, &a.AutoSubmitted, &a.Makeup, &a.StartedAt, &a.SubmittedAt); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeExamGetFailed)
+			return
+		}
+		a.StartedAt = a.StartedAt.In(loc)
+		a.SubmittedAt = inLocation(a.SubmittedAt, loc)
+		attempts = append(attempts, a)
+	}
+
+	statRows, err := db.Query(`
+		SELECT eq.question_id,
+			COUNT(CASE WHEN NOT a.makeup THEN 1 END), COUNT(CASE WHEN NOT a.makeup AND a.answer = q.answer THEN 1 END),
+			AVG(CASE WHEN NOT a.makeup THEN COALESCE(a.credit, CASE WHEN a.answer = q.answer THEN 1 ELSE 0 END) END),
+			COUNT(CASE WHEN a.makeup THEN 1 END), COUNT(CASE WHEN a.makeup AND a.answer = q.answer THEN 1 END)
+		FROM exam_questions eq
+		JOIN questions q ON q.id = eq.question_id
+		LEFT JOIN answers a ON a.question_id = eq.question_id AND a.exam_id = eq.exam_id
+		WHERE eq.exam_id = ?
+		GROUP BY eq.question_id, eq.position
+		ORDER BY eq.position
+	`, exam.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeExamGetFailed)
+		return
+	}
+	defer statRows.Close()
+
+	stats := []ExamQuestionStat{}
+	for statRows.Next() {
+		var s ExamQuestionStat
+		var averageCredit sql.NullFloat64
+		if err := statRows.Scan(&s.QuestionID, &s.AnswerCount, &s.CorrectCount, &averageCredit, &s.MakeupAnswerCount, &s.MakeupCorrectCount); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeExamGetFailed)
+			return
+		}
+		s.AverageCredit = roundCredit(averageCredit.Float64)
+		stats = append(stats, s)
+	}
+
+	exam.inLocation(loc)
+	respondOK(c, http.StatusOK, gin.H{
+		"exam":      exam,
+		"students":  attempts,
+		"questions": stats,
+	})
+}
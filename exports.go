@@ -0,0 +1,293 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 导出状态
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+const jobCourseExport = "course.export"
+
+// 课程数据归档
+type CourseExport struct {
+	ID          int        `json:"id"`
+	CourseID    int        `json:"course_id"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+	RequestedBy int        `json:"requested_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// 归档中的一个 CSV 文件，query 以课程 id 为唯一参数
+type exportTable struct {
+	name   string
+	header []string
+	query  string
+}
+
+// 方言在启动时确定，因此查询在调用时生成
+func exportTables() []exportTable {
+	return []exportTable{
+		{
+			name:   "sessions.csv",
+			header: []string{"id", "stream_key", "status", "start_time", "end_time", "created_at"},
+			query: `SELECT id, stream_key, status, start_time, end_time, created_at
+				FROM live_sessions WHERE course_id = ? ORDER BY id`,
+		},
+		{
+			name:   "attendance.csv",
+			header: []string{"session_id", "user_id", "username", "name", "role", "joined_at"},
+			query: `SELECT a.session_id, a.user_id, COALESCE(u.username, ''), COALESCE(u.name, ''), a.role, a.joined_at
+				FROM session_attendance a
+				JOIN live_sessions s ON s.id = a.session_id
+				LEFT JOIN users u ON u.id = a.user_id
+				WHERE s.course_id = ? ORDER BY a.session_id, a.joined_at`,
+		},
+		{
+			name:   "questions.csv",
+			header: []string{"id", "type", "content", "options", "answer", "difficulty", "estimated_seconds", "tags"},
+			query: `SELECT q.id, q.type, q.content, COALESCE(q.options, ''), q.answer, q.difficulty, q.estimated_seconds,
+					COALESCE((SELECT ` + dialect.groupConcat("t.tag", "t.tag") + ` FROM question_tags t WHERE t.question_id = q.id), '')
+				FROM questions q WHERE q.course_id = ? ORDER BY q.id`,
+		},
+		{
+			name:   "answers.csv",
+			header: []string{"id", "question_id", "student_id", "answer", "correct", "exam_id", "push_id", "checkpoint_id", "makeup", "latency_ms", "submitted_at"},
+			query: `SELECT a.id, a.question_id, a.student_id, a.answer, a.answer = q.answer, a.exam_id, a.push_id, a.checkpoint_id, a.makeup,
+					a.latency_ms, a.submitted_at
+				FROM answers a
+				JOIN questions q ON q.id = a.question_id
+				WHERE q.course_id = ? ORDER BY a.id`,
+		},
+		{
+			name:   "chat.csv",
+			header: []string{"id", "session_id", "room", "user_id", "content", "created_at"},
+			query: `SELECT m.id, m.session_id, m.room, m.user_id, m.content, m.created_at
+				FROM chat_messages m
+				JOIN live_sessions s ON s.id = m.session_id
+				WHERE s.course_id = ? ORDER BY m.id`,
+		},
+	}
+}
+
+func init() {
+	jobHandlers[jobCourseExport] = func(ctx context.Context, payload json.RawMessage) error {
+		var p struct {
+			ExportID int `json:"export_id"`
+		}
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return runCourseExport(ctx, p.ExportID)
+	}
+}
+
+// 生成归档并上传到对象存储
+func runCourseExport(ctx context.Context, exportID int) (err error) {
+	var courseID int
+	if err := db.QueryRow("SELECT course_id FROM course_exports WHERE id = ?", exportID).Scan(&courseID); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			db.Exec("UPDATE course_exports SET status = ?, error = ?, finished_at = ? WHERE id = ?",
+				ExportFailed, err.Error(), time.Now().UTC(), exportID)
+		}
+	}()
+
+	f, err := os.CreateTemp("", fmt.Sprintf("course-%d-export-*.zip", courseID))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for _, t := range exportTables() {
+		if err := writeExportTable(zw, t, courseID); err != nil {
+			return fmt.Errorf("%s: %w", t.name, err)
+		}
+	}
+	if err := writeExportRecordings(ctx, zw, courseID); err != nil {
+		return fmt.Errorf("recordings.csv: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("exports/course-%d/%d.zip", courseID, exportID)
+	if err := putFile(ctx, key, f.Name(), "application/zip"); err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE course_exports SET status = ?, file_key = ?, error = NULL, finished_at = ? WHERE id = ?",
+		ExportReady, key, time.Now().UTC(), exportID)
+	return err
+}
+
+func writeExportTable(zw *zip.Writer, t exportTable, courseID int) error {
+	rows, err := readQuery(t.query, courseID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	w, err := zw.Create(t.name)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(t.header); err != nil {
+		return err
+	}
+
+	values := make([]sql.RawBytes, len(t.header))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	record := make([]string, len(values))
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		for i, v := range values {
+			record[i] = string(v)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// 录像链接随归档生成，有效期与对象存储的下载地址相同
+func writeExportRecordings(ctx context.Context, zw *zip.Writer, courseID int) error {
+	rows, err := readQuery(`
+		SELECT r.id, r.session_id, r.status, r.video_key, r.created_at
+		FROM recordings r
+		JOIN live_sessions s ON s.id = r.session_id
+		WHERE s.course_id = ? ORDER BY r.id
+	`, courseID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	w, err := zw.Create("recordings.csv")
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "session_id", "status", "video_key", "video_url", "created_at"})
+	for rows.Next() {
+		var id, sessionID int
+		var status, key string
+		var createdAt time.Time
+		if err := rows.Scan(&id, &sessionID, &status, &key, &createdAt); err != nil {
+			return err
+		}
+		cw.Write([]string{
+			strconv.Itoa(id), strconv.Itoa(sessionID), status, key,
+			storageURL(ctx, key), createdAt.Format(time.RFC3339),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// 申请导出课程数据，生成后通过 GET 获取下载地址
+func createCourseExport(c *gin.Context) {
+	courseID, ok := intParam(c, "course_id")
+	if !ok {
+		return
+	}
+
+	export := CourseExport{
+		CourseID:    courseID,
+		Status:      ExportPending,
+		RequestedBy: currentUser(c).ID,
+		CreatedAt:   time.Now().UTC(),
+	}
+	id, err := dialect.insertID(db, `
+		INSERT INTO course_exports (course_id, status, requested_by, created_at)
+		VALUES (?, ?, ?, ?)
+	`, export.CourseID, export.Status, export.RequestedBy, export.CreatedAt)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeExportCreateFailed)
+		return
+	}
+	export.ID = int(id)
+
+	if _, err := enqueueJob(jobCourseExport, gin.H{"export_id": export.ID}, time.Time{}); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeExportCreateFailed)
+		return
+	}
+
+	recordAudit(c, "export_course", "course", courseID, gin.H{"export_id": export.ID})
+	export.CreatedAt = export.CreatedAt.In(requestLocation(c))
+	respondOK(c, http.StatusAccepted, export)
+}
+
+// 导出状态，完成后附带下载地址
+func getCourseExport(c *gin.Context) {
+	courseID, ok := intParam(c, "course_id")
+	if !ok {
+		return
+	}
+	exportID, ok := intParam(c, "export_id")
+	if !ok {
+		return
+	}
+
+	var export CourseExport
+	var fileKey string
+	err := db.QueryRow(`
+		SELECT id, course_id, status, file_key, COALESCE(error, ''), requested_by, created_at, finished_at
+		FROM course_exports WHERE id = ? AND course_id = ?
+	`, exportID, courseID).Scan(&export.ID, &export.CourseID, &export.Status, &fileKey, &export.Error,
+		&export.RequestedBy, &export.CreatedAt, &export.FinishedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, CodeExportNotFound)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeExportGetFailed)
+		}
+		return
+	}
+	if export.Status == ExportReady {
+		export.DownloadURL = storageURL(c.Request.Context(), fileKey)
+	}
+
+	loc := requestLocation(c)
+	export.CreatedAt = export.CreatedAt.In(loc)
+	export.FinishedAt = inLocation(export.FinishedAt, loc)
+	respondOK(c, http.StatusOK, export)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 功能开关
+const (
+	FeatureGamification   = "gamification"
+	FeatureExams          = "exams"
+	FeatureWebRTCPlayback = "webrtc_playback"
+)
+
+// 内置默认值。config.json 的 features 可覆盖默认值，
+// 数据库中的全局开关覆盖配置，机构开关再覆盖全局开关
+var defaultFeatures = map[string]bool{
+	FeatureGamification:   true,
+	FeatureExams:          true,
+	FeatureWebRTCPlayback: false,
+}
+
+// 多副本部署时其他实例的修改最迟在该时间后生效
+const featureCacheTTL = 30 * time.Second
+
+// 数据库中的开关，org 为空表示全局
+type FeatureOverride struct {
+	Name      string    `json:"name"`
+	Org       string    `json:"org"`
+	Enabled   bool      `json:"enabled"`
+	UpdatedBy int       `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type featureKey struct {
+	name, org string
+}
+
+var (
+	featureMu       sync.RWMutex
+	featureCache    map[featureKey]bool
+	featureLoadedAt time.Time
+)
+
+func loadFeatureOverrides() (map[featureKey]bool, error) {
+	featureMu.RLock()
+	cache, loadedAt := featureCache, featureLoadedAt
+	featureMu.RUnlock()
+	if cache != nil && time.Since(loadedAt) < featureCacheTTL {
+		return cache, nil
+	}
+
+	rows, err := db.Query("SELECT name, org, enabled FROM feature_flags")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cache = make(map[featureKey]bool)
+	for rows.Next() {
+		var k featureKey
+		var enabled bool
+		if err := rows.Scan(&k.name, &k.org, &enabled); err != nil {
+			return nil, err
+		}
+		cache[k] = enabled
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	featureMu.Lock()
+	featureCache, featureLoadedAt = cache, time.Now()
+	featureMu.Unlock()
+	return cache, nil
+}
+
+func invalidateFeatureCache() {
+	featureMu.Lock()
+	featureCache = nil
+	featureMu.Unlock()
+}
+
+// 计算指定机构的全部开关，数据库不可用时退回配置中的值
+func resolveFeatures(org string) map[string]bool {
+	conf := currentConfig().Features
+	overrides, err := loadFeatureOverrides()
+	if err != nil {
+		log.Printf("Failed to load feature flags: %v", err)
+	}
+
+	features := make(map[string]bool, len(defaultFeatures))
+	for name, enabled := range defaultFeatures {
+		if v, ok := conf[name]; ok {
+			enabled = v
+		}
+		if v, ok := overrides[featureKey{name, ""}]; ok {
+			enabled = v
+		}
+		if org != "" {
+			if v, ok := overrides[featureKey{name, org}]; ok {
+				enabled = v
+			}
+		}
+		features[name] = enabled
+	}
+	return features
+}
+
+func featureEnabled(name, org string) bool {
+	return resolveFeatures(org)[name]
+}
+
+// 按用户所属机构判断，用于没有请求上下文的后台流程
+func featureEnabledForUser(name string, userID int) bool {
+	var org string
+	if err := db.QueryRow("SELECT org FROM users WHERE id = ?", userID).Scan(&org); err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to load org of user %d: %v", userID, err)
+	}
+	return featureEnabled(name, org)
+}
+
+// 功能未开启时拒绝请求
+func requireFeature(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		org := ""
+		if user := currentUser(c); user != nil {
+			org = user.Org
+		}
+		if !featureEnabled(name, org) {
+			respondError(c, http.StatusForbidden, CodeFeatureDisabled)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// 当前用户可用的功能开关
+func getFeatures(c *gin.Context) {
+	respondOK(c, http.StatusOK, resolveFeatures(currentUser(c).Org))
+}
+
+// 管理员查看数据库中的开关
+func adminListFeatures(c *gin.Context) {
+	rows, err := db.Query("SELECT name, org, enabled, updated_by, updated_at FROM feature_flags ORDER BY name, org")
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeFeatureGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	overrides := []FeatureOverride{}
+	for rows.Next() {
+		var f FeatureOverride
+		if err := rows.Scan(&f.Name, &f.Org, &f.Enabled, &f.UpdatedBy, &f.UpdatedAt); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeFeatureGetFailed)
+			return
+		}
+		f.UpdatedAt = f.UpdatedAt.In(loc)
+		overrides = append(overrides, f)
+	}
+
+	names := make([]string, 0, len(defaultFeatures))
+	for name := range defaultFeatures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	respondOK(c, http.StatusOK, gin.H{"features": names, "defaults": resolveFeatures(""), "overrides": overrides})
+}
+
+func featureParam(c *gin.Context) (string, bool) {
+	name := c.Param("name")
+	if _, ok := defaultFeatures[name]; !ok {
+		respondError(c, http.StatusNotFound, CodeFeatureNotFound)
+		return "", false
+	}
+	return name, true
+}
+
+// 设置全局或机构开关
+func adminSetFeature(c *gin.Context) {
+	name, ok := featureParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Org     string `json:"org" binding:"max=64"`
+		Enabled *bool  `json:"enabled" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	f := FeatureOverride{
+		Name:      name,
+		Org:       req.Org,
+		Enabled:   *req.Enabled,
+		UpdatedBy: currentUser(c).ID,
+		UpdatedAt: time.Now().UTC(),
+	}
+	_, err := db.Exec(dialect.upsert(`
+		INSERT INTO feature_flags (name, org, enabled, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		[]string{"name", "org"},
+		"enabled = EXCLUDED.enabled", "updated_by = EXCLUDED.updated_by", "updated_at = EXCLUDED.updated_at",
+	), f.Name, f.Org, f.Enabled, f.UpdatedBy, f.UpdatedAt)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeFeatureUpdateFailed)
+		return
+	}
+	invalidateFeatureCache()
+
+	recordAudit(c, "set_feature", "feature", 0, gin.H{"name": f.Name, "org": f.Org, "enabled": f.Enabled})
+	f.UpdatedAt = f.UpdatedAt.In(requestLocation(c))
+	respondOK(c, http.StatusOK, f)
+}
+
+// 删除开关，恢复为上一级的值
+func adminDeleteFeature(c *gin.Context) {
+	name, ok := featureParam(c)
+	if !ok {
+		return
+	}
+	org := c.Query("org")
+	if _, err := db.Exec("DELETE FROM feature_flags WHERE name = ? AND org = ?", name, org); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeFeatureUpdateFailed)
+		return
+	}
+	invalidateFeatureCache()
+
+	recordAudit(c, "delete_feature", "feature", 0, gin.H{"name": name, "org": org})
+	respondOK(c, http.StatusOK, gin.H{"name": name, "org": org, "enabled": featureEnabled(name, org)})
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 积分来源
+const (
+	PointsCorrect    = "correct"
+	PointsFast       = "fast"
+	PointsAttendance = "attendance"
+)
+
+// 积分规则，未配置的项使用默认值
+type GamifyConfig struct {
+	CorrectPoints    int `json:"correct_points"`
+	FastPoints       int `json:"fast_points"` // “前 N 名答对”模式下的额外奖励
+	AttendancePoints int `json:"attendance_points"`
+}
+
+var defaultGamify = GamifyConfig{CorrectPoints: 10, FastPoints: 5, AttendancePoints: 2}
+
+func gamifyRules() GamifyConfig {
+	rules := currentConfig().Gamify
+	if rules.CorrectPoints == 0 {
+		rules.CorrectPoints = defaultGamify.CorrectPoints
+	}
+	if rules.FastPoints == 0 {
+		rules.FastPoints = defaultGamify.FastPoints
+	}
+	if rules.AttendancePoints == 0 {
+		rules.AttendancePoints = defaultGamify.AttendancePoints
+	}
+	return rules
+}
+
+// 徽章定义
+type Badge struct {
+	ID          string            `json:"id"`
+	Name        map[string]string `json:"-"`
+	Description map[string]string `json:"-"`
+	check       func(s StudentScore, rank int) bool
+}
+
+var badges = []Badge{
+	{
+		ID:          "first_correct",
+		Name:        map[string]string{langEN: "First Blood", langZH: "首次答对"},
+		Description: map[string]string{langEN: "Answer a question correctly", langZH: "第一次答对题目"},
+		check:       func(s StudentScore, _ int) bool { return s.Points > 0 && s.BestStreak >= 1 },
+	},
+	{
+		ID:          "streak_5",
+		Name:        map[string]string{langEN: "On Fire", langZH: "连续答对 5 题"},
+		Description: map[string]string{langEN: "Answer 5 questions correctly in a row", langZH: "连续答对 5 道题"},
+		check:       func(s StudentScore, _ int) bool { return s.BestStreak >= 5 },
+	},
+	{
+		ID:          "streak_10",
+		Name:        map[string]string{langEN: "Unstoppable", langZH: "连续答对 10 题"},
+		Description: map[string]string{langEN: "Answer 10 questions correctly in a row", langZH: "连续答对 10 道题"},
+		check:       func(s StudentScore, _ int) bool { return s.BestStreak >= 10 },
+	},
+	{
+		ID:          "speedster",
+		Name:        map[string]string{langEN: "Speedster", langZH: "抢答第一"},
+		Description: map[string]string{langEN: "Be the first correct answer in a fastest-N quiz", langZH: "在抢答中第一个答对"},
+		check:       func(_ StudentScore, rank int) bool { return rank == 1 },
+	},
+	{
+		ID:          "century",
+		Name:        map[string]string{langEN: "Century", langZH: "百分达人"},
+		Description: map[string]string{langEN: "Earn 100 points in a course", langZH: "在一门课程中获得 100 积分"},
+		check:       func(s StudentScore, _ int) bool { return s.Points >= 100 },
+	},
+}
+
+// 学生在课程中的积分
+type StudentScore struct {
+	CourseID   int      `json:"course_id"`
+	StudentID  int      `json:"student_id"`
+	Points     int      `json:"points"`
+	Streak     int      `json:"streak"`
+	BestStreak int      `json:"best_streak"`
+	Rank       int      `json:"rank,omitempty"`
+	Badges     []string `json:"badges,omitempty"`
+}
+
+// 发放积分，同一来源只发放一次；返回是否新发放
+func awardPoints(courseID, studentID int, reason string, refID, points int) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(dialect.insertIgnore(`
+		INSERT INTO point_events (course_id, student_id, reason, ref_id, points, created_at)
+		VALUES (?, ?, ?, ?, ?, NOW())`,
+	), courseID, studentID, reason, refID, points)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	_, err = tx.Exec(dialect.upsert(
+		"INSERT INTO student_points (course_id, student_id, points) VALUES (?, ?, ?)",
+		[]string{"course_id", "student_id"},
+		"points = student_points.points + EXCLUDED.points",
+	), courseID, studentID, points)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// 更新连续答对次数，答错时清零
+func updateStreak(courseID, studentID int, correct bool) error {
+	if !correct {
+		_, err := db.Exec("UPDATE student_points SET streak = 0 WHERE course_id = ? AND student_id = ?", courseID, studentID)
+		return err
+	}
+	// MySQL 按顺序赋值而 PostgreSQL 使用旧值，因此 best_streak 在前且不引用新的 streak
+	_, err := db.Exec(dialect.upsert(
+		"INSERT INTO student_points (course_id, student_id, streak, best_streak) VALUES (?, ?, 1, 1)",
+		[]string{"course_id", "student_id"},
+		"best_streak = GREATEST(student_points.best_streak, student_points.streak + 1)",
+		"streak = student_points.streak + 1",
+	), courseID, studentID)
+	return err
+}
+
+func loadStudentScore(courseID, studentID int) (StudentScore, error) {
+	s := StudentScore{CourseID: courseID, StudentID: studentID}
+	err := db.QueryRow(`
+		SELECT points, streak, best_streak FROM student_points
+		WHERE course_id = ? AND student_id = ?
+	`, courseID, studentID).Scan(&s.Points, &s.Streak, &s.BestStreak)
+	if err == sql.ErrNoRows {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+
+	err = db.QueryRow(`
+		SELECT COUNT(*) + 1 FROM student_points WHERE course_id = ? AND points > ?
+	`, courseID, s.Points).Scan(&s.Rank)
+	return s, err
+}
+
+// 按积分和抢答名次检查并授予徽章
+func awardBadges(s StudentScore, fastRank int) []string {
+	var awarded []string
+	for _, b := range badges {
+		if !b.check(s, fastRank) {
+			continue
+		}
+		result, err := db.Exec(dialect.insertIgnore(`
+			INSERT INTO student_badges (course_id, student_id, badge, awarded_at)
+			VALUES (?, ?, ?, NOW())`,
+		), s.CourseID, s.StudentID, b.ID)
+		if err != nil {
+			log.Printf("Failed to award badge %s to student %d: %v", b.ID, s.StudentID, err)
+			continue
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			awarded = append(awarded, b.ID)
+		}
+	}
+	return awarded
+}
+
+// 积分变化后检查徽章，并在排名或徽章变化时通知课堂
+func afterPointsChanged(courseID, studentID, previousRank, fastRank int) {
+	score, err := loadStudentScore(courseID, studentID)
+	if err != nil {
+		log.Printf("Failed to load score for student %d: %v", studentID, err)
+		return
+	}
+	awarded := awardBadges(score, fastRank)
+
+	if score.Rank != previousRank || len(awarded) > 0 {
+		hub.broadcast(courseRoom(courseID), Message{Type: "rank_changed", Data: gin.H{
+			"student_id":    studentID,
+			"points":        score.Points,
+			"rank":          score.Rank,
+			"previous_rank": previousRank,
+			"streak":        score.Streak,
+			"new_badges":    awarded,
+		}})
+	}
+}
+
+// 根据作答结果发放积分
+func scoreAnswer(courseID, studentID, questionID int, correct bool, fastRank int) {
+	if !featureEnabledForUser(FeatureGamification, studentID) {
+		return
+	}
+	before, err := loadStudentScore(courseID, studentID)
+	if err != nil {
+		log.Printf("Failed to load score for student %d: %v", studentID, err)
+		return
+	}
+
+	rules := gamifyRules()
+	changed := false
+	if correct {
+		awarded, err := awardPoints(courseID, studentID, PointsCorrect, questionID, rules.CorrectPoints)
+		if err != nil {
+			log.Printf("Failed to award points to student %d: %v", studentID, err)
+			return
+		}
+		// 重复提交同一题不重复累计连对
+		if awarded {
+			changed = true
+			if err := updateStreak(courseID, studentID, true); err != nil {
+				log.Printf("Failed to update streak for student %d: %v", studentID, err)
+			}
+		}
+		if fastRank > 0 {
+			if ok, err := awardPoints(courseID, studentID, PointsFast, questionID, rules.FastPoints); err == nil && ok {
+				changed = true
+			}
+		}
+	} else if err := updateStreak(courseID, studentID, false); err != nil {
+		log.Printf("Failed to reset streak for student %d: %v", studentID, err)
+	}
+
+	if changed {
+		afterPointsChanged(courseID, studentID, before.Rank, fastRank)
+	}
+}
+
+// 学生进入直播时发放出勤积分，每场一次
+func scoreAttendance(courseID, studentID, sessionID int) {
+	if !featureEnabledForUser(FeatureGamification, studentID) {
+		return
+	}
+	before, err := loadStudentScore(courseID, studentID)
+	if err != nil {
+		log.Printf("Failed to load score for student %d: %v", studentID, err)
+		return
+	}
+	// 补课会话与原会话只计一次出勤积分
+	unit, err := attendanceUnit(sessionID)
+	if err != nil {
+		log.Printf("Failed to load session %d: %v", sessionID, err)
+		return
+	}
+	awarded, err := awardPoints(courseID, studentID, PointsAttendance, unit, gamifyRules().AttendancePoints)
+	if err != nil {
+		log.Printf("Failed to award attendance points to student %d: %v", studentID, err)
+		return
+	}
+	if awarded {
+		afterPointsChanged(courseID, studentID, before.Rank, 0)
+	}
+}
+
+// 课程积分排行
+func getGamifyLeaderboard(c *gin.Context) {
+	courseID, ok := intParam(c, "course_id")
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultLeaderboardSize)))
+	if err != nil || limit <= 0 || limit > maxLeaderboardSize {
+		limit = defaultLeaderboardSize
+	}
+
+	rows, err := db.Query(`
+		SELECT student_id, points, streak, best_streak
+		FROM student_points
+		WHERE course_id = ?
+		ORDER BY points DESC, student_id
+		LIMIT ?
+	`, courseID, limit)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeGamifyGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	scores := []StudentScore{}
+	for rows.Next() {
+		s := StudentScore{CourseID: courseID}
+		if err := rows.Scan(&s.StudentID, &s.Points, &s.Streak, &s.BestStreak); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeGamifyGetFailed)
+			return
+		}
+		// 同分同名次
+		s.Rank = len(scores) + 1
+		if n := len(scores); n > 0 && scores[n-1].Points == s.Points {
+			s.Rank = scores[n-1].Rank
+		}
+		scores = append(scores, s)
+	}
+
+	respondOK(c, http.StatusOK, scores)
+}
+
+// 学生在课程中的积分和徽章
+func getStudentScore(c *gin.Context) {
+	studentID, ok := intParam(c, "student_id")
+	if !ok {
+		return
+	}
+	// 学生只能查看自己的积分
+	if user := currentUser(c); user.Role == RoleStudent && user.ID != studentID {
+		respondError(c, http.StatusForbidden, CodeForbidden)
+		return
+	}
+	courseID, err := strconv.Atoi(c.Query("course_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "course_id")
+		return
+	}
+
+	score, err := loadStudentScore(courseID, studentID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeGamifyGetFailed)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT badge FROM student_badges
+		WHERE course_id = ? AND student_id = ?
+		ORDER BY awarded_at
+	`, courseID, studentID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeGamifyGetFailed)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var badge string
+		if err := rows.Scan(&badge); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeGamifyGetFailed)
+			return
+		}
+		score.Badges = append(score.Badges, badge)
+	}
+
+	respondOK(c, http.StatusOK, score)
+}
+
+// 徽章定义及积分规则
+func listBadges(c *gin.Context) {
+	lang := requestLang(c)
+	list := make([]gin.H, 0, len(badges))
+	for _, b := range badges {
+		list = append(list, gin.H{
+			"id":          b.ID,
+			"name":        localizedText(b.Name, lang),
+			"description": localizedText(b.Description, lang),
+		})
+	}
+	respondOK(c, http.StatusOK, gin.H{"badges": list, "rules": gamifyRules()})
+}
+
+func localizedText(texts map[string]string, lang string) string {
+	if text, ok := texts[lang]; ok {
+		return text
+	}
+	return texts[defaultLang]
+}
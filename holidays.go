@@ -0,0 +1,281 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 批量创建会话遇到节假日时的处理方式
+const (
+	HolidayWarn = "warn" // 照常创建，在响应中列出
+	HolidaySkip = "skip" // 不创建当天的会话
+)
+
+const (
+	defaultSessionMinutes = 60
+	// 未指定范围时检查今后 90 天的排课冲突
+	defaultConflictWindow = 90 * 24 * time.Hour
+)
+
+// 机构的节假日，按机构所在地的日期记录
+type Holiday struct {
+	Org  string `json:"org"`
+	Date string `json:"date"`
+	Name string `json:"name"`
+}
+
+// 老师的一节计划课次
+type ScheduleSlot struct {
+	SessionID int       `json:"session_id,omitempty"`
+	CourseID  int       `json:"course_id"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+}
+
+func (s ScheduleSlot) overlaps(o ScheduleSlot) bool {
+	return s.StartsAt.Before(o.EndsAt) && o.StartsAt.Before(s.EndsAt)
+}
+
+// 时间重叠的两节课
+type ScheduleConflict struct {
+	Slot          ScheduleSlot `json:"slot"`
+	ConflictsWith ScheduleSlot `json:"conflicts_with"`
+}
+
+func (c *ScheduleConflict) inLocation(loc *time.Location) {
+	for _, s := range []*ScheduleSlot{&c.Slot, &c.ConflictsWith} {
+		s.StartsAt = s.StartsAt.In(loc)
+		s.EndsAt = s.EndsAt.In(loc)
+	}
+}
+
+// 机构在日期范围内的节假日，from 和 to 为空时不限制
+func loadHolidays(org, from, to string) ([]Holiday, error) {
+	q := "SELECT org, date, name FROM org_holidays WHERE org = ?"
+	args := []interface{}{org}
+	if from != "" {
+		q += " AND date >= ?"
+		args = append(args, from)
+	}
+	if to != "" {
+		q += " AND date <= ?"
+		args = append(args, to)
+	}
+	rows, err := db.Query(q+" ORDER BY date", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holidays := []Holiday{}
+	for rows.Next() {
+		var h Holiday
+		if err := rows.Scan(&h.Org, &h.Date, &h.Name); err != nil {
+			return nil, err
+		}
+		holidays = append(holidays, h)
+	}
+	return holidays, rows.Err()
+}
+
+// 老师在时间范围内尚未开始的计划课次，包括所有课程
+func teacherSlots(teacherID int, from, to time.Time) ([]ScheduleSlot, error) {
+	rows, err := db.Query(`
+		SELECT id, course_id, scheduled_at, scheduled_minutes
+		FROM live_sessions
+		WHERE teacher_id = ? AND status = 'pending' AND scheduled_at IS NOT NULL AND scheduled_at >= ? AND scheduled_at < ?
+		ORDER BY scheduled_at, id
+	`, teacherID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	slots := []ScheduleSlot{}
+	for rows.Next() {
+		var s ScheduleSlot
+		var minutes int
+		if err := rows.Scan(&s.SessionID, &s.CourseID, &s.StartsAt, &minutes); err != nil {
+			return nil, err
+		}
+		if minutes <= 0 {
+			minutes = defaultSessionMinutes
+		}
+		s.EndsAt = s.StartsAt.Add(time.Duration(minutes) * time.Minute)
+		slots = append(slots, s)
+	}
+	return slots, rows.Err()
+}
+
+// 新的课次与老师已有课次的冲突，slots 需按开始时间排序
+func findScheduleConflicts(teacherID int, slots []ScheduleSlot) ([]ScheduleConflict, error) {
+	conflicts := []ScheduleConflict{}
+	if len(slots) == 0 {
+		return conflicts, nil
+	}
+	// 已有课次最长不超过一天，向前多取一天即可覆盖跨越开始时间的课次
+	existing, err := teacherSlots(teacherID, slots[0].StartsAt.Add(-24*time.Hour), slots[len(slots)-1].EndsAt)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range slots {
+		for _, e := range existing {
+			if e.SessionID != s.SessionID && s.overlaps(e) {
+				conflicts = append(conflicts, ScheduleConflict{Slot: s, ConflictsWith: e})
+			}
+		}
+	}
+	return conflicts, nil
+}
+
+// 查看当前老师各课程之间时间重叠的计划课次，管理员可通过 teacher_id 查看其他老师
+func getScheduleConflicts(c *gin.Context) {
+	user := currentUser(c)
+	teacherID := user.ID
+	if raw := c.Query("teacher_id"); raw != "" && user.Role == RoleAdmin {
+		id, err := strconv.Atoi(raw)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidParam, "teacher_id")
+			return
+		}
+		teacherID = id
+	}
+	loc := requestLocation(c)
+	from := time.Now().UTC()
+	to := from.Add(defaultConflictWindow)
+	if raw := c.Query("from"); raw != "" {
+		t, err := time.ParseInLocation(seriesDateLayout, raw, loc)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidParam, "from")
+			return
+		}
+		from = t.UTC()
+	}
+	if raw := c.Query("to"); raw != "" {
+		t, err := time.ParseInLocation(seriesDateLayout, raw, loc)
+		if err != nil || !t.After(from) {
+			respondError(c, http.StatusBadRequest, CodeInvalidParam, "to")
+			return
+		}
+		to = t.AddDate(0, 0, 1).UTC()
+	}
+
+	slots, err := teacherSlots(teacherID, from, to)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+	conflicts := []ScheduleConflict{}
+	for i, s := range slots {
+		for _, e := range slots[i+1:] {
+			if !e.StartsAt.Before(s.EndsAt) {
+				break
+			}
+			conflicts = append(conflicts, ScheduleConflict{Slot: s, ConflictsWith: e})
+		}
+	}
+	for i := range conflicts {
+		conflicts[i].inLocation(loc)
+	}
+	respondOK(c, http.StatusOK, gin.H{"teacher_id": teacherID, "conflicts": conflicts})
+}
+
+// 机构的节假日，非管理员只能查看自己所属机构的
+func listHolidays(c *gin.Context) {
+	user := currentUser(c)
+	org := user.Org
+	if q := c.Query("org"); q != "" && user.Role == RoleAdmin {
+		org = q
+	}
+	for _, name := range []string{"from", "to"} {
+		if raw := c.Query(name); raw != "" {
+			if _, err := time.Parse(seriesDateLayout, raw); err != nil {
+				respondError(c, http.StatusBadRequest, CodeInvalidParam, name)
+				return
+			}
+		}
+	}
+	holidays, err := loadHolidays(org, c.Query("from"), c.Query("to"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeHolidayGetFailed)
+		return
+	}
+	respondOK(c, http.StatusOK, holidays)
+}
+
+// 批量设置机构的节假日，已存在的日期更新名称
+func adminSetHolidays(c *gin.Context) {
+	var req struct {
+		Org      string `json:"org" binding:"max=64"`
+		Holidays []struct {
+			Date string `json:"date" binding:"required"`
+			Name string `json:"name" binding:"required,max=100"`
+		} `json:"holidays" binding:"required,min=1,max=366,dive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	for _, h := range req.Holidays {
+		if _, err := time.Parse(seriesDateLayout, h.Date); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidParam, "date")
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeHolidayUpdateFailed)
+		return
+	}
+	defer tx.Rollback()
+	userID, now := currentUser(c).ID, time.Now().UTC()
+	holidays := make([]Holiday, 0, len(req.Holidays))
+	for _, h := range req.Holidays {
+		holiday := Holiday{Org: req.Org, Date: h.Date, Name: strings.TrimSpace(h.Name)}
+		_, err := tx.Exec(dialect.upsert(`
+			INSERT INTO org_holidays (org, date, name, updated_by, updated_at)
+			VALUES (?, ?, ?, ?, ?)`,
+			[]string{"org", "date"},
+			"name = EXCLUDED.name", "updated_by = EXCLUDED.updated_by", "updated_at = EXCLUDED.updated_at",
+		), holiday.Org, holiday.Date, holiday.Name, userID, now)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeHolidayUpdateFailed)
+			return
+		}
+		holidays = append(holidays, holiday)
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeHolidayUpdateFailed)
+		return
+	}
+	sort.Slice(holidays, func(i, j int) bool { return holidays[i].Date < holidays[j].Date })
+
+	recordAudit(c, "set_holidays", "org", 0, gin.H{"org": req.Org, "count": len(holidays)})
+	respondOK(c, http.StatusOK, holidays)
+}
+
+// 删除机构的一个节假日
+func adminDeleteHoliday(c *gin.Context) {
+	org, date := c.Query("org"), c.Query("date")
+	if _, err := time.Parse(seriesDateLayout, date); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "date")
+		return
+	}
+	res, err := db.Exec("DELETE FROM org_holidays WHERE org = ? AND date = ?", org, date)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeHolidayUpdateFailed)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, CodeHolidayNotFound)
+		return
+	}
+	recordAudit(c, "delete_holiday", "org", 0, gin.H{"org": org, "date": date})
+	respondOK(c, http.StatusOK, gin.H{"org": org, "date": date})
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = (pongWait * 9) / 10
+	maxMessageSize = 64 * 1024
+)
+
+// 实时消息
+type Message struct {
+	ID   int64       `json:"id,omitempty"` // 已保存的消息ID，如聊天消息，举报时引用
+	Type string      `json:"type"`
+	Room string      `json:"room,omitempty"`
+	From int         `json:"from,omitempty"`
+	Data interface{} `json:"data,omitempty"`
+	Time time.Time   `json:"time"`
+
+	// 需要统计送达情况的消息标识，如题目推送为 push:<push_id>
+	Delivery string `json:"delivery,omitempty"`
+}
+
+// WebSocket 客户端连接
+type Client struct {
+	conn      *websocket.Conn
+	send      chan outbound
+	sessionID int
+	courseID  int
+	userID    int
+	role      string
+	lang      string
+	handlers  map[string]wsHandler
+	connID    string // 在线状态记录中的连接ID
+
+	// 自定义协议的客户端（如 Socket.IO）在发送前对消息重新封包
+	frame func(payload []byte) []byte
+	ping  []byte
+
+	mu       sync.Mutex
+	rooms    map[string]bool
+	chatRoom string // 当前聊天房间，分组讨论时为分组房间
+	owner    bool   // 会话的授课老师或管理员，可以发布白板和在禁言时发言；移交会话时更新
+
+	drops int32 // 连续丢弃的消息数，写入成功后清零
+
+	// 会话已满时在候补队列中等待，此时不处理客户端消息
+	waiting      bool
+	waitPosition int
+	admitMu      sync.Mutex // 保证连接断开后不再被放行进入会话
+	closed       bool
+}
+
+// 广播中心，按房间管理连接
+type Hub struct {
+	mu    sync.RWMutex
+	rooms map[string]map[*Client]bool
+}
+
+// 客户端消息处理函数，按消息类型注册
+type wsHandler func(c *Client, msg Message)
+
+var (
+	hub        = newHub()
+	wsHandlers = map[string]wsHandler{}
+	upgrader   = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     func(r *http.Request) bool { return true },
+	}
+)
+
+func newHub() *Hub {
+	return &Hub{rooms: make(map[string]map[*Client]bool)}
+}
+
+func sessionRoom(sessionID int) string { return fmt.Sprintf("session:%d", sessionID) }
+func courseRoom(courseID int) string   { return fmt.Sprintf("course:%d", courseID) }
+func breakoutRoom(groupID int) string  { return fmt.Sprintf("breakout:%d", groupID) }
+func teacherRoom(sessionID int) string { return fmt.Sprintf("session:%d:teachers", sessionID) }
+
+// 课程中老师和助教等非学生的连接，用于预览推送
+func staffRoom(courseID int) string { return fmt.Sprintf("course:%d:staff", courseID) }
+
+// 加入房间
+func (h *Hub) join(c *Client, room string) {
+	h.mu.Lock()
+	if h.rooms[room] == nil {
+		h.rooms[room] = make(map[*Client]bool)
+	}
+	h.rooms[room][c] = true
+	h.mu.Unlock()
+
+	c.mu.Lock()
+	c.rooms[room] = true
+	c.mu.Unlock()
+}
+
+// 离开房间
+func (h *Hub) leave(c *Client, room string) {
+	h.mu.Lock()
+	if clients, ok := h.rooms[room]; ok {
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(h.rooms, room)
+		}
+	}
+	h.mu.Unlock()
+
+	c.mu.Lock()
+	delete(c.rooms, room)
+	c.mu.Unlock()
+}
+
+// 离开所有房间并关闭发送通道
+func (h *Hub) unregister(c *Client) {
+	c.leaveSeat()
+	c.mu.Lock()
+	rooms := make([]string, 0, len(c.rooms))
+	for room := range c.rooms {
+		rooms = append(rooms, room)
+	}
+	c.mu.Unlock()
+
+	for _, room := range rooms {
+		h.leave(c, room)
+	}
+	untrackPresence(c)
+	close(c.send)
+}
+
+// 向房间内所有连接广播消息，启用 Redis 时同时转发给其他副本
+func (h *Hub) broadcast(room string, msg Message) {
+	msg.Room = room
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Failed to encode %s message: %v", msg.Type, err)
+		return
+	}
+
+	h.enqueue(room, outbound{payload: payload, critical: criticalMessages[msg.Type], delivery: msg.Delivery})
+	publishBackplane(room, payload)
+}
+
+// 向本实例房间内的连接投递已编码的消息
+func (h *Hub) deliver(room string, payload []byte) {
+	h.enqueue(room, decodeOutbound(payload))
+}
+
+func (h *Hub) enqueue(room string, out outbound) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.rooms[room] {
+		c.enqueue(out)
+	}
+}
+
+// 房间内的客户端
+func (h *Hub) clients(room string) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	result := make([]*Client, 0, len(h.rooms[room]))
+	for c := range h.rooms[room] {
+		result = append(result, c)
+	}
+	return result
+}
+
+// 当前连接总数
+func (h *Hub) connectionCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	seen := make(map[*Client]bool)
+	for _, clients := range h.rooms {
+		for c := range clients {
+			seen[c] = true
+		}
+	}
+	return len(seen)
+}
+
+// 连接统计，用于排查连接泄漏和发送积压
+type HubStats struct {
+	Connections int            `json:"connections"`
+	Rooms       int            `json:"rooms"`
+	ByRole      map[string]int `json:"by_role"`
+	// 各连接发送缓冲区中待发送的消息总数，持续增长说明有客户端读取过慢
+	QueuedMessages int `json:"queued_messages"`
+	// 发送缓冲区已满的连接数
+	SaturatedClients int `json:"saturated_clients"`
+	// 启动以来因缓冲区已满丢弃的消息数，以及因此断开的慢速连接数
+	DroppedMessages     int64 `json:"dropped_messages"`
+	SlowConsumersClosed int64 `json:"slow_consumers_closed"`
+}
+
+func (h *Hub) stats() HubStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	stats := HubStats{Rooms: len(h.rooms), ByRole: map[string]int{}}
+	seen := make(map[*Client]bool)
+	for _, clients := range h.rooms {
+		for c := range clients {
+			if seen[c] {
+				continue
+			}
+			seen[c] = true
+			stats.ByRole[c.role]++
+			queued := len(c.send)
+			stats.QueuedMessages += queued
+			if queued == cap(c.send) {
+				stats.SaturatedClients++
+			}
+		}
+	}
+	stats.Connections = len(seen)
+	stats.DroppedMessages = atomic.LoadInt64(&droppedMessages)
+	stats.SlowConsumersClosed = atomic.LoadInt64(&slowConsumersClosed)
+	return stats
+}
+
+// 将会话中某个用户的聊天房间切换到指定房间
+func (h *Hub) moveChat(sessionID, userID int, room string) {
+	for _, c := range h.clients(sessionRoom(sessionID)) {
+		if c.userID != userID {
+			continue
+		}
+		c.mu.Lock()
+		old := c.chatRoom
+		c.mu.Unlock()
+		if old != "" && old != sessionRoom(sessionID) {
+			h.leave(c, old)
+		}
+		if room != sessionRoom(sessionID) {
+			h.join(c, room)
+		}
+		c.mu.Lock()
+		c.chatRoom = room
+		c.mu.Unlock()
+	}
+}
+
+// 建立直播会话的 WebSocket 连接
+func serveWS(c *gin.Context) {
+	client, ok := acceptClient(c, wsHandlers)
+	if !ok {
+		return
+	}
+	client.enterSession()
+
+	go client.writePump()
+	client.readPump()
+}
+
+// 加入会话和课程房间
+func (c *Client) joinSessionRooms() {
+	hub.join(c, sessionRoom(c.sessionID))
+	hub.join(c, courseRoom(c.courseID))
+	trackPresence(c)
+	go recordAttendance(c.sessionID, c.userID, c.role)
+	if c.isOwner() {
+		hub.join(c, teacherRoom(c.sessionID))
+	}
+	if c.role != RoleStudent {
+		hub.join(c, staffRoom(c.courseID))
+	}
+	if c.role == RoleStudent {
+		go scoreAttendance(c.courseID, c.userID, c.sessionID)
+	}
+	chatRoom := sessionRoom(c.sessionID)
+
+	// 已被分配到分组的学生直接进入分组聊天室
+	if groupID, ok := currentBreakoutGroup(c.sessionID, c.userID); ok {
+		hub.join(c, breakoutRoom(groupID))
+		chatRoom = breakoutRoom(groupID)
+	}
+
+	c.mu.Lock()
+	c.chatRoom = chatRoom
+	c.mu.Unlock()
+}
+
+// 校验登录令牌和参数并升级为 WebSocket 连接，用户和角色取自令牌；失败时写入错误响应
+func acceptClient(c *gin.Context, handlers map[string]wsHandler) (*Client, bool) {
+	rawID := c.Param("id")
+	if rawID == "" {
+		rawID = c.Query("session_id")
+	}
+	sessionID, err := strconv.Atoi(rawID)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "id")
+		return nil, false
+	}
+	user, ok := authenticateUpgrade(c, sessionID)
+	if !ok {
+		return nil, false
+	}
+	userID, role := user.ID, user.Role
+
+	var courseID, teacherID int
+	err = db.QueryRow("SELECT course_id, teacher_id FROM live_sessions WHERE id = ?", sessionID).Scan(&courseID, &teacherID)
+	if err != nil {
+		respondError(c, http.StatusNotFound, CodeSessionNotFound)
+		return nil, false
+	}
+	if role == RoleStudent && !requireCourseAccess(c, courseID, sessionID, userID) {
+		return nil, false
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade websocket: %v", err)
+		return nil, false
+	}
+
+	return &Client{
+		conn:      conn,
+		send:      make(chan outbound, sendBufferSize()),
+		sessionID: sessionID,
+		courseID:  courseID,
+		userID:    userID,
+		role:      role,
+		owner:     role == RoleAdmin || (teacherID != 0 && teacherID == userID),
+		lang:      requestLang(c),
+		handlers:  handlers,
+		rooms:     make(map[string]bool),
+	}, true
+}
+
+func (c *Client) isOwner() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.owner
+}
+
+// 直接向单个客户端发送消息
+func (c *Client) sendMessage(msg Message) {
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Failed to encode %s message: %v", msg.Type, err)
+		return
+	}
+	c.enqueue(outbound{payload: payload, critical: criticalMessages[msg.Type], delivery: msg.Delivery})
+}
+
+// 发送已编码的数据
+func (c *Client) sendRaw(payload []byte) {
+	c.enqueue(outbound{payload: payload})
+}
+
+func (c *Client) readPump() {
+	defer func() {
+		hub.unregister(c)
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	for {
+		var msg Message
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("Websocket read error for user %d: %v", c.userID, err)
+			}
+			return
+		}
+		msg.ID = 0
+		msg.From = c.userID
+		msg.Time = time.Now()
+
+		if c.isWaiting() {
+			continue
+		}
+		if handler, ok := c.handlers[msg.Type]; ok {
+			handler(c, msg)
+		}
+	}
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case out, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			payload := out.payload
+			if c.frame != nil {
+				payload = c.frame(payload)
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				return
+			}
+			c.delivered(out)
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if c.ping != nil {
+				// 应用层心跳，如 Engine.IO 的 ping 包
+				if err := c.conn.WriteMessage(websocket.TextMessage, c.ping); err != nil {
+					return
+				}
+				continue
+			}
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func init() {
+	// 聊天消息发送到客户端当前所在的聊天房间
+	wsHandlers["chat"] = func(c *Client, msg Message) {
+		if !c.isOwner() {
+			settings, err := loadSessionSettings(c.sessionID)
+			if err != nil {
+				c.sendError(CodeInternal)
+				return
+			}
+			if !settings.ChatEnabled {
+				c.sendError(CodeChatDisabled)
+				return
+			}
+			until, err := chatMutedUntil(c.sessionID, c.userID)
+			if err != nil {
+				c.sendError(CodeInternal)
+				return
+			}
+			if until != nil {
+				c.sendError(CodeChatMuted, int(time.Until(*until).Minutes())+1)
+				return
+			}
+			if code, args := checkChatModes(c, settings, msg); code != "" {
+				c.sendError(code, args...)
+				return
+			}
+		}
+
+		c.mu.Lock()
+		room := c.chatRoom
+		c.mu.Unlock()
+		msg.ID = saveChatMessage(c.sessionID, room, msg)
+		hub.broadcast(room, msg)
+	}
+}
@@ -0,0 +1,589 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 错误码，客户端据此判断错误类型
+type ErrorCode string
+
+const (
+	CodeOK                          ErrorCode = "OK"
+	CodeInternal                    ErrorCode = "INTERNAL_ERROR"
+	CodeInvalidRequest              ErrorCode = "INVALID_REQUEST"
+	CodeInvalidParam                ErrorCode = "INVALID_PARAM"
+	CodeSessionNotFound             ErrorCode = "SESSION_NOT_FOUND"
+	CodeSessionNotLive              ErrorCode = "SESSION_NOT_LIVE"
+	CodeSessionAlreadyStarted       ErrorCode = "SESSION_ALREADY_STARTED"
+	CodeSessionAlreadyEnded         ErrorCode = "SESSION_ALREADY_ENDED"
+	CodeSessionCreateFailed         ErrorCode = "SESSION_CREATE_FAILED"
+	CodeSessionGetFailed            ErrorCode = "SESSION_GET_FAILED"
+	CodeStreamCreateFailed          ErrorCode = "STREAM_CREATE_FAILED"
+	CodeInvalidStreamPath           ErrorCode = "INVALID_STREAM_PATH"
+	CodeQuestionNotFound            ErrorCode = "QUESTION_NOT_FOUND"
+	CodeQuestionCreateFailed        ErrorCode = "QUESTION_CREATE_FAILED"
+	CodeQuestionGetFailed           ErrorCode = "QUESTION_GET_FAILED"
+	CodeAnswerSubmitFailed          ErrorCode = "ANSWER_SUBMIT_FAILED"
+	CodeResultGetFailed             ErrorCode = "RESULT_GET_FAILED"
+	CodeBreakoutCountInvalid        ErrorCode = "BREAKOUT_COUNT_INVALID"
+	CodeBreakoutAlreadyOpen         ErrorCode = "BREAKOUT_ALREADY_OPEN"
+	CodeBreakoutNoneOpen            ErrorCode = "BREAKOUT_NONE_OPEN"
+	CodeBreakoutGroupNotFound       ErrorCode = "BREAKOUT_GROUP_NOT_FOUND"
+	CodeBreakoutNoStudents          ErrorCode = "BREAKOUT_NO_STUDENTS"
+	CodeBreakoutCreateFailed        ErrorCode = "BREAKOUT_CREATE_FAILED"
+	CodeBreakoutGetFailed           ErrorCode = "BREAKOUT_GET_FAILED"
+	CodeBreakoutAssignFailed        ErrorCode = "BREAKOUT_ASSIGN_FAILED"
+	CodeBreakoutCloseFailed         ErrorCode = "BREAKOUT_CLOSE_FAILED"
+	CodeSettingsGetFailed           ErrorCode = "SETTINGS_GET_FAILED"
+	CodeSettingsUpdateFailed        ErrorCode = "SETTINGS_UPDATE_FAILED"
+	CodeInvalidRaiseHand            ErrorCode = "INVALID_RAISE_HAND"
+	CodePlaybackProtocolRequired    ErrorCode = "PLAYBACK_PROTOCOL_REQUIRED"
+	CodePlaybackProtocolUnsupported ErrorCode = "PLAYBACK_PROTOCOL_UNSUPPORTED"
+	CodeWhiteboardGetFailed         ErrorCode = "WHITEBOARD_GET_FAILED"
+	CodeWhiteboardOpInvalid         ErrorCode = "WHITEBOARD_OP_INVALID"
+	CodeWhiteboardForbidden         ErrorCode = "WHITEBOARD_FORBIDDEN"
+	CodeChatDisabled                ErrorCode = "CHAT_DISABLED"
+	CodeUnauthorized                ErrorCode = "UNAUTHORIZED"
+	CodeForbidden                   ErrorCode = "FORBIDDEN"
+	CodeInvalidCredentials          ErrorCode = "INVALID_CREDENTIALS"
+	CodeUserDisabled                ErrorCode = "USER_DISABLED"
+	CodeUserNotFound                ErrorCode = "USER_NOT_FOUND"
+	CodeUserExists                  ErrorCode = "USER_EXISTS"
+	CodeUserGetFailed               ErrorCode = "USER_GET_FAILED"
+	CodeUserCreateFailed            ErrorCode = "USER_CREATE_FAILED"
+	CodeUserUpdateFailed            ErrorCode = "USER_UPDATE_FAILED"
+	CodeImpersonateNotTeacher       ErrorCode = "IMPERSONATE_NOT_TEACHER"
+	CodeStatsGetFailed              ErrorCode = "STATS_GET_FAILED"
+	CodeAuditGetFailed              ErrorCode = "AUDIT_GET_FAILED"
+	CodeUnknownPermission           ErrorCode = "UNKNOWN_PERMISSION"
+	CodePermissionUpdateFailed      ErrorCode = "PERMISSION_UPDATE_FAILED"
+	CodeSocketIOUnsupported         ErrorCode = "SOCKETIO_UNSUPPORTED"
+	CodePresenceGetFailed           ErrorCode = "PRESENCE_GET_FAILED"
+	CodeSessionBusy                 ErrorCode = "SESSION_BUSY"
+	CodeBodyTooLarge                ErrorCode = "BODY_TOO_LARGE"
+	CodeQuestionNotPushed           ErrorCode = "QUESTION_NOT_PUSHED"
+	CodeGamifyGetFailed             ErrorCode = "GAMIFY_GET_FAILED"
+	CodePickNoCandidates            ErrorCode = "PICK_NO_CANDIDATES"
+	CodePickFailed                  ErrorCode = "PICK_FAILED"
+	CodePickCountInvalid            ErrorCode = "PICK_COUNT_INVALID"
+	CodeReactionInvalid             ErrorCode = "REACTION_INVALID"
+	CodeReactionRateLimited         ErrorCode = "REACTION_RATE_LIMITED"
+	CodeReactionGetFailed           ErrorCode = "REACTION_GET_FAILED"
+	CodeQANotFound                  ErrorCode = "QA_NOT_FOUND"
+	CodeQAGetFailed                 ErrorCode = "QA_GET_FAILED"
+	CodeQAPostFailed                ErrorCode = "QA_POST_FAILED"
+	CodeQAVoteFailed                ErrorCode = "QA_VOTE_FAILED"
+	CodeQAAnswerFailed              ErrorCode = "QA_ANSWER_FAILED"
+	CodeCaptionInvalid              ErrorCode = "CAPTION_INVALID"
+	CodeCaptionSaveFailed           ErrorCode = "CAPTION_SAVE_FAILED"
+	CodeCaptionGetFailed            ErrorCode = "CAPTION_GET_FAILED"
+	CodeRecordingNotFound           ErrorCode = "RECORDING_NOT_FOUND"
+	CodeRecordingGetFailed          ErrorCode = "RECORDING_GET_FAILED"
+	CodeTranscriptGetFailed         ErrorCode = "TRANSCRIPT_GET_FAILED"
+	CodeThumbnailGetFailed          ErrorCode = "THUMBNAIL_GET_FAILED"
+	CodeRecordingJobGetFailed       ErrorCode = "RECORDING_JOB_GET_FAILED"
+	CodeRecordingJobCreateFailed    ErrorCode = "RECORDING_JOB_CREATE_FAILED"
+	CodeRecordingJobRunning         ErrorCode = "RECORDING_JOB_RUNNING"
+	CodeJobGetFailed                ErrorCode = "JOB_GET_FAILED"
+	CodeJobRetryFailed              ErrorCode = "JOB_RETRY_FAILED"
+	CodeJobNotFound                 ErrorCode = "JOB_NOT_FOUND"
+	CodeJobNotFailed                ErrorCode = "JOB_NOT_FAILED"
+	CodeExamNotFound                ErrorCode = "EXAM_NOT_FOUND"
+	CodeExamGetFailed               ErrorCode = "EXAM_GET_FAILED"
+	CodeExamCreateFailed            ErrorCode = "EXAM_CREATE_FAILED"
+	CodeExamQuestionInvalid         ErrorCode = "EXAM_QUESTION_INVALID"
+	CodeExamStartFailed             ErrorCode = "EXAM_START_FAILED"
+	CodeExamAlreadyStarted          ErrorCode = "EXAM_ALREADY_STARTED"
+	CodeExamNotRunning              ErrorCode = "EXAM_NOT_RUNNING"
+	CodeExamAnswerFailed            ErrorCode = "EXAM_ANSWER_FAILED"
+	CodeExamAlreadySubmitted        ErrorCode = "EXAM_ALREADY_SUBMITTED"
+	CodeExamCloseFailed             ErrorCode = "EXAM_CLOSE_FAILED"
+	CodeMakeupDeadlineInvalid       ErrorCode = "MAKEUP_DEADLINE_INVALID"
+	CodeMakeupGrantFailed           ErrorCode = "MAKEUP_GRANT_FAILED"
+	CodeUserDataDeleteFailed        ErrorCode = "USER_DATA_DELETE_FAILED"
+	CodeExportCreateFailed          ErrorCode = "EXPORT_CREATE_FAILED"
+	CodeExportNotFound              ErrorCode = "EXPORT_NOT_FOUND"
+	CodeExportGetFailed             ErrorCode = "EXPORT_GET_FAILED"
+	CodeFeatureDisabled             ErrorCode = "FEATURE_DISABLED"
+	CodeFeatureNotFound             ErrorCode = "FEATURE_NOT_FOUND"
+	CodeFeatureGetFailed            ErrorCode = "FEATURE_GET_FAILED"
+	CodeFeatureUpdateFailed         ErrorCode = "FEATURE_UPDATE_FAILED"
+	CodeInvalidStreamProfile        ErrorCode = "INVALID_STREAM_PROFILE"
+	CodePublishTokenInvalid         ErrorCode = "PUBLISH_TOKEN_INVALID"
+	CodePlaybackTokenInvalid        ErrorCode = "PLAYBACK_TOKEN_INVALID"
+	CodePlaybackLimitExceeded       ErrorCode = "PLAYBACK_LIMIT_EXCEEDED"
+	CodeCoursePaymentRequired       ErrorCode = "COURSE_PAYMENT_REQUIRED"
+	CodeCourseFree                  ErrorCode = "COURSE_FREE"
+	CodeAlreadyEnrolled             ErrorCode = "ALREADY_ENROLLED"
+	CodePaymentChannelUnavailable   ErrorCode = "PAYMENT_CHANNEL_UNAVAILABLE"
+	CodePriceUpdateFailed           ErrorCode = "PRICE_UPDATE_FAILED"
+	CodePriceGetFailed              ErrorCode = "PRICE_GET_FAILED"
+	CodeOrderCreateFailed           ErrorCode = "ORDER_CREATE_FAILED"
+	CodeOrderGetFailed              ErrorCode = "ORDER_GET_FAILED"
+	CodeOrderNotFound               ErrorCode = "ORDER_NOT_FOUND"
+	CodeAccessCodeNotFound          ErrorCode = "ACCESS_CODE_NOT_FOUND"
+	CodeAccessCodeExpired           ErrorCode = "ACCESS_CODE_EXPIRED"
+	CodeAccessCodeExhausted         ErrorCode = "ACCESS_CODE_EXHAUSTED"
+	CodeAccessCodeRedeemed          ErrorCode = "ACCESS_CODE_REDEEMED"
+	CodeAccessCodeCreateFailed      ErrorCode = "ACCESS_CODE_CREATE_FAILED"
+	CodeAccessCodeGetFailed         ErrorCode = "ACCESS_CODE_GET_FAILED"
+	CodeChapterNotFound             ErrorCode = "CHAPTER_NOT_FOUND"
+	CodeLessonNotFound              ErrorCode = "LESSON_NOT_FOUND"
+	CodeLessonNotVOD                ErrorCode = "LESSON_NOT_VOD"
+	CodeSyllabusGetFailed           ErrorCode = "SYLLABUS_GET_FAILED"
+	CodeSyllabusUpdateFailed        ErrorCode = "SYLLABUS_UPDATE_FAILED"
+	CodeVideoUploadFailed           ErrorCode = "VIDEO_UPLOAD_FAILED"
+	CodeProgressUpdateFailed        ErrorCode = "PROGRESS_UPDATE_FAILED"
+	CodeCheckpointNotFound          ErrorCode = "CHECKPOINT_NOT_FOUND"
+	CodeCheckpointGetFailed         ErrorCode = "CHECKPOINT_GET_FAILED"
+	CodeCheckpointCreateFailed      ErrorCode = "CHECKPOINT_CREATE_FAILED"
+	CodeCheckpointDeleteFailed      ErrorCode = "CHECKPOINT_DELETE_FAILED"
+	CodeClipNotFound                ErrorCode = "CLIP_NOT_FOUND"
+	CodeClipGetFailed               ErrorCode = "CLIP_GET_FAILED"
+	CodeClipCreateFailed            ErrorCode = "CLIP_CREATE_FAILED"
+	CodeClipDeleteFailed            ErrorCode = "CLIP_DELETE_FAILED"
+	CodeClipNotReady                ErrorCode = "CLIP_NOT_READY"
+	CodeClipShareInvalid            ErrorCode = "CLIP_SHARE_INVALID"
+	CodeRecordingNotReady           ErrorCode = "RECORDING_NOT_READY"
+	CodeMarkerCreateFailed          ErrorCode = "MARKER_CREATE_FAILED"
+	CodeMarkerGetFailed             ErrorCode = "MARKER_GET_FAILED"
+	CodePublisherNotFound           ErrorCode = "PUBLISHER_NOT_FOUND"
+	CodePublisherGetFailed          ErrorCode = "PUBLISHER_GET_FAILED"
+	CodePublisherCreateFailed       ErrorCode = "PUBLISHER_CREATE_FAILED"
+	CodePublisherUpdateFailed       ErrorCode = "PUBLISHER_UPDATE_FAILED"
+	CodeQoEIngestFailed             ErrorCode = "QOE_INGEST_FAILED"
+	CodeQoEGetFailed                ErrorCode = "QOE_GET_FAILED"
+	CodeInvalidPhone                ErrorCode = "INVALID_PHONE"
+	CodeSMSLoginDisabled            ErrorCode = "SMS_LOGIN_DISABLED"
+	CodeSMSRateLimited              ErrorCode = "SMS_RATE_LIMITED"
+	CodeSMSSendFailed               ErrorCode = "SMS_SEND_FAILED"
+	CodeSMSCodeInvalid              ErrorCode = "SMS_CODE_INVALID"
+	CodeSMSVerifyFailed             ErrorCode = "SMS_VERIFY_FAILED"
+	CodeOIDCDisabled                ErrorCode = "OIDC_DISABLED"
+	CodeOIDCStateInvalid            ErrorCode = "OIDC_STATE_INVALID"
+	CodeOIDCLoginFailed             ErrorCode = "OIDC_LOGIN_FAILED"
+	CodeOIDCLinkRequired            ErrorCode = "OIDC_LINK_REQUIRED"
+	CodeLTIPlatformUnknown          ErrorCode = "LTI_PLATFORM_UNKNOWN"
+	CodeLTILaunchInvalid            ErrorCode = "LTI_LAUNCH_INVALID"
+	CodeLTILaunchFailed             ErrorCode = "LTI_LAUNCH_FAILED"
+	CodeLTICourseNotMapped          ErrorCode = "LTI_COURSE_NOT_MAPPED"
+	CodeLTIContextGetFailed         ErrorCode = "LTI_CONTEXT_GET_FAILED"
+	CodeLTIContextSetFailed         ErrorCode = "LTI_CONTEXT_SET_FAILED"
+	CodeProvisionTooMany            ErrorCode = "PROVISION_TOO_MANY"
+	CodeProvisionFailed             ErrorCode = "PROVISION_FAILED"
+	CodeRosterCSVInvalid            ErrorCode = "ROSTER_CSV_INVALID"
+	CodeClassGroupNotFound          ErrorCode = "CLASS_GROUP_NOT_FOUND"
+	CodeClassGroupGetFailed         ErrorCode = "CLASS_GROUP_GET_FAILED"
+	CodeClassGroupSaveFailed        ErrorCode = "CLASS_GROUP_SAVE_FAILED"
+	CodeClassGroupExists            ErrorCode = "CLASS_GROUP_EXISTS"
+	CodeClassGroupTooLarge          ErrorCode = "CLASS_GROUP_TOO_LARGE"
+	CodeClassGroupEnrollFailed      ErrorCode = "CLASS_GROUP_ENROLL_FAILED"
+	CodeSessionTransferFailed       ErrorCode = "SESSION_TRANSFER_FAILED"
+	CodeChatExportFailed            ErrorCode = "CHAT_EXPORT_FAILED"
+	CodeChatMuted                   ErrorCode = "CHAT_MUTED"
+	CodeChatMessageNotFound         ErrorCode = "CHAT_MESSAGE_NOT_FOUND"
+	CodeReportDuplicate             ErrorCode = "REPORT_DUPLICATE"
+	CodeReportNotFound              ErrorCode = "REPORT_NOT_FOUND"
+	CodeReportFailed                ErrorCode = "REPORT_FAILED"
+	CodeReportGetFailed             ErrorCode = "REPORT_GET_FAILED"
+	CodeChatSlowMode                ErrorCode = "CHAT_SLOW_MODE"
+	CodeChatMembersOnly             ErrorCode = "CHAT_MEMBERS_ONLY"
+	CodeChatEmojiOnly               ErrorCode = "CHAT_EMOJI_ONLY"
+	CodeQuestionDraft               ErrorCode = "QUESTION_DRAFT"
+	CodeQuestionPublishFailed       ErrorCode = "QUESTION_PUBLISH_FAILED"
+	CodeQuestionMediaInvalid        ErrorCode = "QUESTION_MEDIA_INVALID"
+	CodeQuestionMediaUnsupported    ErrorCode = "QUESTION_MEDIA_UNSUPPORTED"
+	CodeQuestionMediaUploadFailed   ErrorCode = "QUESTION_MEDIA_UPLOAD_FAILED"
+	CodeQuestionClosed              ErrorCode = "QUESTION_CLOSED"
+	CodeQuestionCloseFailed         ErrorCode = "QUESTION_CLOSE_FAILED"
+	CodeAnswerLocked                ErrorCode = "ANSWER_LOCKED"
+	CodeAnswerGetFailed             ErrorCode = "ANSWER_GET_FAILED"
+	CodeAnswerSuperseded            ErrorCode = "ANSWER_SUPERSEDED"
+	CodeAnswerSyncExpired           ErrorCode = "ANSWER_SYNC_EXPIRED"
+	CodeWSTokenRequired             ErrorCode = "WS_TOKEN_REQUIRED"
+	CodeNetworkRestricted           ErrorCode = "NETWORK_RESTRICTED"
+	CodeNetworkPolicyGetFailed      ErrorCode = "NETWORK_POLICY_GET_FAILED"
+	CodeNetworkPolicyUpdateFailed   ErrorCode = "NETWORK_POLICY_UPDATE_FAILED"
+	CodeNetworkPolicyNotFound       ErrorCode = "NETWORK_POLICY_NOT_FOUND"
+	CodeWaitlistGetFailed           ErrorCode = "WAITLIST_GET_FAILED"
+	CodeSnapshotGetFailed           ErrorCode = "SNAPSHOT_GET_FAILED"
+	CodeSlideUpdateFailed           ErrorCode = "SLIDE_UPDATE_FAILED"
+	CodeAnnouncementGetFailed       ErrorCode = "ANNOUNCEMENT_GET_FAILED"
+	CodeAnnouncementCreateFailed    ErrorCode = "ANNOUNCEMENT_CREATE_FAILED"
+	CodeAnnouncementNotFound        ErrorCode = "ANNOUNCEMENT_NOT_FOUND"
+	CodeAnnouncementLimit           ErrorCode = "ANNOUNCEMENT_LIMIT"
+	CodeTimerGetFailed              ErrorCode = "TIMER_GET_FAILED"
+	CodeTimerCreateFailed           ErrorCode = "TIMER_CREATE_FAILED"
+	CodeTimerUpdateFailed           ErrorCode = "TIMER_UPDATE_FAILED"
+	CodeTimerNotFound               ErrorCode = "TIMER_NOT_FOUND"
+	CodeTimerLimit                  ErrorCode = "TIMER_LIMIT"
+	CodeQuizRuleGetFailed           ErrorCode = "QUIZ_RULE_GET_FAILED"
+	CodeQuizRuleUpdateFailed        ErrorCode = "QUIZ_RULE_UPDATE_FAILED"
+	CodeQuizRuleNotFound            ErrorCode = "QUIZ_RULE_NOT_FOUND"
+	CodeQuizRuleLimit               ErrorCode = "QUIZ_RULE_LIMIT"
+	CodeSeriesNotFound              ErrorCode = "SERIES_NOT_FOUND"
+	CodeSeriesEmpty                 ErrorCode = "SERIES_EMPTY"
+	CodeSeriesTooLarge              ErrorCode = "SERIES_TOO_LARGE"
+	CodeSeriesCancelFailed          ErrorCode = "SERIES_CANCEL_FAILED"
+	CodeHolidayGetFailed            ErrorCode = "HOLIDAY_GET_FAILED"
+	CodeHolidayUpdateFailed         ErrorCode = "HOLIDAY_UPDATE_FAILED"
+	CodeHolidayNotFound             ErrorCode = "HOLIDAY_NOT_FOUND"
+	CodeSessionNotCancellable       ErrorCode = "SESSION_NOT_CANCELLABLE"
+	CodeSessionCancelFailed         ErrorCode = "SESSION_CANCEL_FAILED"
+	CodeNotificationGetFailed       ErrorCode = "NOTIFICATION_GET_FAILED"
+	CodeNotificationNotFound        ErrorCode = "NOTIFICATION_NOT_FOUND"
+	CodeMakeupNotAllowed            ErrorCode = "MAKEUP_NOT_ALLOWED"
+	CodeMakeupExists                ErrorCode = "MAKEUP_EXISTS"
+	CodeMakeupNotFound              ErrorCode = "MAKEUP_NOT_FOUND"
+	CodeMakeupLinkFailed            ErrorCode = "MAKEUP_LINK_FAILED"
+	CodeLoginSessionGetFailed       ErrorCode = "LOGIN_SESSION_GET_FAILED"
+	CodeLoginSessionRevokeFailed    ErrorCode = "LOGIN_SESSION_REVOKE_FAILED"
+	CodeLoginSessionNotFound        ErrorCode = "LOGIN_SESSION_NOT_FOUND"
+	CodeLoginRevoked                ErrorCode = "LOGIN_REVOKED"
+	CodeExamOtherDevice             ErrorCode = "EXAM_OTHER_DEVICE"
+	CodeRefreshTokenInvalid         ErrorCode = "REFRESH_TOKEN_INVALID"
+	CodeRefreshTokenReused          ErrorCode = "REFRESH_TOKEN_REUSED"
+	CodeAPIKeyInvalid               ErrorCode = "API_KEY_INVALID"
+	CodeAPIKeyRateLimited           ErrorCode = "API_KEY_RATE_LIMITED"
+	CodeAPIKeyNotFound              ErrorCode = "API_KEY_NOT_FOUND"
+	CodeAPIKeyGetFailed             ErrorCode = "API_KEY_GET_FAILED"
+	CodeAPIKeyUpdateFailed          ErrorCode = "API_KEY_UPDATE_FAILED"
+	CodeAPIKeyRevoked               ErrorCode = "API_KEY_REVOKED"
+	CodeMediaServerUnavailable      ErrorCode = "MEDIA_SERVER_UNAVAILABLE"
+	CodeStreamNotFound              ErrorCode = "STREAM_NOT_FOUND"
+	CodeRelayNotFound               ErrorCode = "RELAY_NOT_FOUND"
+	CodeRelayGetFailed              ErrorCode = "RELAY_GET_FAILED"
+	CodeRelayUpdateFailed           ErrorCode = "RELAY_UPDATE_FAILED"
+	CodeRelayInvalidURL             ErrorCode = "RELAY_INVALID_URL"
+	CodeRecordingPolicyGetFailed    ErrorCode = "RECORDING_POLICY_GET_FAILED"
+	CodeRecordingPolicyUpdateFailed ErrorCode = "RECORDING_POLICY_UPDATE_FAILED"
+	CodeRecordingPolicyNotFound     ErrorCode = "RECORDING_POLICY_NOT_FOUND"
+	CodeReconcileInProgress         ErrorCode = "RECONCILE_IN_PROGRESS"
+	CodeNotFound                    ErrorCode = "NOT_FOUND"
+	CodeConflict                    ErrorCode = "CONFLICT"
+	CodeInvalidState                ErrorCode = "INVALID_STATE"
+	CodeExpired                     ErrorCode = "EXPIRED"
+)
+
+const (
+	langEN = "en"
+	langZH = "zh"
+
+	defaultLang = langEN
+)
+
+// 错误信息，按语言区分，支持 fmt 格式化参数
+var errorMessages = map[ErrorCode]map[string]string{
+	CodeOK:                          {langEN: "success", langZH: "成功"},
+	CodeInternal:                    {langEN: "Internal server error", langZH: "服务器内部错误"},
+	CodeInvalidRequest:              {langEN: "Invalid request", langZH: "请求参数错误"},
+	CodeInvalidParam:                {langEN: "Invalid %s", langZH: "参数 %s 无效"},
+	CodeSessionNotFound:             {langEN: "Live session not found", langZH: "直播会话不存在"},
+	CodeSessionNotLive:              {langEN: "Live session is not live", langZH: "直播会话未在直播中"},
+	CodeSessionAlreadyStarted:       {langEN: "Live session not found or already started", langZH: "直播会话不存在或已开始"},
+	CodeSessionAlreadyEnded:         {langEN: "Live session not found or already ended", langZH: "直播会话不存在或已结束"},
+	CodeSessionCreateFailed:         {langEN: "Failed to create live session", langZH: "创建直播会话失败"},
+	CodeSessionGetFailed:            {langEN: "Failed to get live session", langZH: "获取直播会话失败"},
+	CodeStreamCreateFailed:          {langEN: "Failed to create stream in Livego", langZH: "创建直播流失败"},
+	CodeInvalidStreamPath:           {langEN: "Invalid stream path", langZH: "直播流路径无效"},
+	CodeQuestionNotFound:            {langEN: "Question not found", langZH: "题目不存在"},
+	CodeQuestionCreateFailed:        {langEN: "Failed to create question", langZH: "创建题目失败"},
+	CodeQuestionGetFailed:           {langEN: "Failed to get question", langZH: "获取题目失败"},
+	CodeAnswerSubmitFailed:          {langEN: "Failed to submit answer", langZH: "提交答案失败"},
+	CodeResultGetFailed:             {langEN: "Failed to get result", langZH: "获取答题结果失败"},
+	CodeBreakoutCountInvalid:        {langEN: "Group count must be between 1 and %d", langZH: "分组数量必须在 1 到 %d 之间"},
+	CodeBreakoutAlreadyOpen:         {langEN: "Breakout groups already open, close them first", langZH: "分组讨论已开启，请先关闭"},
+	CodeBreakoutNoneOpen:            {langEN: "No open breakout groups", langZH: "没有进行中的分组讨论"},
+	CodeBreakoutGroupNotFound:       {langEN: "Breakout group %d not found", langZH: "分组 %d 不存在"},
+	CodeBreakoutNoStudents:          {langEN: "No students to assign", langZH: "没有可分配的学生"},
+	CodeBreakoutCreateFailed:        {langEN: "Failed to create breakout groups", langZH: "创建分组失败"},
+	CodeBreakoutGetFailed:           {langEN: "Failed to get breakout groups", langZH: "获取分组失败"},
+	CodeBreakoutAssignFailed:        {langEN: "Failed to assign students", langZH: "分配学生失败"},
+	CodeBreakoutCloseFailed:         {langEN: "Failed to close breakout groups", langZH: "关闭分组失败"},
+	CodeSettingsGetFailed:           {langEN: "Failed to get session settings", langZH: "获取会话设置失败"},
+	CodeSettingsUpdateFailed:        {langEN: "Failed to update session settings", langZH: "更新会话设置失败"},
+	CodeInvalidRaiseHand:            {langEN: "Invalid raise_hand, must be one of: %s", langZH: "举手设置无效，可选值：%s"},
+	CodePlaybackProtocolRequired:    {langEN: "At least one playback protocol is required", langZH: "至少需要一种播放协议"},
+	CodePlaybackProtocolUnsupported: {langEN: "Unsupported playback protocol: %s", langZH: "不支持的播放协议：%s"},
+	CodeWhiteboardGetFailed:         {langEN: "Failed to get whiteboard", langZH: "获取白板失败"},
+	CodeWhiteboardOpInvalid:         {langEN: "Invalid whiteboard op", langZH: "白板操作无效"},
+	CodeWhiteboardForbidden:         {langEN: "Only the teacher can draw", langZH: "只有老师可以使用白板"},
+	CodeChatDisabled:                {langEN: "Chat is disabled", langZH: "聊天已关闭"},
+	CodeUnauthorized:                {langEN: "Authentication required", langZH: "请先登录"},
+	CodeForbidden:                   {langEN: "Permission denied", langZH: "没有权限"},
+	CodeInvalidCredentials:          {langEN: "Invalid username or password", langZH: "用户名或密码错误"},
+	CodeUserDisabled:                {langEN: "User is disabled", langZH: "用户已被禁用"},
+	CodeUserNotFound:                {langEN: "User not found", langZH: "用户不存在"},
+	CodeUserExists:                  {langEN: "Username already exists", langZH: "用户名已存在"},
+	CodeUserGetFailed:               {langEN: "Failed to get user", langZH: "获取用户失败"},
+	CodeUserCreateFailed:            {langEN: "Failed to create user", langZH: "创建用户失败"},
+	CodeUserUpdateFailed:            {langEN: "Failed to update user", langZH: "更新用户失败"},
+	CodeImpersonateNotTeacher:       {langEN: "Only active teachers can be impersonated", langZH: "只能代登录在职老师账号"},
+	CodeStatsGetFailed:              {langEN: "Failed to get stats", langZH: "获取统计数据失败"},
+	CodeAuditGetFailed:              {langEN: "Failed to get audit logs", langZH: "获取审计日志失败"},
+	CodeUnknownPermission:           {langEN: "Unknown permission: %s", langZH: "未知权限：%s"},
+	CodePermissionUpdateFailed:      {langEN: "Failed to update permissions", langZH: "更新权限失败"},
+	CodeSocketIOUnsupported:         {langEN: "Only Engine.IO v4 over websocket transport is supported", langZH: "仅支持 Engine.IO v4 的 websocket 传输方式"},
+	CodePresenceGetFailed:           {langEN: "Failed to get online users", langZH: "获取在线用户失败"},
+	CodeSessionBusy:                 {langEN: "Session is being updated by another request, please retry", langZH: "会话正在被其他请求处理，请稍后重试"},
+	CodeBodyTooLarge:                {langEN: "Request body exceeds the %d byte limit", langZH: "请求体超过 %d 字节限制"},
+	CodeQuestionNotPushed:           {langEN: "Question has not been pushed yet", langZH: "题目尚未推送"},
+	CodeGamifyGetFailed:             {langEN: "Failed to get points", langZH: "获取积分失败"},
+	CodePickNoCandidates:            {langEN: "No online students available to pick", langZH: "没有可抽取的在线学生"},
+	CodePickFailed:                  {langEN: "Failed to pick students", langZH: "随机点名失败"},
+	CodePickCountInvalid:            {langEN: "Pick count must be between 1 and %d", langZH: "抽取人数必须在 1 到 %d 之间"},
+	CodeReactionInvalid:             {langEN: "Unsupported reaction", langZH: "不支持的表情反馈"},
+	CodeReactionRateLimited:         {langEN: "Too many reactions, slow down", langZH: "表情反馈过于频繁，请稍后再试"},
+	CodeReactionGetFailed:           {langEN: "Failed to get reactions", langZH: "获取表情反馈失败"},
+	CodeQANotFound:                  {langEN: "Question not found on the Q&A board", langZH: "问答不存在"},
+	CodeQAGetFailed:                 {langEN: "Failed to get Q&A", langZH: "获取问答失败"},
+	CodeQAPostFailed:                {langEN: "Failed to post question", langZH: "提问失败"},
+	CodeQAVoteFailed:                {langEN: "Failed to vote", langZH: "投票失败"},
+	CodeQAAnswerFailed:              {langEN: "Failed to mark question answered", langZH: "标记已回答失败"},
+	CodeCaptionInvalid:              {langEN: "Caption segment %d has an invalid time range", langZH: "第 %d 条字幕的时间范围无效"},
+	CodeCaptionSaveFailed:           {langEN: "Failed to save captions", langZH: "保存字幕失败"},
+	CodeCaptionGetFailed:            {langEN: "Failed to get captions", langZH: "获取字幕失败"},
+	CodeRecordingNotFound:           {langEN: "Recording not found", langZH: "录像不存在"},
+	CodeRecordingGetFailed:          {langEN: "Failed to get recording", langZH: "获取录像失败"},
+	CodeTranscriptGetFailed:         {langEN: "Failed to get transcript", langZH: "获取转写文本失败"},
+	CodeThumbnailGetFailed:          {langEN: "Failed to get thumbnails", langZH: "获取封面截图失败"},
+	CodeRecordingJobGetFailed:       {langEN: "Failed to get recording jobs", langZH: "获取录像处理任务失败"},
+	CodeRecordingJobCreateFailed:    {langEN: "Failed to create recording job", langZH: "创建录像处理任务失败"},
+	CodeRecordingJobRunning:         {langEN: "Recording is already being processed", langZH: "录像正在处理中"},
+	CodeJobGetFailed:                {langEN: "Failed to get jobs", langZH: "获取后台任务失败"},
+	CodeJobRetryFailed:              {langEN: "Failed to retry job", langZH: "重试后台任务失败"},
+	CodeJobNotFound:                 {langEN: "Job not found", langZH: "后台任务不存在"},
+	CodeJobNotFailed:                {langEN: "Only failed jobs can be retried", langZH: "只能重试失败的任务"},
+	CodeExamNotFound:                {langEN: "Exam not found", langZH: "测验不存在"},
+	CodeExamGetFailed:               {langEN: "Failed to get exam", langZH: "获取测验失败"},
+	CodeExamCreateFailed:            {langEN: "Failed to create exam", langZH: "创建测验失败"},
+	CodeExamQuestionInvalid:         {langEN: "Questions must belong to the exam's course", langZH: "题目不属于该测验或课程"},
+	CodeExamStartFailed:             {langEN: "Failed to start exam", langZH: "开始测验失败"},
+	CodeExamAlreadyStarted:          {langEN: "Exam has already started", langZH: "测验已开始"},
+	CodeExamNotRunning:              {langEN: "Exam is not in progress", langZH: "测验未在进行中"},
+	CodeExamAnswerFailed:            {langEN: "Failed to save exam answer", langZH: "保存测验答案失败"},
+	CodeExamAlreadySubmitted:        {langEN: "Exam already submitted", langZH: "已交卷"},
+	CodeExamCloseFailed:             {langEN: "Failed to close exam", langZH: "结束测验失败"},
+	CodeMakeupDeadlineInvalid:       {langEN: "Makeup deadline must be in the future", langZH: "补答截止时间必须晚于当前时间"},
+	CodeMakeupGrantFailed:           {langEN: "Failed to open makeup", langZH: "开放补答失败"},
+	CodeUserDataDeleteFailed:        {langEN: "Failed to delete user data", langZH: "删除用户数据失败"},
+	CodeExportCreateFailed:          {langEN: "Failed to create export", langZH: "创建导出任务失败"},
+	CodeExportNotFound:              {langEN: "Export not found", langZH: "导出任务不存在"},
+	CodeExportGetFailed:             {langEN: "Failed to get export", langZH: "获取导出任务失败"},
+	CodeFeatureDisabled:             {langEN: "Feature is not enabled", langZH: "该功能未开启"},
+	CodeFeatureNotFound:             {langEN: "Feature not found", langZH: "功能不存在"},
+	CodeFeatureGetFailed:            {langEN: "Failed to get features", langZH: "获取功能开关失败"},
+	CodeFeatureUpdateFailed:         {langEN: "Failed to update feature", langZH: "修改功能开关失败"},
+	CodeInvalidStreamProfile:        {langEN: "Invalid stream_profile, must be one of: %s", langZH: "推流档位无效，可选值：%s"},
+	CodePublishTokenInvalid:         {langEN: "Publish token is missing, invalid or expired", langZH: "推流令牌缺失、无效或已过期"},
+	CodePlaybackTokenInvalid:        {langEN: "Playback token is missing, invalid or expired", langZH: "播放令牌缺失、无效或已过期"},
+	CodePlaybackLimitExceeded:       {langEN: "This account is already playing on %d device(s)", langZH: "该账号已在 %d 台设备上播放"},
+	CodeCoursePaymentRequired:       {langEN: "This course requires purchase", langZH: "该课程需购买后才能参加"},
+	CodeCourseFree:                  {langEN: "This course is free and does not need an order", langZH: "该课程免费，无需下单"},
+	CodeAlreadyEnrolled:             {langEN: "Already enrolled in this course", langZH: "已报名该课程"},
+	CodePaymentChannelUnavailable:   {langEN: "Payment channel %s is not available", langZH: "支付渠道 %s 不可用"},
+	CodePriceUpdateFailed:           {langEN: "Failed to update course price", langZH: "更新课程价格失败"},
+	CodePriceGetFailed:              {langEN: "Failed to get course price", langZH: "获取课程价格失败"},
+	CodeOrderCreateFailed:           {langEN: "Failed to create order", langZH: "创建订单失败"},
+	CodeOrderGetFailed:              {langEN: "Failed to get order", langZH: "获取订单失败"},
+	CodeOrderNotFound:               {langEN: "Order not found", langZH: "订单不存在"},
+	CodeAccessCodeNotFound:          {langEN: "Access code not found", langZH: "兑换码不存在"},
+	CodeAccessCodeExpired:           {langEN: "Access code has expired", langZH: "兑换码已过期"},
+	CodeAccessCodeExhausted:         {langEN: "Access code has reached its usage limit", langZH: "兑换码已达使用次数上限"},
+	CodeAccessCodeRedeemed:          {langEN: "You have already redeemed this access code", langZH: "你已使用过该兑换码"},
+	CodeAccessCodeCreateFailed:      {langEN: "Failed to create access codes", langZH: "生成兑换码失败"},
+	CodeAccessCodeGetFailed:         {langEN: "Failed to get access codes", langZH: "获取兑换码失败"},
+	CodeChapterNotFound:             {langEN: "Chapter not found", langZH: "章节不存在"},
+	CodeLessonNotFound:              {langEN: "Lesson not found", langZH: "课时不存在"},
+	CodeLessonNotVOD:                {langEN: "Lesson is not a video lesson", langZH: "该课时不是录播课"},
+	CodeSyllabusGetFailed:           {langEN: "Failed to get syllabus", langZH: "获取课程目录失败"},
+	CodeSyllabusUpdateFailed:        {langEN: "Failed to update syllabus", langZH: "更新课程目录失败"},
+	CodeVideoUploadFailed:           {langEN: "Failed to upload video", langZH: "上传视频失败"},
+	CodeProgressUpdateFailed:        {langEN: "Failed to update learning progress", langZH: "更新学习进度失败"},
+	CodeCheckpointNotFound:          {langEN: "Checkpoint not found", langZH: "打点题不存在"},
+	CodeCheckpointGetFailed:         {langEN: "Failed to get checkpoints", langZH: "获取打点题失败"},
+	CodeCheckpointCreateFailed:      {langEN: "Failed to create checkpoint", langZH: "创建打点题失败"},
+	CodeCheckpointDeleteFailed:      {langEN: "Failed to delete checkpoint", langZH: "删除打点题失败"},
+	CodeClipNotFound:                {langEN: "Clip not found", langZH: "片段不存在"},
+	CodeClipGetFailed:               {langEN: "Failed to get clips", langZH: "获取片段失败"},
+	CodeClipCreateFailed:            {langEN: "Failed to create clip", langZH: "创建片段失败"},
+	CodeClipDeleteFailed:            {langEN: "Failed to delete clip", langZH: "删除片段失败"},
+	CodeClipNotReady:                {langEN: "Clip is still being generated", langZH: "片段尚未生成"},
+	CodeClipShareInvalid:            {langEN: "Share link is invalid or expired", langZH: "分享链接无效或已过期"},
+	CodeRecordingNotReady:           {langEN: "Recording has not finished processing", langZH: "录像尚未处理完成"},
+	CodeMarkerCreateFailed:          {langEN: "Failed to create marker", langZH: "标记重点失败"},
+	CodeMarkerGetFailed:             {langEN: "Failed to get timeline", langZH: "获取时间轴失败"},
+	CodePublisherNotFound:           {langEN: "Publisher not found", langZH: "推流端不存在"},
+	CodePublisherGetFailed:          {langEN: "Failed to get publishers", langZH: "获取推流端失败"},
+	CodePublisherCreateFailed:       {langEN: "Failed to add publisher", langZH: "添加推流端失败"},
+	CodePublisherUpdateFailed:       {langEN: "Failed to update publisher", langZH: "更新推流端失败"},
+	CodeQoEIngestFailed:             {langEN: "Failed to record playback quality", langZH: "记录播放质量失败"},
+	CodeQoEGetFailed:                {langEN: "Failed to get playback quality report", langZH: "获取播放质量报告失败"},
+	CodeInvalidPhone:                {langEN: "Invalid phone number", langZH: "手机号格式不正确"},
+	CodeSMSLoginDisabled:            {langEN: "SMS login is not enabled", langZH: "未开启短信验证码登录"},
+	CodeSMSRateLimited:              {langEN: "Too many verification codes requested, try again later", langZH: "验证码发送过于频繁，请稍后再试"},
+	CodeSMSSendFailed:               {langEN: "Failed to send verification code", langZH: "发送验证码失败"},
+	CodeSMSCodeInvalid:              {langEN: "Verification code is invalid or expired", langZH: "验证码错误或已过期"},
+	CodeSMSVerifyFailed:             {langEN: "Failed to verify code", langZH: "验证码校验失败"},
+	CodeOIDCDisabled:                {langEN: "Single sign-on is not enabled", langZH: "未开启统一身份认证登录"},
+	CodeOIDCStateInvalid:            {langEN: "Sign-on request is invalid or expired, please try again", langZH: "登录请求无效或已过期，请重新登录"},
+	CodeOIDCLoginFailed:             {langEN: "Single sign-on failed", langZH: "统一身份认证登录失败"},
+	CodeOIDCLinkRequired:            {langEN: "An account with this username already exists, enter its password to link it", langZH: "已存在同名账号，请输入该账号的密码完成关联"},
+	CodeLTIPlatformUnknown:          {langEN: "Unknown LTI platform", langZH: "未登记的 LTI 平台"},
+	CodeLTILaunchInvalid:            {langEN: "LTI launch is invalid or expired", langZH: "LTI 启动请求无效或已过期"},
+	CodeLTILaunchFailed:             {langEN: "LTI launch failed", langZH: "LTI 启动失败"},
+	CodeLTICourseNotMapped:          {langEN: "This LMS course is not linked to a course", langZH: "该 LMS 课程尚未关联课程"},
+	CodeLTIContextGetFailed:         {langEN: "Failed to get LTI course links", langZH: "获取 LTI 课程关联失败"},
+	CodeLTIContextSetFailed:         {langEN: "Failed to link LTI course", langZH: "设置 LTI 课程关联失败"},
+	CodeProvisionTooMany:            {langEN: "At most %d users can be synced at once", langZH: "单次最多同步 %d 个用户"},
+	CodeProvisionFailed:             {langEN: "Failed to sync users", langZH: "同步用户失败"},
+	CodeRosterCSVInvalid:            {langEN: "Invalid roster CSV: %s", langZH: "花名册 CSV 格式错误：%s"},
+	CodeClassGroupNotFound:          {langEN: "Class group not found", langZH: "班级不存在"},
+	CodeClassGroupGetFailed:         {langEN: "Failed to get class groups", langZH: "获取班级失败"},
+	CodeClassGroupSaveFailed:        {langEN: "Failed to save class group", langZH: "保存班级失败"},
+	CodeClassGroupExists:            {langEN: "A class group with this name already exists", langZH: "同名班级已存在"},
+	CodeClassGroupTooLarge:          {langEN: "A class group can have at most %d members", langZH: "每个班级最多 %d 名学生"},
+	CodeClassGroupEnrollFailed:      {langEN: "Failed to enroll class group", langZH: "班级报名失败"},
+	CodeSessionTransferFailed:       {langEN: "Failed to transfer live session", langZH: "移交直播会话失败"},
+	CodeChatExportFailed:            {langEN: "Failed to export chat", langZH: "导出聊天记录失败"},
+	CodeChatMuted:                   {langEN: "You are muted in this session for another %d minutes", langZH: "你已被禁言，%d 分钟后可再发言"},
+	CodeChatMessageNotFound:         {langEN: "Chat message not found", langZH: "聊天消息不存在"},
+	CodeReportDuplicate:             {langEN: "You have already reported this", langZH: "你已举报过该内容"},
+	CodeReportNotFound:              {langEN: "Report not found", langZH: "举报不存在"},
+	CodeReportFailed:                {langEN: "Failed to process report", langZH: "处理举报失败"},
+	CodeReportGetFailed:             {langEN: "Failed to get reports", langZH: "获取举报失败"},
+	CodeChatSlowMode:                {langEN: "Slow mode is on, please wait %d seconds before sending another message", langZH: "慢速模式已开启，请 %d 秒后再发言"},
+	CodeChatMembersOnly:             {langEN: "Only enrolled students can chat in this session", langZH: "本场直播仅限已报名的学生发言"},
+	CodeChatEmojiOnly:               {langEN: "Only emoji can be sent in this session right now", langZH: "当前仅允许发送表情"},
+	CodeQuestionDraft:               {langEN: "Question is still a draft, publish it first", langZH: "题目仍是草稿，请先发布"},
+	CodeQuestionPublishFailed:       {langEN: "Failed to publish question", langZH: "发布题目失败"},
+	CodeQuestionMediaInvalid:        {langEN: "Question media %v was not uploaded or does not match the block type", langZH: "题目素材 %v 未上传或与内容块类型不符"},
+	CodeQuestionMediaUnsupported:    {langEN: "Unsupported media type %s, images must be PNG, JPEG, GIF or WebP and audio MP3, M4A, OGG, WAV or WebM", langZH: "不支持的素材格式 %s，图片须为 PNG、JPEG、GIF 或 WebP，音频须为 MP3、M4A、OGG、WAV 或 WebM"},
+	CodeQuestionMediaUploadFailed:   {langEN: "Failed to upload question media", langZH: "上传题目素材失败"},
+	CodeQuestionClosed:              {langEN: "Answering for this question has closed", langZH: "该题已截止作答"},
+	CodeQuestionCloseFailed:         {langEN: "Failed to close question", langZH: "结束作答失败"},
+	CodeAnswerLocked:                {langEN: "Answers cannot be changed in fastest-answer mode", langZH: "抢答模式下提交后不能修改答案"},
+	CodeAnswerGetFailed:             {langEN: "Failed to get answer", langZH: "获取答案失败"},
+	CodeAnswerSuperseded:            {langEN: "A newer answer to this question has already been saved", langZH: "该题已保存了更新的答案"},
+	CodeAnswerSyncExpired:           {langEN: "The queued answer is too old to be accepted", langZH: "离线答案已超过同步时限"},
+	CodeWSTokenRequired:             {langEN: "Use a connection token from the ws-token endpoint instead of access_token", langZH: "请通过 ws-token 接口获取连接令牌，不要在地址中传入 access_token"},
+	CodeNetworkRestricted:           {langEN: "This course can only be accessed from the campus network", langZH: "该课程只能在校园网内观看或作答"},
+	CodeNetworkPolicyGetFailed:      {langEN: "Failed to get network restrictions", langZH: "获取网络限制失败"},
+	CodeNetworkPolicyUpdateFailed:   {langEN: "Failed to update network restriction", langZH: "更新网络限制失败"},
+	CodeNetworkPolicyNotFound:       {langEN: "Network restriction not found", langZH: "网络限制不存在"},
+	CodeWaitlistGetFailed:           {langEN: "Failed to get waitlist", langZH: "获取候补名单失败"},
+	CodeSnapshotGetFailed:           {langEN: "Failed to get session snapshot", langZH: "获取课堂状态失败"},
+	CodeSlideUpdateFailed:           {langEN: "Failed to update current slide", langZH: "更新课件页码失败"},
+	CodeAnnouncementGetFailed:       {langEN: "Failed to get announcements", langZH: "获取公告失败"},
+	CodeAnnouncementCreateFailed:    {langEN: "Failed to save announcement", langZH: "保存公告失败"},
+	CodeAnnouncementNotFound:        {langEN: "Announcement not found", langZH: "公告不存在"},
+	CodeAnnouncementLimit:           {langEN: "At most %d announcements can be active at a time", langZH: "同时最多只能有 %d 条公告"},
+	CodeTimerGetFailed:              {langEN: "Failed to get timers", langZH: "获取计时器失败"},
+	CodeTimerCreateFailed:           {langEN: "Failed to start timer", langZH: "启动计时器失败"},
+	CodeTimerUpdateFailed:           {langEN: "Failed to stop timer", langZH: "结束计时器失败"},
+	CodeTimerNotFound:               {langEN: "Timer not found or already finished", langZH: "计时器不存在或已结束"},
+	CodeTimerLimit:                  {langEN: "At most %d timers can run at a time", langZH: "同时最多只能有 %d 个计时器"},
+	CodeQuizRuleGetFailed:           {langEN: "Failed to get quiz rules", langZH: "获取答题规则失败"},
+	CodeQuizRuleUpdateFailed:        {langEN: "Failed to save quiz rule", langZH: "保存答题规则失败"},
+	CodeQuizRuleNotFound:            {langEN: "Quiz rule not found", langZH: "答题规则不存在"},
+	CodeQuizRuleLimit:               {langEN: "A course can have at most %d quiz rules", langZH: "每门课程最多只能设置 %d 条答题规则"},
+	CodeSeriesNotFound:              {langEN: "Session series not found", langZH: "系列课程不存在"},
+	CodeSeriesEmpty:                 {langEN: "The recurrence rule produces no sessions", langZH: "重复规则没有产生任何课次"},
+	CodeSeriesTooLarge:              {langEN: "A series can create at most %d sessions", langZH: "一次最多只能创建 %d 个课次"},
+	CodeSeriesCancelFailed:          {langEN: "Failed to cancel sessions", langZH: "取消课次失败"},
+	CodeHolidayGetFailed:            {langEN: "Failed to get holidays", langZH: "获取节假日失败"},
+	CodeHolidayUpdateFailed:         {langEN: "Failed to save holidays", langZH: "保存节假日失败"},
+	CodeHolidayNotFound:             {langEN: "Holiday not found", langZH: "节假日不存在"},
+	CodeSessionNotCancellable:       {langEN: "Only sessions that have not started can be cancelled", langZH: "只能取消尚未开始的课次"},
+	CodeSessionCancelFailed:         {langEN: "Failed to cancel session", langZH: "取消课次失败"},
+	CodeNotificationGetFailed:       {langEN: "Failed to get notifications", langZH: "获取通知失败"},
+	CodeNotificationNotFound:        {langEN: "Notification not found", langZH: "通知不存在"},
+	CodeMakeupNotAllowed:            {langEN: "Only cancelled or ended sessions can have a makeup session", langZH: "只有已取消或已结束的课次可以安排补课"},
+	CodeMakeupExists:                {langEN: "The session is already linked to a makeup session", langZH: "该课次已关联补课"},
+	CodeMakeupNotFound:              {langEN: "The session has no makeup session", langZH: "该课次没有关联补课"},
+	CodeMakeupLinkFailed:            {langEN: "Failed to update makeup session", langZH: "更新补课关联失败"},
+	CodeLoginSessionGetFailed:       {langEN: "Failed to get login sessions", langZH: "获取登录设备失败"},
+	CodeLoginSessionRevokeFailed:    {langEN: "Failed to revoke login session", langZH: "注销登录设备失败"},
+	CodeLoginSessionNotFound:        {langEN: "Login session not found", langZH: "登录设备不存在"},
+	CodeLoginRevoked:                {langEN: "This login has been signed out, please log in again", langZH: "该设备已退出登录，请重新登录"},
+	CodeExamOtherDevice:             {langEN: "This exam is already in progress on another device", langZH: "该测验已在其他设备上作答"},
+	CodeRefreshTokenInvalid:         {langEN: "Refresh token is invalid or expired, please log in again", langZH: "刷新令牌无效或已过期，请重新登录"},
+	CodeRefreshTokenReused:          {langEN: "Refresh token has already been used, this login has been signed out", langZH: "刷新令牌已被使用，该设备已退出登录"},
+	CodeAPIKeyInvalid:               {langEN: "API key is invalid, expired or revoked", langZH: "API Key 无效、已过期或已吊销"},
+	CodeAPIKeyRateLimited:           {langEN: "Too many requests for this API key, try again later", langZH: "该 API Key 请求过于频繁，请稍后再试"},
+	CodeAPIKeyNotFound:              {langEN: "API key not found", langZH: "API Key 不存在"},
+	CodeAPIKeyGetFailed:             {langEN: "Failed to get API keys", langZH: "获取 API Key 失败"},
+	CodeAPIKeyUpdateFailed:          {langEN: "Failed to save API key", langZH: "保存 API Key 失败"},
+	CodeAPIKeyRevoked:               {langEN: "API key has been revoked", langZH: "API Key 已吊销"},
+	CodeMediaServerUnavailable:      {langEN: "Media server is unavailable", langZH: "流媒体服务器不可用"},
+	CodeStreamNotFound:              {langEN: "Stream is not live", langZH: "直播流不存在或未开始推流"},
+	CodeRelayNotFound:               {langEN: "Relay target not found", langZH: "转推目标不存在"},
+	CodeRelayGetFailed:              {langEN: "Failed to get relay targets", langZH: "获取转推目标失败"},
+	CodeRelayUpdateFailed:           {langEN: "Failed to update relay target", langZH: "更新转推目标失败"},
+	CodeRelayInvalidURL:             {langEN: "Relay URL must be an rtmp:// or rtmps:// address", langZH: "转推地址必须是 rtmp:// 或 rtmps:// 地址"},
+	CodeRecordingPolicyGetFailed:    {langEN: "Failed to get recording policies", langZH: "获取录像策略失败"},
+	CodeRecordingPolicyUpdateFailed: {langEN: "Failed to update recording policy", langZH: "更新录像策略失败"},
+	CodeRecordingPolicyNotFound:     {langEN: "Recording policy not found", langZH: "录像策略不存在"},
+	CodeReconcileInProgress:         {langEN: "Reconciliation is already running", langZH: "正在核对直播状态，请稍后再试"},
+	CodeNotFound:                    {langEN: "Resource not found", langZH: "资源不存在"},
+	CodeConflict:                    {langEN: "Request conflicts with the current state", langZH: "请求与当前状态冲突"},
+	CodeInvalidState:                {langEN: "Operation not allowed in the current state", langZH: "当前状态不允许该操作"},
+	CodeExpired:                     {langEN: "The resource has expired", langZH: "已过期"},
+}
+
+// 按语言获取错误信息，缺少翻译时回退到默认语言
+func localize(code ErrorCode, lang string, args ...interface{}) string {
+	messages, ok := errorMessages[code]
+	if !ok {
+		return string(code)
+	}
+	format, ok := messages[lang]
+	if !ok {
+		format = messages[defaultLang]
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// 请求语言，lang 参数优先，其次 Accept-Language
+func requestLang(c *gin.Context) string {
+	if lang := normalizeLang(c.Query("lang")); lang != "" {
+		return lang
+	}
+	for _, part := range strings.Split(c.GetHeader("Accept-Language"), ",") {
+		tag := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		if lang := normalizeLang(tag); lang != "" {
+			return lang
+		}
+	}
+	return defaultLang
+}
+
+func normalizeLang(tag string) string {
+	tag = strings.ToLower(tag)
+	switch {
+	case strings.HasPrefix(tag, langZH):
+		return langZH
+	case strings.HasPrefix(tag, langEN):
+		return langEN
+	}
+	return ""
+}
+
+// 返回带错误码的本地化错误响应
+func respondError(c *gin.Context, status int, code ErrorCode, args ...interface{}) {
+	writeError(c, status, code, localize(code, requestLang(c), args...), nil)
+}
+
+// 通过 WebSocket 向客户端发送本地化错误
+func (c *Client) sendError(code ErrorCode, args ...interface{}) {
+	c.sendMessage(Message{Type: "error", Data: gin.H{
+		"code":  code,
+		"error": localize(code, c.lang, args...),
+	}})
+}
@@ -0,0 +1,213 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// AMF0 类型标记，只实现 RTMP 命令和元数据用到的部分
+const (
+	amfNumber      = 0x00
+	amfBoolean     = 0x01
+	amfString      = 0x02
+	amfObject      = 0x03
+	amfNull        = 0x05
+	amfUndefined   = 0x06
+	amfECMAArray   = 0x08
+	amfObjectEnd   = 0x09
+	amfStrictArray = 0x0a
+	amfDate        = 0x0b
+	amfLongString  = 0x0c
+)
+
+var errAMFType = errors.New("unsupported amf0 type")
+
+// 有序的 AMF0 对象，保持字段顺序以便原样转发元数据
+type amfObj struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newAMFObj(kv ...interface{}) amfObj {
+	o := amfObj{values: map[string]interface{}{}}
+	for i := 0; i+1 < len(kv); i += 2 {
+		o.set(kv[i].(string), kv[i+1])
+	}
+	return o
+}
+
+func (o *amfObj) set(key string, v interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+func (o amfObj) str(key string) string {
+	s, _ := o.values[key].(string)
+	return s
+}
+
+func (o amfObj) num(key string) float64 {
+	n, _ := o.values[key].(float64)
+	return n
+}
+
+// 解码 payload 中的全部 AMF0 值
+func decodeAMF(payload []byte) ([]interface{}, error) {
+	r := bytes.NewReader(payload)
+	var vals []interface{}
+	for r.Len() > 0 {
+		v, err := decodeAMFValue(r)
+		if err != nil {
+			return vals, err
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+func decodeAMFValue(r *bytes.Reader) (interface{}, error) {
+	marker, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch marker {
+	case amfNumber:
+		var bits uint64
+		if err := binary.Read(r, binary.BigEndian, &bits); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(bits), nil
+	case amfBoolean:
+		b, err := r.ReadByte()
+		return b != 0, err
+	case amfString:
+		return readAMFString(r, 2)
+	case amfLongString:
+		return readAMFString(r, 4)
+	case amfObject:
+		return readAMFProps(r)
+	case amfECMAArray:
+		if _, err := r.Seek(4, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		return readAMFProps(r)
+	case amfStrictArray:
+		var n uint32
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return nil, err
+		}
+		arr := make([]interface{}, 0, n)
+		for i := uint32(0); i < n; i++ {
+			v, err := decodeAMFValue(r)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	case amfDate:
+		var bits uint64
+		if err := binary.Read(r, binary.BigEndian, &bits); err != nil {
+			return nil, err
+		}
+		_, err := r.Seek(2, io.SeekCurrent) // 时区
+		return math.Float64frombits(bits), err
+	case amfNull, amfUndefined:
+		return nil, nil
+	}
+	return nil, errAMFType
+}
+
+func readAMFString(r *bytes.Reader, lenBytes int) (string, error) {
+	var n uint32
+	if lenBytes == 2 {
+		var n16 uint16
+		if err := binary.Read(r, binary.BigEndian, &n16); err != nil {
+			return "", err
+		}
+		n = uint32(n16)
+	} else if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return "", err
+	}
+	if int64(n) > int64(r.Len()) {
+		return "", io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r, buf)
+	return string(buf), err
+}
+
+func readAMFProps(r *bytes.Reader) (amfObj, error) {
+	o := newAMFObj()
+	for {
+		key, err := readAMFString(r, 2)
+		if err != nil {
+			return o, err
+		}
+		if key == "" {
+			marker, err := r.ReadByte()
+			if err != nil {
+				return o, err
+			}
+			if marker == amfObjectEnd {
+				return o, nil
+			}
+			r.UnreadByte()
+		}
+		v, err := decodeAMFValue(r)
+		if err != nil {
+			return o, err
+		}
+		o.set(key, v)
+	}
+}
+
+// 编码为 AMF0，支持 float64、int、bool、string、amfObj 和 nil
+func encodeAMF(vals ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, v := range vals {
+		writeAMFValue(&buf, v)
+	}
+	return buf.Bytes()
+}
+
+func writeAMFValue(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case float64:
+		buf.WriteByte(amfNumber)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case int:
+		writeAMFValue(buf, float64(v))
+	case bool:
+		buf.WriteByte(amfBoolean)
+		if v {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case string:
+		if len(v) > math.MaxUint16 {
+			buf.WriteByte(amfLongString)
+			binary.Write(buf, binary.BigEndian, uint32(len(v)))
+		} else {
+			buf.WriteByte(amfString)
+			binary.Write(buf, binary.BigEndian, uint16(len(v)))
+		}
+		buf.WriteString(v)
+	case amfObj:
+		buf.WriteByte(amfObject)
+		for _, key := range v.keys {
+			binary.Write(buf, binary.BigEndian, uint16(len(key)))
+			buf.WriteString(key)
+			writeAMFValue(buf, v.values[key])
+		}
+		buf.Write([]byte{0, 0, amfObjectEnd})
+	default:
+		buf.WriteByte(amfNull)
+	}
+}
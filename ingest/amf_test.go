@@ -0,0 +1,120 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAMFRoundTrip(t *testing.T) {
+	long := strings.Repeat("x", 70000)
+	tests := []struct {
+		name string
+		in   interface{}
+		want interface{}
+	}{
+		{"number", 1.5, 1.5},
+		{"int", 3, 3.0},
+		{"true", true, true},
+		{"false", false, false},
+		{"string", "live", "live"},
+		{"long string", long, long},
+		{"null", nil, nil},
+		{"object", newAMFObj("app", "live", "tcUrl", "rtmp://host/live", "fpad", false),
+			newAMFObj("app", "live", "tcUrl", "rtmp://host/live", "fpad", false)},
+		{"nested object", newAMFObj("level", "status", "info", newAMFObj("code", 1.0)),
+			newAMFObj("level", "status", "info", newAMFObj("code", 1.0))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vals, err := decodeAMF(encodeAMF(tt.in))
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if len(vals) != 1 || !reflect.DeepEqual(vals[0], tt.want) {
+				t.Fatalf("got %#v, want %#v", vals, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeAMF(t *testing.T) {
+	be := func(v interface{}) []byte {
+		var b bytes.Buffer
+		binary.Write(&b, binary.BigEndian, v)
+		return b.Bytes()
+	}
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	tests := []struct {
+		name    string
+		in      []byte
+		want    []interface{}
+		wantErr bool
+	}{
+		{
+			name: "command",
+			in:   encodeAMF("connect", 1, newAMFObj("app", "live")),
+			want: []interface{}{"connect", 1.0, newAMFObj("app", "live")},
+		},
+		{
+			name: "ecma array",
+			in:   join([]byte{amfECMAArray}, be(uint32(1)), be(uint16(5)), []byte("width"), encodeAMF(1280), []byte{0, 0, amfObjectEnd}),
+			want: []interface{}{newAMFObj("width", 1280.0)},
+		},
+		{
+			name: "strict array",
+			in:   join([]byte{amfStrictArray}, be(uint32(2)), encodeAMF("a", 2)),
+			want: []interface{}{[]interface{}{"a", 2.0}},
+		},
+		{
+			name: "date",
+			in:   join([]byte{amfDate}, encodeAMF(1.0)[1:], be(uint16(0))),
+			want: []interface{}{1.0},
+		},
+		{
+			name: "undefined",
+			in:   []byte{amfUndefined},
+			want: []interface{}{nil},
+		},
+		{
+			name:    "truncated string",
+			in:      join([]byte{amfString}, be(uint16(10)), []byte("abc")),
+			wantErr: true,
+		},
+		{
+			name:    "truncated number",
+			in:      []byte{amfNumber, 0, 0},
+			wantErr: true,
+		},
+		{
+			name:    "unterminated object",
+			in:      join([]byte{amfObject}, be(uint16(3)), []byte("app")),
+			wantErr: true,
+		},
+		{
+			name:    "unsupported type",
+			in:      []byte{0x10},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vals, err := decodeAMF(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %#v, want error", vals)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !reflect.DeepEqual(vals, tt.want) {
+				t.Fatalf("got %#v, want %#v", vals, tt.want)
+			}
+		})
+	}
+}
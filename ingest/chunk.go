@@ -0,0 +1,349 @@
+package ingest
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// RTMP 消息类型
+const (
+	msgSetChunkSize     = 1
+	msgAbort            = 2
+	msgAck              = 3
+	msgUserControl      = 4
+	msgWindowAckSize    = 5
+	msgSetPeerBandwidth = 6
+	msgAudio            = 8
+	msgVideo            = 9
+	msgDataAMF3         = 15
+	msgCommandAMF3      = 17
+	msgDataAMF0         = 18
+	msgCommandAMF0      = 20
+)
+
+const (
+	handshakeSize    = 1536
+	defaultChunkSize = 128
+	outChunkSize     = 4096
+	windowAckSize    = 2500000
+	maxMessageSize   = 16 << 20
+	maxChunkStreams  = 64 // 每个连接同时存在的块流数量上限，正常推流只用到几个
+	extendedTS       = 0xffffff
+)
+
+var (
+	errMessageTooLarge     = errors.New("rtmp message too large")
+	errTooManyChunkStreams = errors.New("too many rtmp chunk streams")
+)
+
+// 一条完整的 RTMP 消息
+type message struct {
+	typ       uint8
+	streamID  uint32
+	timestamp uint32
+	payload   []byte
+}
+
+// 每个块流的头部状态，后续块可以省略与上一条相同的字段
+type chunkStream struct {
+	timestamp uint32
+	delta     uint32
+	length    uint32
+	typ       uint8
+	streamID  uint32
+	extended  bool
+	buf       []byte
+}
+
+// RTMP 连接的块流读写
+type chunkConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	inChunkSize uint32
+	streams     map[uint32]*chunkStream
+
+	// 对端要求的确认窗口，收到的字节数超过窗口时回复确认
+	ackWindow uint32
+	received  uint32
+	acked     uint32
+
+	wmu sync.Mutex
+	w   *bufio.Writer
+}
+
+func newChunkConn(conn net.Conn) *chunkConn {
+	return &chunkConn{
+		conn:        conn,
+		r:           bufio.NewReaderSize(conn, 16<<10),
+		w:           bufio.NewWriterSize(conn, 16<<10),
+		inChunkSize: defaultChunkSize,
+		streams:     map[uint32]*chunkStream{},
+	}
+}
+
+// 简单握手：S1 的版本字段为 0，客户端随之跳过摘要校验
+func (c *chunkConn) handshake() error {
+	c0c1 := make([]byte, 1+handshakeSize)
+	if _, err := io.ReadFull(c.r, c0c1); err != nil {
+		return err
+	}
+	if c0c1[0] != 3 {
+		return fmt.Errorf("unsupported rtmp version %d", c0c1[0])
+	}
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	s0s1s2[0] = 3
+	if _, err := rand.Read(s0s1s2[9 : 1+handshakeSize]); err != nil {
+		return err
+	}
+	copy(s0s1s2[1+handshakeSize:], c0c1[1:])
+	if _, err := c.conn.Write(s0s1s2); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(c.r, make([]byte, handshakeSize))
+	return err
+}
+
+func (c *chunkConn) readN(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(c.r, buf)
+	c.received += uint32(n)
+	return buf, err
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v>>16), byte(v>>8), byte(v)
+}
+
+// 读取下一条完整消息，协议控制消息在内部处理
+func (c *chunkConn) readMessage() (*message, error) {
+	for {
+		msg, err := c.readChunk()
+		if err != nil {
+			return nil, err
+		}
+		if c.ackWindow > 0 && c.received-c.acked >= c.ackWindow {
+			c.acked = c.received
+			if err := c.writeControl(msgAck, c.received); err != nil {
+				return nil, err
+			}
+		}
+		if msg == nil {
+			continue
+		}
+		switch msg.typ {
+		case msgSetChunkSize:
+			if len(msg.payload) < 4 {
+				return nil, errors.New("invalid set chunk size")
+			}
+			size := binary.BigEndian.Uint32(msg.payload) & 0x7fffffff
+			if size == 0 || size > maxMessageSize {
+				return nil, fmt.Errorf("invalid chunk size %d", size)
+			}
+			c.inChunkSize = size
+		case msgAbort:
+			if len(msg.payload) >= 4 {
+				if cs, ok := c.streams[binary.BigEndian.Uint32(msg.payload)]; ok {
+					cs.buf = nil
+				}
+			}
+		case msgWindowAckSize:
+			if len(msg.payload) >= 4 {
+				c.ackWindow = binary.BigEndian.Uint32(msg.payload)
+			}
+		case msgAck, msgUserControl, msgSetPeerBandwidth:
+		default:
+			return msg, nil
+		}
+	}
+}
+
+// 读取一个块，凑齐一条消息时返回该消息
+func (c *chunkConn) readChunk() (*message, error) {
+	b, err := c.readN(1)
+	if err != nil {
+		return nil, err
+	}
+	format := b[0] >> 6
+	csid := uint32(b[0] & 0x3f)
+	switch csid {
+	case 0:
+		ext, err := c.readN(1)
+		if err != nil {
+			return nil, err
+		}
+		csid = 64 + uint32(ext[0])
+	case 1:
+		ext, err := c.readN(2)
+		if err != nil {
+			return nil, err
+		}
+		csid = 64 + uint32(ext[0]) + uint32(ext[1])<<8
+	}
+
+	cs, ok := c.streams[csid]
+	if !ok {
+		if format != 0 {
+			return nil, fmt.Errorf("chunk stream %d starts with format %d", csid, format)
+		}
+		if len(c.streams) >= maxChunkStreams {
+			return nil, errTooManyChunkStreams
+		}
+		cs = &chunkStream{}
+		c.streams[csid] = cs
+	}
+
+	var ts uint32
+	switch format {
+	case 0:
+		h, err := c.readN(11)
+		if err != nil {
+			return nil, err
+		}
+		ts = uint24(h[0:3])
+		cs.length = uint24(h[3:6])
+		cs.typ = h[6]
+		cs.streamID = binary.LittleEndian.Uint32(h[7:11])
+	case 1:
+		h, err := c.readN(7)
+		if err != nil {
+			return nil, err
+		}
+		ts = uint24(h[0:3])
+		cs.length = uint24(h[3:6])
+		cs.typ = h[6]
+	case 2:
+		h, err := c.readN(3)
+		if err != nil {
+			return nil, err
+		}
+		ts = uint24(h[0:3])
+	}
+	if format != 3 {
+		cs.extended = ts == extendedTS
+	}
+	if cs.extended {
+		h, err := c.readN(4)
+		if err != nil {
+			return nil, err
+		}
+		ts = binary.BigEndian.Uint32(h)
+	}
+	// 格式 3 的块只在开始新消息时沿用上一条的时间增量
+	if cs.buf == nil {
+		switch format {
+		case 0:
+			cs.timestamp, cs.delta = ts, 0
+		case 1, 2:
+			cs.timestamp += ts
+			cs.delta = ts
+		case 3:
+			cs.timestamp += cs.delta
+		}
+	}
+	if cs.length > maxMessageSize {
+		return nil, errMessageTooLarge
+	}
+
+	remain := cs.length - uint32(len(cs.buf))
+	if remain > c.inChunkSize {
+		remain = c.inChunkSize
+	}
+	data, err := c.readN(int(remain))
+	if err != nil {
+		return nil, err
+	}
+	// 消息长度来自尚未鉴权的对端，缓冲区随实际收到的数据增长，不按声明的长度预先分配
+	if cs.buf == nil {
+		cs.buf = make([]byte, 0, len(data))
+	}
+	cs.buf = append(cs.buf, data...)
+	if uint32(len(cs.buf)) < cs.length {
+		return nil, nil
+	}
+	msg := &message{typ: cs.typ, streamID: cs.streamID, timestamp: cs.timestamp, payload: cs.buf}
+	cs.buf = nil
+	return msg, nil
+}
+
+// 按 outChunkSize 分块写出消息，每条消息使用完整的格式 0 头部
+func (c *chunkConn) writeMessage(csid uint32, msg *message) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.writeChunks(csid, msg); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+func (c *chunkConn) writeChunks(csid uint32, msg *message) error {
+	ts := msg.timestamp
+	extended := ts >= extendedTS
+	header := make([]byte, 12, 16)
+	header[0] = byte(csid & 0x3f)
+	if extended {
+		putUint24(header[1:4], extendedTS)
+	} else {
+		putUint24(header[1:4], ts)
+	}
+	putUint24(header[4:7], uint32(len(msg.payload)))
+	header[7] = msg.typ
+	binary.LittleEndian.PutUint32(header[8:12], msg.streamID)
+	if extended {
+		header = binary.BigEndian.AppendUint32(header, ts)
+	}
+	if _, err := c.w.Write(header); err != nil {
+		return err
+	}
+
+	payload := msg.payload
+	for {
+		n := len(payload)
+		if n > outChunkSize {
+			n = outChunkSize
+		}
+		if _, err := c.w.Write(payload[:n]); err != nil {
+			return err
+		}
+		payload = payload[n:]
+		if len(payload) == 0 {
+			return nil
+		}
+		cont := []byte{0xc0 | byte(csid&0x3f)}
+		if extended {
+			cont = binary.BigEndian.AppendUint32(cont, ts)
+		}
+		if _, err := c.w.Write(cont); err != nil {
+			return err
+		}
+	}
+}
+
+// 写出协议控制消息，取值为 4 字节
+func (c *chunkConn) writeControl(typ uint8, v uint32) error {
+	payload := binary.BigEndian.AppendUint32(nil, v)
+	if typ == msgSetPeerBandwidth {
+		payload = append(payload, 2) // 动态限制
+	}
+	return c.writeMessage(2, &message{typ: typ, payload: payload})
+}
+
+// 用户控制消息：流开始
+func (c *chunkConn) writeStreamBegin(streamID uint32) error {
+	payload := binary.BigEndian.AppendUint32([]byte{0, 0}, streamID)
+	return c.writeMessage(2, &message{typ: msgUserControl, payload: payload})
+}
+
+func (c *chunkConn) writeCommand(streamID uint32, vals ...interface{}) error {
+	return c.writeMessage(3, &message{typ: msgCommandAMF0, streamID: streamID, payload: encodeAMF(vals...)})
+}
@@ -0,0 +1,292 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// 按 RTMP 块格式拼接测试数据
+type chunkWriter struct{ bytes.Buffer }
+
+func (w *chunkWriter) basic(format byte, csid uint32) {
+	switch {
+	case csid < 64:
+		w.WriteByte(format<<6 | byte(csid))
+	case csid < 320:
+		w.WriteByte(format << 6)
+		w.WriteByte(byte(csid - 64))
+	default:
+		w.WriteByte(format<<6 | 1)
+		binary.Write(w, binary.LittleEndian, uint16(csid-64))
+	}
+}
+
+func (w *chunkWriter) uint24(v uint32) {
+	w.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+}
+
+func (w *chunkWriter) fmt0(csid, ts, length uint32, typ byte, streamID uint32) {
+	w.basic(0, csid)
+	w.uint24(ts)
+	w.uint24(length)
+	w.WriteByte(typ)
+	binary.Write(w, binary.LittleEndian, streamID)
+}
+
+func (w *chunkWriter) fmt1(csid, delta, length uint32, typ byte) {
+	w.basic(1, csid)
+	w.uint24(delta)
+	w.uint24(length)
+	w.WriteByte(typ)
+}
+
+func (w *chunkWriter) fmt2(csid, delta uint32) {
+	w.basic(2, csid)
+	w.uint24(delta)
+}
+
+func testConn(data []byte) *chunkConn {
+	return &chunkConn{
+		r:           bufio.NewReader(bytes.NewReader(data)),
+		w:           bufio.NewWriter(io.Discard),
+		inChunkSize: defaultChunkSize,
+		streams:     map[uint32]*chunkStream{},
+	}
+}
+
+func readAll(c *chunkConn) ([]*message, error) {
+	var msgs []*message
+	for {
+		msg, err := c.readMessage()
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	payload200 := bytes.Repeat([]byte{0xab}, 200)
+
+	tests := []struct {
+		name       string
+		build      func(w *chunkWriter)
+		timestamps []uint32
+		lengths    []int
+		types      []uint8
+	}{
+		{
+			name: "single chunk",
+			build: func(w *chunkWriter) {
+				w.fmt0(3, 1000, 5, msgCommandAMF0, 1)
+				w.WriteString("hello")
+			},
+			timestamps: []uint32{1000},
+			lengths:    []int{5},
+			types:      []uint8{msgCommandAMF0},
+		},
+		{
+			name: "message split across chunks",
+			build: func(w *chunkWriter) {
+				w.fmt0(4, 0, 200, msgVideo, 1)
+				w.Write(payload200[:128])
+				w.basic(3, 4)
+				w.Write(payload200[128:])
+			},
+			timestamps: []uint32{0},
+			lengths:    []int{200},
+			types:      []uint8{msgVideo},
+		},
+		{
+			name: "timestamp deltas",
+			build: func(w *chunkWriter) {
+				w.fmt0(6, 100, 1, msgVideo, 1)
+				w.WriteByte(0)
+				w.fmt1(6, 40, 2, msgAudio)
+				w.Write([]byte{0, 0})
+				w.fmt2(6, 40)
+				w.Write([]byte{0, 0})
+				w.basic(3, 6)
+				w.Write([]byte{0, 0})
+			},
+			timestamps: []uint32{100, 140, 180, 220},
+			lengths:    []int{1, 2, 2, 2},
+			types:      []uint8{msgVideo, msgAudio, msgAudio, msgAudio},
+		},
+		{
+			name: "extended timestamp",
+			build: func(w *chunkWriter) {
+				w.fmt0(3, extendedTS, 1, msgAudio, 1)
+				binary.Write(w, binary.BigEndian, uint32(0x01000000))
+				w.WriteByte(0)
+			},
+			timestamps: []uint32{0x01000000},
+			lengths:    []int{1},
+			types:      []uint8{msgAudio},
+		},
+		{
+			name: "two byte chunk stream id",
+			build: func(w *chunkWriter) {
+				w.fmt0(300, 7, 1, msgAudio, 1)
+				w.WriteByte(0)
+			},
+			timestamps: []uint32{7},
+			lengths:    []int{1},
+			types:      []uint8{msgAudio},
+		},
+		{
+			name: "set chunk size",
+			build: func(w *chunkWriter) {
+				w.fmt0(2, 0, 4, msgSetChunkSize, 0)
+				binary.Write(w, binary.BigEndian, uint32(256))
+				w.fmt0(4, 0, 200, msgVideo, 1)
+				w.Write(payload200)
+			},
+			timestamps: []uint32{0},
+			lengths:    []int{200},
+			types:      []uint8{msgVideo},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w chunkWriter
+			tt.build(&w)
+			msgs, err := readAll(testConn(w.Bytes()))
+			if err != io.EOF {
+				t.Fatalf("err = %v, want EOF", err)
+			}
+			if len(msgs) != len(tt.timestamps) {
+				t.Fatalf("got %d messages, want %d", len(msgs), len(tt.timestamps))
+			}
+			for i, msg := range msgs {
+				if msg.timestamp != tt.timestamps[i] || len(msg.payload) != tt.lengths[i] || msg.typ != tt.types[i] {
+					t.Errorf("message %d = {ts %d, len %d, type %d}, want {ts %d, len %d, type %d}",
+						i, msg.timestamp, len(msg.payload), msg.typ, tt.timestamps[i], tt.lengths[i], tt.types[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(w *chunkWriter)
+		want  error
+	}{
+		{
+			name: "format 3 on new chunk stream",
+			build: func(w *chunkWriter) {
+				w.basic(3, 5)
+			},
+		},
+		{
+			name: "zero chunk size",
+			build: func(w *chunkWriter) {
+				w.fmt0(2, 0, 4, msgSetChunkSize, 0)
+				binary.Write(w, binary.BigEndian, uint32(0))
+			},
+		},
+		{
+			name: "too many chunk streams",
+			build: func(w *chunkWriter) {
+				// 每个块流只发送消息的第一个块，状态一直保留
+				for csid := uint32(3); csid < 3+maxChunkStreams+1; csid++ {
+					w.fmt0(csid, 0, 2*defaultChunkSize, msgAudio, 1)
+					w.Write(make([]byte, defaultChunkSize))
+				}
+			},
+			want: errTooManyChunkStreams,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w chunkWriter
+			tt.build(&w)
+			_, err := readAll(testConn(w.Bytes()))
+			if err == nil || err == io.EOF {
+				t.Fatalf("err = %v, want an error", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// 对端声明的消息长度不会提前分配内存
+func TestReadChunkDoesNotPreallocate(t *testing.T) {
+	var w chunkWriter
+	w.fmt0(3, 0, 0xffffff, msgVideo, 1)
+	w.Write(make([]byte, defaultChunkSize))
+	c := testConn(w.Bytes())
+	if msg, err := c.readChunk(); msg != nil || err != nil {
+		t.Fatalf("readChunk = %v, %v; want incomplete message", msg, err)
+	}
+	if n := cap(c.streams[3].buf); n > defaultChunkSize {
+		t.Fatalf("buffer capacity %d, want at most %d", n, defaultChunkSize)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	tests := []struct {
+		name    string
+		version byte
+		wantErr bool
+	}{
+		{"rtmp 3", 3, false},
+		{"unsupported version", 6, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			c1 := bytes.Repeat([]byte{0x5a}, handshakeSize)
+			s0s1s2 := make([]byte, 1+2*handshakeSize)
+			clientErr := make(chan error, 1)
+			go func() {
+				if _, err := client.Write(append([]byte{tt.version}, c1...)); err != nil {
+					clientErr <- err
+					return
+				}
+				if tt.wantErr {
+					clientErr <- nil
+					return
+				}
+				if _, err := io.ReadFull(client, s0s1s2); err != nil {
+					clientErr <- err
+					return
+				}
+				_, err := client.Write(s0s1s2[1 : 1+handshakeSize]) // C2 回显 S1
+				clientErr <- err
+			}()
+
+			err := newChunkConn(server).handshake()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("handshake succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("handshake: %v", err)
+			}
+			if err := <-clientErr; err != nil {
+				t.Fatalf("client: %v", err)
+			}
+			if s0s1s2[0] != 3 {
+				t.Errorf("S0 = %d, want 3", s0s1s2[0])
+			}
+			if !bytes.Equal(s0s1s2[1+handshakeSize:], c1) {
+				t.Error("S2 does not echo C1")
+			}
+		})
+	}
+}
@@ -0,0 +1,567 @@
+// Package ingest 是内置的 RTMP 推流服务，接收教师推流并通过 RTMP 和 HTTP-FLV 分发。
+//
+// 只实现单机小规模部署需要的部分：简单握手、AMF0 命令、推流和拉流，
+// 不做转码、HLS 和录制。新的播放端从最近的关键帧开始播放。
+package ingest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 推流端超过该时间没有数据时断开
+	publishIdleTimeout = 30 * time.Second
+	// 握手和命令阶段的超时
+	setupTimeout = 10 * time.Second
+	// 播放端积压的消息数，超过时断开该播放端
+	subscriberQueue = 1024
+	// 关键帧缓存的最大消息数
+	maxGOPPackets = 2048
+	// 码率统计窗口
+	bitrateWindow = 5 * time.Second
+)
+
+var (
+	ErrStreamNotFound  = errors.New("stream not found")
+	ErrStreamPublished = errors.New("stream is already being published")
+)
+
+// 推流鉴权，返回错误时拒绝推流。query 为推流地址中 ? 之后的部分
+type PublishFunc func(app, name, query string) error
+
+// 推流结束的通知
+type UnpublishFunc func(app, name string)
+
+// 正在推流的流
+type StreamInfo struct {
+	App         string
+	Name        string
+	Clients     int
+	BitrateKbps int
+	VideoCodec  string
+	AudioCodec  string
+	StartedAt   time.Time
+}
+
+// 内置 RTMP 服务
+type Server struct {
+	OnPublish   PublishFunc
+	OnUnpublish UnpublishFunc
+
+	mu      sync.Mutex
+	streams map[string]*stream
+	ln      net.Listener
+}
+
+func NewServer() *Server {
+	return &Server{streams: map[string]*stream{}}
+}
+
+// 在 ln 上接受 RTMP 连接，直到 Close
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// 停止监听并断开所有推流
+func (s *Server) Close() error {
+	s.mu.Lock()
+	ln := s.ln
+	streams := make([]*stream, 0, len(s.streams))
+	for _, st := range s.streams {
+		streams = append(streams, st)
+	}
+	s.mu.Unlock()
+	for _, st := range streams {
+		st.publisher.conn.conn.Close()
+	}
+	if ln != nil {
+		return ln.Close()
+	}
+	return nil
+}
+
+func streamID(app, name string) string {
+	return app + "/" + name
+}
+
+// 正在推流的流
+func (s *Server) Streams() []StreamInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infos := make([]StreamInfo, 0, len(s.streams))
+	for _, st := range s.streams {
+		infos = append(infos, st.info())
+	}
+	return infos
+}
+
+// 断开推流端，返回是否存在该流
+func (s *Server) Drop(app, name string) bool {
+	s.mu.Lock()
+	st := s.streams[streamID(app, name)]
+	s.mu.Unlock()
+	if st == nil {
+		return false
+	}
+	st.publisher.conn.conn.Close()
+	return true
+}
+
+func (s *Server) lookup(app, name string) *stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[streamID(app, name)]
+}
+
+// 以 HTTP-FLV 播放，流不存在时返回 ErrStreamNotFound，否则持续输出直到推流结束或客户端断开
+func (s *Server) ServeFLV(w http.ResponseWriter, r *http.Request, app, name string) error {
+	st := s.lookup(app, name)
+	if st == nil {
+		return ErrStreamNotFound
+	}
+	sub := st.subscribe()
+	defer st.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "video/x-flv")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	// FLV 文件头：含音频和视频，随后是值为 0 的首个 PreviousTagSize
+	if _, err := w.Write([]byte{'F', 'L', 'V', 1, 0x05, 0, 0, 0, 9, 0, 0, 0, 0}); err != nil {
+		return nil
+	}
+	tag := make([]byte, 11)
+	size := make([]byte, 4)
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case p, ok := <-sub.ch:
+			if !ok {
+				return nil
+			}
+			tag[0] = p.typ
+			putUint24(tag[1:4], uint32(len(p.data)))
+			putUint24(tag[4:7], p.timestamp&0xffffff)
+			tag[7] = byte(p.timestamp >> 24)
+			binary.BigEndian.PutUint32(size, uint32(11+len(p.data)))
+			if _, err := w.Write(tag); err != nil {
+				return nil
+			}
+			if _, err := w.Write(p.data); err != nil {
+				return nil
+			}
+			if _, err := w.Write(size); err != nil {
+				return nil
+			}
+			if flusher != nil && len(sub.ch) == 0 {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// 拆分 app 或流名称中的查询参数
+func splitQuery(s string) (string, string) {
+	name, query, _ := strings.Cut(s, "?")
+	return name, query
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	c := newChunkConn(conn)
+	conn.SetDeadline(time.Now().Add(setupTimeout))
+	if err := c.handshake(); err != nil {
+		return
+	}
+	sess := &session{server: s, conn: c}
+	if err := sess.run(); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		log.Printf("RTMP connection from %s closed: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// 一个 RTMP 连接，推流或播放
+type session struct {
+	server *Server
+	conn   *chunkConn
+	app    string
+
+	publishing *stream
+	playing    *subscriber
+}
+
+func (sess *session) run() error {
+	defer sess.cleanup()
+	for {
+		msg, err := sess.conn.readMessage()
+		if err != nil {
+			return err
+		}
+		switch msg.typ {
+		case msgCommandAMF3:
+			if len(msg.payload) > 0 {
+				msg.payload = msg.payload[1:]
+			}
+			fallthrough
+		case msgCommandAMF0:
+			if err := sess.handleCommand(msg); err != nil {
+				return err
+			}
+		case msgAudio, msgVideo, msgDataAMF0, msgDataAMF3:
+			if sess.publishing == nil {
+				continue
+			}
+			sess.conn.conn.SetReadDeadline(time.Now().Add(publishIdleTimeout))
+			if msg.typ == msgDataAMF3 && len(msg.payload) > 0 {
+				msg.payload = msg.payload[1:]
+				msg.typ = msgDataAMF0
+			}
+			sess.publishing.write(msg)
+		}
+	}
+}
+
+func (sess *session) handleCommand(msg *message) error {
+	vals, err := decodeAMF(msg.payload)
+	if err != nil && len(vals) < 2 {
+		return err
+	}
+	if len(vals) < 2 {
+		return nil
+	}
+	name, _ := vals[0].(string)
+	txID, _ := vals[1].(float64)
+	arg := func(i int) string {
+		if i < len(vals) {
+			s, _ := vals[i].(string)
+			return s
+		}
+		return ""
+	}
+
+	c := sess.conn
+	switch name {
+	case "connect":
+		var obj amfObj
+		if len(vals) > 2 {
+			obj, _ = vals[2].(amfObj)
+		}
+		sess.app, _ = splitQuery(strings.Trim(obj.str("app"), "/"))
+		if err := c.writeControl(msgWindowAckSize, windowAckSize); err != nil {
+			return err
+		}
+		if err := c.writeControl(msgSetPeerBandwidth, windowAckSize); err != nil {
+			return err
+		}
+		if err := c.writeControl(msgSetChunkSize, outChunkSize); err != nil {
+			return err
+		}
+		return c.writeCommand(0, "_result", txID,
+			newAMFObj("fmsVer", "FMS/3,0,1,123", "capabilities", 31),
+			newAMFObj("level", "status", "code", "NetConnection.Connect.Success",
+				"description", "Connection succeeded.", "objectEncoding", obj.num("objectEncoding")))
+	case "createStream":
+		return c.writeCommand(0, "_result", txID, nil, 1)
+	case "releaseStream", "FCPublish", "FCUnpublish", "getStreamLength":
+		if txID == 0 {
+			return nil
+		}
+		return c.writeCommand(0, "_result", txID, nil)
+	case "publish":
+		return sess.publish(msg.streamID, arg(3))
+	case "play":
+		return sess.play(msg.streamID, arg(3))
+	case "deleteStream", "closeStream":
+		return io.EOF
+	}
+	return nil
+}
+
+func onStatus(level, code, description string) amfObj {
+	return newAMFObj("level", level, "code", code, "description", description)
+}
+
+func (sess *session) publish(msgStreamID uint32, path string) error {
+	name, query := splitQuery(path)
+	c := sess.conn
+	if sess.publishing != nil || sess.playing != nil || name == "" {
+		return c.writeCommand(msgStreamID, "onStatus", 0, nil, onStatus("error", "NetStream.Publish.BadName", "Invalid stream."))
+	}
+	if hook := sess.server.OnPublish; hook != nil {
+		if err := hook(sess.app, name, query); err != nil {
+			c.writeCommand(msgStreamID, "onStatus", 0, nil, onStatus("error", "NetStream.Publish.BadName", err.Error()))
+			return err
+		}
+	}
+
+	s := sess.server
+	id := streamID(sess.app, name)
+	s.mu.Lock()
+	if _, ok := s.streams[id]; ok {
+		s.mu.Unlock()
+		c.writeCommand(msgStreamID, "onStatus", 0, nil, onStatus("error", "NetStream.Publish.BadName", ErrStreamPublished.Error()))
+		return ErrStreamPublished
+	}
+	st := newStream(sess.app, name, sess)
+	s.streams[id] = st
+	s.mu.Unlock()
+	sess.publishing = st
+
+	c.conn.SetDeadline(time.Time{})
+	c.conn.SetReadDeadline(time.Now().Add(publishIdleTimeout))
+	return c.writeCommand(msgStreamID, "onStatus", 0, nil, onStatus("status", "NetStream.Publish.Start", "Start publishing."))
+}
+
+func (sess *session) play(msgStreamID uint32, path string) error {
+	name, _ := splitQuery(path)
+	c := sess.conn
+	st := sess.server.lookup(sess.app, name)
+	if st == nil || sess.publishing != nil || sess.playing != nil {
+		c.writeCommand(msgStreamID, "onStatus", 0, nil, onStatus("error", "NetStream.Play.StreamNotFound", "Stream not found."))
+		return ErrStreamNotFound
+	}
+	if err := c.writeStreamBegin(msgStreamID); err != nil {
+		return err
+	}
+	if err := c.writeCommand(msgStreamID, "onStatus", 0, nil, onStatus("status", "NetStream.Play.Reset", "Playing and resetting.")); err != nil {
+		return err
+	}
+	if err := c.writeCommand(msgStreamID, "onStatus", 0, nil, onStatus("status", "NetStream.Play.Start", "Started playing.")); err != nil {
+		return err
+	}
+
+	// 播放端不一定持续发送数据，取消读超时，由写出失败或推流结束断开
+	c.conn.SetDeadline(time.Time{})
+	sub := st.subscribe()
+	sess.playing = sub
+	go func() {
+		defer c.conn.Close()
+		for p := range sub.ch {
+			csid := uint32(4)
+			switch p.typ {
+			case msgVideo:
+				csid = 6
+			case msgDataAMF0:
+				csid = 5
+			}
+			if err := c.writeMessage(csid, &message{typ: p.typ, streamID: msgStreamID, timestamp: p.timestamp, payload: p.data}); err != nil {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (sess *session) cleanup() {
+	if sub := sess.playing; sub != nil {
+		sub.stream.unsubscribe(sub)
+	}
+	st := sess.publishing
+	if st == nil {
+		return
+	}
+	s := sess.server
+	s.mu.Lock()
+	if s.streams[streamID(st.app, st.name)] == st {
+		delete(s.streams, streamID(st.app, st.name))
+	}
+	s.mu.Unlock()
+	st.close()
+	if hook := s.OnUnpublish; hook != nil {
+		hook(st.app, st.name)
+	}
+}
+
+// 转发给播放端的音视频或元数据，时间戳为毫秒
+type packet struct {
+	typ       uint8
+	timestamp uint32
+	data      []byte
+}
+
+type subscriber struct {
+	stream *stream
+	ch     chan *packet
+}
+
+// 一路推流及其播放端
+type stream struct {
+	app, name string
+	publisher *session
+	startedAt time.Time
+
+	mu          sync.Mutex
+	closed      bool
+	subscribers map[*subscriber]struct{}
+	meta        *packet
+	videoSeq    *packet
+	audioSeq    *packet
+	gop         []*packet
+	videoCodec  string
+	audioCodec  string
+
+	windowStart time.Time
+	windowBytes int
+	bitrateKbps int
+}
+
+func newStream(app, name string, publisher *session) *stream {
+	now := time.Now()
+	return &stream{
+		app:         app,
+		name:        name,
+		publisher:   publisher,
+		startedAt:   now,
+		windowStart: now,
+		subscribers: map[*subscriber]struct{}{},
+	}
+}
+
+var videoCodecs = map[byte]string{2: "H263", 7: "H264", 12: "HEVC"}
+var audioCodecs = map[byte]string{2: "MP3", 10: "AAC", 11: "Speex"}
+
+func (st *stream) write(msg *message) {
+	if len(msg.payload) == 0 {
+		return
+	}
+	p := &packet{typ: msg.typ, timestamp: msg.timestamp, data: msg.payload}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.closed {
+		return
+	}
+	st.windowBytes += len(msg.payload)
+	if elapsed := time.Since(st.windowStart); elapsed >= bitrateWindow {
+		st.bitrateKbps = int(float64(st.windowBytes*8) / elapsed.Seconds() / 1000)
+		st.windowStart, st.windowBytes = time.Now(), 0
+	}
+
+	switch p.typ {
+	case msgDataAMF0:
+		// 去掉 @setDataFrame，只保留 onMetaData 及其参数；元数据缓存给之后加入的播放端
+		r := bytes.NewReader(p.data)
+		v, err := decodeAMFValue(r)
+		if err == nil && v == "@setDataFrame" {
+			p.data = p.data[len(p.data)-r.Len():]
+			v, err = decodeAMFValue(r)
+		}
+		if err == nil && v == "onMetaData" {
+			st.meta = p
+		}
+	case msgVideo:
+		codec := p.data[0] & 0x0f
+		st.videoCodec = videoCodecs[codec]
+		if (codec == 7 || codec == 12) && len(p.data) > 1 && p.data[1] == 0 {
+			st.videoSeq = p
+			break
+		}
+		if p.data[0]>>4 == 1 {
+			st.gop = st.gop[:0]
+		}
+		if len(st.gop) < maxGOPPackets {
+			st.gop = append(st.gop, p)
+		}
+	case msgAudio:
+		format := p.data[0] >> 4
+		st.audioCodec = audioCodecs[format]
+		if format == 10 && len(p.data) > 1 && p.data[1] == 0 {
+			st.audioSeq = p
+			break
+		}
+		if len(st.gop) > 0 && len(st.gop) < maxGOPPackets {
+			st.gop = append(st.gop, p)
+		}
+	}
+
+	for sub := range st.subscribers {
+		select {
+		case sub.ch <- p:
+		default:
+			// 播放端跟不上时断开，避免拖慢推流
+			delete(st.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// 新的播放端先收到元数据、序列头和最近一个关键帧以来的数据
+func (st *stream) subscribe() *subscriber {
+	sub := &subscriber{stream: st, ch: make(chan *packet, subscriberQueue+maxGOPPackets+3)}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.closed {
+		close(sub.ch)
+		return sub
+	}
+	for _, p := range []*packet{st.meta, st.videoSeq, st.audioSeq} {
+		if p != nil {
+			sub.ch <- p
+		}
+	}
+	for _, p := range st.gop {
+		sub.ch <- p
+	}
+	st.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (st *stream) unsubscribe(sub *subscriber) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.subscribers[sub]; ok {
+		delete(st.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+func (st *stream) close() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.closed = true
+	for sub := range st.subscribers {
+		close(sub.ch)
+	}
+	st.subscribers = map[*subscriber]struct{}{}
+}
+
+func (st *stream) info() StreamInfo {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return StreamInfo{
+		App:         st.app,
+		Name:        st.name,
+		Clients:     len(st.subscribers),
+		BitrateKbps: st.bitrateKbps,
+		VideoCodec:  st.videoCodec,
+		AudioCodec:  st.audioCodec,
+		StartedAt:   st.startedAt,
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/Dong557799/zhibo-class/query"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// 后台任务状态
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed" // 重试次数用尽
+)
+
+const (
+	defaultJobWorkers     = 2
+	defaultJobMaxAttempts = 3
+	jobPollInterval       = time.Second
+	jobRetryBase          = 30 * time.Second
+	// 超过该时间仍在运行的任务视为实例已退出，由其他 worker 重新领取
+	jobStaleAfter = 2 * time.Hour
+)
+
+var jobSortColumns = map[string]string{
+	"id":     "id",
+	"run_at": "run_at",
+}
+
+// 任务处理函数，返回错误时按退避时间重试
+type jobHandler func(ctx context.Context, payload json.RawMessage) error
+
+var jobHandlers = map[string]jobHandler{}
+
+// 持久化的后台任务
+type Job struct {
+	ID          int             `json:"id"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	RunAt       time.Time       `json:"run_at"`
+	CreatedAt   time.Time       `json:"created_at"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+}
+
+// 加入任务队列，runAt 为零值时立即执行
+func enqueueJob(jobType string, payload interface{}, runAt time.Time) (int, error) {
+	if _, ok := jobHandlers[jobType]; !ok {
+		return 0, fmt.Errorf("unknown job type %q", jobType)
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	if runAt.IsZero() {
+		runAt = now
+	}
+	id, err := dialect.insertID(db, `
+		INSERT INTO jobs (type, payload, status, max_attempts, run_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, jobType, string(raw), JobQueued, defaultJobMaxAttempts, runAt.UTC(), now, now)
+	if err != nil {
+		return 0, err
+	}
+	return int(id), err
+}
+
+// 启动任务 worker，多副本之间通过行锁领取任务
+func runJobWorkers() {
+	n := currentConfig().JobWorkers
+	if n <= 0 {
+		n = defaultJobWorkers
+	}
+	for i := 0; i < n; i++ {
+		go runJobWorker()
+	}
+}
+
+func runJobWorker() {
+	for {
+		job, err := claimJob()
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Failed to claim job: %v", err)
+			}
+			time.Sleep(jobPollInterval)
+			continue
+		}
+		executeJob(job)
+	}
+}
+
+// 领取一个到期的任务
+func claimJob() (Job, error) {
+	var job Job
+	tx, err := db.Begin()
+	if err != nil {
+		return job, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	var payload string
+	err = tx.QueryRow(`
+		SELECT id, type, payload, attempts, max_attempts FROM jobs
+		WHERE (status = ? AND run_at <= ?) OR (status = ? AND updated_at < ?)
+		ORDER BY run_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`, JobQueued, now, JobRunning, now.Add(-jobStaleAfter)).Scan(&job.ID, &job.Type, &payload, &job.Attempts, &job.MaxAttempts)
+	if err != nil {
+		return job, err
+	}
+	job.Payload = json.RawMessage(payload)
+	job.Attempts++
+
+	if _, err := tx.Exec("UPDATE jobs SET status = ?, attempts = ?, updated_at = ? WHERE id = ?",
+		JobRunning, job.Attempts, now, job.ID); err != nil {
+		return job, err
+	}
+	return job, tx.Commit()
+}
+
+func executeJob(job Job) {
+	ctx, span := tracer.Start(context.Background(), "job "+job.Type)
+	defer span.End()
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+				log.Printf("Panic in job %d (%s): %v\n%s", job.ID, job.Type, r, debug.Stack())
+				reportPanic(nil, r, panicFrames(), map[string]string{"job_type": job.Type, "job_id": strconv.Itoa(job.ID)})
+			}
+		}()
+		handler, ok := jobHandlers[job.Type]
+		if !ok {
+			return fmt.Errorf("unknown job type %q", job.Type)
+		}
+		return handler(ctx, job.Payload)
+	}()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	now := time.Now().UTC()
+	if err == nil {
+		db.Exec("UPDATE jobs SET status = ?, last_error = NULL, finished_at = ?, updated_at = ? WHERE id = ?",
+			JobSucceeded, now, now, job.ID)
+		return
+	}
+
+	log.Printf("Job %d (%s) attempt %d failed: %v", job.ID, job.Type, job.Attempts, err)
+	if job.Attempts >= job.MaxAttempts {
+		db.Exec("UPDATE jobs SET status = ?, last_error = ?, finished_at = ?, updated_at = ? WHERE id = ?",
+			JobFailed, err.Error(), now, now, job.ID)
+		return
+	}
+	// 指数退避：30s、60s、120s……
+	retryAt := now.Add(jobRetryBase << (job.Attempts - 1))
+	db.Exec("UPDATE jobs SET status = ?, last_error = ?, run_at = ?, updated_at = ? WHERE id = ?",
+		JobQueued, err.Error(), retryAt, now, job.ID)
+}
+
+// 管理员查看任务队列
+func adminListJobs(c *gin.Context) {
+	page, pageSize, offset := pageParams(c)
+
+	q := query.New().
+		Eq("status", c.Query("status")).
+		Eq("type", c.Query("type")).
+		Sort(c.Query("sort"), jobSortColumns, "id DESC")
+
+	var total int64
+	if err := db.QueryRow("SELECT COUNT(*) FROM jobs WHERE "+q.WhereSQL(), q.Args()...).Scan(&total); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeJobGetFailed)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, type, payload, status, attempts, max_attempts, COALESCE(last_error, ''), run_at, created_at, finished_at
+		FROM jobs
+		WHERE `+q.WhereSQL()+`
+		ORDER BY `+q.OrderSQL()+`
+		LIMIT ? OFFSET ?
+	`, q.Args(pageSize, offset)...)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeJobGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	jobs := []Job{}
+	for rows.Next() {
+		var job Job
+		var payload string
+		if err := rows.Scan(&job.ID, &job.Type, &payload, &job.Status, &job.Attempts, &job.MaxAttempts,
+			&job.LastError, &job.RunAt, &job.CreatedAt, &job.FinishedAt); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeJobGetFailed)
+			return
+		}
+		job.Payload = json.RawMessage(payload)
+		job.RunAt = job.RunAt.In(loc)
+		job.CreatedAt = job.CreatedAt.In(loc)
+		job.FinishedAt = inLocation(job.FinishedAt, loc)
+		jobs = append(jobs, job)
+	}
+
+	respondPage(c, jobs, Pagination{Page: page, PageSize: pageSize, Total: total, HasMore: int64(offset+len(jobs)) < total})
+}
+
+// 管理员重试失败的任务，重新计算重试次数
+func adminRetryJob(c *gin.Context) {
+	jobID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+
+	now := time.Now().UTC()
+	result, err := db.Exec(`
+		UPDATE jobs
+		SET status = ?, attempts = 0, run_at = ?, finished_at = NULL, updated_at = ?
+		WHERE id = ? AND status = ?
+	`, JobQueued, now, now, jobID, JobFailed)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeJobRetryFailed)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		var status string
+		err := db.QueryRow("SELECT status FROM jobs WHERE id = ?", jobID).Scan(&status)
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(c, http.StatusNotFound, CodeJobNotFound)
+		} else {
+			respondError(c, http.StatusConflict, CodeJobNotFailed)
+		}
+		return
+	}
+
+	recordAudit(c, "retry_job", "job", jobID, nil)
+	respondOK(c, http.StatusOK, gin.H{"id": jobID, "status": JobQueued})
+}
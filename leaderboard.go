@@ -0,0 +1,184 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultLeaderboardSize = 10
+	maxLeaderboardSize     = 100
+)
+
+// 一次题目推送，作答耗时从推送时间开始计算
+type QuestionPush struct {
+	ID         int        `json:"id"`
+	QuestionID int        `json:"question_id"`
+	CourseID   int        `json:"course_id"`
+	FastestN   int        `json:"fastest_n,omitempty"` // 大于 0 时只奖励前 N 个答对的学生
+	Winners    int        `json:"winners"`
+	PushedAt   time.Time  `json:"pushed_at"`
+	ClosesAt   *time.Time `json:"closes_at,omitempty"` // 截止作答时间，为空时直到老师结束作答
+}
+
+// 速度排行
+type LeaderboardEntry struct {
+	Rank        int       `json:"rank"`
+	StudentID   int       `json:"student_id"`
+	LatencyMs   int64     `json:"latency_ms"`
+	SubmittedAt time.Time `json:"submitted_at"`
+}
+
+// 记录题目推送
+func createQuestionPush(questionID, courseID, fastestN int, duration time.Duration) (QuestionPush, error) {
+	push := QuestionPush{QuestionID: questionID, CourseID: courseID, FastestN: fastestN, PushedAt: time.Now().UTC()}
+	if duration > 0 {
+		closesAt := push.PushedAt.Add(duration)
+		push.ClosesAt = &closesAt
+	}
+	id, err := dialect.insertID(db, `
+		INSERT INTO question_pushes (question_id, course_id, fastest_n, pushed_at, closes_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, questionID, courseID, fastestN, push.PushedAt, push.ClosesAt)
+	if err != nil {
+		return push, err
+	}
+	push.ID = int(id)
+	return push, err
+}
+
+// 题目最近一次推送
+func latestQuestionPush(questionID int) (QuestionPush, error) {
+	var p QuestionPush
+	err := db.QueryRow(`
+		SELECT id, question_id, course_id, fastest_n, winners, pushed_at, closes_at
+		FROM question_pushes
+		WHERE question_id = ?
+		ORDER BY id DESC
+		LIMIT 1
+	`, questionID).Scan(&p.ID, &p.QuestionID, &p.CourseID, &p.FastestN, &p.Winners, &p.PushedAt, &p.ClosesAt)
+	return p, err
+}
+
+// 推送是否已截止作答
+func (p QuestionPush) closed(now time.Time) bool {
+	return p.ClosesAt != nil && !now.Before(*p.ClosesAt)
+}
+
+// 在“前 N 名答对”模式下争取名次，名额已满时返回 0
+func claimFastestRank(pushID int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var fastestN, winners int
+	err = tx.QueryRow("SELECT fastest_n, winners FROM question_pushes WHERE id = ? FOR UPDATE", pushID).Scan(&fastestN, &winners)
+	if err != nil {
+		return 0, err
+	}
+	if winners >= fastestN {
+		return 0, nil
+	}
+	if _, err := tx.Exec("UPDATE question_pushes SET winners = winners + 1 WHERE id = ?", pushID); err != nil {
+		return 0, err
+	}
+	return winners + 1, tx.Commit()
+}
+
+// 解析推送参数 fastest，表示只奖励前 N 个答对的学生
+func fastestParam(c *gin.Context) (int, bool) {
+	raw := c.Query("fastest")
+	if raw == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "fastest")
+		return 0, false
+	}
+	return n, true
+}
+
+// 推送时的作答时长，单位秒，为空或 0 表示直到老师结束作答
+func durationParam(c *gin.Context) (time.Duration, bool) {
+	raw := c.Query("duration")
+	if raw == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 || n > maxAnswerWindowSeconds {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "duration")
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// 题目答对学生的速度排行，默认取最近一次推送
+func getQuestionLeaderboard(c *gin.Context) {
+	questionID, ok := intParam(c, "question_id")
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultLeaderboardSize)))
+	if err != nil || limit <= 0 {
+		limit = defaultLeaderboardSize
+	}
+	if limit > maxLeaderboardSize {
+		limit = maxLeaderboardSize
+	}
+
+	push, err := latestQuestionPush(questionID)
+	if pushID := c.Query("push_id"); pushID != "" {
+		err = db.QueryRow(`
+			SELECT id, question_id, course_id, fastest_n, winners, pushed_at, closes_at
+			FROM question_pushes
+			WHERE id = ? AND question_id = ?
+		`, pushID, questionID).Scan(&push.ID, &push.QuestionID, &push.CourseID, &push.FastestN, &push.Winners, &push.PushedAt, &push.ClosesAt)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, CodeQuestionNotPushed)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeResultGetFailed)
+		}
+		return
+	}
+
+	// 每个学生只取最快的一次答对；离线同步的答案耗时按客户端时间推算，不参与排行
+	rows, err := db.Query(`
+		SELECT a.student_id, MIN(a.latency_ms), MIN(a.submitted_at)
+		FROM answers a
+		JOIN questions q ON q.id = a.question_id
+		WHERE a.push_id = ? AND a.answer = q.answer AND a.latency_ms IS NOT NULL AND NOT a.offline
+		GROUP BY a.student_id
+		ORDER BY MIN(a.latency_ms), a.student_id
+		LIMIT ?
+	`, push.ID, limit)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeResultGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	entries := []LeaderboardEntry{}
+	for rows.Next() {
+		var e LeaderboardEntry
+		if err := rows.Scan(&e.StudentID, &e.LatencyMs, &e.SubmittedAt); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeResultGetFailed)
+			return
+		}
+		e.Rank = len(entries) + 1
+		e.SubmittedAt = e.SubmittedAt.In(loc)
+		entries = append(entries, e)
+	}
+
+	push.PushedAt = push.PushedAt.In(loc)
+	respondOK(c, http.StatusOK, gin.H{"push": push, "entries": entries})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// livego_url 设为该值时使用内存中的 Livego 替身：只登记推流码，不处理音视频，
+// 用于没有 Livego 的本地开发和演示环境
+const livegoMemoryURL = "memory"
+
+var memoryLivego = struct {
+	sync.Mutex
+	streams map[string]time.Time
+}{streams: map[string]time.Time{}}
+
+func livegoStubbed() bool {
+	conf := currentConfig()
+	switch conf.MediaServer.Driver {
+	case "", MediaLivego:
+		return conf.LivegoURL == livegoMemoryURL
+	}
+	return false
+}
+
+func addMemoryStream(streamKey string) {
+	memoryLivego.Lock()
+	defer memoryLivego.Unlock()
+	memoryLivego.streams[streamKey] = time.Now().UTC()
+}
+
+func deleteMemoryStream(streamKey string) {
+	memoryLivego.Lock()
+	defer memoryLivego.Unlock()
+	delete(memoryLivego.streams, streamKey)
+}
+
+// 内存替身的流媒体服务器，回调格式与 Livego 相同
+type memoryServer struct {
+	livegoServer
+}
+
+func (memoryServer) CreateStream(ctx context.Context, streamKey string) error {
+	addMemoryStream(streamKey)
+	return nil
+}
+
+func (memoryServer) DeleteStream(ctx context.Context, streamKey string) error {
+	deleteMemoryStream(streamKey)
+	return nil
+}
+
+// 替身不接收推流，已登记的推流码都视为没有推流
+func (memoryServer) GetStats(ctx context.Context) ([]StreamStats, error) {
+	return []StreamStats{}, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const lockWait = 5 * time.Second
+
+var errLockTimeout = errors.New("lock wait timeout")
+
+func sessionLockName(sessionID int) string { return fmt.Sprintf("zhibo:session:%d", sessionID) }
+
+// 获取数据库命名锁，多个副本之间互斥；锁与连接绑定，释放时归还连接
+func acquireLock(name string, wait time.Duration) (func(), error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	got, err := dialect.acquireLock(ctx, conn, name, wait)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !got {
+		conn.Close()
+		return nil, errLockTimeout
+	}
+
+	return func() {
+		if err := dialect.releaseLock(ctx, conn, name); err != nil {
+			log.Printf("Failed to release lock %s: %v", name, err)
+		}
+		conn.Close()
+	}, nil
+}
+
+// 在持有锁的情况下执行，用于后台任务
+func withLock(name string, fn func() error) error {
+	unlock, err := acquireLock(name, lockWait)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return fn()
+}
+
+// 锁定会话的状态变更，失败时写入错误响应
+func lockSession(c *gin.Context, sessionID int) (func(), bool) {
+	unlock, err := acquireLock(sessionLockName(sessionID), lockWait)
+	if errors.Is(err, errLockTimeout) {
+		respondError(c, http.StatusConflict, CodeSessionBusy)
+		return nil, false
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return nil, false
+	}
+	return unlock, true
+}
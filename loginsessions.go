@@ -0,0 +1,240 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 注销设备、停用账号或变更 API Key 的跨实例通知类型
+const controlAuth = "auth"
+
+const (
+	loginSessionIDLength = 24
+
+	// 多副本部署时其他实例注销的设备最迟在该时间后失效，最后活跃时间也按该间隔更新
+	loginSessionCacheTTL = 30 * time.Second
+)
+
+// 一次登录签发的令牌，对应一台设备
+type LoginSession struct {
+	ID             string    `json:"id"`
+	ImpersonatorID int       `json:"impersonator_id,omitempty"` // 管理员代登录
+	UserAgent      string    `json:"user_agent"`
+	IP             string    `json:"ip"`
+	CreatedAt      time.Time `json:"created_at"`
+	LastSeenAt     time.Time `json:"last_seen_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	Current        bool      `json:"current"` // 发起请求的设备
+}
+
+type cachedLoginSession struct {
+	active   bool
+	loadedAt time.Time
+}
+
+var (
+	loginSessionMu    sync.Mutex
+	loginSessionCache = make(map[string]cachedLoginSession)
+)
+
+func init() {
+	// 其他副本注销设备、停用账号或吊销 API Key 后清空本地缓存，已注销的令牌在下一次请求时即失效
+	controlHandlers[controlAuth] = func() {
+		loginSessionMu.Lock()
+		loginSessionCache = make(map[string]cachedLoginSession)
+		loginSessionMu.Unlock()
+		userStatusMu.Lock()
+		userStatusCache = make(map[int]cachedUserStatus)
+		userStatusMu.Unlock()
+		apiKeyMu.Lock()
+		apiKeyCache = make(map[string]cachedAPIKey)
+		apiKeyMu.Unlock()
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// 记录新的登录设备，返回写入令牌的设备ID。同时清理该用户已过期的记录
+func createLoginSession(c *gin.Context, userID, impersonatorID int, expiresAt time.Time) (string, error) {
+	now := time.Now().UTC()
+	id := generateRandomString(loginSessionIDLength)
+	_, err := db.Exec(`
+		INSERT INTO login_sessions (id, user_id, impersonator_id, user_agent, ip, created_at, last_seen_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, id, userID, impersonatorID, truncate(c.Request.UserAgent(), 255), c.ClientIP(), now, now, expiresAt.UTC())
+	if err != nil {
+		return "", err
+	}
+	db.Exec("DELETE FROM login_sessions WHERE user_id = ? AND expires_at < ?", userID, now)
+	db.Exec("DELETE FROM refresh_tokens WHERE user_id = ? AND expires_at < ?", userID, now)
+	return id, nil
+}
+
+// 设备是否仍可使用，缓存过期重新加载时顺带更新最后活跃时间和 IP
+func loginSessionActive(c *gin.Context, id string, userID int) (bool, error) {
+	loginSessionMu.Lock()
+	cached, ok := loginSessionCache[id]
+	loginSessionMu.Unlock()
+	if ok && time.Since(cached.loadedAt) < loginSessionCacheTTL {
+		return cached.active, nil
+	}
+
+	now := time.Now().UTC()
+	res, err := db.Exec("UPDATE login_sessions SET last_seen_at = ?, ip = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		now, c.ClientIP(), id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	active := n > 0
+	loginSessionMu.Lock()
+	loginSessionCache[id] = cachedLoginSession{active: active, loadedAt: time.Now()}
+	loginSessionMu.Unlock()
+	return active, nil
+}
+
+// 注销后立即在所有实例生效
+func invalidateLoginSessions(ids ...string) {
+	loginSessionMu.Lock()
+	for _, id := range ids {
+		delete(loginSessionCache, id)
+	}
+	loginSessionMu.Unlock()
+	publishControl(controlAuth)
+}
+
+// 注销用户的设备，except 不为空时保留该设备，id 不为空时只注销该设备。返回注销的设备ID
+func revokeLoginSessions(userID int, id, except string) ([]string, error) {
+	q := "SELECT id FROM login_sessions WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?"
+	now := time.Now().UTC()
+	args := []interface{}{userID, now}
+	if id != "" {
+		q += " AND id = ?"
+		args = append(args, id)
+	}
+	if except != "" {
+		q += " AND id <> ?"
+		args = append(args, except)
+	}
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for rows.Next() {
+		var sid string
+		if err := rows.Scan(&sid); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, sid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, sid := range ids {
+		if _, err := db.Exec("UPDATE login_sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", now, sid); err != nil {
+			return nil, err
+		}
+		if _, err := db.Exec("DELETE FROM refresh_tokens WHERE login_session_id = ?", sid); err != nil {
+			return nil, err
+		}
+	}
+	invalidateLoginSessions(ids...)
+	return ids, nil
+}
+
+// 当前用户未过期的登录设备，按最后活跃时间排序
+func listLoginSessions(c *gin.Context) {
+	user := currentUser(c)
+	rows, err := db.Query(`
+		SELECT id, impersonator_id, user_agent, ip, created_at, last_seen_at, expires_at
+		FROM login_sessions
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY last_seen_at DESC
+	`, user.ID, time.Now().UTC())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeLoginSessionGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	sessions := []LoginSession{}
+	for rows.Next() {
+		var s LoginSession
+		if err := rows.Scan(&s.ID, &s.ImpersonatorID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeLoginSessionGetFailed)
+			return
+		}
+		s.Current = s.ID == user.LoginSessionID
+		s.CreatedAt, s.LastSeenAt, s.ExpiresAt = s.CreatedAt.In(loc), s.LastSeenAt.In(loc), s.ExpiresAt.In(loc)
+		sessions = append(sessions, s)
+	}
+	respondOK(c, http.StatusOK, sessions)
+}
+
+// 注销自己的一台设备，可以是当前设备
+func revokeLoginSession(c *gin.Context) {
+	user := currentUser(c)
+	id := c.Param("id")
+	ids, err := revokeLoginSessions(user.ID, id, "")
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeLoginSessionRevokeFailed)
+		return
+	}
+	if len(ids) == 0 {
+		respondError(c, http.StatusNotFound, CodeLoginSessionNotFound)
+		return
+	}
+	recordAudit(c, "revoke_login_session", "user", user.ID, gin.H{"login_session_id": id})
+	respondOK(c, http.StatusOK, gin.H{"revoked": ids})
+}
+
+// 注销除当前设备外的所有设备
+func revokeOtherLoginSessions(c *gin.Context) {
+	user := currentUser(c)
+	// 旧令牌没有设备ID，此时注销所有记录的设备
+	ids, err := revokeLoginSessions(user.ID, "", user.LoginSessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeLoginSessionRevokeFailed)
+		return
+	}
+	recordAudit(c, "revoke_other_login_sessions", "user", user.ID, gin.H{"count": len(ids)})
+	respondOK(c, http.StatusOK, gin.H{"revoked": ids})
+}
+
+// 管理员注销用户的所有设备，如账号被盗时使用
+func adminRevokeLoginSessions(c *gin.Context) {
+	userID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	var one int
+	if err := db.QueryRow("SELECT 1 FROM users WHERE id = ?", userID).Scan(&one); err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, CodeUserNotFound)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeUserGetFailed)
+		}
+		return
+	}
+	ids, err := revokeLoginSessions(userID, "", "")
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeLoginSessionRevokeFailed)
+		return
+	}
+	recordAudit(c, "revoke_login_sessions", "user", userID, gin.H{"count": len(ids)})
+	respondOK(c, http.StatusOK, gin.H{"user_id": userID, "revoked": ids})
+}
@@ -0,0 +1,694 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dong557799/zhibo-class/query"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	ltiClaimPrefix    = "https://purl.imsglobal.org/spec/lti/claim/"
+	ltiAGSClaim       = "https://purl.imsglobal.org/spec/lti-ags/claim/endpoint"
+	ltiScopeLineItem  = "https://purl.imsglobal.org/spec/lti-ags/scope/lineitem"
+	ltiScopeScore     = "https://purl.imsglobal.org/spec/lti-ags/scope/score"
+	ltiMessageLaunch  = "LtiResourceLinkRequest"
+	ltiVersion        = "1.3.0"
+	ltiAssertionTTL   = 5 * time.Minute
+	jobLTIScore       = "lti.score"
+	ltiExamResourceID = "zhibo-exam-%d" // 测验对应的 AGS line item resourceId
+)
+
+// LTI 1.3 工具配置，课堂可嵌入 Moodle、Canvas 等平台
+type LTIConfig struct {
+	LaunchURL string `json:"launch_url"` // 本服务的启动地址，如 https://class.example.edu/api/lti/launch，需在平台登记为重定向地址
+	// 启动成功后跳转的前端地址，令牌和课程放在 URL fragment 中；为空时返回 JSON
+	LaunchRedirect string `json:"launch_redirect"`
+	// 工具的 RSA 私钥（PEM），回传成绩时向平台申请令牌；公钥通过 /api/lti/jwks 提供给平台
+	PrivateKey string        `json:"private_key"`
+	KeyID      string        `json:"key_id"`
+	Platforms  []LTIPlatform `json:"platforms"`
+
+	// 由 PrivateKey 解析得到，随配置一起替换
+	privateKey *rsa.PrivateKey
+}
+
+// 接入的 LMS 平台，在平台中注册工具后获得
+type LTIPlatform struct {
+	Issuer        string   `json:"issuer"`
+	ClientID      string   `json:"client_id"`
+	DeploymentIDs []string `json:"deployment_ids"` // 为空时接受所有部署
+	AuthURL       string   `json:"auth_url"`       // 平台的 OIDC 授权地址
+	TokenURL      string   `json:"token_url"`      // 平台的 OAuth2 令牌地址，回传成绩时使用
+	JWKSURL       string   `json:"jwks_url"`
+	SendGrades    bool     `json:"send_grades"` // 测验交卷后通过 AGS 回传成绩
+	// 把平台中的教师、助教升级为本系统的教师；教师角色在全站生效，只对可信的平台开启
+	PromoteInstructors bool `json:"promote_instructors"`
+}
+
+// LMS 课程与本系统课程的对应关系
+type LTIContext struct {
+	Issuer       string    `json:"issuer"`
+	ContextID    string    `json:"context_id"`
+	CourseID     int       `json:"course_id"`
+	Title        string    `json:"title"`
+	LineItemsURL string    `json:"lineitems_url,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// 解析工具私钥，支持 PKCS#1 和 PKCS#8
+func parseLTIPrivateKey(conf *LTIConfig) error {
+	if conf.PrivateKey == "" {
+		return nil
+	}
+	block, _ := pem.Decode([]byte(conf.PrivateKey))
+	if block == nil {
+		return errors.New("lti.private_key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		conf.privateKey = key
+		return nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("lti.private_key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return errors.New("lti.private_key is not an RSA key")
+	}
+	conf.privateKey = rsaKey
+	return nil
+}
+
+// 按 issuer 和 client_id 查找平台；登录请求可能不带 client_id，此时 issuer 需唯一
+func findLTIPlatform(issuer, clientID string) (LTIPlatform, bool) {
+	var found []LTIPlatform
+	for _, p := range currentConfig().LTI.Platforms {
+		if p.Issuer == issuer && (clientID == "" || p.ClientID == clientID) {
+			found = append(found, p)
+		}
+	}
+	if len(found) != 1 {
+		return LTIPlatform{}, false
+	}
+	return found[0], true
+}
+
+// 平台发起的第三方登录，跳转回平台的授权地址获取启动令牌
+func ltiLogin(c *gin.Context) {
+	param := c.Request.FormValue
+	platform, ok := findLTIPlatform(param("iss"), param("client_id"))
+	if !ok {
+		respondError(c, http.StatusBadRequest, CodeLTIPlatformUnknown)
+		return
+	}
+	nonce, err := oidcRandom(18)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	q := url.Values{
+		"scope":         {"openid"},
+		"response_type": {"id_token"},
+		"response_mode": {"form_post"},
+		"prompt":        {"none"},
+		"client_id":     {platform.ClientID},
+		"redirect_uri":  {currentConfig().LTI.LaunchURL},
+		"login_hint":    {param("login_hint")},
+		"state":         {oidcState(nonce, time.Now().Add(oidcStateTTL))},
+		"nonce":         {nonce},
+	}
+	if hint := param("lti_message_hint"); hint != "" {
+		q.Set("lti_message_hint", hint)
+	}
+	sep := "?"
+	if strings.Contains(platform.AuthURL, "?") {
+		sep = "&"
+	}
+	c.Redirect(http.StatusFound, platform.AuthURL+sep+q.Encode())
+}
+
+// 平台回传的启动请求：校验启动令牌，对应课程和用户后签发本系统的访问令牌
+func ltiLaunch(c *gin.Context) {
+	nonce, ok := parseOIDCState(c.PostForm("state"))
+	if !ok {
+		respondError(c, http.StatusBadRequest, CodeOIDCStateInvalid)
+		return
+	}
+	platform, claims, err := parseLTILaunch(c.PostForm("id_token"), nonce)
+	if err != nil {
+		log.Printf("Rejected LTI launch: %v", err)
+		respondError(c, http.StatusUnauthorized, CodeLTILaunchInvalid)
+		return
+	}
+	// 每个 nonce 只能使用一次，防止启动令牌被重放
+	now := time.Now().UTC()
+	if _, err := db.Exec("DELETE FROM lti_nonces WHERE expires_at < ?", now); err != nil {
+		log.Printf("Failed to purge LTI nonces: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO lti_nonces (nonce, expires_at) VALUES (?, ?)", nonce, now.Add(oidcStateTTL)); err != nil {
+		if dialect.isDuplicate(err) {
+			respondError(c, http.StatusUnauthorized, CodeLTILaunchInvalid)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeLTILaunchFailed)
+		}
+		return
+	}
+
+	courseID, err := mapLTIContext(platform, claims)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeLTICourseNotMapped)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeLTILaunchFailed)
+		return
+	}
+	user, created, err := provisionLTIUser(platform, claims, courseID)
+	if err != nil {
+		log.Printf("Failed to provision LTI user: %v", err)
+		respondError(c, http.StatusInternalServerError, CodeLTILaunchFailed)
+		return
+	}
+	if user.Status != "active" {
+		respondError(c, http.StatusForbidden, CodeUserDisabled)
+		return
+	}
+	if created {
+		recordAudit(c, "lti_signup", "user", user.ID, gin.H{"issuer": platform.Issuer, "subject": claims["sub"], "course_id": courseID})
+	}
+
+	tokens, err := issueToken(c, user, 0, accessTokenTTL())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	if redirect := currentConfig().LTI.LaunchRedirect; redirect != "" {
+		fragment := tokens.fragment()
+		fragment.Set("course_id", strconv.Itoa(courseID))
+		c.Redirect(http.StatusFound, redirect+"#"+fragment.Encode())
+		return
+	}
+	respondOK(c, http.StatusOK, tokens.response(gin.H{
+		"user":      user,
+		"course_id": courseID,
+		"created":   created,
+	}))
+}
+
+// 用平台公钥校验启动令牌，并检查 audience、nonce、部署和消息类型
+func parseLTILaunch(idToken, nonce string) (LTIPlatform, jwt.MapClaims, error) {
+	var platform LTIPlatform
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		issuer, _ := claims.GetIssuer()
+		aud, _ := claims.GetAudience()
+		var ok bool
+		for _, clientID := range aud {
+			if platform, ok = findLTIPlatform(issuer, clientID); ok {
+				break
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown platform %q", issuer)
+		}
+		kid, _ := t.Header["kid"].(string)
+		return jwksSigningKey(platform.JWKSURL, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return platform, nil, err
+	}
+	if aud, _ := claims.GetAudience(); len(aud) > 1 && claims["azp"] != platform.ClientID {
+		return platform, nil, errors.New("azp does not match client_id")
+	}
+	if claims["nonce"] != nonce {
+		return platform, nil, errors.New("nonce mismatch")
+	}
+	if sub := claimString(claims, "sub"); sub == "" {
+		return platform, nil, errors.New("launch has no subject")
+	}
+	deployment := claimString(claims, ltiClaimPrefix+"deployment_id")
+	if len(platform.DeploymentIDs) > 0 && !contains(platform.DeploymentIDs, deployment) {
+		return platform, nil, fmt.Errorf("unknown deployment %q", deployment)
+	}
+	if claimString(claims, ltiClaimPrefix+"message_type") != ltiMessageLaunch {
+		return platform, nil, errors.New("unsupported message type")
+	}
+	if claimString(claims, ltiClaimPrefix+"version") != ltiVersion {
+		return platform, nil, errors.New("unsupported LTI version")
+	}
+	return platform, claims, nil
+}
+
+func claimObject(claims jwt.MapClaims, name string) map[string]interface{} {
+	obj, _ := claims[name].(map[string]interface{})
+	return obj
+}
+
+// LMS 课程对应的本系统课程，只使用管理员设置的对应关系，启动请求不能创建或修改对应关系；
+// 同时记录 AGS 的 line items 地址供回传成绩
+func mapLTIContext(platform LTIPlatform, claims jwt.MapClaims) (int, error) {
+	ltiContext := claimObject(claims, ltiClaimPrefix+"context")
+	contextID, _ := ltiContext["id"].(string)
+	if contextID == "" {
+		return 0, sql.ErrNoRows
+	}
+	title, _ := ltiContext["title"].(string)
+	var lineItems sql.NullString
+	if ags := claimObject(claims, ltiAGSClaim); ags != nil {
+		if u, _ := ags["lineitems"].(string); u != "" {
+			lineItems = sql.NullString{String: u, Valid: true}
+		}
+	}
+
+	var courseID int
+	err := db.QueryRow("SELECT course_id FROM lti_contexts WHERE issuer = ? AND context_id = ?", platform.Issuer, contextID).Scan(&courseID)
+	if err != nil {
+		return 0, err
+	}
+	_, err = db.Exec(`
+		UPDATE lti_contexts SET title = ?, lineitems_url = COALESCE(?, lineitems_url), updated_at = ?
+		WHERE issuer = ? AND context_id = ?
+	`, title, lineItems, time.Now().UTC(), platform.Issuer, contextID)
+	return courseID, err
+}
+
+// 平台开启 promote_instructors 时，平台中的教师、助教和管理员对应本系统的教师，其余为学生；
+// 不会授予管理员角色
+func ltiRole(platform LTIPlatform, claims jwt.MapClaims) string {
+	if !platform.PromoteInstructors {
+		return RoleStudent
+	}
+	roles, _ := claims[ltiClaimPrefix+"roles"].([]interface{})
+	for _, r := range roles {
+		s, _ := r.(string)
+		for _, suffix := range []string{"#Instructor", "#Administrator", "#ContentDeveloper", "Instructor#TeachingAssistant"} {
+			if strings.HasSuffix(s, suffix) {
+				return RoleTeacher
+			}
+		}
+	}
+	return RoleStudent
+}
+
+// 按平台用户查找或创建账号；平台开启 promote_instructors 时教师身份会把学生账号升级为教师，学生加入课程
+func provisionLTIUser(platform LTIPlatform, claims jwt.MapClaims, courseID int) (User, bool, error) {
+	subject := claimString(claims, "sub")
+	role := ltiRole(platform, claims)
+
+	userID, err := identityUser(platform.Issuer, subject)
+	created := false
+	if err == sql.ErrNoRows {
+		username := claimString(claims, "email")
+		if username == "" {
+			username = "lti_" + subject
+		}
+		userID, err = createExternalUser(username, claimString(claims, "name"), role, "")
+		if err == nil {
+			created = true
+			userID, err = linkIdentity(platform.Issuer, subject, userID)
+		}
+	} else if err == nil && role == RoleTeacher {
+		_, err = db.Exec("UPDATE users SET role = ? WHERE id = ? AND role = ?", RoleTeacher, userID, RoleStudent)
+	}
+	if err != nil {
+		return User{}, false, err
+	}
+
+	user, err := getUser(userID)
+	if err == nil && user.Role == RoleStudent {
+		_, err = db.Exec(dialect.insertIgnore(`
+			INSERT INTO course_enrollments (course_id, student_id, created_at) VALUES (?, ?, ?)
+		`), courseID, userID, time.Now().UTC())
+	}
+	return user, created, err
+}
+
+// 工具的公钥，平台用来校验成绩回传时的客户端断言
+func ltiJWKS(c *gin.Context) {
+	conf := currentConfig().LTI
+	keys := []gin.H{}
+	if key := conf.privateKey; key != nil {
+		keys = append(keys, gin.H{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": conf.KeyID,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// LMS 课程对应关系，管理员查看
+func adminListLTIContexts(c *gin.Context) {
+	rows, err := db.Query(`
+		SELECT issuer, context_id, course_id, title, COALESCE(lineitems_url, ''), created_at, updated_at
+		FROM lti_contexts ORDER BY updated_at DESC
+	`)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeLTIContextGetFailed)
+		return
+	}
+	defer rows.Close()
+	loc := requestLocation(c)
+	contexts := []LTIContext{}
+	for rows.Next() {
+		var x LTIContext
+		if err := rows.Scan(&x.Issuer, &x.ContextID, &x.CourseID, &x.Title, &x.LineItemsURL, &x.CreatedAt, &x.UpdatedAt); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeLTIContextGetFailed)
+			return
+		}
+		x.CreatedAt = x.CreatedAt.In(loc)
+		x.UpdatedAt = x.UpdatedAt.In(loc)
+		contexts = append(contexts, x)
+	}
+	respondOK(c, http.StatusOK, contexts)
+}
+
+// 设置 LMS 课程对应的本系统课程，启动前必须由管理员设置
+func adminSetLTIContext(c *gin.Context) {
+	var req struct {
+		Issuer    string `json:"issuer" binding:"required"`
+		ContextID string `json:"context_id" binding:"required,max=255"`
+		CourseID  int    `json:"course_id" binding:"required,gt=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if _, ok := findLTIPlatform(req.Issuer, ""); !ok {
+		respondError(c, http.StatusBadRequest, CodeLTIPlatformUnknown)
+		return
+	}
+	now := time.Now().UTC()
+	_, err := db.Exec(dialect.upsert(`
+		INSERT INTO lti_contexts (issuer, context_id, course_id, title, created_at, updated_at)
+		VALUES (?, ?, ?, '', ?, ?)`,
+		[]string{"issuer", "context_id"},
+		"course_id = EXCLUDED.course_id",
+		"updated_at = EXCLUDED.updated_at",
+	), req.Issuer, req.ContextID, req.CourseID, now, now)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeLTIContextSetFailed)
+		return
+	}
+	recordAudit(c, "set_lti_context", "course", req.CourseID, gin.H{"issuer": req.Issuer, "context_id": req.ContextID})
+	respondOK(c, http.StatusOK, gin.H{"issuer": req.Issuer, "context_id": req.ContextID, "course_id": req.CourseID})
+}
+
+func init() {
+	// 通过 AGS 把测验成绩回传到 LMS
+	jobHandlers[jobLTIScore] = func(ctx context.Context, payload json.RawMessage) error {
+		var p struct {
+			ExamID    int `json:"exam_id"`
+			StudentID int `json:"student_id"`
+		}
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return sendLTIScore(ctx, p.ExamID, p.StudentID)
+	}
+}
+
+// 课程关联了开启成绩回传的 LMS 课程时，排队回传学生的测验成绩
+func queueLTIScore(courseID, examID, studentID int) {
+	conf := currentConfig().LTI
+	if conf.privateKey == nil {
+		return
+	}
+	var issuers []interface{}
+	for _, p := range conf.Platforms {
+		if p.SendGrades {
+			issuers = append(issuers, p.Issuer)
+		}
+	}
+	if len(issuers) == 0 {
+		return
+	}
+	var n int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM lti_contexts
+		WHERE course_id = ? AND lineitems_url IS NOT NULL AND issuer IN (`+query.Placeholders(len(issuers))+`)
+	`, append([]interface{}{courseID}, issuers...)...).Scan(&n)
+	if err != nil || n == 0 {
+		return
+	}
+	if _, err := enqueueJob(jobLTIScore, gin.H{"exam_id": examID, "student_id": studentID}, time.Time{}); err != nil {
+		log.Printf("Failed to queue LTI score for exam %d student %d: %v", examID, studentID, err)
+	}
+}
+
+func sendLTIScore(ctx context.Context, examID, studentID int) error {
+	exam, err := loadExam(examID)
+	if err != nil {
+		return err
+	}
+	// 回传包含部分得分的成绩，早期交卷没有记录 points 时使用答对题数
+	var score float64
+	var submittedAt sql.NullTime
+	err = db.QueryRow("SELECT COALESCE(points, score), submitted_at FROM exam_attempts WHERE exam_id = ? AND student_id = ?", examID, studentID).
+		Scan(&score, &submittedAt)
+	if err != nil {
+		return err
+	}
+	if !submittedAt.Valid {
+		return nil
+	}
+
+	rows, err := db.Query(`
+		SELECT x.issuer, x.lineitems_url, i.subject
+		FROM lti_contexts x
+		JOIN user_identities i ON i.issuer = x.issuer AND i.user_id = ?
+		WHERE x.course_id = ? AND x.lineitems_url IS NOT NULL
+	`, studentID, exam.CourseID)
+	if err != nil {
+		return err
+	}
+	type target struct{ issuer, lineItems, subject string }
+	var targets []target
+	for rows.Next() {
+		var t target
+		if err := rows.Scan(&t.issuer, &t.lineItems, &t.subject); err != nil {
+			rows.Close()
+			return err
+		}
+		targets = append(targets, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, t := range targets {
+		platform, ok := findLTIPlatform(t.issuer, "")
+		if !ok || !platform.SendGrades {
+			continue
+		}
+		token, err := ltiAccessToken(ctx, platform)
+		if err != nil {
+			return err
+		}
+		lineItem, err := ltiLineItem(ctx, token, t.lineItems, exam)
+		if err != nil {
+			return err
+		}
+		err = ltiRequest(ctx, token, http.MethodPost, ltiEndpoint(lineItem, "/scores"), "application/vnd.ims.lis.v1.score+json", gin.H{
+			"userId":           t.subject,
+			"scoreGiven":       score,
+			"scoreMaximum":     max(1, len(exam.QuestionIDs)),
+			"activityProgress": "Completed",
+			"gradingProgress":  "FullyGraded",
+			"timestamp":        submittedAt.Time.UTC().Format(time.RFC3339Nano),
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 在地址路径后追加子路径，保留查询参数
+func ltiEndpoint(base, suffix string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return base + suffix
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + suffix
+	return u.String()
+}
+
+// 查找测验对应的 line item，不存在时创建
+func ltiLineItem(ctx context.Context, token, lineItemsURL string, exam Exam) (string, error) {
+	resourceID := fmt.Sprintf(ltiExamResourceID, exam.ID)
+	u, err := url.Parse(lineItemsURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("resource_id", resourceID)
+	u.RawQuery = q.Encode()
+
+	var items []struct {
+		ID string `json:"id"`
+	}
+	if err := ltiRequest(ctx, token, http.MethodGet, u.String(), "", nil, &items); err != nil {
+		return "", err
+	}
+	if len(items) > 0 {
+		return items[0].ID, nil
+	}
+	var item struct {
+		ID string `json:"id"`
+	}
+	err = ltiRequest(ctx, token, http.MethodPost, lineItemsURL, "application/vnd.ims.lis.v2.lineitem+json", gin.H{
+		"label":        exam.Title,
+		"scoreMaximum": max(1, len(exam.QuestionIDs)),
+		"resourceId":   resourceID,
+	}, &item)
+	if err == nil && item.ID == "" {
+		err = errors.New("platform returned a line item without id")
+	}
+	return item.ID, err
+}
+
+func ltiRequest(ctx context.Context, token, method, endpoint, contentType string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = strings.NewReader(string(raw))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if out != nil {
+		req.Header.Set("Accept", "application/vnd.ims.lis.v2.lineitemcontainer+json, application/vnd.ims.lis.v2.lineitem+json, application/json")
+	}
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s returned status %d: %s", method, endpoint, resp.StatusCode, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// 平台访问令牌，按平台缓存到过期前
+var ltiTokens = struct {
+	sync.Mutex
+	tokens map[string]ltiToken
+}{tokens: map[string]ltiToken{}}
+
+type ltiToken struct {
+	value   string
+	expires time.Time
+}
+
+// 用工具私钥签名的客户端断言换取 AGS 访问令牌
+func ltiAccessToken(ctx context.Context, platform LTIPlatform) (string, error) {
+	cacheKey := platform.Issuer + "\n" + platform.ClientID
+	ltiTokens.Lock()
+	if t, ok := ltiTokens.tokens[cacheKey]; ok && time.Now().Before(t.expires) {
+		ltiTokens.Unlock()
+		return t.value, nil
+	}
+	ltiTokens.Unlock()
+
+	conf := currentConfig().LTI
+	if conf.privateKey == nil {
+		return "", errors.New("lti private key is not configured")
+	}
+	jti, err := oidcRandom(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	assertion := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{
+		Issuer:    platform.ClientID,
+		Subject:   platform.ClientID,
+		Audience:  jwt.ClaimStrings{platform.TokenURL},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ltiAssertionTTL)),
+		ID:        jti,
+	})
+	assertion.Header["kid"] = conf.KeyID
+	signed, err := assertion.SignedString(conf.privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {signed},
+		"scope":                 {ltiScopeLineItem + " " + ltiScopeScore},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, platform.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("token endpoint returned status %d: %s", resp.StatusCode, msg)
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.AccessToken == "" {
+		return "", errors.New("token response has no access_token")
+	}
+	ttl := time.Duration(max(result.ExpiresIn-30, 0)) * time.Second
+	ltiTokens.Lock()
+	ltiTokens.tokens[cacheKey] = ltiToken{value: result.AccessToken, expires: time.Now().Add(ttl)}
+	ltiTokens.Unlock()
+	return result.AccessToken, nil
+}
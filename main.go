@@ -1,17 +1,22 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/XSAM/otelsql"
 	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Config struct {
@@ -20,45 +25,195 @@ type Config struct {
 	DBHost     string `json:"db_host"`
 	DBPort     int    `json:"db_port"`
 	DBName     string `json:"db_name"`
-	LivegoURL  string `json:"livego_url"`
-	APIPort    int    `json:"api_port"`
+	DBDriver   string `json:"db_driver"` // mysql（默认）、postgres 或 sqlite，sqlite 时 db_name 为文件路径
+
+	// 可选的只读副本，分析和导出查询走副本；用户名和密码为空时与主库相同
+	DBReplicaHost     string `json:"db_replica_host"`
+	DBReplicaPort     int    `json:"db_replica_port"`
+	DBReplicaUser     string `json:"db_replica_user"`
+	DBReplicaPassword string `json:"db_replica_password"`
+	LivegoURL         string `json:"livego_url"`
+	APIPort           int    `json:"api_port"`
+	JWTSecret         string `json:"jwt_secret"`
+
+	// 访问令牌有效期（分钟），为 0 时使用 24 小时。refresh_token_days 大于 0 时登录同时签发刷新令牌，
+	// 访问令牌可以设置得较短，过期后用刷新令牌换取新的令牌
+	TokenMinutes     int `json:"token_minutes"`
+	RefreshTokenDays int `json:"refresh_token_days"`
+
+	// 教师推流使用的 RTMP 地址，如 rtmp://live.example.com:1935/live；为空时使用流媒体服务器的 RTMP 地址
+	RTMPPublishURL string `json:"rtmp_publish_url"`
+	// 推流令牌有效期（秒），为 0 时使用 4 小时；allow_bare_stream_key 为 true 时允许不带令牌推流
+	PublishTokenSeconds int  `json:"publish_token_seconds"`
+	AllowBareStreamKey  bool `json:"allow_bare_stream_key"`
+
+	// 初始管理员账号，仅在没有管理员时创建
+	AdminUsername string `json:"admin_username"`
+	AdminPassword string `json:"admin_password"`
+
+	// 为旧客户端保留不带 code/message/data 外层的响应格式
+	LegacyResponse bool `json:"legacy_response"`
+
+	// 可选的 MQTT 桥接，向低功耗设备转发题目和会话状态
+	MQTT MQTTConfig `json:"mqtt"`
+
+	// 可选的 Kafka/NATS 事件总线，发布领域事件
+	EventBus EventBusConfig `json:"event_bus"`
+
+	// 多副本部署时用于广播转发和在线状态的 Redis
+	Redis RedisConfig `json:"redis"`
+
+	// 内置 TLS 终结，小规模部署可不依赖反向代理
+	TLS TLSConfig `json:"tls"`
+
+	// 机构默认时区（IANA 名称，如 Asia/Shanghai），为空时使用 UTC；数据库中统一存储 UTC
+	TimeZone string `json:"time_zone"`
+
+	// Livego 的 flv_dir，录制分段保存在其下的 live 目录
+	RecordingDir string `json:"recording_dir"`
+	FFmpegPath   string `json:"ffmpeg_path"`
+
+	// 课后录像处理时是否裁掉开头的静音
+	TrimDeadAir bool `json:"trim_dead_air"`
+
+	// 聊天、答题等数据的保留期限
+	Retention RetentionConfig `json:"retention"`
+
+	// 录像默认的低频存储转存和删除期限，课程和机构可单独设置
+	RecordingPolicy RecordingPolicyConfig `json:"recording_policy"`
+
+	// 学生播放令牌与同时播放数量限制
+	Playback PlaybackConfig `json:"playback"`
+
+	// 付费课程的支付渠道，未配置的渠道不能下单
+	Billing BillingConfig `json:"billing"`
+
+	// 后台任务 worker 数量，为 0 时使用 2
+	JobWorkers int `json:"job_workers"`
+
+	// 录像、课件等文件的对象存储，默认保存在本地磁盘
+	Storage StorageConfig `json:"storage"`
+
+	// 直播封面截图
+	ThumbnailDir      string `json:"thumbnail_dir"`
+	ThumbnailInterval int    `json:"thumbnail_interval"` // 截图间隔（秒），为 0 时使用 60
+
+	// 语音识别服务，用于课后转写录像
+	ASR ASRConfig `json:"asr"`
+
+	// 手机号验证码登录
+	SMS SMSConfig `json:"sms"`
+
+	// 校园统一身份认证
+	OIDC OIDCConfig `json:"oidc"`
+
+	// 嵌入 Moodle、Canvas 等 LMS 的 LTI 1.3 工具
+	LTI LTIConfig `json:"lti"`
+
+	// 课堂积分规则
+	Gamify GamifyConfig `json:"gamify"`
+
+	// 聊天举报与自动禁言
+	Moderation ModerationConfig `json:"moderation"`
+
+	// WebSocket 发送队列、慢速连接处理与连接令牌
+	WS WSConfig `json:"ws"`
+
+	// OpenTelemetry 链路追踪
+	Tracing TracingConfig `json:"tracing"`
+
+	// panic 等错误上报到兼容 Sentry 的服务
+	ErrorReporting ErrorReportingConfig `json:"error_reporting"`
+
+	// 调用外部服务时的代理和自定义根证书
+	Outbound OutboundConfig `json:"outbound"`
+
+	// 流媒体服务器，默认使用 livego_url 指定的 Livego
+	MediaServer MediaServerConfig `json:"media_server"`
+
+	// 功能开关默认值，可被数据库中的全局和机构开关覆盖
+	Features map[string]bool `json:"features"`
+
+	// 请求体默认上限（字节），为 0 时使用 1MB
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+
+	// 由 TimeZone 解析得到，随配置一起替换
+	location *time.Location
 }
 
 // 直播会话
 type LiveSession struct {
 	ID        int               `json:"id"`
 	CourseID  int               `json:"course_id"`
-	StreamKey string            `json:"stream_key"`
+	TeacherID int               `json:"teacher_id"`           // 授课老师，可管理会话；为 0 时只有管理员可以管理
+	StreamKey string            `json:"stream_key,omitempty"` // 只返回给授课老师和管理员
 	Status    string            `json:"status"`
-	StartTime time.Time         `json:"start_time,omitempty"`
-	EndTime   time.Time         `json:"end_time,omitempty"`
+	StartTime *time.Time        `json:"start_time,omitempty"` // 未开始时为 NULL
+	EndTime   *time.Time        `json:"end_time,omitempty"`   // 未结束时为 NULL
 	CreatedAt time.Time         `json:"created_at"`
 	PlayURLs  map[string]string `json:"play_urls,omitempty"`
+	Streams   []LiveStream      `json:"streams,omitempty"` // 直播中的所有画面，含连麦推流端
+
+	ThumbnailURL string `json:"thumbnail_url,omitempty"` // 最近一次截取的封面
+
+	ScheduledAt      *time.Time `json:"scheduled_at,omitempty"` // 计划上课时间，按重复规则批量创建的会话才有
+	ScheduledMinutes int        `json:"scheduled_minutes,omitempty"`
+	SeriesID         int        `json:"series_id,omitempty"`
+	MakeupFor        int        `json:"makeup_for,omitempty"`        // 补课会话对应的原会话
+	MakeupSessionID  int        `json:"makeup_session_id,omitempty"` // 原会话的补课会话
 }
 
 // 题目结构体
 type Question struct {
 	ID       int      `json:"id"`
-	CourseID int      `json:"course_id"`
-	Type     string   `json:"type"` // 题目类型，如选择题、判断题
-	Content  string   `json:"content"`
+	CourseID int      `json:"course_id" binding:"required"`
+	Type     string   `json:"type" binding:"required,question_type"` // 题目类型，如选择题、判断题
+	Content  string   `json:"content" binding:"required"`
 	Options  []string `json:"options,omitempty"` // 选择题选项
-	Answer   string   `json:"answer"`
+	Answer   string   `json:"answer" binding:"required"`
+
+	Difficulty       string   `json:"difficulty,omitempty" binding:"omitempty,oneof=easy medium hard"`
+	Tags             []string `json:"tags,omitempty" binding:"omitempty,max=20,dive,required,max=64"` // 知识点标签
+	EstimatedSeconds int      `json:"estimated_seconds,omitempty" binding:"omitempty,min=0,max=86400"`
+
+	// 多选题的计分方式，见 ScoringAllOrNothing
+	Scoring string `json:"scoring,omitempty" binding:"omitempty,oneof=all_or_nothing partial penalty"`
+
+	// 富文本内容块：图片、公式和听力音频，见 ContentBlock
+	Blocks []ContentBlock `json:"blocks,omitempty" binding:"omitempty,max=20,dive"`
+
+	// 草稿题目可以提前准备和预览，发布后才能推送，见 QuestionDraft
+	Status string `json:"status" binding:"omitempty,oneof=draft published"`
 }
 
-var (
-	db     *sql.DB
-	config Config
-)
+var db *sql.DB
 
 func main() {
+	flag.Parse()
+
 	// 加载配置
 	if err := loadConfig(); err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	go watchConfigReload()
+
+	// 出站请求的代理与证书
+	if err := startOutbound(); err != nil {
+		log.Fatalf("Failed to configure outbound HTTP: %v", err)
+	}
+	go runErrorReporter()
+
+	// 启动链路追踪
+	shutdownTracing, err := startTracing()
+	if err != nil {
+		log.Fatalf("Failed to start tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
 
 	// 连接数据库
-	var err error
+	if err := setDialect(currentConfig().DBDriver); err != nil {
+		log.Fatalf("Failed to configure database: %v", err)
+	}
 	db, err = connectDB()
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
@@ -70,60 +225,396 @@ func main() {
 		log.Fatalf("Failed to ping database: %v", err)
 	}
 
+	// 连接只读副本
+	if err := connectReplica(); err != nil {
+		log.Fatalf("Failed to connect to replica: %v", err)
+	}
+
+	// 创建数据表
+	if err := migrate(db); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+	if err := ensureAdminUser(); err != nil {
+		log.Fatalf("Failed to create admin user: %v", err)
+	}
+	var demoStreamKey string
+	if *demoMode {
+		if demoStreamKey, err = seedDemo(); err != nil {
+			log.Fatalf("Failed to seed demo data: %v", err)
+		}
+	}
+
+	// 加载权限矩阵
+	if err := loadPermissions(); err != nil {
+		log.Fatalf("Failed to load permissions: %v", err)
+	}
+
+	// 连接 MQTT broker（未配置时跳过）
+	if err := startMQTTBridge(); err != nil {
+		log.Fatalf("Failed to connect MQTT broker: %v", err)
+	}
+
+	// 连接 Redis 广播转发（未配置时以单实例运行）
+	if err := startBackplane(); err != nil {
+		log.Fatalf("Failed to connect redis backplane: %v", err)
+	}
+	go runPresenceRefresher()
+
+	// 连接事件总线（未配置时跳过）
+	if err := startEventBus(); err != nil {
+		log.Fatalf("Failed to start event bus: %v", err)
+	}
+
+	// 初始化对象存储
+	if err := startStorage(); err != nil {
+		log.Fatalf("Failed to init storage: %v", err)
+	}
+
+	// 启动内置 RTMP 服务（未启用时跳过）
+	if err := startMediaServer(); err != nil {
+		log.Fatalf("Failed to start embedded RTMP server: %v", err)
+	}
+
+	// 启动白板操作日志写入
+	go runWhiteboardWriter()
+	go runReactionAggregator()
+	go runDeliveryFlusher()
+	go runWaitlistAdmitter()
+	go runTimerSync()
+	go runThumbnailer()
+	go runAudioTranscoder()
+	go runRelayManager()
+	go runStreamWatcher()
+	go reconcileOnStartup()
+	runJobWorkers()
+	scheduleRetention()
+	scheduleRecordingLifecycle()
+	if *demoMode {
+		go runDemoStream(demoStreamKey)
+	}
+
 	// 初始化路由
 	r := initRouter()
 
 	// 启动服务
-	log.Printf("Starting live service on port %d", config.APIPort)
-	if err := r.Run(fmt.Sprintf(":%d", config.APIPort)); err != nil {
+	if err := runServer(r); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
 
-func loadConfig() error {
-	file, err := os.Open("config.json")
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	decoder := json.NewDecoder(file)
-	return decoder.Decode(&config)
+func connectDB() (*sql.DB, error) {
+	conf := currentConfig()
+	return openDB(conf.DBUser, conf.DBPassword, conf.DBHost, conf.DBPort, conf.DBName)
 }
 
-func connectDB() (*sql.DB, error) {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
-		config.DBUser,
-		config.DBPassword,
-		config.DBHost,
-		config.DBPort,
-		config.DBName)
-
-	return sql.Open("mysql", dsn)
+func openDB(user, password, host string, port int, name string) (*sql.DB, error) {
+	return otelsql.Open(dialect.driverName(), dialect.dsn(user, password, host, port, name),
+		otelsql.WithAttributes(attribute.String("db.system", dialect.name())))
 }
 
 func initRouter() *gin.Engine {
-	r := gin.Default()
+	r := gin.New()
+	r.Use(gin.Logger())
+	r.Use(otelgin.Middleware(serviceName()), traceIDHeader(), requestID(), recoverPanics())
+	r.Use(limitRequestBody())
 
 	// 直播会话管理
+	auth := authRequired()
 	liveGroup := r.Group("/api/live")
 	{
-		liveGroup.POST("/sessions", createLiveSession)
-		liveGroup.GET("/sessions/:id", getLiveSession)
-		liveGroup.POST("/sessions/:id/start", startLiveSession)
-		liveGroup.POST("/sessions/:id/end", endLiveSession)
+		liveGroup.POST("/sessions", auth, requirePermission(PermSessionCreate), createLiveSession)
+		liveGroup.POST("/series", auth, requirePermission(PermSessionCreate), createSessionSeries)
+		liveGroup.GET("/series/:series_id", auth, requirePermission(PermSessionManage), getSessionSeries)
+		liveGroup.POST("/series/:series_id/cancel", auth, requirePermission(PermSessionManage), cancelSessionSeries)
+		liveGroup.GET("/schedule/conflicts", auth, requirePermission(PermSessionManage), getScheduleConflicts)
+		liveGroup.GET("/sessions/:id", auth, getLiveSession)
+		liveGroup.POST("/sessions/:id/start", auth, requirePermission(PermSessionManage), requireSessionOwner(), startLiveSession)
+		liveGroup.POST("/sessions/:id/end", auth, requirePermission(PermSessionManage), requireSessionOwner(), endLiveSession)
+		liveGroup.POST("/sessions/:id/cancel", auth, requirePermission(PermSessionManage), requireSessionOwner(), cancelLiveSession)
+		liveGroup.PUT("/sessions/:id/makeup", auth, requirePermission(PermSessionManage), requireSessionOwner(), linkMakeupSession)
+		liveGroup.DELETE("/sessions/:id/makeup", auth, requirePermission(PermSessionManage), requireSessionOwner(), unlinkMakeupSession)
+		liveGroup.POST("/sessions/:id/transfer", auth, requirePermission(PermSessionManage), requireSessionOwner(), transferLiveSession)
+		liveGroup.GET("/sessions/:id/ws", serveWS) // 握手时校验令牌
+		liveGroup.POST("/sessions/:id/ws-token", auth, createWSToken)
+		liveGroup.GET("/sessions/:id/presence", auth, getSessionPresence)
+		liveGroup.GET("/sessions/:id/waitlist", auth, requirePermission(PermSessionManage), requireSessionOwner(), getSessionWaitlist)
+		liveGroup.GET("/sessions/:id/snapshot", auth, getSessionSnapshot)
+		liveGroup.PUT("/sessions/:id/slide", auth, requirePermission(PermSessionManage), requireSessionOwner(), updateSessionSlide)
+		liveGroup.GET("/sessions/:id/announcements", auth, listAnnouncements)
+		liveGroup.POST("/sessions/:id/announcements", auth, requirePermission(PermSessionManage), requireSessionOwner(), createAnnouncement)
+		liveGroup.DELETE("/sessions/:id/announcements/:announcement_id", auth, requirePermission(PermSessionManage), requireSessionOwner(), dismissAnnouncement)
+		liveGroup.GET("/sessions/:id/timers", auth, listTimers)
+		liveGroup.POST("/sessions/:id/timers", auth, requirePermission(PermSessionManage), requireSessionOwner(), startTimer)
+		liveGroup.DELETE("/sessions/:id/timers/:timer_id", auth, requirePermission(PermSessionManage), requireSessionOwner(), cancelTimer)
+		liveGroup.GET("/sessions/:id/settings", auth, getSessionSettings)
+		liveGroup.GET("/sessions/:id/publish-info", auth, requirePermission(PermSessionManage), requireSessionOwner(), getPublishInfo)
+		liveGroup.POST("/sessions/:id/publishers", auth, requirePermission(PermSessionManage), requireSessionOwner(), createPublisher)
+		liveGroup.GET("/sessions/:id/publishers", auth, requirePermission(PermSessionManage), requireSessionOwner(), listPublishers)
+		liveGroup.PATCH("/sessions/:id/publishers/:publisher_id", auth, requirePermission(PermSessionManage), requireSessionOwner(), updatePublisher)
+		liveGroup.DELETE("/sessions/:id/publishers/:publisher_id", auth, requirePermission(PermSessionManage), requireSessionOwner(), deletePublisher)
+		liveGroup.GET("/sessions/:id/relays", auth, requirePermission(PermSessionManage), requireSessionOwner(), listRelays)
+		liveGroup.POST("/sessions/:id/relays", auth, requirePermission(PermSessionManage), requireSessionOwner(), createRelay)
+		liveGroup.PATCH("/sessions/:id/relays/:relay_id", auth, requirePermission(PermSessionManage), requireSessionOwner(), updateRelay)
+		liveGroup.DELETE("/sessions/:id/relays/:relay_id", auth, requirePermission(PermSessionManage), requireSessionOwner(), deleteRelay)
+		liveGroup.POST("/sessions/:id/playback-token", auth, requireAllowedNetwork(NetworkPlayback, sessionCourse), createPlaybackToken)
+		liveGroup.POST("/playback/heartbeat", playbackHeartbeatHandler)
+		liveGroup.GET("/playback/auth", authorizePlayback)
+		liveGroup.GET("/play-regions", auth, listPlayRegions)
+		liveGroup.POST("/playback/qoe", ingestQoEBeacon)
+		liveGroup.GET("/sessions/:id/qoe", auth, requirePermission(PermResultView), getSessionQoE)
+		liveGroup.GET("/sessions/:id/attendance/class-groups", auth, requirePermission(PermResultView), getSessionAttendanceByClassGroup)
+		liveGroup.GET("/sessions/:id/chat/export", auth, requirePermission(PermResultExport), requireSessionOwner(), exportSessionChat)
+		liveGroup.POST("/sessions/:id/reports", auth, reportAbuse)
+		liveGroup.PATCH("/sessions/:id/settings", auth, requirePermission(PermSessionManage), requireSessionOwner(), updateSessionSettings)
+
+		// 分组讨论
+		liveGroup.POST("/sessions/:id/breakouts", auth, requirePermission(PermSessionManage), requireSessionOwner(), createBreakoutGroups)
+		liveGroup.GET("/sessions/:id/breakouts", auth, listBreakoutGroups)
+		liveGroup.POST("/sessions/:id/breakouts/assign", auth, requirePermission(PermSessionManage), requireSessionOwner(), assignBreakoutStudents)
+		liveGroup.POST("/sessions/:id/breakouts/broadcast", auth, requirePermission(PermSessionManage), requireSessionOwner(), broadcastToBreakouts)
+		liveGroup.POST("/sessions/:id/breakouts/close", auth, requirePermission(PermSessionManage), requireSessionOwner(), closeBreakoutGroups)
+
+		// 表情反馈
+		liveGroup.GET("/sessions/:id/reactions", auth, requirePermission(PermResultView), getSessionReactions)
+
+		// 课堂问答
+		liveGroup.GET("/sessions/:id/qa", auth, listSessionQA)
+		liveGroup.POST("/sessions/:id/qa", auth, postSessionQA)
+		liveGroup.POST("/sessions/:id/qa/:qa_id/vote", auth, voteSessionQA)
+		liveGroup.DELETE("/sessions/:id/qa/:qa_id/vote", auth, voteSessionQA)
+		liveGroup.POST("/sessions/:id/qa/:qa_id/answered", auth, requirePermission(PermSessionManage), requireSessionOwner(), answerSessionQA)
+
+		// 字幕
+		liveGroup.POST("/sessions/:id/captions", auth, requirePermission(PermSessionManage), requireSessionOwner(), postSessionCaptions)
+		liveGroup.GET("/sessions/:id/captions", auth, getSessionCaptions)
+
+		// 随机点名
+		liveGroup.POST("/sessions/:id/pick", auth, requirePermission(PermSessionManage), requireSessionOwner(), pickStudents)
+		liveGroup.GET("/sessions/:id/picks", auth, requirePermission(PermSessionManage), requireSessionOwner(), listSessionPicks)
+
+		// 重点标记与书签
+		liveGroup.POST("/sessions/:id/markers", auth, requirePermission(PermSessionManage), requireSessionOwner(), createSessionMarker)
+		liveGroup.POST("/sessions/:id/bookmarks", auth, requirePermission(PermSessionManage), requireSessionOwner(), createSessionBookmark)
+		liveGroup.GET("/sessions/:id/bookmarks", auth, requirePermission(PermSessionManage), requireSessionOwner(), listSessionBookmarks)
+
+		// 白板
+		liveGroup.GET("/sessions/:id/whiteboard/ws", serveWhiteboardWS) // 握手时校验令牌
+		liveGroup.GET("/sessions/:id/whiteboard", auth, getWhiteboardSnapshot)
+		liveGroup.GET("/sessions/:id/whiteboard/ops", auth, getWhiteboardOps)
+	}
+
+	// 登录
+	r.POST("/api/auth/login", login)
+	r.POST("/api/auth/refresh", refreshAccessToken)
+	r.POST("/api/auth/sms/send", sendSMSCode)
+	r.POST("/api/auth/sms/verify", verifySMSCode)
+	r.GET("/api/auth/oidc/login", oidcLogin)
+	r.GET("/api/auth/oidc/callback", oidcCallback)
+	r.POST("/api/auth/oidc/link", oidcLinkAccount)
+
+	// LTI 1.3
+	r.GET("/api/lti/login", ltiLogin)
+	r.POST("/api/lti/login", ltiLogin)
+	r.POST("/api/lti/launch", ltiLaunch)
+	r.GET("/api/lti/jwks", ltiJWKS)
+
+	// 删除个人数据
+	r.DELETE("/api/users/:id/data", authRequired(), deleteUserData)
+	r.GET("/api/features", auth, getFeatures)
+	r.GET("/api/holidays", auth, listHolidays)
+	r.GET("/api/notifications", auth, listNotifications)
+	r.POST("/api/notifications/:id/read", auth, readNotification)
+
+	// 登录设备
+	r.GET("/api/users/me/sessions", auth, listLoginSessions)
+	r.POST("/api/users/me/sessions/revoke-others", auth, revokeOtherLoginSessions)
+	r.DELETE("/api/users/me/sessions/:id", auth, revokeLoginSession)
+
+	// 管理后台
+	adminGroup := r.Group("/api/admin", authRequired(), requireRole(RoleAdmin))
+	{
+		adminGroup.GET("/sessions", adminListSessions)
+		adminGroup.POST("/sessions/:id/force-end", adminForceEndSession)
+		adminGroup.GET("/stats", adminGetStats)
+		adminGroup.GET("/diagnostics", adminGetDiagnostics)
+		adminGroup.GET("/streams", adminListStreams)
+		adminGroup.POST("/streams/reconcile", adminReconcileStreams)
+		adminGroup.Any("/debug/pprof/*name", servePprof)
+		adminGroup.GET("/users", adminListUsers)
+		adminGroup.POST("/users", adminCreateUser)
+		adminGroup.PATCH("/users/:id", adminUpdateUser)
+		adminGroup.DELETE("/users/:id/sessions", adminRevokeLoginSessions)
+		adminGroup.GET("/api-keys", adminListAPIKeys)
+		adminGroup.POST("/api-keys", adminCreateAPIKey)
+		adminGroup.PATCH("/api-keys/:id", adminUpdateAPIKey)
+		adminGroup.POST("/api-keys/:id/rotate", adminRotateAPIKey)
+		adminGroup.DELETE("/api-keys/:id", adminRevokeAPIKey)
+		adminGroup.POST("/users/provision", adminProvisionUsers)
+		adminGroup.POST("/users/import", adminImportUsersCSV)
+		adminGroup.POST("/impersonate", adminImpersonate)
+		adminGroup.GET("/audit-logs", listAuditLogs)
+		adminGroup.GET("/permissions", getPermissionMatrix)
+		adminGroup.PUT("/permissions/:role", updateRolePermissions)
+		adminGroup.GET("/jobs", adminListJobs)
+		adminGroup.POST("/jobs/:id/retry", adminRetryJob)
+		adminGroup.GET("/features", adminListFeatures)
+		adminGroup.PUT("/features/:name", adminSetFeature)
+		adminGroup.DELETE("/features/:name", adminDeleteFeature)
+		adminGroup.GET("/network-policies", adminListNetworkPolicies)
+		adminGroup.PUT("/network-policies", adminSetNetworkPolicy)
+		adminGroup.DELETE("/network-policies", adminDeleteNetworkPolicy)
+		adminGroup.GET("/recording-policies", adminListRecordingPolicies)
+		adminGroup.PUT("/recording-policies", adminSetRecordingPolicy)
+		adminGroup.DELETE("/recording-policies", adminDeleteRecordingPolicy)
+		adminGroup.GET("/recording-usage", adminRecordingUsage)
+		adminGroup.PUT("/holidays", adminSetHolidays)
+		adminGroup.DELETE("/holidays", adminDeleteHoliday)
+		adminGroup.POST("/playback/logs", adminIngestPlaybackLogs)
+		adminGroup.GET("/orders", adminListOrders)
+		adminGroup.GET("/lti/contexts", adminListLTIContexts)
+		adminGroup.PUT("/lti/contexts", adminSetLTIContext)
+		adminGroup.POST("/class-groups", createClassGroup)
+		adminGroup.GET("/class-groups", listClassGroups)
+		adminGroup.GET("/class-groups/:id", getClassGroup)
+		adminGroup.DELETE("/class-groups/:id", deleteClassGroup)
+		adminGroup.POST("/class-groups/:id/members", setClassGroupMembers)
+		adminGroup.DELETE("/class-groups/:id/members/:student_id", removeClassGroupMember)
+		adminGroup.POST("/class-groups/:id/enroll", enrollClassGroup)
+		adminGroup.GET("/reports", adminListReports)
+		adminGroup.POST("/reports/:id/review", adminReviewReport)
+	}
+
+	// Socket.IO 兼容接入
+	r.GET("/socket.io/", serveSocketIO)
+
+	// 封面截图
+	r.Static(thumbnailURLPrefix, thumbnailDir())
+
+	// 本地对象存储的签名下载地址
+	r.GET(localFileURLPrefix+"/*key", serveLocalFile)
+
+	// 教师控制台
+	registerConsole(r)
+
+	// 录像
+	recordingGroup := r.Group("/api/recordings", auth)
+	{
+		recordingGroup.GET("/:id", getRecordingDetail)
+		recordingGroup.GET("/:id/jobs", listRecordingJobs)
+		recordingGroup.POST("/:id/jobs", requirePermission(PermSessionManage), createRecordingJobHandler)
+		recordingGroup.GET("/:id/thumbnails", getRecordingThumbnails)
+		recordingGroup.GET("/:id/transcript", getRecordingTranscript)
+		recordingGroup.GET("/:id/timeline", getRecordingTimeline)
+		recordingGroup.POST("/:id/checkpoints", requirePermission(PermQuestionCreate), createRecordingCheckpoint)
+		recordingGroup.POST("/:id/clips", requirePermission(PermSessionManage), createClip)
+	}
+
+	// 录像片段，分享链接无需登录
+	r.GET("/api/clips/shared/:token", openSharedClip)
+	clipGroup := r.Group("/api/clips", auth)
+	{
+		clipGroup.GET("/:id", getClip)
+		clipGroup.DELETE("/:id", requirePermission(PermSessionManage), deleteClip)
+		clipGroup.POST("/:id/share", requirePermission(PermSessionManage), shareClip)
+	}
+
+	// 视频打点题
+	checkpointGroup := r.Group("/api/checkpoints", auth)
+	{
+		checkpointGroup.DELETE("/:id", requirePermission(PermQuestionCreate), deleteCheckpoint)
+		checkpointGroup.POST("/:id/answer", answerCheckpoint)
+	}
+
+	// 课堂测验
+	examGroup := r.Group("/api/exams", auth, requireFeature(FeatureExams))
+	{
+		examGroup.POST("", requirePermission(PermQuestionCreate), createExam)
+		examGroup.GET("/:id", getExam)
+		examGroup.POST("/:id/start", requirePermission(PermQuestionPush), startExam)
+		examGroup.POST("/:id/close", requirePermission(PermQuestionPush), closeExamHandler)
+		examGroup.PUT("/:id/answers", requireAllowedNetwork(NetworkAnswers, examCourse), saveExamAnswer)
+		examGroup.POST("/:id/submit", requireAllowedNetwork(NetworkAnswers, examCourse), submitExam)
+		examGroup.GET("/:id/results", requirePermission(PermResultView), getExamResults)
+		examGroup.POST("/:id/makeup", requirePermission(PermQuestionPush), grantExamMakeup)
+		examGroup.DELETE("/:id/attempts/:student_id/device", requirePermission(PermQuestionPush), releaseExamDevice)
+	}
+
+	// 课程数据归档
+	courseGroup := r.Group("/api/courses/:course_id", auth, requirePermission(PermResultExport))
+	{
+		courseGroup.POST("/exports", createCourseExport)
+		courseGroup.GET("/exports/:export_id", getCourseExport)
+	}
+
+	// 课程目录与录播课
+	syllabusGroup := r.Group("/api/courses/:course_id", auth)
+	{
+		syllabusGroup.GET("/syllabus", getSyllabus)
+		syllabusGroup.GET("/recording-usage", requirePermission(PermSessionManage), getCourseRecordingUsage)
+		syllabusGroup.POST("/chapters", requirePermission(PermSessionManage), createChapter)
+		syllabusGroup.PATCH("/chapters/:chapter_id", requirePermission(PermSessionManage), updateChapter)
+		syllabusGroup.DELETE("/chapters/:chapter_id", requirePermission(PermSessionManage), deleteChapter)
+		syllabusGroup.POST("/lessons", requirePermission(PermSessionManage), createLesson)
+		syllabusGroup.GET("/lessons/:lesson_id", getLesson)
+		syllabusGroup.PATCH("/lessons/:lesson_id", requirePermission(PermSessionManage), updateLesson)
+		syllabusGroup.DELETE("/lessons/:lesson_id", requirePermission(PermSessionManage), deleteLesson)
+		syllabusGroup.PUT("/lessons/:lesson_id/video", requirePermission(PermSessionManage), uploadLessonVideo)
+		syllabusGroup.POST("/lessons/:lesson_id/progress", updateLessonProgress)
+		syllabusGroup.POST("/lessons/:lesson_id/checkpoints", requirePermission(PermQuestionCreate), createLessonCheckpoint)
+		syllabusGroup.GET("/clips", listCourseClips)
+		syllabusGroup.GET("/progress", getCourseProgress)
+		syllabusGroup.GET("/progress/matrix", requirePermission(PermResultView), getCourseProgressMatrix)
+	}
+
+	// 积分与徽章
+	gamifyGroup := r.Group("/api/gamify", auth, requireFeature(FeatureGamification))
+	{
+		gamifyGroup.GET("/badges", listBadges)
+		gamifyGroup.GET("/leaderboard/:course_id", getGamifyLeaderboard)
+		gamifyGroup.GET("/students/:student_id", getStudentScore)
+	}
+
+	// 付费课程
+	billingGroup := r.Group("/api/billing")
+	{
+		billingGroup.PUT("/courses/:course_id/price", auth, requireRole(RoleAdmin), setCoursePrice)
+		billingGroup.GET("/courses/:course_id/price", auth, getCoursePrice)
+		billingGroup.POST("/orders", auth, createOrder)
+		billingGroup.GET("/orders", auth, listMyOrders)
+		billingGroup.GET("/orders/:order_no", auth, getOrder)
+		billingGroup.POST("/access-codes", auth, requireRole(RoleAdmin), createAccessCodes)
+		billingGroup.GET("/access-codes", auth, requireRole(RoleAdmin), listAccessCodes)
+		billingGroup.POST("/redeem", auth, redeemAccessCode)
+		billingGroup.POST("/notify/wechat", wechatPayNotify)
+		billingGroup.POST("/notify/alipay", alipayNotify)
 	}
 
 	// 直播状态回调
 	r.POST("/api/live/status", handleLiveStatusCallback)
+	r.GET("/live/:file", serveEmbeddedFLV)
 
 	// 在线答题管理
 	questionGroup := r.Group("/api/question")
 	{
-		questionGroup.POST("/create", createQuestion)
-		questionGroup.GET("/push/:course_id/:question_id", pushQuestion)
-		questionGroup.POST("/submit", submitAnswer)
-		questionGroup.GET("/result/:question_id", getResult)
+		questionGroup.POST("/create", auth, requirePermission(PermQuestionCreate), createQuestion)
+		questionGroup.POST("/media", auth, requirePermission(PermQuestionCreate), uploadQuestionMedia)
+		questionGroup.GET("/list", auth, requirePermission(PermQuestionCreate), listQuestions)
+		questionGroup.GET("/analytics/:course_id", auth, requirePermission(PermResultView), getKnowledgePointStats)
+		questionGroup.GET("/preview/:question_id", auth, requirePermission(PermQuestionCreate), previewQuestion)
+		questionGroup.POST("/publish/:question_id", auth, requirePermission(PermQuestionCreate), publishQuestion)
+		questionGroup.GET("/push/:course_id/:question_id", auth, requirePermission(PermQuestionPush), pushQuestion)
+		questionGroup.GET("/push/:course_id/:question_id/preview", auth, requirePermission(PermQuestionPush), pushQuestionPreview)
+		questionGroup.POST("/close/:question_id", auth, requirePermission(PermQuestionPush), closeQuestion)
+		questionGroup.POST("/makeup/:question_id", auth, requirePermission(PermQuestionPush), grantQuestionMakeup)
+		questionGroup.GET("/rules/:course_id", auth, requirePermission(PermQuestionPush), listQuizRules)
+		questionGroup.POST("/rules/:course_id", auth, requirePermission(PermQuestionPush), createQuizRule)
+		questionGroup.DELETE("/rules/:course_id/:rule_id", auth, requirePermission(PermQuestionPush), deleteQuizRule)
+		questionGroup.POST("/submit", auth, submitAnswer)
+		questionGroup.POST("/submit/batch", auth, submitAnswerBatch)
+		questionGroup.GET("/answer/:question_id", auth, getSavedAnswer)
+		questionGroup.GET("/result/:question_id", auth, requirePermission(PermResultView), getResult)
+		questionGroup.GET("/leaderboard/:question_id", auth, requirePermission(PermResultView), getQuestionLeaderboard)
+		questionGroup.GET("/delivery/:question_id", auth, requirePermission(PermResultView), getDeliveryReport)
 	}
 
 	return r
@@ -136,48 +627,47 @@ func createLiveSession(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&session); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondBindError(c, err)
 		return
 	}
 
 	// 生成唯一的streamKey
 	streamKey := generateStreamKey()
 
-	// 在数据库中创建直播会话
-	result, err := db.Exec(`
-		INSERT INTO live_sessions (course_id, stream_key, status, created_at)
-		VALUES (?, ?, 'pending', NOW())
-	`, session.CourseID, streamKey)
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create live session"})
-		return
-	}
+	// 在数据库中创建直播会话，创建者为授课老师
+	teacherID := currentUser(c).ID
+	id, err := dialect.insertID(db, `
+		INSERT INTO live_sessions (course_id, teacher_id, stream_key, status, created_at)
+		VALUES (?, ?, ?, 'pending', NOW())
+	`, session.CourseID, teacherID, streamKey)
 
-	// 获取新创建的会话ID
-	id, err := result.LastInsertId()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session ID"})
+		respondError(c, http.StatusInternalServerError, CodeSessionCreateFailed)
 		return
 	}
 
-	// 在Livego中创建流
-	if err := createStreamInLivego(streamKey); err != nil {
+	// 在流媒体服务器中登记推流码
+	if err := media().CreateStream(c.Request.Context(), streamKey); err != nil {
 		// 回滚数据库操作
 		db.Exec("DELETE FROM live_sessions WHERE id = ?", id)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create stream in Livego"})
+		respondError(c, http.StatusInternalServerError, CodeStreamCreateFailed)
 		return
 	}
 
-	// 返回直播会话信息
-	c.JSON(http.StatusCreated, LiveSession{
+	created := LiveSession{
 		ID:        int(id),
 		CourseID:  session.CourseID,
+		TeacherID: teacherID,
 		StreamKey: streamKey,
 		Status:    "pending",
-		CreatedAt: time.Now(),
-		PlayURLs:  getPlayURLs(streamKey),
-	})
+		CreatedAt: time.Now().UTC(),
+		PlayURLs:  getPlayURLs(streamKey, playRegion(c)),
+	}
+	created.inLocation(requestLocation(c))
+	emitEvent(EventSessionCreated, session.CourseID, gin.H{"session_id": created.ID, "course_id": created.CourseID})
+
+	// 返回直播会话信息
+	respondOK(c, http.StatusCreated, created)
 }
 
 // 生成唯一的streamKey
@@ -186,39 +676,22 @@ func generateStreamKey() string {
 	return fmt.Sprintf("live_%d_%s", time.Now().Unix(), generateRandomString(10))
 }
 
-// 生成随机字符串
+// 生成随机字符串。批量创建会话时同一秒内会生成多个推流码，不能按当前时间取字符
 func generateRandomString(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	result := make([]byte, length)
-	for i := range result {
-		result[i] = charset[time.Now().UnixNano()%int64(len(charset))]
-	}
-	return string(result)
-}
-
-// 在Livego中创建流
-func createStreamInLivego(streamKey string) error {
-	url := fmt.Sprintf("%s/api/stream/add?stream=%s", config.LivegoURL, streamKey)
-	resp, err := http.Post(url, "application/json", nil)
-	if err != nil {
-		return err
+	if _, err := rand.Read(result); err != nil {
+		panic(err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to create stream in Livego: %s", resp.Status)
+	for i, b := range result {
+		result[i] = charset[int(b)%len(charset)]
 	}
-
-	return nil
+	return string(result)
 }
 
 // 获取播放URLs
-func getPlayURLs(streamKey string) map[string]string {
-	return map[string]string{
-		"rtmp": fmt.Sprintf("rtmp://%s/live/%s", config.LivegoURL, streamKey),
-		"flv":  fmt.Sprintf("http://%s:7001/live/%s.flv", config.LivegoURL, streamKey),
-		"hls":  fmt.Sprintf("http://%s:7002/live/%s.m3u8", config.LivegoURL, streamKey),
-	}
+func getPlayURLs(streamKey, region string) map[string]string {
+	return regionalPlayURLs(media().PlayURLs(streamKey), streamKey, region)
 }
 
 // 获取直播会话
@@ -227,175 +700,293 @@ func getLiveSession(c *gin.Context) {
 
 	var session LiveSession
 	err := db.QueryRow(`
-		SELECT id, course_id, stream_key, status, start_time, end_time, created_at
+		SELECT id, course_id, teacher_id, stream_key, status, start_time, end_time, created_at, thumbnail, scheduled_at, scheduled_minutes, series_id,
+			makeup_for, COALESCE((SELECT MIN(m.id) FROM live_sessions m WHERE m.makeup_for = live_sessions.id), 0)
 		FROM live_sessions
 		WHERE id = ?
 	`, id).Scan(
 		&session.ID,
 		&session.CourseID,
+		&session.TeacherID,
 		&session.StreamKey,
 		&session.Status,
 		&session.StartTime,
 		&session.EndTime,
 		&session.CreatedAt,
+		&session.ThumbnailURL,
+		&session.ScheduledAt,
+		&session.ScheduledMinutes,
+		&session.SeriesID,
+		&session.MakeupFor,
+		&session.MakeupSessionID,
 	)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Live session not found"})
+			respondError(c, http.StatusNotFound, CodeSessionNotFound)
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get live session"})
+			respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
 		}
 		return
 	}
 
+	session.inLocation(requestLocation(c))
+
+	// 推流码和不带令牌的播放地址只返回给授课老师和管理员，其他人通过播放令牌接口获取播放地址
+	user := currentUser(c)
+	owner := user.Role == RoleAdmin || (session.TeacherID != 0 && session.TeacherID == user.ID)
+	if !owner {
+		session.StreamKey = ""
+	}
+
 	// 添加播放URLs
-	if session.Status == "live" {
-		session.PlayURLs = getPlayURLs(session.StreamKey)
+	if owner && session.Status == "live" {
+		region := playRegion(c)
+		session.PlayURLs = getPlayURLs(session.StreamKey, region)
+		if settings, err := loadSessionSettings(session.ID); err == nil {
+			session.PlayURLs = withAudioVariant(filterPlayURLs(session.PlayURLs, settings), session.StreamKey, region, settings, false)
+			session.Streams, _ = liveStreams(session.ID, session.StreamKey, session.Status, region, settings)
+		}
 	}
 
-	c.JSON(http.StatusOK, session)
+	respondOK(c, http.StatusOK, session)
 }
 
 // 开始直播会话
 func startLiveSession(c *gin.Context) {
-	id := c.Param("id")
-
-	// 更新数据库状态
-	result, err := db.Exec(`
-		UPDATE live_sessions
-		SET status = 'live', start_time = NOW()
-		WHERE id = ? AND status = 'pending'
-	`, id)
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start live session"})
+	id, ok := intParam(c, "id")
+	if !ok {
 		return
 	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check rows affected"})
+	if err := transitionSession(id, transitionStart); err != nil {
+		respondServiceError(c, err)
 		return
 	}
 
-	if rowsAffected == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Live session not found or already started"})
-		return
-	}
+	notifySessionStatus(id, "live")
 
-	c.JSON(http.StatusOK, gin.H{"message": "Live session started successfully"})
+	respondOK(c, http.StatusOK, gin.H{"message": "Live session started successfully"})
 }
 
 // 结束直播会话
 func endLiveSession(c *gin.Context) {
-	id := c.Param("id")
-
-	// 更新数据库状态
-	result, err := db.Exec(`
-		UPDATE live_sessions
-		SET status = 'ended', end_time = NOW()
-		WHERE id = ? AND status = 'live'
-	`, id)
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end live session"})
+	id, ok := intParam(c, "id")
+	if !ok {
 		return
 	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check rows affected"})
+	if err := transitionSession(id, transitionEnd); err != nil {
+		respondServiceError(c, err)
 		return
 	}
 
-	if rowsAffected == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Live session not found or already ended"})
-		return
+	// 关闭仍在进行的分组讨论
+	if _, err := closeSessionBreakouts(id); err != nil {
+		log.Printf("Failed to close breakout groups for session %d: %v", id, err)
 	}
+	notifySessionStatus(id, "ended")
 
-	c.JSON(http.StatusOK, gin.H{"message": "Live session ended successfully"})
+	respondOK(c, http.StatusOK, gin.H{"message": "Live session ended successfully"})
 }
 
-// 处理Livego状态回调
+// 处理流媒体服务器的推流状态回调
 func handleLiveStatusCallback(c *gin.Context) {
-	var callback struct {
-		StreamPath string `json:"streamPath"`
-		Status     string `json:"status"`
-	}
-
-	if err := c.ShouldBindJSON(&callback); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	srv := media()
+	event, err := srv.ParseCallback(c)
+	if err == errInvalidStreamPath {
+		srv.RespondCallback(c, http.StatusBadRequest, CodeInvalidStreamPath)
 		return
 	}
-
-	// 从streamPath中提取streamKey
-	// 格式通常为 /live/stream_key
-	parts := strings.Split(callback.StreamPath, "/")
-	if len(parts) < 3 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid stream path"})
+	if err != nil {
+		respondBindError(c, err)
 		return
 	}
+	status, code := applyStreamEvent(event)
+	srv.RespondCallback(c, status, code)
+}
 
-	streamKey := parts[2]
+// 按推流开始或结束更新会话状态，返回响应的状态码和错误码，错误码为空表示接受
+func applyStreamEvent(event StreamEvent) (int, ErrorCode) {
+	streamKey := event.StreamKey
+	if event.Status == "start" && !currentConfig().AllowBareStreamKey && !verifyPublishToken(streamKey, event.Query) {
+		return http.StatusForbidden, CodePublishTokenInvalid
+	}
+	// 连麦推流端只更新自身状态，不影响会话
+	if handled, status, code := publisherCallback(streamKey, event.Status); handled {
+		return status, code
+	}
 
-	// 更新直播会话状态
-	if callback.Status == "start" {
-		db.Exec(`
+	var query, status string
+	switch event.Status {
+	case "start":
+		status = "live"
+		// 中断的会话重新推流时恢复，保留原开始时间
+		query = `
 			UPDATE live_sessions
-			SET status = 'live', start_time = NOW()
-			WHERE stream_key = ? AND status = 'pending'
-		`, streamKey)
-	} else if callback.Status == "stop" {
-		db.Exec(`
+			SET status = 'live', start_time = COALESCE(start_time, NOW()), stream_misses = 0
+			WHERE id = ? AND status IN ('pending', 'interrupted')
+		`
+	case "stop":
+		status = "ended"
+		query = `
 			UPDATE live_sessions
 			SET status = 'ended', end_time = NOW()
-			WHERE stream_key = ? AND status = 'live'
-		`, streamKey)
+			WHERE id = ? AND status IN ('live', 'interrupted')
+		`
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Callback received"})
+	var sessionID int
+	var sessionStatus string
+	err := db.QueryRow("SELECT id, status FROM live_sessions WHERE stream_key = ?", streamKey).Scan(&sessionID, &sessionStatus)
+	// 课程结束后推流码和令牌都不能再使用
+	if err == nil && event.Status == "start" && sessionStatus == "ended" {
+		return http.StatusConflict, CodeSessionAlreadyEnded
+	}
+	if query != "" && err == nil {
+		// 多个副本可能同时收到同一回调，加锁保证状态变更只执行一次
+		changed := false
+		err = withLock(sessionLockName(sessionID), func() error {
+			result, err := db.Exec(query, sessionID)
+			if err != nil {
+				return err
+			}
+			n, err := result.RowsAffected()
+			changed = n > 0
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to update session %d from callback: %v", sessionID, err)
+			return http.StatusInternalServerError, CodeInternal
+		}
+		if changed && status == "live" && sessionStatus == "interrupted" {
+			status = "resumed"
+		}
+		if changed {
+			notifySessionStatus(sessionID, status)
+		}
+	}
+	return http.StatusOK, ""
 }
 
 // 创建题目
 func createQuestion(c *gin.Context) {
 	var question Question
 	if err := c.ShouldBindJSON(&question); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondBindError(c, err)
 		return
 	}
 
-	// 在数据库中创建题目
-	result, err := db.Exec(`
-		INSERT INTO questions (course_id, type, content, options, answer)
-		VALUES (?, ?, ?, ?, ?)
-	`, question.CourseID, question.Type, question.Content, strings.Join(question.Options, ","), question.Answer)
+	if question.Status == "" {
+		question.Status = QuestionPublished
+	}
+	if question.Scoring == "" {
+		question.Scoring = ScoringAllOrNothing
+	}
+	question.Answer = normalizeAnswer(question.Type, question.Answer)
+	if !checkQuestionMedia(c, question.Blocks) {
+		return
+	}
+	richContent, err := encodeQuestionBlocks(question.Blocks)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQuestionCreateFailed)
+		return
+	}
 
+	tx, err := db.Begin()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create question"})
+		respondError(c, http.StatusInternalServerError, CodeQuestionCreateFailed)
 		return
 	}
+	defer tx.Rollback()
+
+	// 在数据库中创建题目
+	id, err := dialect.insertID(tx, `
+		INSERT INTO questions (course_id, type, content, options, answer, difficulty, estimated_seconds, status, rich_content, scoring)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, question.CourseID, question.Type, question.Content, strings.Join(question.Options, ","), question.Answer,
+		question.Difficulty, question.EstimatedSeconds, question.Status, richContent, question.Scoring)
 
-	// 获取新创建的题目 ID
-	id, err := result.LastInsertId()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get question ID"})
+		respondError(c, http.StatusInternalServerError, CodeQuestionCreateFailed)
 		return
 	}
 
 	question.ID = int(id)
-	c.JSON(http.StatusCreated, question)
+
+	question.Tags = normalizeTags(question.Tags)
+	if err := saveQuestionTags(tx, question.ID, question.Tags); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQuestionCreateFailed)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQuestionCreateFailed)
+		return
+	}
+
+	for i := range question.Blocks {
+		question.Blocks[i].URL = storageURL(c.Request.Context(), question.Blocks[i].MediaKey)
+	}
+	respondOK(c, http.StatusCreated, question)
 }
 
 // 推送题目
 func pushQuestion(c *gin.Context) {
+	question, fastestN, duration, ok := pushParams(c)
+	if !ok {
+		return
+	}
+	if question.Status == QuestionDraft {
+		respondError(c, http.StatusConflict, CodeQuestionDraft)
+		return
+	}
+	ctx := c.Request.Context()
+
+	// 记录推送时间，用于计算作答耗时
+	push, err := createQuestionPush(question.ID, question.CourseID, fastestN, duration)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	scheduleQuestionClose(push)
+	scheduleQuizRules(push)
+
+	// 推送题目到课程房间内的学生端，不包含答案
+	data := pushedQuestion(question, push)
+	_, span := tracer.Start(ctx, "hub.broadcast question")
+	hub.broadcast(courseRoom(question.CourseID), Message{Type: "question", Data: data, Delivery: pushDelivery(push.ID)})
+	publishMQTT(question.CourseID, "question", data)
+	span.End()
+	emitEvent(EventQuestionPushed, question.CourseID, gin.H{
+		"question_id": question.ID,
+		"course_id":   question.CourseID,
+		"type":        question.Type,
+		"difficulty":  question.Difficulty,
+		"tags":        question.Tags,
+		"push_id":     push.ID,
+	})
+
+	respondOK(c, http.StatusOK, question)
+}
+
+// 读取推送的题目和参数，失败时写入错误响应
+func pushParams(c *gin.Context) (Question, int, time.Duration, bool) {
 	courseID := c.Param("course_id")
 	questionID := c.Param("question_id")
+	fastestN, ok := fastestParam(c)
+	if !ok {
+		return Question{}, 0, 0, false
+	}
+	duration, ok := durationParam(c)
+	if !ok {
+		return Question{}, 0, 0, false
+	}
 
 	// 获取题目信息
+	ctx := c.Request.Context()
 	var question Question
-	err := db.QueryRow(`
-		SELECT id, course_id, type, content, options, answer
+	var options, richContent string
+	err := db.QueryRowContext(ctx, `
+		SELECT id, course_id, type, content, options, answer, difficulty, estimated_seconds, status,
+			COALESCE(rich_content, ''), scoring
 		FROM questions
 		WHERE id = ? AND course_id = ?
 	`, questionID, courseID).Scan(
@@ -403,49 +994,111 @@ func pushQuestion(c *gin.Context) {
 		&question.CourseID,
 		&question.Type,
 		&question.Content,
-		&question.Options,
+		&options,
 		&question.Answer,
+		&question.Difficulty,
+		&question.EstimatedSeconds,
+		&question.Status,
+		&richContent,
+		&question.Scoring,
 	)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+			respondError(c, http.StatusNotFound, CodeQuestionNotFound)
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get question"})
+			respondError(c, http.StatusInternalServerError, CodeQuestionGetFailed)
 		}
+		return question, 0, 0, false
+	}
+
+	question.Options = splitList(options)
+	question.Blocks = decodeQuestionBlocks(ctx, richContent)
+	if tags, err := loadQuestionTags([]int{question.ID}); err == nil {
+		question.Tags = tags[question.ID]
+	}
+	return question, fastestN, duration, true
+}
+
+// 预览推送，只发给课程中老师自己和助教的连接，用于推送前确认题目的显示效果和选项；
+// 与正式推送使用相同的参数和消息内容，但不记录推送，草稿题目也可以预览
+func pushQuestionPreview(c *gin.Context) {
+	question, fastestN, duration, ok := pushParams(c)
+	if !ok {
 		return
 	}
 
-	// 推送题目到学生端（使用 WebSocket 或其他实时通信技术）
-	// 这里只是简单返回题目信息
-	c.JSON(http.StatusOK, question)
+	push := QuestionPush{QuestionID: question.ID, CourseID: question.CourseID, FastestN: fastestN, PushedAt: time.Now().UTC()}
+	if duration > 0 {
+		closesAt := push.PushedAt.Add(duration)
+		push.ClosesAt = &closesAt
+	}
+	data := pushedQuestion(question, push)
+	// 预览没有推送记录，客户端不需要回执
+	delete(data, "push_id")
+	data["preview"] = true
+	data["status"] = question.Status
+	hub.broadcast(staffRoom(question.CourseID), Message{Type: "question_preview", Data: data})
+
+	respondOK(c, http.StatusOK, data)
+}
+
+// 推送给学生的题目，去掉答案
+func studentQuestion(q Question) gin.H {
+	return gin.H{
+		"id":        q.ID,
+		"course_id": q.CourseID,
+		"type":      q.Type,
+		"content":   q.Content,
+		"options":   q.Options,
+		"blocks":    q.Blocks,
+
+		"estimated_seconds": q.EstimatedSeconds,
+	}
+}
+
+// 推送给学生的题目，附带推送ID和截止时间，学生端收到后以 question_ack 回执
+func pushedQuestion(q Question, push QuestionPush) gin.H {
+	data := studentQuestion(q)
+	data["push_id"] = push.ID
+	data["fastest_n"] = push.FastestN
+	if push.ClosesAt != nil {
+		data["closes_at"] = push.ClosesAt
+	}
+	return data
 }
 
 // 提交答案
 func submitAnswer(c *gin.Context) {
 	var answer struct {
 		QuestionID int    `json:"question_id" binding:"required"`
-		StudentID  int    `json:"student_id" binding:"required"`
 		Answer     string `json:"answer" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&answer); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondBindError(c, err)
 		return
 	}
 
-	// 在数据库中存储答案
-	_, err := db.Exec(`
-		INSERT INTO answers (question_id, student_id, answer)
-		VALUES (?, ?, ?)
-	`, answer.QuestionID, answer.StudentID, answer.Answer)
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit answer"})
+	// 只能以登录用户的身份作答，以服务端时间计算相对最近一次推送的作答耗时
+	now := time.Now().UTC()
+	result, status, code := recordAnswer(c.Request.Context(), currentUser(c), c.ClientIP(), answer.QuestionID, answer.Answer, now, now, false)
+	if code != "" {
+		respondError(c, status, code)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Answer submitted successfully"})
+	response := gin.H{"message": "Answer submitted successfully", "changed": result.Changed}
+	if result.LatencyMs != nil {
+		response["latency_ms"] = *result.LatencyMs
+	}
+	if result.Rank > 0 {
+		response["rank"] = result.Rank
+	}
+	if result.Makeup {
+		response["makeup"] = true
+	}
+	respondOK(c, http.StatusOK, response)
 }
 
 // 统计结果
@@ -462,31 +1115,89 @@ func getResult(c *gin.Context) {
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+			respondError(c, http.StatusNotFound, CodeQuestionNotFound)
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get correct answer"})
+			respondError(c, http.StatusInternalServerError, CodeQuestionGetFailed)
 		}
 		return
 	}
 
-	// 统计答案
-	var totalCount int
-	var correctCount int
+	// 统计答案，测验补答单独计数；平均得分包含多选题的部分得分，早期没有记录得分的答案按是否答对计算
+	var totalCount, correctCount, makeupCount, makeupCorrectCount int
+	var averageCredit sql.NullFloat64
 	err = db.QueryRow(`
-		SELECT COUNT(*), SUM(CASE WHEN answer = ? THEN 1 ELSE 0 END)
+		SELECT
+			COUNT(CASE WHEN NOT makeup THEN 1 END), COUNT(CASE WHEN NOT makeup AND answer = ? THEN 1 END),
+			COUNT(CASE WHEN makeup THEN 1 END), COUNT(CASE WHEN makeup AND answer = ? THEN 1 END),
+			AVG(CASE WHEN NOT makeup THEN COALESCE(credit, CASE WHEN answer = ? THEN 1 ELSE 0 END) END)
 		FROM answers
 		WHERE question_id = ?
-	`, correctAnswer, questionID).Scan(&totalCount, &correctCount)
+	`, correctAnswer, correctAnswer, correctAnswer, questionID).Scan(&totalCount, &correctCount, &makeupCount, &makeupCorrectCount, &averageCredit)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get result"})
+		respondError(c, http.StatusInternalServerError, CodeResultGetFailed)
 		return
 	}
 
-	result := map[string]int{
-		"total_count":   totalCount,
-		"correct_count": correctCount,
+	result := gin.H{
+		"total_count":          totalCount,
+		"correct_count":        correctCount,
+		"makeup_count":         makeupCount,
+		"makeup_correct_count": makeupCorrectCount,
+		"average_credit":       roundCredit(averageCredit.Float64),
 	}
+	// group_by=class_group 时附带按班级的作答统计
+	if c.Query("group_by") == "class_group" {
+		byClassGroup, err := questionStatsByClassGroup(questionID, correctAnswer)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeResultGetFailed)
+			return
+		}
+		result["class_groups"] = byClassGroup
+	}
+
+	respondOK(c, http.StatusOK, result)
+}
+
+// 解析整数路径参数，失败时写入错误响应
+func intParam(c *gin.Context, name string) (int, bool) {
+	value, err := strconv.Atoi(c.Param(name))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, name)
+		return 0, false
+	}
+	return value, true
+}
 
-	c.JSON(http.StatusOK, result)
+// 通知会话状态变化：推送到会话房间，并转发到 MQTT 课程主题
+func notifySessionStatus(sessionID int, status string) {
+	var courseID int
+	if err := db.QueryRow("SELECT course_id FROM live_sessions WHERE id = ?", sessionID).Scan(&courseID); err != nil {
+		log.Printf("Failed to load course for session %d: %v", sessionID, err)
+		return
+	}
+
+	data := gin.H{"session_id": sessionID, "course_id": courseID, "status": status}
+	// 中断后恢复推流的会话对客户端仍是直播中
+	if status == "resumed" {
+		data["status"] = "live"
+		data["resumed"] = true
+	}
+	hub.broadcast(sessionRoom(sessionID), Message{Type: "session_status", Data: data})
+	publishMQTT(courseID, "session", data)
+
+	switch status {
+	case "live":
+		emitEvent(EventSessionStarted, courseID, data)
+	case "interrupted":
+		emitEvent(EventSessionInterrupted, courseID, data)
+	case "resumed":
+		emitEvent(EventSessionResumed, courseID, data)
+	case "ended":
+		emitEvent(EventSessionEnded, courseID, data)
+		if err := endSessionPublishers(sessionID); err != nil {
+			log.Printf("Failed to end publishers for session %d: %v", sessionID, err)
+		}
+		go onSessionEnded(sessionID)
+	}
 }
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 补答对象类型
+const (
+	MakeupQuestion = "question"
+	MakeupExam     = "exam"
+)
+
+const jobExamMakeupClose = "exam.makeup_close"
+
+// 为缺席学生单独开放的补答
+type MakeupGrant struct {
+	TargetType string    `json:"target_type"`
+	TargetID   int       `json:"target_id"`
+	StudentID  int       `json:"student_id"`
+	Deadline   time.Time `json:"deadline"`
+	CreatedBy  int       `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type makeupRequest struct {
+	StudentIDs []int     `json:"student_ids" binding:"required,min=1,max=500"`
+	Deadline   time.Time `json:"deadline" binding:"required"`
+}
+
+func init() {
+	// 补答截止时为该学生自动交卷
+	jobHandlers[jobExamMakeupClose] = func(ctx context.Context, payload json.RawMessage) error {
+		var p struct {
+			ExamID    int `json:"exam_id"`
+			StudentID int `json:"student_id"`
+		}
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		// 截止时间已被延长时由新的任务收卷
+		deadline, err := activeMakeup(MakeupExam, p.ExamID, p.StudentID)
+		if err != nil || deadline != nil {
+			return err
+		}
+		exam, err := loadExam(p.ExamID)
+		if err != nil {
+			return err
+		}
+		if _, err := finalizeExamAttempt(exam, p.StudentID, true, true); err != nil && !errors.Is(err, errExamSubmitted) {
+			return err
+		}
+		return nil
+	}
+}
+
+// 学生当前有效的补答截止时间，没有时返回 nil
+func activeMakeup(targetType string, targetID, studentID int) (*time.Time, error) {
+	var deadline time.Time
+	err := db.QueryRow(`
+		SELECT deadline FROM makeup_grants
+		WHERE target_type = ? AND target_id = ? AND student_id = ? AND deadline > ?
+	`, targetType, targetID, studentID, time.Now().UTC()).Scan(&deadline)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &deadline, nil
+}
+
+// 写入补答授权，同一学生重复授权时更新截止时间
+func saveMakeupGrants(c *gin.Context, targetType string, targetID, courseID int, studentIDs []int, deadline time.Time) ([]MakeupGrant, bool) {
+	now := time.Now().UTC()
+	deadline = deadline.UTC()
+	if !deadline.After(now) {
+		respondError(c, http.StatusBadRequest, CodeMakeupDeadlineInvalid)
+		return nil, false
+	}
+
+	userID := currentUser(c).ID
+	grants := []MakeupGrant{}
+	seen := make(map[int]bool)
+	for _, studentID := range studentIDs {
+		if seen[studentID] {
+			continue
+		}
+		seen[studentID] = true
+		_, err := db.Exec(dialect.upsert(`
+			INSERT INTO makeup_grants (target_type, target_id, student_id, deadline, created_by, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			[]string{"target_type", "target_id", "student_id"},
+			"deadline = EXCLUDED.deadline", "created_by = EXCLUDED.created_by", "created_at = EXCLUDED.created_at",
+		), targetType, targetID, studentID, deadline, userID, now)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeMakeupGrantFailed)
+			return nil, false
+		}
+		grants = append(grants, MakeupGrant{
+			TargetType: targetType,
+			TargetID:   targetID,
+			StudentID:  studentID,
+			Deadline:   deadline,
+			CreatedBy:  userID,
+			CreatedAt:  now,
+		})
+	}
+
+	// 学生端按 student_ids 判断是否与自己相关
+	hub.broadcast(courseRoom(courseID), Message{Type: "makeup_opened", Data: gin.H{
+		"target_type": targetType,
+		"target_id":   targetID,
+		"student_ids": studentIDs,
+		"deadline":    deadline,
+	}})
+	recordAudit(c, "grant_makeup", targetType, targetID, gin.H{"student_ids": studentIDs, "deadline": deadline})
+	return grants, true
+}
+
+// 为指定学生重新开放已截止的单题，补答截止前提交的答案标记为补答
+func grantQuestionMakeup(c *gin.Context) {
+	questionID, ok := intParam(c, "question_id")
+	if !ok {
+		return
+	}
+	var req makeupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	push, err := latestQuestionPush(questionID)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeQuestionNotPushed)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQuestionGetFailed)
+		return
+	}
+
+	grants, ok := saveMakeupGrants(c, MakeupQuestion, questionID, push.CourseID, req.StudentIDs, req.Deadline)
+	if !ok {
+		return
+	}
+	respondMakeupGrants(c, grants, nil)
+}
+
+// 为未交卷的学生重新开放已结束的测验，截止时自动交卷
+func grantExamMakeup(c *gin.Context) {
+	exam, ok := examParam(c)
+	if !ok {
+		return
+	}
+	var req makeupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if exam.Status == ExamDraft {
+		respondError(c, http.StatusConflict, CodeExamNotRunning)
+		return
+	}
+
+	// 已交卷的学生不能补答
+	var eligible, skipped []int
+	for _, studentID := range req.StudentIDs {
+		var submitted bool
+		err := db.QueryRow("SELECT submitted_at IS NOT NULL FROM exam_attempts WHERE exam_id = ? AND student_id = ?", exam.ID, studentID).Scan(&submitted)
+		if err != nil && err != sql.ErrNoRows {
+			respondError(c, http.StatusInternalServerError, CodeMakeupGrantFailed)
+			return
+		}
+		if submitted {
+			skipped = append(skipped, studentID)
+		} else {
+			eligible = append(eligible, studentID)
+		}
+	}
+	if len(eligible) == 0 {
+		respondError(c, http.StatusConflict, CodeExamAlreadySubmitted)
+		return
+	}
+
+	grants, ok := saveMakeupGrants(c, MakeupExam, exam.ID, exam.CourseID, eligible, req.Deadline)
+	if !ok {
+		return
+	}
+	for _, g := range grants {
+		if _, err := enqueueJob(jobExamMakeupClose, gin.H{"exam_id": exam.ID, "student_id": g.StudentID}, g.Deadline); err != nil {
+			log.Printf("Failed to schedule makeup close for exam %d student %d: %v", exam.ID, g.StudentID, err)
+		}
+	}
+	respondMakeupGrants(c, grants, skipped)
+}
+
+func respondMakeupGrants(c *gin.Context, grants []MakeupGrant, skipped []int) {
+	loc := requestLocation(c)
+	for i := range grants {
+		grants[i].Deadline = grants[i].Deadline.In(loc)
+		grants[i].CreatedAt = grants[i].CreatedAt.In(loc)
+	}
+	if skipped == nil {
+		skipped = []int{}
+	}
+	respondOK(c, http.StatusOK, gin.H{"grants": grants, "skipped": skipped})
+}
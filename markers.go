@@ -0,0 +1,267 @@
+package main
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 录像时间轴上的章节标记类型
+const (
+	MarkerQuestion  = "question"  // 推送题目的时刻
+	MarkerImportant = "important" // 教师在直播中标记的重点
+	MarkerBookmark  = "bookmark"  // 教师在直播中留下的书签和备注
+)
+
+const (
+	defaultMarkerLabel   = "重点"
+	defaultBookmarkLabel = "书签"
+	markerLabelRunes     = 40 // 题目内容作为标题时的最大长度
+)
+
+// 录像时间轴上的章节标记，学生可据此跳转
+type Marker struct {
+	Kind       string    `json:"kind"`
+	OffsetMs   int       `json:"offset_ms"` // 相对处理后 MP4 的时间
+	Label      string    `json:"label"`
+	Note       string    `json:"note,omitempty"` // 书签备注，只对教师可见
+	QuestionID int       `json:"question_id,omitempty"`
+	At         time.Time `json:"at"`
+}
+
+// 直播中教师留下的标记，stream_offset_ms 为相对开播时间的毫秒数
+type SessionMarker struct {
+	ID             int       `json:"id"`
+	SessionID      int       `json:"session_id"`
+	Kind           string    `json:"kind"`
+	Label          string    `json:"label"`
+	Note           string    `json:"note,omitempty"`
+	StreamOffsetMs *int      `json:"stream_offset_ms,omitempty"`
+	CreatedBy      int       `json:"created_by"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// 记录当前时刻的标记，同时保存相对开播时间的偏移
+func insertSessionMarker(c *gin.Context, sessionID int, kind, label, note string) (SessionMarker, error) {
+	m := SessionMarker{
+		SessionID: sessionID,
+		Kind:      kind,
+		Label:     label,
+		Note:      note,
+		CreatedBy: currentUser(c).ID,
+		CreatedAt: time.Now().UTC(),
+	}
+	var startTime sql.NullTime
+	if err := db.QueryRow("SELECT start_time FROM live_sessions WHERE id = ?", sessionID).Scan(&startTime); err != nil {
+		return m, err
+	}
+	if startTime.Valid {
+		offset := int(m.CreatedAt.Sub(startTime.Time).Milliseconds())
+		m.StreamOffsetMs = &offset
+	}
+	id, err := dialect.insertID(db, `
+		INSERT INTO session_markers (session_id, kind, label, note, stream_offset_ms, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, m.SessionID, m.Kind, m.Label, m.Note, m.StreamOffsetMs, m.CreatedBy, m.CreatedAt)
+	m.ID = int(id)
+	m.CreatedAt = m.CreatedAt.In(requestLocation(c))
+	return m, err
+}
+
+// 直播中标记重点，时间以服务端为准
+func createSessionMarker(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Label string `json:"label" binding:"max=128"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		respondBindError(c, err)
+		return
+	}
+	if req.Label == "" {
+		req.Label = defaultMarkerLabel
+	}
+	if !requireLiveSession(c, sessionID) {
+		return
+	}
+
+	m, err := insertSessionMarker(c, sessionID, MarkerImportant, req.Label, "")
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeMarkerCreateFailed)
+		return
+	}
+	respondOK(c, http.StatusCreated, m)
+}
+
+// 直播中添加书签，可附带备注
+func createSessionBookmark(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Label string `json:"label" binding:"max=128"`
+		Note  string `json:"note" binding:"max=2000"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		respondBindError(c, err)
+		return
+	}
+	if req.Label == "" {
+		req.Label = defaultBookmarkLabel
+	}
+	if !requireLiveSession(c, sessionID) {
+		return
+	}
+
+	m, err := insertSessionMarker(c, sessionID, MarkerBookmark, req.Label, req.Note)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeMarkerCreateFailed)
+		return
+	}
+	respondOK(c, http.StatusCreated, m)
+}
+
+// 会话的书签和重点标记，按时间排序
+func listSessionBookmarks(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	if !sessionExists(c, sessionID) {
+		return
+	}
+	rows, err := db.Query(`
+		SELECT id, session_id, kind, label, COALESCE(note, ''), stream_offset_ms, created_by, created_at
+		FROM session_markers WHERE session_id = ?
+		ORDER BY created_at, id
+	`, sessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeMarkerGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	markers := []SessionMarker{}
+	for rows.Next() {
+		var m SessionMarker
+		if err := rows.Scan(&m.ID, &m.SessionID, &m.Kind, &m.Label, &m.Note, &m.StreamOffsetMs, &m.CreatedBy, &m.CreatedAt); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeMarkerGetFailed)
+			return
+		}
+		m.CreatedAt = m.CreatedAt.In(loc)
+		markers = append(markers, m)
+	}
+	respondOK(c, http.StatusOK, markers)
+}
+
+// 录像时间轴：推送题目、重点标记和书签按时间排序，换算为录像中的位置
+func getRecordingTimeline(c *gin.Context) {
+	rec, ok := recordingParam(c)
+	if !ok {
+		return
+	}
+	var courseID int
+	var startTime, endTime sql.NullTime
+	err := db.QueryRow("SELECT course_id, start_time, end_time FROM live_sessions WHERE id = ?", rec.SessionID).
+		Scan(&courseID, &startTime, &endTime)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+	user := currentUser(c)
+	if user.Role == RoleStudent && !requireCourseAccess(c, courseID, rec.SessionID, user.ID) {
+		return
+	}
+
+	markers := []Marker{}
+	if startTime.Valid {
+		end := time.Now().UTC()
+		if endTime.Valid {
+			end = endTime.Time
+		}
+		markers, err = sessionMarkers(rec, courseID, startTime.Time, end, user.Role != RoleStudent)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeMarkerGetFailed)
+			return
+		}
+	}
+
+	loc := requestLocation(c)
+	for i := range markers {
+		markers[i].At = markers[i].At.In(loc)
+	}
+	respondOK(c, http.StatusOK, gin.H{
+		"recording_id": rec.ID,
+		"markers":      markers,
+	})
+}
+
+// 开播后推送的题目和教师留下的标记；落在开头被裁掉部分的标记移到片头
+func sessionMarkers(rec Recording, courseID int, start, end time.Time, withNotes bool) ([]Marker, error) {
+	markers := []Marker{}
+	add := func(m Marker, streamOffset *int) {
+		offset := int(m.At.Sub(start).Milliseconds())
+		if streamOffset != nil {
+			offset = *streamOffset
+		}
+		m.OffsetMs = max(0, offset-rec.TrimMs)
+		markers = append(markers, m)
+	}
+
+	rows, err := db.Query(`
+		SELECT p.question_id, q.content, p.pushed_at
+		FROM question_pushes p
+		JOIN questions q ON q.id = p.question_id
+		WHERE p.course_id = ? AND p.pushed_at >= ? AND p.pushed_at <= ?
+	`, courseID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		m := Marker{Kind: MarkerQuestion}
+		if err := rows.Scan(&m.QuestionID, &m.Label, &m.At); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if r := []rune(m.Label); len(r) > markerLabelRunes {
+			m.Label = string(r[:markerLabelRunes]) + "…"
+		}
+		add(m, nil)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query("SELECT kind, label, COALESCE(note, ''), stream_offset_ms, created_at FROM session_markers WHERE session_id = ?", rec.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m Marker
+		var streamOffset *int
+		if err := rows.Scan(&m.Kind, &m.Label, &m.Note, &streamOffset, &m.At); err != nil {
+			return nil, err
+		}
+		if !withNotes {
+			m.Note = ""
+		}
+		add(m, streamOffset)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(markers, func(i, j int) bool { return markers[i].OffsetMs < markers[j].OffsetMs })
+	return markers, nil
+}
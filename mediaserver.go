@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Dong557799/zhibo-class/query"
+	"github.com/gin-gonic/gin"
+)
+
+// 流媒体服务器类型
+const (
+	MediaLivego     = "livego"
+	MediaSRS        = "srs"
+	MediaNginxRTMP  = "nginx-rtmp"
+	MediaEmbedded   = "embedded"
+	mediaApp        = "live" // 推流和播放地址中的应用名
+	defaultRTMPPort = "1935"
+)
+
+var errInvalidStreamPath = errors.New("invalid stream path")
+
+// 流媒体服务器配置，driver 为空时使用 livego_url 指定的 Livego
+type MediaServerConfig struct {
+	Driver string `json:"driver"` // livego、srs、nginx-rtmp 或 embedded
+	// 管理接口地址：SRS 的 HTTP API（如 http://srs:1985），nginx-rtmp 的 stat 和 control 所在的 HTTP 服务（如 http://nginx:8080）
+	APIURL string `json:"api_url"`
+	// 服务端拉流和推流的 RTMP 地址，如 rtmp://srs:1935/live；为空时按 api_url 的主机生成
+	RTMPURL string `json:"rtmp_url"`
+	// HTTP 播放地址前缀，如 http://srs:8080/live；nginx-rtmp 为 HLS 目录的地址，如 http://nginx:8080/hls
+	PlayURL string `json:"play_url"`
+	// 内置 RTMP 服务的监听地址，默认 :1935，仅 embedded 使用
+	RTMPListen string `json:"rtmp_listen"`
+	// 核对推流是否存在的间隔，为 0 时使用 30 秒
+	PollSeconds int `json:"poll_seconds"`
+	// 连续多少次查不到推流时将会话标记为中断，为 0 时使用 3 次
+	MissingPolls int `json:"missing_polls"`
+}
+
+// 推流开始或结束的回调，由各服务器的回调格式解析而来
+type StreamEvent struct {
+	StreamKey string
+	Status    string // start 或 stop，其他事件为空
+	Query     string // 推流地址中的查询参数，包含推流令牌
+}
+
+// 正在推流的流的统计
+type StreamStats struct {
+	StreamKey   string `json:"stream_key"`
+	SessionID   int    `json:"session_id,omitempty"`
+	Clients     int    `json:"clients"` // 播放端数量，服务器不提供时为 0
+	BitrateKbps int    `json:"bitrate_kbps"`
+	VideoCodec  string `json:"video_codec,omitempty"`
+	AudioCodec  string `json:"audio_codec,omitempty"`
+}
+
+// 流媒体服务器的对接方式。不同学校已部署的服务器不同，通过 media_server.driver 选择
+type mediaServer interface {
+	// 登记推流码，推流时服务器按推流码接受推流；按需创建流的服务器无需登记
+	CreateStream(ctx context.Context, streamKey string) error
+	// 删除推流码并断开正在进行的推流
+	DeleteStream(ctx context.Context, streamKey string) error
+	// 正在推流的流
+	GetStats(ctx context.Context) ([]StreamStats, error)
+	// 解析推流状态回调
+	ParseCallback(c *gin.Context) (StreamEvent, error)
+	// 按服务器要求的格式响应回调，code 为空表示接受
+	RespondCallback(c *gin.Context, status int, code ErrorCode)
+	// RTMP 地址前缀，如 rtmp://host:1935/live
+	RTMPURL() string
+	// 各协议的播放地址
+	PlayURLs(streamKey string) map[string]string
+}
+
+// 当前配置的流媒体服务器
+func media() mediaServer {
+	conf := currentConfig()
+	switch conf.MediaServer.Driver {
+	case MediaSRS:
+		return srsServer{conf: conf.MediaServer}
+	case MediaNginxRTMP:
+		return nginxRTMPServer{conf: conf.MediaServer}
+	case MediaEmbedded:
+		return embeddedServer{conf: conf.MediaServer}
+	}
+	if conf.LivegoURL == livegoMemoryURL {
+		return memoryServer{}
+	}
+	return livegoServer{apiURL: conf.LivegoURL}
+}
+
+func validateMediaServer(conf MediaServerConfig) error {
+	switch conf.Driver {
+	case "", MediaLivego, MediaEmbedded:
+		return nil
+	case MediaSRS, MediaNginxRTMP:
+		if conf.APIURL == "" {
+			return fmt.Errorf("media_server api_url is required for %s", conf.Driver)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown media_server driver %q", conf.Driver)
+}
+
+// 管理接口地址的主机名，无法解析时为 localhost
+func mediaHost(apiURL string) string {
+	if u, err := url.Parse(apiURL); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return "localhost"
+}
+
+// 发送管理请求，返回非 2xx 时报错
+func mediaRequest(ctx context.Context, method, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s", method, u, resp.Status)
+	}
+	if v != nil {
+		return json.NewDecoder(resp.Body).Decode(v)
+	}
+	return nil
+}
+
+// 默认的回调响应，2xx 表示接受
+func respondMediaCallback(c *gin.Context, status int, code ErrorCode) {
+	if code == "" {
+		respondOK(c, http.StatusOK, gin.H{"message": "Callback received"})
+		return
+	}
+	respondError(c, status, code)
+}
+
+// 为统计结果补充会话ID
+func attachStreamSessions(stats []StreamStats) error {
+	if len(stats) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(stats))
+	for i, s := range stats {
+		args[i] = s.StreamKey
+	}
+	rows, err := db.Query("SELECT id, stream_key FROM live_sessions WHERE stream_key IN ("+query.Placeholders(len(args))+")", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	sessions := make(map[string]int)
+	for rows.Next() {
+		var id int
+		var key string
+		if err := rows.Scan(&id, &key); err != nil {
+			return err
+		}
+		sessions[key] = id
+	}
+	for i := range stats {
+		stats[i].SessionID = sessions[stats[i].StreamKey]
+	}
+	return rows.Err()
+}
+
+// 流媒体服务器上正在推流的流
+func adminListStreams(c *gin.Context) {
+	stats, err := media().GetStats(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusBadGateway, CodeMediaServerUnavailable)
+		return
+	}
+	if err := attachStreamSessions(stats); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+	respondOK(c, http.StatusOK, stats)
+}
+
+// Livego：通过 /api/stream 登记推流码，回调为 {streamPath, status}
+type livegoServer struct {
+	apiURL string
+}
+
+func (s livegoServer) CreateStream(ctx context.Context, streamKey string) error {
+	return mediaRequest(ctx, http.MethodPost, fmt.Sprintf("%s/api/stream/add?stream=%s", s.apiURL, url.QueryEscape(streamKey)), nil)
+}
+
+func (s livegoServer) DeleteStream(ctx context.Context, streamKey string) error {
+	return mediaRequest(ctx, http.MethodPost, fmt.Sprintf("%s/api/stream/delete?stream=%s", s.apiURL, url.QueryEscape(streamKey)), nil)
+}
+
+func (s livegoServer) GetStats(ctx context.Context) ([]StreamStats, error) {
+	var resp struct {
+		Data struct {
+			Publishers []livegoStream `json:"publishers"`
+			Players    []livegoStream `json:"players"`
+		} `json:"data"`
+	}
+	if err := mediaRequest(ctx, http.MethodGet, s.apiURL+"/stat/livestat", &resp); err != nil {
+		return nil, err
+	}
+	players := make(map[string]int)
+	for _, p := range resp.Data.Players {
+		players[p.Key]++
+	}
+	stats := []StreamStats{}
+	for _, p := range resp.Data.Publishers {
+		stats = append(stats, StreamStats{
+			StreamKey: strings.TrimPrefix(p.Key, mediaApp+"/"),
+			Clients:   players[p.Key],
+			// 速度单位为字节/毫秒
+			BitrateKbps: int((p.VideoSpeed + p.AudioSpeed) * 8),
+		})
+	}
+	return stats, nil
+}
+
+type livegoStream struct {
+	Key        string `json:"key"` // live/<stream_key>
+	VideoSpeed uint64 `json:"video_speed"`
+	AudioSpeed uint64 `json:"audio_speed"`
+}
+
+func (s livegoServer) ParseCallback(c *gin.Context) (StreamEvent, error) {
+	var callback struct {
+		StreamPath string `json:"streamPath"`
+		Status     string `json:"status"`
+	}
+	if err := c.ShouldBindJSON(&callback); err != nil {
+		return StreamEvent{}, err
+	}
+	// 格式为 /live/stream_key，推流时后面带有推流令牌 ?expires=...&sig=...
+	path, rawQuery, _ := strings.Cut(callback.StreamPath, "?")
+	parts := strings.Split(path, "/")
+	if len(parts) < 3 || parts[2] == "" {
+		return StreamEvent{}, errInvalidStreamPath
+	}
+	return StreamEvent{StreamKey: parts[2], Status: callback.Status, Query: rawQuery}, nil
+}
+
+func (s livegoServer) RespondCallback(c *gin.Context, status int, code ErrorCode) {
+	respondMediaCallback(c, status, code)
+}
+
+func (s livegoServer) RTMPURL() string {
+	return fmt.Sprintf("rtmp://%s:%s/%s", mediaHost(s.apiURL), defaultRTMPPort, mediaApp)
+}
+
+func (s livegoServer) PlayURLs(streamKey string) map[string]string {
+	host := mediaHost(s.apiURL)
+	return map[string]string{
+		"rtmp": fmt.Sprintf("%s/%s", s.RTMPURL(), streamKey),
+		"flv":  fmt.Sprintf("http://%s:7001/%s/%s.flv", host, mediaApp, streamKey),
+		"hls":  fmt.Sprintf("http://%s:7002/%s/%s.m3u8", host, mediaApp, streamKey),
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/Dong557799/zhibo-class/query"
+	"github.com/gin-gonic/gin"
+)
+
+// 举报处理状态
+const (
+	ReportOpen      = "open"
+	ReportDismissed = "dismissed"
+	ReportActioned  = "actioned"
+)
+
+const (
+	defaultAutoMuteReports = 3
+	defaultAutoMuteMinutes = 10
+	maxMuteMinutes         = 7 * 24 * 60
+)
+
+var reportReasons = []string{"spam", "abuse", "harassment", "other"}
+
+// 举报与禁言配置
+type ModerationConfig struct {
+	// 同一场会话中被不同学生举报达到该次数时自动临时禁言，为 0 时使用 3，为负数时不自动禁言
+	AutoMuteReports int `json:"auto_mute_reports"`
+	AutoMuteMinutes int `json:"auto_mute_minutes"` // 自动禁言时长（分钟），为 0 时使用 10
+}
+
+// 对聊天消息或用户的举报
+type AbuseReport struct {
+	ID           int        `json:"id"`
+	SessionID    int        `json:"session_id"`
+	ReporterID   int        `json:"reporter_id"`
+	TargetUserID int        `json:"target_user_id"`
+	TargetName   string     `json:"target_name,omitempty"`
+	MessageID    int64      `json:"message_id,omitempty"`
+	Message      string     `json:"message,omitempty"` // 被举报的聊天内容
+	Reason       string     `json:"reason"`
+	Detail       string     `json:"detail,omitempty"`
+	Status       string     `json:"status"`
+	ReviewedBy   *int       `json:"reviewed_by,omitempty"`
+	ReviewNote   string     `json:"review_note,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ReviewedAt   *time.Time `json:"reviewed_at,omitempty"`
+}
+
+var reportSortColumns = map[string]string{
+	"id":         "r.id",
+	"created_at": "r.created_at",
+}
+
+func autoMuteReports() int {
+	if n := currentConfig().Moderation.AutoMuteReports; n != 0 {
+		return n
+	}
+	return defaultAutoMuteReports
+}
+
+func autoMuteDuration() time.Duration {
+	if m := currentConfig().Moderation.AutoMuteMinutes; m > 0 {
+		return time.Duration(m) * time.Minute
+	}
+	return defaultAutoMuteMinutes * time.Minute
+}
+
+// 用户在会话中被禁言的截止时间，未禁言时返回 nil
+func chatMutedUntil(sessionID, userID int) (*time.Time, error) {
+	var until time.Time
+	err := db.QueryRow("SELECT muted_until FROM chat_mutes WHERE session_id = ? AND user_id = ?", sessionID, userID).Scan(&until)
+	if err == sql.ErrNoRows || (err == nil && !until.After(time.Now())) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &until, nil
+}
+
+// 禁言用户并通知会话，createdBy 为 0 表示自动禁言
+func muteChatUser(sessionID, userID int, d time.Duration, reason string, createdBy int) (time.Time, error) {
+	now := time.Now().UTC()
+	until := now.Add(d).Truncate(time.Second)
+	_, err := db.Exec(dialect.upsert(`
+		INSERT INTO chat_mutes (session_id, user_id, muted_until, reason, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		[]string{"session_id", "user_id"},
+		"muted_until = EXCLUDED.muted_until",
+		"reason = EXCLUDED.reason",
+		"created_by = EXCLUDED.created_by",
+		"created_at = EXCLUDED.created_at",
+	), sessionID, userID, until, reason, createdBy, now)
+	if err != nil {
+		return until, err
+	}
+	hub.broadcast(sessionRoom(sessionID), Message{Type: "chat_muted", Data: gin.H{
+		"user_id":     userID,
+		"muted_until": until,
+		"auto":        createdBy == 0,
+	}})
+	return until, nil
+}
+
+func unmuteChatUser(sessionID, userID int) error {
+	if _, err := db.Exec("DELETE FROM chat_mutes WHERE session_id = ? AND user_id = ?", sessionID, userID); err != nil {
+		return err
+	}
+	hub.broadcast(sessionRoom(sessionID), Message{Type: "chat_unmuted", Data: gin.H{"user_id": userID}})
+	return nil
+}
+
+// 举报会话中的聊天消息或用户；同一用户在同一场会话中被不同学生举报达到阈值时自动临时禁言
+func reportAbuse(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		MessageID    int64  `json:"message_id" binding:"omitempty,gt=0"`
+		TargetUserID int    `json:"target_user_id" binding:"omitempty,gt=0"`
+		Reason       string `json:"reason" binding:"required"`
+		Detail       string `json:"detail" binding:"max=500"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if !contains(reportReasons, req.Reason) {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "reason")
+		return
+	}
+	if req.MessageID == 0 && req.TargetUserID == 0 {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "target_user_id")
+		return
+	}
+
+	var courseID, teacherID int
+	err := db.QueryRow("SELECT course_id, teacher_id FROM live_sessions WHERE id = ?", sessionID).Scan(&courseID, &teacherID)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSessionNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+	user := currentUser(c)
+	if user.Role == RoleStudent && !requireCourseAccess(c, courseID, sessionID, user.ID) {
+		return
+	}
+
+	// 举报消息时以消息的发送者为被举报人
+	if req.MessageID != 0 {
+		err := db.QueryRow("SELECT user_id FROM chat_messages WHERE id = ? AND session_id = ?", req.MessageID, sessionID).Scan(&req.TargetUserID)
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, CodeChatMessageNotFound)
+			return
+		}
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeReportFailed)
+			return
+		}
+	}
+	if req.TargetUserID == user.ID {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "target_user_id")
+		return
+	}
+	target, err := getUser(req.TargetUserID)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeUserNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeUserGetFailed)
+		return
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	id, err := dialect.insertID(db, `
+		INSERT INTO abuse_reports (session_id, reporter_id, target_user_id, message_id, reason, detail, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, sessionID, user.ID, target.ID, req.MessageID, req.Reason, req.Detail, ReportOpen, now)
+	if err != nil {
+		if dialect.isDuplicate(err) {
+			respondError(c, http.StatusConflict, CodeReportDuplicate)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeReportFailed)
+		}
+		return
+	}
+	recordAudit(c, "report_abuse", "user", target.ID, gin.H{
+		"report_id":  id,
+		"session_id": sessionID,
+		"message_id": req.MessageID,
+		"reason":     req.Reason,
+	})
+
+	// 授课老师和管理员不会被自动禁言
+	response := gin.H{"id": id, "status": ReportOpen}
+	threshold := autoMuteReports()
+	if threshold > 0 && target.Role != RoleAdmin && target.ID != teacherID {
+		var reporters int
+		err := db.QueryRow(`
+			SELECT COUNT(DISTINCT reporter_id) FROM abuse_reports
+			WHERE session_id = ? AND target_user_id = ? AND status = ?
+		`, sessionID, target.ID, ReportOpen).Scan(&reporters)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeReportFailed)
+			return
+		}
+		muted, err := chatMutedUntil(sessionID, target.ID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeReportFailed)
+			return
+		}
+		if reporters >= threshold && muted == nil {
+			until, err := muteChatUser(sessionID, target.ID, autoMuteDuration(), "auto: reported", 0)
+			if err != nil {
+				respondError(c, http.StatusInternalServerError, CodeReportFailed)
+				return
+			}
+			recordAudit(c, "auto_mute_user", "user", target.ID, gin.H{
+				"session_id":  sessionID,
+				"reports":     reporters,
+				"muted_until": until,
+			})
+			response["target_muted"] = true
+		}
+	}
+	respondOK(c, http.StatusCreated, response)
+}
+
+// 待处理的举报，管理员审核
+func adminListReports(c *gin.Context) {
+	page, pageSize, offset := pageParams(c)
+	status := c.DefaultQuery("status", ReportOpen)
+	if status == "all" {
+		status = ""
+	}
+	q := query.New().
+		Eq("r.status", status).
+		Eq("r.session_id", c.Query("session_id")).
+		Eq("r.target_user_id", c.Query("target_user_id")).
+		Sort(c.Query("sort"), reportSortColumns, "r.id DESC")
+
+	var total int64
+	if err := db.QueryRow("SELECT COUNT(*) FROM abuse_reports r WHERE "+q.WhereSQL(), q.Args()...).Scan(&total); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeReportGetFailed)
+		return
+	}
+	rows, err := db.Query(`
+		SELECT r.id, r.session_id, r.reporter_id, r.target_user_id, COALESCE(u.name, ''), r.message_id, COALESCE(m.content, ''),
+			r.reason, r.detail, r.status, r.reviewed_by, r.review_note, r.created_at, r.reviewed_at
+		FROM abuse_reports r
+		LEFT JOIN users u ON u.id = r.target_user_id
+		LEFT JOIN chat_messages m ON m.id = r.message_id
+		WHERE `+q.WhereSQL()+`
+		ORDER BY `+q.OrderSQL()+`
+		LIMIT ? OFFSET ?
+	`, q.Args(pageSize, offset)...)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeReportGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	reports := []AbuseReport{}
+	for rows.Next() {
+		var r AbuseReport
+		var message string
+		if err := rows.Scan(&r.ID, &r.SessionID, &r.ReporterID, &r.TargetUserID, &r.TargetName, &r.MessageID, &message,
+			&r.Reason, &r.Detail, &r.Status, &r.ReviewedBy, &r.ReviewNote, &r.CreatedAt, &r.ReviewedAt); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeReportGetFailed)
+			return
+		}
+		if message != "" {
+			r.Message = chatText(message)
+		}
+		r.CreatedAt = r.CreatedAt.In(loc)
+		if r.ReviewedAt != nil {
+			t := r.ReviewedAt.In(loc)
+			r.ReviewedAt = &t
+		}
+		reports = append(reports, r)
+	}
+	respondPage(c, reports, Pagination{Page: page, PageSize: pageSize, Total: total, HasMore: int64(offset+len(reports)) < total})
+}
+
+// 审核举报：dismiss 驳回并解除自动禁言，mute 禁言被举报人，unmute 解除禁言；
+// 同一场会话中针对同一用户的其他待处理举报一并结案
+func adminReviewReport(c *gin.Context) {
+	reportID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Action      string `json:"action" binding:"required,oneof=dismiss mute unmute"`
+		MuteMinutes int    `json:"mute_minutes" binding:"omitempty,min=1"`
+		Note        string `json:"note" binding:"max=500"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if req.MuteMinutes > maxMuteMinutes {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "mute_minutes")
+		return
+	}
+
+	var sessionID, targetID int
+	err := db.QueryRow("SELECT session_id, target_user_id FROM abuse_reports WHERE id = ?", reportID).Scan(&sessionID, &targetID)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeReportNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeReportGetFailed)
+		return
+	}
+
+	user := currentUser(c)
+	status := ReportActioned
+	detail := gin.H{"report_id": reportID, "session_id": sessionID, "action": req.Action, "note": req.Note}
+	switch req.Action {
+	case "mute":
+		d := autoMuteDuration()
+		if req.MuteMinutes > 0 {
+			d = time.Duration(req.MuteMinutes) * time.Minute
+		}
+		until, err := muteChatUser(sessionID, targetID, d, "report", user.ID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeReportFailed)
+			return
+		}
+		detail["muted_until"] = until
+	case "dismiss":
+		status = ReportDismissed
+		_, err = db.Exec("DELETE FROM chat_mutes WHERE session_id = ? AND user_id = ? AND created_by = 0", sessionID, targetID)
+		if err == nil {
+			hub.broadcast(sessionRoom(sessionID), Message{Type: "chat_unmuted", Data: gin.H{"user_id": targetID}})
+		}
+	case "unmute":
+		err = unmuteChatUser(sessionID, targetID)
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeReportFailed)
+		return
+	}
+
+	now := time.Now().UTC()
+	res, err := db.Exec(`
+		UPDATE abuse_reports SET status = ?, reviewed_by = ?, review_note = ?, reviewed_at = ?
+		WHERE (id = ? OR (session_id = ? AND target_user_id = ? AND status = ?))
+	`, status, user.ID, req.Note, now, reportID, sessionID, targetID, ReportOpen)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeReportFailed)
+		return
+	}
+	closed, _ := res.RowsAffected()
+	detail["closed_reports"] = closed
+	recordAudit(c, "review_report", "user", targetID, detail)
+	respondOK(c, http.StatusOK, gin.H{"id": reportID, "status": status, "closed_reports": closed})
+}
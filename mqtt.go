@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	mqttQoS            = 1
+	mqttPublishTimeout = 10 * time.Second
+	defaultTopicPrefix = "zhibo"
+)
+
+// MQTT 桥接配置，broker 为空时不启用
+type MQTTConfig struct {
+	Broker      string `json:"broker"` // 如 tcp://127.0.0.1:1883
+	ClientID    string `json:"client_id"`
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	TopicPrefix string `json:"topic_prefix"`
+}
+
+var mqttClient mqtt.Client
+
+// 连接 MQTT broker，断线后自动重连
+func startMQTTBridge() error {
+	cfg := currentConfig().MQTT
+	if cfg.Broker == "" {
+		return nil
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = fmt.Sprintf("zhibo-class-%d", time.Now().UnixNano())
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetCleanSession(false).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("MQTT connection lost: %v", err)
+		}).
+		SetOnConnectHandler(func(mqtt.Client) {
+			log.Printf("MQTT connected to %s", cfg.Broker)
+		})
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		// 开启了 ConnectRetry，后台会继续重试，消息会在连接恢复后发出
+		log.Printf("MQTT broker %s not reachable yet, retrying in background", cfg.Broker)
+	} else if err := token.Error(); err != nil {
+		return err
+	}
+
+	mqttClient = client
+	return nil
+}
+
+// 课程主题，如 zhibo/course/12/question
+func courseTopic(courseID int, event string) string {
+	prefix := currentConfig().MQTT.TopicPrefix
+	if prefix == "" {
+		prefix = defaultTopicPrefix
+	}
+	return fmt.Sprintf("%s/course/%d/%s", prefix, courseID, event)
+}
+
+// 以 QoS 1 发布到课程主题，未启用时直接返回
+func publishMQTT(courseID int, event string, data interface{}) {
+	if mqttClient == nil {
+		return
+	}
+
+	payload, err := json.Marshal(Message{Type: event, Data: data, Time: time.Now()})
+	if err != nil {
+		log.Printf("Failed to encode MQTT %s message: %v", event, err)
+		return
+	}
+
+	topic := courseTopic(courseID, event)
+	token := mqttClient.Publish(topic, mqttQoS, false, payload)
+	go func() {
+		if !token.WaitTimeout(mqttPublishTimeout) {
+			log.Printf("MQTT publish to %s timed out", topic)
+			return
+		}
+		if err := token.Error(); err != nil {
+			log.Printf("MQTT publish to %s failed: %v", topic, err)
+		}
+	}()
+}
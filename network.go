@@ -0,0 +1,347 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 网络限制适用的操作
+const (
+	NetworkPlayback = "playback"
+	NetworkAnswers  = "answers" // 课堂答题和测验作答
+)
+
+// 限制学生只能从指定 IP 段（如校园网）观看直播或作答，用于考试类课堂。
+// course_id 不为 0 时为课程设置，否则为机构设置；课程设置优先于学生所属机构的设置
+type NetworkPolicy struct {
+	CourseID         int       `json:"course_id,omitempty"`
+	Org              string    `json:"org,omitempty"`
+	CIDRs            []string  `json:"cidrs"`
+	RestrictPlayback bool      `json:"restrict_playback"`
+	RestrictAnswers  bool      `json:"restrict_answers"`
+	UpdatedBy        int       `json:"updated_by"`
+	UpdatedAt        time.Time `json:"updated_at"`
+
+	prefixes []netip.Prefix
+}
+
+type networkKey struct {
+	courseID int
+	org      string
+}
+
+// 多副本部署时其他实例的修改最迟在该时间后生效
+const networkCacheTTL = 30 * time.Second
+
+var (
+	networkMu       sync.RWMutex
+	networkCache    map[networkKey]NetworkPolicy
+	networkLoadedAt time.Time
+)
+
+// 解析 IP 段，也接受单个 IP 地址
+func parseNetworkCIDRs(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ip %q", cidr)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %q", cidr)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func loadNetworkPolicies() (map[networkKey]NetworkPolicy, error) {
+	networkMu.RLock()
+	cache, loadedAt := networkCache, networkLoadedAt
+	networkMu.RUnlock()
+	if cache != nil && time.Since(loadedAt) < networkCacheTTL {
+		return cache, nil
+	}
+
+	policies, err := queryNetworkPolicies()
+	if err != nil {
+		return nil, err
+	}
+	cache = make(map[networkKey]NetworkPolicy, len(policies))
+	for _, p := range policies {
+		cache[networkKey{p.CourseID, p.Org}] = p
+	}
+
+	networkMu.Lock()
+	networkCache, networkLoadedAt = cache, time.Now()
+	networkMu.Unlock()
+	return cache, nil
+}
+
+func queryNetworkPolicies() ([]NetworkPolicy, error) {
+	rows, err := db.Query(`
+		SELECT course_id, org, cidrs, restrict_playback, restrict_answers, updated_by, updated_at
+		FROM network_policies
+		ORDER BY course_id, org
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []NetworkPolicy{}
+	for rows.Next() {
+		var p NetworkPolicy
+		var cidrs string
+		if err := rows.Scan(&p.CourseID, &p.Org, &cidrs, &p.RestrictPlayback, &p.RestrictAnswers, &p.UpdatedBy, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		p.CIDRs = splitList(cidrs)
+		if p.prefixes, err = parseNetworkCIDRs(p.CIDRs); err != nil {
+			log.Printf("Invalid network policy for course %d org %q: %v", p.CourseID, p.Org, err)
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+func invalidateNetworkCache() {
+	networkMu.Lock()
+	networkCache = nil
+	networkMu.Unlock()
+}
+
+// 客户端 IP 是否可以进行指定操作，没有适用的限制时允许
+func networkAllowed(courseID int, org, ip, purpose string) (bool, error) {
+	policies, err := loadNetworkPolicies()
+	if err != nil {
+		return false, err
+	}
+	p, ok := policies[networkKey{courseID, ""}]
+	if !ok && org != "" {
+		p, ok = policies[networkKey{0, org}]
+	}
+	if !ok {
+		return true, nil
+	}
+	if (purpose == NetworkPlayback && !p.RestrictPlayback) || (purpose == NetworkAnswers && !p.RestrictAnswers) {
+		return true, nil
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false, nil
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(addr) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// 检查学生的网络限制，不允许时写入错误响应；老师和管理员不受限制
+func checkNetwork(c *gin.Context, courseID int, purpose string) bool {
+	user := currentUser(c)
+	if user == nil || user.Role != RoleStudent {
+		return true
+	}
+	allowed, err := networkAllowed(courseID, user.Org, c.ClientIP(), purpose)
+	if err != nil {
+		log.Printf("Failed to check network policy for course %d: %v", courseID, err)
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return false
+	}
+	if !allowed {
+		respondError(c, http.StatusForbidden, CodeNetworkRestricted)
+		return false
+	}
+	return true
+}
+
+// 按路径参数找到课程后检查网络限制
+func requireAllowedNetwork(purpose string, course func(c *gin.Context) (int, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := currentUser(c)
+		if user == nil || user.Role != RoleStudent {
+			c.Next()
+			return
+		}
+		courseID, err := course(c)
+		if err != nil {
+			// 会话或测验不存在时交给后续处理返回对应的错误
+			if err != sql.ErrNoRows {
+				respondError(c, http.StatusInternalServerError, CodeInternal)
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+		if !checkNetwork(c, courseID, purpose) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// 路径参数 id 为直播会话时所属的课程
+func sessionCourse(c *gin.Context) (int, error) {
+	var courseID int
+	err := db.QueryRow("SELECT course_id FROM live_sessions WHERE id = ?", c.Param("id")).Scan(&courseID)
+	return courseID, err
+}
+
+// 路径参数 id 为测验时所属的课程
+func examCourse(c *gin.Context) (int, error) {
+	var courseID int
+	err := db.QueryRow("SELECT course_id FROM exams WHERE id = ?", c.Param("id")).Scan(&courseID)
+	return courseID, err
+}
+
+// 播放令牌的持有者是否在允许的网络中，用于 CDN 回源鉴权和播放器心跳；不允许时写入错误响应
+func checkPlaybackNetwork(c *gin.Context, claims playbackClaims) bool {
+	var courseID int
+	var role, org string
+	err := db.QueryRow(`
+		SELECT s.course_id, u.role, u.org
+		FROM live_sessions s, users u
+		WHERE s.id = ? AND u.id = ?
+	`, claims.SessionID, claims.UserID).Scan(&courseID, &role, &org)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusForbidden, CodePlaybackTokenInvalid)
+		return false
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return false
+	}
+	if role != RoleStudent {
+		return true
+	}
+	allowed, err := networkAllowed(courseID, org, c.ClientIP(), NetworkPlayback)
+	if err != nil {
+		log.Printf("Failed to check network policy for course %d: %v", courseID, err)
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return false
+	}
+	if !allowed {
+		respondError(c, http.StatusForbidden, CodeNetworkRestricted)
+		return false
+	}
+	return true
+}
+
+// 管理员查看所有网络限制
+func adminListNetworkPolicies(c *gin.Context) {
+	policies, err := queryNetworkPolicies()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeNetworkPolicyGetFailed)
+		return
+	}
+	loc := requestLocation(c)
+	for i := range policies {
+		policies[i].UpdatedAt = policies[i].UpdatedAt.In(loc)
+	}
+	respondOK(c, http.StatusOK, policies)
+}
+
+// 设置课程或机构的网络限制，course_id 和 org 只能指定一个
+func adminSetNetworkPolicy(c *gin.Context) {
+	var req struct {
+		CourseID         int      `json:"course_id" binding:"min=0"`
+		Org              string   `json:"org" binding:"max=64"`
+		CIDRs            []string `json:"cidrs" binding:"required,min=1,max=100"`
+		RestrictPlayback bool     `json:"restrict_playback"`
+		RestrictAnswers  bool     `json:"restrict_answers"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if (req.CourseID == 0) == (req.Org == "") {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "course_id")
+		return
+	}
+	if _, err := parseNetworkCIDRs(req.CIDRs); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "cidrs")
+		return
+	}
+
+	p := NetworkPolicy{
+		CourseID:         req.CourseID,
+		Org:              req.Org,
+		CIDRs:            req.CIDRs,
+		RestrictPlayback: req.RestrictPlayback,
+		RestrictAnswers:  req.RestrictAnswers,
+		UpdatedBy:        currentUser(c).ID,
+		UpdatedAt:        time.Now().UTC(),
+	}
+	for i, cidr := range p.CIDRs {
+		p.CIDRs[i] = strings.TrimSpace(cidr)
+	}
+	_, err := db.Exec(dialect.upsert(`
+		INSERT INTO network_policies (course_id, org, cidrs, restrict_playback, restrict_answers, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		[]string{"course_id", "org"},
+		"cidrs = EXCLUDED.cidrs", "restrict_playback = EXCLUDED.restrict_playback",
+		"restrict_answers = EXCLUDED.restrict_answers", "updated_by = EXCLUDED.updated_by", "updated_at = EXCLUDED.updated_at",
+	), p.CourseID, p.Org, strings.Join(p.CIDRs, ","), p.RestrictPlayback, p.RestrictAnswers, p.UpdatedBy, p.UpdatedAt)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeNetworkPolicyUpdateFailed)
+		return
+	}
+	invalidateNetworkCache()
+
+	recordAudit(c, "set_network_policy", "course", p.CourseID, gin.H{
+		"org":               p.Org,
+		"cidrs":             p.CIDRs,
+		"restrict_playback": p.RestrictPlayback,
+		"restrict_answers":  p.RestrictAnswers,
+	})
+	p.UpdatedAt = p.UpdatedAt.In(requestLocation(c))
+	respondOK(c, http.StatusOK, p)
+}
+
+// 删除课程或机构的网络限制
+func adminDeleteNetworkPolicy(c *gin.Context) {
+	courseID, err := strconv.Atoi(c.DefaultQuery("course_id", "0"))
+	if err != nil || courseID < 0 {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "course_id")
+		return
+	}
+	org := c.Query("org")
+	if (courseID == 0) == (org == "") {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "course_id")
+		return
+	}
+	res, err := db.Exec("DELETE FROM network_policies WHERE course_id = ? AND org = ?", courseID, org)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeNetworkPolicyUpdateFailed)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, CodeNetworkPolicyNotFound)
+		return
+	}
+	invalidateNetworkCache()
+
+	recordAudit(c, "delete_network_policy", "course", courseID, gin.H{"org": org})
+	respondOK(c, http.StatusOK, gin.H{"course_id": courseID, "org": org})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// nginx-rtmp：按需创建流，推流鉴权和状态通过 on_publish、on_publish_done 回调完成，
+// 断开推流需要启用 rtmp_control，统计来自 rtmp_stat
+type nginxRTMPServer struct {
+	conf MediaServerConfig
+}
+
+// rtmp_stat 输出中用到的部分
+type nginxRTMPStat struct {
+	Applications []struct {
+		Name    string `xml:"name"`
+		Streams []struct {
+			Name       string    `xml:"name"`
+			BwIn       int       `xml:"bw_in"` // 比特/秒
+			NClients   int       `xml:"nclients"`
+			Publishing *struct{} `xml:"publishing"`
+			VideoCodec string    `xml:"meta>video>codec"`
+			AudioCodec string    `xml:"meta>audio>codec"`
+		} `xml:"live>stream"`
+	} `xml:"server>application"`
+}
+
+func (s nginxRTMPServer) apiURL() string {
+	return strings.TrimSuffix(s.conf.APIURL, "/")
+}
+
+// 推流码在推流时由回调校验，无需登记
+func (s nginxRTMPServer) CreateStream(ctx context.Context, streamKey string) error {
+	return nil
+}
+
+func (s nginxRTMPServer) DeleteStream(ctx context.Context, streamKey string) error {
+	u := fmt.Sprintf("%s/control/drop/publisher?app=%s&name=%s", s.apiURL(), mediaApp, url.QueryEscape(streamKey))
+	return mediaRequest(ctx, http.MethodGet, u, nil)
+}
+
+func (s nginxRTMPServer) GetStats(ctx context.Context) ([]StreamStats, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.apiURL()+"/stat", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("nginx-rtmp stat: %s", resp.Status)
+	}
+	var stat nginxRTMPStat
+	if err := xml.NewDecoder(resp.Body).Decode(&stat); err != nil {
+		return nil, err
+	}
+
+	stats := []StreamStats{}
+	for _, app := range stat.Applications {
+		if app.Name != mediaApp {
+			continue
+		}
+		for _, st := range app.Streams {
+			if st.Publishing == nil {
+				continue
+			}
+			stat := StreamStats{
+				StreamKey:   st.Name,
+				BitrateKbps: st.BwIn / 1000,
+				VideoCodec:  st.VideoCodec,
+				AudioCodec:  st.AudioCodec,
+			}
+			// nclients 包含推流端
+			if st.NClients > 0 {
+				stat.Clients = st.NClients - 1
+			}
+			stats = append(stats, stat)
+		}
+	}
+	return stats, nil
+}
+
+// 回调为表单，推流地址中的查询参数（推流令牌）作为表单字段一并传入
+func (s nginxRTMPServer) ParseCallback(c *gin.Context) (StreamEvent, error) {
+	if err := c.Request.ParseForm(); err != nil {
+		return StreamEvent{}, err
+	}
+	form := c.Request.PostForm
+	if form.Get("app") != mediaApp || form.Get("name") == "" {
+		return StreamEvent{}, errInvalidStreamPath
+	}
+	event := StreamEvent{StreamKey: form.Get("name"), Query: form.Encode()}
+	switch form.Get("call") {
+	case "publish":
+		event.Status = "start"
+	case "publish_done":
+		event.Status = "stop"
+	}
+	return event, nil
+}
+
+func (s nginxRTMPServer) RespondCallback(c *gin.Context, status int, code ErrorCode) {
+	respondMediaCallback(c, status, code)
+}
+
+func (s nginxRTMPServer) RTMPURL() string {
+	if s.conf.RTMPURL != "" {
+		return strings.TrimSuffix(s.conf.RTMPURL, "/")
+	}
+	return fmt.Sprintf("rtmp://%s:%s/%s", mediaHost(s.conf.APIURL), defaultRTMPPort, mediaApp)
+}
+
+// nginx-rtmp 不提供 HTTP-FLV，只返回 RTMP 和 HLS 地址
+func (s nginxRTMPServer) PlayURLs(streamKey string) map[string]string {
+	play := strings.TrimSuffix(s.conf.PlayURL, "/")
+	if play == "" {
+		play = fmt.Sprintf("http://%s:8080/hls", mediaHost(s.conf.APIURL))
+	}
+	return map[string]string{
+		"rtmp": s.RTMPURL() + "/" + streamKey,
+		"hls":  play + "/" + streamKey + ".m3u8",
+	}
+}
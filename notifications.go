@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 站内通知类型
+const (
+	NotifySessionCancelled   = "session_cancelled"
+	NotifySessionMakeup      = "session_makeup"
+	NotifySessionInterrupted = "session_interrupted" // 直播推流中断
+	NotifyRecordingExpiring  = "recording_expiring"  // 录像即将按生命周期策略删除
+	NotifySessionTransferred = "session_transferred" // 会话移交给了当前用户
+)
+
+// 站内通知，离线的学生下次登录后查看
+type Notification struct {
+	ID        int             `json:"id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+	ReadAt    *time.Time      `json:"read_at,omitempty"`
+}
+
+// 为多个用户写入同一条通知
+func notifyUsers(userIDs []int, notifyType string, data interface{}) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare("INSERT INTO user_notifications (user_id, type, data, created_at) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	now := time.Now().UTC()
+	for _, id := range userIDs {
+		if _, err := stmt.Exec(id, notifyType, string(raw), now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// 课程的所有报名学生
+func enrolledStudents(courseID int) ([]int, error) {
+	rows, err := db.Query("SELECT student_id FROM course_enrollments WHERE course_id = ? ORDER BY student_id", courseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// 当前用户的通知，unread=true 时只返回未读的
+func listNotifications(c *gin.Context) {
+	page, pageSize, offset := pageParams(c)
+	userID := currentUser(c).ID
+	where := "user_id = ?"
+	if c.Query("unread") == "true" {
+		where += " AND read_at IS NULL"
+	}
+
+	var total int64
+	if err := db.QueryRow("SELECT COUNT(*) FROM user_notifications WHERE "+where, userID).Scan(&total); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeNotificationGetFailed)
+		return
+	}
+	rows, err := db.Query(`
+		SELECT id, type, data, created_at, read_at
+		FROM user_notifications
+		WHERE `+where+`
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`, userID, pageSize, offset)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeNotificationGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		var data string
+		if err := rows.Scan(&n.ID, &n.Type, &data, &n.CreatedAt, &n.ReadAt); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeNotificationGetFailed)
+			return
+		}
+		n.Data = json.RawMessage(data)
+		n.CreatedAt = n.CreatedAt.In(loc)
+		n.ReadAt = inLocation(n.ReadAt, loc)
+		notifications = append(notifications, n)
+	}
+	respondPage(c, notifications, Pagination{Page: page, PageSize: pageSize, Total: total, HasMore: int64(offset+len(notifications)) < total})
+}
+
+// 标记通知已读
+func readNotification(c *gin.Context) {
+	id, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	res, err := db.Exec("UPDATE user_notifications SET read_at = ? WHERE id = ? AND user_id = ? AND read_at IS NULL",
+		time.Now().UTC(), id, currentUser(c).ID)
+	if err != nil {
+		log.Printf("Failed to mark notification %d read: %v", id, err)
+		respondError(c, http.StatusInternalServerError, CodeNotificationGetFailed)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var one int
+		if err := db.QueryRow("SELECT 1 FROM user_notifications WHERE id = ? AND user_id = ?", id, currentUser(c).ID).Scan(&one); err != nil {
+			respondError(c, http.StatusNotFound, CodeNotificationNotFound)
+			return
+		}
+	}
+	respondOK(c, http.StatusOK, gin.H{"id": id})
+}
@@ -0,0 +1,670 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	oidcStateTTL       = 10 * time.Minute
+	oidcDiscoveryTTL   = time.Hour
+	oidcJWKSRefreshMin = time.Minute // 遇到未知 kid 时重新拉取公钥的最小间隔
+	oidcStateCookie    = "oidc_state"
+	oidcLinkTicketTTL  = 10 * time.Minute
+	oidcHTTPTimeout    = 10 * time.Second
+)
+
+// 校园统一身份认证（OIDC）配置，issuer 为空时不启用
+type OIDCConfig struct {
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RedirectURL  string   `json:"redirect_url"` // 本服务的回调地址，如 https://class.example.edu/api/auth/oidc/callback
+	Scopes       []string `json:"scopes"`       // 为空时使用 openid profile email
+
+	// 登录成功后跳转的前端地址，令牌放在 URL fragment 中；为空时回调直接返回 JSON
+	SuccessRedirect string `json:"success_redirect"`
+
+	UsernameClaim string `json:"username_claim"` // 为空时使用 preferred_username，缺失时用 sub
+	NameClaim     string `json:"name_claim"`     // 为空时使用 name
+	OrgClaim      string `json:"org_claim"`      // 写入用户所属机构，为空时不同步
+	// 角色声明（字符串或数组）及其取值到本系统角色的映射，如 {"faculty": "teacher"}；
+	// 映射到多个角色时取权限最高的，没有映射时使用 default_role（默认 student）
+	RoleClaim   string            `json:"role_claim"`
+	RoleMapping map[string]string `json:"role_mapping"`
+	DefaultRole string            `json:"default_role"`
+
+	// 首次登录时遇到同名的本地账号，要求用户输入该账号的本地密码确认后再关联；关闭时创建新账号
+	LinkExistingUsers bool `json:"link_existing_users"`
+}
+
+func oidcEnabled() bool {
+	return currentConfig().OIDC.Issuer != ""
+}
+
+// IdP 的发现文档，按 issuer 缓存
+var oidcProvider = struct {
+	sync.Mutex
+	issuer      string
+	docIssuer   string // 发现文档中的 issuer 原文，ID Token 的 iss 必须与之完全一致
+	fetchedAt   time.Time
+	auth, token string
+	jwksURI     string
+}{}
+
+// 签名公钥，按 JWKS 地址缓存，OIDC 和 LTI 共用
+var jwksCache = struct {
+	sync.Mutex
+	keys    map[string]map[string]interface{}
+	fetched map[string]time.Time
+}{keys: map[string]map[string]interface{}{}, fetched: map[string]time.Time{}}
+
+type oidcEndpoints struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+var oidcHTTPClient = &http.Client{Timeout: oidcHTTPTimeout, Transport: httpClient.Transport}
+
+func oidcGetJSON(u string, v interface{}) error {
+	resp, err := oidcHTTPClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("GET %s returned status %d: %s", u, resp.StatusCode, msg)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// 读取发现文档，返回授权和令牌端点
+func oidcDiscover() (authURL, tokenURL string, err error) {
+	issuer := strings.TrimRight(currentConfig().OIDC.Issuer, "/")
+	oidcProvider.Lock()
+	defer oidcProvider.Unlock()
+	if oidcProvider.issuer == issuer && time.Since(oidcProvider.fetchedAt) < oidcDiscoveryTTL {
+		return oidcProvider.auth, oidcProvider.token, nil
+	}
+
+	var doc oidcEndpoints
+	if err := oidcGetJSON(issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return "", "", err
+	}
+	if strings.TrimRight(doc.Issuer, "/") != issuer {
+		return "", "", fmt.Errorf("discovery issuer %q does not match %q", doc.Issuer, issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return "", "", errors.New("discovery document is missing endpoints")
+	}
+	oidcProvider.issuer, oidcProvider.docIssuer = issuer, doc.Issuer
+	oidcProvider.fetchedAt = time.Now()
+	oidcProvider.auth, oidcProvider.token, oidcProvider.jwksURI = doc.AuthorizationEndpoint, doc.TokenEndpoint, doc.JWKSURI
+	return doc.AuthorizationEndpoint, doc.TokenEndpoint, nil
+}
+
+// 按 kid 查找签名公钥，找不到时重新拉取 JWKS 以支持轮换密钥
+func jwksSigningKey(jwksURL, kid string) (interface{}, error) {
+	jwksCache.Lock()
+	defer jwksCache.Unlock()
+	if key, ok := jwksCache.keys[jwksURL][kid]; ok {
+		return key, nil
+	}
+	if at, ok := jwksCache.fetched[jwksURL]; ok && time.Since(at) < oidcJWKSRefreshMin {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := oidcGetJSON(jwksURL, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]interface{})
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			default:
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	jwksCache.keys[jwksURL] = keys
+	jwksCache.fetched[jwksURL] = time.Now()
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// 无状态的 state：nonce.过期时间.签名，多副本部署时任一实例都能校验；
+// PKCE 的 code_verifier 由 nonce 推导，不需要在服务端保存
+func oidcSign(parts ...string) string {
+	mac := hmac.New(sha256.New, []byte(currentConfig().JWTSecret))
+	mac.Write([]byte("oidc\n" + strings.Join(parts, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func oidcState(nonce string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return nonce + "." + exp + "." + oidcSign("state", nonce, exp)
+}
+
+func parseOIDCState(state string) (nonce string, ok bool) {
+	parts := strings.Split(state, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	exp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return "", false
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(oidcSign("state", parts[0], parts[1]))) {
+		return "", false
+	}
+	return parts[0], true
+}
+
+// 关联账号的凭据：账号.过期时间.sub（base64url）.签名，只在本次登录的外部身份与该账号之间有效
+func oidcLinkTicket(userID int, subject string, expires time.Time) string {
+	id, exp := strconv.Itoa(userID), strconv.FormatInt(expires.Unix(), 10)
+	sub := base64.RawURLEncoding.EncodeToString([]byte(subject))
+	return id + "." + exp + "." + sub + "." + oidcSign("link", id, exp, sub)
+}
+
+func parseOIDCLinkTicket(ticket string) (userID int, subject string, ok bool) {
+	parts := strings.Split(ticket, ".")
+	if len(parts) != 4 {
+		return 0, "", false
+	}
+	exp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return 0, "", false
+	}
+	if !hmac.Equal([]byte(parts[3]), []byte(oidcSign("link", parts[0], parts[1], parts[2]))) {
+		return 0, "", false
+	}
+	userID, err1 := strconv.Atoi(parts[0])
+	sub, err2 := base64.RawURLEncoding.DecodeString(parts[2])
+	if err1 != nil || err2 != nil {
+		return 0, "", false
+	}
+	return userID, string(sub), true
+}
+
+func oidcRandom(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func oidcCodeVerifier(nonce string) string {
+	return oidcSign("pkce", nonce)
+}
+
+// 跳转到 IdP 登录页
+func oidcLogin(c *gin.Context) {
+	conf := currentConfig().OIDC
+	if conf.Issuer == "" {
+		respondError(c, http.StatusNotFound, CodeOIDCDisabled)
+		return
+	}
+	authURL, _, err := oidcDiscover()
+	if err != nil {
+		log.Printf("OIDC discovery failed: %v", err)
+		respondError(c, http.StatusBadGateway, CodeOIDCLoginFailed)
+		return
+	}
+
+	nonce, err := oidcRandom(18)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	state := oidcState(nonce, time.Now().Add(oidcStateTTL))
+	challenge := sha256.Sum256([]byte(oidcCodeVerifier(nonce)))
+	scopes := conf.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {conf.ClientID},
+		"redirect_uri":          {conf.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(authURL, "?") {
+		sep = "&"
+	}
+	// state 同时写入 Cookie，防止攻击者诱导用户用攻击者的授权码登录
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, nonce, int(oidcStateTTL.Seconds()), "/api/auth/oidc", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, authURL+sep+q.Encode())
+}
+
+// IdP 回调：用授权码换取 ID Token，校验后登录，首次登录时创建账号
+func oidcCallback(c *gin.Context) {
+	conf := currentConfig().OIDC
+	if conf.Issuer == "" {
+		respondError(c, http.StatusNotFound, CodeOIDCDisabled)
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		log.Printf("OIDC login rejected by provider: %s %s", e, c.Query("error_description"))
+		respondError(c, http.StatusUnauthorized, CodeOIDCLoginFailed)
+		return
+	}
+	nonce, ok := parseOIDCState(c.Query("state"))
+	cookie, _ := c.Cookie(oidcStateCookie)
+	if !ok || cookie == "" || !hmac.Equal([]byte(cookie), []byte(nonce)) {
+		respondError(c, http.StatusBadRequest, CodeOIDCStateInvalid)
+		return
+	}
+	c.SetCookie(oidcStateCookie, "", -1, "/api/auth/oidc", "", c.Request.TLS != nil, true)
+	code := c.Query("code")
+	if code == "" {
+		respondError(c, http.StatusBadRequest, CodeOIDCStateInvalid)
+		return
+	}
+
+	claims, err := oidcExchange(conf, code, nonce)
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		respondError(c, http.StatusUnauthorized, CodeOIDCLoginFailed)
+		return
+	}
+	user, created, err := provisionOIDCUser(conf, claims)
+	var link *oidcLinkRequired
+	if errors.As(err, &link) {
+		ticket := oidcLinkTicket(link.userID, claimString(claims, "sub"), time.Now().Add(oidcLinkTicketTTL))
+		if conf.SuccessRedirect != "" {
+			fragment := url.Values{"link_ticket": {ticket}, "username": {link.username}}
+			c.Redirect(http.StatusFound, conf.SuccessRedirect+"#"+fragment.Encode())
+			return
+		}
+		writeError(c, http.StatusConflict, CodeOIDCLinkRequired, localize(CodeOIDCLinkRequired, requestLang(c)),
+			gin.H{"link_ticket": ticket, "username": link.username})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to provision OIDC user: %v", err)
+		respondError(c, http.StatusInternalServerError, CodeOIDCLoginFailed)
+		return
+	}
+	if user.Status != "active" {
+		respondError(c, http.StatusForbidden, CodeUserDisabled)
+		return
+	}
+	if created {
+		recordAudit(c, "oidc_signup", "user", user.ID, gin.H{"issuer": conf.Issuer, "subject": claims["sub"]})
+	}
+
+	tokens, err := issueToken(c, user, 0, accessTokenTTL())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	if conf.SuccessRedirect != "" {
+		c.Redirect(http.StatusFound, conf.SuccessRedirect+"#"+tokens.fragment().Encode())
+		return
+	}
+	respondOK(c, http.StatusOK, tokens.response(gin.H{"user": user, "created": created}))
+}
+
+// 用同名本地账号的密码确认后关联外部身份并登录
+func oidcLinkAccount(c *gin.Context) {
+	conf := currentConfig().OIDC
+	if conf.Issuer == "" {
+		respondError(c, http.StatusNotFound, CodeOIDCDisabled)
+		return
+	}
+	var req struct {
+		LinkTicket string `json:"link_ticket" binding:"required"`
+		Password   string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	userID, subject, ok := parseOIDCLinkTicket(req.LinkTicket)
+	if !ok {
+		respondError(c, http.StatusBadRequest, CodeOIDCStateInvalid)
+		return
+	}
+
+	var passwordHash string
+	err := db.QueryRow("SELECT password_hash FROM users WHERE id = ?", userID).Scan(&passwordHash)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusUnauthorized, CodeInvalidCredentials)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeUserGetFailed)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)) != nil {
+		respondError(c, http.StatusUnauthorized, CodeInvalidCredentials)
+		return
+	}
+
+	issuer := strings.TrimRight(conf.Issuer, "/")
+	linkedID, err := linkIdentity(issuer, subject, userID)
+	if err != nil {
+		log.Printf("Failed to link OIDC identity: %v", err)
+		respondError(c, http.StatusInternalServerError, CodeOIDCLoginFailed)
+		return
+	}
+	if linkedID != userID {
+		// 同一外部身份已关联到其他账号
+		respondError(c, http.StatusConflict, CodeOIDCLoginFailed)
+		return
+	}
+	user, err := getUser(userID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeUserGetFailed)
+		return
+	}
+	if user.Status != "active" {
+		respondError(c, http.StatusForbidden, CodeUserDisabled)
+		return
+	}
+	recordAudit(c, "oidc_link", "user", user.ID, gin.H{"issuer": conf.Issuer, "subject": subject})
+
+	tokens, err := issueToken(c, user, 0, accessTokenTTL())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	respondOK(c, http.StatusOK, tokens.response(gin.H{"user": user}))
+}
+
+// 授权码换取令牌并校验 ID Token 的签名、issuer、audience 和 nonce
+func oidcExchange(conf OIDCConfig, code, nonce string) (jwt.MapClaims, error) {
+	_, tokenURL, err := oidcDiscover()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {conf.RedirectURL},
+		"code_verifier": {oidcCodeVerifier(nonce)},
+	}
+	req, err := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(conf.ClientID), url.QueryEscape(conf.ClientSecret))
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("token endpoint returned status %d: %s", resp.StatusCode, msg)
+	}
+	var result struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	oidcProvider.Lock()
+	jwksURL, docIssuer := oidcProvider.jwksURI, oidcProvider.docIssuer
+	oidcProvider.Unlock()
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(result.IDToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return jwksSigningKey(jwksURL, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384"}),
+		jwt.WithIssuer(docIssuer),
+		jwt.WithAudience(conf.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if claims["nonce"] != nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("id_token has no subject")
+	}
+	return claims, nil
+}
+
+func claimString(claims jwt.MapClaims, name string) string {
+	s, _ := claims[name].(string)
+	return strings.TrimSpace(s)
+}
+
+// 按角色声明映射本系统角色；未配置映射或没有命中时返回空字符串
+func oidcMappedRole(conf OIDCConfig, claims jwt.MapClaims) string {
+	if conf.RoleClaim == "" {
+		return ""
+	}
+	var values []string
+	switch v := claims[conf.RoleClaim].(type) {
+	case string:
+		values = strings.Fields(v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	// userRoles 按权限从高到低排列
+	best := len(userRoles)
+	for _, v := range values {
+		for i, role := range userRoles {
+			if conf.RoleMapping[v] == role && i < best {
+				best = i
+			}
+		}
+	}
+	if best == len(userRoles) {
+		return ""
+	}
+	return userRoles[best]
+}
+
+// 按 issuer+sub 查找绑定的账号，首次登录时创建或关联账号；角色声明有映射时每次登录同步角色
+func provisionOIDCUser(conf OIDCConfig, claims jwt.MapClaims) (User, bool, error) {
+	issuer := strings.TrimRight(conf.Issuer, "/")
+	subject := claimString(claims, "sub")
+	role := oidcMappedRole(conf, claims)
+	org := ""
+	if conf.OrgClaim != "" {
+		org = claimString(claims, conf.OrgClaim)
+	}
+
+	userID, err := identityUser(issuer, subject)
+	created := false
+	if err == sql.ErrNoRows {
+		userID, created, err = createOIDCUser(conf, claims, role, org)
+		if err == nil {
+			userID, err = linkIdentity(issuer, subject, userID)
+		}
+	} else if err == nil {
+		if role != "" {
+			_, err = db.Exec("UPDATE users SET role = ? WHERE id = ?", role, userID)
+		}
+		if err == nil && org != "" {
+			_, err = db.Exec("UPDATE users SET org = ? WHERE id = ?", org, userID)
+		}
+	}
+	if err != nil {
+		return User{}, false, err
+	}
+	user, err := getUser(userID)
+	return user, created, err
+}
+
+// 外部身份绑定的本地账号
+func identityUser(issuer, subject string) (int, error) {
+	var userID int
+	err := db.QueryRow("SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?", issuer, subject).Scan(&userID)
+	return userID, err
+}
+
+// 绑定外部身份；并发的首次登录已先绑定时返回已绑定的账号
+func linkIdentity(issuer, subject string, userID int) (int, error) {
+	_, err := db.Exec(`
+		INSERT INTO user_identities (issuer, subject, user_id, created_at)
+		VALUES (?, ?, ?, NOW())
+	`, issuer, subject, userID)
+	if err != nil && dialect.isDuplicate(err) {
+		return identityUser(issuer, subject)
+	}
+	return userID, err
+}
+
+// 首次登录遇到同名的本地账号，需要用户以本地密码确认后才关联
+type oidcLinkRequired struct {
+	userID   int
+	username string
+}
+
+func (e *oidcLinkRequired) Error() string {
+	return fmt.Sprintf("username %q needs local login to link", e.username)
+}
+
+// 创建本地账号；开启 link_existing_users 且存在设有密码的同名账号时返回 oidcLinkRequired
+func createOIDCUser(conf OIDCConfig, claims jwt.MapClaims, role, org string) (int, bool, error) {
+	usernameClaim := conf.UsernameClaim
+	if usernameClaim == "" {
+		usernameClaim = "preferred_username"
+	}
+	username := claimString(claims, usernameClaim)
+	if username == "" {
+		username = "oidc_" + claimString(claims, "sub")
+	}
+	nameClaim := conf.NameClaim
+	if nameClaim == "" {
+		nameClaim = "name"
+	}
+
+	if conf.LinkExistingUsers {
+		var id int
+		var passwordHash string
+		err := db.QueryRow("SELECT id, password_hash FROM users WHERE username = ?", username).Scan(&id, &passwordHash)
+		if err == nil && passwordHash != noPasswordHash {
+			return 0, false, &oidcLinkRequired{userID: id, username: username}
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return 0, false, err
+		}
+	}
+
+	if role == "" {
+		role = conf.DefaultRole
+	}
+	if !contains(userRoles, role) {
+		role = RoleStudent
+	}
+	id, err := createExternalUser(username, claimString(claims, nameClaim), role, org)
+	return id, err == nil, err
+}
+
+// 为外部身份创建没有密码的本地账号，只能通过对应的外部登录方式登录；用户名被占用时加随机后缀
+func createExternalUser(username, name, role, org string) (int, error) {
+	if r := []rune(username); len(r) > 48 {
+		username = string(r[:48])
+	}
+	if name == "" {
+		name = username
+	}
+	if r := []rune(name); len(r) > 64 {
+		name = string(r[:64])
+	}
+	candidate := username
+	for attempt := 0; ; attempt++ {
+		id, err := dialect.insertID(db, `
+			INSERT INTO users (username, name, role, status, password_hash, org, created_at)
+			VALUES (?, ?, ?, 'active', ?, ?, NOW())
+		`, candidate, name, role, noPasswordHash, org)
+		if err == nil {
+			return int(id), nil
+		}
+		if !dialect.isDuplicate(err) || attempt >= 3 {
+			return 0, err
+		}
+		suffix, err := oidcRandom(3)
+		if err != nil {
+			return 0, err
+		}
+		candidate = username + "_" + suffix
+	}
+}
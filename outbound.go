@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// 出站 HTTP 请求的代理与证书，作用于 Livego、短信、语音识别、OIDC/LTI 和对象存储等外部服务。
+// 部署在企业代理之后、外部服务使用内部 CA 签发的证书时配置
+type OutboundConfig struct {
+	// 代理地址，如 http://proxy.corp:3128；为空时使用 HTTP_PROXY/HTTPS_PROXY/NO_PROXY 环境变量
+	ProxyURL string `json:"proxy_url"`
+	// 不经过代理的主机，格式同 NO_PROXY，如 localhost、10.0.0.0/8、.corp.example.com
+	NoProxy []string `json:"no_proxy"`
+	// 追加到系统根证书的 PEM 文件
+	CAFiles []string `json:"ca_files"`
+	// 不校验服务端证书，仅用于实验环境
+	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+}
+
+// 按配置创建的出站连接，未配置时与 http.DefaultTransport 相同
+var outboundTransport = http.DefaultTransport.(*http.Transport).Clone()
+
+func validateOutbound(conf OutboundConfig) error {
+	if conf.ProxyURL != "" {
+		u, err := url.Parse(conf.ProxyURL)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid outbound proxy_url %q", conf.ProxyURL)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("unsupported outbound proxy scheme %q", u.Scheme)
+		}
+	}
+	for _, file := range conf.CAFiles {
+		if _, err := os.Stat(file); err != nil {
+			return fmt.Errorf("outbound ca_files: %w", err)
+		}
+	}
+	return nil
+}
+
+// 创建出站连接
+func newOutboundTransport(conf OutboundConfig) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if conf.ProxyURL != "" {
+		proxy := (&httpproxy.Config{
+			HTTPProxy:  conf.ProxyURL,
+			HTTPSProxy: conf.ProxyURL,
+			NoProxy:    strings.Join(conf.NoProxy, ","),
+		}).ProxyFunc()
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxy(req.URL)
+		}
+	}
+
+	if len(conf.CAFiles) == 0 && !conf.InsecureSkipVerify {
+		return transport, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(conf.CAFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, file := range conf.CAFiles {
+			pem, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", file)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if conf.InsecureSkipVerify {
+		log.Printf("Outbound TLS certificate verification is disabled; do not use this in production")
+		tlsConfig.InsecureSkipVerify = true
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// 按配置替换出站客户端的连接，需在发起外部请求之前调用
+func startOutbound() error {
+	transport, err := newOutboundTransport(currentConfig().Outbound)
+	if err != nil {
+		return err
+	}
+	outboundTransport = transport
+	httpClient.Transport = otelhttp.NewTransport(transport)
+	oidcHTTPClient.Transport = httpClient.Transport
+	return nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/Dong557799/zhibo-class/apperr"
+	"github.com/gin-gonic/gin"
+)
+
+// 权限
+const (
+	PermSessionCreate  = "session.create"
+	PermSessionManage  = "session.manage"
+	PermQuestionCreate = "question.create"
+	PermQuestionPush   = "question.push"
+	PermResultView     = "result.view"
+	PermResultExport   = "result.export"
+)
+
+var allPermissions = []string{
+	PermSessionCreate,
+	PermSessionManage,
+	PermQuestionCreate,
+	PermQuestionPush,
+	PermResultView,
+	PermResultExport,
+}
+
+// 默认权限矩阵，权限表为空时写入
+var defaultRolePermissions = map[string][]string{
+	RoleTeacher: {
+		PermSessionCreate,
+		PermSessionManage,
+		PermQuestionCreate,
+		PermQuestionPush,
+		PermResultView,
+		PermResultExport,
+	},
+	RoleStudent: {},
+}
+
+// 权限矩阵变更的跨实例通知类型
+const controlPermissions = "permissions"
+
+var (
+	permissionsMu   sync.RWMutex
+	rolePermissions = make(map[string]map[string]bool)
+)
+
+func init() {
+	// 其他副本修改权限后重新加载
+	controlHandlers[controlPermissions] = func() {
+		if err := loadPermissions(); err != nil {
+			log.Printf("Failed to reload permissions: %v", err)
+		}
+	}
+}
+
+// 从数据库加载权限矩阵，首次启动时写入默认值
+func loadPermissions() error {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM role_permissions").Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		for role, perms := range defaultRolePermissions {
+			if err := saveRolePermissions(role, perms); err != nil {
+				return err
+			}
+		}
+	}
+
+	rows, err := db.Query("SELECT role, permission FROM role_permissions")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	matrix := make(map[string]map[string]bool)
+	for rows.Next() {
+		var role, perm string
+		if err := rows.Scan(&role, &perm); err != nil {
+			return err
+		}
+		if matrix[role] == nil {
+			matrix[role] = make(map[string]bool)
+		}
+		matrix[role][perm] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	permissionsMu.Lock()
+	rolePermissions = matrix
+	permissionsMu.Unlock()
+	return nil
+}
+
+// 覆盖保存某个角色的权限
+func saveRolePermissions(role string, perms []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM role_permissions WHERE role = ?", role); err != nil {
+		return err
+	}
+	// 空权限占位行，避免角色权限被清空后下次启动又写入默认值
+	if _, err := tx.Exec("INSERT INTO role_permissions (role, permission) VALUES (?, '')", role); err != nil {
+		return err
+	}
+	for _, perm := range perms {
+		if _, err := tx.Exec("INSERT INTO role_permissions (role, permission) VALUES (?, ?)", role, perm); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// 角色是否拥有权限，管理员拥有全部权限
+func hasPermission(role, perm string) bool {
+	if role == RoleAdmin {
+		return true
+	}
+	permissionsMu.RLock()
+	defer permissionsMu.RUnlock()
+	return rolePermissions[role][perm]
+}
+
+// 当前用户是否拥有权限，API Key 按其 scopes 判断
+func (u *AuthUser) can(perm string) bool {
+	if u.APIKeyID != 0 {
+		return contains(u.Scopes, perm)
+	}
+	return hasPermission(u.Role, perm)
+}
+
+// 要求登录用户拥有指定权限，需在 authRequired 之后使用
+func requirePermission(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := currentUser(c)
+		if user == nil || !user.can(perm) {
+			respondError(c, http.StatusForbidden, CodeForbidden)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// 会话的授课老师，0 表示升级前创建、尚未指定老师的会话
+func sessionTeacher(sessionID int) (int, error) {
+	var teacherID int
+	err := db.QueryRow("SELECT teacher_id FROM live_sessions WHERE id = ?", sessionID).Scan(&teacherID)
+	if err == sql.ErrNoRows {
+		return 0, domainError(apperr.ErrNotFound, CodeSessionNotFound)
+	}
+	return teacherID, err
+}
+
+// 管理员或会话的授课老师可以管理会话，会话不存在时返回 ErrNotFound，无权管理时返回 ErrForbidden
+func authorizeSessionOwner(user *AuthUser, sessionID int) error {
+	teacherID, err := sessionTeacher(sessionID)
+	if err != nil {
+		return err
+	}
+	if user.Role != RoleAdmin && (teacherID == 0 || teacherID != user.ID) {
+		return apperr.ErrForbidden
+	}
+	return nil
+}
+
+// 要求登录用户是路由参数 id 对应会话的授课老师或管理员，需在 authRequired 之后使用
+func requireSessionOwner() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionID, ok := intParam(c, "id")
+		if !ok {
+			c.Abort()
+			return
+		}
+		if err := authorizeSessionOwner(currentUser(c), sessionID); err != nil {
+			respondServiceError(c, err)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// 获取权限矩阵
+func getPermissionMatrix(c *gin.Context) {
+	permissionsMu.RLock()
+	matrix := make(map[string][]string)
+	for _, role := range userRoles {
+		if role == RoleAdmin {
+			continue
+		}
+		perms := []string{}
+		for perm := range rolePermissions[role] {
+			if perm != "" {
+				perms = append(perms, perm)
+			}
+		}
+		sort.Strings(perms)
+		matrix[role] = perms
+	}
+	permissionsMu.RUnlock()
+
+	respondOK(c, http.StatusOK, gin.H{
+		"permissions": allPermissions,
+		"roles":       matrix,
+	})
+}
+
+// 修改角色权限
+func updateRolePermissions(c *gin.Context) {
+	role := c.Param("role")
+	if !contains(userRoles, role) || role == RoleAdmin {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "role")
+		return
+	}
+
+	var req struct {
+		Permissions []string `json:"permissions" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	for _, perm := range req.Permissions {
+		if !contains(allPermissions, perm) {
+			respondError(c, http.StatusBadRequest, CodeUnknownPermission, perm)
+			return
+		}
+	}
+
+	if err := saveRolePermissions(role, req.Permissions); err != nil {
+		respondError(c, http.StatusInternalServerError, CodePermissionUpdateFailed)
+		return
+	}
+	if err := loadPermissions(); err != nil {
+		respondError(c, http.StatusInternalServerError, CodePermissionUpdateFailed)
+		return
+	}
+	publishControl(controlPermissions)
+
+	recordAudit(c, "update_permissions", "role", 0, gin.H{"role": role, "permissions": req.Permissions})
+	respondOK(c, http.StatusOK, gin.H{"role": role, "permissions": req.Permissions})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxPickCount = 50
+
+// 随机点名记录，保存候选名单以便事后核查公平性
+type SessionPick struct {
+	ID              int       `json:"id"`
+	SessionID       int       `json:"session_id"`
+	PickedBy        int       `json:"picked_by"`
+	ExcludePrevious bool      `json:"exclude_previous"`
+	Candidates      []int     `json:"candidates"`
+	Winners         []int     `json:"winners"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// 从在线学生中随机抽取，可排除本场已被抽中的学生
+func pickStudents(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Count           int  `json:"count"`
+		ExcludePrevious bool `json:"exclude_previous"`
+	}
+	// 请求体可以为空，默认抽取 1 人
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		respondBindError(c, err)
+		return
+	}
+	if req.Count == 0 {
+		req.Count = 1
+	}
+	if req.Count < 1 || req.Count > maxPickCount {
+		respondError(c, http.StatusBadRequest, CodePickCountInvalid, maxPickCount)
+		return
+	}
+
+	if !requireLiveSession(c, sessionID) {
+		return
+	}
+
+	candidates, err := onlineUserIDs(sessionID, RoleStudent)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodePresenceGetFailed)
+		return
+	}
+	if req.ExcludePrevious {
+		previous, err := previousWinners(sessionID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodePickFailed)
+			return
+		}
+		eligible := candidates[:0]
+		for _, id := range candidates {
+			if !previous[id] {
+				eligible = append(eligible, id)
+			}
+		}
+		candidates = eligible
+	}
+	if len(candidates) == 0 {
+		respondError(c, http.StatusBadRequest, CodePickNoCandidates)
+		return
+	}
+
+	shuffled := append([]int(nil), candidates...)
+	rand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	count := req.Count
+	if count > len(shuffled) {
+		count = len(shuffled)
+	}
+
+	pick := SessionPick{
+		SessionID:       sessionID,
+		ExcludePrevious: req.ExcludePrevious,
+		Candidates:      candidates,
+		Winners:         shuffled[:count],
+		CreatedAt:       time.Now().UTC(),
+	}
+	if user := currentUser(c); user != nil {
+		pick.PickedBy = user.ID
+	}
+
+	candidatesJSON, _ := json.Marshal(pick.Candidates)
+	winnersJSON, _ := json.Marshal(pick.Winners)
+	id, err := dialect.insertID(db, `
+		INSERT INTO session_picks (session_id, picked_by, exclude_previous, candidates, winners, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, sessionID, pick.PickedBy, pick.ExcludePrevious, string(candidatesJSON), string(winnersJSON), pick.CreatedAt)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodePickFailed)
+		return
+	}
+	pick.ID = int(id)
+
+	// 客户端收到后播放抽奖动画，最终停在 winners 上
+	hub.broadcast(sessionRoom(sessionID), Message{Type: "pick", Data: gin.H{
+		"pick_id":    pick.ID,
+		"winners":    pick.Winners,
+		"candidates": len(pick.Candidates),
+	}})
+
+	respondOK(c, http.StatusCreated, pick)
+}
+
+// 本场已被抽中的学生
+func previousWinners(sessionID int) (map[int]bool, error) {
+	rows, err := db.Query("SELECT winners FROM session_picks WHERE session_id = ?", sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	winners := make(map[int]bool)
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var ids []int
+		json.Unmarshal([]byte(raw), &ids)
+		for _, id := range ids {
+			winners[id] = true
+		}
+	}
+	return winners, rows.Err()
+}
+
+// 本场的点名记录
+func listSessionPicks(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, session_id, picked_by, exclude_previous, candidates, winners, created_at
+		FROM session_picks
+		WHERE session_id = ?
+		ORDER BY id
+	`, sessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodePickFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	picks := []SessionPick{}
+	for rows.Next() {
+		var p SessionPick
+		var candidates, winners string
+		if err := rows.Scan(&p.ID, &p.SessionID, &p.PickedBy, &p.ExcludePrevious, &candidates, &winners, &p.CreatedAt); err != nil {
+			respondError(c, http.StatusInternalServerError, CodePickFailed)
+			return
+		}
+		json.Unmarshal([]byte(candidates), &p.Candidates)
+		json.Unmarshal([]byte(winners), &p.Winners)
+		p.CreatedAt = p.CreatedAt.In(loc)
+		picks = append(picks, p)
+	}
+
+	respondOK(c, http.StatusOK, picks)
+}
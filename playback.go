@@ -0,0 +1,375 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	playbackHeartbeat       = 15 * time.Second
+	playbackPlayerTTL       = 3 * playbackHeartbeat // 超过该时间没有心跳的播放器视为已停止
+	playbackKeyTTL          = 24 * time.Hour
+	defaultPlaybackTokenTTL = 4 * time.Hour
+)
+
+// 播放令牌配置。令牌与学生绑定，同一学生同时在播的播放器数量受限，防止付费课程账号共享
+type PlaybackConfig struct {
+	MaxPlayers   int `json:"max_players"`   // 为 0 时使用 1
+	TokenSeconds int `json:"token_seconds"` // 令牌有效期，为 0 时使用 4 小时
+
+	// 按地区划分的播放域名；region_header 为反向代理或 CDN 写入客户端地区的请求头，如 X-Geo-Region
+	Domains      []PlayDomain `json:"domains"`
+	RegionHeader string       `json:"region_header"`
+}
+
+// 播放令牌的内容，格式为 session.user.expires.sig
+type playbackClaims struct {
+	SessionID int
+	UserID    int
+	Expires   int64
+}
+
+// 未启用 Redis 时，在本实例内记录播放器最近一次心跳
+var (
+	playersMu sync.Mutex
+	players   = make(map[string]map[string]time.Time)
+)
+
+func playbackMaxPlayers() int {
+	if n := currentConfig().Playback.MaxPlayers; n > 0 {
+		return n
+	}
+	return 1
+}
+
+func playbackTokenTTL() time.Duration {
+	if secs := currentConfig().Playback.TokenSeconds; secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return defaultPlaybackTokenTTL
+}
+
+func playbackToken(sessionID, userID int, expires time.Time) string {
+	payload := fmt.Sprintf("%d.%d.%d", sessionID, userID, expires.Unix())
+	return payload + "." + playbackSignature(payload)
+}
+
+func playbackSignature(payload string) string {
+	mac := hmac.New(sha256.New, []byte(currentConfig().JWTSecret))
+	mac.Write([]byte("playback\n" + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func parsePlaybackToken(token string) (playbackClaims, bool) {
+	var claims playbackClaims
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 || !hmac.Equal([]byte(token[i+1:]), []byte(playbackSignature(token[:i]))) {
+		return claims, false
+	}
+	parts := strings.Split(token[:i], ".")
+	if len(parts) != 3 {
+		return claims, false
+	}
+	var err1, err2, err3 error
+	claims.SessionID, err1 = strconv.Atoi(parts[0])
+	claims.UserID, err2 = strconv.Atoi(parts[1])
+	claims.Expires, err3 = strconv.ParseInt(parts[2], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || time.Now().Unix() > claims.Expires {
+		return claims, false
+	}
+	return claims, true
+}
+
+func playersKey(sessionID, userID int) string {
+	return fmt.Sprintf("zhibo:players:%d:%d", sessionID, userID)
+}
+
+// 登记播放器心跳。已在播的播放器直接续期；新播放器在达到上限时被拒绝，
+// 返回当前在播的播放器数量
+func trackPlayer(claims playbackClaims, playerID string, seen time.Time) (bool, int, error) {
+	key := playersKey(claims.SessionID, claims.UserID)
+	limit := playbackMaxPlayers()
+	cutoff := time.Now().Add(-playbackPlayerTTL)
+
+	if redisClient == nil {
+		playersMu.Lock()
+		defer playersMu.Unlock()
+		active := players[key]
+		if active == nil {
+			active = make(map[string]time.Time)
+			players[key] = active
+		}
+		for id, last := range active {
+			if last.Before(cutoff) {
+				delete(active, id)
+			}
+		}
+		if _, ok := active[playerID]; !ok && len(active) >= limit {
+			return false, len(active), nil
+		}
+		if seen.After(active[playerID]) {
+			active[playerID] = seen
+		}
+		return true, len(active), nil
+	}
+
+	// 多实例共享 Redis 哈希，字段为播放器 ID，值为最近心跳时间
+	entries, err := redisClient.HGetAll(key).Result()
+	if err != nil {
+		return false, 0, err
+	}
+	active := 0
+	known := false
+	for id, raw := range entries {
+		last, _ := strconv.ParseInt(raw, 10, 64)
+		if time.Unix(last, 0).Before(cutoff) {
+			redisClient.HDel(key, id)
+			continue
+		}
+		active++
+		known = known || id == playerID
+	}
+	if !known && active >= limit {
+		return false, active, nil
+	}
+	if err := redisClient.HSet(key, playerID, seen.Unix()).Err(); err != nil {
+		return false, active, err
+	}
+	redisClient.Expire(key, playbackKeyTTL)
+	if !known {
+		active++
+	}
+	return true, active, nil
+}
+
+// 为当前用户签发会话的播放令牌，返回带令牌的播放地址；audio_only=true 时只返回纯音频地址
+func createPlaybackToken(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	user := currentUser(c)
+
+	var streamKey, status string
+	var courseID int
+	err := db.QueryRow("SELECT stream_key, status, course_id FROM live_sessions WHERE id = ?", sessionID).Scan(&streamKey, &status, &courseID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, CodeSessionNotFound)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		}
+		return
+	}
+	if status == "ended" {
+		respondError(c, http.StatusConflict, CodeSessionAlreadyEnded)
+		return
+	}
+	if user.Role == RoleStudent && !requireCourseAccess(c, courseID, sessionID, user.ID) {
+		return
+	}
+
+	settings, err := loadSessionSettings(sessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSettingsGetFailed)
+		return
+	}
+	watermark, err := playerWatermark(settings, user.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+
+	expires := time.Now().Add(playbackTokenTTL()).Truncate(time.Second)
+	token := playbackToken(sessionID, user.ID, expires)
+	audioOnly := audioVariantEnabled(settings) &&
+		(c.Query("audio_only") == "true" || (settings.StudentAudioOnly && user.Role == RoleStudent))
+	region := playRegion(c)
+	urls := withAudioVariant(filterPlayURLs(getPlayURLs(streamKey, region), settings), streamKey, region, settings, audioOnly)
+	for protocol, u := range urls {
+		urls[protocol] = u + "?token=" + url.QueryEscape(token)
+	}
+	streams, err := liveStreams(sessionID, streamKey, status, region, settings)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodePublisherGetFailed)
+		return
+	}
+	if audioOnly {
+		streams = []LiveStream{} // 纯音频流只包含主讲画面的声音
+	}
+	for _, s := range streams {
+		for protocol, u := range s.PlayURLs {
+			s.PlayURLs[protocol] = u + "?token=" + url.QueryEscape(token)
+		}
+	}
+
+	respondOK(c, http.StatusOK, gin.H{
+		"token":              token,
+		"expires_at":         expires.In(requestLocation(c)),
+		"play_urls":          urls,
+		"audio_only":         audioOnly,
+		"region":             region,
+		"streams":            streams,
+		"max_players":        playbackMaxPlayers(),
+		"heartbeat_interval": int(playbackHeartbeat.Seconds()),
+		"watermark":          watermark,
+	})
+}
+
+// 播放器心跳，超出同时播放数量时返回 409，播放器应停止播放
+func playbackHeartbeatHandler(c *gin.Context) {
+	var req struct {
+		Token    string `json:"token" binding:"required"`
+		PlayerID string `json:"player_id" binding:"required,max=64"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	claims, ok := parsePlaybackToken(req.Token)
+	if !ok {
+		respondError(c, http.StatusForbidden, CodePlaybackTokenInvalid)
+		return
+	}
+	if !checkPlaybackNetwork(c, claims) {
+		return
+	}
+	checkPlayer(c, claims, req.PlayerID)
+}
+
+// 供 CDN 或边缘节点回源鉴权，如 nginx auth_request；stream 为播放的流名称，也可直接传请求路径
+// 如 /live/<stream_key>.m3u8，令牌只能播放签发时对应会话的流；未传 player_id 时按客户端 IP 计数
+func authorizePlayback(c *gin.Context) {
+	claims, ok := authorizeStreamToken(c, c.Query("token"), c.Query("stream"))
+	if !ok {
+		return
+	}
+	playerID := c.Query("player_id")
+	if playerID == "" {
+		playerID = c.ClientIP()
+	}
+	checkPlayer(c, claims, playerID)
+}
+
+// 校验播放令牌能否播放 stream 对应的流，并检查持有者所在的网络；不允许时写入错误响应
+func authorizeStreamToken(c *gin.Context, token, stream string) (playbackClaims, bool) {
+	claims, ok := parsePlaybackToken(token)
+	if !ok {
+		respondError(c, http.StatusForbidden, CodePlaybackTokenInvalid)
+		return claims, false
+	}
+	sessionID, err := streamSession(streamName(stream))
+	if err != nil && err != sql.ErrNoRows {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return claims, false
+	}
+	if err == sql.ErrNoRows || sessionID != claims.SessionID {
+		respondError(c, http.StatusForbidden, CodePlaybackTokenInvalid)
+		return claims, false
+	}
+	return claims, checkPlaybackNetwork(c, claims)
+}
+
+// 从流名称或播放地址路径中取出推流码，去掉文件扩展名和纯音频流后缀
+func streamName(stream string) string {
+	if i := strings.Index(stream, "/live/"); i >= 0 {
+		stream = stream[i+len("/live/"):]
+	}
+	stream = strings.TrimPrefix(stream, "/")
+	if i := strings.IndexByte(stream, '/'); i >= 0 {
+		stream = stream[:i] // HLS 分片位于 /live/<stream_key>/ 下
+	}
+	if i := strings.IndexByte(stream, '.'); i >= 0 {
+		stream = stream[:i]
+	}
+	return strings.TrimSuffix(stream, audioVariantSuffix)
+}
+
+// 查找推流码所属的会话，包括主讲画面、连麦推流端和分组讨论的流
+func streamSession(streamKey string) (int, error) {
+	if streamKey == "" {
+		return 0, sql.ErrNoRows
+	}
+	var sessionID int
+	err := db.QueryRow(`
+		SELECT id FROM live_sessions WHERE stream_key = ?
+		UNION ALL SELECT session_id FROM session_publishers WHERE stream_key = ?
+		UNION ALL SELECT session_id FROM breakout_groups WHERE stream_key = ?
+	`, streamKey, streamKey, streamKey).Scan(&sessionID)
+	return sessionID, err
+}
+
+func checkPlayer(c *gin.Context, claims playbackClaims, playerID string) {
+	allowed, active, err := trackPlayer(claims, playerID, time.Now())
+	if err != nil {
+		log.Printf("Failed to track player for user %d: %v", claims.UserID, err)
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	if !allowed {
+		respondError(c, http.StatusConflict, CodePlaybackLimitExceeded, active)
+		return
+	}
+	respondOK(c, http.StatusOK, gin.H{
+		"session_id":         claims.SessionID,
+		"players":            active,
+		"heartbeat_interval": int(playbackHeartbeat.Seconds()),
+	})
+}
+
+// 导入 CDN 访问日志，每条记录按客户端 IP 视为一个播放器，返回超出限制的学生
+func adminIngestPlaybackLogs(c *gin.Context) {
+	var req struct {
+		Records []struct {
+			Token    string    `json:"token" binding:"required"`
+			ClientIP string    `json:"client_ip" binding:"required"`
+			Time     time.Time `json:"time" binding:"required"`
+		} `json:"records" binding:"required,dive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	type violation struct {
+		SessionID int `json:"session_id"`
+		UserID    int `json:"user_id"`
+		Players   int `json:"players"`
+	}
+	accepted := 0
+	violations := []violation{}
+	seen := make(map[string]bool)
+	cutoff := time.Now().Add(-playbackPlayerTTL)
+	for _, r := range req.Records {
+		claims, ok := parsePlaybackToken(r.Token)
+		if !ok || r.Time.Before(cutoff) {
+			continue
+		}
+		accepted++
+		allowed, active, err := trackPlayer(claims, r.ClientIP, r.Time)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeInternal)
+			return
+		}
+		key := playersKey(claims.SessionID, claims.UserID)
+		if !allowed && !seen[key] {
+			seen[key] = true
+			violations = append(violations, violation{claims.SessionID, claims.UserID, active + 1})
+			log.Printf("Playback limit exceeded by user %d in session %d", claims.UserID, claims.SessionID)
+		}
+	}
+
+	respondOK(c, http.StatusOK, gin.H{"accepted": accepted, "violations": violations})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 按地区或运营商划分的播放域名，分校区或外地学生就近拉流
+type PlayDomain struct {
+	Region string   `json:"region"` // 如 north-campus、telecom
+	CIDRs  []string `json:"cidrs"`  // 归属该地区的客户端 IP 段
+	// 各协议的地址前缀，如 https://bj.live.example.com/live；为空时使用默认地址
+	RTMP string `json:"rtmp"`
+	FLV  string `json:"flv"`
+	HLS  string `json:"hls"`
+
+	// 由 CIDRs 解析得到，随配置一起替换
+	prefixes []netip.Prefix
+}
+
+// 校验并解析播放域名配置
+func parsePlayDomains(domains []PlayDomain) error {
+	seen := make(map[string]bool)
+	for i := range domains {
+		d := &domains[i]
+		if d.Region == "" {
+			return fmt.Errorf("playback.domains[%d]: region is required", i)
+		}
+		if seen[d.Region] {
+			return fmt.Errorf("playback.domains: duplicate region %q", d.Region)
+		}
+		seen[d.Region] = true
+		for _, cidr := range d.CIDRs {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return fmt.Errorf("playback.domains[%s]: %w", d.Region, err)
+			}
+			d.prefixes = append(d.prefixes, prefix.Masked())
+		}
+	}
+	return nil
+}
+
+func findPlayDomain(region string) (PlayDomain, bool) {
+	for _, d := range currentConfig().Playback.Domains {
+		if d.Region == region {
+			return d, true
+		}
+	}
+	return PlayDomain{}, false
+}
+
+// 选择客户端使用的播放地区：显式的 region 参数优先，其次是反向代理或 CDN 的 GeoIP 模块写入的请求头，
+// 最后按客户端 IP 匹配 IP 段，IP 段重叠时取最长前缀；都不匹配时返回空字符串，使用默认地址
+func playRegion(c *gin.Context) string {
+	conf := currentConfig().Playback
+	if len(conf.Domains) == 0 {
+		return ""
+	}
+	if region := c.Query("region"); region != "" {
+		if _, ok := findPlayDomain(region); ok {
+			return region
+		}
+	}
+	if conf.RegionHeader != "" {
+		if region := c.GetHeader(conf.RegionHeader); region != "" {
+			if _, ok := findPlayDomain(region); ok {
+				return region
+			}
+		}
+	}
+	addr, err := netip.ParseAddr(c.ClientIP())
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	best, bestBits := "", -1
+	for _, d := range conf.Domains {
+		for _, prefix := range d.prefixes {
+			if prefix.Contains(addr) && prefix.Bits() > bestBits {
+				best, bestBits = d.Region, prefix.Bits()
+			}
+		}
+	}
+	return best
+}
+
+// 用地区的地址前缀替换默认播放地址
+func regionalPlayURLs(urls map[string]string, streamKey, region string) map[string]string {
+	d, ok := findPlayDomain(region)
+	if !ok {
+		return urls
+	}
+	for protocol, base := range map[string]string{"rtmp": d.RTMP, "flv": d.FLV, "hls": d.HLS} {
+		if base == "" {
+			continue
+		}
+		u := strings.TrimRight(base, "/") + "/" + streamKey
+		switch protocol {
+		case "flv":
+			u += ".flv"
+		case "hls":
+			u += ".m3u8"
+		}
+		urls[protocol] = u
+	}
+	return urls
+}
+
+// 已配置的播放地区和按当前请求选出的地区，供客户端提供线路切换
+func listPlayRegions(c *gin.Context) {
+	regions := []string{}
+	for _, d := range currentConfig().Playback.Domains {
+		regions = append(regions, d.Region)
+	}
+	respondOK(c, http.StatusOK, gin.H{
+		"regions":  regions,
+		"selected": playRegion(c),
+	})
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	presenceRefresh = 30 * time.Second
+	presenceTTL     = 3 * presenceRefresh // 超过该时间未刷新的连接视为已断开
+	presenceKeyTTL  = 24 * time.Hour
+)
+
+// 在线连接记录，按会话存放在 Redis 哈希中，字段为连接ID
+type presenceEntry struct {
+	UserID   int    `json:"user_id"`
+	Role     string `json:"role"`
+	Instance string `json:"instance"`
+	LastSeen int64  `json:"last_seen"`
+}
+
+// 在线用户
+type OnlineUser struct {
+	UserID   int        `json:"user_id"`
+	Role     string     `json:"role"`
+	JoinedAt *time.Time `json:"joined_at,omitempty"` // 本场首次进入时间，仅 Redis 模式下记录
+}
+
+var (
+	presenceMu      sync.Mutex
+	presenceClients = make(map[*Client]bool)
+	connCounter     uint64
+)
+
+func presenceKey(sessionID int) string   { return fmt.Sprintf("zhibo:presence:%d", sessionID) }
+func attendanceKey(sessionID int) string { return fmt.Sprintf("zhibo:attendance:%d", sessionID) }
+
+// 记录连接上线及首次进入时间
+func trackPresence(c *Client) {
+	if redisClient == nil {
+		return
+	}
+
+	c.connID = fmt.Sprintf("%s:%d", instanceID, atomic.AddUint64(&connCounter, 1))
+	presenceMu.Lock()
+	presenceClients[c] = true
+	presenceMu.Unlock()
+
+	writePresence(c)
+	key := attendanceKey(c.sessionID)
+	if err := redisClient.HSetNX(key, strconv.Itoa(c.userID), time.Now().Unix()).Err(); err != nil {
+		log.Printf("Failed to record attendance for user %d: %v", c.userID, err)
+	}
+	redisClient.Expire(key, presenceKeyTTL)
+}
+
+// 在数据库中记录首次进入会话的时间，用于课后考勤
+func recordAttendance(sessionID, userID int, role string) {
+	_, err := db.Exec(dialect.insertIgnore(`
+		INSERT INTO session_attendance (session_id, user_id, role, joined_at)
+		VALUES (?, ?, ?, ?)`,
+	), sessionID, userID, role, time.Now().UTC())
+	if err != nil {
+		log.Printf("Failed to save attendance for user %d: %v", userID, err)
+	}
+}
+
+// 移除断开的连接
+func untrackPresence(c *Client) {
+	if redisClient == nil || c.connID == "" {
+		return
+	}
+
+	presenceMu.Lock()
+	delete(presenceClients, c)
+	presenceMu.Unlock()
+
+	if err := redisClient.HDel(presenceKey(c.sessionID), c.connID).Err(); err != nil {
+		log.Printf("Failed to remove presence for user %d: %v", c.userID, err)
+	}
+}
+
+func writePresence(c *Client) {
+	entry, _ := json.Marshal(presenceEntry{
+		UserID:   c.userID,
+		Role:     c.role,
+		Instance: instanceID,
+		LastSeen: time.Now().Unix(),
+	})
+	key := presenceKey(c.sessionID)
+	if err := redisClient.HSet(key, c.connID, entry).Err(); err != nil {
+		log.Printf("Failed to write presence for user %d: %v", c.userID, err)
+		return
+	}
+	redisClient.Expire(key, presenceKeyTTL)
+}
+
+// 定期刷新本实例连接的在线时间，实例异常退出后其记录会自然过期
+func runPresenceRefresher() {
+	if redisClient == nil {
+		return
+	}
+
+	ticker := time.NewTicker(presenceRefresh)
+	defer ticker.Stop()
+	for range ticker.C {
+		presenceMu.Lock()
+		clients := make([]*Client, 0, len(presenceClients))
+		for c := range presenceClients {
+			clients = append(clients, c)
+		}
+		presenceMu.Unlock()
+
+		for _, c := range clients {
+			writePresence(c)
+		}
+	}
+}
+
+// 会话中在线的用户，未启用 Redis 时使用本实例的连接
+func onlineUsers(sessionID int, role string) ([]OnlineUser, error) {
+	if redisClient == nil {
+		var users []OnlineUser
+		seen := make(map[int]bool)
+		for _, c := range hub.clients(sessionRoom(sessionID)) {
+			if (role != "" && c.role != role) || seen[c.userID] {
+				continue
+			}
+			seen[c.userID] = true
+			users = append(users, OnlineUser{UserID: c.userID, Role: c.role})
+		}
+		return users, nil
+	}
+
+	key := presenceKey(sessionID)
+	entries, err := redisClient.HGetAll(key).Result()
+	if err != nil {
+		return nil, err
+	}
+	joined, err := redisClient.HGetAll(attendanceKey(sessionID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-presenceTTL).Unix()
+	var users []OnlineUser
+	var stale []string
+	seen := make(map[int]bool)
+	for connID, raw := range entries {
+		var entry presenceEntry
+		if err := json.Unmarshal([]byte(raw), &entry); err != nil || entry.LastSeen < cutoff {
+			stale = append(stale, connID)
+			continue
+		}
+		if (role != "" && entry.Role != role) || seen[entry.UserID] {
+			continue
+		}
+		seen[entry.UserID] = true
+
+		user := OnlineUser{UserID: entry.UserID, Role: entry.Role}
+		if ts, err := strconv.ParseInt(joined[strconv.Itoa(entry.UserID)], 10, 64); err == nil {
+			joinedAt := time.Unix(ts, 0)
+			user.JoinedAt = &joinedAt
+		}
+		users = append(users, user)
+	}
+	if len(stale) > 0 {
+		redisClient.HDel(key, stale...)
+	}
+	return users, nil
+}
+
+// 在线用户ID
+func onlineUserIDs(sessionID int, role string) ([]int, error) {
+	users, err := onlineUsers(sessionID, role)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.UserID)
+	}
+	return ids, nil
+}
+
+// 获取会话在线用户，可按角色过滤
+func getSessionPresence(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	if !sessionExists(c, sessionID) {
+		return
+	}
+
+	users, err := onlineUsers(sessionID, c.Query("role"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodePresenceGetFailed)
+		return
+	}
+	if users == nil {
+		users = []OnlineUser{}
+	}
+
+	respondOK(c, http.StatusOK, gin.H{"count": len(users), "users": users})
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 单个课时的进度
+type LessonProgress struct {
+	LessonID  int    `json:"lesson_id"`
+	Kind      string `json:"kind"`
+	Percent   int    `json:"percent"` // 录播课为观看百分比，直播课出勤即为 100
+	Completed bool   `json:"completed"`
+}
+
+// 学生在课程中的整体进度
+type StudentProgress struct {
+	StudentID        int              `json:"student_id"`
+	Name             string           `json:"name,omitempty"`
+	Lessons          []LessonProgress `json:"lessons"`
+	CompletedLessons int              `json:"completed_lessons"`
+	TotalLessons     int              `json:"total_lessons"`
+	Percent          int              `json:"percent"` // 已完成课时占比
+	QuizzesCompleted int              `json:"quizzes_completed"`
+	QuizzesTotal     int              `json:"quizzes_total"`
+}
+
+type lessonRef struct {
+	ID       int
+	Kind     string
+	Duration int
+}
+
+type lessonMark struct {
+	lessonID, studentID int
+}
+
+// 汇总课程的学习进度，课时按目录顺序排列；studentID 为 0 时统计已报名或有学习记录的所有学生
+func courseProgress(courseID, studentID int) ([]lessonRef, []StudentProgress, error) {
+	rows, err := db.Query(`
+		SELECT l.id, l.kind, l.duration_seconds
+		FROM course_lessons l
+		JOIN course_chapters ch ON ch.id = l.chapter_id
+		WHERE l.course_id = ?
+		ORDER BY ch.position, ch.id, l.position, l.id
+	`, courseID)
+	if err != nil {
+		return nil, nil, err
+	}
+	lessons := []lessonRef{}
+	for rows.Next() {
+		var l lessonRef
+		if err := rows.Scan(&l.ID, &l.Kind, &l.Duration); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		lessons = append(lessons, l)
+	}
+	rows.Close()
+
+	var quizzes int
+	if err := db.QueryRow("SELECT COUNT(*) FROM exams WHERE course_id = ? AND status <> ?", courseID, ExamDraft).Scan(&quizzes); err != nil {
+		return nil, nil, err
+	}
+
+	students := make(map[int]*StudentProgress)
+	student := func(id int) *StudentProgress {
+		if studentID > 0 && id != studentID {
+			return nil
+		}
+		if students[id] == nil {
+			students[id] = &StudentProgress{StudentID: id}
+		}
+		return students[id]
+	}
+	if studentID > 0 {
+		student(studentID)
+	} else {
+		rows, err = db.Query("SELECT student_id FROM course_enrollments WHERE course_id = ?", courseID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, nil, err
+			}
+			student(id)
+		}
+		rows.Close()
+	}
+
+	// 录播课观看位置和手动完成标记
+	watched := make(map[lessonMark]int)
+	completed := make(map[lessonMark]bool)
+	rows, err = db.Query(`
+		SELECT p.lesson_id, p.student_id, p.position_seconds, p.completed_at IS NOT NULL
+		FROM lesson_progress p
+		JOIN course_lessons l ON l.id = p.lesson_id
+		WHERE l.course_id = ?
+	`, courseID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var m lessonMark
+		var pos int
+		var done bool
+		if err := rows.Scan(&m.lessonID, &m.studentID, &pos, &done); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		if student(m.studentID) == nil {
+			continue
+		}
+		watched[m] = pos
+		completed[m] = done
+	}
+	rows.Close()
+
+	// 直播课出勤，出勤补课会话也算
+	rows, err = db.Query(`
+		SELECT l.id, a.user_id
+		FROM course_lessons l
+		JOIN live_sessions s ON s.id = l.session_id OR s.makeup_for = l.session_id
+		JOIN session_attendance a ON a.session_id = s.id
+		WHERE l.course_id = ? AND a.role = ?
+	`, courseID, RoleStudent)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var m lessonMark
+		if err := rows.Scan(&m.lessonID, &m.studentID); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		if student(m.studentID) == nil {
+			continue
+		}
+		completed[m] = true
+	}
+	rows.Close()
+
+	// 已交卷的测验
+	rows, err = db.Query(`
+		SELECT a.student_id, COUNT(*)
+		FROM exam_attempts a
+		JOIN exams e ON e.id = a.exam_id
+		WHERE e.course_id = ? AND a.submitted_at IS NOT NULL
+		GROUP BY a.student_id
+	`, courseID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var id, n int
+		if err := rows.Scan(&id, &n); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		if s := student(id); s != nil {
+			s.QuizzesCompleted = n
+		}
+	}
+	rows.Close()
+
+	result := make([]StudentProgress, 0, len(students))
+	for _, s := range students {
+		s.TotalLessons = len(lessons)
+		s.QuizzesTotal = quizzes
+		s.Lessons = make([]LessonProgress, 0, len(lessons))
+		for _, l := range lessons {
+			m := lessonMark{l.ID, s.StudentID}
+			lp := LessonProgress{LessonID: l.ID, Kind: l.Kind, Completed: completed[m]}
+			switch {
+			case lp.Completed:
+				lp.Percent = 100
+			case l.Kind == LessonVOD && l.Duration > 0:
+				lp.Percent = min(100, watched[m]*100/l.Duration)
+			}
+			if lp.Completed {
+				s.CompletedLessons++
+			}
+			s.Lessons = append(s.Lessons, lp)
+		}
+		if s.TotalLessons > 0 {
+			s.Percent = s.CompletedLessons * 100 / s.TotalLessons
+		}
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].StudentID < result[j].StudentID })
+	return lessons, result, nil
+}
+
+// 当前学生在课程中的学习进度
+func getCourseProgress(c *gin.Context) {
+	courseID, ok := intParam(c, "course_id")
+	if !ok {
+		return
+	}
+	_, progress, err := courseProgress(courseID, currentUser(c).ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSyllabusGetFailed)
+		return
+	}
+	respondOK(c, http.StatusOK, progress[0])
+}
+
+// 教师查看全班进度矩阵：每行一个学生，列与 lessons 中的课时顺序一致
+func getCourseProgressMatrix(c *gin.Context) {
+	courseID, ok := intParam(c, "course_id")
+	if !ok {
+		return
+	}
+	lessons, progress, err := courseProgress(courseID, 0)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSyllabusGetFailed)
+		return
+	}
+
+	// 补充学生姓名
+	names := make(map[int]string)
+	ids := make([]interface{}, len(progress))
+	for i, p := range progress {
+		ids[i] = p.StudentID
+	}
+	if len(ids) == 0 {
+		ids = append(ids, 0)
+	}
+	rows, err := db.Query("SELECT id, name FROM users WHERE id IN (?"+strings.Repeat(", ?", len(ids)-1)+")", ids...)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSyllabusGetFailed)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeSyllabusGetFailed)
+			return
+		}
+		names[id] = name
+	}
+	for i := range progress {
+		progress[i].Name = names[progress[i].StudentID]
+	}
+
+	lessonIDs := make([]int, len(lessons))
+	for i, l := range lessons {
+		lessonIDs[i] = l.ID
+	}
+	respondOK(c, http.StatusOK, gin.H{
+		"course_id": courseID,
+		"lessons":   lessonIDs,
+		"students":  progress,
+	})
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/Dong557799/zhibo-class/query"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+const (
+	maxProvisionUsers  = 5000
+	maxRosterCSVBytes  = 8 << 20
+	provisionedActive  = "active"
+	provisionedStopped = "disabled"
+)
+
+// 同步结果中每一行的处理方式
+const (
+	ProvisionCreated     = "created"
+	ProvisionUpdated     = "updated"
+	ProvisionUnchanged   = "unchanged"
+	ProvisionDeactivated = "deactivated"
+	ProvisionFailed      = "failed"
+)
+
+// 学校花名册中的一个用户，按 external_id（学号、工号）匹配已同步的账号
+type ProvisionUser struct {
+	ExternalID string `json:"external_id" binding:"required,max=64"`
+	Username   string `json:"username" binding:"required,max=64"`
+	Name       string `json:"name" binding:"required,max=64"`
+	Role       string `json:"role" binding:"required,oneof=teacher student"`
+	Org        string `json:"org" binding:"max=64"`
+	TimeZone   string `json:"time_zone" binding:"omitempty,timezone"`
+	Password   string `json:"password" binding:"omitempty,min=8"` // 只作为新账号的初始密码，为空时只能通过统一身份认证或验证码登录
+	Active     *bool  `json:"active"`                             // 为 false 时停用账号，默认启用
+}
+
+// 单行的同步结果，line 为请求中的序号或 CSV 行号
+type ProvisionResult struct {
+	Line     int    `json:"line"`
+	Username string `json:"username,omitempty"`
+	UserID   int    `json:"user_id,omitempty"`
+	Action   string `json:"action"`
+	Error    string `json:"error,omitempty"`
+}
+
+// 同步选项：deactivate_missing 时停用本次名单中没有的已同步账号（有 external_id 的教师和学生），
+// 指定 org 时只处理该机构的账号；link_existing 时同名且未同步过的本地账号绑定到名单中的 external_id，
+// 否则同名账号视为冲突；dry_run 时只返回结果不写入
+type provisionOptions struct {
+	DeactivateMissing bool
+	Org               string
+	LinkExisting      bool
+	DryRun            bool
+}
+
+// 按 JSON 批量创建、更新或停用教师和学生账号
+func adminProvisionUsers(c *gin.Context) {
+	var req struct {
+		Users             []ProvisionUser `json:"users" binding:"required,min=1"`
+		DeactivateMissing bool            `json:"deactivate_missing"`
+		Org               string          `json:"org" binding:"max=64"`
+		LinkExisting      bool            `json:"link_existing"`
+		DryRun            bool            `json:"dry_run"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if len(req.Users) > maxProvisionUsers {
+		respondError(c, http.StatusBadRequest, CodeProvisionTooMany, maxProvisionUsers)
+		return
+	}
+	lines := make([]int, len(req.Users))
+	for i := range lines {
+		lines[i] = i + 1
+	}
+	provisionUsers(c, req.Users, lines, provisionOptions{
+		DeactivateMissing: req.DeactivateMissing,
+		Org:               req.Org,
+		LinkExisting:      req.LinkExisting,
+		DryRun:            req.DryRun,
+	})
+}
+
+// 导入 CSV 花名册（multipart 字段 file），首行为表头：
+// external_id,username,name,role 必填，org,time_zone,password,active 可选；选项通过查询参数传入
+func adminImportUsersCSV(c *gin.Context) {
+	header, err := c.FormFile("file")
+	if err != nil {
+		if limit, ok := bodyTooLarge(err); ok {
+			respondError(c, http.StatusRequestEntityTooLarge, CodeBodyTooLarge, limit)
+			return
+		}
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "file")
+		return
+	}
+	f, err := header.Open()
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "file")
+		return
+	}
+	defer f.Close()
+
+	users, lines, err := parseRosterCSV(f)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeRosterCSVInvalid, err.Error())
+		return
+	}
+	if len(users) == 0 {
+		respondError(c, http.StatusBadRequest, CodeRosterCSVInvalid, "no rows")
+		return
+	}
+	if len(users) > maxProvisionUsers {
+		respondError(c, http.StatusBadRequest, CodeProvisionTooMany, maxProvisionUsers)
+		return
+	}
+	provisionUsers(c, users, lines, provisionOptions{
+		DeactivateMissing: c.Query("deactivate_missing") == "true",
+		Org:               c.Query("org"),
+		LinkExisting:      c.Query("link_existing") == "true",
+		DryRun:            c.Query("dry_run") == "true",
+	})
+}
+
+// 解析 CSV 花名册，返回每个用户及其所在行号；兼容 Excel 导出的 UTF-8 BOM
+func parseRosterCSV(r io.Reader) ([]ProvisionUser, []int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	head, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("missing header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range head {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		columns[name] = i
+	}
+	for _, required := range []string{"external_id", "username", "name", "role"} {
+		if _, ok := columns[required]; !ok {
+			return nil, nil, fmt.Errorf("missing column %q", required)
+		}
+	}
+
+	var users []ProvisionUser
+	var lines []int
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		if strings.Join(record, "") == "" {
+			continue
+		}
+		u := ProvisionUser{
+			ExternalID: field("external_id"),
+			Username:   field("username"),
+			Name:       field("name"),
+			Role:       strings.ToLower(field("role")),
+			Org:        field("org"),
+			TimeZone:   field("time_zone"),
+			Password:   field("password"),
+		}
+		switch strings.ToLower(field("active")) {
+		case "":
+		case "false", "0", "no", "n":
+			active := false
+			u.Active = &active
+		default:
+			active := true
+			u.Active = &active
+		}
+		users = append(users, u)
+		lines = append(lines, line)
+	}
+	return users, lines, nil
+}
+
+// 在一个事务中同步名单；单行出错只记入结果，数据库错误时整体回滚
+func provisionUsers(c *gin.Context, users []ProvisionUser, lines []int, opts provisionOptions) {
+	lang := requestLang(c)
+	tx, err := db.Begin()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeProvisionFailed)
+		return
+	}
+	defer tx.Rollback()
+
+	results := make([]ProvisionResult, 0, len(users))
+	counts := make(map[string]int)
+	seenExternal := make(map[string]bool)
+	seenUsername := make(map[string]bool)
+	for i, u := range users {
+		result := ProvisionResult{Line: lines[i], Username: u.Username, Action: ProvisionFailed}
+		if opts.Org != "" && u.Org == "" {
+			u.Org = opts.Org
+		}
+		verr := binding.Validator.ValidateStruct(u)
+		switch {
+		case verr != nil:
+			var messages []string
+			for _, fe := range fieldErrors(verr, lang) {
+				messages = append(messages, fe.Message)
+			}
+			result.Error = strings.Join(messages, "; ")
+		case opts.Org != "" && u.Org != opts.Org:
+			result.Error = fmt.Sprintf("org must be %q", opts.Org)
+		case seenExternal[u.ExternalID]:
+			result.Error = "duplicate external_id"
+		case seenUsername[u.Username]:
+			result.Error = "duplicate username"
+		default:
+			result.UserID, result.Action, result.Error, err = provisionUser(tx, u, opts.LinkExisting)
+			if err != nil {
+				log.Printf("Failed to provision user %s: %v", u.Username, err)
+				respondError(c, http.StatusInternalServerError, CodeProvisionFailed)
+				return
+			}
+		}
+		seenExternal[u.ExternalID] = true
+		seenUsername[u.Username] = true
+		counts[result.Action]++
+		results = append(results, result)
+	}
+
+	if opts.DeactivateMissing {
+		deactivated, err := deactivateMissingUsers(tx, seenExternal, opts.Org)
+		if err != nil {
+			log.Printf("Failed to deactivate missing users: %v", err)
+			respondError(c, http.StatusInternalServerError, CodeProvisionFailed)
+			return
+		}
+		for _, r := range deactivated {
+			counts[r.Action]++
+			results = append(results, r)
+		}
+	}
+
+	if !opts.DryRun {
+		if err := tx.Commit(); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeProvisionFailed)
+			return
+		}
+		var changed []int
+		for _, r := range results {
+			if r.Action == ProvisionUpdated || r.Action == ProvisionDeactivated {
+				changed = append(changed, r.UserID)
+			}
+		}
+		invalidateUserStatus(changed...)
+		recordAudit(c, "provision_users", "user", 0, gin.H{
+			"counts":             counts,
+			"org":                opts.Org,
+			"deactivate_missing": opts.DeactivateMissing,
+			"link_existing":      opts.LinkExisting,
+		})
+	}
+	respondOK(c, http.StatusOK, gin.H{
+		"dry_run":     opts.DryRun,
+		"created":     counts[ProvisionCreated],
+		"updated":     counts[ProvisionUpdated],
+		"unchanged":   counts[ProvisionUnchanged],
+		"deactivated": counts[ProvisionDeactivated],
+		"failed":      counts[ProvisionFailed],
+		"results":     results,
+	})
+}
+
+// 创建或更新一个用户；rowErr 为该行的业务错误，err 为数据库错误。
+// 只按 external_id 匹配已同步的账号，同名的本地账号仅在 linkExisting 时绑定
+func provisionUser(tx *sql.Tx, u ProvisionUser, linkExisting bool) (id int, action, rowErr string, err error) {
+	status := provisionedActive
+	if u.Active != nil && !*u.Active {
+		status = provisionedStopped
+	}
+
+	var existing User
+	var externalID sql.NullString
+	find := func(where string, arg string) error {
+		return tx.QueryRow(`
+			SELECT id, username, name, role, status, time_zone, org, external_id
+			FROM users WHERE `+where, arg).
+			Scan(&existing.ID, &existing.Username, &existing.Name, &existing.Role, &existing.Status, &existing.TimeZone, &existing.Org, &externalID)
+	}
+	err = find("external_id = ?", u.ExternalID)
+	if err == sql.ErrNoRows {
+		err = find("username = ?", u.Username)
+		if err == nil {
+			// 同名账号已绑定其他学号，或未允许绑定本地账号时不能覆盖
+			if externalID.Valid {
+				return existing.ID, ProvisionFailed, "username is taken by another external_id", nil
+			}
+			if !linkExisting {
+				return existing.ID, ProvisionFailed, "username is taken by a local account", nil
+			}
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		hash := noPasswordHash
+		if u.Password != "" {
+			if hash, err = hashPassword(u.Password); err != nil {
+				return 0, "", "", err
+			}
+		}
+		newID, err := dialect.insertID(tx, `
+			INSERT INTO users (username, name, role, status, time_zone, org, external_id, password_hash, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, NOW())
+		`, u.Username, u.Name, u.Role, status, u.TimeZone, u.Org, u.ExternalID, hash)
+		if err != nil {
+			return 0, "", "", err
+		}
+		return int(newID), ProvisionCreated, "", nil
+	}
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	if existing.Role == RoleAdmin {
+		return existing.ID, ProvisionFailed, "admin accounts cannot be provisioned", nil
+	}
+	if existing.Status == "deleted" {
+		return existing.ID, ProvisionFailed, "account has been erased", nil
+	}
+	if existing.Username != u.Username {
+		var taken int
+		err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE username = ? AND id <> ?", u.Username, existing.ID).Scan(&taken)
+		if err != nil {
+			return 0, "", "", err
+		}
+		if taken > 0 {
+			return existing.ID, ProvisionFailed, "username is taken", nil
+		}
+	}
+	if existing.Username == u.Username && existing.Name == u.Name && existing.Role == u.Role && existing.Status == status &&
+		existing.TimeZone == u.TimeZone && existing.Org == u.Org && externalID.Valid {
+		return existing.ID, ProvisionUnchanged, "", nil
+	}
+	_, err = tx.Exec(`
+		UPDATE users SET username = ?, name = ?, role = ?, status = ?, time_zone = ?, org = ?, external_id = NULLIF(?, '')
+		WHERE id = ?
+	`, u.Username, u.Name, u.Role, status, u.TimeZone, u.Org, u.ExternalID, existing.ID)
+	if err != nil {
+		return 0, "", "", err
+	}
+	if status == provisionedStopped && existing.Status != provisionedStopped {
+		return existing.ID, ProvisionDeactivated, "", nil
+	}
+	return existing.ID, ProvisionUpdated, "", nil
+}
+
+// 停用名单中没有的已同步账号，只处理有 external_id 的教师和学生
+func deactivateMissingUsers(tx *sql.Tx, seen map[string]bool, org string) ([]ProvisionResult, error) {
+	q := query.New().
+		Where("external_id IS NOT NULL").
+		Where("role IN (?, ?)", RoleTeacher, RoleStudent).
+		Where("status = ?", provisionedActive).
+		Eq("org", org)
+	rows, err := tx.Query("SELECT id, username, external_id FROM users WHERE "+q.WhereSQL(), q.Args()...)
+	if err != nil {
+		return nil, err
+	}
+	var results []ProvisionResult
+	for rows.Next() {
+		var r ProvisionResult
+		var externalID string
+		if err := rows.Scan(&r.UserID, &r.Username, &externalID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if !seen[externalID] {
+			r.Action = ProvisionDeactivated
+			results = append(results, r)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, r := range results {
+		if _, err := tx.Exec("UPDATE users SET status = ? WHERE id = ?", provisionedStopped, r.UserID); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 推流档位，决定推荐给 OBS 的分辨率、码率和关键帧间隔
+type StreamProfile struct {
+	Name             string `json:"name"`
+	Width            int    `json:"width"`
+	Height           int    `json:"height"`
+	FPS              int    `json:"fps"`
+	VideoBitrateKbps int    `json:"video_bitrate_kbps"`
+	AudioBitrateKbps int    `json:"audio_bitrate_kbps"`
+	KeyframeInterval int    `json:"keyframe_interval"` // 秒，HLS 切片依赖固定的关键帧间隔
+}
+
+const defaultStreamProfile = "standard"
+
+var (
+	streamProfileNames = []string{"low", "standard", "high"}
+	streamProfiles     = map[string]StreamProfile{
+		"low":      {Name: "low", Width: 854, Height: 480, FPS: 25, VideoBitrateKbps: 1000, AudioBitrateKbps: 96, KeyframeInterval: 2},
+		"standard": {Name: "standard", Width: 1280, Height: 720, FPS: 30, VideoBitrateKbps: 2500, AudioBitrateKbps: 128, KeyframeInterval: 2},
+		"high":     {Name: "high", Width: 1920, Height: 1080, FPS: 30, VideoBitrateKbps: 4500, AudioBitrateKbps: 160, KeyframeInterval: 2},
+	}
+)
+
+// OBS 自动配置所需的推流信息
+type PublishInfo struct {
+	SessionID   int           `json:"session_id"`
+	Status      string        `json:"status"`
+	Server      string        `json:"server"`
+	StreamKey   string        `json:"stream_key"`
+	Profile     StreamProfile `json:"profile"`
+	Encoder     string        `json:"encoder"`
+	RateControl string        `json:"rate_control"`
+	// 带推流令牌的串流密钥，填入 OBS；令牌过期后需重新获取
+	PublishKey string    `json:"publish_key"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// 完整推流地址，客户端生成二维码供手机推流 App 扫码
+	QRPayload string `json:"qr_payload"`
+}
+
+const defaultPublishTokenTTL = 4 * time.Hour
+
+func publishTokenTTL() time.Duration {
+	if secs := currentConfig().PublishTokenSeconds; secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return defaultPublishTokenTTL
+}
+
+// 推流令牌与推流码绑定，以查询参数的形式附加在推流码之后
+func publishToken(streamKey string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return url.Values{"expires": {exp}, "sig": {publishSignature(streamKey, exp)}}.Encode()
+}
+
+func publishSignature(streamKey, expires string) string {
+	mac := hmac.New(sha256.New, []byte(currentConfig().JWTSecret))
+	mac.Write([]byte("publish\n" + streamKey + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 校验推流回调中的令牌
+func verifyPublishToken(streamKey, rawQuery string) bool {
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return false
+	}
+	exp := q.Get("expires")
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > expUnix {
+		return false
+	}
+	return hmac.Equal([]byte(q.Get("sig")), []byte(publishSignature(streamKey, exp)))
+}
+
+// 教师推流的 RTMP 地址，未配置时使用流媒体服务器的 RTMP 地址
+func publishServerURL() string {
+	if u := currentConfig().RTMPPublishURL; u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+	return media().RTMPURL()
+}
+
+// 获取会话的推流配置，带 publisher_id 时返回连麦推流端的推流码
+func getPublishInfo(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+
+	info := PublishInfo{SessionID: sessionID, Encoder: "x264", RateControl: "CBR"}
+	var teacherID int
+	err := db.QueryRow("SELECT stream_key, status, teacher_id FROM live_sessions WHERE id = ?", sessionID).Scan(&info.StreamKey, &info.Status, &teacherID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, CodeSessionNotFound)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		}
+		return
+	}
+	// 推流码只签发给会话的授课老师和管理员，连麦推流端的推流码也签发给指定的用户
+	user := currentUser(c)
+	allowed := user.Role == RoleAdmin || (teacherID != 0 && teacherID == user.ID)
+	if c.Query("publisher_id") != "" {
+		var status string
+		var publisherUserID sql.NullInt64
+		err := db.QueryRow("SELECT stream_key, status, user_id FROM session_publishers WHERE id = ? AND session_id = ?",
+			c.Query("publisher_id"), sessionID).Scan(&info.StreamKey, &status, &publisherUserID)
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, CodePublisherNotFound)
+			return
+		}
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodePublisherGetFailed)
+			return
+		}
+		if status == PublisherEnded {
+			info.Status = "ended"
+		}
+		allowed = allowed || (publisherUserID.Valid && int(publisherUserID.Int64) == user.ID)
+	}
+	if !allowed {
+		respondError(c, http.StatusForbidden, CodeForbidden)
+		return
+	}
+
+	settings, err := loadSessionSettings(sessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSettingsGetFailed)
+		return
+	}
+	profile, ok := streamProfiles[settings.StreamProfile]
+	if !ok {
+		profile = streamProfiles[defaultStreamProfile]
+	}
+
+	info.Server = publishServerURL()
+	info.Profile = profile
+	expires := time.Now().Add(publishTokenTTL()).Truncate(time.Second)
+	info.PublishKey = info.StreamKey + "?" + publishToken(info.StreamKey, expires)
+	info.ExpiresAt = expires.In(requestLocation(c))
+	info.QRPayload = info.Server + "/" + info.PublishKey
+	respondOK(c, http.StatusOK, info)
+}
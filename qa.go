@@ -0,0 +1,230 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 问答状态
+const (
+	QAOpen     = "open"
+	QAAnswered = "answered"
+)
+
+// 课堂问答，独立于聊天，按票数排序
+type QAItem struct {
+	ID         int        `json:"id"`
+	SessionID  int        `json:"session_id"`
+	UserID     int        `json:"user_id"`
+	Content    string     `json:"content"`
+	Votes      int        `json:"votes"`
+	Voted      bool       `json:"voted"` // 当前用户是否已投票
+	Status     string     `json:"status"`
+	Answer     string     `json:"answer,omitempty"`
+	AnsweredBy *int       `json:"answered_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	AnsweredAt *time.Time `json:"answered_at,omitempty"`
+}
+
+// 问答列表，默认按票数从高到低
+func listSessionQA(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	if !sessionExists(c, sessionID) {
+		return
+	}
+
+	userID := 0
+	if user := currentUser(c); user != nil {
+		userID = user.ID
+	}
+
+	q := `
+		SELECT q.id, q.session_id, q.user_id, q.content, q.votes, v.user_id IS NOT NULL,
+			q.status, COALESCE(q.answer, ''), q.answered_by, q.created_at, q.answered_at
+		FROM session_qa q
+		LEFT JOIN session_qa_votes v ON v.qa_id = q.id AND v.user_id = ?
+		WHERE q.session_id = ?`
+	args := []interface{}{userID, sessionID}
+	if status := c.Query("status"); status != "" {
+		q += " AND q.status = ?"
+		args = append(args, status)
+	}
+	rows, err := db.Query(q+" ORDER BY q.votes DESC, q.id", args...)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQAGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	items := []QAItem{}
+	for rows.Next() {
+		var item QAItem
+		if err := rows.Scan(&item.ID, &item.SessionID, &item.UserID, &item.Content, &item.Votes, &item.Voted,
+			&item.Status, &item.Answer, &item.AnsweredBy, &item.CreatedAt, &item.AnsweredAt); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeQAGetFailed)
+			return
+		}
+		item.CreatedAt = item.CreatedAt.In(loc)
+		item.AnsweredAt = inLocation(item.AnsweredAt, loc)
+		items = append(items, item)
+	}
+
+	respondOK(c, http.StatusOK, items)
+}
+
+// 学生提问
+func postSessionQA(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Content string `json:"content" binding:"required,max=1000"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	req.Content = strings.TrimSpace(req.Content)
+	if req.Content == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "content")
+		return
+	}
+
+	if !requireLiveSession(c, sessionID) {
+		return
+	}
+
+	item := QAItem{
+		SessionID: sessionID,
+		UserID:    currentUser(c).ID,
+		Content:   req.Content,
+		Status:    QAOpen,
+		CreatedAt: time.Now().UTC(),
+	}
+	id, err := dialect.insertID(db, `
+		INSERT INTO session_qa (session_id, user_id, content, created_at)
+		VALUES (?, ?, ?, ?)
+	`, item.SessionID, item.UserID, item.Content, item.CreatedAt)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQAPostFailed)
+		return
+	}
+	item.ID = int(id)
+
+	hub.broadcast(sessionRoom(sessionID), Message{Type: "qa_posted", From: item.UserID, Data: item})
+	item.CreatedAt = item.CreatedAt.In(requestLocation(c))
+	respondOK(c, http.StatusCreated, item)
+}
+
+// 投票或取消投票，每人每题一票
+func voteSessionQA(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	qaID, ok := intParam(c, "qa_id")
+	if !ok {
+		return
+	}
+	userID := currentUser(c).ID
+	remove := c.Request.Method == http.MethodDelete
+
+	tx, err := db.Begin()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQAVoteFailed)
+		return
+	}
+	defer tx.Rollback()
+
+	var votes int
+	err = tx.QueryRow("SELECT votes FROM session_qa WHERE id = ? AND session_id = ? FOR UPDATE", qaID, sessionID).Scan(&votes)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, CodeQANotFound)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeQAVoteFailed)
+		}
+		return
+	}
+
+	var result sql.Result
+	if remove {
+		result, err = tx.Exec("DELETE FROM session_qa_votes WHERE qa_id = ? AND user_id = ?", qaID, userID)
+	} else {
+		result, err = tx.Exec(dialect.insertIgnore("INSERT INTO session_qa_votes (qa_id, user_id) VALUES (?, ?)"), qaID, userID)
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQAVoteFailed)
+		return
+	}
+
+	// 重复投票或重复取消时票数不变
+	if n, _ := result.RowsAffected(); n > 0 {
+		delta := 1
+		if remove {
+			delta = -1
+		}
+		if _, err := tx.Exec("UPDATE session_qa SET votes = votes + ? WHERE id = ?", delta, qaID); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeQAVoteFailed)
+			return
+		}
+		votes += delta
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQAVoteFailed)
+		return
+	}
+
+	hub.broadcast(sessionRoom(sessionID), Message{Type: "qa_voted", Data: gin.H{"id": qaID, "votes": votes}})
+	respondOK(c, http.StatusOK, gin.H{"id": qaID, "votes": votes, "voted": !remove})
+}
+
+// 老师标记问题已回答，可附带文字回答
+func answerSessionQA(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	qaID, ok := intParam(c, "qa_id")
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Answer string `json:"answer" binding:"max=4000"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && c.Request.ContentLength != 0 {
+		respondBindError(c, err)
+		return
+	}
+
+	userID := currentUser(c).ID
+	answeredAt := time.Now().UTC()
+	result, err := db.Exec(`
+		UPDATE session_qa
+		SET status = ?, answer = ?, answered_by = ?, answered_at = ?
+		WHERE id = ? AND session_id = ?
+	`, QAAnswered, req.Answer, userID, answeredAt, qaID, sessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQAAnswerFailed)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, CodeQANotFound)
+		return
+	}
+
+	data := gin.H{"id": qaID, "status": QAAnswered, "answer": req.Answer, "answered_by": userID}
+	hub.broadcast(sessionRoom(sessionID), Message{Type: "qa_answered", From: userID, Data: data})
+	respondOK(c, http.StatusOK, data)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	qoeWindow        = time.Minute
+	qoeUnknownRegion = "unknown"
+
+	// 同一窗口内多数播放器都卡顿时更可能是服务端或 CDN 的问题
+	qoeServerAffectedRatio = 0.5
+	qoeServerMinBeacons    = 3
+)
+
+// 卡顿原因的判断
+const (
+	QoECauseNone   = "none"
+	QoECauseClient = "client" // 只有少数播放器卡顿，多为用户网络或设备问题
+	QoECauseServer = "server" // 多数播放器同时卡顿
+)
+
+// 播放体验指标的汇总
+type QoEStats struct {
+	Beacons         int     `json:"beacons"`
+	AffectedBeacons int     `json:"affected_beacons"` // 上报期间发生过卡顿的次数
+	WatchMs         int64   `json:"watch_ms"`
+	BufferingCount  int     `json:"buffering_count"`
+	BufferingMs     int64   `json:"buffering_ms"`
+	RebufferRatio   float64 `json:"rebuffer_ratio"` // 卡顿时长占观看时长的比例
+	BitrateSwitches int     `json:"bitrate_switches"`
+	AvgBitrateKbps  int     `json:"avg_bitrate_kbps"`
+	AvgLatencyMs    int     `json:"avg_latency_ms"`
+	Errors          int     `json:"errors"`
+	LikelyCause     string  `json:"likely_cause"`
+}
+
+type QoERegion struct {
+	Region string `json:"region"`
+	QoEStats
+}
+
+type QoEWindow struct {
+	WindowStart time.Time `json:"window_start"`
+	QoEStats
+}
+
+// 汇总时累加的原始计数
+type qoeTotals struct {
+	beacons, affected, bufferingCount, switches, errors, latencySamples int
+	watchMs, bufferingMs, bitrateSum, latencySum                        int64
+}
+
+func (t qoeTotals) stats() QoEStats {
+	s := QoEStats{
+		Beacons:         t.beacons,
+		AffectedBeacons: t.affected,
+		WatchMs:         t.watchMs,
+		BufferingCount:  t.bufferingCount,
+		BufferingMs:     t.bufferingMs,
+		BitrateSwitches: t.switches,
+		Errors:          t.errors,
+		LikelyCause:     QoECauseNone,
+	}
+	if t.watchMs > 0 {
+		s.RebufferRatio = float64(t.bufferingMs) / float64(t.watchMs)
+	}
+	if t.beacons > 0 {
+		s.AvgBitrateKbps = int(t.bitrateSum / int64(t.beacons))
+	}
+	if t.latencySamples > 0 {
+		s.AvgLatencyMs = int(t.latencySum / int64(t.latencySamples))
+	}
+	switch {
+	case t.beacons >= qoeServerMinBeacons && float64(t.affected) >= float64(t.beacons)*qoeServerAffectedRatio:
+		s.LikelyCause = QoECauseServer
+	case t.affected > 0:
+		s.LikelyCause = QoECauseClient
+	}
+	return s
+}
+
+// 接收播放器定期上报的体验数据，按会话、分钟和地区累加；用播放令牌鉴权
+func ingestQoEBeacon(c *gin.Context) {
+	var req struct {
+		Token           string `json:"token" binding:"required"`
+		Region          string `json:"region" binding:"max=64"` // 如省份或运营商，为空时按播放地区归类
+		WatchMs         int64  `json:"watch_ms" binding:"min=0,max=3600000"`
+		BufferingCount  int    `json:"buffering_count" binding:"min=0"`
+		BufferingMs     int64  `json:"buffering_ms" binding:"min=0"`
+		BitrateSwitches int    `json:"bitrate_switches" binding:"min=0"`
+		BitrateKbps     int64  `json:"bitrate_kbps" binding:"min=0"`
+		LatencyMs       *int64 `json:"latency_ms" binding:"omitempty,min=0"` // 端到端延迟，不支持测量的播放器不传
+		Errors          int    `json:"errors" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	claims, ok := parsePlaybackToken(req.Token)
+	if !ok {
+		respondError(c, http.StatusForbidden, CodePlaybackTokenInvalid)
+		return
+	}
+	region := strings.TrimSpace(req.Region)
+	if region == "" {
+		region = playRegion(c)
+	}
+	if region == "" {
+		region = qoeUnknownRegion
+	}
+	affected := 0
+	if req.BufferingCount > 0 {
+		affected = 1
+	}
+	var latencySum int64
+	latencySamples := 0
+	if req.LatencyMs != nil {
+		latencySum = *req.LatencyMs
+		latencySamples = 1
+	}
+
+	windowStart := time.Now().UTC().Truncate(qoeWindow)
+	_, err := db.Exec(dialect.upsert(`
+		INSERT INTO session_qoe (session_id, window_start, region, beacons, affected, watch_ms, buffering_count, buffering_ms,
+			bitrate_switches, bitrate_sum, latency_sum, latency_samples, errors)
+		VALUES (?, ?, ?, 1, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		[]string{"session_id", "window_start", "region"},
+		"beacons = session_qoe.beacons + 1",
+		"affected = session_qoe.affected + EXCLUDED.affected",
+		"watch_ms = session_qoe.watch_ms + EXCLUDED.watch_ms",
+		"buffering_count = session_qoe.buffering_count + EXCLUDED.buffering_count",
+		"buffering_ms = session_qoe.buffering_ms + EXCLUDED.buffering_ms",
+		"bitrate_switches = session_qoe.bitrate_switches + EXCLUDED.bitrate_switches",
+		"bitrate_sum = session_qoe.bitrate_sum + EXCLUDED.bitrate_sum",
+		"latency_sum = session_qoe.latency_sum + EXCLUDED.latency_sum",
+		"latency_samples = session_qoe.latency_samples + EXCLUDED.latency_samples",
+		"errors = session_qoe.errors + EXCLUDED.errors",
+	), claims.SessionID, windowStart, region, affected, req.WatchMs, req.BufferingCount, req.BufferingMs,
+		req.BitrateSwitches, req.BitrateKbps, latencySum, latencySamples, req.Errors)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQoEIngestFailed)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// 会话的播放体验报告：整体、按地区和按分钟汇总，并给出卡顿更可能来自客户端还是服务端
+func getSessionQoE(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	if !sessionExists(c, sessionID) {
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT window_start, region, beacons, affected, watch_ms, buffering_count, buffering_ms,
+			bitrate_switches, bitrate_sum, latency_sum, latency_samples, errors
+		FROM session_qoe WHERE session_id = ?
+		ORDER BY window_start, region
+	`, sessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQoEGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	var total qoeTotals
+	byRegion := make(map[string]*qoeTotals)
+	var regionOrder []string
+	var windows []time.Time
+	byWindow := make(map[time.Time]*qoeTotals)
+	for rows.Next() {
+		var start time.Time
+		var region string
+		var t qoeTotals
+		if err := rows.Scan(&start, &region, &t.beacons, &t.affected, &t.watchMs, &t.bufferingCount, &t.bufferingMs,
+			&t.switches, &t.bitrateSum, &t.latencySum, &t.latencySamples, &t.errors); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeQoEGetFailed)
+			return
+		}
+		if byRegion[region] == nil {
+			byRegion[region] = &qoeTotals{}
+			regionOrder = append(regionOrder, region)
+		}
+		if byWindow[start] == nil {
+			byWindow[start] = &qoeTotals{}
+			windows = append(windows, start)
+		}
+		for _, acc := range []*qoeTotals{&total, byRegion[region], byWindow[start]} {
+			acc.beacons += t.beacons
+			acc.affected += t.affected
+			acc.watchMs += t.watchMs
+			acc.bufferingCount += t.bufferingCount
+			acc.bufferingMs += t.bufferingMs
+			acc.switches += t.switches
+			acc.bitrateSum += t.bitrateSum
+			acc.latencySum += t.latencySum
+			acc.latencySamples += t.latencySamples
+			acc.errors += t.errors
+		}
+	}
+
+	regions := make([]QoERegion, 0, len(regionOrder))
+	for _, r := range regionOrder {
+		regions = append(regions, QoERegion{Region: r, QoEStats: byRegion[r].stats()})
+	}
+	loc := requestLocation(c)
+	timeline := make([]QoEWindow, 0, len(windows))
+	for _, w := range windows {
+		timeline = append(timeline, QoEWindow{WindowStart: w.In(loc), QoEStats: byWindow[w].stats()})
+	}
+	respondOK(c, http.StatusOK, gin.H{
+		"session_id": sessionID,
+		"summary":    total.stats(),
+		"regions":    regions,
+		"windows":    timeline,
+	})
+}
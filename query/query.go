@@ -0,0 +1,114 @@
+// Package query 提供拼接过滤、搜索和排序条件的辅助方法。
+//
+// 所有取值都以占位符参数传入；列名只能来自代码中的常量或白名单，
+// 不要把请求参数直接作为列名或 SQL 片段传入。
+package query
+
+import (
+	"strings"
+)
+
+// LIKE 模式的转义字符，使用 ! 以免受 MySQL NO_BACKSLASH_ESCAPES 等模式影响
+const likeEscape = '!'
+
+// 查询条件构造器
+type Builder struct {
+	conds   []string
+	args    []interface{}
+	orderBy string
+}
+
+func New() *Builder {
+	return &Builder{}
+}
+
+// 追加条件，cond 中的取值必须使用 ? 占位符
+func (b *Builder) Where(cond string, args ...interface{}) *Builder {
+	b.conds = append(b.conds, cond)
+	b.args = append(b.args, args...)
+	return b
+}
+
+// value 非空时追加 column = ? 条件
+func (b *Builder) Eq(column, value string) *Builder {
+	if value == "" {
+		return b
+	}
+	return b.Where(column+" = ?", value)
+}
+
+// term 非空时追加包含匹配，多个列之间为 OR
+func (b *Builder) Contains(term string, columns ...string) *Builder {
+	if term == "" || len(columns) == 0 {
+		return b
+	}
+
+	pattern := "%" + EscapeLike(term) + "%"
+	parts := make([]string, len(columns))
+	args := make([]interface{}, len(columns))
+	for i, column := range columns {
+		parts[i] = column + " LIKE ? ESCAPE '" + string(likeEscape) + "'"
+		args[i] = pattern
+	}
+	return b.Where("("+strings.Join(parts, " OR ")+")", args...)
+}
+
+// 按白名单排序。sort 形如 "created_at" 或 "-created_at"（降序），
+// allowed 将请求中的字段名映射到列名，不在白名单中时使用 fallback
+func (b *Builder) Sort(sort string, allowed map[string]string, fallback string) *Builder {
+	b.orderBy = fallback
+
+	desc := strings.HasPrefix(sort, "-")
+	column, ok := allowed[strings.TrimPrefix(sort, "-")]
+	if !ok {
+		return b
+	}
+	if desc {
+		b.orderBy = column + " DESC"
+	} else {
+		b.orderBy = column + " ASC"
+	}
+	return b
+}
+
+// WHERE 子句内容，没有条件时为 1 = 1
+func (b *Builder) WhereSQL() string {
+	if len(b.conds) == 0 {
+		return "1 = 1"
+	}
+	return strings.Join(b.conds, " AND ")
+}
+
+// ORDER BY 子句内容
+func (b *Builder) OrderSQL() string {
+	return b.orderBy
+}
+
+// 条件参数，返回副本以便追加分页参数
+func (b *Builder) Args(extra ...interface{}) []interface{} {
+	args := make([]interface{}, 0, len(b.args)+len(extra))
+	args = append(args, b.args...)
+	return append(args, extra...)
+}
+
+// n 个以逗号分隔的占位符，用于 IN (...) 条件
+func Placeholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.Repeat("?, ", n-1) + "?"
+}
+
+// 转义 LIKE 模式中的通配符，配合 ESCAPE '!' 使用
+func EscapeLike(s string) string {
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for _, r := range s {
+		switch r {
+		case '%', '_', likeEscape:
+			sb.WriteRune(likeEscape)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
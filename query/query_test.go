@@ -0,0 +1,118 @@
+package query
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"物理", "物理"},
+		{"100%", "100!%"},
+		{"a_b", "a!_b"},
+		{"hi!", "hi!!"},
+		{`C:\path`, `C:\path`}, // 转义字符是 !，反斜杠按普通字符处理
+		{`%_!\`, `!%!_!!\`},
+		{"!!", "!!!!"},
+	}
+	for _, tt := range tests {
+		if got := EscapeLike(tt.in); got != tt.want {
+			t.Errorf("EscapeLike(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// 转义后的模式在数据库中只按字面匹配
+func TestContainsMatchesLiterally(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE t (name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"100% 完成", "1000 完成", "a_b", "axb", "hi!", "hi", `C:\path`, "C:path"} {
+		if _, err := db.Exec("INSERT INTO t (name) VALUES (?)", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		term string
+		want []string
+	}{
+		{"100%", []string{"100% 完成"}},
+		{"a_b", []string{"a_b"}},
+		{"hi!", []string{"hi!"}},
+		{`C:\`, []string{`C:\path`}},
+		{"%", []string{"100% 完成"}},
+	}
+	for _, tt := range tests {
+		b := New().Contains(tt.term, "name")
+		rows, err := db.Query("SELECT name FROM t WHERE "+b.WhereSQL()+" ORDER BY name", b.Args()...)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.term, err)
+		}
+		var got []string
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, name)
+		}
+		rows.Close()
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Contains(%q) matched %q, want %q", tt.term, got, tt.want)
+		}
+	}
+}
+
+func TestSort(t *testing.T) {
+	allowed := map[string]string{"created_at": "c.created_at", "name": "c.name"}
+	tests := []struct {
+		sort, want string
+	}{
+		{"created_at", "c.created_at ASC"},
+		{"-created_at", "c.created_at DESC"},
+		{"name", "c.name ASC"},
+		{"", "c.id DESC"},
+		{"-", "c.id DESC"},
+		{"c.created_at", "c.id DESC"},
+		{"--name", "c.id DESC"},
+		{"password_hash", "c.id DESC"},
+		{"name; DROP TABLE users", "c.id DESC"},
+		{"name DESC", "c.id DESC"},
+		{"NAME", "c.id DESC"},
+	}
+	for _, tt := range tests {
+		if got := New().Sort(tt.sort, allowed, "c.id DESC").OrderSQL(); got != tt.want {
+			t.Errorf("Sort(%q) = %q, want %q", tt.sort, got, tt.want)
+		}
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	b := New().Eq("status", "").Eq("status", "active").Contains("", "name").Contains("x", "name", "email")
+	if want := "status = ? AND (name LIKE ? ESCAPE '!' OR email LIKE ? ESCAPE '!')"; b.WhereSQL() != want {
+		t.Errorf("WhereSQL() = %q, want %q", b.WhereSQL(), want)
+	}
+	if got, want := b.Args(20, 0), []interface{}{"active", "%x%", "%x%", 20, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Args() = %v, want %v", got, want)
+	}
+	if got := New().WhereSQL(); got != "1 = 1" {
+		t.Errorf("empty WhereSQL() = %q", got)
+	}
+	for n, want := range map[int]string{0: "", 1: "?", 3: "?, ?, ?"} {
+		if got := Placeholders(n); got != want {
+			t.Errorf("Placeholders(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Dong557799/zhibo-class/query"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// 富文本题目的内容块类型
+const (
+	BlockText  = "text"
+	BlockLatex = "latex"
+	BlockImage = "image"
+	BlockAudio = "audio"
+)
+
+const (
+	maxQuestionImageBytes = 2 << 20
+	maxQuestionAudioBytes = 10 << 20
+	maxQuestionImages     = 5
+	maxQuestionAudios     = 2
+)
+
+// 允许上传的题目素材格式，值为文件扩展名
+var (
+	questionImageTypes = map[string]string{
+		"image/png":  ".png",
+		"image/jpeg": ".jpg",
+		"image/gif":  ".gif",
+		"image/webp": ".webp",
+	}
+	questionAudioTypes = map[string]string{
+		"audio/mpeg": ".mp3",
+		"audio/mp4":  ".m4a",
+		"audio/ogg":  ".ogg",
+		"audio/wav":  ".wav",
+		"audio/webm": ".webm",
+	}
+)
+
+// 公式中不允许的命令，客户端渲染时可能读取文件或生成链接
+var unsafeLatex = regexp.MustCompile(`\\(input|include|write|immediate|openout|openin|read|def|let|catcode|href|url|html[A-Za-z]*)\b`)
+
+// 题目的一个内容块。text 和 latex 使用 text 字段；image 和 audio 引用上传接口返回的 media_key，
+// 下发时附带带有效期的 url。Question.Content 仍保存纯文本题干，用于导出和不支持富文本的客户端
+type ContentBlock struct {
+	Type     string `json:"type" binding:"required,oneof=text latex image audio"`
+	Text     string `json:"text,omitempty" binding:"max=4000"`
+	MediaKey string `json:"media_key,omitempty" binding:"max=255"`
+	Caption  string `json:"caption,omitempty" binding:"max=255"` // 图片的替代文本或音频说明
+	URL      string `json:"url,omitempty" binding:"-"`
+}
+
+// 题目素材，上传后在题目中通过 media_key 引用
+type QuestionMedia struct {
+	MediaKey    string    `json:"media_key"`
+	Kind        string    `json:"kind"` // image 或 audio
+	ContentType string    `json:"content_type"`
+	SizeBytes   int64     `json:"size_bytes"`
+	URL         string    `json:"url"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// 按内容块类型校验必填字段、素材数量和公式内容，由 validateQuestion 调用
+func validateQuestionBlocks(sl validator.StructLevel, blocks []ContentBlock) {
+	images, audios := 0, 0
+	for i, b := range blocks {
+		name := fmt.Sprintf("blocks[%d]", i)
+		switch b.Type {
+		case BlockText, BlockLatex:
+			if strings.TrimSpace(b.Text) == "" {
+				sl.ReportError(b.Text, name+".text", "Text", "required", "")
+			}
+			if b.Type == BlockLatex && !safeLatex(b.Text) {
+				sl.ReportError(b.Text, name+".text", "Text", "latex", "")
+			}
+		case BlockImage, BlockAudio:
+			if b.MediaKey == "" {
+				sl.ReportError(b.MediaKey, name+".media_key", "MediaKey", "required", "")
+			}
+			if b.Type == BlockImage {
+				images++
+			} else {
+				audios++
+			}
+		}
+	}
+	if images > maxQuestionImages {
+		sl.ReportError(blocks, "blocks", "Blocks", "max_media", fmt.Sprintf("%d %s", maxQuestionImages, BlockImage))
+	}
+	if audios > maxQuestionAudios {
+		sl.ReportError(blocks, "blocks", "Blocks", "max_media", fmt.Sprintf("%d %s", maxQuestionAudios, BlockAudio))
+	}
+}
+
+// 公式的花括号需要配对，且不能包含 unsafeLatex 中的命令
+func safeLatex(s string) bool {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++ // 跳过转义字符，如 \{
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth < 0 {
+				return false
+			}
+		}
+	}
+	return depth == 0 && !unsafeLatex.MatchString(s)
+}
+
+// 检查内容块引用的素材都已上传且类型一致，不一致时写入错误响应
+func checkQuestionMedia(c *gin.Context, blocks []ContentBlock) bool {
+	kinds := make(map[string]string)
+	args := []interface{}{}
+	for _, b := range blocks {
+		if b.MediaKey != "" {
+			if _, ok := kinds[b.MediaKey]; !ok {
+				args = append(args, b.MediaKey)
+			}
+			kinds[b.MediaKey] = b.Type
+		}
+	}
+	if len(args) == 0 {
+		return true
+	}
+	rows, err := db.Query("SELECT media_key, kind FROM question_media WHERE media_key IN ("+query.Placeholders(len(args))+")", args...)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQuestionCreateFailed)
+		return false
+	}
+	defer rows.Close()
+	found := make(map[string]bool)
+	for rows.Next() {
+		var key, kind string
+		if err := rows.Scan(&key, &kind); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeQuestionCreateFailed)
+			return false
+		}
+		if kinds[key] != kind {
+			respondError(c, http.StatusBadRequest, CodeQuestionMediaInvalid, key)
+			return false
+		}
+		found[key] = true
+	}
+	if err := rows.Err(); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQuestionCreateFailed)
+		return false
+	}
+	for _, key := range args {
+		if !found[key.(string)] {
+			respondError(c, http.StatusBadRequest, CodeQuestionMediaInvalid, key)
+			return false
+		}
+	}
+	return true
+}
+
+// 内容块序列化后保存在 questions.rich_content，没有内容块时保存 NULL
+func encodeQuestionBlocks(blocks []ContentBlock) (interface{}, error) {
+	if len(blocks) == 0 {
+		return nil, nil
+	}
+	stored := make([]ContentBlock, len(blocks))
+	for i, b := range blocks {
+		b.URL = ""
+		stored[i] = b
+	}
+	data, err := json.Marshal(stored)
+	return string(data), err
+}
+
+// 解析保存的内容块并为素材生成下载地址，格式错误时忽略
+func decodeQuestionBlocks(ctx context.Context, raw string) []ContentBlock {
+	if raw == "" {
+		return nil
+	}
+	var blocks []ContentBlock
+	if err := json.Unmarshal([]byte(raw), &blocks); err != nil {
+		log.Printf("Failed to decode question content blocks: %v", err)
+		return nil
+	}
+	for i := range blocks {
+		blocks[i].URL = storageURL(ctx, blocks[i].MediaKey)
+	}
+	return blocks
+}
+
+// 上传题目图片或听力音频，返回的 media_key 用于创建题目时引用
+func uploadQuestionMedia(c *gin.Context) {
+	header, err := c.FormFile("file")
+	if err != nil {
+		if limit, ok := bodyTooLarge(err); ok {
+			respondError(c, http.StatusRequestEntityTooLarge, CodeBodyTooLarge, limit)
+			return
+		}
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "file")
+		return
+	}
+	contentType := strings.ToLower(strings.TrimSpace(strings.SplitN(header.Header.Get("Content-Type"), ";", 2)[0]))
+	kind, ext, limit := BlockImage, questionImageTypes[contentType], int64(maxQuestionImageBytes)
+	if ext == "" {
+		kind, ext, limit = BlockAudio, questionAudioTypes[contentType], maxQuestionAudioBytes
+	}
+	if ext == "" {
+		respondError(c, http.StatusUnsupportedMediaType, CodeQuestionMediaUnsupported, contentType)
+		return
+	}
+	if header.Size > limit {
+		respondError(c, http.StatusRequestEntityTooLarge, CodeBodyTooLarge, limit)
+		return
+	}
+
+	f, err := header.Open()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQuestionMediaUploadFailed)
+		return
+	}
+	defer f.Close()
+	// 图片按文件内容识别格式，避免把其他文件当作图片下发给学生
+	if kind == BlockImage {
+		head := make([]byte, 512)
+		n, _ := f.Read(head)
+		if http.DetectContentType(head[:n]) != contentType {
+			respondError(c, http.StatusUnsupportedMediaType, CodeQuestionMediaUnsupported, contentType)
+			return
+		}
+		if _, err := f.Seek(0, 0); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeQuestionMediaUploadFailed)
+			return
+		}
+	}
+
+	suffix := make([]byte, 12)
+	if _, err := rand.Read(suffix); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQuestionMediaUploadFailed)
+		return
+	}
+	media := QuestionMedia{
+		MediaKey:    fmt.Sprintf("questions/%s/%s%s", kind, hex.EncodeToString(suffix), ext),
+		Kind:        kind,
+		ContentType: contentType,
+		SizeBytes:   header.Size,
+		CreatedAt:   time.Now().UTC(),
+	}
+	if err := storage.Put(c.Request.Context(), media.MediaKey, f, header.Size, contentType); err != nil {
+		log.Printf("Failed to store question media: %v", err)
+		respondError(c, http.StatusInternalServerError, CodeQuestionMediaUploadFailed)
+		return
+	}
+	_, err = db.Exec(`
+		INSERT INTO question_media (media_key, kind, content_type, size_bytes, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, media.MediaKey, media.Kind, media.ContentType, media.SizeBytes, currentUser(c).ID, media.CreatedAt)
+	if err != nil {
+		if err := storage.Delete(context.Background(), media.MediaKey); err != nil {
+			log.Printf("Failed to delete question media %s: %v", media.MediaKey, err)
+		}
+		respondError(c, http.StatusInternalServerError, CodeQuestionMediaUploadFailed)
+		return
+	}
+
+	media.URL = storageURL(c.Request.Context(), media.MediaKey)
+	respondOK(c, http.StatusCreated, media)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Dong557799/zhibo-class/query"
+	"github.com/gin-gonic/gin"
+)
+
+// 题目状态
+const (
+	QuestionDraft     = "draft"
+	QuestionPublished = "published"
+)
+
+// 知识点掌握情况
+type KnowledgePointStat struct {
+	Tag           string  `json:"tag"`
+	QuestionCount int     `json:"question_count"`
+	AnswerCount   int     `json:"answer_count"`
+	CorrectCount  int     `json:"correct_count"`
+	Accuracy      float64 `json:"accuracy"` // 没有作答时为 0
+}
+
+// 去除空白和重复的标签
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return result
+}
+
+func saveQuestionTags(tx *sql.Tx, questionID int, tags []string) error {
+	for _, tag := range tags {
+		if _, err := tx.Exec("INSERT INTO question_tags (question_id, tag) VALUES (?, ?)", questionID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 批量读取题目的标签
+func loadQuestionTags(questionIDs []int) (map[int][]string, error) {
+	tags := make(map[int][]string)
+	if len(questionIDs) == 0 {
+		return tags, nil
+	}
+
+	args := make([]interface{}, len(questionIDs))
+	for i, id := range questionIDs {
+		args[i] = id
+	}
+	rows, err := db.Query(`
+		SELECT question_id, tag FROM question_tags
+		WHERE question_id IN (`+query.Placeholders(len(args))+`)
+		ORDER BY tag
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return nil, err
+		}
+		tags[id] = append(tags[id], tag)
+	}
+	return tags, rows.Err()
+}
+
+// 题目列表，可按课程、类型、难度、状态、知识点和关键字过滤
+func listQuestions(c *gin.Context) {
+	page, pageSize, offset := pageParams(c)
+
+	q := query.New().
+		Eq("course_id", c.Query("course_id")).
+		Eq("type", c.Query("type")).
+		Eq("difficulty", c.Query("difficulty")).
+		Eq("status", c.Query("status")).
+		Contains(c.Query("q"), "content")
+	if tag := c.Query("tag"); tag != "" {
+		q.Where("id IN (SELECT question_id FROM question_tags WHERE tag = ?)", tag)
+	}
+
+	var total int64
+	if err := db.QueryRow("SELECT COUNT(*) FROM questions WHERE "+q.WhereSQL(), q.Args()...).Scan(&total); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQuestionGetFailed)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, course_id, type, content, options, answer, difficulty, estimated_seconds, status,
+			COALESCE(rich_content, ''), scoring
+		FROM questions
+		WHERE `+q.WhereSQL()+`
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`, q.Args(pageSize, offset)...)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQuestionGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	questions := []Question{}
+	var ids []int
+	for rows.Next() {
+		var question Question
+		var options, richContent string
+		if err := rows.Scan(&question.ID, &question.CourseID, &question.Type, &question.Content, &options,
+			&question.Answer, &question.Difficulty, &question.EstimatedSeconds, &question.Status, &richContent,
+			&question.Scoring); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeQuestionGetFailed)
+			return
+		}
+		question.Options = splitList(options)
+		question.Blocks = decodeQuestionBlocks(c.Request.Context(), richContent)
+		questions = append(questions, question)
+		ids = append(ids, question.ID)
+	}
+
+	tags, err := loadQuestionTags(ids)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQuestionGetFailed)
+		return
+	}
+	for i := range questions {
+		questions[i].Tags = tags[questions[i].ID]
+	}
+
+	respondPage(c, questions, Pagination{Page: page, PageSize: pageSize, Total: total, HasMore: int64(offset+len(questions)) < total})
+}
+
+// 按知识点统计课程的答题正确率，正确率低的排在前面，便于发现薄弱知识点
+func getKnowledgePointStats(c *gin.Context) {
+	courseID, ok := intParam(c, "course_id")
+	if !ok {
+		return
+	}
+
+	rows, err := readQuery(`
+		SELECT t.tag, COUNT(DISTINCT q.id), COUNT(a.id),
+			COALESCE(SUM(CASE WHEN a.answer = q.answer THEN 1 ELSE 0 END), 0)
+		FROM question_tags t
+		JOIN questions q ON q.id = t.question_id
+		LEFT JOIN answers a ON a.question_id = q.id
+		WHERE q.course_id = ?
+		GROUP BY t.tag
+	`, courseID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeResultGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	stats := []KnowledgePointStat{}
+	for rows.Next() {
+		var s KnowledgePointStat
+		if err := rows.Scan(&s.Tag, &s.QuestionCount, &s.AnswerCount, &s.CorrectCount); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeResultGetFailed)
+			return
+		}
+		if s.AnswerCount > 0 {
+			s.Accuracy = float64(s.CorrectCount) / float64(s.AnswerCount)
+		}
+		stats = append(stats, s)
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		// 还没有作答记录的知识点排在最后
+		if (stats[i].AnswerCount == 0) != (stats[j].AnswerCount == 0) {
+			return stats[j].AnswerCount == 0
+		}
+		if stats[i].Accuracy != stats[j].Accuracy {
+			return stats[i].Accuracy < stats[j].Accuracy
+		}
+		return stats[i].Tag < stats[j].Tag
+	})
+
+	respondOK(c, http.StatusOK, stats)
+}
+
+// 按ID读取题目，包含答案和知识点标签
+func loadQuestion(id int) (Question, error) {
+	var q Question
+	var options, richContent string
+	err := db.QueryRow(`
+		SELECT id, course_id, type, content, options, answer, difficulty, estimated_seconds, status,
+			COALESCE(rich_content, ''), scoring
+		FROM questions
+		WHERE id = ?
+	`, id).Scan(&q.ID, &q.CourseID, &q.Type, &q.Content, &options, &q.Answer, &q.Difficulty, &q.EstimatedSeconds, &q.Status,
+		&richContent, &q.Scoring)
+	if err != nil {
+		return q, err
+	}
+	q.Options = splitList(options)
+	q.Blocks = decodeQuestionBlocks(context.Background(), richContent)
+	tags, err := loadQuestionTags([]int{q.ID})
+	q.Tags = tags[q.ID]
+	return q, err
+}
+
+// 读取路径中的题目，不存在时写入错误响应
+func questionParam(c *gin.Context) (Question, bool) {
+	id, ok := intParam(c, "question_id")
+	if !ok {
+		return Question{}, false
+	}
+	q, err := loadQuestion(id)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeQuestionNotFound)
+		return q, false
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQuestionGetFailed)
+		return q, false
+	}
+	return q, true
+}
+
+// 预览题目推送给学生时的内容，草稿题目也可以预览
+func previewQuestion(c *gin.Context) {
+	q, ok := questionParam(c)
+	if !ok {
+		return
+	}
+	data := studentQuestion(q)
+	data["status"] = q.Status
+	respondOK(c, http.StatusOK, data)
+}
+
+// 发布草稿题目，发布后才能推送；已发布的题目直接返回
+func publishQuestion(c *gin.Context) {
+	q, ok := questionParam(c)
+	if !ok {
+		return
+	}
+	if q.Status == QuestionDraft {
+		_, err := db.Exec("UPDATE questions SET status = ? WHERE id = ? AND status = ?", QuestionPublished, q.ID, QuestionDraft)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeQuestionPublishFailed)
+			return
+		}
+		q.Status = QuestionPublished
+		recordAudit(c, "publish_question", "question", q.ID, nil)
+	}
+	respondOK(c, http.StatusOK, q)
+}
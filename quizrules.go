@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 规则的统计指标，均为百分比
+const (
+	MetricAccuracy     = "accuracy"      // 已作答学生中答对的比例
+	MetricAnsweredRate = "answered_rate" // 收到题目的学生中已作答的比例
+)
+
+// 条件满足时执行的动作
+const (
+	RuleNotify = "notify" // 通知课程中的老师和助教
+	RuleExtend = "extend" // 延长作答截止时间
+	RuleReveal = "reveal" // 结束作答并公布答案
+)
+
+const (
+	jobQuizRulesEvaluate = "quiz_rules.evaluate"
+	maxCourseQuizRules   = 20
+)
+
+var (
+	ruleMetrics   = []string{MetricAccuracy, MetricAnsweredRate}
+	ruleOperators = []string{"lt", "lte", "gt", "gte"}
+	ruleActions   = []string{RuleNotify, RuleExtend, RuleReveal}
+)
+
+// 课堂答题规则，如“正确率低于 50% 时通知我”或“推送 60 秒后作答率不足 80% 时延长 30 秒”。
+// 每次推送中每条规则最多触发一次
+type QuizRule struct {
+	ID            int       `json:"id"`
+	CourseID      int       `json:"course_id"`
+	Metric        string    `json:"metric"`
+	Operator      string    `json:"operator"`
+	Threshold     float64   `json:"threshold"`
+	AfterSeconds  int       `json:"after_seconds"` // 推送后经过该时间才检查，0 表示随时检查
+	MinAnswers    int       `json:"min_answers"`   // 作答人数达到该值才检查，避免前几个答案就触发
+	Action        string    `json:"action"`
+	ActionSeconds int       `json:"action_seconds,omitempty"` // extend 时延长的秒数
+	CreatedBy     int       `json:"created_by"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// 推送的实时作答统计
+type pushStats struct {
+	Targeted int
+	Answered int
+	Correct  int
+}
+
+func (s pushStats) value(metric string) (float64, bool) {
+	switch metric {
+	case MetricAccuracy:
+		if s.Answered == 0 {
+			return 0, false
+		}
+		return float64(s.Correct) * 100 / float64(s.Answered), true
+	case MetricAnsweredRate:
+		if s.Targeted == 0 {
+			return 0, false
+		}
+		return float64(s.Answered) * 100 / float64(s.Targeted), true
+	}
+	return 0, false
+}
+
+func (r QuizRule) matches(s pushStats, elapsed time.Duration) bool {
+	if elapsed < time.Duration(r.AfterSeconds)*time.Second || s.Answered < r.MinAnswers {
+		return false
+	}
+	v, ok := s.value(r.Metric)
+	if !ok {
+		return false
+	}
+	switch r.Operator {
+	case "lt":
+		return v < r.Threshold
+	case "lte":
+		return v <= r.Threshold
+	case "gt":
+		return v > r.Threshold
+	case "gte":
+		return v >= r.Threshold
+	}
+	return false
+}
+
+func init() {
+	// 按规则的等待时间检查，作答人数不再变化时也能触发
+	jobHandlers[jobQuizRulesEvaluate] = func(ctx context.Context, payload json.RawMessage) error {
+		var p struct {
+			PushID int `json:"push_id"`
+		}
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return evaluateQuizRules(ctx, p.PushID)
+	}
+}
+
+func loadQuizRules(ctx context.Context, courseID int) ([]QuizRule, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, course_id, metric, operator, threshold, after_seconds, min_answers, action, action_seconds, created_by, created_at
+		FROM quiz_rules
+		WHERE course_id = ?
+		ORDER BY id
+	`, courseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []QuizRule{}
+	for rows.Next() {
+		var r QuizRule
+		if err := rows.Scan(&r.ID, &r.CourseID, &r.Metric, &r.Operator, &r.Threshold, &r.AfterSeconds, &r.MinAnswers,
+			&r.Action, &r.ActionSeconds, &r.CreatedBy, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// 推送题目后按规则的等待时间安排检查
+func scheduleQuizRules(push QuestionPush) {
+	rules, err := loadQuizRules(context.Background(), push.CourseID)
+	if err != nil {
+		log.Printf("Failed to load quiz rules for course %d: %v", push.CourseID, err)
+		return
+	}
+	scheduled := make(map[int]bool)
+	for _, r := range rules {
+		if r.AfterSeconds <= 0 || scheduled[r.AfterSeconds] {
+			continue
+		}
+		scheduled[r.AfterSeconds] = true
+		runAt := push.PushedAt.Add(time.Duration(r.AfterSeconds) * time.Second)
+		if _, err := enqueueJob(jobQuizRulesEvaluate, gin.H{"push_id": push.ID}, runAt); err != nil {
+			log.Printf("Failed to schedule quiz rules for push %d: %v", push.ID, err)
+		}
+	}
+}
+
+func loadPushStats(ctx context.Context, push QuestionPush) (pushStats, error) {
+	var s pushStats
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT student_id), COUNT(DISTINCT CASE WHEN credit = 1 THEN student_id END)
+		FROM answers
+		WHERE push_id = ?
+	`, push.ID).Scan(&s.Answered, &s.Correct)
+	if err != nil {
+		return s, err
+	}
+	targeted, _, _, err := deliveries.report(pushDelivery(push.ID))
+	if err != nil {
+		return s, err
+	}
+	// 送达记录尚未合并时以作答人数为准
+	s.Targeted = len(targeted)
+	if s.Targeted < s.Answered {
+		s.Targeted = s.Answered
+	}
+	return s, nil
+}
+
+// 检查推送的答题规则，在收到答案和到达规则的等待时间时调用；已截止的推送不再检查
+func evaluateQuizRules(ctx context.Context, pushID int) error {
+	push, err := loadQuestionPush(pushID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if push.closed(now) {
+		return nil
+	}
+	rules, err := loadQuizRules(ctx, push.CourseID)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	stats, err := loadPushStats(ctx, push)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rules {
+		if !r.matches(stats, now.Sub(push.PushedAt)) {
+			continue
+		}
+		// 多个实例同时检查时只有一个能记录触发
+		res, err := db.ExecContext(ctx, dialect.insertIgnore(`
+			INSERT INTO quiz_rule_firings (rule_id, push_id, fired_at) VALUES (?, ?, ?)
+		`), r.ID, push.ID, now)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		if push, err = fireQuizRule(ctx, r, push, stats, now); err != nil {
+			log.Printf("Failed to run quiz rule %d for push %d: %v", r.ID, push.ID, err)
+		}
+		if push.closed(now) {
+			break
+		}
+	}
+	return nil
+}
+
+// 执行规则的动作，返回更新后的推送
+func fireQuizRule(ctx context.Context, r QuizRule, push QuestionPush, stats pushStats, now time.Time) (QuestionPush, error) {
+	value, _ := stats.value(r.Metric)
+	data := gin.H{
+		"rule":        r,
+		"question_id": push.QuestionID,
+		"push_id":     push.ID,
+		"value":       value,
+		"targeted":    stats.Targeted,
+		"answered":    stats.Answered,
+		"correct":     stats.Correct,
+	}
+
+	switch r.Action {
+	case RuleExtend:
+		// 没有截止时间的推送直到老师结束作答，不需要延长
+		if push.ClosesAt == nil {
+			break
+		}
+		closesAt := push.ClosesAt.Add(time.Duration(r.ActionSeconds) * time.Second)
+		res, err := db.ExecContext(ctx, "UPDATE question_pushes SET closes_at = ? WHERE id = ? AND closes_at > ?", closesAt, push.ID, now)
+		if err != nil {
+			return push, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			break
+		}
+		push.ClosesAt = &closesAt
+		scheduleQuestionClose(push)
+		extended := gin.H{"question_id": push.QuestionID, "push_id": push.ID, "closes_at": closesAt}
+		hub.broadcast(courseRoom(push.CourseID), Message{Type: "question_extended", Data: extended})
+		publishMQTT(push.CourseID, "question", extended)
+		data["closes_at"] = closesAt
+
+	case RuleReveal:
+		var answer string
+		if err := db.QueryRowContext(ctx, "SELECT answer FROM questions WHERE id = ?", push.QuestionID).Scan(&answer); err != nil {
+			return push, err
+		}
+		if _, err := db.ExecContext(ctx, "UPDATE question_pushes SET closes_at = ? WHERE id = ?", now, push.ID); err != nil {
+			return push, err
+		}
+		push.ClosesAt = &now
+		notifyQuestionClosed(push)
+		hub.broadcast(courseRoom(push.CourseID), Message{Type: "answer_revealed", Data: gin.H{
+			"question_id": push.QuestionID,
+			"push_id":     push.ID,
+			"answer":      answer,
+		}})
+	}
+
+	hub.broadcast(staffRoom(push.CourseID), Message{Type: "quiz_rule_triggered", Data: data})
+	return push, nil
+}
+
+// 课程的答题规则
+func listQuizRules(c *gin.Context) {
+	courseID, ok := intParam(c, "course_id")
+	if !ok {
+		return
+	}
+	rules, err := loadQuizRules(c.Request.Context(), courseID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQuizRuleGetFailed)
+		return
+	}
+	loc := requestLocation(c)
+	for i := range rules {
+		rules[i].CreatedAt = rules[i].CreatedAt.In(loc)
+	}
+	respondOK(c, http.StatusOK, rules)
+}
+
+// 添加答题规则，之后的推送生效
+func createQuizRule(c *gin.Context) {
+	courseID, ok := intParam(c, "course_id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Metric        string  `json:"metric" binding:"required"`
+		Operator      string  `json:"operator" binding:"required"`
+		Threshold     float64 `json:"threshold" binding:"min=0,max=100"`
+		AfterSeconds  int     `json:"after_seconds" binding:"min=0,max=3600"`
+		MinAnswers    int     `json:"min_answers" binding:"min=0"`
+		Action        string  `json:"action" binding:"required"`
+		ActionSeconds int     `json:"action_seconds" binding:"min=0,max=3600"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	switch {
+	case !contains(ruleMetrics, req.Metric):
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "metric")
+		return
+	case !contains(ruleOperators, req.Operator):
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "operator")
+		return
+	case !contains(ruleActions, req.Action):
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "action")
+		return
+	case req.Action == RuleExtend && req.ActionSeconds == 0:
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "action_seconds")
+		return
+	}
+
+	ctx := c.Request.Context()
+	var count int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM quiz_rules WHERE course_id = ?", courseID).Scan(&count); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQuizRuleUpdateFailed)
+		return
+	}
+	if count >= maxCourseQuizRules {
+		respondError(c, http.StatusConflict, CodeQuizRuleLimit, maxCourseQuizRules)
+		return
+	}
+
+	r := QuizRule{
+		CourseID:      courseID,
+		Metric:        req.Metric,
+		Operator:      req.Operator,
+		Threshold:     req.Threshold,
+		AfterSeconds:  req.AfterSeconds,
+		MinAnswers:    req.MinAnswers,
+		Action:        req.Action,
+		ActionSeconds: req.ActionSeconds,
+		CreatedBy:     currentUser(c).ID,
+		CreatedAt:     time.Now().UTC(),
+	}
+	if r.Action != RuleExtend {
+		r.ActionSeconds = 0
+	}
+	id, err := dialect.insertID(db, `
+		INSERT INTO quiz_rules (course_id, metric, operator, threshold, after_seconds, min_answers, action, action_seconds, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, r.CourseID, r.Metric, r.Operator, r.Threshold, r.AfterSeconds, r.MinAnswers, r.Action, r.ActionSeconds, r.CreatedBy, r.CreatedAt)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQuizRuleUpdateFailed)
+		return
+	}
+	r.ID = int(id)
+
+	recordAudit(c, "create_quiz_rule", "course", courseID, gin.H{"rule_id": r.ID, "metric": r.Metric, "action": r.Action})
+	r.CreatedAt = r.CreatedAt.In(requestLocation(c))
+	respondOK(c, http.StatusCreated, r)
+}
+
+// 删除答题规则
+func deleteQuizRule(c *gin.Context) {
+	courseID, ok := intParam(c, "course_id")
+	if !ok {
+		return
+	}
+	ruleID, ok := intParam(c, "rule_id")
+	if !ok {
+		return
+	}
+	res, err := db.Exec("DELETE FROM quiz_rules WHERE id = ? AND course_id = ?", ruleID, courseID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeQuizRuleUpdateFailed)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, CodeQuizRuleNotFound)
+		return
+	}
+	recordAudit(c, "delete_quiz_rule", "course", courseID, gin.H{"rule_id": ruleID})
+	respondOK(c, http.StatusOK, gin.H{"id": ruleID})
+}
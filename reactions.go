@@ -0,0 +1,195 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	reactionWindow        = 10 * time.Second
+	maxReactionsPerWindow = 10 // 每个连接在一个统计窗口内最多计入的次数
+)
+
+var reactionKinds = []string{"like", "confused", "clap", "wow"}
+
+// 一个统计窗口内的表情反馈数量
+type ReactionWindow struct {
+	WindowStart  time.Time      `json:"window_start"`
+	StreamOffset *int           `json:"stream_offset,omitempty"` // 相对开播时间的秒数
+	Counts       map[string]int `json:"counts"`
+}
+
+// 按会话汇总当前窗口的表情反馈，定期写入数据库并推送给老师
+type reactionAggregator struct {
+	mu      sync.Mutex
+	counts  map[int]map[string]int // session -> kind -> count
+	perConn map[*Client]int
+}
+
+var reactions = &reactionAggregator{
+	counts:  make(map[int]map[string]int),
+	perConn: make(map[*Client]int),
+}
+
+func (a *reactionAggregator) add(c *Client, kind string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.perConn[c] >= maxReactionsPerWindow {
+		return false
+	}
+	a.perConn[c]++
+	if a.counts[c.sessionID] == nil {
+		a.counts[c.sessionID] = make(map[string]int)
+	}
+	a.counts[c.sessionID][kind]++
+	return true
+}
+
+// 取出当前窗口的数据并开始新窗口
+func (a *reactionAggregator) drain() map[int]map[string]int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	counts := a.counts
+	a.counts = make(map[int]map[string]int)
+	a.perConn = make(map[*Client]int)
+	return counts
+}
+
+// 每个窗口结束时写入并推送汇总，多副本时按窗口累加
+func runReactionAggregator() {
+	ticker := time.NewTicker(reactionWindow)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		windowStart := now.UTC().Add(-reactionWindow).Truncate(reactionWindow)
+		for sessionID, counts := range reactions.drain() {
+			window, err := saveReactionWindow(sessionID, windowStart, counts)
+			if err != nil {
+				log.Printf("Failed to save reactions for session %d: %v", sessionID, err)
+				continue
+			}
+			hub.broadcast(teacherRoom(sessionID), Message{Type: "engagement", Data: window})
+		}
+	}
+}
+
+func saveReactionWindow(sessionID int, windowStart time.Time, counts map[string]int) (ReactionWindow, error) {
+	window := ReactionWindow{WindowStart: windowStart, Counts: make(map[string]int)}
+
+	var startTime sql.NullTime
+	if err := db.QueryRow("SELECT start_time FROM live_sessions WHERE id = ?", sessionID).Scan(&startTime); err != nil {
+		return window, err
+	}
+	if startTime.Valid {
+		offset := int(windowStart.Sub(startTime.Time).Seconds())
+		window.StreamOffset = &offset
+	}
+
+	for kind, count := range counts {
+		_, err := db.Exec(dialect.upsert(`
+			INSERT INTO session_reactions (session_id, window_start, stream_offset, kind, count)
+			VALUES (?, ?, ?, ?, ?)`,
+			[]string{"session_id", "window_start", "kind"},
+			"count = session_reactions.count + EXCLUDED.count",
+		), sessionID, windowStart, window.StreamOffset, kind, count)
+		if err != nil {
+			return window, err
+		}
+	}
+
+	// 推送所有副本累加后的数量
+	rows, err := db.Query(`
+		SELECT kind, count FROM session_reactions
+		WHERE session_id = ? AND window_start = ?
+	`, sessionID, windowStart)
+	if err != nil {
+		return window, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind string
+		var count int
+		if err := rows.Scan(&kind, &count); err != nil {
+			return window, err
+		}
+		window.Counts[kind] = count
+	}
+	return window, rows.Err()
+}
+
+// 会话的表情反馈时间线，可用 from/to（开播后秒数）截取
+func getSessionReactions(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	if !sessionExists(c, sessionID) {
+		return
+	}
+
+	q := "SELECT window_start, stream_offset, kind, count FROM session_reactions WHERE session_id = ?"
+	args := []interface{}{sessionID}
+	if from := c.Query("from"); from != "" {
+		q += " AND stream_offset >= ?"
+		args = append(args, from)
+	}
+	if to := c.Query("to"); to != "" {
+		q += " AND stream_offset < ?"
+		args = append(args, to)
+	}
+	rows, err := db.Query(q+" ORDER BY window_start", args...)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeReactionGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	windows := []ReactionWindow{}
+	for rows.Next() {
+		var start time.Time
+		var offset sql.NullInt64
+		var kind string
+		var count int
+		if err := rows.Scan(&start, &offset, &kind, &count); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeReactionGetFailed)
+			return
+		}
+		start = start.In(loc)
+		if n := len(windows); n == 0 || !windows[n-1].WindowStart.Equal(start) {
+			w := ReactionWindow{WindowStart: start, Counts: make(map[string]int)}
+			if offset.Valid {
+				v := int(offset.Int64)
+				w.StreamOffset = &v
+			}
+			windows = append(windows, w)
+		}
+		windows[len(windows)-1].Counts[kind] = count
+	}
+
+	respondOK(c, http.StatusOK, windows)
+}
+
+func init() {
+	// 学生发送表情反馈，data 为反馈类型，如 "like" 或 {"kind": "like"}
+	wsHandlers["reaction"] = func(c *Client, msg Message) {
+		var kind string
+		switch data := msg.Data.(type) {
+		case string:
+			kind = data
+		case map[string]interface{}:
+			kind, _ = data["kind"].(string)
+		}
+		if !contains(reactionKinds, kind) {
+			c.sendError(CodeReactionInvalid)
+			return
+		}
+		if !reactions.add(c, kind) {
+			c.sendError(CodeReactionRateLimited)
+		}
+	}
+}
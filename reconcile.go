@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const reconcileLockKey = "zhibo:reconcile"
+
+// 一次核对的处理结果
+type ReconcileAction struct {
+	SessionID int    `json:"session_id,omitempty"`
+	StreamKey string `json:"stream_key"`
+	From      string `json:"from,omitempty"` // 会话原状态
+	To        string `json:"to,omitempty"`   // 会话更正后的状态
+	Reason    string `json:"reason"`
+	Error     string `json:"error,omitempty"`
+}
+
+// 数据库与流媒体服务器的核对结果：corrected 为更正的会话，terminated 为断开的推流
+type ReconcileReport struct {
+	Streams    int               `json:"streams"`
+	Corrected  []ReconcileAction `json:"corrected"`
+	Terminated []ReconcileAction `json:"terminated"`
+}
+
+// 以流媒体服务器上的推流为准核对会话状态：
+// 没有推流的直播中会话标记为中断，有推流的中断或待开始会话改为直播中；
+// 找不到会话、会话已结束、推流端已失效或分组讨论已关闭的推流直接断开
+func reconcileStreams(ctx context.Context, srv mediaServer, stats []StreamStats) (ReconcileReport, error) {
+	report := ReconcileReport{Streams: len(stats), Corrected: []ReconcileAction{}, Terminated: []ReconcileAction{}}
+	present := make(map[string]bool, len(stats))
+	for _, s := range stats {
+		present[s.StreamKey] = true
+	}
+
+	rows, err := db.Query("SELECT id, stream_key, status FROM live_sessions WHERE status IN ('pending', 'live', 'interrupted')")
+	if err != nil {
+		return report, err
+	}
+	type activeSession struct {
+		id        int
+		streamKey string
+		status    string
+	}
+	var sessions []activeSession
+	for rows.Next() {
+		var s activeSession
+		if err := rows.Scan(&s.id, &s.streamKey, &s.status); err != nil {
+			rows.Close()
+			return report, err
+		}
+		sessions = append(sessions, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	known := make(map[string]bool, len(sessions))
+	for _, s := range sessions {
+		known[s.streamKey] = true
+		action := ReconcileAction{SessionID: s.id, StreamKey: s.streamKey, From: s.status}
+		switch {
+		case s.status == "live" && !present[s.streamKey]:
+			if !interruptSession(s.id) {
+				continue
+			}
+			action.To, action.Reason = "interrupted", "stream_missing"
+		case s.status == "interrupted" && present[s.streamKey]:
+			if !resumeInterruptedSession(s.id) {
+				continue
+			}
+			action.To, action.Reason = "live", "stream_active"
+		case s.status == "pending" && present[s.streamKey]:
+			// 推流开始的回调丢失
+			if !startSessionFromStream(s.id) {
+				continue
+			}
+			action.To, action.Reason = "live", "stream_active"
+		default:
+			continue
+		}
+		report.Corrected = append(report.Corrected, action)
+	}
+
+	for _, s := range stats {
+		// 音频转码的推流随主讲推流保留
+		if known[strings.TrimSuffix(s.StreamKey, audioVariantSuffix)] {
+			continue
+		}
+		reason, err := orphanStreamReason(s.StreamKey)
+		if err != nil {
+			return report, err
+		}
+		if reason == "" {
+			continue
+		}
+		action := ReconcileAction{StreamKey: s.StreamKey, Reason: reason}
+		if err := srv.DeleteStream(ctx, s.StreamKey); err != nil {
+			action.Error = err.Error()
+		}
+		report.Terminated = append(report.Terminated, action)
+	}
+	return report, nil
+}
+
+// 不属于进行中会话的推流的断开原因，连麦推流端或分组讨论仍有效时返回空
+func orphanStreamReason(streamKey string) (string, error) {
+	var status string
+	err := db.QueryRow("SELECT status FROM live_sessions WHERE stream_key = ?", strings.TrimSuffix(streamKey, audioVariantSuffix)).Scan(&status)
+	if err == nil {
+		return "session_" + status, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+	err = db.QueryRow("SELECT status FROM session_publishers WHERE stream_key = ?", streamKey).Scan(&status)
+	if err == nil {
+		if status == PublisherEnded {
+			return "publisher_ended", nil
+		}
+		return "", nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+	err = db.QueryRow("SELECT status FROM breakout_groups WHERE stream_key = ?", streamKey).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "unknown_stream", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if status != "open" {
+		return "breakout_" + status, nil
+	}
+	return "", nil
+}
+
+// 待开始的会话已有推流时改为直播中
+func startSessionFromStream(sessionID int) bool {
+	changed := false
+	err := withLock(sessionLockName(sessionID), func() error {
+		result, err := db.Exec(`
+			UPDATE live_sessions
+			SET status = 'live', start_time = COALESCE(start_time, NOW()), stream_misses = 0
+			WHERE id = ? AND status = 'pending'
+		`, sessionID)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		changed = n > 0
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to start session %d from stream: %v", sessionID, err)
+		return false
+	}
+	if changed {
+		notifySessionStatus(sessionID, "live")
+	}
+	return changed
+}
+
+// 启动时核对一次，多副本同时启动时只由抢到锁的实例执行
+func reconcileOnStartup() {
+	if livegoStubbed() {
+		return
+	}
+	unlock, err := acquireLock(reconcileLockKey, 0)
+	if err != nil {
+		if !errors.Is(err, errLockTimeout) {
+			log.Printf("Failed to lock startup reconciliation: %v", err)
+		}
+		return
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), streamPollTimeout)
+	defer cancel()
+	srv := media()
+	stats, err := srv.GetStats(ctx)
+	if err != nil {
+		log.Printf("Startup reconciliation skipped, media server unavailable: %v", err)
+		return
+	}
+	report, err := reconcileStreams(ctx, srv, stats)
+	if err != nil {
+		log.Printf("Startup reconciliation failed: %v", err)
+		return
+	}
+	if len(report.Corrected)+len(report.Terminated) > 0 {
+		log.Printf("Startup reconciliation: %d sessions corrected, %d streams terminated", len(report.Corrected), len(report.Terminated))
+	}
+}
+
+// 管理员手动核对会话状态与流媒体服务器
+func adminReconcileStreams(c *gin.Context) {
+	unlock, err := acquireLock(reconcileLockKey, lockWait)
+	if errors.Is(err, errLockTimeout) {
+		respondError(c, http.StatusConflict, CodeReconcileInProgress)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	defer unlock()
+
+	srv := media()
+	stats, err := srv.GetStats(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusBadGateway, CodeMediaServerUnavailable)
+		return
+	}
+	report, err := reconcileStreams(c.Request.Context(), srv, stats)
+	if err != nil {
+		log.Printf("Reconciliation failed: %v", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	recordAudit(c, "reconcile_streams", "live_session", 0, gin.H{
+		"corrected":  len(report.Corrected),
+		"terminated": len(report.Terminated),
+	})
+	respondOK(c, http.StatusOK, report)
+}
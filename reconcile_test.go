@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// 只记录断开的推流
+type fakeMediaServer struct {
+	mediaServer
+	deleted []string
+}
+
+func (s *fakeMediaServer) DeleteStream(ctx context.Context, streamKey string) error {
+	s.deleted = append(s.deleted, streamKey)
+	return nil
+}
+
+func TestOrphanStreamReason(t *testing.T) {
+	openTestDB(t)
+	mustExec(t, "INSERT INTO live_sessions (id, course_id, stream_key, status, created_at) VALUES (1, 1, 'main-live', 'live', NOW())")
+	mustExec(t, "INSERT INTO live_sessions (id, course_id, stream_key, status, created_at) VALUES (2, 1, 'main-ended', 'ended', NOW())")
+	mustExec(t, "INSERT INTO session_publishers (session_id, name, stream_key, layout, status, created_at) VALUES (1, 'cam', 'pub-active', 'pip', ?, NOW())", PublisherLive)
+	mustExec(t, "INSERT INTO session_publishers (session_id, name, stream_key, layout, status, created_at) VALUES (1, 'cam', 'pub-ended', 'pip', ?, NOW())", PublisherEnded)
+	mustExec(t, "INSERT INTO breakout_groups (session_id, name, stream_key, status, created_at) VALUES (1, 'g1', 'group-open', 'open', NOW())")
+	mustExec(t, "INSERT INTO breakout_groups (session_id, name, stream_key, status, created_at) VALUES (1, 'g2', 'group-closed', 'closed', NOW())")
+
+	tests := []struct {
+		streamKey string
+		want      string
+	}{
+		{"main-ended", "session_ended"},
+		{"main-ended" + audioVariantSuffix, "session_ended"},
+		{"pub-active", ""},
+		{"pub-ended", "publisher_ended"},
+		{"group-open", ""},
+		{"group-closed", "breakout_closed"},
+		{"nobody", "unknown_stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.streamKey, func(t *testing.T) {
+			got, err := orphanStreamReason(tt.streamKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("orphanStreamReason(%q) = %q, want %q", tt.streamKey, got, tt.want)
+			}
+		})
+	}
+}
+
+// 分组讨论的推流不属于任何会话的主推流码，仍在进行时不能被断开
+func TestReconcileStreamsKeepsBreakoutStreams(t *testing.T) {
+	openTestDB(t)
+	mustExec(t, "INSERT INTO live_sessions (id, course_id, stream_key, status, created_at) VALUES (1, 1, 'main-live', 'live', NOW())")
+	mustExec(t, "INSERT INTO breakout_groups (session_id, name, stream_key, status, created_at) VALUES (1, 'g1', 'group-open', 'open', NOW())")
+	mustExec(t, "INSERT INTO breakout_groups (session_id, name, stream_key, status, created_at) VALUES (1, 'g2', 'group-closed', 'closed', NOW())")
+
+	srv := &fakeMediaServer{}
+	stats := []StreamStats{{StreamKey: "main-live"}, {StreamKey: "group-open"}, {StreamKey: "group-closed"}, {StreamKey: "nobody"}}
+	report, err := reconcileStreams(context.Background(), srv, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Corrected) != 0 {
+		t.Errorf("corrected = %+v, want none", report.Corrected)
+	}
+	sort.Strings(srv.deleted)
+	if want := []string{"group-closed", "nobody"}; !reflect.DeepEqual(srv.deleted, want) {
+		t.Errorf("deleted streams = %v, want %v", srv.deleted, want)
+	}
+	reasons := map[string]string{}
+	for _, a := range report.Terminated {
+		reasons[a.StreamKey] = a.Reason
+	}
+	if want := map[string]string{"group-closed": "breakout_closed", "nobody": "unknown_stream"}; !reflect.DeepEqual(reasons, want) {
+		t.Errorf("terminated = %v, want %v", reasons, want)
+	}
+}
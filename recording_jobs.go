@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 录像处理任务状态
+const (
+	RecordingJobQueued    = "queued"
+	RecordingJobRunning   = "running"
+	RecordingJobSucceeded = "succeeded"
+	RecordingJobFailed    = "failed"
+)
+
+// 录像处理步骤
+const (
+	stepConcat = "concat"
+	stepTrim   = "trim"
+	stepEncode = "encode"
+	stepUpload = "upload"
+)
+
+const (
+	deadAirProbe     = 10 * time.Minute // 只在开头这段时间内检测静音
+	deadAirNoise     = "-40dB"
+	deadAirMinLength = 2 * time.Second
+)
+
+var silenceEndPattern = regexp.MustCompile(`silence_start: (-?[0-9.]+)[\s\S]*?silence_end: ([0-9.]+)`)
+
+// 录像后处理任务
+type RecordingJob struct {
+	ID          int        `json:"id"`
+	RecordingID int        `json:"recording_id"`
+	Status      string     `json:"status"`
+	Step        string     `json:"step,omitempty"`
+	TrimDeadAir bool       `json:"trim_dead_air"`
+	Watermark   bool       `json:"watermark"` // 是否烧录取证水印
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// 队列中的任务类型
+const (
+	jobRecordingProcess    = "recording.process"
+	jobRecordingTranscribe = "recording.transcribe"
+)
+
+func init() {
+	jobHandlers[jobRecordingProcess] = func(ctx context.Context, payload json.RawMessage) error {
+		var p struct {
+			JobID int `json:"recording_job_id"`
+		}
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return runRecordingJob(p.JobID)
+	}
+	jobHandlers[jobRecordingTranscribe] = func(ctx context.Context, payload json.RawMessage) error {
+		var p struct {
+			RecordingID int `json:"recording_id"`
+		}
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		rec, err := getRecording("id", p.RecordingID)
+		if err != nil {
+			return err
+		}
+		return transcribeRecording(rec)
+	}
+}
+
+// 登记处理任务并放入后台队列
+func createRecordingJob(recordingID int, trimDeadAir, watermark bool) (RecordingJob, error) {
+	job := RecordingJob{
+		RecordingID: recordingID,
+		Status:      RecordingJobQueued,
+		TrimDeadAir: trimDeadAir,
+		Watermark:   watermark,
+		CreatedAt:   time.Now().UTC(),
+	}
+	id, err := dialect.insertID(db, `
+		INSERT INTO recording_jobs (recording_id, status, trim_dead_air, watermark, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, job.RecordingID, job.Status, job.TrimDeadAir, job.Watermark, job.CreatedAt)
+	if err != nil {
+		return job, err
+	}
+	job.ID = int(id)
+
+	_, err = enqueueJob(jobRecordingProcess, gin.H{"recording_job_id": job.ID}, time.Time{})
+	return job, err
+}
+
+// 执行录像处理任务：合并分段、裁剪开头静音、上传并更新录像，完成后提交转写
+func runRecordingJob(jobID int) error {
+	var job RecordingJob
+	err := db.QueryRow("SELECT id, recording_id, trim_dead_air, watermark FROM recording_jobs WHERE id = ?", jobID).
+		Scan(&job.ID, &job.RecordingID, &job.TrimDeadAir, &job.Watermark)
+	if err != nil {
+		return err
+	}
+
+	db.Exec("UPDATE recording_jobs SET status = ?, step = '', error = NULL, started_at = ? WHERE id = ?", RecordingJobRunning, time.Now().UTC(), job.ID)
+	db.Exec("UPDATE recordings SET status = ?, updated_at = NOW() WHERE id = ?", RecordingProcessing, job.RecordingID)
+
+	finish := func(status, recStatus, msg string) {
+		db.Exec("UPDATE recording_jobs SET status = ?, error = NULLIF(?, ''), finished_at = ? WHERE id = ?", status, msg, time.Now().UTC(), job.ID)
+		db.Exec("UPDATE recordings SET status = ?, updated_at = NOW() WHERE id = ?", recStatus, job.RecordingID)
+	}
+	if err := processRecording(job); err != nil {
+		finish(RecordingJobFailed, RecordingFailed, err.Error())
+		return err
+	}
+	finish(RecordingJobSucceeded, RecordingReady, "")
+
+	// 转写在裁剪之后进行，时间轴与 MP4 对齐
+	if asrEnabled() {
+		if _, err := enqueueJob(jobRecordingTranscribe, gin.H{"recording_id": job.RecordingID}, time.Time{}); err != nil {
+			log.Printf("Failed to enqueue transcription for recording %d: %v", job.RecordingID, err)
+		}
+	}
+	return nil
+}
+
+func processRecording(job RecordingJob) error {
+	setStep := func(step string) {
+		db.Exec("UPDATE recording_jobs SET step = ? WHERE id = ?", step, job.ID)
+	}
+
+	rec, err := getRecording("id", job.RecordingID)
+	if err != nil {
+		return err
+	}
+	if len(rec.Segments) == 0 {
+		return errNoRecordingSegments
+	}
+
+	workDir, err := os.MkdirTemp("", fmt.Sprintf("recording-job-%d-", job.ID))
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	setStep(stepConcat)
+	list, err := writeConcatList(rec.Segments, workDir)
+	if err != nil {
+		return err
+	}
+
+	var trim time.Duration
+	if job.TrimDeadAir {
+		setStep(stepTrim)
+		if trim, err = detectLeadingSilence(list); err != nil {
+			return err
+		}
+	}
+
+	// FLV 中的 H.264/AAC 可直接封装为 MP4，只有烧录水印时才需要重新编码视频
+	out := filepath.Join(workDir, "recording.mp4")
+	args := []string{"-hide_banner", "-loglevel", "error", "-f", "concat", "-safe", "0", "-i", list}
+	if trim > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(trim.Seconds(), 'f', 3, 64))
+	}
+	if job.Watermark {
+		setStep(stepEncode)
+		args = append(args, "-vf", forensicWatermarkFilter(forensicMark(rec.ID)),
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-c:a", "copy")
+	} else {
+		args = append(args, "-c", "copy")
+	}
+	args = append(args, "-movflags", "+faststart", "-y", out)
+	if output, err := exec.Command(ffmpegPath(), args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, output)
+	}
+
+	// 上传前检查课程和机构的录像配额，重新处理时替换原文件，不计入原大小
+	info, err := os.Stat(out)
+	if err != nil {
+		return err
+	}
+	if err := checkRecordingQuota(rec.ID, info.Size()); err != nil {
+		return err
+	}
+
+	setStep(stepUpload)
+	key := fmt.Sprintf("recordings/%d.mp4", rec.ID)
+	if err := putFile(context.Background(), key, out, "video/mp4"); err != nil {
+		return err
+	}
+
+	// 新上传的文件为标准存储，重新计算生命周期
+	_, err = db.Exec(`UPDATE recordings SET video_key = ?, trim_ms = ?, size_bytes = ?, storage_class = ?,
+		archived_at = NULL, delete_warned_at = NULL, deleted_at = NULL, updated_at = NOW() WHERE id = ?`,
+		key, trim.Milliseconds(), info.Size(), StorageClassStandard, rec.ID)
+	return err
+}
+
+// 检测开头的静音时长，开头不是静音时返回 0
+func detectLeadingSilence(list string) (time.Duration, error) {
+	cmd := exec.Command(ffmpegPath(), "-hide_banner", "-nostats",
+		"-f", "concat", "-safe", "0", "-i", list,
+		"-t", strconv.Itoa(int(deadAirProbe.Seconds())),
+		"-vn", "-af", fmt.Sprintf("silencedetect=noise=%s:d=%.1f", deadAirNoise, deadAirMinLength.Seconds()),
+		"-f", "null", "-")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("ffmpeg silencedetect failed: %v: %s", err, output)
+	}
+
+	m := silenceEndPattern.FindSubmatch(output)
+	if m == nil {
+		return 0, nil
+	}
+	start, _ := strconv.ParseFloat(string(m[1]), 64)
+	end, _ := strconv.ParseFloat(string(m[2]), 64)
+	if start > 0.5 {
+		return 0, nil
+	}
+	return time.Duration(end * float64(time.Second)), nil
+}
+
+// 录像的处理任务记录，最新的在前
+func listRecordingJobs(c *gin.Context) {
+	rec, ok := recordingParam(c)
+	if !ok {
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, recording_id, status, step, trim_dead_air, watermark, COALESCE(error, ''), created_at, started_at, finished_at
+		FROM recording_jobs
+		WHERE recording_id = ?
+		ORDER BY id DESC
+	`, rec.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeRecordingJobGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	jobs := []RecordingJob{}
+	for rows.Next() {
+		var job RecordingJob
+		if err := rows.Scan(&job.ID, &job.RecordingID, &job.Status, &job.Step, &job.TrimDeadAir, &job.Watermark, &job.Error,
+			&job.CreatedAt, &job.StartedAt, &job.FinishedAt); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeRecordingJobGetFailed)
+			return
+		}
+		job.CreatedAt = job.CreatedAt.In(loc)
+		job.StartedAt = inLocation(job.StartedAt, loc)
+		job.FinishedAt = inLocation(job.FinishedAt, loc)
+		jobs = append(jobs, job)
+	}
+
+	respondOK(c, http.StatusOK, jobs)
+}
+
+// 重新处理录像，trim_dead_air 未指定时使用全局配置，watermark 未指定时使用会话设置
+func createRecordingJobHandler(c *gin.Context) {
+	rec, ok := recordingParam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		TrimDeadAir *bool `json:"trim_dead_air"`
+		Watermark   *bool `json:"watermark"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		respondBindError(c, err)
+		return
+	}
+	trim := currentConfig().TrimDeadAir
+	if req.TrimDeadAir != nil {
+		trim = *req.TrimDeadAir
+	}
+	var watermark bool
+	if req.Watermark != nil {
+		watermark = *req.Watermark
+	} else if settings, err := loadSessionSettings(rec.SessionID); err == nil {
+		watermark = settings.ForensicWatermark
+	}
+
+	var pending int
+	err := db.QueryRow("SELECT id FROM recording_jobs WHERE recording_id = ? AND status IN (?, ?) LIMIT 1",
+		rec.ID, RecordingJobQueued, RecordingJobRunning).Scan(&pending)
+	if err == nil {
+		respondError(c, http.StatusConflict, CodeRecordingJobRunning)
+		return
+	}
+	if err != sql.ErrNoRows {
+		respondError(c, http.StatusInternalServerError, CodeRecordingJobCreateFailed)
+		return
+	}
+
+	job, err := createRecordingJob(rec.ID, trim, watermark)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeRecordingJobCreateFailed)
+		return
+	}
+	job.CreatedAt = job.CreatedAt.In(requestLocation(c))
+	respondOK(c, http.StatusAccepted, job)
+}
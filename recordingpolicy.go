@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	jobRecordingLifecycle    = "recordings.lifecycle"
+	recordingLifecycleRun    = 24 * time.Hour
+	defaultRecordingWarnDays = 7
+	StorageClassStandard     = "standard"
+	StorageClassCold         = "cold"
+)
+
+var errRecordingQuotaExceeded = errors.New("recording storage quota exceeded")
+
+// 录像生命周期的默认值，可被机构和课程的录像策略覆盖；天数为 0 时不转存或不删除
+type RecordingPolicyConfig struct {
+	ColdAfterDays   int `json:"cold_after_days"`   // 超过天数的录像转为低频存储
+	DeleteAfterDays int `json:"delete_after_days"` // 超过天数的录像删除
+	WarnDays        int `json:"warn_days"`         // 删除前提前通知授课老师的天数，为 0 时使用 7 天
+}
+
+// 课程或机构的录像存储配额与生命周期。course_id 不为 0 时为课程策略，否则为机构策略；
+// 配额分别计算，课程和所属机构的配额都不能超出。天数为空时沿用上一级，课程优先于机构，机构优先于配置文件
+type RecordingPolicy struct {
+	CourseID        int       `json:"course_id,omitempty"`
+	Org             string    `json:"org,omitempty"`
+	QuotaBytes      int64     `json:"quota_bytes"` // 为 0 时不限制
+	ColdAfterDays   *int      `json:"cold_after_days"`
+	DeleteAfterDays *int      `json:"delete_after_days"`
+	UpdatedBy       int       `json:"updated_by"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// 课程或机构的录像用量
+type RecordingUsage struct {
+	CourseID   int    `json:"course_id,omitempty"`
+	Org        string `json:"org,omitempty"`
+	Recordings int    `json:"recordings"`
+	Bytes      int64  `json:"bytes"`
+	ColdBytes  int64  `json:"cold_bytes"`
+	QuotaBytes int64  `json:"quota_bytes"`
+}
+
+func init() {
+	// 每天执行一次，完成后安排下一次
+	jobHandlers[jobRecordingLifecycle] = func(ctx context.Context, payload json.RawMessage) error {
+		if err := applyRecordingLifecycle(ctx, time.Now().UTC()); err != nil {
+			return err
+		}
+		_, err := enqueueJob(jobRecordingLifecycle, nil, time.Now().Add(recordingLifecycleRun))
+		return err
+	}
+}
+
+// 启动时确保队列中有生命周期任务，多副本只安排一个
+func scheduleRecordingLifecycle() {
+	err := withLock("zhibo:schedule:recording-lifecycle", func() error {
+		var id int
+		err := db.QueryRow("SELECT id FROM jobs WHERE type = ? AND status IN (?, ?) LIMIT 1",
+			jobRecordingLifecycle, JobQueued, JobRunning).Scan(&id)
+		if err != sql.ErrNoRows {
+			return err
+		}
+		_, err = enqueueJob(jobRecordingLifecycle, nil, time.Time{})
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to schedule recording lifecycle job: %v", err)
+	}
+}
+
+func queryRecordingPolicies() ([]RecordingPolicy, error) {
+	rows, err := db.Query(`
+		SELECT course_id, org, quota_bytes, cold_after_days, delete_after_days, updated_by, updated_at
+		FROM recording_policies
+		ORDER BY course_id, org
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []RecordingPolicy{}
+	for rows.Next() {
+		var p RecordingPolicy
+		if err := rows.Scan(&p.CourseID, &p.Org, &p.QuotaBytes, &p.ColdAfterDays, &p.DeleteAfterDays, &p.UpdatedBy, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+type recordingPolicies map[networkKey]RecordingPolicy
+
+func loadRecordingPolicies() (recordingPolicies, error) {
+	list, err := queryRecordingPolicies()
+	if err != nil {
+		return nil, err
+	}
+	policies := make(recordingPolicies, len(list))
+	for _, p := range list {
+		policies[networkKey{p.CourseID, p.Org}] = p
+	}
+	return policies, nil
+}
+
+// 课程适用的转存和删除天数
+func (policies recordingPolicies) lifecycle(courseID int, org string) (coldDays, deleteDays int) {
+	conf := currentConfig().RecordingPolicy
+	coldDays, deleteDays = conf.ColdAfterDays, conf.DeleteAfterDays
+	for _, key := range []networkKey{{0, org}, {courseID, ""}} {
+		if key.org == "" && key.courseID == 0 {
+			continue
+		}
+		p, ok := policies[key]
+		if !ok {
+			continue
+		}
+		if p.ColdAfterDays != nil {
+			coldDays = *p.ColdAfterDays
+		}
+		if p.DeleteAfterDays != nil {
+			deleteDays = *p.DeleteAfterDays
+		}
+	}
+	return coldDays, deleteDays
+}
+
+func recordingWarnDays() int {
+	if days := currentConfig().RecordingPolicy.WarnDays; days > 0 {
+		return days
+	}
+	return defaultRecordingWarnDays
+}
+
+// 课程或机构未删除录像的用量，exceptID 不为 0 时不计入该录像（重新处理时替换原文件）
+func recordingUsage(courseID int, org string, exceptID int) (RecordingUsage, error) {
+	usage := RecordingUsage{CourseID: courseID, Org: org}
+	q := `
+		SELECT COUNT(*), COALESCE(SUM(r.size_bytes), 0),
+			COALESCE(SUM(CASE WHEN r.storage_class = ? THEN r.size_bytes ELSE 0 END), 0)
+		FROM recordings r
+		JOIN live_sessions s ON s.id = r.session_id
+		JOIN users u ON u.id = s.teacher_id
+		WHERE r.deleted_at IS NULL AND r.id <> ?`
+	args := []interface{}{StorageClassCold, exceptID}
+	if courseID != 0 {
+		q += " AND s.course_id = ?"
+		args = append(args, courseID)
+	} else {
+		q += " AND u.org = ?"
+		args = append(args, org)
+	}
+	err := db.QueryRow(q, args...).Scan(&usage.Recordings, &usage.Bytes, &usage.ColdBytes)
+	return usage, err
+}
+
+// 录像所属课程和授课老师的机构
+func recordingOwner(recordingID int) (courseID, teacherID int, org string, err error) {
+	err = db.QueryRow(`
+		SELECT s.course_id, s.teacher_id, u.org
+		FROM recordings r
+		JOIN live_sessions s ON s.id = r.session_id
+		JOIN users u ON u.id = s.teacher_id
+		WHERE r.id = ?
+	`, recordingID).Scan(&courseID, &teacherID, &org)
+	return courseID, teacherID, org, err
+}
+
+// 检查写入 size 字节的录像后是否超出课程或机构的配额
+func checkRecordingQuota(recordingID int, size int64) error {
+	courseID, _, org, err := recordingOwner(recordingID)
+	if err != nil {
+		return err
+	}
+	policies, err := loadRecordingPolicies()
+	if err != nil {
+		return err
+	}
+	for _, key := range []networkKey{{courseID, ""}, {0, org}} {
+		if key.org == "" && key.courseID == 0 {
+			continue
+		}
+		p, ok := policies[key]
+		if !ok || p.QuotaBytes <= 0 {
+			continue
+		}
+		usage, err := recordingUsage(key.courseID, key.org, recordingID)
+		if err != nil {
+			return err
+		}
+		if usage.Bytes+size > p.QuotaBytes {
+			if key.courseID != 0 {
+				return fmt.Errorf("%w: course %d uses %d of %d bytes", errRecordingQuotaExceeded, key.courseID, usage.Bytes, p.QuotaBytes)
+			}
+			return fmt.Errorf("%w: org %q uses %d of %d bytes", errRecordingQuotaExceeded, key.org, usage.Bytes, p.QuotaBytes)
+		}
+	}
+	return nil
+}
+
+// 按生命周期转存或删除录像。删除前先通知授课老师，通知满 warn_days 天后才删除
+func applyRecordingLifecycle(ctx context.Context, now time.Time) error {
+	policies, err := loadRecordingPolicies()
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query(`
+		SELECT r.id, r.session_id, r.video_key, r.storage_class, r.created_at, r.delete_warned_at, s.course_id, s.teacher_id, u.org
+		FROM recordings r
+		JOIN live_sessions s ON s.id = r.session_id
+		JOIN users u ON u.id = s.teacher_id
+		WHERE r.deleted_at IS NULL AND r.video_key <> ''
+		ORDER BY r.id
+	`)
+	if err != nil {
+		return err
+	}
+	type candidate struct {
+		id, sessionID, courseID, teacherID int
+		videoKey, storageClass, org        string
+		createdAt                          time.Time
+		warnedAt                           *time.Time
+	}
+	var candidates []candidate
+	for rows.Next() {
+		var r candidate
+		if err := rows.Scan(&r.id, &r.sessionID, &r.videoKey, &r.storageClass, &r.createdAt, &r.warnedAt, &r.courseID, &r.teacherID, &r.org); err != nil {
+			rows.Close()
+			return err
+		}
+		candidates = append(candidates, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	warnDays := recordingWarnDays()
+	archive, canArchive := storage.(archiver)
+	var archived, warned, deleted int
+	for _, r := range candidates {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		coldDays, deleteDays := policies.lifecycle(r.courseID, r.org)
+
+		if deleteDays > 0 {
+			deleteAt := r.createdAt.AddDate(0, 0, deleteDays)
+			// 未通知过时从通知之日起顺延，保证老师至少提前 warn_days 天收到通知
+			if r.warnedAt != nil && r.warnedAt.AddDate(0, 0, warnDays).After(deleteAt) {
+				deleteAt = r.warnedAt.AddDate(0, 0, warnDays)
+			}
+			switch {
+			case r.warnedAt != nil && !now.Before(deleteAt):
+				if err := storage.Delete(ctx, r.videoKey); err != nil {
+					log.Printf("Failed to delete recording %d file %s: %v", r.id, r.videoKey, err)
+					continue
+				}
+				if _, err := db.Exec("UPDATE recordings SET status = ?, video_key = '', deleted_at = ?, updated_at = ? WHERE id = ?",
+					RecordingDeleted, now, now, r.id); err != nil {
+					return err
+				}
+				deleted++
+				continue
+			case r.warnedAt == nil && !now.Before(deleteAt.AddDate(0, 0, -warnDays)):
+				if deleteAt.Before(now.AddDate(0, 0, warnDays)) {
+					deleteAt = now.AddDate(0, 0, warnDays)
+				}
+				data := gin.H{"recording_id": r.id, "session_id": r.sessionID, "course_id": r.courseID, "delete_at": deleteAt}
+				if err := notifyUsers([]int{r.teacherID}, NotifyRecordingExpiring, data); err != nil {
+					log.Printf("Failed to notify expiring recording %d: %v", r.id, err)
+					continue
+				}
+				if _, err := db.Exec("UPDATE recordings SET delete_warned_at = ? WHERE id = ?", now, r.id); err != nil {
+					return err
+				}
+				warned++
+			}
+		}
+
+		if coldDays > 0 && canArchive && r.storageClass != StorageClassCold && !now.Before(r.createdAt.AddDate(0, 0, coldDays)) {
+			if err := archive.Archive(ctx, r.videoKey); err != nil {
+				log.Printf("Failed to move recording %d to cold storage: %v", r.id, err)
+				continue
+			}
+			if _, err := db.Exec("UPDATE recordings SET storage_class = ?, archived_at = ?, updated_at = ? WHERE id = ?",
+				StorageClassCold, now, now, r.id); err != nil {
+				return err
+			}
+			archived++
+		}
+	}
+	if archived+warned+deleted > 0 {
+		log.Printf("Recording lifecycle: %d moved to cold storage, %d warned, %d deleted", archived, warned, deleted)
+	}
+	return nil
+}
+
+// 管理员查看所有录像策略
+func adminListRecordingPolicies(c *gin.Context) {
+	policies, err := queryRecordingPolicies()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeRecordingPolicyGetFailed)
+		return
+	}
+	loc := requestLocation(c)
+	for i := range policies {
+		policies[i].UpdatedAt = policies[i].UpdatedAt.In(loc)
+	}
+	respondOK(c, http.StatusOK, policies)
+}
+
+// 设置课程或机构的录像策略，course_id 和 org 只能指定一个
+func adminSetRecordingPolicy(c *gin.Context) {
+	var req struct {
+		CourseID        int    `json:"course_id" binding:"min=0"`
+		Org             string `json:"org" binding:"max=64"`
+		QuotaBytes      int64  `json:"quota_bytes" binding:"min=0"`
+		ColdAfterDays   *int   `json:"cold_after_days" binding:"omitempty,min=0"`
+		DeleteAfterDays *int   `json:"delete_after_days" binding:"omitempty,min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if (req.CourseID == 0) == (req.Org == "") {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "course_id")
+		return
+	}
+
+	p := RecordingPolicy{
+		CourseID:        req.CourseID,
+		Org:             req.Org,
+		QuotaBytes:      req.QuotaBytes,
+		ColdAfterDays:   req.ColdAfterDays,
+		DeleteAfterDays: req.DeleteAfterDays,
+		UpdatedBy:       currentUser(c).ID,
+		UpdatedAt:       time.Now().UTC(),
+	}
+	_, err := db.Exec(dialect.upsert(`
+		INSERT INTO recording_policies (course_id, org, quota_bytes, cold_after_days, delete_after_days, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		[]string{"course_id", "org"},
+		"quota_bytes = EXCLUDED.quota_bytes", "cold_after_days = EXCLUDED.cold_after_days",
+		"delete_after_days = EXCLUDED.delete_after_days", "updated_by = EXCLUDED.updated_by", "updated_at = EXCLUDED.updated_at",
+	), p.CourseID, p.Org, p.QuotaBytes, p.ColdAfterDays, p.DeleteAfterDays, p.UpdatedBy, p.UpdatedAt)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeRecordingPolicyUpdateFailed)
+		return
+	}
+
+	recordAudit(c, "set_recording_policy", "course", p.CourseID, gin.H{
+		"org":               p.Org,
+		"quota_bytes":       p.QuotaBytes,
+		"cold_after_days":   p.ColdAfterDays,
+		"delete_after_days": p.DeleteAfterDays,
+	})
+	p.UpdatedAt = p.UpdatedAt.In(requestLocation(c))
+	respondOK(c, http.StatusOK, p)
+}
+
+// 删除课程或机构的录像策略
+func adminDeleteRecordingPolicy(c *gin.Context) {
+	courseID, err := strconv.Atoi(c.DefaultQuery("course_id", "0"))
+	if err != nil || courseID < 0 {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "course_id")
+		return
+	}
+	org := c.Query("org")
+	if (courseID == 0) == (org == "") {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "course_id")
+		return
+	}
+	res, err := db.Exec("DELETE FROM recording_policies WHERE course_id = ? AND org = ?", courseID, org)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeRecordingPolicyUpdateFailed)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, CodeRecordingPolicyNotFound)
+		return
+	}
+
+	recordAudit(c, "delete_recording_policy", "course", courseID, gin.H{"org": org})
+	respondOK(c, http.StatusOK, gin.H{"course_id": courseID, "org": org})
+}
+
+// 按课程（group=course，默认）或机构（group=org）汇总录像用量及配额
+func adminRecordingUsage(c *gin.Context) {
+	group := c.DefaultQuery("group", "course")
+	column := "s.course_id"
+	switch group {
+	case "course":
+	case "org":
+		column = "u.org"
+	default:
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "group")
+		return
+	}
+	policies, err := loadRecordingPolicies()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeRecordingPolicyGetFailed)
+		return
+	}
+	rows, err := db.Query(`
+		SELECT `+column+`, COUNT(*), COALESCE(SUM(r.size_bytes), 0),
+			COALESCE(SUM(CASE WHEN r.storage_class = ? THEN r.size_bytes ELSE 0 END), 0)
+		FROM recordings r
+		JOIN live_sessions s ON s.id = r.session_id
+		JOIN users u ON u.id = s.teacher_id
+		WHERE r.deleted_at IS NULL
+		GROUP BY `+column+`
+		ORDER BY 3 DESC
+	`, StorageClassCold)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeRecordingGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	usages := []RecordingUsage{}
+	for rows.Next() {
+		var u RecordingUsage
+		var err error
+		if group == "course" {
+			err = rows.Scan(&u.CourseID, &u.Recordings, &u.Bytes, &u.ColdBytes)
+		} else {
+			err = rows.Scan(&u.Org, &u.Recordings, &u.Bytes, &u.ColdBytes)
+		}
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeRecordingGetFailed)
+			return
+		}
+		u.QuotaBytes = policies[networkKey{u.CourseID, u.Org}].QuotaBytes
+		usages = append(usages, u)
+	}
+	respondOK(c, http.StatusOK, usages)
+}
+
+// 课程的录像用量、配额和生效的生命周期，供老师在配额用尽前清理
+func getCourseRecordingUsage(c *gin.Context) {
+	courseID, ok := intParam(c, "course_id")
+	if !ok {
+		return
+	}
+	usage, err := recordingUsage(courseID, "", 0)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeRecordingGetFailed)
+		return
+	}
+	policies, err := loadRecordingPolicies()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeRecordingPolicyGetFailed)
+		return
+	}
+	usage.QuotaBytes = policies[networkKey{courseID, ""}].QuotaBytes
+
+	// 课程所属机构取授课老师的机构，还没有直播时按当前用户的机构
+	org := currentUser(c).Org
+	err = db.QueryRow(`
+		SELECT u.org FROM live_sessions s JOIN users u ON u.id = s.teacher_id
+		WHERE s.course_id = ? ORDER BY s.id DESC LIMIT 1
+	`, courseID).Scan(&org)
+	if err != nil && err != sql.ErrNoRows {
+		respondError(c, http.StatusInternalServerError, CodeRecordingGetFailed)
+		return
+	}
+	coldDays, deleteDays := policies.lifecycle(courseID, org)
+	respondOK(c, http.StatusOK, gin.H{
+		"usage":             usage,
+		"cold_after_days":   coldDays,
+		"delete_after_days": deleteDays,
+		"warn_days":         recordingWarnDays(),
+	})
+}
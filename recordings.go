@@ -0,0 +1,171 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Dong557799/zhibo-class/apperr"
+	"github.com/gin-gonic/gin"
+)
+
+// 录像状态
+const (
+	RecordingRecorded   = "recorded"   // 已有 Livego 录制的原始分段
+	RecordingProcessing = "processing" // 正在合并为 MP4
+	RecordingReady      = "ready"      // MP4 已上传，可点播
+	RecordingFailed     = "failed"
+	RecordingDeleted    = "deleted" // 按生命周期策略删除了 MP4
+)
+
+// 转写状态
+const (
+	TranscriptNone       = "none"
+	TranscriptProcessing = "processing"
+	TranscriptReady      = "ready"
+	TranscriptFailed     = "failed"
+)
+
+const defaultRecordingDir = "tmp"
+
+var errNoRecordingSegments = errors.New("no recording segments found")
+
+// 直播录像
+type Recording struct {
+	ID               int          `json:"id"`
+	SessionID        int          `json:"session_id"`
+	StreamKey        string       `json:"stream_key"`
+	Status           string       `json:"status"`
+	Segments         []string     `json:"-"` // Livego 录制的 FLV 分段，按时间排序
+	SegmentCount     int          `json:"segment_count"`
+	VideoKey         string       `json:"-"`                   // 合并后的 MP4 在对象存储中的 key
+	VideoURL         string       `json:"video_url,omitempty"` // 带有效期的下载地址
+	TrimMs           int          `json:"trim_ms"`             // 开头裁掉的静音时长
+	TranscriptStatus string       `json:"transcript_status"`
+	Checkpoints      []Checkpoint `json:"checkpoints,omitempty"` // 仅录像详情返回
+	CreatedAt        time.Time    `json:"created_at"`
+	UpdatedAt        time.Time    `json:"updated_at"`
+}
+
+// Livego 以 flv_dir/live/<stream_key>_<时间戳>.flv 保存录制分段
+func recordingSegments(streamKey string) ([]string, error) {
+	dir := currentConfig().RecordingDir
+	if dir == "" {
+		dir = defaultRecordingDir
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "live", streamKey+"_*.flv"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// 为已结束的会话登记录像
+func createSessionRecording(sessionID int) (Recording, error) {
+	rec := Recording{SessionID: sessionID, Status: RecordingRecorded, TranscriptStatus: TranscriptNone}
+	if err := db.QueryRow("SELECT stream_key FROM live_sessions WHERE id = ?", sessionID).Scan(&rec.StreamKey); err != nil {
+		return rec, err
+	}
+
+	segments, err := recordingSegments(rec.StreamKey)
+	if err != nil {
+		return rec, err
+	}
+	if len(segments) == 0 {
+		return rec, errNoRecordingSegments
+	}
+	rec.Segments = segments
+	rec.SegmentCount = len(segments)
+
+	raw, _ := json.Marshal(segments)
+	_, err = db.Exec(dialect.upsert(`
+		INSERT INTO recordings (session_id, stream_key, status, segments, created_at, updated_at)
+		VALUES (?, ?, ?, ?, NOW(), NOW())`,
+		[]string{"session_id"},
+		"segments = EXCLUDED.segments", "updated_at = NOW()",
+	), sessionID, rec.StreamKey, rec.Status, string(raw))
+	if err != nil {
+		return rec, err
+	}
+	return getRecording("session_id", sessionID)
+}
+
+// 按 id 或 session_id 查询录像
+func getRecording(column string, value int) (Recording, error) {
+	var rec Recording
+	var segments string
+	err := db.QueryRow(`
+		SELECT id, session_id, stream_key, status, segments, video_key, trim_ms, transcript_status, created_at, updated_at
+		FROM recordings
+		WHERE `+column+` = ?
+	`, value).Scan(&rec.ID, &rec.SessionID, &rec.StreamKey, &rec.Status, &segments, &rec.VideoKey, &rec.TrimMs,
+		&rec.TranscriptStatus, &rec.CreatedAt, &rec.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return rec, domainError(apperr.ErrNotFound, CodeRecordingNotFound)
+	}
+	if err != nil {
+		return rec, err
+	}
+	json.Unmarshal([]byte(segments), &rec.Segments)
+	rec.SegmentCount = len(rec.Segments)
+	return rec, nil
+}
+
+// 会话结束后登记录像，交给后台队列合并为 MP4 并转写
+func onSessionEnded(sessionID int) {
+	settings, err := loadSessionSettings(sessionID)
+	if err != nil || !settings.RecordingEnabled {
+		return
+	}
+
+	rec, err := createSessionRecording(sessionID)
+	if err != nil {
+		if err != errNoRecordingSegments {
+			log.Printf("Failed to register recording for session %d: %v", sessionID, err)
+		}
+		return
+	}
+
+	if _, err := createRecordingJob(rec.ID, currentConfig().TrimDeadAir, settings.ForensicWatermark); err != nil {
+		log.Printf("Failed to create processing job for recording %d: %v", rec.ID, err)
+	}
+}
+
+// 查询录像，失败时写入错误响应
+func recordingParam(c *gin.Context) (Recording, bool) {
+	id, ok := intParam(c, "id")
+	if !ok {
+		return Recording{}, false
+	}
+	rec, err := getRecording("id", id)
+	if err != nil {
+		respondServiceError(c, err)
+		return rec, false
+	}
+	return rec, true
+}
+
+// 录像详情
+func getRecordingDetail(c *gin.Context) {
+	rec, ok := recordingParam(c)
+	if !ok {
+		return
+	}
+	rec.VideoURL = storageURL(c.Request.Context(), rec.VideoKey)
+	checkpoints, err := loadCheckpoints(CheckpointRecording, rec.ID, currentUser(c).ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeCheckpointGetFailed)
+		return
+	}
+	rec.Checkpoints = checkpoints
+	loc := requestLocation(c)
+	rec.CreatedAt = rec.CreatedAt.In(loc)
+	rec.UpdatedAt = rec.UpdatedAt.In(loc)
+	respondOK(c, http.StatusOK, rec)
+}
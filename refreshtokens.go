@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const refreshTokenLength = 48
+
+// 刷新令牌有效期，为 0 时不签发刷新令牌
+func refreshTokenTTL() time.Duration {
+	return time.Duration(currentConfig().RefreshTokenDays) * 24 * time.Hour
+}
+
+// 数据库中只保存刷新令牌、API Key 等密钥的哈希
+func hashSecret(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// 为登录设备签发新的刷新令牌
+func issueRefreshToken(e execer, userID int, loginSessionID string, expiresAt time.Time) (string, error) {
+	token := generateRandomString(refreshTokenLength)
+	_, err := e.Exec(`
+		INSERT INTO refresh_tokens (token_hash, login_session_id, user_id, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, hashSecret(token), loginSessionID, userID, time.Now().UTC(), expiresAt.UTC())
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// 用刷新令牌换取新的访问令牌和刷新令牌，旧的刷新令牌随即作废。
+// 已作废的刷新令牌再次使用说明令牌可能已泄露，注销对应的登录设备
+func refreshAccessToken(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if refreshTokenTTL() <= 0 {
+		respondError(c, http.StatusUnauthorized, CodeRefreshTokenInvalid)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	hash := hashSecret(req.RefreshToken)
+	var loginSessionID string
+	var userID int
+	var expiresAt time.Time
+	var usedAt *time.Time
+	err = tx.QueryRow("SELECT login_session_id, user_id, expires_at, used_at FROM refresh_tokens WHERE token_hash = ? FOR UPDATE", hash).
+		Scan(&loginSessionID, &userID, &expiresAt, &usedAt)
+	if err == sql.ErrNoRows || err == nil && !expiresAt.After(now) {
+		respondError(c, http.StatusUnauthorized, CodeRefreshTokenInvalid)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	if usedAt != nil {
+		tx.Rollback()
+		if _, err := revokeLoginSessions(userID, loginSessionID, ""); err != nil {
+			log.Printf("Failed to revoke login session %s of user %d: %v", loginSessionID, userID, err)
+		}
+		log.Printf("Refresh token reused for login session %s of user %d, session revoked", loginSessionID, userID)
+		respondError(c, http.StatusUnauthorized, CodeRefreshTokenReused)
+		return
+	}
+
+	var revokedAt *time.Time
+	err = tx.QueryRow("SELECT revoked_at FROM login_sessions WHERE id = ?", loginSessionID).Scan(&revokedAt)
+	if err == sql.ErrNoRows || err == nil && revokedAt != nil {
+		respondError(c, http.StatusUnauthorized, CodeLoginRevoked)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	user, err := getUser(userID)
+	if err == sql.ErrNoRows || err == nil && user.Status != "active" {
+		respondError(c, http.StatusUnauthorized, CodeUserDisabled)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeUserGetFailed)
+		return
+	}
+
+	pair := tokenPair{}
+	refreshExpiresAt := now.Add(refreshTokenTTL())
+	if _, err := tx.Exec("UPDATE refresh_tokens SET used_at = ? WHERE token_hash = ?", now, hash); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	if pair.RefreshToken, err = issueRefreshToken(tx, userID, loginSessionID, refreshExpiresAt); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	if _, err := tx.Exec("UPDATE login_sessions SET expires_at = ?, last_seen_at = ?, ip = ? WHERE id = ?",
+		refreshExpiresAt, now, c.ClientIP(), loginSessionID); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	if pair.Token, pair.ExpiresAt, err = signToken(user, 0, loginSessionID, accessTokenTTL()); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	pair.RefreshExpiresAt = &refreshExpiresAt
+	respondOK(c, http.StatusOK, pair.response(nil))
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 转推状态，由负责转推的实例写入，各实例都可以查询
+const (
+	RelayIdle     = "idle"     // 等待会话开始直播
+	RelayRunning  = "running"  // 正在转推
+	RelayRetrying = "retrying" // 转推中断，等待重试
+	RelayFailed   = "failed"   // 连续失败，修改配置或重新启用后再尝试
+	RelayStopped  = "stopped"  // 推流结束或已停用
+)
+
+const (
+	relayCheck       = 10 * time.Second
+	relayLockKey     = "zhibo:relay:%d"
+	relayMaxFailures = 5
+	relayMaxBackoff  = time.Minute
+	// 运行超过该时间后中断视为偶发，重新计算连续失败次数
+	relayStableAfter = 30 * time.Second
+	relayErrorLength = 1000
+)
+
+// 会话的转推目标，如公开课同时推到公共 CDN 或 B 站直播间
+type Relay struct {
+	ID        int        `json:"id"`
+	SessionID int        `json:"session_id"`
+	Name      string     `json:"name"`
+	URL       string     `json:"url"` // 返回时隐藏推流密钥
+	Enabled   bool       `json:"enabled"`
+	Status    string     `json:"status"`
+	LastError string     `json:"last_error,omitempty"`
+	Restarts  int        `json:"restarts"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+const relayColumns = "id, session_id, name, url, enabled, status, last_error, restarts, started_at, updated_at, created_at"
+
+func scanRelay(row interface{ Scan(...interface{}) error }) (Relay, error) {
+	var r Relay
+	err := row.Scan(&r.ID, &r.SessionID, &r.Name, &r.URL, &r.Enabled, &r.Status, &r.LastError, &r.Restarts,
+		&r.StartedAt, &r.UpdatedAt, &r.CreatedAt)
+	return r, err
+}
+
+func (r *Relay) inLocation(loc *time.Location) {
+	r.UpdatedAt, r.CreatedAt = r.UpdatedAt.In(loc), r.CreatedAt.In(loc)
+	if r.StartedAt != nil {
+		t := r.StartedAt.In(loc)
+		r.StartedAt = &t
+	}
+}
+
+// 隐藏推流地址中的密钥：保留协议、主机和第一级路径，其余部分和查询参数替换为 ****
+func maskRelayURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "****"
+	}
+	app, _, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	return fmt.Sprintf("%s://%s/%s/****", u.Scheme, u.Host, app)
+}
+
+// ffmpeg 的错误输出会带上转推地址，保存和记录日志前隐去其中的推流密钥和会话推流码
+func redactRelayOutput(output, target, streamKey string) string {
+	output = strings.ReplaceAll(output, target, maskRelayURL(target))
+	secrets := []string{streamKey}
+	if u, err := url.Parse(target); err == nil {
+		_, key, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+		secrets = append(secrets, key, u.RawQuery)
+	}
+	for _, secret := range secrets {
+		if secret != "" {
+			output = strings.ReplaceAll(output, secret, "****")
+		}
+	}
+	return output
+}
+
+func validateRelayURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "rtmp" || u.Scheme == "rtmps") && u.Host != ""
+}
+
+func setRelayStatus(id int, status, lastError string) {
+	q := "UPDATE session_relays SET status = ?, last_error = ?, updated_at = ?"
+	now := time.Now().UTC()
+	args := []interface{}{status, truncate(lastError, relayErrorLength), now}
+	if status == RelayRunning {
+		q += ", started_at = ?"
+		args = append(args, now)
+	}
+	if status == RelayRetrying {
+		q += ", restarts = restarts + 1"
+	}
+	if _, err := db.Exec(q+" WHERE id = ?", append(args, id)...); err != nil {
+		log.Printf("Failed to update relay %d status: %v", id, err)
+	}
+}
+
+// 本实例正在运行的转推，目标地址或会话推流码变更、不再需要时取消
+type runningRelay struct {
+	url       string
+	streamKey string
+	cancel    context.CancelFunc
+}
+
+var relayRunners = struct {
+	sync.Mutex
+	relays map[int]runningRelay
+}{relays: map[int]runningRelay{}}
+
+// 定期检查直播中会话的转推目标，启动新增的转推，停止已停用或会话已结束的转推；
+// 多副本时由抢到锁的实例负责转推
+func runRelayManager() {
+	if livegoStubbed() {
+		return
+	}
+	ticker := time.NewTicker(relayCheck)
+	defer ticker.Stop()
+	for range ticker.C {
+		rows, err := db.Query(`
+			SELECT r.id, r.url, s.stream_key
+			FROM session_relays r JOIN live_sessions s ON s.id = r.session_id
+			WHERE r.enabled = ? AND r.status <> ? AND s.status = 'live'
+		`, true, RelayFailed)
+		if err != nil {
+			log.Printf("Failed to list relays: %v", err)
+			continue
+		}
+		type wantedRelay struct{ url, streamKey string }
+		wanted := make(map[int]wantedRelay)
+		for rows.Next() {
+			var id int
+			var w wantedRelay
+			if err := rows.Scan(&id, &w.url, &w.streamKey); err != nil {
+				continue
+			}
+			wanted[id] = w
+		}
+		rows.Close()
+
+		relayRunners.Lock()
+		// 会话移交后推流码会变，原转推拉取的是已失效的流
+		for id, r := range relayRunners.relays {
+			if w, ok := wanted[id]; !ok || w.url != r.url || w.streamKey != r.streamKey {
+				r.cancel()
+			}
+		}
+		for id, w := range wanted {
+			if _, ok := relayRunners.relays[id]; ok {
+				continue
+			}
+			unlock, err := acquireLock(fmt.Sprintf(relayLockKey, id), 0)
+			if err != nil {
+				if !errors.Is(err, errLockTimeout) {
+					log.Printf("Failed to lock relay %d: %v", id, err)
+				}
+				continue
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			relayRunners.relays[id] = runningRelay{url: w.url, streamKey: w.streamKey, cancel: cancel}
+			go func(id int, target, streamKey string) {
+				defer unlock()
+				runRelay(ctx, id, target, streamKey)
+				relayRunners.Lock()
+				delete(relayRunners.relays, id)
+				relayRunners.Unlock()
+				cancel()
+			}(id, w.url, w.streamKey)
+		}
+		relayRunners.Unlock()
+	}
+}
+
+// 拉取会话主画面原样转推到目标地址，中断后按退避间隔重试，连续失败时标记为 failed
+func runRelay(ctx context.Context, id int, target, streamKey string) {
+	failures := 0
+	for {
+		setRelayStatus(id, RelayRunning, "")
+		started := time.Now()
+		cmd := exec.CommandContext(ctx, ffmpegPath(), "-hide_banner", "-loglevel", "error",
+			"-i", internalRTMPURL(streamKey), "-c", "copy", "-f", "flv", target)
+		output, err := cmd.CombinedOutput()
+		if ctx.Err() != nil {
+			setRelayStatus(id, RelayStopped, "")
+			return
+		}
+		if err == nil {
+			// 教师停止推流，会话仍在直播时由下一次检查重新启动
+			setRelayStatus(id, RelayStopped, "")
+			return
+		}
+
+		if time.Since(started) >= relayStableAfter {
+			failures = 0
+		}
+		failures++
+		msg := redactRelayOutput(strings.TrimSpace(fmt.Sprintf("%v: %s", err, output)), target, streamKey)
+		if failures >= relayMaxFailures {
+			log.Printf("Relay %d failed %d times, giving up: %s", id, failures, msg)
+			setRelayStatus(id, RelayFailed, msg)
+			return
+		}
+		setRelayStatus(id, RelayRetrying, msg)
+		backoff := time.Duration(1<<failures) * time.Second
+		if backoff > relayMaxBackoff {
+			backoff = relayMaxBackoff
+		}
+		select {
+		case <-ctx.Done():
+			setRelayStatus(id, RelayStopped, "")
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// 会话的转推目标及其状态
+func listRelays(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	if !sessionExists(c, sessionID) {
+		return
+	}
+	rows, err := db.Query("SELECT "+relayColumns+" FROM session_relays WHERE session_id = ? ORDER BY id", sessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeRelayGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	relays := []Relay{}
+	for rows.Next() {
+		r, err := scanRelay(rows)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeRelayGetFailed)
+			return
+		}
+		r.URL = maskRelayURL(r.URL)
+		r.inLocation(loc)
+		relays = append(relays, r)
+	}
+	respondOK(c, http.StatusOK, relays)
+}
+
+// 添加转推目标，会话直播中时在下一次检查时开始转推
+func createRelay(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Name    string `json:"name" binding:"required,max=100"`
+		URL     string `json:"url" binding:"required,max=1024"`
+		Enabled *bool  `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if !validateRelayURL(req.URL) {
+		respondError(c, http.StatusBadRequest, CodeRelayInvalidURL)
+		return
+	}
+	var status string
+	err := db.QueryRow("SELECT status FROM live_sessions WHERE id = ?", sessionID).Scan(&status)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSessionNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+	if status == "ended" {
+		respondError(c, http.StatusConflict, CodeSessionAlreadyEnded)
+		return
+	}
+
+	now := time.Now().UTC()
+	r := Relay{SessionID: sessionID, Name: req.Name, URL: req.URL, Enabled: true, Status: RelayIdle, UpdatedAt: now, CreatedAt: now}
+	if req.Enabled != nil {
+		r.Enabled = *req.Enabled
+	}
+	id, err := dialect.insertID(db, `
+		INSERT INTO session_relays (session_id, name, url, enabled, status, last_error, restarts, created_by, updated_at, created_at)
+		VALUES (?, ?, ?, ?, ?, '', 0, ?, ?, ?)
+	`, r.SessionID, r.Name, r.URL, r.Enabled, r.Status, currentUser(c).ID, now, now)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeRelayUpdateFailed)
+		return
+	}
+	r.ID = int(id)
+
+	recordAudit(c, "create_relay", "live_session", sessionID, gin.H{"relay_id": r.ID, "name": r.Name, "url": maskRelayURL(r.URL)})
+	r.URL = maskRelayURL(r.URL)
+	r.inLocation(requestLocation(c))
+	respondOK(c, http.StatusCreated, r)
+}
+
+// 按 URL 中的 relay_id 加载会话的转推目标，不存在时写入错误响应
+func relayParam(c *gin.Context) (Relay, bool) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return Relay{}, false
+	}
+	relayID, ok := intParam(c, "relay_id")
+	if !ok {
+		return Relay{}, false
+	}
+	r, err := scanRelay(db.QueryRow("SELECT "+relayColumns+" FROM session_relays WHERE id = ? AND session_id = ?", relayID, sessionID))
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeRelayNotFound)
+		return r, false
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeRelayGetFailed)
+		return r, false
+	}
+	return r, true
+}
+
+// 修改名称、地址或启停转推。修改后清除失败状态，由下一次检查按新配置重新转推
+func updateRelay(c *gin.Context) {
+	r, ok := relayParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name    *string `json:"name" binding:"omitempty,min=1,max=100"`
+		URL     *string `json:"url" binding:"omitempty,max=1024"`
+		Enabled *bool   `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if req.Name != nil {
+		r.Name = *req.Name
+	}
+	if req.URL != nil {
+		if !validateRelayURL(*req.URL) {
+			respondError(c, http.StatusBadRequest, CodeRelayInvalidURL)
+			return
+		}
+		r.URL = *req.URL
+	}
+	if req.Enabled != nil {
+		r.Enabled = *req.Enabled
+	}
+	if r.Status == RelayFailed || req.URL != nil || req.Enabled != nil {
+		r.Status, r.LastError, r.Restarts = RelayIdle, "", 0
+	}
+	r.UpdatedAt = time.Now().UTC()
+	if _, err := db.Exec("UPDATE session_relays SET name = ?, url = ?, enabled = ?, status = ?, last_error = ?, restarts = ?, updated_at = ? WHERE id = ?",
+		r.Name, r.URL, r.Enabled, r.Status, r.LastError, r.Restarts, r.UpdatedAt, r.ID); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeRelayUpdateFailed)
+		return
+	}
+
+	recordAudit(c, "update_relay", "live_session", r.SessionID, gin.H{"relay_id": r.ID, "enabled": r.Enabled, "url_changed": req.URL != nil})
+	r.URL = maskRelayURL(r.URL)
+	r.inLocation(requestLocation(c))
+	respondOK(c, http.StatusOK, r)
+}
+
+// 删除转推目标，正在进行的转推在下一次检查时停止
+func deleteRelay(c *gin.Context) {
+	r, ok := relayParam(c)
+	if !ok {
+		return
+	}
+	if _, err := db.Exec("DELETE FROM session_relays WHERE id = ?", r.ID); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeRelayUpdateFailed)
+		return
+	}
+	recordAudit(c, "delete_relay", "live_session", r.SessionID, gin.H{"relay_id": r.ID, "name": r.Name})
+	respondOK(c, http.StatusOK, gin.H{"id": r.ID})
+}
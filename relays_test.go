@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestRedactRelayOutput(t *testing.T) {
+	const streamKey = "sess-key-123"
+	tests := []struct {
+		name, output, target, want string
+	}{
+		{
+			name:   "full target url",
+			output: "rtmp://live.example.com/app/secret-key: Input/output error",
+			target: "rtmp://live.example.com/app/secret-key",
+			want:   "rtmp://live.example.com/app/****: Input/output error",
+		},
+		{
+			name:   "key and query quoted separately",
+			output: "[flv] stream 'secret-key' rejected, auth=abc",
+			target: "rtmp://live.example.com/app/secret-key?auth=abc",
+			want:   "[flv] stream '****' rejected, ****",
+		},
+		{
+			name:   "source stream key",
+			output: "rtmp://127.0.0.1:1935/live/sess-key-123: Connection refused",
+			target: "rtmps://cdn.example.com/live/other",
+			want:   "rtmp://127.0.0.1:1935/live/****: Connection refused",
+		},
+		{
+			name:   "nothing to redact",
+			output: "exit status 1: Conversion failed!",
+			target: "rtmp://live.example.com/app/secret-key",
+			want:   "exit status 1: Conversion failed!",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactRelayOutput(tt.output, tt.target, streamKey); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
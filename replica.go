@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	replicaCheckInterval = 10 * time.Second
+	replicaPingTimeout   = 3 * time.Second
+)
+
+// 只读副本。写操作和事务内的读取始终走主库，
+// 副本不可用时读查询自动回退到主库
+var (
+	replica        *sql.DB
+	replicaHealthy atomic.Bool
+)
+
+func connectReplica() error {
+	conf := currentConfig()
+	if conf.DBReplicaHost == "" {
+		return nil
+	}
+	port := conf.DBReplicaPort
+	if port == 0 {
+		port = conf.DBPort
+	}
+	user, password := conf.DBReplicaUser, conf.DBReplicaPassword
+	if user == "" {
+		user, password = conf.DBUser, conf.DBPassword
+	}
+
+	r, err := openDB(user, password, conf.DBReplicaHost, port, conf.DBName)
+	if err != nil {
+		return err
+	}
+	replica = r
+	checkReplica()
+	go func() {
+		for range time.Tick(replicaCheckInterval) {
+			checkReplica()
+		}
+	}()
+	return nil
+}
+
+func checkReplica() {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaPingTimeout)
+	defer cancel()
+	setReplicaHealthy(replica.PingContext(ctx))
+}
+
+func setReplicaHealthy(err error) {
+	healthy := err == nil
+	if replicaHealthy.Swap(healthy) == healthy {
+		return
+	}
+	if healthy {
+		log.Printf("Read replica is available")
+	} else {
+		log.Printf("Read replica is unavailable, falling back to primary: %v", err)
+	}
+}
+
+// 分析类查询使用的连接，副本不可用时为主库
+func readDB() *sql.DB {
+	if replica != nil && replicaHealthy.Load() {
+		return replica
+	}
+	return db
+}
+
+// 在副本上执行只读查询，失败时标记副本不可用并在主库上重试
+func readQuery(query string, args ...interface{}) (*sql.Rows, error) {
+	if r := readDB(); r != db {
+		rows, err := r.Query(query, args...)
+		if err == nil {
+			return rows, nil
+		}
+		setReplicaHealthy(err)
+	}
+	return db.Query(query, args...)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Dong557799/zhibo-class/apperr"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// 统一响应格式
+type Response struct {
+	Code      ErrorCode   `json:"code"`
+	Message   string      `json:"message"`
+	Data      interface{} `json:"data,omitempty"`
+	Page      *Pagination `json:"page,omitempty"`
+	Detail    interface{} `json:"detail,omitempty"`
+	TraceID   string      `json:"trace_id,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// 分页信息
+type Pagination struct {
+	Page     int   `json:"page,omitempty"`
+	PageSize int   `json:"page_size"`
+	Total    int64 `json:"total,omitempty"`
+	HasMore  bool  `json:"has_more"`
+}
+
+// 是否使用旧版响应格式，请求头 X-Response-Format 优先于配置
+func legacyResponse(c *gin.Context) bool {
+	switch c.GetHeader("X-Response-Format") {
+	case "legacy":
+		return true
+	case "envelope":
+		return false
+	}
+	return currentConfig().LegacyResponse
+}
+
+// 返回成功响应
+func respondOK(c *gin.Context, status int, data interface{}) {
+	if legacyResponse(c) {
+		c.JSON(status, data)
+		return
+	}
+	c.JSON(status, Response{
+		Code:    CodeOK,
+		Message: localize(CodeOK, requestLang(c)),
+		Data:    data,
+	})
+}
+
+// 返回带分页信息的列表响应
+func respondPage(c *gin.Context, data interface{}, page Pagination) {
+	if legacyResponse(c) {
+		c.JSON(http.StatusOK, data)
+		return
+	}
+	c.JSON(http.StatusOK, Response{
+		Code:    CodeOK,
+		Message: localize(CodeOK, requestLang(c)),
+		Data:    data,
+		Page:    &page,
+	})
+}
+
+// 返回错误响应，detail 为可选的补充信息
+func writeError(c *gin.Context, status int, code ErrorCode, message string, detail interface{}) {
+	if legacyResponse(c) {
+		body := gin.H{"code": code, "error": message}
+		if detail != nil {
+			body["detail"] = detail
+		}
+		if id := c.GetString("request_id"); id != "" {
+			body["request_id"] = id
+		}
+		c.JSON(status, body)
+		return
+	}
+	c.JSON(status, Response{
+		Code:      code,
+		Message:   message,
+		Detail:    detail,
+		TraceID:   traceID(c),
+		RequestID: c.GetString("request_id"),
+	})
+}
+
+// 领域错误种类对应的状态码，错误没有错误码时使用默认错误码
+var serviceErrorStatus = map[error]struct {
+	status int
+	code   ErrorCode
+}{
+	apperr.ErrNotFound:     {http.StatusNotFound, CodeNotFound},
+	apperr.ErrConflict:     {http.StatusConflict, CodeConflict},
+	apperr.ErrForbidden:    {http.StatusForbidden, CodeForbidden},
+	apperr.ErrInvalidState: {http.StatusConflict, CodeInvalidState},
+	apperr.ErrExpired:      {http.StatusGone, CodeExpired},
+}
+
+// 领域错误对应的状态码和错误码，批量接口逐条返回结果时使用；不是领域错误时返回 false
+func serviceErrorCode(err error) (int, ErrorCode, []interface{}, bool) {
+	m, ok := serviceErrorStatus[apperr.KindOf(err)]
+	if !ok {
+		return 0, "", nil, false
+	}
+	code, args := apperr.CodeOf(err)
+	if code == "" {
+		return m.status, m.code, nil, true
+	}
+	return m.status, ErrorCode(code), args, true
+}
+
+// 按服务层返回的领域错误写入错误响应，其他错误记录日志后返回内部错误
+func respondServiceError(c *gin.Context, err error) {
+	status, code, args, ok := serviceErrorCode(err)
+	if !ok {
+		log.Printf("%s %s failed: %v", c.Request.Method, c.FullPath(), err)
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	respondError(c, status, code, args...)
+}
+
+// 领域错误，code 为返回给客户端的错误码
+func domainError(kind error, code ErrorCode, args ...interface{}) error {
+	return apperr.New(kind, string(code), args...)
+}
+
+// 解析分页参数 page、page_size
+func pageParams(c *gin.Context) (page, pageSize, offset int) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err = strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(defaultPageSize)))
+	if err != nil || pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	return page, pageSize, (page - 1) * pageSize
+}
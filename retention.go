@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	jobRetentionPurge = "retention.purge"
+	retentionInterval = 24 * time.Hour
+)
+
+// 数据保留策略，天数为 0 时不清理
+type RetentionConfig struct {
+	ChatDays   int `json:"chat_days"`   // 超过天数的聊天记录删除
+	AnswerDays int `json:"answer_days"` // 超过天数的答题记录去除学生身份
+}
+
+// 开放手机号登录时，即使未配置保留天数也需要定期清理过期的短信验证码
+func retentionEnabled() bool {
+	conf := currentConfig()
+	return conf.Retention.ChatDays > 0 || conf.Retention.AnswerDays > 0 || conf.SMS.Driver != ""
+}
+
+func init() {
+	// 每天执行一次，完成后安排下一次
+	jobHandlers[jobRetentionPurge] = func(ctx context.Context, payload json.RawMessage) error {
+		if !retentionEnabled() {
+			return nil
+		}
+		if err := purgeExpiredData(time.Now().UTC()); err != nil {
+			return err
+		}
+		_, err := enqueueJob(jobRetentionPurge, nil, time.Now().Add(retentionInterval))
+		return err
+	}
+}
+
+// 启动时确保队列中有清理任务，多副本只安排一个
+func scheduleRetention() {
+	if !retentionEnabled() {
+		return
+	}
+	err := withLock("zhibo:schedule:retention", func() error {
+		var id int
+		err := db.QueryRow("SELECT id FROM jobs WHERE type = ? AND status IN (?, ?) LIMIT 1",
+			jobRetentionPurge, JobQueued, JobRunning).Scan(&id)
+		if err != sql.ErrNoRows {
+			return err
+		}
+		_, err = enqueueJob(jobRetentionPurge, nil, time.Time{})
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to schedule retention job: %v", err)
+	}
+}
+
+// 按保留策略清理聊天并匿名化答题记录，删除不再用于频率限制的短信验证码
+func purgeExpiredData(now time.Time) error {
+	result, err := db.Exec("DELETE FROM sms_codes WHERE created_at < ?", now.Add(-smsCodeRetention))
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Retention: purged %d expired SMS codes", n)
+	}
+
+	conf := currentConfig().Retention
+	if days := conf.ChatDays; days > 0 {
+		result, err := db.Exec("DELETE FROM chat_messages WHERE created_at < ?", now.AddDate(0, 0, -days))
+		if err != nil {
+			return err
+		}
+		n, _ := result.RowsAffected()
+		log.Printf("Retention: purged %d chat messages older than %d days", n, days)
+	}
+
+	if days := conf.AnswerDays; days > 0 {
+		cutoff := now.AddDate(0, 0, -days)
+		result, err := db.Exec("UPDATE answers SET student_id = 0 WHERE student_id <> 0 AND submitted_at < ?", cutoff)
+		if err != nil {
+			return err
+		}
+		n, _ := result.RowsAffected()
+		// 测验草稿答案已在交卷时写入 answers，直接删除
+		if _, err := db.Exec("DELETE FROM exam_answers WHERE answered_at < ?", cutoff); err != nil {
+			return err
+		}
+		log.Printf("Retention: anonymized %d answers older than %d days", n, days)
+	}
+	return nil
+}
+
+// 删除或匿名化学生在各表中的个人数据。统计类记录保留但去除身份，
+// 用户行保留以维持关联，账号信息清空并停用
+func eraseUserData(tx *sql.Tx, userID int) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	exec := func(name, stmt string, args ...interface{}) error {
+		result, err := tx.Exec(stmt, args...)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		n, _ := result.RowsAffected()
+		counts[name] += n
+		return nil
+	}
+
+	steps := []struct {
+		name, stmt string
+	}{
+		{"answers", "UPDATE answers SET student_id = 0 WHERE student_id = ?"},
+		{"answer_revisions", "UPDATE answer_revisions SET student_id = 0 WHERE student_id = ?"},
+		{"answer_sync_keys", "DELETE FROM answer_sync_keys WHERE student_id = ?"},
+		{"question_acks", "DELETE FROM question_acks WHERE student_id = ?"},
+		{"session_waitlist", "DELETE FROM session_waitlist WHERE user_id = ?"},
+		{"user_notifications", "DELETE FROM user_notifications WHERE user_id = ?"},
+		{"login_sessions", "DELETE FROM login_sessions WHERE user_id = ?"},
+		{"refresh_tokens", "DELETE FROM refresh_tokens WHERE user_id = ?"},
+		{"exam_answers", "DELETE FROM exam_answers WHERE student_id = ?"},
+		{"exam_attempts", "DELETE FROM exam_attempts WHERE student_id = ?"},
+		{"makeup_grants", "DELETE FROM makeup_grants WHERE student_id = ?"},
+		{"chat_messages", "DELETE FROM chat_messages WHERE user_id = ?"},
+		// 撤回该用户的投票，再删除其提问及提问下的投票
+		{"session_qa_votes", `UPDATE session_qa SET votes = votes - 1
+			WHERE id IN (SELECT qa_id FROM session_qa_votes WHERE user_id = ?)`},
+		{"session_qa_votes", "DELETE FROM session_qa_votes WHERE user_id = ?"},
+		{"session_qa_votes", "DELETE FROM session_qa_votes WHERE qa_id IN (SELECT id FROM session_qa WHERE user_id = ?)"},
+		{"session_qa", "DELETE FROM session_qa WHERE user_id = ?"},
+		{"session_qa", "UPDATE session_qa SET answered_by = NULL WHERE answered_by = ?"},
+		{"session_attendance", "DELETE FROM session_attendance WHERE user_id = ?"},
+		{"breakout_members", "DELETE FROM breakout_members WHERE student_id = ?"},
+		{"class_group_members", "DELETE FROM class_group_members WHERE student_id = ?"},
+		{"abuse_reports", "DELETE FROM abuse_reports WHERE reporter_id = ?"},
+		{"abuse_reports", "UPDATE abuse_reports SET target_user_id = 0 WHERE target_user_id = ?"},
+		{"chat_mutes", "DELETE FROM chat_mutes WHERE user_id = ?"},
+		{"whiteboard_ops", "UPDATE whiteboard_ops SET user_id = 0 WHERE user_id = ?"},
+		{"point_events", "DELETE FROM point_events WHERE student_id = ?"},
+		{"student_points", "DELETE FROM student_points WHERE student_id = ?"},
+		{"student_badges", "DELETE FROM student_badges WHERE student_id = ?"},
+		{"lesson_progress", "DELETE FROM lesson_progress WHERE student_id = ?"},
+		{"user_identities", "DELETE FROM user_identities WHERE user_id = ?"},
+		// 验证码按手机号保存，需在清空 users.phone 之前删除
+		{"sms_codes", "DELETE FROM sms_codes WHERE phone = (SELECT phone FROM users WHERE id = ?)"},
+	}
+	for _, s := range steps {
+		if err := exec(s.name, s.stmt, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	// 用户名需唯一，用 id 生成占位用户名
+	if err := exec("users", `
+		UPDATE users SET username = ?, name = '', status = 'deleted', password_hash = '', time_zone = '', org = '', phone = NULL, external_id = NULL
+		WHERE id = ?
+	`, fmt.Sprintf("deleted-%d", userID), userID); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// 删除用户个人数据，管理员或本人可调用
+func deleteUserData(c *gin.Context) {
+	userID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	user := currentUser(c)
+	if user.ID != userID && user.Role != RoleAdmin {
+		respondError(c, http.StatusForbidden, CodeForbidden)
+		return
+	}
+
+	var role string
+	if err := db.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role); err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, CodeUserNotFound)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeUserGetFailed)
+		}
+		return
+	}
+	// 管理员账号需先降级，避免误删唯一的管理员
+	if role == RoleAdmin {
+		respondError(c, http.StatusForbidden, CodeForbidden)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeUserDataDeleteFailed)
+		return
+	}
+	defer tx.Rollback()
+
+	counts, err := eraseUserData(tx, userID)
+	if err != nil {
+		log.Printf("Failed to erase data of user %d: %v", userID, err)
+		respondError(c, http.StatusInternalServerError, CodeUserDataDeleteFailed)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeUserDataDeleteFailed)
+		return
+	}
+	invalidateUserStatus(userID)
+
+	recordAudit(c, "erase_user_data", "user", userID, counts)
+	respondOK(c, http.StatusOK, gin.H{"user_id": userID, "affected": counts})
+}
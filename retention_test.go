@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func countRows(t *testing.T, query string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestPurgeExpiredSMSCodes(t *testing.T) {
+	useTestConfig(t, &Config{SMS: SMSConfig{Driver: "log"}})
+	openTestDB(t)
+	now := time.Now().UTC()
+	for _, age := range []time.Duration{time.Minute, smsCodeRetention - time.Minute, smsCodeRetention + time.Minute, 30 * 24 * time.Hour} {
+		mustExec(t, "INSERT INTO sms_codes (phone, code_hash, expires_at, created_at) VALUES ('13800000000', 'h', ?, ?)",
+			now.Add(-age).Add(defaultSMSCodeTTL), now.Add(-age))
+	}
+	if err := purgeExpiredData(now); err != nil {
+		t.Fatal(err)
+	}
+	// 最近一天内的记录仍用于发送频率限制
+	if n := countRows(t, "SELECT COUNT(*) FROM sms_codes"); n != 2 {
+		t.Fatalf("%d SMS codes left, want 2", n)
+	}
+}
+
+func TestEraseUserDataRemovesSMSCodes(t *testing.T) {
+	openTestDB(t)
+	now := time.Now().UTC()
+	mustExec(t, "INSERT INTO users (id, username, name, role, password_hash, phone, created_at) VALUES (1, 'a', 'A', 'student', '', '13800000001', ?)", now)
+	mustExec(t, "INSERT INTO users (id, username, name, role, password_hash, phone, created_at) VALUES (2, 'b', 'B', 'student', '', '13800000002', ?)", now)
+	for _, phone := range []string{"13800000001", "13800000001", "13800000002"} {
+		mustExec(t, "INSERT INTO sms_codes (phone, code_hash, expires_at, created_at) VALUES (?, 'h', ?, ?)", phone, now, now)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	counts, err := eraseUserData(tx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if counts["sms_codes"] != 2 {
+		t.Errorf("erased %d SMS codes, want 2", counts["sms_codes"])
+	}
+	if n := countRows(t, "SELECT COUNT(*) FROM sms_codes WHERE phone = '13800000002'"); n != 1 {
+		t.Errorf("other user's SMS codes = %d, want 1", n)
+	}
+}
@@ -0,0 +1,863 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// 数据表结构，启动时按顺序执行。按 MySQL 语法书写，其他数据库由 dialect 转换
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS live_sessions (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		course_id INT NOT NULL,
+		stream_key VARCHAR(64) NOT NULL UNIQUE,
+		status VARCHAR(16) NOT NULL DEFAULT 'pending',
+		start_time DATETIME NULL,
+		end_time DATETIME NULL,
+		created_at DATETIME NOT NULL,
+		INDEX idx_course (course_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS questions (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		course_id INT NOT NULL,
+		type VARCHAR(32) NOT NULL,
+		content TEXT NOT NULL,
+		options TEXT,
+		answer VARCHAR(255) NOT NULL,
+		INDEX idx_course (course_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS answers (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		question_id INT NOT NULL,
+		student_id INT NOT NULL,
+		answer VARCHAR(255) NOT NULL,
+		INDEX idx_question (question_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS breakout_groups (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		session_id INT NOT NULL,
+		name VARCHAR(64) NOT NULL,
+		stream_key VARCHAR(64) NULL,
+		status VARCHAR(16) NOT NULL DEFAULT 'open',
+		created_at DATETIME NOT NULL,
+		closed_at DATETIME NULL,
+		INDEX idx_session (session_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS breakout_members (
+		group_id INT NOT NULL,
+		student_id INT NOT NULL,
+		PRIMARY KEY (group_id, student_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS whiteboard_ops (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		session_id INT NOT NULL,
+		seq BIGINT NOT NULL,
+		user_id INT NOT NULL,
+		kind VARCHAR(32) NOT NULL,
+		op TEXT NOT NULL,
+		created_at DATETIME(3) NOT NULL,
+		UNIQUE KEY uk_session_seq (session_id, seq)
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_settings (
+		session_id INT PRIMARY KEY,
+		chat_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		danmaku_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		raise_hand VARCHAR(16) NOT NULL DEFAULT 'all',
+		recording_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+		playback_protocols VARCHAR(64) NOT NULL DEFAULT 'rtmp,flv,hls'
+	)`,
+	`CREATE TABLE IF NOT EXISTS users (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		username VARCHAR(64) NOT NULL UNIQUE,
+		name VARCHAR(64) NOT NULL,
+		role VARCHAR(16) NOT NULL,
+		status VARCHAR(16) NOT NULL DEFAULT 'active',
+		password_hash VARCHAR(255) NOT NULL,
+		created_at DATETIME NOT NULL,
+		INDEX idx_role (role)
+	)`,
+	`CREATE TABLE IF NOT EXISTS audit_logs (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		actor_id INT NOT NULL,
+		impersonator_id INT NOT NULL DEFAULT 0,
+		action VARCHAR(64) NOT NULL,
+		target_type VARCHAR(32) NOT NULL,
+		target_id INT NOT NULL,
+		detail TEXT,
+		ip VARCHAR(64) NOT NULL,
+		created_at DATETIME NOT NULL,
+		INDEX idx_actor (actor_id),
+		INDEX idx_action (action)
+	)`,
+	`CREATE TABLE IF NOT EXISTS role_permissions (
+		role VARCHAR(16) NOT NULL,
+		permission VARCHAR(64) NOT NULL,
+		PRIMARY KEY (role, permission)
+	)`,
+	`CREATE TABLE IF NOT EXISTS question_tags (
+		question_id INT NOT NULL,
+		tag VARCHAR(64) NOT NULL,
+		PRIMARY KEY (question_id, tag),
+		INDEX idx_tag (tag)
+	)`,
+	`CREATE TABLE IF NOT EXISTS question_pushes (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		question_id INT NOT NULL,
+		course_id INT NOT NULL,
+		fastest_n INT NOT NULL DEFAULT 0,
+		winners INT NOT NULL DEFAULT 0,
+		pushed_at DATETIME(3) NOT NULL,
+		INDEX idx_question (question_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS point_events (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		course_id INT NOT NULL,
+		student_id INT NOT NULL,
+		reason VARCHAR(32) NOT NULL,
+		ref_id INT NOT NULL,
+		points INT NOT NULL,
+		created_at DATETIME NOT NULL,
+		UNIQUE KEY uk_award (course_id, student_id, reason, ref_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS student_points (
+		course_id INT NOT NULL,
+		student_id INT NOT NULL,
+		points INT NOT NULL DEFAULT 0,
+		streak INT NOT NULL DEFAULT 0,
+		best_streak INT NOT NULL DEFAULT 0,
+		PRIMARY KEY (course_id, student_id),
+		INDEX idx_course_points (course_id, points)
+	)`,
+	`CREATE TABLE IF NOT EXISTS student_badges (
+		course_id INT NOT NULL,
+		student_id INT NOT NULL,
+		badge VARCHAR(32) NOT NULL,
+		awarded_at DATETIME NOT NULL,
+		PRIMARY KEY (course_id, student_id, badge)
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_reactions (
+		session_id INT NOT NULL,
+		window_start DATETIME NOT NULL,
+		stream_offset INT NULL,
+		kind VARCHAR(16) NOT NULL,
+		count INT NOT NULL,
+		PRIMARY KEY (session_id, window_start, kind)
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_qa (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		session_id INT NOT NULL,
+		user_id INT NOT NULL,
+		content TEXT NOT NULL,
+		votes INT NOT NULL DEFAULT 0,
+		status VARCHAR(16) NOT NULL DEFAULT 'open',
+		answer TEXT,
+		answered_by INT NULL,
+		created_at DATETIME NOT NULL,
+		answered_at DATETIME NULL,
+		INDEX idx_session (session_id, status)
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_qa_votes (
+		qa_id INT NOT NULL,
+		user_id INT NOT NULL,
+		PRIMARY KEY (qa_id, user_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_captions (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		session_id INT NOT NULL,
+		lang VARCHAR(16) NOT NULL,
+		start_ms INT NOT NULL,
+		end_ms INT NOT NULL,
+		text TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		INDEX idx_session_start (session_id, lang, start_ms)
+	)`,
+	`CREATE TABLE IF NOT EXISTS recordings (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		session_id INT NOT NULL UNIQUE,
+		stream_key VARCHAR(64) NOT NULL,
+		status VARCHAR(16) NOT NULL,
+		segments TEXT NOT NULL,
+		transcript_status VARCHAR(16) NOT NULL DEFAULT 'none',
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS recording_transcripts (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		recording_id INT NOT NULL,
+		start_ms INT NOT NULL,
+		end_ms INT NOT NULL,
+		text TEXT NOT NULL,
+		INDEX idx_recording_start (recording_id, start_ms)
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_thumbnails (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		session_id INT NOT NULL,
+		path VARCHAR(255) NOT NULL,
+		captured_at DATETIME NOT NULL,
+		INDEX idx_session (session_id, captured_at)
+	)`,
+	`CREATE TABLE IF NOT EXISTS recording_jobs (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		recording_id INT NOT NULL,
+		status VARCHAR(16) NOT NULL,
+		step VARCHAR(16) NOT NULL DEFAULT '',
+		trim_dead_air BOOLEAN NOT NULL DEFAULT FALSE,
+		error TEXT,
+		created_at DATETIME NOT NULL,
+		started_at DATETIME NULL,
+		finished_at DATETIME NULL,
+		INDEX idx_recording (recording_id),
+		INDEX idx_status (status)
+	)`,
+	`CREATE TABLE IF NOT EXISTS jobs (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		type VARCHAR(64) NOT NULL,
+		payload TEXT NOT NULL,
+		status VARCHAR(16) NOT NULL,
+		attempts INT NOT NULL DEFAULT 0,
+		max_attempts INT NOT NULL,
+		last_error TEXT,
+		run_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		finished_at DATETIME NULL,
+		INDEX idx_status_run_at (status, run_at),
+		INDEX idx_type (type)
+	)`,
+	`CREATE TABLE IF NOT EXISTS exams (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		course_id INT NOT NULL,
+		session_id INT NULL,
+		title VARCHAR(255) NOT NULL,
+		time_limit_seconds INT NOT NULL,
+		status VARCHAR(16) NOT NULL,
+		created_by INT NOT NULL,
+		created_at DATETIME NOT NULL,
+		started_at DATETIME NULL,
+		ends_at DATETIME NULL,
+		closed_at DATETIME NULL,
+		INDEX idx_course (course_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS exam_questions (
+		exam_id INT NOT NULL,
+		question_id INT NOT NULL,
+		position INT NOT NULL,
+		PRIMARY KEY (exam_id, question_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS exam_attempts (
+		exam_id INT NOT NULL,
+		student_id INT NOT NULL,
+		score INT NOT NULL DEFAULT 0,
+		auto_submitted BOOLEAN NOT NULL DEFAULT FALSE,
+		started_at DATETIME NOT NULL,
+		submitted_at DATETIME NULL,
+		PRIMARY KEY (exam_id, student_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS exam_answers (
+		exam_id INT NOT NULL,
+		student_id INT NOT NULL,
+		question_id INT NOT NULL,
+		answer VARCHAR(255) NOT NULL,
+		answered_at DATETIME NOT NULL,
+		PRIMARY KEY (exam_id, student_id, question_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS makeup_grants (
+		target_type VARCHAR(16) NOT NULL,
+		target_id INT NOT NULL,
+		student_id INT NOT NULL,
+		deadline DATETIME NOT NULL,
+		created_by INT NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (target_type, target_id, student_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS chat_messages (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		session_id INT NOT NULL,
+		room VARCHAR(64) NOT NULL,
+		user_id INT NOT NULL,
+		content TEXT NOT NULL,
+		created_at DATETIME(3) NOT NULL,
+		INDEX idx_session (session_id, created_at),
+		INDEX idx_user (user_id),
+		INDEX idx_created (created_at)
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_attendance (
+		session_id INT NOT NULL,
+		user_id INT NOT NULL,
+		role VARCHAR(16) NOT NULL,
+		joined_at DATETIME NOT NULL,
+		PRIMARY KEY (session_id, user_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS course_exports (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		course_id INT NOT NULL,
+		status VARCHAR(16) NOT NULL,
+		file_key VARCHAR(255) NOT NULL DEFAULT '',
+		error TEXT,
+		requested_by INT NOT NULL,
+		created_at DATETIME NOT NULL,
+		finished_at DATETIME NULL,
+		INDEX idx_course (course_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS feature_flags (
+		name VARCHAR(64) NOT NULL,
+		org VARCHAR(64) NOT NULL DEFAULT '',
+		enabled BOOLEAN NOT NULL,
+		updated_by INT NOT NULL,
+		updated_at DATETIME NOT NULL,
+		PRIMARY KEY (name, org)
+	)`,
+	`CREATE TABLE IF NOT EXISTS course_prices (
+		course_id INT PRIMARY KEY,
+		price_cents INT NOT NULL,
+		currency VARCHAR(8) NOT NULL DEFAULT 'CNY',
+		updated_by INT NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS orders (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		order_no VARCHAR(32) NOT NULL UNIQUE,
+		course_id INT NOT NULL,
+		student_id INT NOT NULL,
+		amount_cents INT NOT NULL,
+		currency VARCHAR(8) NOT NULL,
+		channel VARCHAR(16) NOT NULL,
+		status VARCHAR(16) NOT NULL DEFAULT 'pending',
+		transaction_id VARCHAR(64) NULL,
+		created_at DATETIME NOT NULL,
+		paid_at DATETIME NULL,
+		INDEX idx_student (student_id, course_id),
+		INDEX idx_status (status, created_at)
+	)`,
+	`CREATE TABLE IF NOT EXISTS course_enrollments (
+		course_id INT NOT NULL,
+		student_id INT NOT NULL,
+		order_id INT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (course_id, student_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS access_codes (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		code VARCHAR(32) NOT NULL UNIQUE,
+		course_id INT NOT NULL,
+		session_id INT NULL,
+		batch VARCHAR(64) NOT NULL DEFAULT '',
+		max_uses INT NOT NULL,
+		used_count INT NOT NULL DEFAULT 0,
+		expires_at DATETIME NULL,
+		created_by INT NOT NULL,
+		created_at DATETIME NOT NULL,
+		INDEX idx_course (course_id, batch)
+	)`,
+	`CREATE TABLE IF NOT EXISTS access_code_redemptions (
+		code_id INT NOT NULL,
+		student_id INT NOT NULL,
+		redeemed_at DATETIME NOT NULL,
+		PRIMARY KEY (code_id, student_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_grants (
+		session_id INT NOT NULL,
+		student_id INT NOT NULL,
+		code_id INT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (session_id, student_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS course_chapters (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		course_id INT NOT NULL,
+		title VARCHAR(255) NOT NULL,
+		position INT NOT NULL,
+		created_at DATETIME NOT NULL,
+		INDEX idx_course (course_id, position)
+	)`,
+	`CREATE TABLE IF NOT EXISTS course_lessons (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		course_id INT NOT NULL,
+		chapter_id INT NOT NULL,
+		title VARCHAR(255) NOT NULL,
+		position INT NOT NULL,
+		kind VARCHAR(16) NOT NULL,
+		session_id INT NULL,
+		scheduled_at DATETIME NULL,
+		video_key VARCHAR(512) NOT NULL DEFAULT '',
+		duration_seconds INT NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		INDEX idx_chapter (chapter_id, position),
+		INDEX idx_course (course_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS lesson_progress (
+		lesson_id INT NOT NULL,
+		student_id INT NOT NULL,
+		position_seconds INT NOT NULL DEFAULT 0,
+		completed_at DATETIME NULL,
+		updated_at DATETIME NOT NULL,
+		PRIMARY KEY (lesson_id, student_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS video_checkpoints (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		target_type VARCHAR(16) NOT NULL,
+		target_id INT NOT NULL,
+		question_id INT NOT NULL,
+		offset_ms INT NOT NULL,
+		created_by INT NOT NULL,
+		created_at DATETIME NOT NULL,
+		INDEX idx_target (target_type, target_id, offset_ms)
+	)`,
+	`CREATE TABLE IF NOT EXISTS recording_clips (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		recording_id INT NOT NULL,
+		course_id INT NOT NULL,
+		title VARCHAR(128) NOT NULL,
+		start_ms INT NOT NULL,
+		end_ms INT NOT NULL,
+		status VARCHAR(16) NOT NULL,
+		video_key VARCHAR(512) NOT NULL DEFAULT '',
+		error TEXT NULL,
+		created_by INT NOT NULL,
+		created_at DATETIME NOT NULL,
+		finished_at DATETIME NULL,
+		INDEX idx_course (course_id, recording_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_markers (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		session_id INT NOT NULL,
+		label VARCHAR(128) NOT NULL,
+		created_by INT NOT NULL,
+		created_at DATETIME(3) NOT NULL,
+		INDEX idx_session (session_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_publishers (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		session_id INT NOT NULL,
+		name VARCHAR(64) NOT NULL,
+		user_id INT NULL,
+		stream_key VARCHAR(64) NOT NULL UNIQUE,
+		layout VARCHAR(16) NOT NULL,
+		priority INT NOT NULL DEFAULT 0,
+		status VARCHAR(16) NOT NULL,
+		started_at DATETIME NULL,
+		ended_at DATETIME NULL,
+		created_at DATETIME NOT NULL,
+		INDEX idx_session (session_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_qoe (
+		session_id INT NOT NULL,
+		window_start DATETIME NOT NULL,
+		region VARCHAR(64) NOT NULL,
+		beacons INT NOT NULL DEFAULT 0,
+		affected INT NOT NULL DEFAULT 0,
+		watch_ms BIGINT NOT NULL DEFAULT 0,
+		buffering_count INT NOT NULL DEFAULT 0,
+		buffering_ms BIGINT NOT NULL DEFAULT 0,
+		bitrate_switches INT NOT NULL DEFAULT 0,
+		bitrate_sum BIGINT NOT NULL DEFAULT 0,
+		latency_sum BIGINT NOT NULL DEFAULT 0,
+		latency_samples INT NOT NULL DEFAULT 0,
+		errors INT NOT NULL DEFAULT 0,
+		PRIMARY KEY (session_id, window_start, region)
+	)`,
+	`CREATE TABLE IF NOT EXISTS sms_codes (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		phone VARCHAR(32) NOT NULL,
+		code_hash VARCHAR(64) NOT NULL,
+		attempts INT NOT NULL DEFAULT 0,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME NULL,
+		created_at DATETIME NOT NULL,
+		INDEX idx_phone_created (phone, created_at)
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_identities (
+		issuer VARCHAR(255) NOT NULL,
+		subject VARCHAR(255) NOT NULL,
+		user_id INT NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (issuer, subject),
+		INDEX idx_user (user_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS lti_contexts (
+		issuer VARCHAR(255) NOT NULL,
+		context_id VARCHAR(255) NOT NULL,
+		course_id INT NOT NULL,
+		title VARCHAR(255) NOT NULL DEFAULT '',
+		lineitems_url VARCHAR(1024) NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		PRIMARY KEY (issuer, context_id),
+		INDEX idx_course (course_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS lti_nonces (
+		nonce VARCHAR(64) PRIMARY KEY,
+		expires_at DATETIME NOT NULL,
+		INDEX idx_expires (expires_at)
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_picks (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		session_id INT NOT NULL,
+		picked_by INT NOT NULL,
+		exclude_previous BOOLEAN NOT NULL DEFAULT FALSE,
+		candidates TEXT NOT NULL,
+		winners TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		INDEX idx_session (session_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS class_groups (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(64) NOT NULL,
+		org VARCHAR(64) NOT NULL DEFAULT '',
+		created_by INT NOT NULL,
+		created_at DATETIME NOT NULL,
+		UNIQUE KEY uk_org_name (org, name)
+	)`,
+	`CREATE TABLE IF NOT EXISTS class_group_members (
+		group_id INT NOT NULL,
+		student_id INT NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (group_id, student_id),
+		INDEX idx_student (student_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS abuse_reports (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		session_id INT NOT NULL,
+		reporter_id INT NOT NULL,
+		target_user_id INT NOT NULL,
+		message_id BIGINT NOT NULL DEFAULT 0,
+		reason VARCHAR(16) NOT NULL,
+		detail VARCHAR(500) NOT NULL DEFAULT '',
+		status VARCHAR(16) NOT NULL,
+		reviewed_by INT NULL,
+		review_note VARCHAR(500) NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		reviewed_at DATETIME NULL,
+		UNIQUE KEY uk_report (session_id, reporter_id, target_user_id, message_id),
+		INDEX idx_status (status, created_at),
+		INDEX idx_target (session_id, target_user_id, status)
+	)`,
+	`CREATE TABLE IF NOT EXISTS chat_mutes (
+		session_id INT NOT NULL,
+		user_id INT NOT NULL,
+		muted_until DATETIME NOT NULL,
+		reason VARCHAR(64) NOT NULL DEFAULT '',
+		created_by INT NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (session_id, user_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS question_media (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		media_key VARCHAR(255) NOT NULL,
+		kind VARCHAR(16) NOT NULL,
+		content_type VARCHAR(64) NOT NULL,
+		size_bytes BIGINT NOT NULL,
+		created_by INT NOT NULL,
+		created_at DATETIME NOT NULL,
+		UNIQUE KEY uk_media_key (media_key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS answer_revisions (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		answer_id INT NOT NULL,
+		question_id INT NOT NULL,
+		push_id INT NOT NULL,
+		student_id INT NOT NULL,
+		answer VARCHAR(255) NOT NULL,
+		credit DOUBLE PRECISION NULL,
+		submitted_at DATETIME NOT NULL,
+		replaced_at DATETIME NOT NULL,
+		INDEX idx_answer (answer_id),
+		INDEX idx_push_student (push_id, student_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS answer_sync_keys (
+		student_id INT NOT NULL,
+		client_id VARCHAR(64) NOT NULL,
+		question_id INT NOT NULL,
+		status VARCHAR(16) NOT NULL,
+		code VARCHAR(64) NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (student_id, client_id),
+		INDEX idx_created (created_at)
+	)`,
+	`CREATE TABLE IF NOT EXISTS question_acks (
+		push_id INT NOT NULL,
+		student_id INT NOT NULL,
+		acked_at DATETIME(3) NOT NULL,
+		PRIMARY KEY (push_id, student_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS network_policies (
+		course_id INT NOT NULL DEFAULT 0,
+		org VARCHAR(64) NOT NULL DEFAULT '',
+		cidrs TEXT NOT NULL,
+		restrict_playback BOOLEAN NOT NULL DEFAULT FALSE,
+		restrict_answers BOOLEAN NOT NULL DEFAULT FALSE,
+		updated_by INT NOT NULL,
+		updated_at DATETIME NOT NULL,
+		PRIMARY KEY (course_id, org)
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_waitlist (
+		session_id INT NOT NULL,
+		user_id INT NOT NULL,
+		queued_at DATETIME(3) NOT NULL,
+		seen_at DATETIME(3) NOT NULL,
+		PRIMARY KEY (session_id, user_id),
+		INDEX idx_session_queued (session_id, queued_at)
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_slides (
+		session_id INT PRIMARY KEY,
+		document VARCHAR(255) NOT NULL,
+		page INT NOT NULL,
+		updated_by INT NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_announcements (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		session_id INT NOT NULL,
+		content TEXT NOT NULL,
+		created_by INT NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NULL,
+		dismissed_at DATETIME NULL,
+		INDEX idx_session (session_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_timers (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		session_id INT NOT NULL,
+		kind VARCHAR(20) NOT NULL,
+		label VARCHAR(100) NOT NULL DEFAULT '',
+		duration_seconds INT NOT NULL,
+		status VARCHAR(20) NOT NULL,
+		started_at DATETIME(3) NOT NULL,
+		ends_at DATETIME(3) NOT NULL,
+		created_by INT NOT NULL,
+		finished_at DATETIME(3) NULL,
+		INDEX idx_status_session (status, session_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS quiz_rules (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		course_id INT NOT NULL,
+		metric VARCHAR(20) NOT NULL,
+		operator VARCHAR(10) NOT NULL,
+		threshold DOUBLE PRECISION NOT NULL,
+		after_seconds INT NOT NULL DEFAULT 0,
+		min_answers INT NOT NULL DEFAULT 0,
+		action VARCHAR(20) NOT NULL,
+		action_seconds INT NOT NULL DEFAULT 0,
+		created_by INT NOT NULL,
+		created_at DATETIME NOT NULL,
+		INDEX idx_course (course_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS quiz_rule_firings (
+		rule_id INT NOT NULL,
+		push_id INT NOT NULL,
+		fired_at DATETIME(3) NOT NULL,
+		PRIMARY KEY (rule_id, push_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_series (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		course_id INT NOT NULL,
+		teacher_id INT NOT NULL,
+		weekdays VARCHAR(32) NOT NULL,
+		start_time VARCHAR(5) NOT NULL,
+		time_zone VARCHAR(64) NOT NULL,
+		start_date VARCHAR(10) NOT NULL,
+		weeks INT NOT NULL,
+		exclude_dates TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		INDEX idx_course (course_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS org_holidays (
+		org VARCHAR(64) NOT NULL DEFAULT '',
+		date VARCHAR(10) NOT NULL,
+		name VARCHAR(100) NOT NULL,
+		updated_by INT NOT NULL,
+		updated_at DATETIME NOT NULL,
+		PRIMARY KEY (org, date)
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_notifications (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		user_id INT NOT NULL,
+		type VARCHAR(32) NOT NULL,
+		data TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		read_at DATETIME NULL,
+		INDEX idx_user (user_id, id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS login_sessions (
+		id VARCHAR(32) PRIMARY KEY,
+		user_id INT NOT NULL,
+		impersonator_id INT NOT NULL DEFAULT 0,
+		user_agent VARCHAR(255) NOT NULL,
+		ip VARCHAR(64) NOT NULL,
+		created_at DATETIME NOT NULL,
+		last_seen_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		revoked_at DATETIME NULL,
+		INDEX idx_user (user_id, expires_at)
+	)`,
+	`CREATE TABLE IF NOT EXISTS refresh_tokens (
+		token_hash VARCHAR(64) PRIMARY KEY,
+		login_session_id VARCHAR(32) NOT NULL,
+		user_id INT NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME NULL,
+		INDEX idx_session (login_session_id),
+		INDEX idx_user (user_id, expires_at)
+	)`,
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		org VARCHAR(64) NOT NULL DEFAULT '',
+		name VARCHAR(100) NOT NULL,
+		prefix VARCHAR(16) NOT NULL,
+		key_hash VARCHAR(64) NOT NULL UNIQUE,
+		previous_hash VARCHAR(64) NULL,
+		previous_expires_at DATETIME NULL,
+		scopes TEXT NOT NULL,
+		rate_limit INT NOT NULL DEFAULT 0,
+		created_by INT NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NULL,
+		last_used_at DATETIME NULL,
+		last_used_ip VARCHAR(64) NOT NULL DEFAULT '',
+		rotated_at DATETIME NULL,
+		revoked_at DATETIME NULL,
+		INDEX idx_org (org),
+		INDEX idx_previous (previous_hash)
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_relays (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		session_id INT NOT NULL,
+		name VARCHAR(100) NOT NULL,
+		url VARCHAR(1024) NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		status VARCHAR(16) NOT NULL,
+		last_error TEXT NOT NULL,
+		restarts INT NOT NULL DEFAULT 0,
+		started_at DATETIME NULL,
+		created_by INT NOT NULL,
+		updated_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL,
+		INDEX idx_session (session_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS recording_policies (
+		course_id INT NOT NULL DEFAULT 0,
+		org VARCHAR(64) NOT NULL DEFAULT '',
+		quota_bytes BIGINT NOT NULL DEFAULT 0,
+		cold_after_days INT NULL,
+		delete_after_days INT NULL,
+		updated_by INT NOT NULL,
+		updated_at DATETIME NOT NULL,
+		PRIMARY KEY (course_id, org)
+	)`,
+}
+
+// 已有数据表上新增的列，启动时检查缺失后补充
+var columnMigrations = []struct {
+	table, column, definition string
+}{
+	{"users", "time_zone", "VARCHAR(64) NOT NULL DEFAULT ''"},
+	{"users", "org", "VARCHAR(64) NOT NULL DEFAULT ''"},
+	{"users", "phone", "VARCHAR(32) NULL"},
+	{"users", "external_id", "VARCHAR(64) NULL"},
+	{"questions", "difficulty", "VARCHAR(16) NOT NULL DEFAULT ''"},
+	{"questions", "estimated_seconds", "INT NOT NULL DEFAULT 0"},
+	{"answers", "push_id", "INT NOT NULL DEFAULT 0"},
+	{"answers", "submitted_at", "DATETIME(3) NULL"},
+	{"answers", "latency_ms", "INT NULL"},
+	{"answers", "exam_id", "INT NOT NULL DEFAULT 0"},
+	{"answers", "makeup", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"answers", "checkpoint_id", "INT NOT NULL DEFAULT 0"},
+	{"session_markers", "kind", "VARCHAR(16) NOT NULL DEFAULT 'important'"},
+	{"session_markers", "note", "TEXT NULL"},
+	{"session_markers", "stream_offset_ms", "INT NULL"},
+	{"exam_attempts", "makeup", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"live_sessions", "thumbnail", "VARCHAR(255) NOT NULL DEFAULT ''"},
+	{"live_sessions", "teacher_id", "INT NOT NULL DEFAULT 0"},
+	{"live_sessions", "scheduled_at", "DATETIME NULL"},
+	{"live_sessions", "series_id", "INT NOT NULL DEFAULT 0"},
+	{"live_sessions", "scheduled_minutes", "INT NOT NULL DEFAULT 0"},
+	{"session_series", "duration_minutes", "INT NOT NULL DEFAULT 60"},
+	{"live_sessions", "cancelled_at", "DATETIME NULL"},
+	{"live_sessions", "cancel_reason", "VARCHAR(255) NOT NULL DEFAULT ''"},
+	{"live_sessions", "makeup_proposed_at", "DATETIME NULL"},
+	{"live_sessions", "makeup_for", "INT NOT NULL DEFAULT 0"},
+	{"live_sessions", "stream_misses", "INT NOT NULL DEFAULT 0"},
+	{"live_sessions", "stream_checked_at", "DATETIME NULL"},
+	{"recordings", "video_key", "VARCHAR(512) NOT NULL DEFAULT ''"},
+	{"recordings", "trim_ms", "INT NOT NULL DEFAULT 0"},
+	{"recordings", "size_bytes", "BIGINT NOT NULL DEFAULT 0"},
+	{"recordings", "storage_class", "VARCHAR(16) NOT NULL DEFAULT 'standard'"},
+	{"recordings", "archived_at", "DATETIME NULL"},
+	{"recordings", "delete_warned_at", "DATETIME NULL"},
+	{"recordings", "deleted_at", "DATETIME NULL"},
+	{"session_settings", "stream_profile", "VARCHAR(16) NOT NULL DEFAULT 'standard'"},
+	{"session_settings", "watermark_enabled", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"session_settings", "watermark_text", "VARCHAR(128) NOT NULL DEFAULT ''"},
+	{"session_settings", "forensic_watermark", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"session_settings", "audio_variant", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"session_settings", "student_audio_only", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"session_settings", "chat_slow_mode", "INT NOT NULL DEFAULT 0"},
+	{"session_settings", "chat_members_only", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"session_settings", "chat_emoji_only", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"session_settings", "max_participants", "INT NOT NULL DEFAULT 0"},
+	{"recording_jobs", "watermark", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"questions", "status", "VARCHAR(16) NOT NULL DEFAULT 'published'"},
+	{"questions", "rich_content", "TEXT NULL"},
+	{"questions", "scoring", "VARCHAR(16) NOT NULL DEFAULT 'all_or_nothing'"},
+	{"answers", "credit", "DOUBLE PRECISION NULL"},
+	{"exam_attempts", "points", "DOUBLE PRECISION NULL"},
+	{"question_pushes", "closes_at", "DATETIME(3) NULL"},
+	{"exams", "single_device", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"exam_attempts", "login_session_id", "VARCHAR(32) NOT NULL DEFAULT ''"},
+	{"answers", "offline", "BOOLEAN NOT NULL DEFAULT FALSE"},
+}
+
+// 已有数据表上新增的索引，name 不含表名前缀
+var indexMigrations = []struct {
+	table, name, columns string
+	unique               bool
+}{
+	{"users", "uk_external_id", "external_id", true},
+	{"answers", "idx_push_student", "push_id, student_id", false},
+}
+
+// 创建缺失的数据表、列和索引
+func migrate(db *sql.DB) error {
+	for i, migration := range migrations {
+		for _, stmt := range dialect.createTable(migration) {
+			if _, err := db.Exec(stmt); err != nil {
+				return fmt.Errorf("migration %d failed: %w", i, err)
+			}
+		}
+	}
+
+	for _, m := range columnMigrations {
+		var count int
+		err := db.QueryRow(dialect.columnExistsSQL(), m.table, m.column).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("check column %s.%s failed: %w", m.table, m.column, err)
+		}
+		if count > 0 {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, dialect.columnType(m.definition))); err != nil {
+			return fmt.Errorf("add column %s.%s failed: %w", m.table, m.column, err)
+		}
+	}
+
+	for _, m := range indexMigrations {
+		// 与 splitIndexes 一致加上表名前缀，PostgreSQL 和 SQLite 的索引名在库内唯一
+		name := m.table + "_" + m.name
+		var count int
+		if err := db.QueryRow(dialect.indexExistsSQL(), m.table, name).Scan(&count); err != nil {
+			return fmt.Errorf("check index %s failed: %w", name, err)
+		}
+		if count > 0 {
+			continue
+		}
+		kind := "INDEX"
+		if m.unique {
+			kind = "UNIQUE INDEX"
+		}
+		if _, err := db.Exec(fmt.Sprintf("CREATE %s %s ON %s (%s)", kind, name, m.table, m.columns)); err != nil {
+			return fmt.Errorf("create index %s failed: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// 多选题的计分方式：all_or_nothing 全对才得分；partial 按选中的正确选项比例得分，选错任一项不得分；
+// penalty 每个正确选项加分、每个错误选项扣同样的分，最低为 0
+const (
+	ScoringAllOrNothing = "all_or_nothing"
+	ScoringPartial      = "partial"
+	ScoringPenalty      = "penalty"
+)
+
+// 多选题答案中的选项，用逗号分隔且不区分顺序和大小写，如 "A,C"
+func choiceSet(answer string) []string {
+	seen := make(map[string]bool)
+	choices := []string{}
+	for _, s := range strings.Split(answer, ",") {
+		s = strings.ToUpper(strings.TrimSpace(s))
+		if s != "" && !seen[s] {
+			seen[s] = true
+			choices = append(choices, s)
+		}
+	}
+	sort.Strings(choices)
+	return choices
+}
+
+// 统一答案的写法，多选题的选项排序去重后保存，使相同选择的答案可以直接比较
+func normalizeAnswer(questionType, answer string) string {
+	if questionType != QuestionMultipleChoice {
+		return answer
+	}
+	return strings.Join(choiceSet(answer), ",")
+}
+
+// 按题目的计分方式计算作答得分，范围 0 到 1；多选题以外的题型答案一致才得分
+func answerCredit(questionType, scoring, correctAnswer, answer string) float64 {
+	if questionType != QuestionMultipleChoice {
+		if answer == correctAnswer {
+			return 1
+		}
+		return 0
+	}
+
+	correct := choiceSet(correctAnswer)
+	if len(correct) == 0 {
+		return 0
+	}
+	isCorrect := make(map[string]bool, len(correct))
+	for _, s := range correct {
+		isCorrect[s] = true
+	}
+	hits, misses := 0, 0
+	for _, s := range choiceSet(answer) {
+		if isCorrect[s] {
+			hits++
+		} else {
+			misses++
+		}
+	}
+
+	switch scoring {
+	case ScoringPartial:
+		if misses > 0 {
+			return 0
+		}
+		return roundCredit(float64(hits) / float64(len(correct)))
+	case ScoringPenalty:
+		return roundCredit(math.Max(0, float64(hits-misses)/float64(len(correct))))
+	default:
+		if hits == len(correct) && misses == 0 {
+			return 1
+		}
+		return 0
+	}
+}
+
+// 得分保留四位小数，避免汇总时出现 0.30000000000000004 之类的结果
+func roundCredit(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxSeriesSessions = 200
+	seriesDateLayout  = "2006-01-02"
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// 按重复规则批量创建的一组直播会话，如“16 周内每周二、周四 19:00”
+type SessionSeries struct {
+	ID           int      `json:"id"`
+	CourseID     int      `json:"course_id"`
+	TeacherID    int      `json:"teacher_id"`
+	Weekdays     []string `json:"weekdays"`
+	StartTime    string   `json:"start_time"` // 当地时间 HH:MM
+	TimeZone     string   `json:"time_zone"`
+	StartDate    string   `json:"start_date"`
+	Weeks        int      `json:"weeks"`
+	ExcludeDates []string `json:"exclude_dates"`
+	// 每节课的时长（分钟），用于检查排课冲突
+	DurationMinutes int           `json:"duration_minutes"`
+	CreatedAt       time.Time     `json:"created_at"`
+	Sessions        []LiveSession `json:"sessions"`
+
+	// 创建时落在机构节假日的日期和与老师其他课程重叠的课次
+	HolidayPolicy string             `json:"holiday_policy,omitempty"`
+	Holidays      []Holiday          `json:"holidays,omitempty"`
+	Conflicts     []ScheduleConflict `json:"conflicts,omitempty"`
+}
+
+// 重复规则展开后的上课时间，跳过排除的日期
+func expandSeries(weekdays []string, startTime, startDate string, weeks int, exclude []string, loc *time.Location) ([]time.Time, error) {
+	days := make(map[time.Weekday]bool, len(weekdays))
+	for _, name := range weekdays {
+		d, ok := weekdayNames[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday %q", name)
+		}
+		days[d] = true
+	}
+	at, err := time.Parse("15:04", startTime)
+	if err != nil {
+		return nil, err
+	}
+	first, err := time.ParseInLocation(seriesDateLayout, startDate, loc)
+	if err != nil {
+		return nil, err
+	}
+	skip := make(map[string]bool, len(exclude))
+	for _, d := range exclude {
+		skip[d] = true
+	}
+
+	var times []time.Time
+	for i := 0; i < weeks*7; i++ {
+		day := first.AddDate(0, 0, i)
+		if !days[day.Weekday()] || skip[day.Format(seriesDateLayout)] {
+			continue
+		}
+		times = append(times, seriesTime(day, at, loc).UTC())
+	}
+	return times, nil
+}
+
+// day 当天 at 时刻的当地时间。夏令时开始当天跳过的时刻，time.Date 可能换算到跳过之前，
+// 这里统一顺延到跳过之后，避免课程比设定的时间提前
+func seriesTime(day, at time.Time, loc *time.Location) time.Time {
+	t := time.Date(day.Year(), day.Month(), day.Day(), at.Hour(), at.Minute(), 0, 0, loc)
+	wall := time.Date(day.Year(), day.Month(), day.Day(), at.Hour(), at.Minute(), 0, 0, time.UTC)
+	local := t.In(loc)
+	if d := wall.Sub(time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), 0, 0, time.UTC)); d > 0 {
+		t = t.Add(d)
+	}
+	return t
+}
+
+// 按重复规则为课程批量创建直播会话，全部创建成功或全部不创建
+func createSessionSeries(c *gin.Context) {
+	var req struct {
+		CourseID     int      `json:"course_id" binding:"required"`
+		Weekdays     []string `json:"weekdays" binding:"required,min=1,max=7"`
+		StartTime    string   `json:"start_time" binding:"required"`
+		TimeZone     string   `json:"time_zone"`
+		StartDate    string   `json:"start_date" binding:"required"`
+		Weeks        int      `json:"weeks" binding:"required,min=1,max=52"`
+		ExcludeDates []string `json:"exclude_dates" binding:"max=366"`
+		// 每节课的时长，默认 60 分钟
+		DurationMinutes int `json:"duration_minutes" binding:"omitempty,min=5,max=720"`
+		// 遇到机构节假日时 warn（默认）照常创建并提示，skip 跳过
+		Holidays string `json:"holidays" binding:"omitempty,oneof=warn skip"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	loc := requestLocation(c)
+	if req.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(req.TimeZone); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidParam, "time_zone")
+			return
+		}
+	}
+	if _, err := time.Parse("15:04", req.StartTime); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "start_time")
+		return
+	}
+	if _, err := time.Parse(seriesDateLayout, req.StartDate); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "start_date")
+		return
+	}
+	for _, d := range req.ExcludeDates {
+		if _, err := time.Parse(seriesDateLayout, d); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidParam, "exclude_dates")
+			return
+		}
+	}
+	times, err := expandSeries(req.Weekdays, req.StartTime, req.StartDate, req.Weeks, req.ExcludeDates, loc)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "weekdays")
+		return
+	}
+	if req.DurationMinutes == 0 {
+		req.DurationMinutes = defaultSessionMinutes
+	}
+	if req.Holidays == "" {
+		req.Holidays = HolidayWarn
+	}
+
+	// 按上课当地的日期匹配老师所属机构的节假日
+	user := currentUser(c)
+	var landed []Holiday
+	if len(times) > 0 {
+		holidays, err := loadHolidays(user.Org, times[0].In(loc).Format(seriesDateLayout), times[len(times)-1].In(loc).Format(seriesDateLayout))
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeHolidayGetFailed)
+			return
+		}
+		byDate := make(map[string]Holiday, len(holidays))
+		for _, h := range holidays {
+			byDate[h.Date] = h
+		}
+		kept := times[:0]
+		for _, t := range times {
+			h, ok := byDate[t.In(loc).Format(seriesDateLayout)]
+			if ok {
+				landed = append(landed, h)
+			}
+			if !ok || req.Holidays != HolidaySkip {
+				kept = append(kept, t)
+			}
+		}
+		times = kept
+	}
+	if len(times) == 0 {
+		respondError(c, http.StatusBadRequest, CodeSeriesEmpty)
+		return
+	}
+	if len(times) > maxSeriesSessions {
+		respondError(c, http.StatusBadRequest, CodeSeriesTooLarge, maxSeriesSessions)
+		return
+	}
+
+	series := SessionSeries{
+		CourseID:        req.CourseID,
+		TeacherID:       user.ID,
+		Weekdays:        req.Weekdays,
+		StartTime:       req.StartTime,
+		TimeZone:        loc.String(),
+		StartDate:       req.StartDate,
+		Weeks:           req.Weeks,
+		ExcludeDates:    req.ExcludeDates,
+		DurationMinutes: req.DurationMinutes,
+		CreatedAt:       time.Now().UTC(),
+		Sessions:        make([]LiveSession, 0, len(times)),
+		HolidayPolicy:   req.Holidays,
+		Holidays:        landed,
+	}
+	if series.ExcludeDates == nil {
+		series.ExcludeDates = []string{}
+	}
+	for i, d := range series.Weekdays {
+		series.Weekdays[i] = strings.ToLower(d)
+	}
+
+	ctx := c.Request.Context()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionCreateFailed)
+		return
+	}
+	defer tx.Rollback()
+
+	seriesID, err := dialect.insertID(tx, `
+		INSERT INTO session_series (course_id, teacher_id, weekdays, start_time, time_zone, start_date, weeks, exclude_dates,
+			duration_minutes, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, series.CourseID, series.TeacherID, strings.Join(series.Weekdays, ","), series.StartTime, series.TimeZone,
+		series.StartDate, series.Weeks, strings.Join(series.ExcludeDates, ","), series.DurationMinutes, series.CreatedAt)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionCreateFailed)
+		return
+	}
+	series.ID = int(seriesID)
+
+	for _, at := range times {
+		scheduledAt := at
+		s := LiveSession{
+			CourseID:    series.CourseID,
+			TeacherID:   series.TeacherID,
+			StreamKey:   generateStreamKey(),
+			Status:      "pending",
+			CreatedAt:   series.CreatedAt,
+			ScheduledAt: &scheduledAt,
+			SeriesID:    series.ID,
+
+			ScheduledMinutes: series.DurationMinutes,
+		}
+		id, err := dialect.insertID(tx, `
+			INSERT INTO live_sessions (course_id, teacher_id, stream_key, status, created_at, scheduled_at, scheduled_minutes, series_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, s.CourseID, s.TeacherID, s.StreamKey, s.Status, s.CreatedAt, s.ScheduledAt, s.ScheduledMinutes, s.SeriesID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeSessionCreateFailed)
+			return
+		}
+		s.ID = int(id)
+		series.Sessions = append(series.Sessions, s)
+	}
+
+	// 提交前检查，已有的课次不包含本次创建的
+	slots := make([]ScheduleSlot, 0, len(series.Sessions))
+	for _, s := range series.Sessions {
+		slots = append(slots, ScheduleSlot{
+			SessionID: s.ID,
+			CourseID:  s.CourseID,
+			StartsAt:  *s.ScheduledAt,
+			EndsAt:    s.ScheduledAt.Add(time.Duration(s.ScheduledMinutes) * time.Minute),
+		})
+	}
+	if series.Conflicts, err = findScheduleConflicts(series.TeacherID, slots); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+
+	// Livego 中的流全部创建成功后才提交，失败时删除已创建的流
+	for i, s := range series.Sessions {
+		if err := media().CreateStream(ctx, s.StreamKey); err != nil {
+			log.Printf("Failed to create stream for series %d: %v", series.ID, err)
+			deleteSeriesStreams(series.Sessions[:i])
+			respondError(c, http.StatusInternalServerError, CodeStreamCreateFailed)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		deleteSeriesStreams(series.Sessions)
+		respondError(c, http.StatusInternalServerError, CodeSessionCreateFailed)
+		return
+	}
+
+	for _, s := range series.Sessions {
+		emitEvent(EventSessionCreated, s.CourseID, gin.H{"session_id": s.ID, "course_id": s.CourseID, "series_id": series.ID})
+	}
+	recordAudit(c, "create_session_series", "course", series.CourseID, gin.H{"series_id": series.ID, "sessions": len(series.Sessions)})
+
+	out := requestLocation(c)
+	series.CreatedAt = series.CreatedAt.In(out)
+	for i := range series.Sessions {
+		series.Sessions[i].inLocation(out)
+	}
+	for i := range series.Conflicts {
+		series.Conflicts[i].inLocation(out)
+	}
+	respondOK(c, http.StatusCreated, series)
+}
+
+func deleteSeriesStreams(sessions []LiveSession) {
+	for _, s := range sessions {
+		if err := media().DeleteStream(context.Background(), s.StreamKey); err != nil {
+			log.Printf("Failed to delete stream of session %d: %v", s.ID, err)
+		}
+	}
+}
+
+// 读取路径中的系列并校验当前用户是否为授课老师，失败时写入错误响应
+func seriesParam(c *gin.Context) (SessionSeries, bool) {
+	var series SessionSeries
+	id, ok := intParam(c, "series_id")
+	if !ok {
+		return series, false
+	}
+	var weekdays, exclude string
+	err := db.QueryRow(`
+		SELECT id, course_id, teacher_id, weekdays, start_time, time_zone, start_date, weeks, exclude_dates, duration_minutes, created_at
+		FROM session_series
+		WHERE id = ?
+	`, id).Scan(&series.ID, &series.CourseID, &series.TeacherID, &weekdays, &series.StartTime, &series.TimeZone,
+		&series.StartDate, &series.Weeks, &exclude, &series.DurationMinutes, &series.CreatedAt)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSeriesNotFound)
+		return series, false
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return series, false
+	}
+	series.Weekdays = splitList(weekdays)
+	series.ExcludeDates = splitList(exclude)
+
+	user := currentUser(c)
+	if user.Role != RoleAdmin && series.TeacherID != user.ID {
+		respondError(c, http.StatusForbidden, CodeForbidden)
+		return series, false
+	}
+	return series, true
+}
+
+func seriesSessions(seriesID int) ([]LiveSession, error) {
+	rows, err := db.Query(`
+		SELECT id, course_id, teacher_id, stream_key, status, start_time, end_time, created_at, scheduled_at, scheduled_minutes, series_id
+		FROM live_sessions
+		WHERE series_id = ?
+		ORDER BY scheduled_at, id
+	`, seriesID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []LiveSession{}
+	for rows.Next() {
+		var s LiveSession
+		if err := rows.Scan(&s.ID, &s.CourseID, &s.TeacherID, &s.StreamKey, &s.Status, &s.StartTime, &s.EndTime,
+			&s.CreatedAt, &s.ScheduledAt, &s.ScheduledMinutes, &s.SeriesID); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// 系列及其中的所有会话
+func getSessionSeries(c *gin.Context) {
+	series, ok := seriesParam(c)
+	if !ok {
+		return
+	}
+	sessions, err := seriesSessions(series.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+	loc := requestLocation(c)
+	series.CreatedAt = series.CreatedAt.In(loc)
+	for i := range sessions {
+		sessions[i].inLocation(loc)
+	}
+	series.Sessions = sessions
+	respondOK(c, http.StatusOK, series)
+}
+
+// 批量取消系列中尚未开始的会话。from 为日期时只取消该日及之后的会话，session_ids 不为空时只取消指定的会话
+func cancelSessionSeries(c *gin.Context) {
+	series, ok := seriesParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		From       string `json:"from"`
+		SessionIDs []int  `json:"session_ids" binding:"max=200"`
+		Reason     string `json:"reason" binding:"max=255"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	var from time.Time
+	if req.From != "" {
+		loc, err := time.LoadLocation(series.TimeZone)
+		if err != nil {
+			loc = time.UTC
+		}
+		if from, err = time.ParseInLocation(seriesDateLayout, req.From, loc); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidParam, "from")
+			return
+		}
+	}
+
+	sessions, err := seriesSessions(series.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+	selected := make(map[int]bool, len(req.SessionIDs))
+	for _, id := range req.SessionIDs {
+		selected[id] = true
+	}
+	var targets []LiveSession
+	for _, s := range sessions {
+		if s.Status != "pending" || (len(selected) > 0 && !selected[s.ID]) {
+			continue
+		}
+		if !from.IsZero() && s.ScheduledAt != nil && s.ScheduledAt.Before(from) {
+			continue
+		}
+		targets = append(targets, s)
+	}
+
+	ctx := c.Request.Context()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSeriesCancelFailed)
+		return
+	}
+	defer tx.Rollback()
+	cancelled := []LiveSession{}
+	reason, now := strings.TrimSpace(req.Reason), time.Now().UTC()
+	for _, s := range targets {
+		// 状态已变化的会话（如刚开始直播）不取消
+		res, err := tx.ExecContext(ctx, `
+			UPDATE live_sessions SET status = 'cancelled', cancelled_at = ?, cancel_reason = ?
+			WHERE id = ? AND status = 'pending'
+		`, now, reason, s.ID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeSeriesCancelFailed)
+			return
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			cancelled = append(cancelled, s)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSeriesCancelFailed)
+		return
+	}
+
+	ids := make([]int, 0, len(cancelled))
+	for _, s := range cancelled {
+		ids = append(ids, s.ID)
+		onSessionCancelled(s, reason, nil)
+	}
+	sort.Ints(ids)
+	recordAudit(c, "cancel_session_series", "course", series.CourseID, gin.H{"series_id": series.ID, "session_ids": ids})
+	respondOK(c, http.StatusOK, gin.H{"series_id": series.ID, "cancelled": len(ids), "session_ids": ids})
+}
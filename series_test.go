@@ -0,0 +1,125 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestExpandSeries(t *testing.T) {
+	load := func(name string) *time.Location {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return loc
+	}
+	shanghai, newYork, london := load("Asia/Shanghai"), load("America/New_York"), load("Europe/London")
+	utc := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name      string
+		weekdays  []string
+		startTime string
+		startDate string
+		weeks     int
+		exclude   []string
+		loc       *time.Location
+		want      []time.Time // 只比较 first、last 时 count 非零
+		count     int
+	}{
+		{
+			name: "semester count", weekdays: []string{"tue", "thu"}, startTime: "19:00", startDate: "2026-09-01", weeks: 16, loc: shanghai,
+			count: 32, want: []time.Time{utc("2026-09-01 11:00"), utc("2026-12-17 11:00")},
+		},
+		{
+			name: "last day is start plus weeks minus one", weekdays: []string{"mon"}, startTime: "08:00", startDate: "2026-03-02", weeks: 2, loc: time.UTC,
+			want: []time.Time{utc("2026-03-02 08:00"), utc("2026-03-09 08:00")},
+		},
+		{
+			name: "start date not on a listed weekday", weekdays: []string{"MON"}, startTime: "08:00", startDate: "2026-03-04", weeks: 1, loc: time.UTC,
+			want: []time.Time{utc("2026-03-09 08:00")},
+		},
+		{
+			name: "zero weeks", weekdays: []string{"mon"}, startTime: "08:00", startDate: "2026-03-02", weeks: 0, loc: time.UTC,
+		},
+		{
+			name: "month end", weekdays: []string{"thu", "sat"}, startTime: "10:00", startDate: "2026-01-29", weeks: 2, loc: time.UTC,
+			want: []time.Time{utc("2026-01-29 10:00"), utc("2026-01-31 10:00"), utc("2026-02-05 10:00"), utc("2026-02-07 10:00")},
+		},
+		{
+			name: "leap day", weekdays: []string{"tue"}, startTime: "10:00", startDate: "2028-02-26", weeks: 1, loc: time.UTC,
+			want: []time.Time{utc("2028-02-29 10:00")},
+		},
+		{
+			name: "year end", weekdays: []string{"tue", "fri"}, startTime: "20:30", startDate: "2026-12-29", weeks: 1, loc: shanghai,
+			want: []time.Time{utc("2026-12-29 12:30"), utc("2027-01-01 12:30")},
+		},
+		{
+			name: "exclusions", weekdays: []string{"mon", "wed"}, startTime: "08:00", startDate: "2026-03-02", weeks: 1, exclude: []string{"2026-03-04", "2026-04-01"}, loc: time.UTC,
+			want: []time.Time{utc("2026-03-02 08:00")},
+		},
+		{
+			// 夏令时开始后当地时间不变，UTC 时间提前一小时
+			name: "dst starts", weekdays: []string{"sat", "mon"}, startTime: "19:00", startDate: "2026-03-07", weeks: 1, loc: newYork,
+			want: []time.Time{utc("2026-03-08 00:00"), utc("2026-03-09 23:00")},
+		},
+		{
+			name: "dst ends", weekdays: []string{"sat", "mon"}, startTime: "09:00", startDate: "2026-10-24", weeks: 1, loc: london,
+			want: []time.Time{utc("2026-10-24 08:00"), utc("2026-10-26 09:00")},
+		},
+		{
+			// 跳过的当地时间顺延为 03:30 EDT，不能提前到 01:30 EST
+			name: "nonexistent local time", weekdays: []string{"sun"}, startTime: "02:30", startDate: "2026-03-08", weeks: 1, loc: newYork,
+			want: []time.Time{utc("2026-03-08 07:30")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandSeries(tt.weekdays, tt.startTime, tt.startDate, tt.weeks, tt.exclude, tt.loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.count > 0 {
+				if len(got) != tt.count {
+					t.Fatalf("got %d times, want %d", len(got), tt.count)
+				}
+				got = []time.Time{got[0], got[len(got)-1]}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("time %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExpandSeriesErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		weekdays  []string
+		startTime string
+		startDate string
+	}{
+		{"full weekday name", []string{"tuesday"}, "19:00", "2026-09-01"},
+		{"invalid time", []string{"tue"}, "25:00", "2026-09-01"},
+		{"invalid date", []string{"tue"}, "19:00", "2026-02-30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := expandSeries(tt.weekdays, tt.startTime, tt.startDate, 1, nil, time.UTC); err == nil {
+				t.Fatal("expandSeries succeeded, want error")
+			}
+		})
+	}
+}
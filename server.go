@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLS 配置：指定证书文件，或配置域名通过 Let's Encrypt 自动签发；都为空时使用 HTTP
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+
+	AutocertDomains  []string `json:"autocert_domains"`
+	AutocertEmail    string   `json:"autocert_email"`
+	AutocertCacheDir string   `json:"autocert_cache_dir"`
+
+	// 自动签发时用于 HTTP-01 验证和跳转 HTTPS 的端口，默认 80
+	HTTPPort int `json:"http_port"`
+}
+
+func (t TLSConfig) enabled() bool {
+	return t.CertFile != "" || len(t.AutocertDomains) > 0
+}
+
+// 启动 HTTP 服务，启用 TLS 时同时支持 HTTP/2
+func runServer(handler http.Handler) error {
+	cfg := currentConfig().TLS
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", currentConfig().APIPort),
+		Handler: handler,
+	}
+
+	if !cfg.enabled() {
+		log.Printf("Starting live service on port %d", currentConfig().APIPort)
+		return server.ListenAndServe()
+	}
+
+	// 使用 TLSConfig 时需显式声明 h2，否则只协商 HTTP/1.1
+	server.TLSConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+
+	if len(cfg.AutocertDomains) > 0 {
+		cacheDir := cfg.AutocertCacheDir
+		if cacheDir == "" {
+			cacheDir = "certs"
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+			Cache:      autocert.DirCache(cacheDir),
+			Email:      cfg.AutocertEmail,
+		}
+		server.TLSConfig.GetCertificate = manager.GetCertificate
+		server.TLSConfig.NextProtos = append(server.TLSConfig.NextProtos, "acme-tls/1")
+
+		httpPort := cfg.HTTPPort
+		if httpPort == 0 {
+			httpPort = 80
+		}
+		go func() {
+			if err := http.ListenAndServe(fmt.Sprintf(":%d", httpPort), manager.HTTPHandler(nil)); err != nil {
+				log.Printf("ACME HTTP listener stopped: %v", err)
+			}
+		}()
+	}
+
+	log.Printf("Starting live service with TLS on port %d", currentConfig().APIPort)
+	return server.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 补课会话与被取消或中断的原会话视为同一节课：出勤任一即算出勤，积分只计一次
+
+// 会话所属的课次，补课会话返回原会话，其他会话返回自身
+func attendanceUnit(sessionID int) (int, error) {
+	var makeupFor int
+	if err := db.QueryRow("SELECT makeup_for FROM live_sessions WHERE id = ?", sessionID).Scan(&makeupFor); err != nil {
+		return 0, err
+	}
+	if makeupFor != 0 {
+		return makeupFor, nil
+	}
+	return sessionID, nil
+}
+
+// 会话的补课会话，没有时返回 0
+func makeupSessionOf(sessionID int) (int, error) {
+	var id int
+	err := db.QueryRow("SELECT id FROM live_sessions WHERE makeup_for = ? ORDER BY id LIMIT 1", sessionID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// 将已取消或已结束（中断）的会话关联到补课会话
+func linkMakeupSession(c *gin.Context) {
+	id, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		MakeupSessionID int `json:"makeup_session_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if req.MakeupSessionID == id {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "makeup_session_id")
+		return
+	}
+	unlock, ok := lockSession(c, id)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	var original, makeup LiveSession
+	var originalFor, makeupFor int
+	err := db.QueryRow("SELECT id, course_id, status, scheduled_at, makeup_for FROM live_sessions WHERE id = ?", id).
+		Scan(&original.ID, &original.CourseID, &original.Status, &original.ScheduledAt, &originalFor)
+	if err == nil {
+		err = db.QueryRow("SELECT id, course_id, teacher_id, status, scheduled_at, makeup_for FROM live_sessions WHERE id = ?", req.MakeupSessionID).
+			Scan(&makeup.ID, &makeup.CourseID, &makeup.TeacherID, &makeup.Status, &makeup.ScheduledAt, &makeupFor)
+	}
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSessionNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+
+	user := currentUser(c)
+	switch {
+	case original.Status != "cancelled" && original.Status != "ended":
+		respondError(c, http.StatusConflict, CodeMakeupNotAllowed)
+		return
+	case makeup.CourseID != original.CourseID || makeup.Status == "cancelled":
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "makeup_session_id")
+		return
+	case user.Role != RoleAdmin && makeup.TeacherID != user.ID:
+		respondError(c, http.StatusForbidden, CodeForbidden)
+		return
+	case originalFor != 0 || makeupFor != 0:
+		// 不支持补课的补课，避免形成链
+		respondError(c, http.StatusConflict, CodeMakeupExists)
+		return
+	}
+	existing, err := makeupSessionOf(id)
+	if err == nil && existing == 0 {
+		existing, err = makeupSessionOf(makeup.ID)
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+	if existing != 0 {
+		respondError(c, http.StatusConflict, CodeMakeupExists)
+		return
+	}
+
+	res, err := db.Exec("UPDATE live_sessions SET makeup_for = ? WHERE id = ? AND makeup_for = 0", id, makeup.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeMakeupLinkFailed)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusConflict, CodeMakeupExists)
+		return
+	}
+
+	data := gin.H{
+		"session_id":          id,
+		"course_id":           original.CourseID,
+		"scheduled_at":        original.ScheduledAt,
+		"makeup_session_id":   makeup.ID,
+		"makeup_scheduled_at": makeup.ScheduledAt,
+	}
+	students, err := enrolledStudents(original.CourseID)
+	if err == nil {
+		err = notifyUsers(students, NotifySessionMakeup, data)
+	}
+	if err != nil {
+		log.Printf("Failed to notify students of makeup for session %d: %v", id, err)
+	}
+	recordAudit(c, "link_makeup_session", "session", id, gin.H{"makeup_session_id": makeup.ID})
+	respondOK(c, http.StatusOK, data)
+}
+
+// 取消补课关联，已在补课会话中的出勤不再计入原会话
+func unlinkMakeupSession(c *gin.Context) {
+	id, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	makeupID, err := makeupSessionOf(id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+	if makeupID == 0 {
+		respondError(c, http.StatusNotFound, CodeMakeupNotFound)
+		return
+	}
+	if _, err := db.Exec("UPDATE live_sessions SET makeup_for = 0 WHERE id = ? AND makeup_for = ?", makeupID, id); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeMakeupLinkFailed)
+		return
+	}
+	recordAudit(c, "unlink_makeup_session", "session", id, gin.H{"makeup_session_id": makeupID})
+	respondOK(c, http.StatusOK, gin.H{"session_id": id, "makeup_session_id": makeupID})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/Dong557799/zhibo-class/apperr"
+)
+
+// 会话状态变更，set 为 SET 子句，只能使用代码中的常量
+type sessionTransition struct {
+	set  string
+	from []string
+	// 当前状态不允许变更时的错误码
+	code ErrorCode
+}
+
+var (
+	transitionStart = sessionTransition{
+		set:  "status = 'live', start_time = NOW()",
+		from: []string{"pending"},
+		code: CodeSessionAlreadyStarted,
+	}
+	transitionEnd = sessionTransition{
+		set:  "status = 'ended', end_time = NOW()",
+		from: []string{"live", "interrupted"},
+		code: CodeSessionAlreadyEnded,
+	}
+	// 管理员可以结束尚未开始的会话
+	transitionForceEnd = sessionTransition{
+		set:  "status = 'ended', end_time = NOW()",
+		from: []string{"pending", "live", "interrupted"},
+		code: CodeSessionAlreadyEnded,
+	}
+)
+
+// 在会话锁内变更会话状态。会话不存在时返回 ErrNotFound，
+// 当前状态不允许变更时返回 ErrInvalidState，其他副本正在变更时返回 ErrConflict
+func transitionSession(sessionID int, t sessionTransition) error {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(t.from)), ", ")
+	args := []interface{}{sessionID}
+	for _, status := range t.from {
+		args = append(args, status)
+	}
+
+	err := withLock(sessionLockName(sessionID), func() error {
+		result, err := db.Exec("UPDATE live_sessions SET "+t.set+" WHERE id = ? AND status IN ("+placeholders+")", args...)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil || n > 0 {
+			return err
+		}
+
+		var status string
+		err = db.QueryRow("SELECT status FROM live_sessions WHERE id = ?", sessionID).Scan(&status)
+		if err == sql.ErrNoRows {
+			return domainError(apperr.ErrNotFound, CodeSessionNotFound)
+		}
+		if err != nil {
+			return err
+		}
+		return domainError(apperr.ErrInvalidState, t.code)
+	})
+	if errors.Is(err, errLockTimeout) {
+		return apperr.Wrap(apperr.ErrConflict, string(CodeSessionBusy), err)
+	}
+	return err
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 直播会话设置
+type SessionSettings struct {
+	ChatEnabled       bool     `json:"chat_enabled"`
+	DanmakuEnabled    bool     `json:"danmaku_enabled"`
+	RaiseHand         string   `json:"raise_hand"` // 谁可以举手：all 所有人，none 禁止
+	RecordingEnabled  bool     `json:"recording_enabled"`
+	PlaybackProtocols []string `json:"playback_protocols"`
+	StreamProfile     string   `json:"stream_profile"` // 推流档位，见 streamProfiles
+
+	// 播放器动态水印，文本模板见 defaultWatermarkText
+	WatermarkEnabled bool   `json:"watermark_enabled"`
+	WatermarkText    string `json:"watermark_text"`
+	// 录像处理时烧录取证水印，需要重新编码
+	ForensicWatermark bool `json:"forensic_watermark"`
+
+	// 转码生成纯音频流，学生可选择省流量模式；student_audio_only 时学生只能收听音频
+	AudioVariant     bool `json:"audio_variant"`
+	StudentAudioOnly bool `json:"student_audio_only"`
+
+	// 聊天控制，老师和管理员不受限制：慢速模式下每个学生每 chat_slow_mode 秒最多发一条，
+	// 0 表示关闭；members_only 时只有已报名课程的学生可以发言；emoji_only 时只能发送表情
+	ChatSlowMode    int  `json:"chat_slow_mode"`
+	ChatMembersOnly bool `json:"chat_members_only"`
+	ChatEmojiOnly   bool `json:"chat_emoji_only"`
+
+	// 同时在线的学生上限，0 表示不限制；已满时新进入的学生排入候补队列，有空位时按顺序自动进入
+	MaxParticipants int `json:"max_participants"`
+}
+
+var (
+	playbackProtocols = []string{"rtmp", "flv", "hls"}
+	raiseHandModes    = []string{"all", "none"}
+
+	settingsMu    sync.RWMutex
+	settingsCache = make(map[int]SessionSettings)
+)
+
+func defaultSessionSettings() SessionSettings {
+	return SessionSettings{
+		ChatEnabled:       true,
+		DanmakuEnabled:    true,
+		RaiseHand:         "all",
+		RecordingEnabled:  false,
+		PlaybackProtocols: append([]string(nil), playbackProtocols...),
+		StreamProfile:     defaultStreamProfile,
+	}
+}
+
+// 获取会话设置，未设置过的会话使用默认值
+func loadSessionSettings(sessionID int) (SessionSettings, error) {
+	settingsMu.RLock()
+	settings, ok := settingsCache[sessionID]
+	settingsMu.RUnlock()
+	if ok {
+		return settings, nil
+	}
+
+	settings = defaultSessionSettings()
+	var protocols string
+	err := db.QueryRow(`
+		SELECT chat_enabled, danmaku_enabled, raise_hand, recording_enabled, playback_protocols, stream_profile,
+			watermark_enabled, watermark_text, forensic_watermark, audio_variant, student_audio_only,
+			chat_slow_mode, chat_members_only, chat_emoji_only, max_participants
+		FROM session_settings
+		WHERE session_id = ?
+	`, sessionID).Scan(
+		&settings.ChatEnabled,
+		&settings.DanmakuEnabled,
+		&settings.RaiseHand,
+		&settings.RecordingEnabled,
+		&protocols,
+		&settings.StreamProfile,
+		&settings.WatermarkEnabled,
+		&settings.WatermarkText,
+		&settings.ForensicWatermark,
+		&settings.AudioVariant,
+		&settings.StudentAudioOnly,
+		&settings.ChatSlowMode,
+		&settings.ChatMembersOnly,
+		&settings.ChatEmojiOnly,
+		&settings.MaxParticipants,
+	)
+	if err != nil && err != sql.ErrNoRows {
+		return settings, err
+	}
+	if err == nil {
+		settings.PlaybackProtocols = splitList(protocols)
+	}
+
+	settingsMu.Lock()
+	settingsCache[sessionID] = settings
+	settingsMu.Unlock()
+	return settings, nil
+}
+
+// 获取会话设置
+func getSessionSettings(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	if !sessionExists(c, sessionID) {
+		return
+	}
+
+	settings, err := loadSessionSettings(sessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSettingsGetFailed)
+		return
+	}
+
+	respondOK(c, http.StatusOK, settings)
+}
+
+// 修改会话设置，只更新请求中出现的字段，并通知在线客户端
+func updateSessionSettings(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var req struct {
+		ChatEnabled       *bool    `json:"chat_enabled"`
+		DanmakuEnabled    *bool    `json:"danmaku_enabled"`
+		RaiseHand         *string  `json:"raise_hand"`
+		RecordingEnabled  *bool    `json:"recording_enabled"`
+		PlaybackProtocols []string `json:"playback_protocols"`
+		StreamProfile     *string  `json:"stream_profile"`
+		WatermarkEnabled  *bool    `json:"watermark_enabled"`
+		WatermarkText     *string  `json:"watermark_text" binding:"omitempty,max=128"`
+		ForensicWatermark *bool    `json:"forensic_watermark"`
+		AudioVariant      *bool    `json:"audio_variant"`
+		StudentAudioOnly  *bool    `json:"student_audio_only"`
+		ChatSlowMode      *int     `json:"chat_slow_mode" binding:"omitempty,min=0,max=600"`
+		ChatMembersOnly   *bool    `json:"chat_members_only"`
+		ChatEmojiOnly     *bool    `json:"chat_emoji_only"`
+		MaxParticipants   *int     `json:"max_participants" binding:"omitempty,min=0,max=100000"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	if req.RaiseHand != nil && !contains(raiseHandModes, *req.RaiseHand) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRaiseHand, strings.Join(raiseHandModes, ", "))
+		return
+	}
+	if req.StreamProfile != nil {
+		if _, ok := streamProfiles[*req.StreamProfile]; !ok {
+			respondError(c, http.StatusBadRequest, CodeInvalidStreamProfile, strings.Join(streamProfileNames, ", "))
+			return
+		}
+	}
+	if req.PlaybackProtocols != nil {
+		if len(req.PlaybackProtocols) == 0 {
+			respondError(c, http.StatusBadRequest, CodePlaybackProtocolRequired)
+			return
+		}
+		for _, p := range req.PlaybackProtocols {
+			if !contains(playbackProtocols, p) {
+				respondError(c, http.StatusBadRequest, CodePlaybackProtocolUnsupported, p)
+				return
+			}
+		}
+	}
+
+	if !sessionExists(c, sessionID) {
+		return
+	}
+
+	settings, err := loadSessionSettings(sessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSettingsGetFailed)
+		return
+	}
+
+	if req.ChatEnabled != nil {
+		settings.ChatEnabled = *req.ChatEnabled
+	}
+	if req.DanmakuEnabled != nil {
+		settings.DanmakuEnabled = *req.DanmakuEnabled
+	}
+	if req.RaiseHand != nil {
+		settings.RaiseHand = *req.RaiseHand
+	}
+	if req.RecordingEnabled != nil {
+		settings.RecordingEnabled = *req.RecordingEnabled
+	}
+	if req.PlaybackProtocols != nil {
+		settings.PlaybackProtocols = req.PlaybackProtocols
+	}
+	if req.StreamProfile != nil {
+		settings.StreamProfile = *req.StreamProfile
+	}
+	if req.WatermarkEnabled != nil {
+		settings.WatermarkEnabled = *req.WatermarkEnabled
+	}
+	if req.WatermarkText != nil {
+		settings.WatermarkText = strings.TrimSpace(*req.WatermarkText)
+	}
+	if req.ForensicWatermark != nil {
+		settings.ForensicWatermark = *req.ForensicWatermark
+	}
+	if req.AudioVariant != nil {
+		settings.AudioVariant = *req.AudioVariant
+	}
+	if req.StudentAudioOnly != nil {
+		settings.StudentAudioOnly = *req.StudentAudioOnly
+	}
+	if req.ChatSlowMode != nil {
+		settings.ChatSlowMode = *req.ChatSlowMode
+	}
+	if req.ChatMembersOnly != nil {
+		settings.ChatMembersOnly = *req.ChatMembersOnly
+	}
+	if req.ChatEmojiOnly != nil {
+		settings.ChatEmojiOnly = *req.ChatEmojiOnly
+	}
+	if req.MaxParticipants != nil {
+		settings.MaxParticipants = *req.MaxParticipants
+	}
+
+	_, err = db.Exec(dialect.upsert(`
+		INSERT INTO session_settings
+			(session_id, chat_enabled, danmaku_enabled, raise_hand, recording_enabled, playback_protocols, stream_profile,
+			watermark_enabled, watermark_text, forensic_watermark, audio_variant, student_audio_only,
+			chat_slow_mode, chat_members_only, chat_emoji_only, max_participants)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		[]string{"session_id"},
+		"chat_enabled = EXCLUDED.chat_enabled",
+		"danmaku_enabled = EXCLUDED.danmaku_enabled",
+		"raise_hand = EXCLUDED.raise_hand",
+		"recording_enabled = EXCLUDED.recording_enabled",
+		"playback_protocols = EXCLUDED.playback_protocols",
+		"stream_profile = EXCLUDED.stream_profile",
+		"watermark_enabled = EXCLUDED.watermark_enabled",
+		"watermark_text = EXCLUDED.watermark_text",
+		"forensic_watermark = EXCLUDED.forensic_watermark",
+		"audio_variant = EXCLUDED.audio_variant",
+		"student_audio_only = EXCLUDED.student_audio_only",
+		"chat_slow_mode = EXCLUDED.chat_slow_mode",
+		"chat_members_only = EXCLUDED.chat_members_only",
+		"chat_emoji_only = EXCLUDED.chat_emoji_only",
+		"max_participants = EXCLUDED.max_participants",
+	), sessionID, settings.ChatEnabled, settings.DanmakuEnabled, settings.RaiseHand,
+		settings.RecordingEnabled, strings.Join(settings.PlaybackProtocols, ","), settings.StreamProfile,
+		settings.WatermarkEnabled, settings.WatermarkText, settings.ForensicWatermark,
+		settings.AudioVariant, settings.StudentAudioOnly,
+		settings.ChatSlowMode, settings.ChatMembersOnly, settings.ChatEmojiOnly, settings.MaxParticipants)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSettingsUpdateFailed)
+		return
+	}
+
+	settingsMu.Lock()
+	settingsCache[sessionID] = settings
+	settingsMu.Unlock()
+
+	hub.broadcast(sessionRoom(sessionID), Message{Type: "settings_updated", Data: settings})
+	wakeWaitlist() // 上限提高或取消后立即放行候补的学生
+	respondOK(c, http.StatusOK, settings)
+}
+
+// 按会话设置过滤播放地址
+func filterPlayURLs(urls map[string]string, settings SessionSettings) map[string]string {
+	filtered := make(map[string]string, len(urls))
+	for protocol, url := range urls {
+		if contains(settings.PlaybackProtocols, protocol) {
+			filtered[protocol] = url
+		}
+	}
+	return filtered
+}
+
+// 检查会话是否存在，否则写入错误响应
+func sessionExists(c *gin.Context, sessionID int) bool {
+	var id int
+	err := db.QueryRow("SELECT id FROM live_sessions WHERE id = ?", sessionID).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, CodeSessionNotFound)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		}
+		return false
+	}
+	return true
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
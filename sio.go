@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Engine.IO v4 / Socket.IO v5 协议的最小实现，只支持 websocket 传输，
+// 前端需配置 transports: ['websocket']，会话信息通过 query 传入：
+// /socket.io/?EIO=4&transport=websocket&session_id=1&ws_token=<连接令牌>
+
+// Engine.IO 包类型
+const (
+	eioOpen    = '0'
+	eioClose   = '1'
+	eioPing    = '2'
+	eioPong    = '3'
+	eioMessage = '4'
+)
+
+// Socket.IO 包类型
+const (
+	sioConnect    = '0'
+	sioDisconnect = '1'
+	sioEvent      = '2'
+	sioAck        = '3'
+)
+
+const sioPingTimeout = 20 * time.Second
+
+// 建立 Socket.IO 连接，房间与原生 WebSocket 连接一致
+func serveSocketIO(c *gin.Context) {
+	if c.Query("EIO") != "4" || c.Query("transport") != "websocket" {
+		respondError(c, http.StatusBadRequest, CodeSocketIOUnsupported)
+		return
+	}
+
+	client, ok := acceptClient(c, wsHandlers)
+	if !ok {
+		return
+	}
+	client.frame = sioFrame
+	client.ping = []byte{eioPing}
+
+	sid := generateRandomString(20)
+	open, _ := json.Marshal(gin.H{
+		"sid":          sid,
+		"upgrades":     []string{},
+		"pingInterval": pingPeriod.Milliseconds(),
+		"pingTimeout":  sioPingTimeout.Milliseconds(),
+		"maxPayload":   maxMessageSize,
+	})
+	client.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := client.conn.WriteMessage(websocket.TextMessage, append([]byte{eioOpen}, open...)); err != nil {
+		client.conn.Close()
+		return
+	}
+
+	client.sioReadPump(sid)
+}
+
+// 将广播消息封装为 Socket.IO 事件，事件名为消息类型；
+// 非 JSON 对象的数据视为已封包的协议数据直接发送
+func sioFrame(payload []byte) []byte {
+	if len(payload) == 0 || payload[0] != '{' {
+		return payload
+	}
+
+	var head struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(payload, &head)
+	event, _ := json.Marshal(head.Type)
+
+	var buf bytes.Buffer
+	buf.Grow(len(payload) + len(event) + 5)
+	buf.WriteByte(eioMessage)
+	buf.WriteByte(sioEvent)
+	buf.WriteByte('[')
+	buf.Write(event)
+	buf.WriteByte(',')
+	buf.Write(payload)
+	buf.WriteByte(']')
+	return buf.Bytes()
+}
+
+func (c *Client) sioReadPump(sid string) {
+	defer func() {
+		hub.unregister(c)
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+
+	connected := false
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("Socket.IO read error for user %d: %v", c.userID, err)
+			}
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		if len(data) == 0 {
+			continue
+		}
+
+		switch data[0] {
+		case eioPong, eioPing:
+			continue
+		case eioClose:
+			return
+		case eioMessage:
+		default:
+			continue
+		}
+
+		packet := data[1:]
+		if len(packet) == 0 {
+			continue
+		}
+		switch packet[0] {
+		case sioConnect:
+			first := !connected
+			if first {
+				connected = true
+				go c.writePump()
+			}
+			ack, _ := json.Marshal(gin.H{"sid": sid})
+			c.sendRaw(append([]byte{eioMessage, sioConnect}, ack...))
+			if first {
+				c.enterSession()
+			}
+		case sioDisconnect:
+			return
+		case sioEvent:
+			if !connected {
+				continue
+			}
+			c.handleSocketIOEvent(packet[1:])
+		}
+	}
+}
+
+// 处理客户端事件 2[id]["event", data]，带 id 时回复确认
+func (c *Client) handleSocketIOEvent(packet []byte) {
+	i := 0
+	for i < len(packet) && packet[i] >= '0' && packet[i] <= '9' {
+		i++
+	}
+	ackID := string(packet[:i])
+
+	var args []json.RawMessage
+	if err := json.Unmarshal(packet[i:], &args); err != nil || len(args) == 0 {
+		return
+	}
+	var event string
+	if err := json.Unmarshal(args[0], &event); err != nil {
+		return
+	}
+
+	msg := Message{Type: event, From: c.userID, Time: time.Now()}
+	if len(args) > 1 {
+		var data interface{}
+		if err := json.Unmarshal(args[1], &data); err == nil {
+			msg.Data = data
+		}
+	}
+
+	// 与 WebSocket 连接一致，候补中的学生不能发送事件，确认照常回复
+	if handler, ok := c.handlers[event]; ok && !c.isWaiting() {
+		handler(c, msg)
+	}
+
+	if ackID != "" {
+		c.sendRaw([]byte(string([]byte{eioMessage, sioAck}) + ackID + "[]"))
+	}
+}
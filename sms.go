@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	smsCodeDigits          = 6
+	smsMaxAttempts         = 5 // 同一验证码允许输错的次数
+	smsSendTimeout         = 10 * time.Second
+	defaultSMSCodeTTL      = 5 * time.Minute
+	defaultSMSSendInterval = time.Minute
+	defaultSMSDailyLimit   = 10
+	// 发送频率按最近一天的记录计算，更早的验证码由数据保留任务删除
+	smsCodeRetention = 24 * time.Hour
+)
+
+// 手机号，允许带国际区号
+var phonePattern = regexp.MustCompile(`^\+?[0-9]{6,15}$`)
+
+// 短信验证码配置，driver 为空时不开放手机号登录
+type SMSConfig struct {
+	Driver string `json:"driver"` // log（只写日志，用于开发环境）或 http
+	// http 短信网关：POST {"phone", "code", "template", "sign_name", "ttl_minutes"}，返回 2xx 即视为发送成功
+	URL      string `json:"url"`
+	APIKey   string `json:"api_key"`
+	Template string `json:"template"`
+	SignName string `json:"sign_name"`
+
+	CodeSeconds     int `json:"code_seconds"`     // 验证码有效期，为 0 时使用 5 分钟
+	IntervalSeconds int `json:"interval_seconds"` // 同一号码两次发送的最小间隔，为 0 时使用 60 秒
+	DailyLimit      int `json:"daily_limit"`      // 同一号码 24 小时内的发送上限，为 0 时使用 10
+}
+
+// 短信发送后端
+type smsProvider interface {
+	send(ctx context.Context, phone, code string, ttl time.Duration) error
+}
+
+func smsSender() (smsProvider, error) {
+	conf := currentConfig().SMS
+	switch conf.Driver {
+	case "log":
+		return logSMSProvider{}, nil
+	case "http":
+		if conf.URL == "" {
+			return nil, fmt.Errorf("sms url is required")
+		}
+		return httpSMSProvider{conf: conf}, nil
+	case "":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported sms driver %q", conf.Driver)
+	}
+}
+
+type logSMSProvider struct{}
+
+func (logSMSProvider) send(ctx context.Context, phone, code string, ttl time.Duration) error {
+	log.Printf("SMS code for %s: %s (valid for %s)", maskPhone(phone), code, ttl)
+	return nil
+}
+
+type httpSMSProvider struct {
+	conf SMSConfig
+}
+
+func (p httpSMSProvider) send(ctx context.Context, phone, code string, ttl time.Duration) error {
+	body, err := json.Marshal(gin.H{
+		"phone":       phone,
+		"code":        code,
+		"template":    p.conf.Template,
+		"sign_name":   p.conf.SignName,
+		"ttl_minutes": int(ttl.Minutes()),
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.conf.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.conf.APIKey)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("SMS gateway returned status %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
+
+func smsCodeTTL() time.Duration {
+	if s := currentConfig().SMS.CodeSeconds; s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	return defaultSMSCodeTTL
+}
+
+func smsSendInterval() time.Duration {
+	if s := currentConfig().SMS.IntervalSeconds; s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	return defaultSMSSendInterval
+}
+
+func smsDailyLimit() int {
+	if n := currentConfig().SMS.DailyLimit; n > 0 {
+		return n
+	}
+	return defaultSMSDailyLimit
+}
+
+// 去掉空格和连字符
+func normalizePhone(phone string) (string, bool) {
+	phone = strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(phone))
+	return phone, phonePattern.MatchString(phone)
+}
+
+// 日志和默认昵称中隐藏号码中间几位
+func maskPhone(phone string) string {
+	if len(phone) < 7 {
+		return phone
+	}
+	return phone[:3] + "****" + phone[len(phone)-4:]
+}
+
+// 同一号码发送验证码的命名锁
+func smsLockName(phone string) string { return "zhibo:sms:" + phone }
+
+// 数据库中只保存验证码的摘要
+func smsCodeHash(phone, code string) string {
+	mac := hmac.New(sha256.New, []byte(currentConfig().JWTSecret))
+	mac.Write([]byte("sms\n" + phone + "\n" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func randomSMSCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", smsCodeDigits, n.Int64()), nil
+}
+
+// 发送登录验证码；同一号码受发送间隔和每日上限限制
+func sendSMSCode(c *gin.Context) {
+	var req struct {
+		Phone string `json:"phone" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	phone, ok := normalizePhone(req.Phone)
+	if !ok {
+		respondError(c, http.StatusBadRequest, CodeInvalidPhone)
+		return
+	}
+	provider, err := smsSender()
+	if err != nil {
+		log.Printf("Failed to configure SMS provider: %v", err)
+	}
+	if provider == nil {
+		respondError(c, http.StatusNotFound, CodeSMSLoginDisabled)
+		return
+	}
+
+	// 同一号码的频率检查和写入验证码在命名锁内完成，避免并发请求同时通过检查
+	unlock, err := acquireLock(smsLockName(phone), lockWait)
+	if errors.Is(err, errLockTimeout) {
+		respondError(c, http.StatusTooManyRequests, CodeSMSRateLimited)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSMSSendFailed)
+		return
+	}
+	code, ttl, ok := issueSMSCode(c, phone)
+	unlock()
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), smsSendTimeout)
+	defer cancel()
+	if err := provider.send(ctx, phone, code, ttl); err != nil {
+		log.Printf("Failed to send SMS to %s: %v", maskPhone(phone), err)
+		respondError(c, http.StatusBadGateway, CodeSMSSendFailed)
+		return
+	}
+	respondOK(c, http.StatusOK, gin.H{
+		"expires_in":     int(ttl.Seconds()),
+		"retry_interval": int(smsSendInterval().Seconds()),
+	})
+}
+
+// 检查发送频率并写入新的验证码，调用方需持有该号码的命名锁；失败时写入错误响应
+func issueSMSCode(c *gin.Context, phone string) (string, time.Duration, bool) {
+	now := time.Now().UTC()
+	var last sql.NullTime
+	var sent int
+	err := db.QueryRow("SELECT MAX(created_at), COUNT(*) FROM sms_codes WHERE phone = ? AND created_at > ?",
+		phone, now.Add(-smsCodeRetention)).Scan(&last, &sent)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSMSSendFailed)
+		return "", 0, false
+	}
+	if last.Valid && now.Sub(last.Time) < smsSendInterval() {
+		retryAfter := smsSendInterval() - now.Sub(last.Time)
+		c.Header("Retry-After", fmt.Sprint(int(retryAfter.Seconds())+1))
+		respondError(c, http.StatusTooManyRequests, CodeSMSRateLimited)
+		return "", 0, false
+	}
+	if sent >= smsDailyLimit() {
+		respondError(c, http.StatusTooManyRequests, CodeSMSRateLimited)
+		return "", 0, false
+	}
+
+	code, err := randomSMSCode()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return "", 0, false
+	}
+	ttl := smsCodeTTL()
+	_, err = db.Exec(`
+		INSERT INTO sms_codes (phone, code_hash, attempts, expires_at, created_at)
+		VALUES (?, ?, 0, ?, ?)
+	`, phone, smsCodeHash(phone, code), now.Add(ttl), now)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSMSSendFailed)
+		return "", 0, false
+	}
+	return code, ttl, true
+}
+
+// 校验验证码并登录；号码未注册时自动创建学生账号
+func verifySMSCode(c *gin.Context) {
+	var req struct {
+		Phone string `json:"phone" binding:"required"`
+		Code  string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	phone, ok := normalizePhone(req.Phone)
+	if !ok {
+		respondError(c, http.StatusBadRequest, CodeInvalidPhone)
+		return
+	}
+	if currentConfig().SMS.Driver == "" {
+		respondError(c, http.StatusNotFound, CodeSMSLoginDisabled)
+		return
+	}
+
+	// 只校验最近一次发送的验证码
+	var codeID int
+	var codeHash string
+	err := db.QueryRow(`
+		SELECT id, code_hash FROM sms_codes
+		WHERE phone = ? AND used_at IS NULL AND expires_at > ?
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1
+	`, phone, time.Now().UTC()).Scan(&codeID, &codeHash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusUnauthorized, CodeSMSCodeInvalid)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeSMSVerifyFailed)
+		}
+		return
+	}
+	// 比对前先占用一次尝试次数，条件更新保证并发请求合计也不会超过上限
+	result, err := db.Exec("UPDATE sms_codes SET attempts = attempts + 1 WHERE id = ? AND attempts < ?", codeID, smsMaxAttempts)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSMSVerifyFailed)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		respondError(c, http.StatusUnauthorized, CodeSMSCodeInvalid)
+		return
+	}
+	if !hmac.Equal([]byte(codeHash), []byte(smsCodeHash(phone, strings.TrimSpace(req.Code)))) {
+		respondError(c, http.StatusUnauthorized, CodeSMSCodeInvalid)
+		return
+	}
+	// 条件更新保证并发请求中只有一个能用掉验证码
+	result, err = db.Exec("UPDATE sms_codes SET used_at = ? WHERE id = ? AND used_at IS NULL", time.Now().UTC(), codeID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSMSVerifyFailed)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		respondError(c, http.StatusUnauthorized, CodeSMSCodeInvalid)
+		return
+	}
+
+	user, created, err := userByPhone(phone)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSMSVerifyFailed)
+		return
+	}
+	if user.Status != "active" {
+		respondError(c, http.StatusForbidden, CodeUserDisabled)
+		return
+	}
+	if created {
+		recordAudit(c, "sms_signup", "user", user.ID, gin.H{"phone": maskPhone(phone)})
+	}
+
+	tokens, err := issueToken(c, user, 0, accessTokenTTL())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	respondOK(c, http.StatusOK, tokens.response(gin.H{"user": user, "created": created}))
+}
+
+// 按手机号查找用户，不存在时创建学生账号；账号没有可用密码，只能通过验证码登录
+func userByPhone(phone string) (User, bool, error) {
+	var user User
+	query := "SELECT id, username, name, role, status, time_zone, org, created_at FROM users WHERE phone = ?"
+	err := db.QueryRow(query, phone).
+		Scan(&user.ID, &user.Username, &user.Name, &user.Role, &user.Status, &user.TimeZone, &user.Org, &user.CreatedAt)
+	if err != sql.ErrNoRows {
+		return user, false, err
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO users (username, name, role, status, password_hash, phone, created_at)
+		VALUES (?, ?, ?, 'active', ?, ?, NOW())
+	`, "sms_"+strings.TrimPrefix(phone, "+"), maskPhone(phone), RoleStudent, noPasswordHash, phone)
+	if err != nil && !dialect.isDuplicate(err) {
+		return user, false, err
+	}
+	created := err == nil
+	err = db.QueryRow(query, phone).
+		Scan(&user.ID, &user.Username, &user.Name, &user.Role, &user.Status, &user.TimeZone, &user.Org, &user.CreatedAt)
+	return user, created, err
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func postSMSVerify(phone, code string) int {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/auth/sms/verify", strings.NewReader(`{"phone":"`+phone+`","code":"`+code+`"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	verifySMSCode(c)
+	return w.Code
+}
+
+// 并发输错时尝试次数也不能超过上限，用完后正确的验证码同样失效
+func TestVerifySMSCodeAttemptLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	useTestConfig(t, &Config{JWTSecret: "test", SMS: SMSConfig{Driver: "log"}})
+	openTestDB(t)
+	const phone = "13800000000"
+	now := time.Now().UTC()
+	mustExec(t, "INSERT INTO sms_codes (phone, code_hash, attempts, expires_at, created_at) VALUES (?, ?, 0, ?, ?)",
+		phone, smsCodeHash(phone, "123456"), now.Add(time.Minute), now)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3*smsMaxAttempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if status := postSMSVerify(phone, "000000"); status != http.StatusUnauthorized {
+				t.Errorf("wrong code status = %d, want 401", status)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var attempts int
+	if err := db.QueryRow("SELECT attempts FROM sms_codes WHERE phone = ?", phone).Scan(&attempts); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != smsMaxAttempts {
+		t.Fatalf("attempts = %d, want %d", attempts, smsMaxAttempts)
+	}
+	if status := postSMSVerify(phone, "123456"); status != http.StatusUnauthorized {
+		t.Fatalf("correct code after limit status = %d, want 401", status)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	snapshotChatLimit       = 50
+	maxSessionAnnouncements = 20
+)
+
+// 课件当前页，老师翻页时更新
+type SessionSlide struct {
+	Document  string    `json:"document"` // 课件名称或地址
+	Page      int       `json:"page"`
+	UpdatedBy int       `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// 课堂公告，过期或被撤下后不再下发
+type Announcement struct {
+	ID        int        `json:"id"`
+	SessionID int        `json:"session_id"`
+	Content   string     `json:"content"`
+	CreatedBy int        `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// 中途进入课堂时的状态快照，包含作答中的题目、最近的聊天、课件当前页、有效的公告和正在计时的计时器
+type SessionSnapshot struct {
+	SessionID     int            `json:"session_id"`
+	Status        string         `json:"status"`
+	Question      gin.H          `json:"question,omitempty"`
+	Chat          []ChatMessage  `json:"chat"`
+	Slide         *SessionSlide  `json:"slide,omitempty"`
+	Announcements []Announcement `json:"announcements"`
+	Timers        []SessionTimer `json:"timers"`
+	GeneratedAt   time.Time      `json:"generated_at"`
+}
+
+// 汇总会话的当前状态，chatRoom 为用户所在的聊天房间，分组讨论时为分组房间
+func buildSessionSnapshot(ctx context.Context, sessionID, courseID, userID int, role, chatRoom string) (SessionSnapshot, error) {
+	now := time.Now().UTC()
+	snap := SessionSnapshot{SessionID: sessionID, GeneratedAt: now}
+	if err := db.QueryRowContext(ctx, "SELECT status FROM live_sessions WHERE id = ?", sessionID).Scan(&snap.Status); err != nil {
+		return snap, err
+	}
+
+	push, open, err := openQuestionPush(courseID, now)
+	if err != nil {
+		return snap, err
+	}
+	if open {
+		q, err := loadQuestion(push.QuestionID)
+		if err != nil {
+			return snap, err
+		}
+		snap.Question = pushedQuestion(q, push)
+		if push.ClosesAt != nil {
+			snap.Question["remaining_seconds"] = int((push.ClosesAt.Sub(now) + time.Second - 1) / time.Second)
+		}
+		if role == RoleStudent {
+			var n int
+			err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM answers WHERE push_id = ? AND student_id = ?", push.ID, userID).Scan(&n)
+			if err != nil {
+				return snap, err
+			}
+			snap.Question["answered"] = n > 0
+		}
+	}
+
+	if snap.Chat, err = recentChat(ctx, sessionID, chatRoom, snapshotChatLimit); err != nil {
+		return snap, err
+	}
+	if snap.Slide, err = loadSessionSlide(ctx, sessionID); err != nil {
+		return snap, err
+	}
+	if snap.Announcements, err = activeAnnouncements(ctx, sessionID, now); err != nil {
+		return snap, err
+	}
+	if snap.Timers, err = runningTimers(ctx, sessionID); err != nil {
+		return snap, err
+	}
+	for i := range snap.Timers {
+		snap.Timers[i].sync(now)
+	}
+	return snap, nil
+}
+
+// 聊天房间最近的消息，按时间先后排列
+func recentChat(ctx context.Context, sessionID int, room string, limit int) ([]ChatMessage, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, session_id, room, user_id, content, created_at
+		FROM chat_messages
+		WHERE session_id = ? AND room = ?
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, sessionID, room, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []ChatMessage{}
+	for rows.Next() {
+		var m ChatMessage
+		var content string
+		if err := rows.Scan(&m.ID, &m.SessionID, &m.Room, &m.UserID, &content, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		m.Content = json.RawMessage(content)
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+func loadSessionSlide(ctx context.Context, sessionID int) (*SessionSlide, error) {
+	var s SessionSlide
+	err := db.QueryRowContext(ctx, "SELECT document, page, updated_by, updated_at FROM session_slides WHERE session_id = ?", sessionID).
+		Scan(&s.Document, &s.Page, &s.UpdatedBy, &s.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func activeAnnouncements(ctx context.Context, sessionID int, now time.Time) ([]Announcement, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, session_id, content, created_by, created_at, expires_at
+		FROM session_announcements
+		WHERE session_id = ? AND dismissed_at IS NULL AND (expires_at IS NULL OR expires_at > ?)
+		ORDER BY id
+	`, sessionID, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	announcements := []Announcement{}
+	for rows.Next() {
+		var a Announcement
+		if err := rows.Scan(&a.ID, &a.SessionID, &a.Content, &a.CreatedBy, &a.CreatedAt, &a.ExpiresAt); err != nil {
+			return nil, err
+		}
+		announcements = append(announcements, a)
+	}
+	return announcements, rows.Err()
+}
+
+// 进入课堂后通过 WebSocket 下发快照
+func (c *Client) sendSnapshot() {
+	c.mu.Lock()
+	chatRoom := c.chatRoom
+	c.mu.Unlock()
+	snap, err := buildSessionSnapshot(context.Background(), c.sessionID, c.courseID, c.userID, c.role, chatRoom)
+	if err != nil {
+		log.Printf("Failed to build snapshot of session %d for user %d: %v", c.sessionID, c.userID, err)
+		return
+	}
+	c.sendMessage(Message{Type: "snapshot", Data: snap})
+}
+
+// 获取会话的状态快照，用于断线重连或不使用 WebSocket 的客户端
+func getSessionSnapshot(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	user := currentUser(c)
+	var courseID int
+	err := db.QueryRow("SELECT course_id FROM live_sessions WHERE id = ?", sessionID).Scan(&courseID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, CodeSessionNotFound)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		}
+		return
+	}
+	if user.Role == RoleStudent && !requireCourseAccess(c, courseID, sessionID, user.ID) {
+		return
+	}
+
+	chatRoom := sessionRoom(sessionID)
+	if user.Role == RoleStudent {
+		if groupID, ok := currentBreakoutGroup(sessionID, user.ID); ok {
+			chatRoom = breakoutRoom(groupID)
+		}
+	}
+	snap, err := buildSessionSnapshot(c.Request.Context(), sessionID, courseID, user.ID, user.Role, chatRoom)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSnapshotGetFailed)
+		return
+	}
+	respondOK(c, http.StatusOK, snap)
+}
+
+// 老师翻页，通知会话内的客户端
+func updateSessionSlide(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Document string `json:"document" binding:"required,max=255"`
+		Page     int    `json:"page" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if !sessionExists(c, sessionID) {
+		return
+	}
+
+	slide := SessionSlide{
+		Document:  strings.TrimSpace(req.Document),
+		Page:      req.Page,
+		UpdatedBy: currentUser(c).ID,
+		UpdatedAt: time.Now().UTC(),
+	}
+	_, err := db.Exec(dialect.upsert(`
+		INSERT INTO session_slides (session_id, document, page, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		[]string{"session_id"},
+		"document = EXCLUDED.document", "page = EXCLUDED.page",
+		"updated_by = EXCLUDED.updated_by", "updated_at = EXCLUDED.updated_at",
+	), sessionID, slide.Document, slide.Page, slide.UpdatedBy, slide.UpdatedAt)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSlideUpdateFailed)
+		return
+	}
+
+	hub.broadcast(sessionRoom(sessionID), Message{Type: "slide_changed", Data: slide})
+	respondOK(c, http.StatusOK, slide)
+}
+
+// 发布课堂公告，duration_seconds 为 0 时一直有效直到撤下
+func createAnnouncement(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Content         string `json:"content" binding:"required,max=1000"`
+		DurationSeconds int    `json:"duration_seconds" binding:"min=0,max=86400"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if !sessionExists(c, sessionID) {
+		return
+	}
+
+	now := time.Now().UTC()
+	active, err := activeAnnouncements(c.Request.Context(), sessionID, now)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeAnnouncementCreateFailed)
+		return
+	}
+	if len(active) >= maxSessionAnnouncements {
+		respondError(c, http.StatusConflict, CodeAnnouncementLimit, maxSessionAnnouncements)
+		return
+	}
+
+	a := Announcement{SessionID: sessionID, Content: strings.TrimSpace(req.Content), CreatedBy: currentUser(c).ID, CreatedAt: now}
+	if req.DurationSeconds > 0 {
+		expiresAt := now.Add(time.Duration(req.DurationSeconds) * time.Second)
+		a.ExpiresAt = &expiresAt
+	}
+	id, err := dialect.insertID(db, `
+		INSERT INTO session_announcements (session_id, content, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, a.SessionID, a.Content, a.CreatedBy, a.CreatedAt, a.ExpiresAt)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeAnnouncementCreateFailed)
+		return
+	}
+	a.ID = int(id)
+
+	hub.broadcast(sessionRoom(sessionID), Message{Type: "announcement", Data: a})
+	respondOK(c, http.StatusCreated, a)
+}
+
+// 会话中有效的公告
+func listAnnouncements(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	if !sessionExists(c, sessionID) {
+		return
+	}
+	announcements, err := activeAnnouncements(c.Request.Context(), sessionID, time.Now().UTC())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeAnnouncementGetFailed)
+		return
+	}
+	respondOK(c, http.StatusOK, announcements)
+}
+
+// 撤下公告
+func dismissAnnouncement(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	announcementID, ok := intParam(c, "announcement_id")
+	if !ok {
+		return
+	}
+	res, err := db.Exec(`
+		UPDATE session_announcements SET dismissed_at = ?
+		WHERE id = ? AND session_id = ? AND dismissed_at IS NULL
+	`, time.Now().UTC(), announcementID, sessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeAnnouncementCreateFailed)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, CodeAnnouncementNotFound)
+		return
+	}
+
+	data := gin.H{"id": announcementID, "session_id": sessionID}
+	hub.broadcast(sessionRoom(sessionID), Message{Type: "announcement_dismissed", Data: data})
+	respondOK(c, http.StatusOK, data)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// 注册的 SQLite 驱动名。在 modernc.org/sqlite 之上去掉 SQLite 不支持的
+// FOR UPDATE（事务以 IMMEDIATE 方式开启，本身就是串行的），
+// 并补上业务 SQL 用到的 NOW()、GREATEST()
+const sqliteCompatDriver = "sqlite-compat"
+
+// 与 modernc 的 _time_format=sqlite 写入格式一致，字符串比较即时间比较
+const sqliteTimeFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+var forUpdatePattern = regexp.MustCompile(`\s+FOR UPDATE( SKIP LOCKED)?`)
+
+func init() {
+	sqlite.MustRegisterScalarFunction("NOW", 0, func(*sqlite.FunctionContext, []driver.Value) (driver.Value, error) {
+		return time.Now().UTC().Format(sqliteTimeFormat), nil
+	})
+	sqlite.MustRegisterDeterministicScalarFunction("GREATEST", -1, sqliteGreatest)
+
+	// sql.Open 不会建立连接，只用来取得 modernc 注册的驱动实例
+	base, err := sql.Open("sqlite", "")
+	if err != nil {
+		panic(err)
+	}
+	sql.Register(sqliteCompatDriver, sqliteDriver{base.Driver()})
+}
+
+func sqliteGreatest(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	var greatest driver.Value
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case nil:
+			return nil, nil
+		case int64:
+			if g, ok := greatest.(int64); !ok || v > g {
+				greatest = v
+			}
+		default:
+			return nil, fmt.Errorf("GREATEST: unsupported argument %T", arg)
+		}
+	}
+	return greatest, nil
+}
+
+type sqliteDriver struct {
+	driver.Driver
+}
+
+func (d sqliteDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteConn{conn}, nil
+}
+
+type sqliteConn struct {
+	driver.Conn
+}
+
+func (c *sqliteConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(forUpdatePattern.ReplaceAllString(query, ""))
+}
+
+func (c *sqliteConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, forUpdatePattern.ReplaceAllString(query, ""))
+	}
+	return c.Prepare(query)
+}
+
+func (c *sqliteConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := c.Conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *sqliteConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	rows, err := q.QueryContext(ctx, forUpdatePattern.ReplaceAllString(query, ""), args)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteRows{Rows: rows}, nil
+}
+
+func (c *sqliteConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *sqliteConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+// modernc 只按列的声明类型把文本解析为时间，MIN(submitted_at) 这类表达式
+// 没有声明类型，这里补上解析
+type sqliteRows struct {
+	driver.Rows
+	types []string
+}
+
+func (r *sqliteRows) Next(dest []driver.Value) error {
+	if err := r.Rows.Next(dest); err != nil {
+		return err
+	}
+	if r.types == nil {
+		r.types = make([]string, len(dest))
+		if t, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+			for i := range dest {
+				r.types[i] = t.ColumnTypeDatabaseTypeName(i)
+			}
+		}
+	}
+	for i, v := range dest {
+		s, ok := v.(string)
+		if !ok || r.types[i] != "" {
+			continue
+		}
+		if t, err := time.Parse(sqliteTimeFormat, s); err == nil {
+			dest[i] = t
+		}
+	}
+	return nil
+}
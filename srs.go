@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SRS：按需创建流，推流鉴权和状态通过 http_hooks 的 on_publish、on_unpublish 回调完成，
+// 断开推流通过 HTTP API 踢掉推流客户端
+type srsServer struct {
+	conf MediaServerConfig
+}
+
+type srsStream struct {
+	Name    string `json:"name"`
+	App     string `json:"app"`
+	Clients int    `json:"clients"` // 包含推流端
+	Kbps    struct {
+		Recv30s int `json:"recv_30s"`
+	} `json:"kbps"`
+	Publish struct {
+		Active bool   `json:"active"`
+		CID    string `json:"cid"`
+	} `json:"publish"`
+	Video *struct {
+		Codec string `json:"codec"`
+	} `json:"video"`
+	Audio *struct {
+		Codec string `json:"codec"`
+	} `json:"audio"`
+}
+
+func (s srsServer) apiURL() string {
+	return strings.TrimSuffix(s.conf.APIURL, "/")
+}
+
+func (s srsServer) streams(ctx context.Context) ([]srsStream, error) {
+	var resp struct {
+		Code    int         `json:"code"`
+		Streams []srsStream `json:"streams"`
+	}
+	// 默认只返回前 10 个流
+	if err := mediaRequest(ctx, http.MethodGet, s.apiURL()+"/api/v1/streams/?count=1000", &resp); err != nil {
+		return nil, err
+	}
+	if resp.Code != 0 {
+		return nil, fmt.Errorf("srs streams api returned code %d", resp.Code)
+	}
+	return resp.Streams, nil
+}
+
+// 推流码在推流时由回调校验，无需登记
+func (s srsServer) CreateStream(ctx context.Context, streamKey string) error {
+	return nil
+}
+
+// 踢掉正在推流的客户端，之后的重连由回调按会话状态拒绝
+func (s srsServer) DeleteStream(ctx context.Context, streamKey string) error {
+	streams, err := s.streams(ctx)
+	if err != nil {
+		return err
+	}
+	for _, st := range streams {
+		if st.App != mediaApp || st.Name != streamKey || !st.Publish.Active || st.Publish.CID == "" {
+			continue
+		}
+		return mediaRequest(ctx, http.MethodDelete, s.apiURL()+"/api/v1/clients/"+st.Publish.CID, nil)
+	}
+	return nil
+}
+
+func (s srsServer) GetStats(ctx context.Context) ([]StreamStats, error) {
+	streams, err := s.streams(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats := []StreamStats{}
+	for _, st := range streams {
+		if st.App != mediaApp || !st.Publish.Active {
+			continue
+		}
+		stat := StreamStats{StreamKey: st.Name, BitrateKbps: st.Kbps.Recv30s}
+		if st.Clients > 0 {
+			stat.Clients = st.Clients - 1
+		}
+		if st.Video != nil {
+			stat.VideoCodec = st.Video.Codec
+		}
+		if st.Audio != nil {
+			stat.AudioCodec = st.Audio.Codec
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+func (s srsServer) ParseCallback(c *gin.Context) (StreamEvent, error) {
+	var callback struct {
+		Action string `json:"action"`
+		App    string `json:"app"`
+		Stream string `json:"stream"`
+		Param  string `json:"param"` // 推流地址中的查询参数，如 ?expires=...&sig=...
+	}
+	if err := c.ShouldBindJSON(&callback); err != nil {
+		return StreamEvent{}, err
+	}
+	if callback.App != mediaApp || callback.Stream == "" {
+		return StreamEvent{}, errInvalidStreamPath
+	}
+	event := StreamEvent{StreamKey: callback.Stream, Query: strings.TrimPrefix(callback.Param, "?")}
+	switch callback.Action {
+	case "on_publish":
+		event.Status = "start"
+	case "on_unpublish":
+		event.Status = "stop"
+	}
+	return event, nil
+}
+
+// SRS 只接受 HTTP 200 且 code 为 0 的响应
+func (s srsServer) RespondCallback(c *gin.Context, status int, code ErrorCode) {
+	if code == "" {
+		c.JSON(http.StatusOK, gin.H{"code": 0})
+		return
+	}
+	respondError(c, status, code)
+}
+
+func (s srsServer) RTMPURL() string {
+	if s.conf.RTMPURL != "" {
+		return strings.TrimSuffix(s.conf.RTMPURL, "/")
+	}
+	return fmt.Sprintf("rtmp://%s:%s/%s", mediaHost(s.conf.APIURL), defaultRTMPPort, mediaApp)
+}
+
+func (s srsServer) PlayURLs(streamKey string) map[string]string {
+	play := strings.TrimSuffix(s.conf.PlayURL, "/")
+	if play == "" {
+		play = fmt.Sprintf("http://%s:8080/%s", mediaHost(s.conf.APIURL), mediaApp)
+	}
+	return map[string]string{
+		"rtmp": s.RTMPURL() + "/" + streamKey,
+		"flv":  play + "/" + streamKey + ".flv",
+		"hls":  play + "/" + streamKey + ".m3u8",
+	}
+}
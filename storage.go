@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	defaultStorageDir     = "storage"
+	defaultPresignExpires = time.Hour
+	localFileURLPrefix    = "/files"
+)
+
+// 对象存储配置。driver 为 local（默认）、s3、oss 或 cos，
+// 云存储均通过 S3 兼容接口访问，endpoint 为空时按 region 推导
+type StorageConfig struct {
+	Driver         string `json:"driver"`
+	Dir            string `json:"dir"` // local 存储目录
+	Endpoint       string `json:"endpoint"`
+	Region         string `json:"region"`
+	Bucket         string `json:"bucket"`
+	AccessKey      string `json:"access_key"`
+	SecretKey      string `json:"secret_key"`
+	Insecure       bool   `json:"insecure"`        // 自建 S3 兼容服务不使用 HTTPS 时开启
+	PresignSeconds int    `json:"presign_seconds"` // 下载地址有效期，为 0 时使用 1 小时
+	// 录像转入低频存储时使用的存储类型，为空时 s3、cos 使用 STANDARD_IA，oss 使用 IA
+	ColdStorageClass string `json:"cold_storage_class"`
+}
+
+// 录像、课件、头像和导出文件统一保存到对象存储
+type objectStorage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Delete(ctx context.Context, key string) error
+	// 带有效期的下载地址
+	URL(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+var storage objectStorage
+
+// 支持转为低频存储的对象存储，本地存储不支持，录像生命周期跳过转存
+type archiver interface {
+	Archive(ctx context.Context, key string) error
+}
+
+func startStorage() error {
+	cfg := currentConfig().Storage
+	switch cfg.Driver {
+	case "", "local":
+		dir := cfg.Dir
+		if dir == "" {
+			dir = defaultStorageDir
+		}
+		storage = &localStorage{dir: dir}
+		return nil
+	case "s3", "oss", "cos":
+		s, err := newS3Storage(cfg)
+		if err != nil {
+			return err
+		}
+		storage = s
+		return nil
+	default:
+		return fmt.Errorf("unknown storage driver %q", cfg.Driver)
+	}
+}
+
+func presignExpires() time.Duration {
+	if secs := currentConfig().Storage.PresignSeconds; secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return defaultPresignExpires
+}
+
+// 上传本地文件
+func putFile(ctx context.Context, key, localPath, contentType string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return storage.Put(ctx, key, f, info.Size(), contentType)
+}
+
+// 按配置生成下载地址，失败时返回空字符串
+func storageURL(ctx context.Context, key string) string {
+	if key == "" {
+		return ""
+	}
+	u, err := storage.URL(ctx, key, presignExpires())
+	if err != nil {
+		return ""
+	}
+	return u
+}
+
+// 本地磁盘存储，下载地址用 HMAC 签名并由 serveLocalFile 校验
+type localStorage struct {
+	dir string
+}
+
+var errInvalidStorageKey = errors.New("invalid storage key")
+
+func (s *localStorage) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" || clean != "/"+key {
+		return "", errInvalidStorageKey
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(clean)), nil
+}
+
+func (s *localStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	dst, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	// 先写临时文件再改名，避免读到写了一半的文件
+	tmp := dst + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+func (s *localStorage) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *localStorage) URL(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if _, err := s.path(key); err != nil {
+		return "", err
+	}
+	exp := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+	q := url.Values{"expires": {exp}, "sig": {localFileSignature(key, exp)}}
+	return localFileURLPrefix + "/" + key + "?" + q.Encode(), nil
+}
+
+func localFileSignature(key, expires string) string {
+	mac := hmac.New(sha256.New, []byte(currentConfig().JWTSecret))
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 校验签名后返回本地存储中的文件
+func serveLocalFile(c *gin.Context) {
+	s, ok := storage.(*localStorage)
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	exp := c.Query("expires")
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > unix ||
+		!hmac.Equal([]byte(c.Query("sig")), []byte(localFileSignature(key, exp))) {
+		c.Status(http.StatusForbidden)
+		return
+	}
+
+	p, err := s.path(key)
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.File(p)
+}
+
+// 通过 S3 兼容接口访问 AWS S3、阿里云 OSS 或腾讯云 COS
+type s3Storage struct {
+	client    *minio.Client
+	bucket    string
+	coldClass string
+}
+
+func newS3Storage(cfg StorageConfig) (*s3Storage, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("storage bucket is required")
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		if cfg.Region == "" {
+			return nil, errors.New("storage endpoint or region is required")
+		}
+		switch cfg.Driver {
+		case "s3":
+			endpoint = fmt.Sprintf("s3.%s.amazonaws.com", cfg.Region)
+		case "oss":
+			endpoint = fmt.Sprintf("oss-%s.aliyuncs.com", cfg.Region)
+		case "cos":
+			endpoint = fmt.Sprintf("cos.%s.myqcloud.com", cfg.Region)
+		}
+	}
+
+	opts := &minio.Options{
+		Creds:     credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure:    !cfg.Insecure,
+		Region:    cfg.Region,
+		Transport: outboundTransport,
+	}
+	// OSS 和 COS 只支持虚拟主机风格的地址
+	if cfg.Driver == "oss" || cfg.Driver == "cos" {
+		opts.BucketLookup = minio.BucketLookupDNS
+	}
+	client, err := minio.New(endpoint, opts)
+	if err != nil {
+		return nil, err
+	}
+	coldClass := cfg.ColdStorageClass
+	if coldClass == "" {
+		coldClass = "STANDARD_IA"
+		if cfg.Driver == "oss" {
+			coldClass = "IA"
+		}
+	}
+	return &s3Storage{client: client, bucket: cfg.Bucket, coldClass: coldClass}, nil
+}
+
+func (s *s3Storage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *s3Storage) URL(ctx context.Context, key string, expires time.Duration) (string, error) {
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, key, expires, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// 原地复制对象并替换存储类型
+func (s *s3Storage) Archive(ctx context.Context, key string) error {
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return err
+	}
+	dst := minio.CopyDestOptions{
+		Bucket:          s.bucket,
+		Object:          key,
+		ReplaceMetadata: true,
+		UserMetadata: map[string]string{
+			"X-Amz-Storage-Class": s.coldClass,
+			"Content-Type":        info.ContentType,
+		},
+	}
+	_, err = s.client.CopyObject(ctx, dst, minio.CopySrcOptions{Bucket: s.bucket, Object: key})
+	return err
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultStreamPollInterval = 30 * time.Second
+	defaultStreamMissingPolls = 3
+	streamPollTimeout         = 10 * time.Second
+)
+
+func streamPollInterval() time.Duration {
+	if secs := currentConfig().MediaServer.PollSeconds; secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return defaultStreamPollInterval
+}
+
+func streamMissingPolls() int {
+	if n := currentConfig().MediaServer.MissingPolls; n > 0 {
+		return n
+	}
+	return defaultStreamMissingPolls
+}
+
+// 定期向流媒体服务器核对直播中会话的推流。服务器重启时推流结束的回调会丢失，
+// 连续多次查不到推流的会话标记为中断并通知授课老师，推流恢复后回到直播中
+func runStreamWatcher() {
+	if livegoStubbed() {
+		return
+	}
+	ticker := time.NewTicker(streamPollInterval())
+	defer ticker.Stop()
+	for range ticker.C {
+		checkLiveStreams(time.Now().UTC())
+	}
+}
+
+func checkLiveStreams(now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), streamPollTimeout)
+	stats, err := media().GetStats(ctx)
+	cancel()
+	// 服务器不可用时无法判断推流是否还在，不计入
+	if err != nil {
+		log.Printf("Failed to poll media server streams: %v", err)
+		return
+	}
+	present := make(map[string]bool, len(stats))
+	for _, s := range stats {
+		present[s.StreamKey] = true
+	}
+
+	rows, err := db.Query("SELECT id, stream_key, status, stream_misses FROM live_sessions WHERE status IN ('live', 'interrupted')")
+	if err != nil {
+		log.Printf("Failed to list live sessions for stream check: %v", err)
+		return
+	}
+	type liveStream struct {
+		id        int
+		streamKey string
+		status    string
+		misses    int
+	}
+	var streams []liveStream
+	for rows.Next() {
+		var s liveStream
+		if err := rows.Scan(&s.id, &s.streamKey, &s.status, &s.misses); err == nil {
+			streams = append(streams, s)
+		}
+	}
+	rows.Close()
+
+	for _, s := range streams {
+		switch {
+		case present[s.streamKey] && s.status == "interrupted":
+			resumeInterruptedSession(s.id)
+		case present[s.streamKey]:
+			if s.misses > 0 {
+				db.Exec("UPDATE live_sessions SET stream_misses = 0 WHERE id = ?", s.id)
+			}
+		case s.status == "live":
+			// 多副本同时轮询时，半个周期内只计一次
+			result, err := db.Exec(`
+				UPDATE live_sessions SET stream_misses = stream_misses + 1, stream_checked_at = ?
+				WHERE id = ? AND status = 'live' AND (stream_checked_at IS NULL OR stream_checked_at < ?)
+			`, now, s.id, now.Add(-streamPollInterval()/2))
+			if err != nil {
+				log.Printf("Failed to record missing stream for session %d: %v", s.id, err)
+				continue
+			}
+			if n, _ := result.RowsAffected(); n > 0 && s.misses+1 >= streamMissingPolls() {
+				interruptSession(s.id)
+			}
+		}
+	}
+}
+
+// 推流丢失的会话标记为中断，通知学生端并提醒授课老师，返回状态是否变更
+func interruptSession(sessionID int) bool {
+	changed := false
+	err := withLock(sessionLockName(sessionID), func() error {
+		result, err := db.Exec("UPDATE live_sessions SET status = 'interrupted' WHERE id = ? AND status = 'live'", sessionID)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		changed = n > 0
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to mark session %d interrupted: %v", sessionID, err)
+		return false
+	}
+	if !changed {
+		return false
+	}
+	log.Printf("Session %d interrupted: stream missing for %d polls", sessionID, streamMissingPolls())
+	notifySessionStatus(sessionID, "interrupted")
+
+	var teacherID int
+	if err := db.QueryRow("SELECT teacher_id FROM live_sessions WHERE id = ?", sessionID).Scan(&teacherID); err != nil || teacherID == 0 {
+		return true
+	}
+	if err := notifyUsers([]int{teacherID}, NotifySessionInterrupted, gin.H{"session_id": sessionID}); err != nil {
+		log.Printf("Failed to notify teacher of interrupted session %d: %v", sessionID, err)
+	}
+	return true
+}
+
+// 中断的会话重新查到推流时恢复为直播中，返回状态是否变更
+func resumeInterruptedSession(sessionID int) bool {
+	changed := false
+	err := withLock(sessionLockName(sessionID), func() error {
+		result, err := db.Exec("UPDATE live_sessions SET status = 'live', stream_misses = 0 WHERE id = ? AND status = 'interrupted'", sessionID)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		changed = n > 0
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to resume session %d: %v", sessionID, err)
+		return false
+	}
+	if changed {
+		notifySessionStatus(sessionID, "resumed")
+	}
+	return changed
+}
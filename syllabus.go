@@ -0,0 +1,635 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 课时类型
+const (
+	LessonLive = "live" // 关联直播会话，可先只填排课时间
+	LessonVOD  = "vod"  // 上传的录播视频
+)
+
+const (
+	maxVODBytes      = 2 << 30
+	vodCompleteRatio = 0.9 // 录播课看到时长的 90% 视为完成
+)
+
+// 课程章节
+type Chapter struct {
+	ID       int      `json:"id"`
+	CourseID int      `json:"course_id"`
+	Title    string   `json:"title"`
+	Position int      `json:"position"`
+	Lessons  []Lesson `json:"lessons"`
+}
+
+// 课时及当前用户的学习进度
+type Lesson struct {
+	ID            int    `json:"id"`
+	CourseID      int    `json:"course_id"`
+	ChapterID     int    `json:"chapter_id"`
+	Title         string `json:"title"`
+	Position      int    `json:"position"`
+	Kind          string `json:"kind"`
+	SessionID     *int   `json:"session_id,omitempty"`
+	SessionStatus string `json:"session_status,omitempty"`
+	// 直播课被取消或中断后安排的补课会话
+	MakeupSessionID *int       `json:"makeup_session_id,omitempty"`
+	MakeupStatus    string     `json:"makeup_status,omitempty"`
+	MakeupAt        *time.Time `json:"makeup_at,omitempty"`
+	ScheduledAt     *time.Time `json:"scheduled_at,omitempty"`
+	DurationSeconds int        `json:"duration_seconds"`
+	HasVideo        bool       `json:"has_video"`
+	VideoURL        string     `json:"video_url,omitempty"`
+
+	PositionSeconds int        `json:"position_seconds"`
+	Completed       bool       `json:"completed"` // 录播课看完或直播课出勤
+	CompletedAt     *time.Time `json:"completed_at,omitempty"`
+
+	Checkpoints []Checkpoint `json:"checkpoints,omitempty"` // 仅课时详情返回
+
+	videoKey string
+}
+
+// 课时及指定用户的进度，直播课以出勤记录作为完成依据，出勤补课会话也算完成
+const lessonSelect = `
+	SELECT l.id, l.course_id, l.chapter_id, l.title, l.position, l.kind, l.session_id, COALESCE(s.status, ''),
+		ms.id, COALESCE(ms.status, ''), ms.scheduled_at,
+		l.scheduled_at, l.video_key, l.duration_seconds, COALESCE(p.position_seconds, 0), p.completed_at,
+		COALESCE(a.joined_at, ma.joined_at)
+	FROM course_lessons l
+	LEFT JOIN live_sessions s ON s.id = l.session_id
+	LEFT JOIN live_sessions ms ON ms.makeup_for = l.session_id
+	LEFT JOIN lesson_progress p ON p.lesson_id = l.id AND p.student_id = ?
+	LEFT JOIN session_attendance a ON a.session_id = l.session_id AND a.user_id = ?
+	LEFT JOIN session_attendance ma ON ma.session_id = ms.id AND ma.user_id = ?`
+
+func scanLesson(row interface{ Scan(...interface{}) error }, loc *time.Location) (Lesson, error) {
+	var l Lesson
+	var attendedAt *time.Time
+	err := row.Scan(&l.ID, &l.CourseID, &l.ChapterID, &l.Title, &l.Position, &l.Kind, &l.SessionID, &l.SessionStatus,
+		&l.MakeupSessionID, &l.MakeupStatus, &l.MakeupAt, &l.ScheduledAt, &l.videoKey, &l.DurationSeconds, &l.PositionSeconds, &l.CompletedAt, &attendedAt)
+	if l.CompletedAt == nil && l.Kind == LessonLive {
+		l.CompletedAt = attendedAt
+	}
+	l.Completed = l.CompletedAt != nil
+	l.HasVideo = l.videoKey != ""
+	l.ScheduledAt = inLocation(l.ScheduledAt, loc)
+	l.MakeupAt = inLocation(l.MakeupAt, loc)
+	l.CompletedAt = inLocation(l.CompletedAt, loc)
+	return l, err
+}
+
+// 按路径中的 course_id 和 lesson_id 查询课时，失败时写入错误响应
+func lessonParam(c *gin.Context) (Lesson, bool) {
+	courseID, ok := intParam(c, "course_id")
+	if !ok {
+		return Lesson{}, false
+	}
+	lessonID, ok := intParam(c, "lesson_id")
+	if !ok {
+		return Lesson{}, false
+	}
+	userID := currentUser(c).ID
+	lesson, err := scanLesson(db.QueryRow(lessonSelect+" WHERE l.id = ? AND l.course_id = ?",
+		userID, userID, userID, lessonID, courseID), requestLocation(c))
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeLessonNotFound)
+		return lesson, false
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSyllabusGetFailed)
+		return lesson, false
+	}
+	return lesson, true
+}
+
+// 章节是否属于课程，不属于时写入错误响应
+func requireChapter(c *gin.Context, courseID, chapterID int) bool {
+	var id int
+	err := db.QueryRow("SELECT id FROM course_chapters WHERE id = ? AND course_id = ?", chapterID, courseID).Scan(&id)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeChapterNotFound)
+		return false
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSyllabusGetFailed)
+		return false
+	}
+	return true
+}
+
+// 直播课关联的会话需属于同一课程，不属于时写入错误响应
+func requireCourseSession(c *gin.Context, courseID, sessionID int) bool {
+	var sessionCourse int
+	err := db.QueryRow("SELECT course_id FROM live_sessions WHERE id = ?", sessionID).Scan(&sessionCourse)
+	if err == sql.ErrNoRows || (err == nil && sessionCourse != courseID) {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "session_id")
+		return false
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return false
+	}
+	return true
+}
+
+// 课程目录：按顺序列出章节和课时，附带当前用户的完成情况
+func getSyllabus(c *gin.Context) {
+	courseID, ok := intParam(c, "course_id")
+	if !ok {
+		return
+	}
+	userID := currentUser(c).ID
+	loc := requestLocation(c)
+
+	rows, err := db.Query("SELECT id, course_id, title, position FROM course_chapters WHERE course_id = ? ORDER BY position, id", courseID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSyllabusGetFailed)
+		return
+	}
+	defer rows.Close()
+	chapters := []Chapter{}
+	index := make(map[int]int)
+	for rows.Next() {
+		ch := Chapter{Lessons: []Lesson{}}
+		if err := rows.Scan(&ch.ID, &ch.CourseID, &ch.Title, &ch.Position); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeSyllabusGetFailed)
+			return
+		}
+		index[ch.ID] = len(chapters)
+		chapters = append(chapters, ch)
+	}
+
+	lessonRows, err := db.Query(lessonSelect+" WHERE l.course_id = ? ORDER BY l.position, l.id", userID, userID, userID, courseID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSyllabusGetFailed)
+		return
+	}
+	defer lessonRows.Close()
+	total, completed := 0, 0
+	for lessonRows.Next() {
+		lesson, err := scanLesson(lessonRows, loc)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeSyllabusGetFailed)
+			return
+		}
+		i, ok := index[lesson.ChapterID]
+		if !ok {
+			continue
+		}
+		chapters[i].Lessons = append(chapters[i].Lessons, lesson)
+		total++
+		if lesson.Completed {
+			completed++
+		}
+	}
+
+	respondOK(c, http.StatusOK, gin.H{
+		"course_id":         courseID,
+		"chapters":          chapters,
+		"total_lessons":     total,
+		"completed_lessons": completed,
+	})
+}
+
+// 新建章节，未指定位置时追加到末尾
+func createChapter(c *gin.Context) {
+	courseID, ok := intParam(c, "course_id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Title    string `json:"title" binding:"required,max=255"`
+		Position *int   `json:"position" binding:"omitempty,min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	ch := Chapter{CourseID: courseID, Title: strings.TrimSpace(req.Title), Lessons: []Lesson{}}
+	if req.Position != nil {
+		ch.Position = *req.Position
+	} else if err := db.QueryRow("SELECT COALESCE(MAX(position), 0) + 1 FROM course_chapters WHERE course_id = ?", courseID).Scan(&ch.Position); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSyllabusUpdateFailed)
+		return
+	}
+	id, err := dialect.insertID(db, "INSERT INTO course_chapters (course_id, title, position, created_at) VALUES (?, ?, ?, ?)",
+		ch.CourseID, ch.Title, ch.Position, time.Now().UTC())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSyllabusUpdateFailed)
+		return
+	}
+	ch.ID = int(id)
+	respondOK(c, http.StatusCreated, ch)
+}
+
+// 修改章节标题或位置
+func updateChapter(c *gin.Context) {
+	courseID, ok := intParam(c, "course_id")
+	if !ok {
+		return
+	}
+	chapterID, ok := intParam(c, "chapter_id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Title    *string `json:"title" binding:"omitempty,min=1,max=255"`
+		Position *int    `json:"position" binding:"omitempty,min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	ch := Chapter{ID: chapterID, CourseID: courseID, Lessons: []Lesson{}}
+	err := db.QueryRow("SELECT title, position FROM course_chapters WHERE id = ? AND course_id = ?", chapterID, courseID).Scan(&ch.Title, &ch.Position)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeChapterNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSyllabusGetFailed)
+		return
+	}
+	if req.Title != nil {
+		ch.Title = strings.TrimSpace(*req.Title)
+	}
+	if req.Position != nil {
+		ch.Position = *req.Position
+	}
+	if _, err := db.Exec("UPDATE course_chapters SET title = ?, position = ? WHERE id = ?", ch.Title, ch.Position, ch.ID); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSyllabusUpdateFailed)
+		return
+	}
+	respondOK(c, http.StatusOK, ch)
+}
+
+// 删除章节及其下的课时、学习进度和录播视频
+func deleteChapter(c *gin.Context) {
+	courseID, ok := intParam(c, "course_id")
+	if !ok {
+		return
+	}
+	chapterID, ok := intParam(c, "chapter_id")
+	if !ok {
+		return
+	}
+	if !requireChapter(c, courseID, chapterID) {
+		return
+	}
+
+	keys, err := lessonVideoKeys("chapter_id = ?", chapterID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSyllabusUpdateFailed)
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSyllabusUpdateFailed)
+		return
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		"DELETE FROM lesson_progress WHERE lesson_id IN (SELECT id FROM course_lessons WHERE chapter_id = ?)",
+		"DELETE FROM video_checkpoints WHERE target_type = 'lesson' AND target_id IN (SELECT id FROM course_lessons WHERE chapter_id = ?)",
+		"DELETE FROM course_lessons WHERE chapter_id = ?",
+		"DELETE FROM course_chapters WHERE id = ?",
+	} {
+		if _, err := tx.Exec(stmt, chapterID); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeSyllabusUpdateFailed)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSyllabusUpdateFailed)
+		return
+	}
+	deleteVideos(c, keys)
+	respondOK(c, http.StatusOK, gin.H{"id": chapterID})
+}
+
+func lessonVideoKeys(where string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query("SELECT video_key FROM course_lessons WHERE video_key <> '' AND "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// 删除对象存储中的视频，失败只记录日志
+func deleteVideos(c *gin.Context, keys []string) {
+	for _, key := range keys {
+		if err := storage.Delete(c.Request.Context(), key); err != nil {
+			log.Printf("Failed to delete video %s: %v", key, err)
+		}
+	}
+}
+
+// 新建课时，直播课可关联已有会话或只填排课时间，录播课创建后再上传视频
+func createLesson(c *gin.Context) {
+	courseID, ok := intParam(c, "course_id")
+	if !ok {
+		return
+	}
+	var req struct {
+		ChapterID       int        `json:"chapter_id" binding:"required"`
+		Title           string     `json:"title" binding:"required,max=255"`
+		Kind            string     `json:"kind" binding:"required,oneof=live vod"`
+		SessionID       *int       `json:"session_id"`
+		ScheduledAt     *time.Time `json:"scheduled_at"`
+		DurationSeconds int        `json:"duration_seconds" binding:"min=0"`
+		Position        *int       `json:"position" binding:"omitempty,min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if req.Kind == LessonVOD && req.SessionID != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "session_id")
+		return
+	}
+	if !requireChapter(c, courseID, req.ChapterID) {
+		return
+	}
+	if req.SessionID != nil && !requireCourseSession(c, courseID, *req.SessionID) {
+		return
+	}
+
+	lesson := Lesson{
+		CourseID:        courseID,
+		ChapterID:       req.ChapterID,
+		Title:           strings.TrimSpace(req.Title),
+		Kind:            req.Kind,
+		SessionID:       req.SessionID,
+		DurationSeconds: req.DurationSeconds,
+	}
+	if req.ScheduledAt != nil {
+		t := req.ScheduledAt.UTC()
+		lesson.ScheduledAt = &t
+	}
+	if req.Position != nil {
+		lesson.Position = *req.Position
+	} else if err := db.QueryRow("SELECT COALESCE(MAX(position), 0) + 1 FROM course_lessons WHERE chapter_id = ?", req.ChapterID).Scan(&lesson.Position); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSyllabusUpdateFailed)
+		return
+	}
+
+	id, err := dialect.insertID(db, `
+		INSERT INTO course_lessons (course_id, chapter_id, title, position, kind, session_id, scheduled_at, duration_seconds, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, lesson.CourseID, lesson.ChapterID, lesson.Title, lesson.Position, lesson.Kind, lesson.SessionID,
+		lesson.ScheduledAt, lesson.DurationSeconds, time.Now().UTC())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSyllabusUpdateFailed)
+		return
+	}
+	lesson.ID = int(id)
+	lesson.ScheduledAt = inLocation(lesson.ScheduledAt, requestLocation(c))
+	respondOK(c, http.StatusCreated, lesson)
+}
+
+// 修改课时，只更新请求中出现的字段；课时类型不可修改
+func updateLesson(c *gin.Context) {
+	lesson, ok := lessonParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		ChapterID       *int       `json:"chapter_id"`
+		Title           *string    `json:"title" binding:"omitempty,min=1,max=255"`
+		Position        *int       `json:"position" binding:"omitempty,min=0"`
+		SessionID       *int       `json:"session_id"`
+		ScheduledAt     *time.Time `json:"scheduled_at"`
+		DurationSeconds *int       `json:"duration_seconds" binding:"omitempty,min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if req.SessionID != nil {
+		if lesson.Kind != LessonLive {
+			respondError(c, http.StatusBadRequest, CodeInvalidParam, "session_id")
+			return
+		}
+		if !requireCourseSession(c, lesson.CourseID, *req.SessionID) {
+			return
+		}
+		lesson.SessionID = req.SessionID
+	}
+	if req.ChapterID != nil {
+		if !requireChapter(c, lesson.CourseID, *req.ChapterID) {
+			return
+		}
+		lesson.ChapterID = *req.ChapterID
+	}
+	if req.Title != nil {
+		lesson.Title = strings.TrimSpace(*req.Title)
+	}
+	if req.Position != nil {
+		lesson.Position = *req.Position
+	}
+	if req.ScheduledAt != nil {
+		lesson.ScheduledAt = req.ScheduledAt
+	}
+	if req.DurationSeconds != nil {
+		lesson.DurationSeconds = *req.DurationSeconds
+	}
+
+	var scheduledAt *time.Time
+	if lesson.ScheduledAt != nil {
+		t := lesson.ScheduledAt.UTC()
+		scheduledAt = &t
+	}
+	_, err := db.Exec(`
+		UPDATE course_lessons SET chapter_id = ?, title = ?, position = ?, session_id = ?, scheduled_at = ?, duration_seconds = ?
+		WHERE id = ?
+	`, lesson.ChapterID, lesson.Title, lesson.Position, lesson.SessionID, scheduledAt, lesson.DurationSeconds, lesson.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSyllabusUpdateFailed)
+		return
+	}
+	lesson.ScheduledAt = inLocation(scheduledAt, requestLocation(c))
+	respondOK(c, http.StatusOK, lesson)
+}
+
+// 删除课时及其学习进度和录播视频
+func deleteLesson(c *gin.Context) {
+	lesson, ok := lessonParam(c)
+	if !ok {
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSyllabusUpdateFailed)
+		return
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		"DELETE FROM lesson_progress WHERE lesson_id = ?",
+		"DELETE FROM video_checkpoints WHERE target_type = 'lesson' AND target_id = ?",
+		"DELETE FROM course_lessons WHERE id = ?",
+	} {
+		if _, err := tx.Exec(stmt, lesson.ID); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeSyllabusUpdateFailed)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSyllabusUpdateFailed)
+		return
+	}
+	if lesson.videoKey != "" {
+		deleteVideos(c, []string{lesson.videoKey})
+	}
+	respondOK(c, http.StatusOK, gin.H{"id": lesson.ID})
+}
+
+// 上传录播课视频（multipart 字段 file），替换原有视频
+func uploadLessonVideo(c *gin.Context) {
+	lesson, ok := lessonParam(c)
+	if !ok {
+		return
+	}
+	if lesson.Kind != LessonVOD {
+		respondError(c, http.StatusBadRequest, CodeLessonNotVOD)
+		return
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		if limit, ok := bodyTooLarge(err); ok {
+			respondError(c, http.StatusRequestEntityTooLarge, CodeBodyTooLarge, limit)
+			return
+		}
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "file")
+		return
+	}
+	contentType := header.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "video/") {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "file")
+		return
+	}
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	if ext == "" {
+		ext = ".mp4"
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeVideoUploadFailed)
+		return
+	}
+	key := fmt.Sprintf("vod/%d/%s%s", lesson.ID, hex.EncodeToString(suffix), ext)
+
+	f, err := header.Open()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeVideoUploadFailed)
+		return
+	}
+	defer f.Close()
+	if err := storage.Put(c.Request.Context(), key, f, header.Size, contentType); err != nil {
+		log.Printf("Failed to store video for lesson %d: %v", lesson.ID, err)
+		respondError(c, http.StatusInternalServerError, CodeVideoUploadFailed)
+		return
+	}
+	if _, err := db.Exec("UPDATE course_lessons SET video_key = ? WHERE id = ?", key, lesson.ID); err != nil {
+		deleteVideos(c, []string{key})
+		respondError(c, http.StatusInternalServerError, CodeVideoUploadFailed)
+		return
+	}
+	if lesson.videoKey != "" {
+		deleteVideos(c, []string{lesson.videoKey})
+	}
+
+	lesson.videoKey = key
+	lesson.HasVideo = true
+	lesson.VideoURL = storageURL(c.Request.Context(), key)
+	respondOK(c, http.StatusOK, lesson)
+}
+
+// 课时详情，录播课附带视频地址；付费课程的学生需已报名
+func getLesson(c *gin.Context) {
+	lesson, ok := lessonParam(c)
+	if !ok {
+		return
+	}
+	user := currentUser(c)
+	if user.Role == RoleStudent && !requireCourseAccess(c, lesson.CourseID, 0, user.ID) {
+		return
+	}
+	lesson.VideoURL = storageURL(c.Request.Context(), lesson.videoKey)
+	if lesson.Kind == LessonVOD {
+		checkpoints, err := loadCheckpoints(CheckpointLesson, lesson.ID, user.ID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeCheckpointGetFailed)
+			return
+		}
+		lesson.Checkpoints = checkpoints
+	}
+	respondOK(c, http.StatusOK, lesson)
+}
+
+// 上报学习进度，录播课看到接近结尾时自动记为完成
+func updateLessonProgress(c *gin.Context) {
+	lesson, ok := lessonParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		PositionSeconds int  `json:"position_seconds" binding:"min=0"`
+		Completed       bool `json:"completed"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	user := currentUser(c)
+	if user.Role == RoleStudent && !requireCourseAccess(c, lesson.CourseID, 0, user.ID) {
+		return
+	}
+
+	now := time.Now().UTC()
+	var completedAt *time.Time
+	if req.Completed || (lesson.Kind == LessonVOD && lesson.DurationSeconds > 0 &&
+		float64(req.PositionSeconds) >= float64(lesson.DurationSeconds)*vodCompleteRatio) {
+		completedAt = &now
+	}
+	_, err := db.Exec(dialect.upsert(
+		"INSERT INTO lesson_progress (lesson_id, student_id, position_seconds, completed_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		[]string{"lesson_id", "student_id"},
+		"position_seconds = EXCLUDED.position_seconds",
+		"completed_at = COALESCE(lesson_progress.completed_at, EXCLUDED.completed_at)",
+		"updated_at = EXCLUDED.updated_at",
+	), lesson.ID, user.ID, req.PositionSeconds, completedAt, now)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeProgressUpdateFailed)
+		return
+	}
+
+	lesson, ok = lessonParam(c)
+	if !ok {
+		return
+	}
+	respondOK(c, http.StatusOK, lesson)
+}
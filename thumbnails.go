@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	thumbnailURLPrefix       = "/thumbnails"
+	defaultThumbnailDir      = "thumbnails"
+	defaultThumbnailInterval = 60 * time.Second
+	thumbnailCaptureTimeout  = 20 * time.Second
+	thumbnailWidth           = 640
+)
+
+// 封面截图
+type Thumbnail struct {
+	URL        string    `json:"url"`
+	CapturedAt time.Time `json:"captured_at"`
+}
+
+func thumbnailDir() string {
+	if dir := currentConfig().ThumbnailDir; dir != "" {
+		return dir
+	}
+	return defaultThumbnailDir
+}
+
+// 服务端拉流地址，直接连接流媒体服务器的 RTMP 端口
+func internalRTMPURL(streamKey string) string {
+	return media().RTMPURL() + "/" + streamKey
+}
+
+// 定期为直播中的会话截取一帧作为封面
+func runThumbnailer() {
+	interval := defaultThumbnailInterval
+	if secs := currentConfig().ThumbnailInterval; secs > 0 {
+		interval = time.Duration(secs) * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		rows, err := db.Query("SELECT id, stream_key FROM live_sessions WHERE status = 'live'")
+		if err != nil {
+			log.Printf("Failed to list live sessions for thumbnails: %v", err)
+			continue
+		}
+		type liveStream struct {
+			id        int
+			streamKey string
+		}
+		var streams []liveStream
+		for rows.Next() {
+			var s liveStream
+			if err := rows.Scan(&s.id, &s.streamKey); err == nil {
+				streams = append(streams, s)
+			}
+		}
+		rows.Close()
+
+		for _, s := range streams {
+			// 多副本时只由抢到锁的实例截图
+			unlock, err := acquireLock(fmt.Sprintf("zhibo:thumbnail:%d", s.id), 0)
+			if err != nil {
+				if !errors.Is(err, errLockTimeout) {
+					log.Printf("Failed to lock thumbnail capture for session %d: %v", s.id, err)
+				}
+				continue
+			}
+			if err := captureThumbnail(s.id, s.streamKey); err != nil {
+				log.Printf("Failed to capture thumbnail for session %d: %v", s.id, err)
+			}
+			unlock()
+		}
+	}
+}
+
+func captureThumbnail(sessionID int, streamKey string) error {
+	now := time.Now().UTC()
+	rel := path.Join("sessions", fmt.Sprint(sessionID), fmt.Sprintf("%d.jpg", now.Unix()))
+	out := filepath.Join(thumbnailDir(), filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), thumbnailCaptureTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, ffmpegPath(), "-hide_banner", "-loglevel", "error",
+		"-i", internalRTMPURL(streamKey),
+		"-frames:v", "1", "-vf", fmt.Sprintf("scale=%d:-2", thumbnailWidth),
+		"-y", out)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, output)
+	}
+
+	thumbURL := path.Join(thumbnailURLPrefix, rel)
+	if _, err := db.Exec(`
+		INSERT INTO session_thumbnails (session_id, path, captured_at) VALUES (?, ?, ?)
+	`, sessionID, thumbURL, now); err != nil {
+		return err
+	}
+	_, err := db.Exec("UPDATE live_sessions SET thumbnail = ? WHERE id = ?", thumbURL, sessionID)
+	return err
+}
+
+// 录像对应直播期间的所有截图，供点播库选择封面
+func getRecordingThumbnails(c *gin.Context) {
+	rec, ok := recordingParam(c)
+	if !ok {
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT path, captured_at FROM session_thumbnails
+		WHERE session_id = ?
+		ORDER BY captured_at
+	`, rec.SessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeThumbnailGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	thumbnails := []Thumbnail{}
+	for rows.Next() {
+		var t Thumbnail
+		if err := rows.Scan(&t.URL, &t.CapturedAt); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeThumbnailGetFailed)
+			return
+		}
+		t.CapturedAt = t.CapturedAt.In(loc)
+		thumbnails = append(thumbnails, t)
+	}
+
+	respondOK(c, http.StatusOK, thumbnails)
+}
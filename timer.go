@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 计时器用途
+const (
+	TimerBreak    = "break"    // 课间休息
+	TimerQuestion = "question" // 答题倒计时
+	TimerCustom   = "custom"
+)
+
+// 计时器状态
+const (
+	TimerRunning   = "running"
+	TimerExpired   = "expired"
+	TimerCancelled = "cancelled"
+)
+
+const (
+	jobTimerExpire         = "timer.expire"
+	maxSessionTimers       = 5
+	timerSyncInterval      = 10 * time.Second
+	timerExpireGracePeriod = time.Second
+)
+
+var timerKinds = []string{TimerBreak, TimerQuestion, TimerCustom}
+
+// 老师在课堂上启动的倒计时。到期以服务端为准，客户端按 remaining_ms 显示，收到 timer_expired 后结束
+type SessionTimer struct {
+	ID              int        `json:"id"`
+	SessionID       int        `json:"session_id"`
+	Kind            string     `json:"kind"`
+	Label           string     `json:"label,omitempty"`
+	DurationSeconds int        `json:"duration_seconds"`
+	Status          string     `json:"status"`
+	StartedAt       time.Time  `json:"started_at"`
+	EndsAt          time.Time  `json:"ends_at"`
+	RemainingMs     int64      `json:"remaining_ms"`
+	CreatedBy       int        `json:"created_by"`
+	FinishedAt      *time.Time `json:"finished_at,omitempty"`
+}
+
+func init() {
+	// 到期时以服务端时间结束计时并通知会话内的客户端
+	jobHandlers[jobTimerExpire] = func(ctx context.Context, payload json.RawMessage) error {
+		var p struct {
+			TimerID int `json:"timer_id"`
+		}
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return expireTimer(ctx, p.TimerID, time.Now().UTC())
+	}
+}
+
+// 计算剩余时间，now 统一取服务端时间
+func (t *SessionTimer) sync(now time.Time) {
+	t.RemainingMs = 0
+	if t.Status == TimerRunning && t.EndsAt.After(now) {
+		t.RemainingMs = t.EndsAt.Sub(now).Milliseconds()
+	}
+}
+
+// 下发给客户端的计时器消息，附带服务端时间供客户端校准
+func timerMessage(msgType string, t SessionTimer, now time.Time) Message {
+	t.sync(now)
+	return Message{Type: msgType, Data: gin.H{"timer": t, "server_time": now}, Time: now}
+}
+
+const timerColumns = `id, session_id, kind, label, duration_seconds, status, started_at, ends_at, created_by, finished_at`
+
+func scanTimer(scan func(...interface{}) error) (SessionTimer, error) {
+	var t SessionTimer
+	err := scan(&t.ID, &t.SessionID, &t.Kind, &t.Label, &t.DurationSeconds, &t.Status, &t.StartedAt, &t.EndsAt, &t.CreatedBy, &t.FinishedAt)
+	return t, err
+}
+
+func loadTimer(ctx context.Context, id int) (SessionTimer, error) {
+	return scanTimer(db.QueryRowContext(ctx, "SELECT "+timerColumns+" FROM session_timers WHERE id = ?", id).Scan)
+}
+
+// 会话中正在计时的计时器，sessionID 为 0 时返回所有会话的
+func runningTimers(ctx context.Context, sessionID int) ([]SessionTimer, error) {
+	q := "SELECT " + timerColumns + " FROM session_timers WHERE status = ?"
+	args := []interface{}{TimerRunning}
+	if sessionID > 0 {
+		q += " AND session_id = ?"
+		args = append(args, sessionID)
+	}
+	rows, err := db.QueryContext(ctx, q+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	timers := []SessionTimer{}
+	for rows.Next() {
+		t, err := scanTimer(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		timers = append(timers, t)
+	}
+	return timers, rows.Err()
+}
+
+// 结束到期的计时器，已取消或已结束时不重复通知
+func expireTimer(ctx context.Context, id int, now time.Time) error {
+	t, err := loadTimer(ctx, id)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if t.Status != TimerRunning {
+		return nil
+	}
+	// 任务提前被领取时等到期后再执行
+	if now.Add(timerExpireGracePeriod).Before(t.EndsAt) {
+		_, err := enqueueJob(jobTimerExpire, gin.H{"timer_id": t.ID}, t.EndsAt)
+		return err
+	}
+
+	res, err := db.ExecContext(ctx, "UPDATE session_timers SET status = ?, finished_at = ? WHERE id = ? AND status = ?",
+		TimerExpired, t.EndsAt, t.ID, TimerRunning)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	t.Status = TimerExpired
+	t.FinishedAt = &t.EndsAt
+	hub.broadcast(sessionRoom(t.SessionID), timerMessage("timer_expired", t, now))
+	return nil
+}
+
+// 定期向本实例的连接下发正在计时的剩余时间，纠正客户端的时钟漂移。
+// 每个实例只发给自己的连接，多副本部署时不会重复
+func runTimerSync() {
+	ticker := time.NewTicker(timerSyncInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		timers, err := runningTimers(context.Background(), 0)
+		if err != nil {
+			log.Printf("Failed to load running timers: %v", err)
+			continue
+		}
+		now := time.Now().UTC()
+		for _, t := range timers {
+			if !t.EndsAt.After(now) {
+				continue
+			}
+			msg := timerMessage("timer_sync", t, now)
+			for _, c := range hub.clients(sessionRoom(t.SessionID)) {
+				c.sendMessage(msg)
+			}
+		}
+	}
+}
+
+// 启动计时器
+func startTimer(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Kind            string `json:"kind" binding:"required"`
+		Label           string `json:"label" binding:"max=100"`
+		DurationSeconds int    `json:"duration_seconds" binding:"required,min=1,max=86400"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if !contains(timerKinds, req.Kind) {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "kind")
+		return
+	}
+	if !sessionExists(c, sessionID) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	running, err := runningTimers(ctx, sessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeTimerCreateFailed)
+		return
+	}
+	if len(running) >= maxSessionTimers {
+		respondError(c, http.StatusConflict, CodeTimerLimit, maxSessionTimers)
+		return
+	}
+
+	now := time.Now().UTC()
+	t := SessionTimer{
+		SessionID:       sessionID,
+		Kind:            req.Kind,
+		Label:           strings.TrimSpace(req.Label),
+		DurationSeconds: req.DurationSeconds,
+		Status:          TimerRunning,
+		StartedAt:       now,
+		EndsAt:          now.Add(time.Duration(req.DurationSeconds) * time.Second),
+		CreatedBy:       currentUser(c).ID,
+	}
+	id, err := dialect.insertID(db, `
+		INSERT INTO session_timers (session_id, kind, label, duration_seconds, status, started_at, ends_at, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, t.SessionID, t.Kind, t.Label, t.DurationSeconds, t.Status, t.StartedAt, t.EndsAt, t.CreatedBy)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeTimerCreateFailed)
+		return
+	}
+	t.ID = int(id)
+	if _, err := enqueueJob(jobTimerExpire, gin.H{"timer_id": t.ID}, t.EndsAt); err != nil {
+		log.Printf("Failed to schedule expiration of timer %d: %v", t.ID, err)
+	}
+
+	hub.broadcast(sessionRoom(sessionID), timerMessage("timer_started", t, now))
+	t.sync(now)
+	respondOK(c, http.StatusCreated, t)
+}
+
+// 会话中正在计时的计时器
+func listTimers(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	if !sessionExists(c, sessionID) {
+		return
+	}
+	timers, err := runningTimers(c.Request.Context(), sessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeTimerGetFailed)
+		return
+	}
+	now := time.Now().UTC()
+	for i := range timers {
+		timers[i].sync(now)
+	}
+	respondOK(c, http.StatusOK, gin.H{"timers": timers, "server_time": now})
+}
+
+// 提前结束计时器，客户端收到 timer_cancelled 后停止计时
+func cancelTimer(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	timerID, ok := intParam(c, "timer_id")
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	now := time.Now().UTC()
+	res, err := db.ExecContext(ctx, "UPDATE session_timers SET status = ?, finished_at = ? WHERE id = ? AND session_id = ? AND status = ?",
+		TimerCancelled, now, timerID, sessionID, TimerRunning)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeTimerUpdateFailed)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, CodeTimerNotFound)
+		return
+	}
+	t, err := loadTimer(ctx, timerID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeTimerGetFailed)
+		return
+	}
+
+	hub.broadcast(sessionRoom(sessionID), timerMessage("timer_cancelled", t, now))
+	t.sync(now)
+	respondOK(c, http.StatusOK, t)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 请求使用的时区：?tz 参数、X-Timezone 头、用户设置、机构默认，依次回退
+func requestLocation(c *gin.Context) *time.Location {
+	candidates := []string{c.Query("tz"), c.GetHeader("X-Timezone")}
+	if user := currentUser(c); user != nil {
+		candidates = append(candidates, user.TimeZone)
+	}
+	for _, name := range candidates {
+		if name == "" {
+			continue
+		}
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	return currentConfig().location
+}
+
+// 转换到指定时区，JSON 序列化时输出带偏移的 RFC3339
+func inLocation(t *time.Time, loc *time.Location) *time.Time {
+	if t == nil {
+		return nil
+	}
+	local := t.In(loc)
+	return &local
+}
+
+// 将会话中的时间转换到指定时区
+func (s *LiveSession) inLocation(loc *time.Location) {
+	s.StartTime = inLocation(s.StartTime, loc)
+	s.EndTime = inLocation(s.EndTime, loc)
+	s.ScheduledAt = inLocation(s.ScheduledAt, loc)
+	s.CreatedAt = s.CreatedAt.In(loc)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultServiceName = "zhibo-class"
+
+// 链路追踪，通过 OTLP/HTTP 导出，endpoint 为空时不启用
+type TracingConfig struct {
+	Endpoint    string  `json:"endpoint"` // 如 otel-collector:4318
+	Insecure    bool    `json:"insecure"`
+	ServiceName string  `json:"service_name"`
+	SampleRatio float64 `json:"sample_ratio"` // 采样比例，为 0 时全部采样
+}
+
+var tracer = otel.Tracer("github.com/Dong557799/zhibo-class")
+
+// 调用 Livego、语音识别等外部服务的客户端，请求会带上 trace 上下文
+var httpClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+func serviceName() string {
+	if name := currentConfig().Tracing.ServiceName; name != "" {
+		return name
+	}
+	return defaultServiceName
+}
+
+// 初始化链路追踪，返回退出时刷新剩余 span 的函数
+func startTracing() (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	cfg := currentConfig().Tracing
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	sampler := sdktrace.AlwaysSample()
+	if cfg.SampleRatio > 0 && cfg.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(cfg.SampleRatio)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName()))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// 当前请求的 trace id，未启用追踪时为空
+func traceID(c *gin.Context) string {
+	if sc := trace.SpanContextFromContext(c.Request.Context()); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
+
+// 在响应头中返回 trace id，便于按请求排查
+func traceIDHeader() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if id := traceID(c); id != "" {
+			c.Header("X-Trace-ID", id)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// 纯音频流的推流码为主推流码加后缀，由服务端拉流转码后推回 Livego
+	audioVariantSuffix    = "_audio"
+	audioOnlyBitrateKbps  = 48
+	audioTranscodeCheck   = 10 * time.Second
+	audioTranscodeLockKey = "zhibo:transcode:audio:%d"
+)
+
+// 本实例正在运行的纯音频转码，按会话取消
+var audioTranscodes = struct {
+	sync.Mutex
+	cancel map[int]context.CancelFunc
+}{cancel: map[int]context.CancelFunc{}}
+
+// 开启纯音频流或强制学生只听音频时都需要转码
+func audioVariantEnabled(settings SessionSettings) bool {
+	return settings.AudioVariant || settings.StudentAudioOnly
+}
+
+// 纯音频流的 HLS 地址，移动端可直接播放
+func audioPlayURL(streamKey, region string) string {
+	return getPlayURLs(streamKey+audioVariantSuffix, region)["hls"]
+}
+
+// 在播放地址中加入纯音频流；audioOnly 为 true 时只返回纯音频地址，用于省流量模式
+func withAudioVariant(urls map[string]string, streamKey, region string, settings SessionSettings, audioOnly bool) map[string]string {
+	if !audioVariantEnabled(settings) {
+		return urls
+	}
+	if audioOnly {
+		return map[string]string{"audio": audioPlayURL(streamKey, region)}
+	}
+	urls["audio"] = audioPlayURL(streamKey, region)
+	return urls
+}
+
+// 定期检查直播中的会话，为开启纯音频流的会话启动转码，设置关闭后停止；
+// 多副本时由抢到锁的实例负责转码
+func runAudioTranscoder() {
+	if livegoStubbed() {
+		return
+	}
+	ticker := time.NewTicker(audioTranscodeCheck)
+	defer ticker.Stop()
+	for range ticker.C {
+		rows, err := db.Query("SELECT id, stream_key FROM live_sessions WHERE status = 'live'")
+		if err != nil {
+			log.Printf("Failed to list live sessions for transcoding: %v", err)
+			continue
+		}
+		wanted := make(map[int]string)
+		for rows.Next() {
+			var id int
+			var key string
+			if err := rows.Scan(&id, &key); err != nil {
+				continue
+			}
+			if settings, err := loadSessionSettings(id); err == nil && audioVariantEnabled(settings) {
+				wanted[id] = key
+			}
+		}
+		rows.Close()
+
+		audioTranscodes.Lock()
+		for id, cancel := range audioTranscodes.cancel {
+			if _, ok := wanted[id]; !ok {
+				cancel()
+			}
+		}
+		for id, key := range wanted {
+			if _, ok := audioTranscodes.cancel[id]; ok {
+				continue
+			}
+			unlock, err := acquireLock(fmt.Sprintf(audioTranscodeLockKey, id), 0)
+			if err != nil {
+				if !errors.Is(err, errLockTimeout) {
+					log.Printf("Failed to lock audio transcode for session %d: %v", id, err)
+				}
+				continue
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			audioTranscodes.cancel[id] = cancel
+			go func(id int, key string) {
+				defer unlock()
+				if err := transcodeAudio(ctx, key); err != nil && ctx.Err() == nil {
+					log.Printf("Audio transcode for session %d stopped: %v", id, err)
+				}
+				audioTranscodes.Lock()
+				delete(audioTranscodes.cancel, id)
+				audioTranscodes.Unlock()
+				cancel()
+			}(id, key)
+		}
+		audioTranscodes.Unlock()
+	}
+}
+
+// 拉取主画面，去掉视频后以低码率 AAC 推回 Livego，推流结束时返回
+func transcodeAudio(ctx context.Context, streamKey string) error {
+	audioKey := streamKey + audioVariantSuffix
+	if err := media().CreateStream(ctx, audioKey); err != nil {
+		return err
+	}
+	defer media().DeleteStream(context.Background(), audioKey)
+
+	target := internalRTMPURL(audioKey) + "?" + publishToken(audioKey, time.Now().Add(publishTokenTTL()))
+	cmd := exec.CommandContext(ctx, ffmpegPath(), "-hide_banner", "-loglevel", "error",
+		"-i", internalRTMPURL(streamKey),
+		"-vn", "-c:a", "aac", "-b:a", strconv.Itoa(audioOnlyBitrateKbps)+"k", "-ac", "1",
+		"-f", "flv", target)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, output)
+	}
+	return nil
+}
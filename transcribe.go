@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/Dong557799/zhibo-class/query"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultASRTimeout = 30 * time.Minute
+
+// 语音识别服务配置。服务需接收 multipart 上传的 file 字段（16kHz 单声道 WAV），
+// 返回 {"segments": [{"start_ms": 0, "end_ms": 1200, "text": "..."}]}
+type ASRConfig struct {
+	URL            string `json:"url"` // 为空时不转写
+	APIKey         string `json:"api_key"`
+	Language       string `json:"language"`
+	TimeoutSeconds int    `json:"timeout_seconds"`
+}
+
+// 转写片段，时间为相对录像开始的毫秒数
+type TranscriptSegment struct {
+	StartMs int    `json:"start_ms"`
+	EndMs   int    `json:"end_ms"`
+	Text    string `json:"text"`
+}
+
+func asrEnabled() bool {
+	return currentConfig().ASR.URL != ""
+}
+
+func ffmpegPath() string {
+	if p := currentConfig().FFmpegPath; p != "" {
+		return p
+	}
+	return "ffmpeg"
+}
+
+// 提取录像音频并提交语音识别，保存带时间戳的转写结果
+func transcribeRecording(rec Recording) (err error) {
+	setStatus := func(status string) {
+		db.Exec("UPDATE recordings SET transcript_status = ?, updated_at = NOW() WHERE id = ?", status, rec.ID)
+	}
+	setStatus(TranscriptProcessing)
+	defer func() {
+		if err != nil {
+			setStatus(TranscriptFailed)
+		}
+	}()
+
+	workDir, err := os.MkdirTemp("", fmt.Sprintf("recording-%d-", rec.ID))
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	audio, err := extractAudio(rec.Segments, workDir)
+	if err != nil {
+		return err
+	}
+	segments, err := requestTranscription(audio)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM recording_transcripts WHERE recording_id = ?", rec.ID); err != nil {
+		return err
+	}
+	for _, seg := range segments {
+		// 开头静音已从 MP4 中裁掉，时间轴随之前移
+		seg.StartMs -= rec.TrimMs
+		seg.EndMs -= rec.TrimMs
+		if seg.EndMs <= 0 {
+			continue
+		}
+		if seg.StartMs < 0 {
+			seg.StartMs = 0
+		}
+		_, err := tx.Exec(`
+			INSERT INTO recording_transcripts (recording_id, start_ms, end_ms, text)
+			VALUES (?, ?, ?, ?)
+		`, rec.ID, seg.StartMs, seg.EndMs, seg.Text)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE recordings SET transcript_status = ?, updated_at = NOW() WHERE id = ?", TranscriptReady, rec.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 生成 ffmpeg concat 输入列表
+func writeConcatList(segments []string, workDir string) (string, error) {
+	list := filepath.Join(workDir, "segments.txt")
+	var buf bytes.Buffer
+	for _, seg := range segments {
+		abs, err := filepath.Abs(seg)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&buf, "file '%s'\n", abs)
+	}
+	return list, os.WriteFile(list, buf.Bytes(), 0644)
+}
+
+// 用 ffmpeg 将录制分段拼接并转为 16kHz 单声道 WAV
+func extractAudio(segments []string, workDir string) (string, error) {
+	list, err := writeConcatList(segments, workDir)
+	if err != nil {
+		return "", err
+	}
+
+	out := filepath.Join(workDir, "audio.wav")
+	cmd := exec.Command(ffmpegPath(), "-hide_banner", "-loglevel", "error",
+		"-f", "concat", "-safe", "0", "-i", list,
+		"-vn", "-ac", "1", "-ar", "16000", "-y", out)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("ffmpeg failed: %v: %s", err, output)
+	}
+	return out, nil
+}
+
+// 上传音频到语音识别服务
+func requestTranscription(audioPath string) ([]TranscriptSegment, error) {
+	conf := currentConfig().ASR
+	file, err := os.Open(audioPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// 音频可能较大，边读边上传
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		part, err := form.CreateFormFile("file", filepath.Base(audioPath))
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		if err == nil && conf.Language != "" {
+			err = form.WriteField("language", conf.Language)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, conf.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if conf.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+conf.APIKey)
+	}
+
+	timeout := defaultASRTimeout
+	if conf.TimeoutSeconds > 0 {
+		timeout = time.Duration(conf.TimeoutSeconds) * time.Second
+	}
+	resp, err := (&http.Client{Timeout: timeout, Transport: httpClient.Transport}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("ASR request failed with status %d: %s", resp.StatusCode, msg)
+	}
+
+	var result struct {
+		Segments []TranscriptSegment `json:"segments"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.Segments, nil
+}
+
+// 录像转写文本，query 非空时只返回包含关键字的片段
+func getRecordingTranscript(c *gin.Context) {
+	rec, ok := recordingParam(c)
+	if !ok {
+		return
+	}
+
+	q := query.New().
+		Where("recording_id = ?", rec.ID).
+		Contains(c.Query("query"), "text")
+	rows, err := db.Query(`
+		SELECT start_ms, end_ms, text
+		FROM recording_transcripts
+		WHERE `+q.WhereSQL()+`
+		ORDER BY start_ms
+	`, q.Args()...)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeTranscriptGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	segments := []TranscriptSegment{}
+	for rows.Next() {
+		var seg TranscriptSegment
+		if err := rows.Scan(&seg.StartMs, &seg.EndMs, &seg.Text); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeTranscriptGetFailed)
+			return
+		}
+		segments = append(segments, seg)
+	}
+
+	respondOK(c, http.StatusOK, gin.H{
+		"recording_id": rec.ID,
+		"status":       rec.TranscriptStatus,
+		"segments":     segments,
+	})
+}
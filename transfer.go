@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const msgSessionTransferred = "session_transferred"
+
+func init() {
+	backplaneObservers = append(backplaneObservers, observeRemoteSessionTransfer)
+}
+
+// 把未结束的会话移交给其他老师，如原授课老师临时请假时由管理员代为安排；
+// 移交后签发新的推流码，原推流码立即失效
+func transferLiveSession(c *gin.Context) {
+	id, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		TeacherID int    `json:"teacher_id" binding:"required,gt=0"`
+		Reason    string `json:"reason" binding:"max=255"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	unlock, ok := lockSession(c, id)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	var courseID, previousID int
+	var status, oldKey string
+	err := db.QueryRow("SELECT course_id, teacher_id, status, stream_key FROM live_sessions WHERE id = ?", id).
+		Scan(&courseID, &previousID, &status, &oldKey)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSessionNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		return
+	}
+	if status == "ended" {
+		respondError(c, http.StatusConflict, CodeSessionAlreadyEnded)
+		return
+	}
+	if req.TeacherID == previousID {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "teacher_id")
+		return
+	}
+
+	// 接手的老师需要有管理会话的权限
+	teacher, err := getUser(req.TeacherID)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeUserNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeUserGetFailed)
+		return
+	}
+	if teacher.Status != "active" || !hasPermission(teacher.Role, PermSessionManage) {
+		respondError(c, http.StatusBadRequest, CodeInvalidParam, "teacher_id")
+		return
+	}
+
+	newKey := generateStreamKey()
+	if err := media().CreateStream(c.Request.Context(), newKey); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeStreamCreateFailed)
+		return
+	}
+	_, err = db.Exec("UPDATE live_sessions SET teacher_id = ?, stream_key = ? WHERE id = ?", req.TeacherID, newKey, id)
+	if err != nil {
+		if err := media().DeleteStream(context.Background(), newKey); err != nil {
+			log.Printf("Failed to delete stream %s: %v", newKey, err)
+		}
+		respondError(c, http.StatusInternalServerError, CodeSessionTransferFailed)
+		return
+	}
+	if err := media().DeleteStream(context.Background(), oldKey); err != nil {
+		log.Printf("Failed to delete previous stream of session %d: %v", id, err)
+	}
+
+	recordAudit(c, "transfer_session", "session", id, gin.H{
+		"from_teacher_id": previousID,
+		"to_teacher_id":   req.TeacherID,
+		"reason":          req.Reason,
+	})
+	data := gin.H{
+		"session_id":          id,
+		"course_id":           courseID,
+		"teacher_id":          req.TeacherID,
+		"teacher_name":        teacher.Name,
+		"previous_teacher_id": previousID,
+		"reason":              req.Reason,
+	}
+	applySessionOwner(id, req.TeacherID)
+	hub.broadcast(sessionRoom(id), Message{Type: msgSessionTransferred, Data: data})
+	publishMQTT(courseID, "session", data)
+	emitEvent(EventSessionTransferred, courseID, data)
+	// 接手的老师可能不在直播间，通过站内通知告知
+	if err := notifyUsers([]int{req.TeacherID}, NotifySessionTransferred, data); err != nil {
+		log.Printf("Failed to notify teacher of transferred session %d: %v", id, err)
+	}
+
+	respondOK(c, http.StatusOK, gin.H{
+		"session_id": id,
+		"teacher_id": req.TeacherID,
+		"status":     status,
+		"stream_key": newKey,
+	})
+}
+
+// 按新的授课老师更新本实例上会话连接的权限，原老师离开教师房间，新老师加入
+func applySessionOwner(sessionID, teacherID int) {
+	for _, room := range []string{sessionRoom(sessionID), whiteboardRoom(sessionID)} {
+		for _, c := range hub.clients(room) {
+			owner := c.role == RoleAdmin || c.userID == teacherID
+			c.mu.Lock()
+			changed := c.owner != owner
+			c.owner = owner
+			c.mu.Unlock()
+			if !changed || room != sessionRoom(sessionID) {
+				continue
+			}
+			if owner {
+				hub.join(c, teacherRoom(sessionID))
+			} else {
+				hub.leave(c, teacherRoom(sessionID))
+			}
+		}
+	}
+}
+
+// 其他副本移交会话后同步本实例连接的权限
+func observeRemoteSessionTransfer(room string, payload []byte) {
+	if !strings.HasPrefix(room, "session:") || !bytes.Contains(payload, []byte(msgSessionTransferred)) {
+		return
+	}
+	var msg struct {
+		Type string `json:"type"`
+		Data struct {
+			SessionID int `json:"session_id"`
+			TeacherID int `json:"teacher_id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &msg); err != nil || msg.Type != msgSessionTransferred {
+		return
+	}
+	applySessionOwner(msg.Data.SessionID, msg.Data.TeacherID)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// 题目类型
+const (
+	QuestionSingleChoice   = "single_choice"
+	QuestionMultipleChoice = "multiple_choice"
+	QuestionTrueFalse      = "true_false"
+	QuestionFillBlank      = "fill_blank"
+	QuestionShortAnswer    = "short_answer"
+)
+
+var questionTypes = []string{
+	QuestionSingleChoice,
+	QuestionMultipleChoice,
+	QuestionTrueFalse,
+	QuestionFillBlank,
+	QuestionShortAnswer,
+}
+
+// 选择题至少需要的选项数量
+const minChoiceOptions = 2
+
+// 字段校验错误
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// 校验规则对应的错误信息，%[1]s 为字段名，%[2]s 为规则参数
+var ruleMessages = map[string]map[string]string{
+	"required":      {langEN: "%[1]s is required", langZH: "%[1]s 为必填项"},
+	"oneof":         {langEN: "%[1]s must be one of: %[2]s", langZH: "%[1]s 必须是以下值之一：%[2]s"},
+	"min":           {langEN: "%[1]s must be at least %[2]s", langZH: "%[1]s 不能小于 %[2]s"},
+	"max":           {langEN: "%[1]s must be at most %[2]s", langZH: "%[1]s 不能大于 %[2]s"},
+	"gt":            {langEN: "%[1]s must be greater than %[2]s", langZH: "%[1]s 必须大于 %[2]s"},
+	"gte":           {langEN: "%[1]s must be at least %[2]s", langZH: "%[1]s 不能小于 %[2]s"},
+	"lte":           {langEN: "%[1]s must be at most %[2]s", langZH: "%[1]s 不能大于 %[2]s"},
+	"type":          {langEN: "%[1]s must be of type %[2]s", langZH: "%[1]s 的类型应为 %[2]s"},
+	"question_type": {langEN: "%[1]s must be a valid question type", langZH: "%[1]s 不是有效的题目类型"},
+	"min_options":   {langEN: "choice questions need at least %[2]s options", langZH: "选择题至少需要 %[2]s 个选项"},
+	"scoring_type":  {langEN: "%[1]s other than all_or_nothing requires a %[2]s question", langZH: "只有 %[2]s 题型可以设置 %[1]s 为部分得分"},
+	"max_media":     {langEN: "%[1]s may contain at most %[2]s blocks", langZH: "%[1]s 最多包含 %[2]s 类型的内容块"},
+	"latex":         {langEN: "%[1]s must be a valid LaTeX formula without file or link commands", langZH: "%[1]s 不是有效的公式，且不能包含文件或链接命令"},
+	"invalid":       {langEN: "%[1]s is invalid", langZH: "%[1]s 无效"},
+}
+
+func init() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+
+	// 错误中使用 JSON 字段名
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+
+	v.RegisterValidation("question_type", func(fl validator.FieldLevel) bool {
+		return contains(questionTypes, fl.Field().String())
+	})
+	v.RegisterStructValidation(validateQuestion, Question{})
+}
+
+// 选择题的选项数量、计分方式和富文本内容块校验
+func validateQuestion(sl validator.StructLevel) {
+	q := sl.Current().Interface().(Question)
+	validateQuestionBlocks(sl, q.Blocks)
+	if q.Scoring != "" && q.Scoring != ScoringAllOrNothing && q.Type != QuestionMultipleChoice {
+		sl.ReportError(q.Scoring, "scoring", "Scoring", "scoring_type", QuestionMultipleChoice)
+	}
+	if q.Type != QuestionSingleChoice && q.Type != QuestionMultipleChoice {
+		return
+	}
+	if len(q.Options) < minChoiceOptions {
+		sl.ReportError(q.Options, "options", "Options", "min_options", fmt.Sprint(minChoiceOptions))
+	}
+}
+
+// 将绑定错误转换为字段级错误
+func fieldErrors(err error, lang string) []FieldError {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		result := make([]FieldError, 0, len(validationErrs))
+		for _, fe := range validationErrs {
+			result = append(result, FieldError{
+				Field:   fieldPath(fe),
+				Rule:    fe.Tag(),
+				Param:   fe.Param(),
+				Message: ruleMessage(fe.Tag(), lang, fieldPath(fe), strings.ReplaceAll(fe.Param(), " ", ", ")),
+			})
+		}
+		return result
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return []FieldError{{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Param:   typeErr.Type.String(),
+			Message: ruleMessage("type", lang, typeErr.Field, typeErr.Type.String()),
+		}}
+	}
+
+	return nil
+}
+
+// 去掉顶层结构体名，保留嵌套路径，如 assignments[0].group_id
+func fieldPath(fe validator.FieldError) string {
+	ns := fe.Namespace()
+	if i := strings.Index(ns, "."); i >= 0 {
+		return ns[i+1:]
+	}
+	return fe.Field()
+}
+
+func ruleMessage(rule, lang, field, param string) string {
+	messages, ok := ruleMessages[rule]
+	if !ok {
+		messages = ruleMessages["invalid"]
+	}
+	format, ok := messages[lang]
+	if !ok {
+		format = messages[defaultLang]
+	}
+	return fmt.Sprintf(format, field, param)
+}
+
+// 返回请求参数绑定错误，能解析到字段时返回字段级错误列表
+func respondBindError(c *gin.Context, err error) {
+	if limit, ok := bodyTooLarge(err); ok {
+		respondError(c, http.StatusRequestEntityTooLarge, CodeBodyTooLarge, limit)
+		return
+	}
+
+	lang := requestLang(c)
+	var detail interface{} = err.Error()
+	if errs := fieldErrors(err, lang); errs != nil {
+		detail = gin.H{"fields": errs}
+	}
+	writeError(c, http.StatusBadRequest, CodeInvalidRequest, localize(CodeInvalidRequest, lang), detail)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	waitlistInterval = 5 * time.Second
+	// 超过该时间没有刷新的候补记录视为已离开，实例异常退出后不再占用排位
+	waitlistTTL = 6 * waitlistInterval
+)
+
+// 候补中的学生
+type WaitlistEntry struct {
+	UserID   int       `json:"user_id"`
+	Position int       `json:"position"`
+	QueuedAt time.Time `json:"queued_at"`
+}
+
+var (
+	waitingMu      sync.Mutex
+	waitingClients = make(map[*Client]bool)
+	waitlistWake   = make(chan struct{}, 1)
+)
+
+func seatLockName(sessionID int) string { return fmt.Sprintf("zhibo:seats:%d", sessionID) }
+
+// 有学生离开或上限变化时立即检查候补队列，其他副本最迟在 waitlistInterval 后检查
+func wakeWaitlist() {
+	select {
+	case waitlistWake <- struct{}{}:
+	default:
+	}
+}
+
+// 进入会话。会话已满时学生进入候补队列，只接收候补通知，不加入会话房间也不计入在线人数
+func (c *Client) enterSession() {
+	if c.role == RoleStudent && !c.isOwner() {
+		admitted, position, err := claimSeat(c.sessionID, c.userID)
+		if err != nil {
+			// 无法判断人数时不阻止学生进入
+			log.Printf("Failed to claim seat for user %d in session %d: %v", c.userID, c.sessionID, err)
+		} else if !admitted {
+			c.mu.Lock()
+			c.waiting = true
+			c.waitPosition = position
+			c.mu.Unlock()
+			waitingMu.Lock()
+			waitingClients[c] = true
+			waitingMu.Unlock()
+			c.sendMessage(Message{Type: "waitlisted", Data: gin.H{"position": position}})
+			notifyWaitlist(c.sessionID)
+			return
+		}
+	}
+	c.joinSessionRooms()
+	c.sendSnapshot()
+	c.repushOpenQuestion()
+}
+
+func (c *Client) isWaiting() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.waiting
+}
+
+// 为学生争取名额，已满时登记或刷新候补记录并返回排位；同一学生的其他连接已在会话中时直接进入
+func claimSeat(sessionID, userID int) (bool, int, error) {
+	settings, err := loadSessionSettings(sessionID)
+	if err != nil {
+		return false, 0, err
+	}
+	if settings.MaxParticipants <= 0 {
+		return true, 0, nil
+	}
+
+	unlock, err := acquireLock(seatLockName(sessionID), lockWait)
+	if err != nil {
+		return false, 0, err
+	}
+	defer unlock()
+
+	online, err := onlineUserIDs(sessionID, RoleStudent)
+	if err != nil {
+		return false, 0, err
+	}
+	for _, id := range online {
+		if id == userID {
+			return true, 0, nil
+		}
+	}
+
+	now := time.Now().UTC()
+	queuedAt := now
+	err = db.QueryRow("SELECT queued_at FROM session_waitlist WHERE session_id = ? AND user_id = ?", sessionID, userID).Scan(&queuedAt)
+	if err == sql.ErrNoRows {
+		_, err = db.Exec("INSERT INTO session_waitlist (session_id, user_id, queued_at, seen_at) VALUES (?, ?, ?, ?)",
+			sessionID, userID, queuedAt, now)
+	} else if err == nil {
+		_, err = db.Exec("UPDATE session_waitlist SET seen_at = ? WHERE session_id = ? AND user_id = ?", now, sessionID, userID)
+	}
+	if err != nil {
+		return false, 0, err
+	}
+
+	var ahead int
+	err = db.QueryRow(`
+		SELECT COUNT(*) FROM session_waitlist
+		WHERE session_id = ? AND seen_at > ? AND (queued_at < ? OR (queued_at = ? AND user_id < ?))
+	`, sessionID, now.Add(-waitlistTTL), queuedAt, queuedAt, userID).Scan(&ahead)
+	if err != nil {
+		return false, 0, err
+	}
+	if len(online)+ahead < settings.MaxParticipants {
+		_, err := db.Exec("DELETE FROM session_waitlist WHERE session_id = ? AND user_id = ?", sessionID, userID)
+		return err == nil, 0, err
+	}
+	return false, ahead + 1, nil
+}
+
+// 重新检查候补的连接，有空位时进入会话，排位变化时通知学生
+func (c *Client) retrySeat() {
+	admitted, position, err := claimSeat(c.sessionID, c.userID)
+	if err != nil {
+		log.Printf("Failed to claim seat for user %d in session %d: %v", c.userID, c.sessionID, err)
+		return
+	}
+
+	c.admitMu.Lock()
+	defer c.admitMu.Unlock()
+	if c.closed {
+		return
+	}
+	if !admitted {
+		c.mu.Lock()
+		changed := c.waitPosition != position
+		c.waitPosition = position
+		c.mu.Unlock()
+		if changed {
+			c.sendMessage(Message{Type: "waitlisted", Data: gin.H{"position": position}})
+		}
+		return
+	}
+
+	waitingMu.Lock()
+	delete(waitingClients, c)
+	waitingMu.Unlock()
+	// 取消人数上限后放行时候补记录仍在
+	if _, err := db.Exec("DELETE FROM session_waitlist WHERE session_id = ? AND user_id = ?", c.sessionID, c.userID); err != nil {
+		log.Printf("Failed to remove user %d from waitlist of session %d: %v", c.userID, c.sessionID, err)
+	}
+	c.mu.Lock()
+	c.waiting = false
+	c.mu.Unlock()
+	c.joinSessionRooms()
+	c.sendMessage(Message{Type: "admitted", Data: gin.H{"session_id": c.sessionID}})
+	c.sendSnapshot()
+	c.repushOpenQuestion()
+	notifyWaitlist(c.sessionID)
+}
+
+// 连接断开时释放名额或候补排位
+func (c *Client) leaveSeat() {
+	c.admitMu.Lock()
+	c.closed = true
+	c.admitMu.Unlock()
+
+	if c.role != RoleStudent {
+		return
+	}
+	if !c.isWaiting() {
+		wakeWaitlist()
+		return
+	}
+	waitingMu.Lock()
+	delete(waitingClients, c)
+	others := false
+	for other := range waitingClients {
+		if other.sessionID == c.sessionID && other.userID == c.userID {
+			others = true
+		}
+	}
+	waitingMu.Unlock()
+	if !others {
+		if _, err := db.Exec("DELETE FROM session_waitlist WHERE session_id = ? AND user_id = ?", c.sessionID, c.userID); err != nil {
+			log.Printf("Failed to remove user %d from waitlist of session %d: %v", c.userID, c.sessionID, err)
+		}
+		notifyWaitlist(c.sessionID)
+	}
+}
+
+// 通知会话的老师当前候补人数
+func notifyWaitlist(sessionID int) {
+	var waiting int
+	err := db.QueryRow("SELECT COUNT(*) FROM session_waitlist WHERE session_id = ? AND seen_at > ?",
+		sessionID, time.Now().UTC().Add(-waitlistTTL)).Scan(&waiting)
+	if err != nil {
+		log.Printf("Failed to count waitlist of session %d: %v", sessionID, err)
+		return
+	}
+	hub.broadcast(teacherRoom(sessionID), Message{Type: "waitlist_updated", Data: gin.H{"waiting": waiting}})
+}
+
+// 定期为本实例候补的连接刷新排位，有空位时按排位放行
+func runWaitlistAdmitter() {
+	ticker := time.NewTicker(waitlistInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-waitlistWake:
+		}
+		waitingMu.Lock()
+		clients := make([]*Client, 0, len(waitingClients))
+		for c := range waitingClients {
+			clients = append(clients, c)
+		}
+		waitingMu.Unlock()
+		for _, c := range clients {
+			c.retrySeat()
+		}
+	}
+}
+
+// 会话的候补队列，按排位排序
+func getSessionWaitlist(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	if !sessionExists(c, sessionID) {
+		return
+	}
+	rows, err := db.Query(`
+		SELECT user_id, queued_at FROM session_waitlist
+		WHERE session_id = ? AND seen_at > ?
+		ORDER BY queued_at, user_id
+	`, sessionID, time.Now().UTC().Add(-waitlistTTL))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeWaitlistGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	loc := requestLocation(c)
+	entries := []WaitlistEntry{}
+	for rows.Next() {
+		var e WaitlistEntry
+		if err := rows.Scan(&e.UserID, &e.QueuedAt); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeWaitlistGetFailed)
+			return
+		}
+		e.Position = len(entries) + 1
+		e.QueuedAt = e.QueuedAt.In(loc)
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeWaitlistGetFailed)
+		return
+	}
+	respondOK(c, http.StatusOK, gin.H{"count": len(entries), "users": entries})
+}
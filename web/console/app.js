@@ -0,0 +1,279 @@
+// 教师控制台：登录后创建或打开直播会话、推送题目并实时查看作答结果
+(function () {
+  'use strict';
+
+  var RESULT_POLL_MS = 2000;
+  var MAX_EVENTS = 100;
+
+  var state = {
+    token: localStorage.getItem('console.token') || '',
+    refreshToken: localStorage.getItem('console.refresh') || '',
+    user: JSON.parse(localStorage.getItem('console.user') || 'null'),
+    courseID: localStorage.getItem('console.course') || '',
+    session: null,
+    questionID: 0,
+    poller: 0,
+    ws: null
+  };
+
+  function $(id) { return document.getElementById(id); }
+
+  function showError(msg) {
+    var el = $('error');
+    el.textContent = msg || '';
+    el.hidden = !msg;
+  }
+
+  // 统一处理 {code, message, data} 响应；访问令牌过期时用刷新令牌换取新令牌后重试一次
+  function api(method, path, body, retried) {
+    var opts = { method: method, headers: { 'Accept-Language': 'zh' } };
+    if (state.token) opts.headers.Authorization = 'Bearer ' + state.token;
+    if (body !== undefined) {
+      opts.headers['Content-Type'] = 'application/json';
+      opts.body = JSON.stringify(body);
+    }
+    return fetch(path, opts).then(function (resp) {
+      return resp.json().then(function (payload) {
+        if (resp.status === 401 && (state.refreshToken || refreshing) && !retried) {
+          return refresh().then(function () { return api(method, path, body, true); });
+        }
+        if (resp.status === 401) logout();
+        if (!resp.ok) throw new Error(payload.message || resp.statusText);
+        return payload.data;
+      });
+    });
+  }
+
+  function saveTokens(data) {
+    state.token = data.token;
+    state.refreshToken = data.refresh_token || '';
+    localStorage.setItem('console.token', state.token);
+    localStorage.setItem('console.refresh', state.refreshToken);
+  }
+
+  // 同时过期的多个请求共用一次刷新，刷新令牌只能使用一次
+  var refreshing = null;
+  function refresh() {
+    if (!refreshing) {
+      refreshing = api('POST', '/api/auth/refresh', { refresh_token: state.refreshToken }, true)
+        .then(saveTokens)
+        .finally(function () { refreshing = null; });
+    }
+    return refreshing;
+  }
+
+  function run(promise) {
+    showError('');
+    return promise.catch(function (err) { showError(err.message); });
+  }
+
+  function render() {
+    var loggedIn = !!state.token;
+    $('login-panel').hidden = loggedIn;
+    $('app').hidden = !loggedIn;
+    $('whoami').hidden = !loggedIn;
+    if (loggedIn && state.user) $('user-name').textContent = state.user.name + '（' + state.user.role + '）';
+    document.querySelector('#course-form [name=course_id]').value = state.courseID;
+  }
+
+  function logout() {
+    state.token = '';
+    state.refreshToken = '';
+    state.user = null;
+    localStorage.removeItem('console.token');
+    localStorage.removeItem('console.refresh');
+    localStorage.removeItem('console.user');
+    closeSocket();
+    render();
+  }
+
+  // ---- 会话 ----
+
+  function showPublishInfo(info) {
+    var p = info.profile;
+    $('obs-server').textContent = info.server;
+    $('stream-key').textContent = info.publish_key;
+    $('key-expires').textContent = new Date(info.expires_at).toLocaleString();
+    $('obs-output').textContent = p.width + '×' + p.height + ' ' + p.fps + 'fps，视频 ' +
+      p.video_bitrate_kbps + ' Kbps（' + info.rate_control + '），音频 ' + p.audio_bitrate_kbps +
+      ' Kbps，关键帧间隔 ' + p.keyframe_interval + ' 秒';
+  }
+
+  function showSession(session) {
+    state.session = session;
+    state.courseID = String(session.course_id);
+    localStorage.setItem('console.course', state.courseID);
+    $('session').hidden = false;
+    $('session-id').textContent = '#' + session.id;
+    $('session-status').textContent = session.status;
+    run(api('GET', '/api/live/sessions/' + session.id + '/publish-info').then(showPublishInfo));
+    var urls = session.play_urls || {};
+    $('play-urls').innerHTML = '';
+    Object.keys(urls).sort().forEach(function (name) {
+      var div = document.createElement('div');
+      div.textContent = name + ': ' + urls[name];
+      $('play-urls').appendChild(div);
+    });
+    $('start-session').disabled = session.status !== 'pending';
+    $('end-session').disabled = session.status !== 'live';
+    render();
+    loadQuestions();
+    openSocket();
+  }
+
+  function loadSession(id) {
+    return api('GET', '/api/live/sessions/' + id).then(showSession);
+  }
+
+  $('course-form').addEventListener('submit', function (e) {
+    e.preventDefault();
+    var courseID = parseInt(e.target.course_id.value, 10);
+    run(api('POST', '/api/live/sessions', { course_id: courseID }).then(showSession));
+  });
+
+  $('load-form').addEventListener('submit', function (e) {
+    e.preventDefault();
+    run(loadSession(e.target.session_id.value));
+  });
+
+  $('start-session').addEventListener('click', function () {
+    run(api('POST', '/api/live/sessions/' + state.session.id + '/start').then(function () {
+      return loadSession(state.session.id);
+    }));
+  });
+
+  $('end-session').addEventListener('click', function () {
+    if (!confirm('确定结束直播？')) return;
+    run(api('POST', '/api/live/sessions/' + state.session.id + '/end').then(function () {
+      return loadSession(state.session.id);
+    }));
+  });
+
+  // ---- 题目 ----
+
+  function loadQuestions() {
+    if (!state.courseID) return;
+    run(api('GET', '/api/question/list?page_size=100&course_id=' + state.courseID).then(function (questions) {
+      var tbody = $('questions');
+      tbody.innerHTML = '';
+      (questions || []).forEach(function (q) {
+        var tr = document.createElement('tr');
+        [q.id, q.type, q.content, q.answer].forEach(function (v) {
+          var td = document.createElement('td');
+          td.textContent = v;
+          tr.appendChild(td);
+        });
+        var td = document.createElement('td');
+        var btn = document.createElement('button');
+        btn.textContent = '推送';
+        btn.addEventListener('click', function () { pushQuestion(q); });
+        td.appendChild(btn);
+        tr.appendChild(td);
+        tbody.appendChild(tr);
+      });
+    }));
+  }
+
+  $('question-form').addEventListener('submit', function (e) {
+    e.preventDefault();
+    var f = e.target;
+    var options = f.options.value.split(',').map(function (s) { return s.trim(); }).filter(Boolean);
+    run(api('POST', '/api/question/create', {
+      course_id: parseInt(state.courseID, 10),
+      type: f.type.value,
+      content: f.content.value,
+      options: options,
+      answer: f.answer.value
+    }).then(function () {
+      f.reset();
+      loadQuestions();
+    }));
+  });
+
+  function pushQuestion(q) {
+    run(api('GET', '/api/question/push/' + state.courseID + '/' + q.id).then(function () {
+      state.questionID = q.id;
+      $('result-question').textContent = '#' + q.id + ' ' + q.content;
+      clearInterval(state.poller);
+      refreshResult();
+      state.poller = setInterval(refreshResult, RESULT_POLL_MS);
+    }));
+  }
+
+  function refreshResult() {
+    var id = state.questionID;
+    api('GET', '/api/question/result/' + id).then(function (r) {
+      var rate = r.total_count ? Math.round(r.correct_count * 100 / r.total_count) : 0;
+      $('result').innerHTML =
+        '<div>作答 <b>' + r.total_count + '</b></div>' +
+        '<div>答对 <b>' + r.correct_count + '</b></div>' +
+        '<div>正确率 <b>' + rate + '%</b></div>';
+    }).catch(function (err) { showError(err.message); });
+    api('GET', '/api/question/leaderboard/' + id).then(function (board) {
+      var ol = $('leaderboard');
+      ol.innerHTML = '';
+      (board.entries || []).forEach(function (e) {
+        var li = document.createElement('li');
+        li.textContent = '学生 ' + e.student_id + ' · ' + e.latency_ms + ' ms';
+        ol.appendChild(li);
+      });
+    }).catch(function () {});
+  }
+
+  // ---- 课堂动态 ----
+
+  function closeSocket() {
+    if (state.ws) {
+      state.ws.onclose = null;
+      state.ws.close();
+      state.ws = null;
+    }
+  }
+
+  function openSocket() {
+    closeSocket();
+    if (!state.session || !state.user) return;
+    var proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
+    var url = proto + '//' + location.host + '/api/live/sessions/' + state.session.id +
+      '/ws?access_token=' + encodeURIComponent(state.token);
+    var ws = new WebSocket(url);
+    ws.onmessage = function (e) {
+      var msg;
+      try { msg = JSON.parse(e.data); } catch (err) { return; }
+      logEvent(msg);
+    };
+    ws.onclose = function () {
+      setTimeout(openSocket, RESULT_POLL_MS);
+    };
+    state.ws = ws;
+  }
+
+  function logEvent(msg) {
+    var list = $('events');
+    var li = document.createElement('li');
+    var time = new Date().toLocaleTimeString();
+    li.textContent = time + ' ' + msg.type + (msg.data !== undefined ? ' ' + JSON.stringify(msg.data) : '');
+    list.insertBefore(li, list.firstChild);
+    while (list.children.length > MAX_EVENTS) list.removeChild(list.lastChild);
+  }
+
+  // ---- 登录 ----
+
+  $('login-form').addEventListener('submit', function (e) {
+    e.preventDefault();
+    var f = e.target;
+    run(api('POST', '/api/auth/login', { username: f.username.value, password: f.password.value }).then(function (data) {
+      saveTokens(data);
+      state.user = data.user;
+      localStorage.setItem('console.user', JSON.stringify(data.user));
+      f.reset();
+      render();
+      loadQuestions();
+    }));
+  });
+
+  $('logout').addEventListener('click', logout);
+
+  render();
+  if (state.token) loadQuestions();
+})();
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v7"
+)
+
+const (
+	whiteboardFlushInterval = 200 * time.Millisecond
+	whiteboardFlushSize     = 200
+	whiteboardQueueSize     = 10000
+	maxWhiteboardOpsPage    = 1000
+)
+
+// 白板操作
+type WhiteboardOp struct {
+	Seq       int64           `json:"seq"`
+	SessionID int             `json:"session_id"`
+	UserID    int             `json:"user_id"`
+	Kind      string          `json:"kind"`
+	Op        json.RawMessage `json:"op"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// 单个会话的白板状态
+type whiteboard struct {
+	mu      sync.Mutex
+	lastSeq int64          // 本实例已知的最大序号
+	ops     []WhiteboardOp // 最近一次清屏之后的操作，用于晚加入者快照
+}
+
+// 启用 Redis 时会话白板的序号计数器，多个副本共用；
+// 计数器丢失时从调用方已知的最大序号继续，避免与已写入的操作冲突
+var whiteboardSeqScript = redis.NewScript(`
+local v = redis.call('INCR', KEYS[1])
+local floor = tonumber(ARGV[1])
+if v <= floor then
+	v = floor + 1
+	redis.call('SET', KEYS[1], v)
+end
+return v
+`)
+
+func whiteboardSeqKey(sessionID int) string { return fmt.Sprintf("zhibo:whiteboard:%d:seq", sessionID) }
+
+var (
+	whiteboardsMu sync.Mutex
+	whiteboards   = make(map[int]*whiteboard)
+	whiteboardLog = make(chan WhiteboardOp, whiteboardQueueSize)
+
+	whiteboardHandlers = map[string]wsHandler{}
+)
+
+func whiteboardRoom(sessionID int) string { return fmt.Sprintf("whiteboard:%d", sessionID) }
+
+// 获取会话白板，首次使用时从操作日志恢复
+func getWhiteboard(sessionID int) (*whiteboard, error) {
+	whiteboardsMu.Lock()
+	defer whiteboardsMu.Unlock()
+
+	if wb, ok := whiteboards[sessionID]; ok {
+		return wb, nil
+	}
+
+	wb := &whiteboard{}
+	var maxSeq int64
+	if err := db.QueryRow(`
+		SELECT COALESCE(MAX(seq), 0) FROM whiteboard_ops WHERE session_id = ?
+	`, sessionID).Scan(&maxSeq); err != nil {
+		return nil, err
+	}
+	wb.lastSeq = maxSeq
+
+	ops, err := loadWhiteboardSnapshot(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	wb.ops = ops
+
+	whiteboards[sessionID] = wb
+	return wb, nil
+}
+
+// 从数据库加载最近一次清屏之后的操作
+func loadWhiteboardSnapshot(sessionID int) ([]WhiteboardOp, error) {
+	rows, err := db.Query(`
+		SELECT seq, session_id, user_id, kind, op, created_at
+		FROM whiteboard_ops
+		WHERE session_id = ? AND seq >= (
+			SELECT COALESCE(MAX(seq), 0) FROM whiteboard_ops WHERE session_id = ? AND kind = 'clear'
+		)
+		ORDER BY seq
+	`, sessionID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWhiteboardOps(rows)
+}
+
+func scanWhiteboardOps(rows *sql.Rows) ([]WhiteboardOp, error) {
+	ops := []WhiteboardOp{}
+	for rows.Next() {
+		var op WhiteboardOp
+		var raw string
+		if err := rows.Scan(&op.Seq, &op.SessionID, &op.UserID, &op.Kind, &raw, &op.CreatedAt); err != nil {
+			return nil, err
+		}
+		op.Op = json.RawMessage(raw)
+		ops = append(ops, op)
+	}
+	return ops, rows.Err()
+}
+
+// 分配序号、更新快照并排队持久化
+func (wb *whiteboard) apply(sessionID, userID int, data interface{}) (WhiteboardOp, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return WhiteboardOp{}, err
+	}
+
+	kind := "draw"
+	if m, ok := data.(map[string]interface{}); ok {
+		if k, ok := m["kind"].(string); ok && k != "" {
+			kind = k
+		}
+	}
+
+	wb.mu.Lock()
+	seq, err := wb.nextSeq(sessionID)
+	if err != nil {
+		wb.mu.Unlock()
+		return WhiteboardOp{}, err
+	}
+	op := WhiteboardOp{
+		Seq:       seq,
+		SessionID: sessionID,
+		UserID:    userID,
+		Kind:      kind,
+		Op:        raw,
+		CreatedAt: time.Now(),
+	}
+	wb.record(op)
+	wb.mu.Unlock()
+
+	select {
+	case whiteboardLog <- op:
+	default:
+		log.Printf("Whiteboard log queue full, op %d of session %d not persisted", op.Seq, sessionID)
+	}
+	return op, nil
+}
+
+// 分配下一个序号，需持有 wb.mu。多副本部署时由 Redis 分配，保证同一会话内不重复
+func (wb *whiteboard) nextSeq(sessionID int) (int64, error) {
+	if redisClient == nil {
+		return wb.lastSeq + 1, nil
+	}
+	return whiteboardSeqScript.Run(redisClient, []string{whiteboardSeqKey(sessionID)}, wb.lastSeq).Int64()
+}
+
+// 按序号将操作加入快照，需持有 wb.mu；其他副本的操作可能乱序到达
+func (wb *whiteboard) record(op WhiteboardOp) {
+	if op.Seq > wb.lastSeq {
+		wb.lastSeq = op.Seq
+	}
+	if len(wb.ops) > 0 && wb.ops[0].Kind == "clear" && op.Seq < wb.ops[0].Seq {
+		return
+	}
+	if op.Kind == "clear" {
+		kept := wb.ops[:0]
+		for _, o := range wb.ops {
+			if o.Seq > op.Seq {
+				kept = append(kept, o)
+			}
+		}
+		wb.ops = append([]WhiteboardOp{op}, kept...)
+		return
+	}
+
+	i := sort.Search(len(wb.ops), func(i int) bool { return wb.ops[i].Seq > op.Seq })
+	wb.ops = append(wb.ops, WhiteboardOp{})
+	copy(wb.ops[i+1:], wb.ops[i:])
+	wb.ops[i] = op
+}
+
+// 其他副本广播的白板操作同步到本实例已加载的快照
+func observeRemoteWhiteboardOp(room string, payload []byte) {
+	if !strings.HasPrefix(room, "whiteboard:") {
+		return
+	}
+	var msg struct {
+		Type string       `json:"type"`
+		Data WhiteboardOp `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &msg); err != nil || msg.Type != "op" {
+		return
+	}
+
+	whiteboardsMu.Lock()
+	wb, ok := whiteboards[msg.Data.SessionID]
+	whiteboardsMu.Unlock()
+	if !ok {
+		return
+	}
+	wb.mu.Lock()
+	wb.record(msg.Data)
+	wb.mu.Unlock()
+}
+
+// 当前快照中序号大于 since 的操作
+func (wb *whiteboard) snapshot(since int64) []WhiteboardOp {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	result := make([]WhiteboardOp, 0, len(wb.ops))
+	for _, op := range wb.ops {
+		if op.Seq > since {
+			result = append(result, op)
+		}
+	}
+	return result
+}
+
+// 批量写入白板操作日志
+func runWhiteboardWriter() {
+	ticker := time.NewTicker(whiteboardFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]WhiteboardOp, 0, whiteboardFlushSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := persistWhiteboardOps(batch); err != nil {
+			log.Printf("Failed to persist %d whiteboard ops: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case op := <-whiteboardLog:
+			batch = append(batch, op)
+			if len(batch) >= whiteboardFlushSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func persistWhiteboardOps(ops []WhiteboardOp) error {
+	placeholders := make([]string, 0, len(ops))
+	args := make([]interface{}, 0, len(ops)*6)
+	for _, op := range ops {
+		placeholders = append(placeholders, "(?, ?, ?, ?, ?, ?)")
+		args = append(args, op.SessionID, op.Seq, op.UserID, op.Kind, string(op.Op), op.CreatedAt)
+	}
+
+	// 重复的序号（如 Redis 计数器重置后）只跳过该行，不影响同批其他操作
+	_, err := db.Exec(dialect.insertIgnore(`
+		INSERT INTO whiteboard_ops (session_id, seq, user_id, kind, op, created_at)
+		VALUES `+strings.Join(placeholders, ", ")), args...)
+	return err
+}
+
+// 建立白板 WebSocket 连接，连接后先下发当前快照
+func serveWhiteboardWS(c *gin.Context) {
+	client, ok := acceptClient(c, whiteboardHandlers)
+	if !ok {
+		return
+	}
+
+	wb, err := getWhiteboard(client.sessionID)
+	if err != nil {
+		log.Printf("Failed to load whiteboard for session %d: %v", client.sessionID, err)
+		client.conn.Close()
+		return
+	}
+
+	hub.join(client, whiteboardRoom(client.sessionID))
+	client.sendMessage(Message{Type: "snapshot", Data: wb.snapshot(0)})
+
+	go client.writePump()
+	client.readPump()
+}
+
+// 获取白板快照，since 用于断线重连后的增量同步
+func getWhiteboardSnapshot(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	since, _ := strconv.ParseInt(c.DefaultQuery("since", "0"), 10, 64)
+
+	wb, err := getWhiteboard(sessionID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeWhiteboardGetFailed)
+		return
+	}
+
+	respondOK(c, http.StatusOK, gin.H{"ops": wb.snapshot(since)})
+}
+
+// 获取白板操作日志，用于回放
+func getWhiteboardOps(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	fromSeq, _ := strconv.ParseInt(c.DefaultQuery("from_seq", "0"), 10, 64)
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "500"))
+	if err != nil || limit <= 0 || limit > maxWhiteboardOpsPage {
+		limit = maxWhiteboardOpsPage
+	}
+
+	rows, err := db.Query(`
+		SELECT seq, session_id, user_id, kind, op, created_at
+		FROM whiteboard_ops
+		WHERE session_id = ? AND seq > ?
+		ORDER BY seq
+		LIMIT ?
+	`, sessionID, fromSeq, limit)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeWhiteboardGetFailed)
+		return
+	}
+	defer rows.Close()
+
+	ops, err := scanWhiteboardOps(rows)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeWhiteboardGetFailed)
+		return
+	}
+
+	respondPage(c, gin.H{"ops": ops}, Pagination{PageSize: limit, HasMore: len(ops) == limit})
+}
+
+func init() {
+	backplaneObservers = append(backplaneObservers, observeRemoteWhiteboardOp)
+
+	// 只有会话的授课老师可以发布白板操作
+	whiteboardHandlers["op"] = func(c *Client, msg Message) {
+		if !c.isOwner() {
+			c.sendError(CodeWhiteboardForbidden)
+			return
+		}
+
+		wb, err := getWhiteboard(c.sessionID)
+		if err != nil {
+			log.Printf("Failed to load whiteboard for session %d: %v", c.sessionID, err)
+			return
+		}
+		op, err := wb.apply(c.sessionID, c.userID, msg.Data)
+		if err != nil {
+			c.sendError(CodeWhiteboardOpInvalid)
+			return
+		}
+
+		hub.broadcast(whiteboardRoom(c.sessionID), Message{Type: "op", From: c.userID, Data: op})
+	}
+}
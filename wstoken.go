@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultWSTokenTTL = time.Minute
+
+// WebSocket 连接令牌的内容，格式为 session.user.impersonator.expires.nonce.sig。
+// 令牌只能用于建立一次指定会话的连接，浏览器通过 ws_token 查询参数传入，避免登录令牌出现在地址和访问日志中
+type wsTokenClaims struct {
+	SessionID      int
+	UserID         int
+	ImpersonatorID int
+	Expires        int64
+	Nonce          string
+}
+
+// 未启用 Redis 时，在本实例内记录已使用的连接令牌，过期后清理
+var (
+	wsTokensMu   sync.Mutex
+	wsTokensUsed = make(map[string]time.Time)
+)
+
+func wsTokenTTL() time.Duration {
+	if secs := currentConfig().WS.TokenSeconds; secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return defaultWSTokenTTL
+}
+
+func wsToken(claims wsTokenClaims) string {
+	payload := fmt.Sprintf("%d.%d.%d.%d.%s", claims.SessionID, claims.UserID, claims.ImpersonatorID, claims.Expires, claims.Nonce)
+	return payload + "." + wsTokenSignature(payload)
+}
+
+func wsTokenSignature(payload string) string {
+	mac := hmac.New(sha256.New, []byte(currentConfig().JWTSecret))
+	mac.Write([]byte("ws\n" + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func parseWSToken(token string) (wsTokenClaims, bool) {
+	var claims wsTokenClaims
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 || !hmac.Equal([]byte(token[i+1:]), []byte(wsTokenSignature(token[:i]))) {
+		return claims, false
+	}
+	parts := strings.Split(token[:i], ".")
+	if len(parts) != 5 || parts[4] == "" {
+		return claims, false
+	}
+	var err1, err2, err3, err4 error
+	claims.SessionID, err1 = strconv.Atoi(parts[0])
+	claims.UserID, err2 = strconv.Atoi(parts[1])
+	claims.ImpersonatorID, err3 = strconv.Atoi(parts[2])
+	claims.Expires, err4 = strconv.ParseInt(parts[3], 10, 64)
+	claims.Nonce = parts[4]
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || time.Now().Unix() > claims.Expires {
+		return claims, false
+	}
+	return claims, true
+}
+
+// 标记连接令牌已使用，已使用过时返回 false；配置了 Redis 时在所有副本间共享
+func consumeWSToken(claims wsTokenClaims) bool {
+	ttl := time.Until(time.Unix(claims.Expires, 0)) + time.Second
+	if redisClient != nil {
+		ok, err := redisClient.SetNX("zhibo:wstoken:"+claims.Nonce, 1, ttl).Result()
+		if err == nil {
+			return ok
+		}
+		log.Printf("Failed to record websocket token use: %v", err)
+	}
+
+	now := time.Now()
+	wsTokensMu.Lock()
+	defer wsTokensMu.Unlock()
+	for nonce, expires := range wsTokensUsed {
+		if now.After(expires) {
+			delete(wsTokensUsed, nonce)
+		}
+	}
+	if _, used := wsTokensUsed[claims.Nonce]; used {
+		return false
+	}
+	wsTokensUsed[claims.Nonce] = now.Add(ttl)
+	return true
+}
+
+// 校验连接令牌并加载用户，失败时写入错误响应
+func authenticateWSToken(c *gin.Context, token string, sessionID int) (*AuthUser, bool) {
+	claims, ok := parseWSToken(token)
+	if !ok || claims.SessionID != sessionID || !consumeWSToken(claims) {
+		respondError(c, http.StatusUnauthorized, CodeUnauthorized)
+		return nil, false
+	}
+	status, err := userStatus(claims.UserID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return nil, false
+	}
+	if status != "active" {
+		respondError(c, http.StatusUnauthorized, CodeUserDisabled)
+		return nil, false
+	}
+	user := &AuthUser{ID: claims.UserID, ImpersonatorID: claims.ImpersonatorID}
+	err = db.QueryRow("SELECT role, time_zone, org FROM users WHERE id = ?", claims.UserID).Scan(&user.Role, &user.TimeZone, &user.Org)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusUnauthorized, CodeUserDisabled)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeInternal)
+		}
+		return nil, false
+	}
+	return user, true
+}
+
+// 为当前用户签发会话的 WebSocket 连接令牌，直播和白板连接都可使用，每个令牌只能连接一次
+func createWSToken(c *gin.Context) {
+	sessionID, ok := intParam(c, "id")
+	if !ok {
+		return
+	}
+	user := currentUser(c)
+
+	var courseID int
+	err := db.QueryRow("SELECT course_id FROM live_sessions WHERE id = ?", sessionID).Scan(&courseID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, CodeSessionNotFound)
+		} else {
+			respondError(c, http.StatusInternalServerError, CodeSessionGetFailed)
+		}
+		return
+	}
+	if user.Role == RoleStudent && !requireCourseAccess(c, courseID, sessionID, user.ID) {
+		return
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal)
+		return
+	}
+	expires := time.Now().Add(wsTokenTTL()).Truncate(time.Second)
+	token := wsToken(wsTokenClaims{
+		SessionID:      sessionID,
+		UserID:         user.ID,
+		ImpersonatorID: user.ImpersonatorID,
+		Expires:        expires.Unix(),
+		Nonce:          hex.EncodeToString(nonce),
+	})
+	respondOK(c, http.StatusCreated, gin.H{
+		"token":      token,
+		"expires_at": expires.In(requestLocation(c)),
+	})
+}